@@ -0,0 +1,75 @@
+package main
+
+// Semantic diff between two scheduler plugin configs, for reviewing config changes.
+//
+// Both configs are validated, and compared after defaulting. With -state, the effects of the change
+// on the current state of the cluster are also estimated, from the output of the scheduler's
+// /dump-state endpoint.
+//
+// Example usage:
+//
+//	kubectl -n kube-system port-forward deploy/autoscale-scheduler 10299 &
+//	curl -s localhost:10299/dump-state > state.json
+//	go run ./autoscale-scheduler/cmd/configdiff -state state.json old-config.json new-config.json
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin"
+)
+
+func main() {
+	var statePath string
+	flag.StringVar(&statePath, "state", "", "Path to a state dump from the scheduler's /dump-state endpoint, to estimate the effects of the change")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-state <path>] <old config> <new config>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), flag.Arg(1), statePath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(oldPath string, newPath string, statePath string) error {
+	oldConfig, err := plugin.ReadConfig(oldPath)
+	if err != nil {
+		return fmt.Errorf("old config: %w", err)
+	}
+	newConfig, err := plugin.ReadConfig(newPath)
+	if err != nil {
+		return fmt.Errorf("new config: %w", err)
+	}
+
+	changes, err := plugin.DiffConfigs(oldConfig, newConfig)
+	if err != nil {
+		return err
+	}
+
+	var dump *plugin.StateDump
+	var effects []plugin.ConfigEffect
+	if statePath != "" {
+		contents, err := os.ReadFile(statePath)
+		if err != nil {
+			return fmt.Errorf("could not read state dump: %w", err)
+		}
+		dump = &plugin.StateDump{} //nolint:exhaustruct // filled by json.Unmarshal
+		if err := json.Unmarshal(contents, dump); err != nil {
+			return fmt.Errorf("could not decode state dump: %w", err)
+		}
+		effects = plugin.EstimateConfigEffects(oldConfig, newConfig, dump)
+	}
+
+	fmt.Print(plugin.FormatConfigDiff(changes, effects, dump))
+	return nil
+}
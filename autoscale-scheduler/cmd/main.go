@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -25,6 +26,35 @@ import (
 // all of the juicy bits are defined in pkg/plugin/
 
 func main() {
+	// 'config-schema' prints the JSON Schema for the plugin config, instead of running the scheduler.
+	if len(os.Args) == 2 && os.Args[1] == "config-schema" {
+		schema, err := plugin.ConfigSchema()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := os.Stdout.Write(schema); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// 'validate-config <path>...' checks the plugin config in each file -- either the config itself,
+	// or manifests containing its ConfigMap -- so that invalid configs can be rejected before
+	// they're applied (e.g. from a pre-sync hook), instead of crashing the scheduler at startup.
+	if len(os.Args) >= 3 && os.Args[1] == "validate-config" {
+		failed := false
+		for _, path := range os.Args[2:] {
+			if err := plugin.ValidateConfigFile(path); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
 	logConfig := zap.NewProductionConfig()
 	logConfig.Sampling = nil           // Disable sampling, which the production config enables by default.
 	logConfig.DisableStacktrace = true // No stack traces; reconcile failures spam the logs otherwise
@@ -42,6 +72,9 @@ func runProgram(logger *zap.Logger) (err error) {
 	if err != nil {
 		return fmt.Errorf("Error reading config at %q: %w", plugin.DefaultConfigPath, err)
 	}
+	for _, name := range conf.EnvOverrides() {
+		logger.Info("Applied config override from environment", zap.String("name", name), zap.String("value", os.Getenv(name)))
+	}
 
 	// this: listens for sigterm, when we catch that signal, the
 	// context gets canceled, a go routine waits for half a second, and
@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -40,9 +41,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -50,6 +54,7 @@ import (
 	"k8s.io/klog/v2"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/neonvm/controllers"
 	"github.com/neondatabase/autoscaling/pkg/neonvm/ipam"
 	"github.com/neondatabase/autoscaling/pkg/util"
@@ -92,6 +97,23 @@ func run(mgr manager.Manager) error {
 	return nil
 }
 
+// labelSelectorFlag returns a flag.Func callback that parses a label selector into *dst, or sets it
+// to nil if the value is empty.
+func labelSelectorFlag(dst **metav1.LabelSelector) func(string) error {
+	return func(value string) error {
+		if value == "" {
+			*dst = nil
+			return nil
+		}
+		selector, err := metav1.ParseToLabelSelector(value)
+		if err != nil {
+			return err
+		}
+		*dst = selector
+		return nil
+	}
+}
+
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
@@ -105,6 +127,44 @@ func main() {
 	var failurePendingPeriod time.Duration
 	var failingRefreshInterval time.Duration
 	var atMostOnePod bool
+	var computeUnitConfigPath string
+	var nodeGroupLabel string
+	var rootDiskCacheDir string
+	var syncRunnerPodRequests bool
+	var manageRunnerPodDisruptionBudgets bool
+	var disruptableImportanceClasses map[string]struct{}
+	var migrationTargetSchedulingTimeout time.Duration
+	var runnerPodMaxAge time.Duration
+	runnerPodRecyclingAction := controllers.RecyclingActionFlag
+	var runnerPodRecyclingWindow *controllers.MaintenanceWindow
+	var runnerPodRecyclingMaxConcurrentMigrations int
+	var runnerPodRecyclingMaxConcurrentRestarts int
+	var canaryNamespace string
+	var canaryImage string
+	var canarySchedulerName string
+	var canaryInterval time.Duration
+	var canaryStageTimeout time.Duration
+	var canaryStageTimeouts map[controllers.CanaryStage]time.Duration
+	var enableQMPProxy bool
+	var ipamQuarantinePeriod time.Duration
+	var diskFullThreshold float64
+	var storageTopologyKey string
+	var storageLocalityWeight int
+	var federationBindAddress string
+	var federationStaleAfter time.Duration
+	networkPolicyControllerSelector := &metav1.LabelSelector{
+		MatchLabels:      map[string]string{"control-plane": "controller"},
+		MatchExpressions: nil,
+	}
+	networkPolicyAgentSelector := &metav1.LabelSelector{
+		MatchLabels:      map[string]string{"name": "autoscaler-agent"},
+		MatchExpressions: nil,
+	}
+	qmpProxyAllowedCommands := make(map[string]struct{})
+	for _, cmd := range controllers.DefaultQMPProxyAllowedCommands {
+		qmpProxyAllowedCommands[cmd] = struct{}{}
+	}
+	rootDiskCacheMaxSize := resource.MustParse("20Gi")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -148,8 +208,158 @@ func main() {
 	flag.BoolVar(&atMostOnePod, "at-most-one-pod", false,
 		"If true, the controller will ensure that at most one pod is running at a time. "+
 			"Otherwise, the outdated pod might be left to terminate, while the new one is already running.")
+	flag.StringVar(&computeUnitConfigPath, "compute-unit-config-path", "",
+		"Path to the JSON Compute Unit config shared with the autoscaler-agent and scheduler plugin. "+
+			"If set, the controller warns about VMs whose memory slot size doesn't fit their Compute Unit.")
+	flag.StringVar(&nodeGroupLabel, "node-group-label", "",
+		"Node label whose value is used as the node_group label in VM startup latency metrics")
+	flag.StringVar(&rootDiskCacheDir, "root-disk-cache-dir", "",
+		"Directory on each node to cache VM root disk images pinned by digest. If empty, the cache is disabled.")
+	flag.Func("root-disk-cache-max-size", "Size above which images are evicted from the root disk cache (default 20Gi)",
+		func(value string) error {
+			var err error
+			rootDiskCacheMaxSize, err = resource.ParseQuantity(value)
+			return err
+		},
+	)
+	flag.BoolVar(&syncRunnerPodRequests, "sync-runner-pod-requests", false,
+		"Keep runner pod CPU and memory requests in sync with the VM's current size, using in-place pod resizing")
+	flag.BoolVar(&manageRunnerPodDisruptionBudgets, "manage-runner-pod-disruption-budgets", false,
+		"Create PodDisruptionBudgets to protect runner pods of VMs with importance classes that may not be voluntarily disrupted")
+	flag.Func(
+		"disruptable-importance-classes",
+		"Comma-separated list of VM importance classes whose runner pods may be voluntarily disrupted",
+		func(value string) error {
+			classes := make(map[string]struct{})
+			if value != "" {
+				for _, class := range strings.Split(value, ",") {
+					if class == "" {
+						return errors.New("importance class must not be empty")
+					}
+					classes[class] = struct{}{}
+				}
+			}
+			disruptableImportanceClasses = classes
+			return nil
+		},
+	)
+	flag.DurationVar(&migrationTargetSchedulingTimeout, "migration-target-scheduling-timeout", 0,
+		"If non-zero, fail migrations whose target runner pod has been unschedulable for this long. If zero, wait indefinitely.")
+	flag.DurationVar(&runnerPodMaxAge, "runner-pod-max-age", 0,
+		"If non-zero, recycle runner pods older than this, as set by -runner-pod-recycling-action. If zero, runner pod recycling is disabled.")
+	flag.Func(
+		"runner-pod-recycling-action",
+		"What to do with VMs whose runner pods are older than -runner-pod-max-age: 'flag', 'migrate', or 'restart'. Defaults to 'flag'.",
+		func(value string) error {
+			action := controllers.RecyclingAction(value)
+			switch action {
+			case controllers.RecyclingActionFlag, controllers.RecyclingActionMigrate, controllers.RecyclingActionRestart:
+				runnerPodRecyclingAction = action
+				return nil
+			default:
+				return fmt.Errorf("unknown runner pod recycling action %q", value)
+			}
+		},
+	)
+	flag.Func(
+		"runner-pod-recycling-window",
+		"Daily maintenance window in UTC, as HH:MM-HH:MM, during which old runner pods may be migrated or restarted. If unset, they may be recycled at any time.",
+		func(value string) error {
+			window, err := controllers.ParseMaintenanceWindow(value)
+			if err != nil {
+				return err
+			}
+			runnerPodRecyclingWindow = window
+			return nil
+		},
+	)
+	flag.IntVar(&runnerPodRecyclingMaxConcurrentMigrations, "runner-pod-recycling-max-concurrent-migrations", 5,
+		"Maximum number of migrations to recycle old runner pods that may be ongoing at once")
+	flag.IntVar(&runnerPodRecyclingMaxConcurrentRestarts, "runner-pod-recycling-max-concurrent-restarts", 5,
+		"Maximum number of VMs restarting to recycle old runner pods at once")
+	flag.StringVar(&canaryNamespace, "canary-namespace", "",
+		"Namespace to periodically create a synthetic canary VM in, to verify the VM lifecycle end-to-end. If empty, the canary is disabled.")
+	flag.StringVar(&canaryImage, "canary-image", "", "Root disk image for the canary VM. Required if -canary-namespace is set.")
+	flag.StringVar(&canarySchedulerName, "canary-scheduler-name", "autoscale-scheduler",
+		"Scheduler for the canary VM. Must be the autoscaling scheduler, so that the canary's scaling goes through the autoscaler-agent and scheduler plugin.")
+	flag.DurationVar(&canaryInterval, "canary-interval", 10*time.Minute, "Time between the start of each canary VM run")
+	flag.DurationVar(&canaryStageTimeout, "canary-stage-timeout", 5*time.Minute,
+		"Default time each stage of the canary VM's lifecycle must complete within")
+	flag.Func(
+		"canary-stage-timeouts",
+		"Comma-separated list of <stage>=<duration> overriding -canary-stage-timeout for individual canary stages",
+		func(value string) error {
+			timeouts := make(map[controllers.CanaryStage]time.Duration)
+			for _, pair := range strings.Split(value, ",") {
+				stage, durationStr, ok := strings.Cut(pair, "=")
+				if !ok {
+					return fmt.Errorf("expected <stage>=<duration>, got %q", pair)
+				}
+				if !slices.Contains(controllers.CanaryStages, controllers.CanaryStage(stage)) {
+					return fmt.Errorf("unknown canary stage %q", stage)
+				}
+				duration, err := time.ParseDuration(durationStr)
+				if err != nil {
+					return fmt.Errorf("invalid duration for canary stage %q: %w", stage, err)
+				}
+				timeouts[controllers.CanaryStage(stage)] = duration
+			}
+			canaryStageTimeouts = timeouts
+			return nil
+		},
+	)
+	flag.BoolVar(&enableQMPProxy, "enable-qmp-proxy", false,
+		"Serve an audited proxy on the webhook server for running allowlisted QMP commands against VMs")
+	flag.Func(
+		"qmp-proxy-allowed-commands",
+		fmt.Sprintf("Comma-separated list of QMP commands allowed through the QMP proxy (default %q)",
+			strings.Join(controllers.DefaultQMPProxyAllowedCommands, ",")),
+		func(value string) error {
+			commands := make(map[string]struct{})
+			for _, cmd := range strings.Split(value, ",") {
+				if cmd == "" {
+					return errors.New("QMP command must not be empty")
+				}
+				commands[cmd] = struct{}{}
+			}
+			qmpProxyAllowedCommands = commands
+			return nil
+		},
+	)
+	flag.DurationVar(&ipamQuarantinePeriod, "ipam-quarantine-period", time.Minute,
+		"Time that overlay IPs released by deleted VMs are kept out of use before reuse, so stale ARP entries can expire. 0 to reuse immediately")
+	flag.Float64Var(&diskFullThreshold, "disk-full-threshold", 0,
+		"If non-zero, the fraction of a VM disk's space in use (from 0 to 1) at which to mark the VM as having a nearly full disk")
+	flag.StringVar(&storageTopologyKey, "storage-topology-key", "",
+		"If set, the node label used to place runner pods close to or away from their storage, for VMs that request it")
+	flag.IntVar(&storageLocalityWeight, "storage-locality-weight", controllers.DefaultStorageLocalityWeight,
+		"Weight (from 1 to 100) of the preferred node affinity added for storage locality")
+	flag.StringVar(&federationBindAddress, "federation-bind-address", "",
+		"If set, the address to receive cluster summaries from scheduler plugins on, and serve cross-cluster placement recommendations")
+	flag.DurationVar(&federationStaleAfter, "federation-stale-after", 5*time.Minute,
+		"Time after which a cluster's summary is no longer used for placement recommendations, if no newer summary was received")
+	flag.Func(
+		"network-policy-controller-selector",
+		"Label selector for neonvm-controller pods, allowed to reach QMP and neonvm-runner for VMs with a network policy (default \"control-plane=controller\"). Empty to disallow",
+		labelSelectorFlag(&networkPolicyControllerSelector),
+	)
+	flag.Func(
+		"network-policy-agent-selector",
+		"Label selector for autoscaler-agent pods, allowed to reach VMs with a network policy (default \"name=autoscaler-agent\"). Empty to disallow",
+		labelSelectorFlag(&networkPolicyAgentSelector),
+	)
 	flag.Parse()
 
+	if canaryNamespace != "" && canaryImage == "" {
+		panic(errors.New("-canary-image must be set if -canary-namespace is set"))
+	}
+	if diskFullThreshold < 0 || diskFullThreshold > 1 {
+		panic(errors.New("-disk-full-threshold must be between 0 and 1"))
+	}
+	if storageLocalityWeight < 1 || storageLocalityWeight > 100 {
+		panic(errors.New("-storage-locality-weight must be between 1 and 100"))
+	}
+
 	logConfig := zap.NewProductionConfig()
 	logConfig.Sampling = nil // Disabling sampling; it's enabled by default for zap's production configs.
 	logConfig.Level.SetLevel(zap.InfoLevel)
@@ -187,19 +397,67 @@ func main() {
 		panic(err)
 	}
 
+	var computeUnits *api.ComputeUnitConfig
+	if computeUnitConfigPath != "" {
+		computeUnits, err = api.ReadComputeUnitConfig(computeUnitConfigPath)
+		if err != nil {
+			setupLog.Error(err, "unable to read compute unit config")
+			panic(err)
+		}
+	}
+
+	inPlacePodResize := controllers.InPlacePodResizeUnsupported
+	if syncRunnerPodRequests {
+		inPlacePodResize, err = controllers.DetectInPlacePodResize(discovery.NewDiscoveryClientForConfigOrDie(cfg))
+		if err != nil {
+			setupLog.Error(err, "unable to detect in-place pod resize support")
+		}
+		if inPlacePodResize == controllers.InPlacePodResizeUnsupported {
+			setupLog.Info("In-place pod resize is not supported; runner pod requests will only be set at creation")
+		} else {
+			setupLog.Info("Detected in-place pod resize support", "mode", inPlacePodResize)
+		}
+	}
+
+	var runnerPodRecycling *controllers.RunnerPodRecyclingConfig
+	if runnerPodMaxAge != 0 {
+		runnerPodRecycling = &controllers.RunnerPodRecyclingConfig{
+			MaxAge:                  runnerPodMaxAge,
+			Action:                  runnerPodRecyclingAction,
+			Window:                  runnerPodRecyclingWindow,
+			MaxConcurrentMigrations: runnerPodRecyclingMaxConcurrentMigrations,
+			MaxConcurrentRestarts:   runnerPodRecyclingMaxConcurrentRestarts,
+		}
+	}
+
 	reconcilerMetrics := controllers.MakeReconcilerMetrics()
 
 	rc := &controllers.ReconcilerConfig{
-		DisableRunnerCgroup:     disableRunnerCgroup,
-		MaxConcurrentReconciles: concurrencyLimit,
-		SkipUpdateValidationFor: skipUpdateValidationFor,
-		QEMUDiskCacheSettings:   qemuDiskCacheSettings,
-		MemhpAutoMovableRatio:   memhpAutoMovableRatio,
-		FailurePendingPeriod:    failurePendingPeriod,
-		FailingRefreshInterval:  failingRefreshInterval,
-		AtMostOnePod:            atMostOnePod,
-		DefaultCPUScalingMode:   defaultCpuScalingMode,
-		NADConfig:               controllers.GetNADConfig(),
+		DisableRunnerCgroup:              disableRunnerCgroup,
+		MaxConcurrentReconciles:          concurrencyLimit,
+		SkipUpdateValidationFor:          skipUpdateValidationFor,
+		QEMUDiskCacheSettings:            qemuDiskCacheSettings,
+		MemhpAutoMovableRatio:            memhpAutoMovableRatio,
+		FailurePendingPeriod:             failurePendingPeriod,
+		FailingRefreshInterval:           failingRefreshInterval,
+		AtMostOnePod:                     atMostOnePod,
+		DefaultCPUScalingMode:            defaultCpuScalingMode,
+		NADConfig:                        controllers.GetNADConfig(),
+		ComputeUnits:                     computeUnits,
+		NodeGroupLabel:                   nodeGroupLabel,
+		RootDiskCacheDir:                 rootDiskCacheDir,
+		RootDiskCacheMaxSize:             rootDiskCacheMaxSize,
+		SyncRunnerPodRequests:            syncRunnerPodRequests,
+		InPlacePodResize:                 inPlacePodResize,
+		ManageRunnerPodDisruptionBudgets: manageRunnerPodDisruptionBudgets,
+		DisruptableImportanceClasses:     disruptableImportanceClasses,
+		MigrationTargetSchedulingTimeout: migrationTargetSchedulingTimeout,
+		RunnerPodRecycling:               runnerPodRecycling,
+		DiskFullThreshold:                diskFullThreshold,
+		StorageTopologyKey:               storageTopologyKey,
+		StorageLocalityWeight:            int32(storageLocalityWeight),
+		NetworkPolicyControllerSelector:  networkPolicyControllerSelector,
+		NetworkPolicyAgentSelector:       networkPolicyAgentSelector,
 	}
 
 	ipam, err := ipam.New(ipam.IPAMParams{
@@ -211,6 +469,8 @@ func main() {
 		ConcurrencyLimit: max(1, concurrencyLimit/4),
 
 		MetricsReg: metrics.Registry,
+
+		QuarantinePeriod: ipamQuarantinePeriod,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to create ipam")
@@ -218,8 +478,12 @@ func main() {
 	}
 	defer ipam.Close()
 
+	// Writes by the reconcilers are tracked so that reconciles can be counted by whether they
+	// changed anything.
+	reconcilerClient := controllers.WithWriteTracking(mgr.GetClient())
+
 	vmReconciler := &controllers.VMReconciler{
-		Client:   mgr.GetClient(),
+		Client:   reconcilerClient,
 		Scheme:   mgr.GetScheme(),
 		Recorder: mgr.GetEventRecorderFor("virtualmachine-controller"),
 		Config:   rc,
@@ -241,17 +505,41 @@ func main() {
 	}
 
 	migrationReconciler := &controllers.VirtualMachineMigrationReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("virtualmachinemigration-controller"),
-		Config:   rc,
-		Metrics:  reconcilerMetrics,
+		Client:    reconcilerClient,
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("virtualmachinemigration-controller"),
+		Config:    rc,
+		APIReader: mgr.GetAPIReader(),
+		Metrics:   reconcilerMetrics,
 	}
 	migrationReconcilerMetrics, err := migrationReconciler.SetupWithManager(mgr)
 	if err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "VirtualMachineMigration")
 		panic(err)
 	}
+	batchReconciler := &controllers.VirtualMachineBatchReconciler{
+		Client:   reconcilerClient,
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("virtualmachinebatch-controller"),
+		Config:   rc,
+		IPAM:     ipam,
+		Metrics:  reconcilerMetrics,
+	}
+	if _, err := batchReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VirtualMachineBatch")
+		panic(err)
+	}
+	warmPoolReconciler := &controllers.VirtualMachineWarmPoolReconciler{
+		Client:   reconcilerClient,
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("virtualmachinewarmpool-controller"),
+		Config:   rc,
+		Metrics:  reconcilerMetrics,
+	}
+	if _, err := warmPoolReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VirtualMachineWarmPool")
+		panic(err)
+	}
 	migrationWebhook := &controllers.VMMigrationWebhook{
 		Recorder: mgr.GetEventRecorderFor("virtualmachinemigration-webhook"),
 		Config:   rc,
@@ -260,6 +548,24 @@ func main() {
 		setupLog.Error(err, "unable to create webhook", "webhook", "VirtualMachine")
 		panic(err)
 	}
+	nodeWebhook := &controllers.NodeWebhook{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("node-webhook"),
+		Metrics:  reconcilerMetrics,
+	}
+	if err := nodeWebhook.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "Node")
+		panic(err)
+	}
+	if enableQMPProxy {
+		qmpProxy := &controllers.QMPProxy{
+			Client:          mgr.GetClient(),
+			Recorder:        mgr.GetEventRecorderFor("qmp-proxy"),
+			Logger:          logger.WithName("qmp-proxy"),
+			AllowedCommands: qmpProxyAllowedCommands,
+		}
+		qmpProxy.SetupWithManager(mgr)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -282,6 +588,37 @@ func main() {
 		panic(err)
 	}
 
+	if canaryNamespace != "" {
+		canary := &controllers.Canary{
+			Client: mgr.GetClient(),
+			Config: controllers.CanaryConfig{
+				Namespace:           canaryNamespace,
+				Image:               canaryImage,
+				SchedulerName:       canarySchedulerName,
+				Interval:            canaryInterval,
+				DefaultStageTimeout: canaryStageTimeout,
+				StageTimeouts:       canaryStageTimeouts,
+			},
+			Metrics: controllers.MakeCanaryMetrics(),
+			Logger:  logger.WithName("canary"),
+		}
+		if err := mgr.Add(canary); err != nil {
+			setupLog.Error(err, "unable to set up canary VM")
+			panic(err)
+		}
+	}
+
+	if federationBindAddress != "" {
+		federation := controllers.NewFederationAggregator(controllers.FederationConfig{
+			BindAddress: federationBindAddress,
+			StaleAfter:  federationStaleAfter,
+		}, logger.WithName("federation"))
+		if err := mgr.Add(federation); err != nil {
+			setupLog.Error(err, "unable to set up federation aggregator")
+			panic(err)
+		}
+	}
+
 	// NOTE: THE CONTROLLER MUST IMMEDIATELY EXIT AFTER RUNNING THE MANAGER.
 	if err := run(mgr); err != nil {
 		setupLog.Error(err, "run manager error")
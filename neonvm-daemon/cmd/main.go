@@ -9,8 +9,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -18,6 +21,7 @@ import (
 	k8sutil "k8s.io/kubernetes/pkg/volume/util"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/neonvm/cpuscaling"
 	"github.com/neondatabase/autoscaling/pkg/util"
 )
@@ -177,6 +181,14 @@ func (s *cpuServer) handleUploadFile(w http.ResponseWriter, r *http.Request, pat
 		}
 	}
 
+	// The directory may not exist yet, e.g. for disks of a VM claimed from a warm pool, which
+	// weren't known when the guest booted.
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		s.logger.Error("could not create directory", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	aw, err := k8sutil.NewAtomicWriter(path, "neonvm-daemon")
 	if err != nil {
 		s.logger.Error("could not create writer", zap.Error(err))
@@ -193,6 +205,67 @@ func (s *cpuServer) handleUploadFile(w http.ResponseWriter, r *http.Request, pat
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s *cpuServer) handleGetDiskUsage(w http.ResponseWriter) {
+	usage, err := getDiskUsage()
+	if err != nil {
+		s.logger.Error("could not get disk usage", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(usage)
+	if err != nil {
+		s.logger.Error("could not marshal disk usage", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		s.logger.Error("could not write response", zap.Error(err))
+	}
+}
+
+// getDiskUsage returns the usage of each writable filesystem mounted in the guest.
+//
+// Pseudo-filesystems (like /proc) that have no size are skipped.
+func getDiskUsage() ([]api.DiskUsage, error) {
+	mounts, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("could not read mounts: %w", err)
+	}
+
+	usage := []api.DiskUsage{}
+	for _, line := range strings.Split(string(mounts), "\n") {
+		// Each line is: <device> <mount path> <fs type> <options> <dump> <pass>
+		fields := strings.Fields(line)
+		if len(fields) < 4 || slices.Contains(strings.Split(fields[3], ","), "ro") {
+			continue
+		}
+		mountPath := fields[1]
+
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(mountPath, &stat); err != nil {
+			return nil, fmt.Errorf("could not get filesystem stats for %s: %w", mountPath, err)
+		}
+		if stat.Blocks == 0 {
+			continue
+		}
+
+		blockSize := uint64(stat.Bsize)
+		usage = append(usage, api.DiskUsage{
+			Name:           "",
+			MountPath:      mountPath,
+			TotalBytes:     stat.Blocks * blockSize,
+			UsedBytes:      (stat.Blocks - stat.Bfree) * blockSize,
+			AvailableBytes: stat.Bavail * blockSize,
+		})
+	}
+
+	return usage, nil
+}
+
 func (s *cpuServer) run(addr string) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/cpu", func(w http.ResponseWriter, r *http.Request) {
@@ -207,6 +280,15 @@ func (s *cpuServer) run(addr string) {
 			w.WriteHeader(http.StatusNotFound)
 		}
 	})
+	mux.HandleFunc("/disks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			s.handleGetDiskUsage(w)
+			return
+		} else {
+			// unknown method
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
 	mux.HandleFunc("/files/{path...}", func(w http.ResponseWriter, r *http.Request) {
 		path := fmt.Sprintf("/%s", r.PathValue("path"))
 		if r.Method == http.MethodGet {
@@ -221,10 +303,16 @@ func (s *cpuServer) run(addr string) {
 		}
 	})
 
+	// Include our version in every response, so that the runner can check it.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.SetComponentVersionHeader(w.Header(), api.ComponentNeonVMDaemon)
+		mux.ServeHTTP(w, r)
+	})
+
 	timeout := 5 * time.Second
 	server := http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           handler,
 		ReadTimeout:       timeout,
 		ReadHeaderTimeout: timeout,
 		WriteTimeout:      timeout,
@@ -0,0 +1,232 @@
+package main
+
+// Duplicate address detection and gratuitous ARP for the VM's overlay IP.
+//
+// Overlay IPs are reused after VMs are deleted, so the previous owner of the IP may still be
+// around (e.g. because its runner pod is still terminating), and other hosts on the overlay network
+// may have stale ARP entries pointing at it. While the VM is starting, we probe for the IP (as in
+// RFC 5227) and wait for any existing owner to go away, and then announce the IP with the new
+// guest's MAC address so that stale entries are replaced immediately.
+//
+// Probing takes at least arpProbeCount * arpProbeWait, so it's done in parallel with QEMU starting
+// rather than delaying it. Until it's done, the guest's TAP interface is kept down, so that the
+// guest can't use the IP while another host still has it.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+)
+
+const (
+	// number of consecutive probes that must go unanswered before the IP is considered free
+	arpProbeCount = 3
+	// time to wait for replies after each probe
+	arpProbeWait = 500 * time.Millisecond
+	// maximum time to wait for a conflicting owner of the IP to go away
+	arpConflictTimeout = 30 * time.Second
+
+	arpAnnounceCount    = 3
+	arpAnnounceInterval = 200 * time.Millisecond
+
+	arpOpRequest = 1
+	arpOpReply   = 2
+
+	// Ethernet header + ARP payload for IPv4, padded to the minimum Ethernet frame size
+	arpFrameSize = 60
+)
+
+var ethernetBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// enableOverlayNetworkWhenFree waits until no other host is using ip on the overlay network, and
+// then brings up the guest's TAP interface and announces the IP for the guest.
+//
+// If the IP is still in use after arpConflictTimeout, the runner exits (taking the VM with it), as
+// it would have if the check were done before starting QEMU.
+func enableOverlayNetworkWhenFree(logger *zap.Logger, ip net.IP, guestMAC net.HardwareAddr) {
+	if err := waitForOverlayIPConflicts(logger, overlayNetworkBridgeName, ip); err != nil {
+		logger.Fatal("Failed to check overlay IP for conflicts", zap.Error(err))
+	}
+
+	tap, err := netlink.LinkByName(overlayNetworkTapName)
+	if err != nil {
+		logger.Fatal("Failed to get overlay TAP interface", zap.Error(err))
+	}
+	if err := netlink.LinkSetUp(tap); err != nil {
+		logger.Fatal("Failed to set up overlay TAP interface", zap.Error(err))
+	}
+
+	if err := announceOverlayIP(overlayNetworkBridgeName, ip, guestMAC); err != nil {
+		// Not fatal: at worst, other hosts have stale ARP entries for a little while.
+		logger.Warn("failed to announce overlay IP", zap.Error(err))
+	}
+}
+
+// waitForOverlayIPConflicts probes for other hosts using ip on the interface, returning an error if
+// the IP is still in use after arpConflictTimeout.
+func waitForOverlayIPConflicts(logger *zap.Logger, ifaceName string, ip net.IP) error {
+	sock, err := openARPSocket(ifaceName)
+	if err != nil {
+		return err
+	}
+	defer sock.close()
+
+	deadline := time.Now().Add(arpConflictTimeout)
+	unanswered := 0
+	for unanswered < arpProbeCount {
+		// Probes use the unspecified address as the sender IP, so that they don't update other
+		// hosts' ARP caches.
+		if err := sock.send(arpOpRequest, sock.iface.HardwareAddr, net.IPv4zero, ip); err != nil {
+			return fmt.Errorf("failed to send ARP probe: %w", err)
+		}
+
+		owner, err := sock.waitForSender(ip, time.Now().Add(arpProbeWait))
+		if err != nil {
+			return fmt.Errorf("failed to receive ARP replies: %w", err)
+		}
+		if owner == nil {
+			unanswered += 1
+			continue
+		}
+
+		unanswered = 0
+		if time.Now().After(deadline) {
+			return fmt.Errorf("overlay IP %s is in use by %s", ip, owner)
+		}
+		logger.Warn("overlay IP is in use by another host, waiting for it to be released",
+			zap.String("ip", ip.String()), zap.String("owner", owner.String()))
+		time.Sleep(arpProbeWait)
+	}
+
+	return nil
+}
+
+// announceOverlayIP sends gratuitous ARP requests for ip on the interface, on behalf of the guest
+// with the given MAC address.
+func announceOverlayIP(ifaceName string, ip net.IP, guestMAC net.HardwareAddr) error {
+	sock, err := openARPSocket(ifaceName)
+	if err != nil {
+		return err
+	}
+	defer sock.close()
+
+	for i := 0; i < arpAnnounceCount; i++ {
+		if i != 0 {
+			time.Sleep(arpAnnounceInterval)
+		}
+		if err := sock.send(arpOpRequest, guestMAC, ip, ip); err != nil {
+			return fmt.Errorf("failed to send gratuitous ARP: %w", err)
+		}
+	}
+	return nil
+}
+
+type arpSocket struct {
+	fd    int
+	iface *net.Interface
+}
+
+func openARPSocket(ifaceName string) (*arpSocket, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface %s: %w", ifaceName, err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ARP socket: %w", err)
+	}
+	sock := &arpSocket{fd: fd, iface: iface}
+
+	//nolint:exhaustruct // other fields are only used for sending
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  iface.Index,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		sock.close()
+		return nil, fmt.Errorf("failed to bind ARP socket to %s: %w", ifaceName, err)
+	}
+
+	// Use a short timeout on reads, so that we can check for deadlines between them.
+	timeout := syscall.NsecToTimeval(int64(50 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		sock.close()
+		return nil, fmt.Errorf("failed to set ARP socket timeout: %w", err)
+	}
+
+	return sock, nil
+}
+
+func (s *arpSocket) close() {
+	_ = syscall.Close(s.fd)
+}
+
+func (s *arpSocket) send(op uint16, senderMAC net.HardwareAddr, senderIP net.IP, targetIP net.IP) error {
+	frame := make([]byte, arpFrameSize)
+
+	// Ethernet header
+	copy(frame[0:6], ethernetBroadcast)
+	copy(frame[6:12], senderMAC)
+	binary.BigEndian.PutUint16(frame[12:14], syscall.ETH_P_ARP)
+
+	// ARP payload
+	binary.BigEndian.PutUint16(frame[14:16], 1) // hardware type: Ethernet
+	binary.BigEndian.PutUint16(frame[16:18], syscall.ETH_P_IP)
+	frame[18] = 6 // hardware address length
+	frame[19] = 4 // protocol address length
+	binary.BigEndian.PutUint16(frame[20:22], op)
+	copy(frame[22:28], senderMAC)
+	copy(frame[28:32], senderIP.To4())
+	// target hardware address is left as zeros
+	copy(frame[38:42], targetIP.To4())
+
+	//nolint:exhaustruct // other fields are only used for receiving
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ARP),
+		Ifindex:  s.iface.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], ethernetBroadcast)
+
+	return syscall.Sendto(s.fd, frame, 0, addr)
+}
+
+// waitForSender waits until the deadline for an ARP packet sent by a host claiming ip, returning
+// the host's MAC address if there was one, or nil if not.
+func (s *arpSocket) waitForSender(ip net.IP, deadline time.Time) (net.HardwareAddr, error) {
+	buf := make([]byte, 1500)
+	for time.Now().Before(deadline) {
+		n, _, err := syscall.Recvfrom(s.fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return nil, err
+		}
+		if n < 42 || binary.BigEndian.Uint16(buf[12:14]) != syscall.ETH_P_ARP {
+			continue
+		}
+
+		op := binary.BigEndian.Uint16(buf[20:22])
+		senderMAC := net.HardwareAddr(buf[22:28])
+		senderIP := net.IP(buf[28:32])
+		if (op == arpOpRequest || op == arpOpReply) && senderIP.Equal(ip.To4()) {
+			return append(net.HardwareAddr{}, senderMAC...), nil
+		}
+	}
+	return nil, nil
+}
+
+// htons converts a uint16 from host to network byte order
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
@@ -0,0 +1,197 @@
+package main
+
+// Node-local cache of VM root disk images, keyed by image digest.
+//
+// When the cache is enabled, the init container copies the root disk image to
+// <cache dir>/<key>/disk.qcow2 if it isn't already there. Here, we create the VM's root disk as a
+// thin qcow2 overlay backed by the cached image (instead of each VM having its own full copy), and
+// then evict the least recently used images while the cache is over its size limit.
+//
+// Each runner holds a shared flock on its cached image for as long as it's running, and eviction
+// requires an exclusive lock, so we never remove an image that's backing a running VM.
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+const (
+	rootDiskCacheFileName = "disk.qcow2"
+	// rootDiskCacheMissMarker is created by the init container if it had to populate the cache.
+	rootDiskCacheMissMarker = "/vm/images/rootdisk-cache-miss"
+	// rootDiskCacheMinAge is the minimum time since an image was last used before we'll evict it.
+	//
+	// This protects images that were just populated or touched by an init container, but aren't
+	// yet locked by their runner.
+	rootDiskCacheMinAge = 10 * time.Minute
+)
+
+// rootDiskCacheLock is the file holding the shared lock on the cached image in use by this VM.
+//
+// It's never closed, so that the lock is held until the runner exits. We keep a reference to it
+// here because otherwise the file would be closed when it's garbage collected.
+var rootDiskCacheLock *os.File
+
+type rootDiskCacheStats struct {
+	// hit is true if the image was already in the cache when the pod started.
+	hit bool
+	// sizeBytes is the total size of the cache, after eviction.
+	sizeBytes int64
+	// evictedImages and evictedBytes are the number and total size of images we evicted.
+	evictedImages int
+	evictedBytes  int64
+}
+
+// setupCachedRootDisk creates the VM's root disk as an overlay on top of the cached image with the
+// given key, and then evicts images from the cache until it's no larger than maxSize.
+func setupCachedRootDisk(logger *zap.Logger, cacheDir string, key string, maxSize int64) (*rootDiskCacheStats, error) {
+	basePath := filepath.Join(cacheDir, key, rootDiskCacheFileName)
+
+	file, err := os.Open(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open cached root disk image: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("could not lock cached root disk image: %w", err)
+	}
+	rootDiskCacheLock = file
+
+	// Mark the image as recently used, for LRU eviction.
+	now := time.Now()
+	if err := os.Chtimes(basePath, now, now); err != nil {
+		logger.Warn("could not update cached root disk image modification time", zap.Error(err))
+	}
+
+	logger.Info("creating root disk overlay backed by cached image", zap.String("image", basePath))
+	if err := execFg(qemuImgBin, "create", "-f", "qcow2", "-F", "qcow2", "-b", basePath, rootDiskPath); err != nil {
+		return nil, fmt.Errorf("could not create root disk overlay: %w", err)
+	}
+	/* uid=36(qemu) gid=34(kvm) groups=34(kvm) */
+	if err := os.Chown(rootDiskPath, 36, 34); err != nil {
+		return nil, fmt.Errorf("could not set root disk overlay owner: %w", err)
+	}
+
+	_, err = os.Stat(rootDiskCacheMissMarker)
+	stats := &rootDiskCacheStats{
+		hit:           errors.Is(err, fs.ErrNotExist),
+		sizeBytes:     0,
+		evictedImages: 0,
+		evictedBytes:  0,
+	}
+
+	// Failing to evict isn't a reason to stop the VM from starting, so we just log the error.
+	if err := evictRootDiskCache(logger, cacheDir, maxSize, stats); err != nil {
+		logger.Error("failed to evict images from root disk cache", zap.Error(err))
+	}
+
+	return stats, nil
+}
+
+// evictRootDiskCache removes the least recently used images from the cache until its total size
+// is no more than maxSize, skipping any images that are in use.
+func evictRootDiskCache(logger *zap.Logger, cacheDir string, maxSize int64, stats *rootDiskCacheStats) error {
+	type cachedImage struct {
+		dir     string
+		size    int64
+		modTime time.Time
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return fmt.Errorf("could not read cache directory: %w", err)
+	}
+
+	var images []cachedImage
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(cacheDir, entry.Name())
+		info, err := os.Stat(filepath.Join(dir, rootDiskCacheFileName))
+		if err != nil {
+			// Not (yet) populated, or concurrently removed.
+			continue
+		}
+		images = append(images, cachedImage{dir: dir, size: info.Size(), modTime: info.ModTime()})
+		stats.sizeBytes += info.Size()
+	}
+
+	slices.SortFunc(images, func(a, b cachedImage) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	for _, image := range images {
+		if stats.sizeBytes <= maxSize {
+			break
+		} else if time.Since(image.modTime) < rootDiskCacheMinAge {
+			continue
+		}
+
+		evicted, err := evictCachedImage(image.dir)
+		if err != nil {
+			return err
+		} else if !evicted {
+			continue // in use
+		}
+
+		logger.Info("evicted image from root disk cache", zap.String("dir", image.dir), zap.Int64("size", image.size))
+		stats.sizeBytes -= image.size
+		stats.evictedImages += 1
+		stats.evictedBytes += image.size
+	}
+
+	return nil
+}
+
+// evictCachedImage removes the cached image in the directory, returning false if it's in use.
+func evictCachedImage(dir string) (evicted bool, _ error) {
+	file, err := os.Open(filepath.Join(dir, rootDiskCacheFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil // already removed by someone else
+		}
+		return false, fmt.Errorf("could not open cached image: %w", err)
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not lock cached image: %w", err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return false, fmt.Errorf("could not remove cached image: %w", err)
+	}
+	return true, nil
+}
+
+// registerRootDiskCacheMetrics registers metrics describing the root disk cache as it was when the
+// runner started.
+func registerRootDiskCacheMetrics(reg *prometheus.Registry, stats *rootDiskCacheStats) {
+	gauge := func(name, help string, value float64) {
+		util.RegisterMetric(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		})).Set(value)
+	}
+
+	gauge("runner_rootdisk_cache_hit", "Whether the VM's root disk image was already in the node-local cache (1) or not (0)", lo.Ternary[float64](stats.hit, 1, 0))
+	gauge("runner_rootdisk_cache_size_bytes", "Total size of the node-local root disk image cache, after eviction at startup", float64(stats.sizeBytes))
+	gauge("runner_rootdisk_cache_evicted_images", "Number of images evicted from the node-local root disk image cache at startup", float64(stats.evictedImages))
+	gauge("runner_rootdisk_cache_evicted_bytes", "Total size of images evicted from the node-local root disk image cache at startup", float64(stats.evictedBytes))
+}
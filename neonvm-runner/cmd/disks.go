@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/alessio/shellescape"
@@ -120,6 +122,31 @@ func resizeRootDisk(logger *zap.Logger, vmSpec *vmv1.VirtualMachineSpec) error {
 	return nil
 }
 
+// runtimeScripts returns the command.sh, args.sh, and env.sh files that are run by vmstart inside
+// the guest, by name. Files that would be empty are not included.
+func runtimeScripts(command []string, args []string, env []vmv1.EnvVar) map[string][]byte {
+	files := make(map[string][]byte)
+
+	if len(command) != 0 {
+		files["command.sh"] = []byte(shellescape.QuoteCommand(command))
+	}
+
+	if len(args) != 0 {
+		files["args.sh"] = []byte(shellescape.QuoteCommand(args))
+	}
+
+	if len(env) != 0 {
+		envstring := []string{}
+		for _, e := range env {
+			envstring = append(envstring, fmt.Sprintf(`export %s=%s`, e.Name, shellescape.Quote(e.Value)))
+		}
+		envstring = append(envstring, "")
+		files["env.sh"] = []byte(strings.Join(envstring, "\n"))
+	}
+
+	return files
+}
+
 func createISO9660runtime(
 	diskPath string,
 	command []string,
@@ -130,6 +157,7 @@ func createISO9660runtime(
 	enableSSH bool,
 	swapSize *resource.Quantity,
 	shmsize *resource.Quantity,
+	warmPool bool,
 ) error {
 	writer, err := iso9660.NewWriter()
 	if err != nil {
@@ -144,29 +172,19 @@ func createISO9660runtime(
 		}
 	}
 
-	if len(command) != 0 {
-		err = writer.AddFile(bytes.NewReader([]byte(shellescape.QuoteCommand(command))), "command.sh")
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(args) != 0 {
-		err = writer.AddFile(bytes.NewReader([]byte(shellescape.QuoteCommand(args))), "args.sh")
+	if warmPool {
+		// Warm pool VMs get their command, args, and env once they're claimed.
+		err = writer.AddFile(bytes.NewReader(nil), warmPoolMarkerFile)
 		if err != nil {
 			return err
 		}
-	}
-
-	if len(env) != 0 {
-		envstring := []string{}
-		for _, e := range env {
-			envstring = append(envstring, fmt.Sprintf(`export %s=%s`, e.Name, shellescape.Quote(e.Value)))
-		}
-		envstring = append(envstring, "")
-		err = writer.AddFile(bytes.NewReader([]byte(strings.Join(envstring, "\n"))), "env.sh")
-		if err != nil {
-			return err
+	} else {
+		scripts := runtimeScripts(command, args, env)
+		for _, name := range slices.Sorted(maps.Keys(scripts)) {
+			err = writer.AddFile(bytes.NewReader(scripts[name]), name)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
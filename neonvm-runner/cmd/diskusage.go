@@ -0,0 +1,170 @@
+package main
+
+// Reporting the usage of the VM's disks, as measured inside the guest by neonvm-daemon.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// rootDiskName is the name used for the root disk in disk usage reports
+const rootDiskName = "rootdisk"
+
+func getNeonvmDaemonDiskUsage() ([]api.DiskUsage, error) {
+	_, vmIP, _, err := calcIPs(defaultNetworkCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("could not calculate VM IP address: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:25183/disks", vmIP)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build request: %w", err)
+	}
+
+	resp, err := doNeonvmDaemonRequest(http.DefaultClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("neonvm-daemon responded with status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+
+	var usage []api.DiskUsage
+	if err := json.Unmarshal(body, &usage); err != nil {
+		return nil, fmt.Errorf("could not parse response: %w", err)
+	}
+	return usage, nil
+}
+
+// vmDiskUsage returns the usage of the VM's writable disks -- the root disk, plus any empty disks
+// or tmpfs disks in the spec -- from the usage of all filesystems in the guest.
+//
+// Filesystems that don't correspond to one of these disks are ignored.
+func vmDiskUsage(vmSpec *vmv1.VirtualMachineSpec, guestUsage []api.DiskUsage) []api.DiskUsage {
+	names := map[string]string{"/": rootDiskName}
+	for _, disk := range vmSpec.Disks {
+		if disk.MountPath != "" && (disk.EmptyDisk != nil || disk.Tmpfs != nil) {
+			names[disk.MountPath] = disk.Name
+		}
+	}
+
+	usage := []api.DiskUsage{}
+	for _, u := range guestUsage {
+		if name, ok := names[u.MountPath]; ok {
+			u.Name = name
+			usage = append(usage, u)
+		}
+	}
+	return usage
+}
+
+func handleDiskUsage(
+	logger *zap.Logger,
+	w http.ResponseWriter,
+	r *http.Request,
+	get func(*zap.Logger) ([]api.DiskUsage, error),
+) {
+	if r.Method != "GET" {
+		logger.Error("unexpected method", zap.String("method", r.Method))
+		w.WriteHeader(400)
+		return
+	}
+
+	usage, err := get(logger)
+	if err != nil {
+		logger.Error("could not get disk usage", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	body, err := json.Marshal(usage)
+	if err != nil {
+		logger.Error("could not marshal body", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(body) //nolint:errcheck // Not much to do with the error here. TODO: log it?
+}
+
+type diskUsageMetrics struct {
+	totalBytes *prometheus.GaugeVec
+	usedBytes  *prometheus.GaugeVec
+	usedRatio  *prometheus.GaugeVec
+	errors     prometheus.Counter
+}
+
+func newDiskUsageMetrics(reg *prometheus.Registry) *diskUsageMetrics {
+	labels := []string{"disk", "mount_path"}
+	return &diskUsageMetrics{
+		totalBytes: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "runner_vm_disk_size_bytes",
+				Help: "Size of the filesystem on each of the VM's writable disks",
+			},
+			labels,
+		)),
+		usedBytes: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "runner_vm_disk_used_bytes",
+				Help: "Space used in the filesystem on each of the VM's writable disks",
+			},
+			labels,
+		)),
+		usedRatio: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "runner_vm_disk_used_ratio",
+				Help: "Fraction of the usable space in use in the filesystem on each of the VM's writable disks",
+			},
+			labels,
+		)),
+		errors: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "runner_vm_disk_usage_fetch_errors_total",
+				Help: "Number of errors while fetching disk usage from the guest",
+			},
+		)),
+	}
+}
+
+func (m *diskUsageMetrics) update(logger *zap.Logger, get func(*zap.Logger) ([]api.DiskUsage, error)) {
+	usage, err := get(logger)
+	if err != nil {
+		logger.Warn("failed to get disk usage for metrics", zap.Error(err))
+		m.errors.Inc()
+		return
+	}
+
+	// Reset first, so that disks that are no longer mounted aren't reported.
+	m.totalBytes.Reset()
+	m.usedBytes.Reset()
+	m.usedRatio.Reset()
+	for _, u := range usage {
+		m.totalBytes.WithLabelValues(u.Name, u.MountPath).Set(float64(u.TotalBytes))
+		m.usedBytes.WithLabelValues(u.Name, u.MountPath).Set(float64(u.UsedBytes))
+		m.usedRatio.WithLabelValues(u.Name, u.MountPath).Set(u.UsedFraction())
+	}
+}
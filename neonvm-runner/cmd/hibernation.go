@@ -0,0 +1,244 @@
+package main
+
+// Saving and restoring the VM's state for hibernation.
+//
+// When hibernating, we pause the guest, "migrate" its memory state to a file on the hibernation
+// state volume, and copy the VM's writable disks alongside it. The controller then removes the
+// runner pod. When the VM is resumed, the new runner copies the disks back and starts QEMU with
+// that file as the incoming migration.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/go-qemu/qmp"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+const (
+	hibernationStateDir        = "/vm/hibernation"
+	hibernationMemoryStatePath = hibernationStateDir + "/memory"
+	hibernationDisksDir        = hibernationStateDir + "/disks"
+	// hibernationCompletePath is created once all of the state has been saved, so that we never
+	// try to restore from partially-saved state.
+	hibernationCompletePath = hibernationStateDir + "/complete"
+
+	qmpUnixSocketForHibernation = "/vm/qmp-hibernation.sock"
+)
+
+// shouldRestoreHibernationState returns whether this runner was started to resume the VM from
+// hibernation.
+func shouldRestoreHibernationState() bool {
+	return os.Getenv("RESTORE_HIBERNATION_STATE") == "true"
+}
+
+// writableDiskPaths returns the paths of the disk images that the guest may have written to, which
+// need to be saved alongside the memory state.
+func writableDiskPaths() ([]string, error) {
+	return filepath.Glob(filepath.Join(mountedDiskPath, "*.qcow2"))
+}
+
+// restoreHibernationState copies the VM's saved disks back, so that QEMU can be started with the
+// saved memory state as the incoming migration.
+func restoreHibernationState(logger *zap.Logger) error {
+	if _, err := os.Stat(hibernationCompletePath); err != nil {
+		return fmt.Errorf("hibernation state is incomplete: %w", err)
+	}
+
+	saved, err := filepath.Glob(filepath.Join(hibernationDisksDir, "*.qcow2"))
+	if err != nil {
+		return err
+	}
+	for _, src := range saved {
+		dst := filepath.Join(mountedDiskPath, filepath.Base(src))
+		logger.Info("restoring disk from hibernation state", zap.String("from", src), zap.String("to", dst))
+		if err := copyFile(src, dst); err != nil {
+			return fmt.Errorf("failed to restore disk %q: %w", filepath.Base(src), err)
+		}
+	}
+	return nil
+}
+
+// hibernator saves the VM's state when requested by the controller, reporting its progress.
+type hibernator struct {
+	mu     sync.Mutex
+	status api.HibernationStatus
+}
+
+func newHibernator() *hibernator {
+	return &hibernator{
+		mu:     sync.Mutex{},
+		status: api.HibernationStatus{State: api.HibernationStateNotStarted, Error: ""},
+	}
+}
+
+func (h *hibernator) getStatus() api.HibernationStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+func (h *hibernator) setStatus(status api.HibernationStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status = status
+}
+
+// start begins saving the VM's state in the background, if it isn't already being saved.
+//
+// It's fine to call start again after a previous attempt failed.
+func (h *hibernator) start(logger *zap.Logger) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch h.status.State {
+	case api.HibernationStateInProgress, api.HibernationStateCompleted:
+		return
+	case api.HibernationStateNotStarted, api.HibernationStateFailed:
+	}
+
+	h.status = api.HibernationStatus{State: api.HibernationStateInProgress, Error: ""}
+	go func() {
+		if err := saveHibernationState(logger); err != nil {
+			logger.Error("failed to save hibernation state", zap.Error(err))
+			h.setStatus(api.HibernationStatus{State: api.HibernationStateFailed, Error: err.Error()})
+			return
+		}
+		logger.Info("saved hibernation state")
+		h.setStatus(api.HibernationStatus{State: api.HibernationStateCompleted, Error: ""})
+	}()
+}
+
+func saveHibernationState(logger *zap.Logger) (finalErr error) {
+	// Clear out any state from previous hibernations, starting with the marker that it's complete.
+	for _, path := range []string{hibernationCompletePath, hibernationMemoryStatePath, hibernationDisksDir} {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove previous hibernation state: %w", err)
+		}
+	}
+	if err := os.MkdirAll(hibernationDisksDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create hibernation state directory: %w", err)
+	}
+	// QEMU runs as an unprivileged user, so it needs to be able to write the memory state file.
+	if err := os.WriteFile(hibernationMemoryStatePath, nil, 0o666); err != nil {
+		return fmt.Errorf("failed to create memory state file: %w", err)
+	}
+	if err := os.Chmod(hibernationMemoryStatePath, 0o666); err != nil {
+		return fmt.Errorf("failed to set permissions on memory state file: %w", err)
+	}
+
+	mon, err := qmp.NewSocketMonitor("unix", qmpUnixSocketForHibernation, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to QEMU monitor: %w", err)
+	}
+	if err := mon.Connect(); err != nil {
+		return fmt.Errorf("failed to start monitor connection: %w", err)
+	}
+	defer mon.Disconnect() //nolint:errcheck // nothing to do with error when deferred.
+
+	// Pause the guest so that its disks don't change while we're copying them.
+	logger.Info("pausing VM to save hibernation state")
+	if _, err := mon.Run([]byte(`{"execute": "stop"}`)); err != nil {
+		return fmt.Errorf("failed to pause VM: %w", err)
+	}
+	// If we fail to save the state, the VM should keep running.
+	defer func() {
+		if finalErr == nil {
+			return
+		}
+		if _, err := mon.Run([]byte(`{"execute": "cont"}`)); err != nil {
+			logger.Error("failed to resume VM after failing to save hibernation state", zap.Error(err))
+		}
+	}()
+
+	migrateCmd, err := json.Marshal(map[string]any{
+		"execute": "migrate",
+		"arguments": map[string]any{
+			"uri": fmt.Sprintf("exec:cat > %s", hibernationMemoryStatePath),
+		},
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal migrate command: %w", err))
+	}
+	logger.Info("saving VM memory state", zap.String("path", hibernationMemoryStatePath))
+	if _, err := mon.Run(migrateCmd); err != nil {
+		return fmt.Errorf("failed to start saving memory state: %w", err)
+	}
+	if err := waitForMigration(mon); err != nil {
+		return fmt.Errorf("failed to save memory state: %w", err)
+	}
+
+	// Once the migration has completed, QEMU has flushed the disks and won't write to them again.
+	disks, err := writableDiskPaths()
+	if err != nil {
+		return err
+	}
+	for _, src := range disks {
+		dst := filepath.Join(hibernationDisksDir, filepath.Base(src))
+		logger.Info("saving disk for hibernation", zap.String("from", src), zap.String("to", dst))
+		if err := copyFile(src, dst); err != nil {
+			return fmt.Errorf("failed to save disk %q: %w", filepath.Base(src), err)
+		}
+	}
+
+	f, err := os.Create(hibernationCompletePath)
+	if err != nil {
+		return fmt.Errorf("failed to mark hibernation state as complete: %w", err)
+	}
+	return errors.Join(f.Sync(), f.Close())
+}
+
+// waitForMigration polls QEMU until the current outgoing migration is done.
+func waitForMigration(mon *qmp.SocketMonitor) error {
+	for {
+		raw, err := mon.Run([]byte(`{"execute": "query-migrate"}`))
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Return struct {
+				Status    string `json:"status"`
+				ErrorDesc string `json:"error-desc"`
+			} `json:"return"`
+		}
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return fmt.Errorf("failed to parse query-migrate response: %w", err)
+		}
+
+		switch result.Return.Status {
+		case "completed":
+			return nil
+		case "failed", "cancelled":
+			return fmt.Errorf("migration %s: %s", result.Return.Status, result.Return.ErrorDesc)
+		default:
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+}
+
+// copyFile copies src to dst, syncing dst to disk before returning.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return errors.Join(out.Sync(), out.Close())
+}
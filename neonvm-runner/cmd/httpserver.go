@@ -20,8 +20,11 @@ import (
 
 type cpuServerCallbacks struct {
 	get   func(*zap.Logger) (*vmv1.MilliCPU, error)
+	guest func(*zap.Logger) *vmv1.MilliCPU
 	set   func(*zap.Logger, vmv1.MilliCPU) error
 	ready func(*zap.Logger) bool
+	// diskUsage returns the usage of the VM's writable disks
+	diskUsage func(*zap.Logger) ([]api.DiskUsage, error)
 }
 
 func listenForHTTPRequests(
@@ -31,6 +34,9 @@ func listenForHTTPRequests(
 	callbacks cpuServerCallbacks,
 	wg *sync.WaitGroup,
 	networkMonitoring bool,
+	cacheStats *rootDiskCacheStats,
+	hib *hibernator,
+	warmPool bool,
 ) {
 	defer wg.Done()
 	mux := http.NewServeMux()
@@ -41,7 +47,11 @@ func listenForHTTPRequests(
 	})
 	cpuCurrentLogger := loggerHandlers.Named("cpu_current")
 	mux.HandleFunc("/cpu_current", func(w http.ResponseWriter, r *http.Request) {
-		handleCPUCurrent(cpuCurrentLogger, w, r, callbacks.get)
+		handleCPUCurrent(cpuCurrentLogger, w, r, callbacks.get, callbacks.guest)
+	})
+	diskUsageLogger := loggerHandlers.Named("disk_usage")
+	mux.HandleFunc("/disk_usage", func(w http.ResponseWriter, r *http.Request) {
+		handleDiskUsage(diskUsageLogger, w, r, callbacks.diskUsage)
 	})
 	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
 		if callbacks.ready(logger) {
@@ -50,18 +60,41 @@ func listenForHTTPRequests(
 			w.WriteHeader(500)
 		}
 	})
-	if networkMonitoring {
+	if hib != nil {
+		hibernateLogger := loggerHandlers.Named("hibernate")
+		mux.HandleFunc("/hibernate", func(w http.ResponseWriter, r *http.Request) {
+			handleHibernate(hibernateLogger, w, r, hib)
+		})
+	}
+	if warmPool {
+		rebindLogger := loggerHandlers.Named("rebind")
+		mux.HandleFunc("/rebind", func(w http.ResponseWriter, r *http.Request) {
+			handleRebind(rebindLogger, w, r)
+		})
+	}
+	{
 		reg := prometheus.NewRegistry()
-		metrics := NewMonitoringMetrics(reg)
+		var metrics *NetworkMonitoringMetrics
+		if networkMonitoring {
+			metrics = NewMonitoringMetrics(reg)
+		}
+		if cacheStats != nil {
+			registerRootDiskCacheMetrics(reg, cacheStats)
+		}
+		diskMetrics := newDiskUsageMetrics(reg)
+		reg.MustRegister(versionSkewTotal)
 		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-			metrics.update(logger)
+			if metrics != nil {
+				metrics.update(logger)
+			}
+			diskMetrics.update(logger, callbacks.diskUsage)
 			h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
 			h.ServeHTTP(w, r)
 		})
 	}
 	server := http.Server{
 		Addr:              fmt.Sprintf("0.0.0.0:%d", port),
-		Handler:           mux,
+		Handler:           withVersionCheck(loggerHandlers, mux),
 		ReadTimeout:       5 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      5 * time.Second,
@@ -125,6 +158,7 @@ func handleCPUCurrent(
 	w http.ResponseWriter,
 	r *http.Request,
 	get func(*zap.Logger) (*vmv1.MilliCPU, error),
+	guest func(*zap.Logger) *vmv1.MilliCPU,
 ) {
 	if r.Method != "GET" {
 		logger.Error("unexpected method", zap.String("method", r.Method))
@@ -138,7 +172,7 @@ func handleCPUCurrent(
 		w.WriteHeader(500)
 		return
 	}
-	resp := api.VCPUCgroup{VCPUs: *cpus}
+	resp := api.VCPUCgroup{VCPUs: *cpus, GuestVCPUs: guest(logger)}
 	body, err := json.Marshal(resp)
 	if err != nil {
 		logger.Error("could not marshal body", zap.Error(err))
@@ -149,3 +183,29 @@ func handleCPUCurrent(
 	w.Header().Add("Content-Type", "application/json")
 	w.Write(body) //nolint:errcheck // Not much to do with the error here. TODO: log it?
 }
+
+func handleHibernate(
+	logger *zap.Logger,
+	w http.ResponseWriter,
+	r *http.Request,
+	hib *hibernator,
+) {
+	switch r.Method {
+	case "POST":
+		hib.start(logger)
+		w.WriteHeader(202)
+	case "GET":
+		body, err := json.Marshal(hib.getStatus())
+		if err != nil {
+			logger.Error("could not marshal body", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(body) //nolint:errcheck // Not much to do with the error here.
+	default:
+		logger.Error("unexpected method", zap.String("method", r.Method))
+		w.WriteHeader(400)
+	}
+}
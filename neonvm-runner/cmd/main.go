@@ -31,6 +31,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/util"
 	"github.com/neondatabase/autoscaling/pkg/util/taskgroup"
 )
@@ -111,6 +112,16 @@ type Config struct {
 	cpuScalingMode vmv1.CpuScalingMode
 	// System CPU architecture. Set automatically equal to runtime.GOARCH.
 	architecture string
+	// rootDiskCacheDir, if not empty, is the directory of the node-local root disk image cache.
+	// See diskcache.go for more.
+	rootDiskCacheDir string
+	// rootDiskCacheKey is the key of the VM's root disk image in the cache.
+	rootDiskCacheKey string
+	// rootDiskCacheMaxSize is the size, in bytes, above which we evict images from the cache.
+	rootDiskCacheMaxSize int64
+	// warmPool is true if the VM is part of a warm pool, so its workload shouldn't start until
+	// it's claimed. See warmpool.go for more.
+	warmPool bool
 }
 
 func newConfig(logger *zap.Logger) *Config {
@@ -124,6 +135,10 @@ func newConfig(logger *zap.Logger) *Config {
 		autoMovableRatio:     "",
 		cpuScalingMode:       "",
 		architecture:         runtime.GOARCH,
+		rootDiskCacheDir:     "",
+		rootDiskCacheKey:     "",
+		rootDiskCacheMaxSize: 0,
+		warmPool:             false,
 	}
 	flag.StringVar(&cfg.vmSpecDump, "vmspec", cfg.vmSpecDump,
 		"Base64 encoded VirtualMachine json specification")
@@ -141,6 +156,14 @@ func newConfig(logger *zap.Logger) *Config {
 	flag.StringVar(&cfg.autoMovableRatio, "memhp-auto-movable-ratio",
 		cfg.autoMovableRatio, "Set value of kernel's memory_hotplug.auto_movable_ratio [virtio-mem only]")
 	flag.Func("cpu-scaling-mode", "Set CPU scaling mode", cfg.cpuScalingMode.FlagFunc)
+	flag.StringVar(&cfg.rootDiskCacheDir, "rootdisk-cache-dir", cfg.rootDiskCacheDir,
+		"Directory of the node-local root disk image cache, populated by the init container")
+	flag.StringVar(&cfg.rootDiskCacheKey, "rootdisk-cache-key", cfg.rootDiskCacheKey,
+		"Key of the VM's root disk image in the cache")
+	flag.Int64Var(&cfg.rootDiskCacheMaxSize, "rootdisk-cache-max-size", cfg.rootDiskCacheMaxSize,
+		"Size in bytes above which images are evicted from the root disk image cache")
+	flag.BoolVar(&cfg.warmPool, "warm-pool", cfg.warmPool,
+		"Wait for the VM to be claimed from its warm pool before starting the workload")
 	flag.Parse()
 
 	if cfg.autoMovableRatio == "" {
@@ -149,6 +172,9 @@ func newConfig(logger *zap.Logger) *Config {
 	if cfg.cpuScalingMode == "" {
 		logger.Fatal("missing required flag '-cpu-scaling-mode'")
 	}
+	if (cfg.rootDiskCacheDir == "") != (cfg.rootDiskCacheKey == "") {
+		logger.Fatal("flags '-rootdisk-cache-dir' and '-rootdisk-cache-key' must be set together")
+	}
 
 	return cfg
 }
@@ -255,10 +281,21 @@ func run(logger *zap.Logger) error {
 			enableSSH,
 			swapSize,
 			shmSize,
+			cfg.warmPool,
 		)
 	})
 
+	var cacheStats *rootDiskCacheStats
+
 	tg.Go("rootDisk", func(logger *zap.Logger) error {
+		if cfg.rootDiskCacheDir != "" {
+			var err error
+			cacheStats, err = setupCachedRootDisk(logger, cfg.rootDiskCacheDir, cfg.rootDiskCacheKey, cfg.rootDiskCacheMaxSize)
+			if err != nil {
+				return err
+			}
+		}
+
 		// resize rootDisk image of size specified and new size more than current
 		return resizeRootDisk(logger, vmSpec)
 	})
@@ -274,7 +311,14 @@ func run(logger *zap.Logger) error {
 		return err
 	}
 
-	err = runQEMU(cfg, logger, vmSpec, qemuCmd)
+	// Disks must be restored after they're set up above, so that the saved state isn't overwritten.
+	if shouldRestoreHibernationState() {
+		if err := restoreHibernationState(logger); err != nil {
+			return fmt.Errorf("failed to restore hibernation state: %w", err)
+		}
+	}
+
+	err = runQEMU(cfg, logger, vmSpec, qemuCmd, cacheStats)
 	if err != nil {
 		return fmt.Errorf("failed to run QEMU: %w", err)
 	}
@@ -294,7 +338,7 @@ func buildQEMUCmd(
 	// prepare qemu command line
 	qemuCmd := []string{
 		"-runas", "qemu",
-		"-machine", getMachineType(cfg.architecture),
+		"-machine", getMachineType(cfg.architecture, vmSpec),
 		"-nographic",
 		"-no-reboot",
 		"-nodefaults",
@@ -305,6 +349,7 @@ func buildQEMUCmd(
 		"-qmp", fmt.Sprintf("tcp:0.0.0.0:%d,server,wait=off", vmSpec.QMP),
 		"-qmp", fmt.Sprintf("tcp:0.0.0.0:%d,server,wait=off", vmSpec.QMPManual),
 		"-qmp", fmt.Sprintf("unix:%s,server,wait=off", qmpUnixSocketForSigtermHandler),
+		"-qmp", fmt.Sprintf("unix:%s,server,wait=off", qmpUnixSocketForHibernation),
 		"-device", "virtio-serial",
 		"-chardev", fmt.Sprintf("socket,path=%s,server=on,wait=off,id=log", logSerialSocket),
 		"-device", "virtserialport,chardev=log,name=tech.neon.log.0",
@@ -316,8 +361,8 @@ func buildQEMUCmd(
 	}
 	qemuCmd = append(qemuCmd, qemuDiskArgs...)
 
-	switch cfg.architecture {
-	case architectureArm64:
+	switch {
+	case cfg.architecture == architectureArm64:
 		// add custom firmware to have ACPI working
 		qemuCmd = append(qemuCmd, "-bios", "/vm/QEMU_EFI_ARM.fd")
 		// arm virt has only one UART, setup virtio-serial to add more /dev/hvcX
@@ -325,7 +370,13 @@ func buildQEMUCmd(
 			"-chardev", "stdio,id=virtio-console",
 			"-device", "virtconsole,chardev=virtio-console",
 		)
-	case architectureAmd64:
+	case cfg.architecture == architectureAmd64 && vmSpec.UsesMicroVM():
+		// microvm also has only one UART, so same as arm.
+		qemuCmd = append(qemuCmd,
+			"-chardev", "stdio,id=virtio-console",
+			"-device", "virtconsole,chardev=virtio-console",
+		)
+	case cfg.architecture == architectureAmd64:
 		// on amd we have multiple UART ports so we can just use serial stdio
 		qemuCmd = append(qemuCmd, "-serial", "stdio")
 	default:
@@ -388,7 +439,13 @@ func buildQEMUCmd(
 		qemuCmd = append(qemuCmd, "-device", "virtio-mem-pci,id=vm0,memdev=vmem0,block-size=8M,requested-size=0")
 	}
 
-	qemuNetArgs, err := setupVMNetworks(logger, vmSpec.Guest.Ports, vmSpec.ExtraNetwork)
+	// When receiving a migration, the source VM still holds the overlay IP until the migration
+	// completes, and QEMU announces the guest itself once it's resumed.
+	var overlayIP net.IP
+	if os.Getenv("RECEIVE_MIGRATION") != "true" {
+		overlayIP = net.ParseIP(vmStatus.ExtraNetIP)
+	}
+	qemuNetArgs, err := setupVMNetworks(logger, vmSpec.Guest.Ports, vmSpec.ExtraNetwork, overlayIP)
 	if err != nil {
 		return nil, err
 	}
@@ -404,6 +461,8 @@ func buildQEMUCmd(
 	// should runner receive migration ?
 	if os.Getenv("RECEIVE_MIGRATION") == "true" {
 		qemuCmd = append(qemuCmd, "-incoming", fmt.Sprintf("tcp:0:%d", vmv1.MigrationPort))
+	} else if shouldRestoreHibernationState() {
+		qemuCmd = append(qemuCmd, "-incoming", fmt.Sprintf("exec:cat %s", hibernationMemoryStatePath))
 	}
 
 	return qemuCmd, nil
@@ -444,7 +503,12 @@ func makeKernelCmdline(cfg *Config, logger *zap.Logger, vmSpec *vmv1.VirtualMach
 		// use virtio-serial device kernel console
 		cmdlineParts = append(cmdlineParts, "console=hvc0")
 	case architectureAmd64:
-		cmdlineParts = append(cmdlineParts, "console=ttyS1")
+		if vmSpec.UsesMicroVM() {
+			// same console as arm; see buildQEMUCmd.
+			cmdlineParts = append(cmdlineParts, "console=hvc0")
+		} else {
+			cmdlineParts = append(cmdlineParts, "console=ttyS1")
+		}
 	default:
 		logger.Fatal("unsupported architecture", zap.String("architecture", cfg.architecture))
 	}
@@ -457,6 +521,7 @@ func runQEMU(
 	logger *zap.Logger,
 	vmSpec *vmv1.VirtualMachineSpec,
 	qemuCmd []string,
+	cacheStats *rootDiskCacheStats,
 ) error {
 	selfPodName, ok := os.LookupEnv("K8S_POD_NAME")
 	if !ok {
@@ -508,6 +573,15 @@ func runQEMU(
 				panic(fmt.Errorf("unknown CPU scaling mode %q", cfg.cpuScalingMode))
 			}
 		},
+		guest: func(logger *zap.Logger) *vmv1.MilliCPU {
+			// Only informational, so failures here aren't errors.
+			cpu, err := getNeonvmDaemonCPU()
+			if err != nil {
+				logger.Warn("failed to get online CPUs from NeonVM Daemon", zap.Error(err))
+				return nil
+			}
+			return &cpu
+		},
 		set: func(logger *zap.Logger, cpu vmv1.MilliCPU) error {
 			switch cfg.cpuScalingMode {
 			case vmv1.CpuScalingModeSysfs:
@@ -544,11 +618,22 @@ func runQEMU(
 				panic(fmt.Errorf("unknown CPU scaling mode %q", cfg.cpuScalingMode))
 			}
 		},
+		diskUsage: func(logger *zap.Logger) ([]api.DiskUsage, error) {
+			usage, err := getNeonvmDaemonDiskUsage()
+			if err != nil {
+				return nil, err
+			}
+			return vmDiskUsage(vmSpec, usage), nil
+		},
 	}
 
 	wg.Add(1)
 	monitoring := vmSpec.EnableNetworkMonitoring != nil && *vmSpec.EnableNetworkMonitoring
-	go listenForHTTPRequests(ctx, logger, vmSpec.RunnerPort, callbacks, &wg, monitoring)
+	var hib *hibernator
+	if vmSpec.Hibernation != nil {
+		hib = newHibernator()
+	}
+	go listenForHTTPRequests(ctx, logger, vmSpec.RunnerPort, callbacks, &wg, monitoring, cacheStats, hib, cfg.warmPool)
 	wg.Add(1)
 	go forwardLogs(ctx, logger, &wg)
 	wg.Add(1)
@@ -592,12 +677,18 @@ func getQemuBinaryName(architecture string) string {
 	}
 }
 
-func getMachineType(architecture string) string {
+func getMachineType(architecture string, vmSpec *vmv1.VirtualMachineSpec) string {
 	switch architecture {
 	case architectureArm64:
 		// virt is the most up to date and generic ARM machine architecture
+		// nb: microvm is amd64-only, which the webhook checks.
 		return "virt"
 	case architectureAmd64:
+		if vmSpec.UsesMicroVM() {
+			// microvm without the legacy devices we don't use. PCIe is kept so that our
+			// virtio devices work the same as with q35.
+			return "microvm,x-option-roms=off,pit=off,pic=off,pcie=on"
+		}
 		// q35 is the most up to date and generic x86_64 machine architecture
 		return "q35"
 	default:
@@ -877,7 +968,7 @@ func setNeonvmDaemonCPU(cpu vmv1.MilliCPU) error {
 		return fmt.Errorf("could not build request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doNeonvmDaemonRequest(http.DefaultClient, req)
 	if err != nil {
 		return fmt.Errorf("could not send request: %w", err)
 	}
@@ -906,7 +997,7 @@ func getNeonvmDaemonCPU() (vmv1.MilliCPU, error) {
 		return 0, fmt.Errorf("could not build request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doNeonvmDaemonRequest(http.DefaultClient, req)
 	if err != nil {
 		return 0, fmt.Errorf("could not send request: %w", err)
 	}
@@ -935,16 +1026,22 @@ type File struct {
 }
 
 func sendFilesToNeonvmDaemon(ctx context.Context, hostpath, guestpath string) error {
-	_, vmIP, _, err := calcIPs(defaultNetworkCIDR)
-	if err != nil {
-		return fmt.Errorf("could not calculate VM IP address: %w", err)
-	}
-
 	files, err := util.ReadAllFiles(hostpath)
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("could not open file: %w", err)
 	}
 
+	return sendFileMapToNeonvmDaemon(ctx, files, guestpath)
+}
+
+// sendFileMapToNeonvmDaemon replaces the contents of guestpath inside the VM with the files, by
+// name
+func sendFileMapToNeonvmDaemon(ctx context.Context, files map[string][]byte, guestpath string) error {
+	_, vmIP, _, err := calcIPs(defaultNetworkCIDR)
+	if err != nil {
+		return fmt.Errorf("could not calculate VM IP address: %w", err)
+	}
+
 	encodedFiles := make(map[string]File)
 	for k, v := range files {
 		encodedFiles[k] = File{Data: base64.StdEncoding.EncodeToString(v)}
@@ -970,7 +1067,7 @@ func sendFilesToNeonvmDaemon(ctx context.Context, hostpath, guestpath string) er
 			return http.ErrUseLastResponse
 		},
 	}
-	resp, err := client.Do(req)
+	resp, err := doNeonvmDaemonRequest(client, req)
 	if err != nil {
 		return fmt.Errorf("could not send request: %w", err)
 	}
@@ -1000,7 +1097,7 @@ func getFileChecksumFromNeonvmDaemon(ctx context.Context, guestpath string) (str
 		return "", fmt.Errorf("could not build request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doNeonvmDaemonRequest(http.DefaultClient, req)
 	if err != nil {
 		return "", fmt.Errorf("could not send request: %w", err)
 	}
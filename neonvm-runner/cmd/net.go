@@ -30,7 +30,12 @@ const (
 )
 
 // setupVMNetworks creates the networks for the VM and returns the appropriate QMEU args
-func setupVMNetworks(logger *zap.Logger, ports []vmv1.Port, extraNetwork *vmv1.ExtraNetwork) ([]string, error) {
+//
+// If overlayIP is not nil, the guest's side of the overlay network is kept down until we've checked
+// that the IP isn't used by another host on the overlay network. That happens in the background
+// while QEMU starts, after which the IP is announced for the guest. Refer to
+// enableOverlayNetworkWhenFree.
+func setupVMNetworks(logger *zap.Logger, ports []vmv1.Port, extraNetwork *vmv1.ExtraNetwork, overlayIP net.IP) ([]string, error) {
 	// Create network tap devices.
 	//
 	// It is important to enable multiqueue support for virtio-net-pci devices as we seen them choking on
@@ -51,10 +56,13 @@ func setupVMNetworks(logger *zap.Logger, ports []vmv1.Port, extraNetwork *vmv1.E
 
 	// overlay (multus) net details
 	if extraNetwork != nil && extraNetwork.Enable {
-		macOverlay, err := overlayNetwork(extraNetwork.Interface)
+		macOverlay, err := overlayNetwork(extraNetwork.Interface, overlayIP == nil)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to set up overlay network: %w", err)
 		}
+		if overlayIP != nil {
+			go enableOverlayNetworkWhenFree(logger, overlayIP, net.HardwareAddr(macOverlay))
+		}
 		qemuCmd = append(qemuCmd, "-netdev", fmt.Sprintf("tap,id=overlay,ifname=%s,queues=4,script=no,downscript=no,vhost=on", overlayNetworkTapName))
 		qemuCmd = append(qemuCmd, "-device", fmt.Sprintf("virtio-net-pci,mq=on,vectors=10,netdev=overlay,mac=%s", macOverlay.String()))
 	}
@@ -260,7 +268,9 @@ func defaultNetwork(logger *zap.Logger, cidr string, ports []vmv1.Port) (mac.MAC
 	return mac, nil
 }
 
-func overlayNetwork(iface string) (mac.MAC, error) {
+// overlayNetwork creates the bridge and TAP interface for the overlay network. If tapUp is false,
+// the TAP interface is left down, so that the guest can't use the network yet.
+func overlayNetwork(iface string, tapUp bool) (mac.MAC, error) {
 	// gerenare random MAC for overlay Guest interface
 	mac, err := mac.GenerateRandMAC()
 	if err != nil {
@@ -297,8 +307,10 @@ func overlayNetwork(iface string) (mac.MAC, error) {
 	if err := netlink.LinkSetMaster(tap, bridge); err != nil {
 		return nil, err
 	}
-	if err := netlink.LinkSetUp(tap); err != nil {
-		return nil, err
+	if tapUp {
+		if err := netlink.LinkSetUp(tap); err != nil {
+			return nil, err
+		}
 	}
 
 	// add overlay interface to bridge as well
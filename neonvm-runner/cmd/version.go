@@ -0,0 +1,67 @@
+package main
+
+// Checking the versions of the components we talk to: the controller, which sends us requests, and
+// neonvm-daemon inside the guest, which we send requests to.
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// versionSkewTotal counts the requests and responses from peers with unsupported versions. It's
+// global because requests to neonvm-daemon are made from many places.
+var versionSkewTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "runner_version_skew_total",
+		Help: "Number of messages from peers with unsupported versions, by peer, peer version, and action",
+	},
+	[]string{"peer", "peer_version", "action"},
+)
+
+// withVersionCheck wraps the handler to check the version of the controller making each request,
+// refusing requests from incompatible versions, and to include our own version in the response.
+func withVersionCheck(logger *zap.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.SetComponentVersionHeader(w.Header(), api.ComponentNeonVMRunner)
+
+		skew := api.CheckPeerVersionHeader(api.ComponentNeonVMRunner, api.ComponentNeonVMController, r.Header)
+		if skew != nil {
+			versionSkewTotal.WithLabelValues(string(skew.Peer), skew.PeerVersion.String(), skew.Action()).Inc()
+			if skew.Refuse {
+				logger.Error("Refusing request from incompatible controller", zap.String("path", r.URL.Path), zap.Error(skew))
+				w.WriteHeader(400)
+				_, _ = w.Write([]byte(skew.Error()))
+				return
+			}
+			logger.Warn("Received request from controller with unsupported version skew", zap.String("path", r.URL.Path), zap.Error(skew))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// doNeonvmDaemonRequest sends the request to neonvm-daemon with the client, refusing responses from
+// incompatible versions of neonvm-daemon.
+func doNeonvmDaemonRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	api.SetComponentVersionHeader(req.Header, api.ComponentNeonVMRunner)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	skew := api.CheckPeerVersionHeader(api.ComponentNeonVMRunner, api.ComponentNeonVMDaemon, resp.Header)
+	if skew != nil {
+		versionSkewTotal.WithLabelValues(string(skew.Peer), skew.PeerVersion.String(), skew.Action()).Inc()
+		if skew.Refuse {
+			resp.Body.Close()
+			return nil, skew
+		}
+	}
+
+	return resp, nil
+}
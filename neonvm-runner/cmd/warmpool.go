@@ -0,0 +1,87 @@
+package main
+
+// Booting VMs for a warm pool, and handing them over to the VM that claims them.
+//
+// Warm pool VMs are booted without the parts of the spec that belong to the VM that will claim
+// them: the command, args, and env, plus the contents of any ConfigMap or Secret disks. Instead,
+// the runtime disk has a marker file telling vmstart to wait before starting the workload.
+//
+// When the VM is claimed, the controller sends those to the runner, which writes them inside the
+// guest via neonvm-daemon. The command, args, and env are written last, together with the file
+// that vmstart is waiting for, so the workload only starts once everything is in place.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+const (
+	// warmPoolMarkerFile is added to the runtime disk of warm pool VMs, so that vmstart waits for
+	// the VM to be claimed.
+	warmPoolMarkerFile = "warmpool"
+	// rebindGuestPath is the directory inside the guest where the claiming VM's command.sh,
+	// args.sh, and env.sh are written. vmstart uses these in place of the ones on the runtime
+	// disk.
+	rebindGuestPath = "/neonvm/rebind"
+	// rebindClaimedFile is written to rebindGuestPath alongside the scripts, once everything
+	// else has been sent to the guest.
+	rebindClaimedFile = "claimed"
+)
+
+// rebindWarmPoolVM sends the parts of the claiming VM's spec to the guest, and allows the workload
+// to start.
+//
+// It's safe to call more than once; the files in the guest are replaced each time.
+func rebindWarmPoolVM(ctx context.Context, logger *zap.Logger, rebind *api.WarmPoolRebind) error {
+	for _, dir := range slices.Sorted(maps.Keys(rebind.Files)) {
+		logger.Info("sending files to guest", zap.String("dir", dir), zap.Int("count", len(rebind.Files[dir])))
+		if err := sendFileMapToNeonvmDaemon(ctx, rebind.Files[dir], dir); err != nil {
+			return fmt.Errorf("could not send files for %s: %w", dir, err)
+		}
+	}
+
+	files := runtimeScripts(rebind.Command, rebind.Args, rebind.Env)
+	files[rebindClaimedFile] = nil
+	if err := sendFileMapToNeonvmDaemon(ctx, files, rebindGuestPath); err != nil {
+		return fmt.Errorf("could not send runtime scripts: %w", err)
+	}
+	return nil
+}
+
+func handleRebind(logger *zap.Logger, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		logger.Error("unexpected method", zap.String("method", r.Method))
+		w.WriteHeader(400)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Error("could not read body", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+
+	var parsed api.WarmPoolRebind
+	if err = json.Unmarshal(body, &parsed); err != nil {
+		logger.Error("could not parse body", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+
+	if err := rebindWarmPoolVM(r.Context(), logger, &parsed); err != nil {
+		logger.Error("could not rebind warm pool VM", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+}
@@ -18,6 +18,10 @@ type IPPoolSpec struct {
 type IPAllocation struct {
 	ContainerID string `json:"id"`
 	PodRef      string `json:"podref,omitempty"`
+	// ReleasedAt is set when the IP was released by its owner, in which case the IP is quarantined
+	// for a while before it can be reused, so that stale ARP entries for it can expire.
+	// +optional
+	ReleasedAt *metav1.Time `json:"releasedAt,omitempty"`
 }
 
 //+genclient
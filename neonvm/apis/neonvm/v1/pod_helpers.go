@@ -101,3 +101,16 @@ func extractFromAnnotation[T any](pod *corev1.Pod, annotation string) (*T, error
 	}
 	return &value, nil
 }
+
+// ScalingFrozenForPod returns whether autoscaling is frozen for the virtual machine, as indicated by
+// the helper annotation on the pod.
+func ScalingFrozenForPod(pod *corev1.Pod) bool {
+	return pod.Annotations[VirtualMachineScalingFrozenAnnotation] == "true"
+}
+
+// IsWarmPoolPod returns whether the pod belongs to a warm pool VM that hasn't yet been claimed, as
+// indicated by the WarmPoolNameLabel copied from the VM.
+func IsWarmPoolPod(pod *corev1.Pod) bool {
+	_, ok := pod.Labels[WarmPoolNameLabel]
+	return ok
+}
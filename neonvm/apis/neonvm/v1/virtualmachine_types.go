@@ -25,6 +25,7 @@ import (
 	"go.uber.org/zap/zapcore"
 
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -58,6 +59,55 @@ const (
 	//
 	// The value of this annotation is always a JSON-encoded OvercommitSettings.
 	VirtualMachineOvercommitAnnotation string = "vm.neon.tech/overcommit"
+
+	// VirtualMachineScalingFrozenAnnotation is the annotation added to runner pods of VMs with
+	// .Spec.ScalingFrozen set to true.
+	//
+	// The value of this annotation is always "true".
+	VirtualMachineScalingFrozenAnnotation string = "vm.neon.tech/scaling-frozen"
+
+	// VirtualMachineNeverMigrateLabel is the label that, when set to "true" on a VirtualMachine
+	// (and so also its runner pod), marks that the VM must not be live-migrated. The
+	// "autoscaling.neon.tech/never-migrate" annotation has the same effect.
+	//
+	// Deletion of Nodes that still host such VMs is rejected by the controller's webhook, unless
+	// the Node has NodeAllowUnmigratableVMsDeletionAnnotation.
+	VirtualMachineNeverMigrateLabel string = "vm.neon.tech/never-migrate"
+
+	// NodeAllowUnmigratableVMsDeletionAnnotation is the annotation that, when set to "true" on a
+	// Node, allows deleting the Node even though it still hosts VMs that must not be migrated.
+	//
+	// This exists so that operators can deliberately override the protection.
+	NodeAllowUnmigratableVMsDeletionAnnotation string = "vm.neon.tech/allow-deletion-with-unmigratable-vms"
+
+	// VirtualMachinePrePullNodesAnnotation is the annotation that, when set on a VirtualMachine,
+	// requests that the controller pre-pull the VM's images onto each of the listed nodes.
+	//
+	// The value of this annotation is a comma-separated list of node names. It's typically set to
+	// the candidate nodes for a pending VM or upcoming migration, so that image pulls don't
+	// dominate the startup time. The scheduler plugin sets it to the node it nominates for a
+	// pending VM during preemption, if configured to.
+	VirtualMachinePrePullNodesAnnotation string = "vm.neon.tech/prepull-nodes"
+
+	// VirtualMachinePrePullLabel is the label assigned to each pod created for
+	// VirtualMachinePrePullNodesAnnotation, providing the name of the VirtualMachine the images
+	// are being pulled for.
+	VirtualMachinePrePullLabel string = "vm.neon.tech/prepull-for"
+
+	// VirtualMachineImportanceClassLabel is the label on a VirtualMachine (and so also its runner
+	// pod) that gives the VM's importance class, e.g. "primary" or "replica".
+	//
+	// If the controller is configured to manage eviction protection, runner pods for VMs with an
+	// importance class that may not be voluntarily disrupted are protected by a
+	// PodDisruptionBudget.
+	VirtualMachineImportanceClassLabel string = "vm.neon.tech/importance-class"
+
+	// VirtualMachineMaxRunnerPodAgeAnnotation is the annotation that, when set on a
+	// VirtualMachine, overrides the controller's maximum runner pod age for that VM.
+	//
+	// The value of this annotation is a Go duration (e.g. "720h"). A value of "0" exempts the VM
+	// from runner pod recycling.
+	VirtualMachineMaxRunnerPodAgeAnnotation string = "vm.neon.tech/max-runner-pod-age"
 )
 
 // VirtualMachineUsage provides information about a VM's current usage. This is the type of the
@@ -172,10 +222,79 @@ type VirtualMachineSpec struct {
 	// +optional
 	CpuScalingMode *CpuScalingMode `json:"cpuScalingMode,omitempty"`
 
+	// MachineType selects the kind of QEMU machine the VM is run with. If not set, the standard
+	// machine type for the architecture is used (q35 for amd64, virt for arm64).
+	//
+	// MachineTypeMicroVM has fewer emulated devices, which reduces the per-VM memory overhead and
+	// boot time. It's intended for the smallest VMs, and doesn't support CPU or memory hotplug, live
+	// migration, or hibernation. Refer to MachineTypeMicroVM for more.
+	// +optional
+	MachineType *MachineType `json:"machineType,omitempty"`
+
 	// Enable network monitoring on the VM
 	// +kubebuilder:default:=false
 	// +optional
 	EnableNetworkMonitoring *bool `json:"enableNetworkMonitoring,omitempty"`
+
+	// ScalingFrozen, if true, puts the VM into maintenance mode for autoscaling: the
+	// autoscaler-agent stops making changes to the VM's resources, and the scheduler keeps
+	// accounting for the VM at its current size. Manual changes to the VM's resources are still
+	// applied.
+	//
+	// This is intended for use during incident response or debugging of the guest.
+	// +optional
+	ScalingFrozen bool `json:"scalingFrozen,omitempty"`
+
+	// Hibernation, if set, allows the VM to be hibernated: its memory and disk state is saved to a
+	// volume and its runner pod is removed, so that it can later be resumed from where it left off,
+	// which is much faster than a full reboot.
+	//
+	// Hibernation is only supported with the sysfs CPU scaling mode, and can only be enabled when
+	// the VM is created.
+	// +optional
+	Hibernation *Hibernation `json:"hibernation,omitempty"`
+
+	// NetworkPolicy, if set, restricts the traffic to and from the VM. neonvm-controller maintains
+	// a NetworkPolicy with the same name as the VM that applies these rules to its runner pods.
+	//
+	// Ingress to the runner's own ports (QMP, the runner API, and live migration) is always
+	// allowed. The rules don't apply to traffic on the VM's ExtraNetwork.
+	// +optional
+	NetworkPolicy *VirtualMachineNetworkPolicy `json:"networkPolicy,omitempty"`
+}
+
+// VirtualMachineNetworkPolicy gives the rules for a VM's traffic, with the same meaning as the
+// corresponding fields of a NetworkPolicy.
+type VirtualMachineNetworkPolicy struct {
+	// Ingress gives the traffic allowed into the VM, if "Ingress" is one of the PolicyTypes.
+	// +optional
+	Ingress []networkingv1.NetworkPolicyIngressRule `json:"ingress,omitempty"`
+
+	// Egress gives the traffic allowed out of the VM, if "Egress" is one of the PolicyTypes.
+	// +optional
+	Egress []networkingv1.NetworkPolicyEgressRule `json:"egress,omitempty"`
+
+	// PolicyTypes lists which directions of traffic are restricted. If empty, ingress is always
+	// restricted, and egress is restricted only if there are Egress rules.
+	// +optional
+	PolicyTypes []networkingv1.PolicyType `json:"policyTypes,omitempty"`
+}
+
+type Hibernation struct {
+	// Hibernated, if true, requests that the VM is hibernated. Setting it back to false resumes
+	// the VM.
+	// +optional
+	Hibernated bool `json:"hibernated,omitempty"`
+
+	// StateVolumeClaimName is the name of the PersistentVolumeClaim that the VM's state is saved
+	// to. It must be large enough to hold the VM's memory at its maximum size, plus its writable
+	// disks, and must not be used by anything else.
+	StateVolumeClaimName string `json:"stateVolumeClaimName"`
+}
+
+// HibernationRequested returns whether the VM should be hibernated.
+func (spec *VirtualMachineSpec) HibernationRequested() bool {
+	return spec.Hibernation != nil && spec.Hibernation.Hibernated
 }
 
 type TLSProvisioning struct {
@@ -260,6 +379,28 @@ const (
 	CpuScalingModeSysfs CpuScalingMode = "SysfsScaling"
 )
 
+// +kubebuilder:validation:Enum=Standard;MicroVM
+type MachineType string
+
+const (
+	// MachineTypeStandard is the value of the VirtualMachineSpec.MachineType field that indicates
+	// that the VM should use the standard machine type for its architecture. This is the default.
+	MachineTypeStandard MachineType = "Standard"
+
+	// MachineTypeMicroVM is the value of the VirtualMachineSpec.MachineType field that indicates
+	// that the VM should use QEMU's minimal "microvm" machine type.
+	//
+	// It's only supported for amd64. Because there's no ACPI hotplug or device memory, the VM must
+	// use the sysfs CPU scaling mode and a fixed number of memory slots. VMs with this machine type
+	// cannot be live-migrated or hibernated.
+	MachineTypeMicroVM MachineType = "MicroVM"
+)
+
+// UsesMicroVM returns whether the VM runs with MachineTypeMicroVM.
+func (spec *VirtualMachineSpec) UsesMicroVM() bool {
+	return spec.MachineType != nil && *spec.MachineType == MachineTypeMicroVM
+}
+
 // +kubebuilder:validation:Enum=Always;OnFailure;Never
 type RestartPolicy string
 
@@ -633,6 +774,43 @@ type VirtualMachineStatus struct {
 	// the changes are propagated to the VM.
 	// +optional
 	CurrentRevision *RevisionWithTime `json:"currentRevision,omitempty"`
+
+	// HibernatedAt, if not nil, gives the time that the VM's state was saved when it was
+	// hibernated. It's set until the VM is next successfully resumed from that state.
+	// +optional
+	HibernatedAt *metav1.Time `json:"hibernatedAt,omitempty"`
+
+	// Resources tracks the progress of changes to the VM's resources, from being requested in
+	// .spec.guest to being in use by the guest, so that it's clear where a stuck change stalled.
+	// +optional
+	Resources *VirtualMachineResourcesStatus `json:"resources,omitempty"`
+}
+
+// VirtualMachineResourcesStatus gives the VM's resources at each stage of scaling
+type VirtualMachineResourcesStatus struct {
+	// Requested are the resources requested in .spec.guest, typically by the autoscaler-agent.
+	//
+	// If .spec.targetRevision is set, its time is used as the time of the request.
+	// +optional
+	Requested *ResourcesStage `json:"requested,omitempty"`
+	// Granted are the resources made available to the VM by the controller, through the runner
+	// pod's cgroup, CPU hotplug, and the virtio-mem device's requested size.
+	// +optional
+	Granted *ResourcesStage `json:"granted,omitempty"`
+	// Applied are the resources in use by the guest: the CPUs online in the guest as reported by
+	// neonvm-daemon, and the memory plugged by the guest's virtio-mem driver.
+	// +optional
+	Applied *ResourcesStage `json:"applied,omitempty"`
+}
+
+// ResourcesStage gives the VM's resources at one stage of scaling, and when they last changed
+type ResourcesStage struct {
+	// +optional
+	CPUs *MilliCPU `json:"cpus,omitempty"`
+	// +optional
+	MemorySize *resource.Quantity `json:"memorySize,omitempty"`
+	// LastTransitionTime is when the resources at this stage last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
 }
 
 type VmPhase string
@@ -656,6 +834,10 @@ const (
 	VmMigrating VmPhase = "Migrating"
 	// VmScaling means that devices are plugging/unplugging to/from the VM
 	VmScaling VmPhase = "Scaling"
+	// VmHibernating means that the VM's state is being saved so that its runner pod can be removed
+	VmHibernating VmPhase = "Hibernating"
+	// VmHibernated means that the VM's state has been saved and its runner pod removed
+	VmHibernated VmPhase = "Hibernated"
 )
 
 // IsAlive returns whether the guest in the VM is expected to be running
@@ -699,6 +881,10 @@ func (vm *VirtualMachine) Cleanup() {
 	vm.Status.Node = ""
 	vm.Status.CPUs = nil
 	vm.Status.MemorySize = nil
+	if vm.Status.Resources != nil {
+		vm.Status.Resources.Granted = nil
+		vm.Status.Resources.Applied = nil
+	}
 }
 
 func (vm *VirtualMachine) HasRestarted() bool {
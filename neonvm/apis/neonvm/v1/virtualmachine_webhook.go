@@ -86,6 +86,7 @@ func (r *VirtualMachine) ValidateCreate() (admission.Warnings, error) {
 		"ssh-publickey",
 		"ssh-authorized-keys",
 		"tls",
+		"hibernation-state",
 	}
 	for _, disk := range r.Spec.Disks {
 		if slices.Contains(reservedDiskNames, disk.Name) {
@@ -103,9 +104,93 @@ func (r *VirtualMachine) ValidateCreate() (admission.Warnings, error) {
 		}
 	}
 
+	// validate .spec.hibernation
+	if r.Spec.Hibernation != nil {
+		if r.Spec.Hibernation.StateVolumeClaimName == "" {
+			return nil, errors.New(".spec.hibernation.stateVolumeClaimName must not be empty")
+		}
+		// nb: if .spec.cpuScalingMode isn't set, neonvm-controller checks the default instead.
+		if r.Spec.CpuScalingMode != nil && *r.Spec.CpuScalingMode != CpuScalingModeSysfs {
+			return nil, fmt.Errorf(".spec.hibernation is only supported with .spec.cpuScalingMode %q", CpuScalingModeSysfs)
+		}
+	}
+
+	// validate .spec.machineType
+	if r.Spec.UsesMicroVM() {
+		if err := r.validateMicroVM(); err != nil {
+			return nil, err
+		}
+	}
+
 	return nil, nil
 }
 
+// validateMicroVM checks that the VM doesn't use any features that aren't available with
+// MachineTypeMicroVM.
+func (r *VirtualMachine) validateMicroVM() error {
+	wrap := func(err error) error {
+		return fmt.Errorf(".spec.machineType %q: %w", MachineTypeMicroVM, err)
+	}
+
+	if r.Spec.TargetArchitecture != nil && *r.Spec.TargetArchitecture != CPUArchitectureAMD64 {
+		return wrap(fmt.Errorf("only supported with .spec.targetArchitecture %q", CPUArchitectureAMD64))
+	}
+	// nb: if .spec.cpuScalingMode isn't set, neonvm-controller defaults it to sysfs for microvm.
+	if r.Spec.CpuScalingMode != nil && *r.Spec.CpuScalingMode != CpuScalingModeSysfs {
+		return wrap(fmt.Errorf("CPU hotplug is not supported, .spec.cpuScalingMode must be %q", CpuScalingModeSysfs))
+	}
+	if r.Spec.Guest.MemorySlots.Min != r.Spec.Guest.MemorySlots.Max {
+		return wrap(errors.New("memory hotplug is not supported, .spec.guest.memorySlots.min must equal .spec.guest.memorySlots.max"))
+	}
+	if r.Spec.Hibernation != nil {
+		return wrap(errors.New(".spec.hibernation is not supported"))
+	}
+	return nil
+}
+
+// immutableFields are the fields of a VirtualMachine that cannot be changed once it's been created
+var immutableFields = []struct {
+	fieldName string
+	getter    func(*VirtualMachine) any
+}{
+	{".spec.guest.cpus.min", func(v *VirtualMachine) any { return v.Spec.Guest.CPUs.Min }},
+	{".spec.guest.cpus.max", func(v *VirtualMachine) any { return v.Spec.Guest.CPUs.Max }},
+	{".spec.guest.memorySlots.min", func(v *VirtualMachine) any { return v.Spec.Guest.MemorySlots.Min }},
+	{".spec.guest.memorySlots.max", func(v *VirtualMachine) any { return v.Spec.Guest.MemorySlots.Max }},
+	{".spec.guest.ports", func(v *VirtualMachine) any { return v.Spec.Guest.Ports }},
+	{".spec.guest.rootDisk", func(v *VirtualMachine) any { return v.Spec.Guest.RootDisk }},
+	{".spec.guest.command", func(v *VirtualMachine) any { return v.Spec.Guest.Command }},
+	{".spec.guest.args", func(v *VirtualMachine) any { return v.Spec.Guest.Args }},
+	{".spec.guest.env", func(v *VirtualMachine) any { return v.Spec.Guest.Env }},
+	{".spec.guest.settings", func(v *VirtualMachine) any { return v.Spec.Guest.Settings }},
+	{".spec.disks", func(v *VirtualMachine) any { return v.Spec.Disks }},
+	{".spec.podResources", func(v *VirtualMachine) any { return v.Spec.PodResources }},
+	{".spec.enableAcceleration", func(v *VirtualMachine) any { return v.Spec.EnableAcceleration }},
+	{".spec.enableSSH", func(v *VirtualMachine) any { return v.Spec.EnableSSH }},
+	// nb: we don't check overcommit here, so that it's allowed to be mutable.
+	{".spec.initScript", func(v *VirtualMachine) any { return v.Spec.InitScript }},
+	{".spec.enableNetworkMonitoring", func(v *VirtualMachine) any { return v.Spec.EnableNetworkMonitoring }},
+	{".spec.machineType", func(v *VirtualMachine) any { return v.Spec.MachineType }},
+	// nb: .spec.hibernation.hibernated is mutable, so that the VM can be hibernated and resumed.
+	{".spec.hibernation.stateVolumeClaimName", func(v *VirtualMachine) any {
+		if v.Spec.Hibernation == nil {
+			return ""
+		}
+		return v.Spec.Hibernation.StateVolumeClaimName
+	}},
+}
+
+// ChangedImmutableField returns the name of the first immutable field that differs between the
+// two VirtualMachines, or the empty string if all immutable fields are equal.
+func ChangedImmutableField(a, b *VirtualMachine) string {
+	for _, info := range immutableFields {
+		if !reflect.DeepEqual(info.getter(a), info.getter(b)) {
+			return info.fieldName
+		}
+	}
+	return ""
+}
+
 // ValidateUpdate implements webhook.Validator
 //
 // The controller wraps this logic so it can inject extra control.
@@ -113,33 +198,8 @@ func (r *VirtualMachine) ValidateUpdate(old runtime.Object) (admission.Warnings,
 	// process immutable fields
 	before, _ := old.(*VirtualMachine)
 
-	immutableFields := []struct {
-		fieldName string
-		getter    func(*VirtualMachine) any
-	}{
-		{".spec.guest.cpus.min", func(v *VirtualMachine) any { return v.Spec.Guest.CPUs.Min }},
-		{".spec.guest.cpus.max", func(v *VirtualMachine) any { return v.Spec.Guest.CPUs.Max }},
-		{".spec.guest.memorySlots.min", func(v *VirtualMachine) any { return v.Spec.Guest.MemorySlots.Min }},
-		{".spec.guest.memorySlots.max", func(v *VirtualMachine) any { return v.Spec.Guest.MemorySlots.Max }},
-		{".spec.guest.ports", func(v *VirtualMachine) any { return v.Spec.Guest.Ports }},
-		{".spec.guest.rootDisk", func(v *VirtualMachine) any { return v.Spec.Guest.RootDisk }},
-		{".spec.guest.command", func(v *VirtualMachine) any { return v.Spec.Guest.Command }},
-		{".spec.guest.args", func(v *VirtualMachine) any { return v.Spec.Guest.Args }},
-		{".spec.guest.env", func(v *VirtualMachine) any { return v.Spec.Guest.Env }},
-		{".spec.guest.settings", func(v *VirtualMachine) any { return v.Spec.Guest.Settings }},
-		{".spec.disks", func(v *VirtualMachine) any { return v.Spec.Disks }},
-		{".spec.podResources", func(v *VirtualMachine) any { return v.Spec.PodResources }},
-		{".spec.enableAcceleration", func(v *VirtualMachine) any { return v.Spec.EnableAcceleration }},
-		{".spec.enableSSH", func(v *VirtualMachine) any { return v.Spec.EnableSSH }},
-		// nb: we don't check overcommit here, so that it's allowed to be mutable.
-		{".spec.initScript", func(v *VirtualMachine) any { return v.Spec.InitScript }},
-		{".spec.enableNetworkMonitoring", func(v *VirtualMachine) any { return v.Spec.EnableNetworkMonitoring }},
-	}
-
-	for _, info := range immutableFields {
-		if !reflect.DeepEqual(info.getter(r), info.getter(before)) {
-			return nil, fmt.Errorf("%s is immutable", info.fieldName)
-		}
+	if field := ChangedImmutableField(before, r); field != "" {
+		return nil, fmt.Errorf("%s is immutable", field)
 	}
 
 	fieldsAllowedToChangeFromNilOnly := []struct {
@@ -72,3 +72,100 @@ func TestFieldsAllowedToChangeFromNilOnly(t *testing.T) {
 		}
 	})
 }
+
+func TestHibernationValidation(t *testing.T) {
+	withHibernation := func(claimName string) *VirtualMachine {
+		vm := &VirtualMachine{}
+		vm.Default()
+		vm.Spec.Hibernation = &Hibernation{Hibernated: false, StateVolumeClaimName: claimName}
+		return vm
+	}
+
+	t.Run("should require a state volume claim", func(t *testing.T) {
+		_, err := withHibernation("").ValidateCreate()
+		assert.Error(t, err)
+
+		_, err = withHibernation("vm-state").ValidateCreate()
+		assert.NotError(t, err)
+	})
+
+	t.Run("should require sysfs CPU scaling mode", func(t *testing.T) {
+		vm := withHibernation("vm-state")
+		vm.Spec.CpuScalingMode = lo.ToPtr(CpuScalingModeQMP)
+		_, err := vm.ValidateCreate()
+		assert.Error(t, err)
+
+		vm.Spec.CpuScalingMode = lo.ToPtr(CpuScalingModeSysfs)
+		_, err = vm.ValidateCreate()
+		assert.NotError(t, err)
+	})
+
+	t.Run("should allow hibernating and resuming", func(t *testing.T) {
+		vm := withHibernation("vm-state")
+		vm2 := vm.DeepCopy()
+		vm2.Spec.Hibernation.Hibernated = true
+		_, err := vm2.ValidateUpdate(vm)
+		assert.NotError(t, err)
+		_, err = vm.ValidateUpdate(vm2)
+		assert.NotError(t, err)
+	})
+
+	t.Run("should not allow changing the state volume claim", func(t *testing.T) {
+		vm := withHibernation("vm-state")
+		vm2 := vm.DeepCopy()
+		vm2.Spec.Hibernation.StateVolumeClaimName = "other-vm-state"
+		_, err := vm2.ValidateUpdate(vm)
+		assert.Error(t, err)
+
+		noHibernation := &VirtualMachine{}
+		noHibernation.Default()
+		_, err = vm.ValidateUpdate(noHibernation)
+		assert.Error(t, err)
+	})
+}
+
+func TestMicroVMValidation(t *testing.T) {
+	microVM := func() *VirtualMachine {
+		vm := &VirtualMachine{}
+		vm.Default()
+		vm.Spec.MachineType = lo.ToPtr(MachineTypeMicroVM)
+		vm.Spec.Guest.MemorySlots = MemorySlots{Min: 1, Use: 1, Max: 1}
+		return vm
+	}
+
+	t.Run("should allow fixed size VMs", func(t *testing.T) {
+		_, err := microVM().ValidateCreate()
+		assert.NotError(t, err)
+
+		vm := microVM()
+		vm.Spec.CpuScalingMode = lo.ToPtr(CpuScalingModeSysfs)
+		_, err = vm.ValidateCreate()
+		assert.NotError(t, err)
+	})
+
+	t.Run("should not allow unsupported features", func(t *testing.T) {
+		unsupported := []func(*VirtualMachine){
+			func(vm *VirtualMachine) { vm.Spec.TargetArchitecture = lo.ToPtr(CPUArchitectureARM64) },
+			func(vm *VirtualMachine) { vm.Spec.CpuScalingMode = lo.ToPtr(CpuScalingModeQMP) },
+			func(vm *VirtualMachine) { vm.Spec.Guest.MemorySlots.Max = 4 },
+			func(vm *VirtualMachine) {
+				vm.Spec.Hibernation = &Hibernation{Hibernated: false, StateVolumeClaimName: "vm-state"}
+			},
+		}
+
+		for _, setter := range unsupported {
+			vm := microVM()
+			setter(vm)
+			_, err := vm.ValidateCreate()
+			assert.Error(t, err)
+		}
+	})
+
+	t.Run("should not allow changing the machine type", func(t *testing.T) {
+		vm := microVM()
+		vm2 := vm.DeepCopy()
+		vm2.Spec.MachineType = lo.ToPtr(MachineTypeStandard)
+		_, err := vm2.ValidateUpdate(vm)
+		assert.Error(t, err)
+	})
+}
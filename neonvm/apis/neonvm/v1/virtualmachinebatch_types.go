@@ -0,0 +1,126 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VirtualMachineBatchNameLabel is the label set on each VirtualMachine created by a
+// VirtualMachineBatch, with the name of the batch.
+const VirtualMachineBatchNameLabel = "vm.neon.tech/batch-name"
+
+// VirtualMachineBatchSpec defines the desired state of VirtualMachineBatch
+type VirtualMachineBatchSpec struct {
+	// Names are the names of the VirtualMachines to create. VirtualMachines are created in the
+	// order given here.
+	// +kubebuilder:validation:MinItems=1
+	Names []string `json:"names"`
+
+	// Template is used to create each VirtualMachine in the batch.
+	Template VirtualMachineTemplate `json:"template"`
+
+	// MaxPending is the maximum number of VirtualMachines from the batch that may be starting at
+	// once. Each time one of them starts running, another is created.
+	//
+	// This limits the load on IPAM and the scheduler when creating many VirtualMachines at once.
+	// +kubebuilder:default:=20
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxPending *int32 `json:"maxPending,omitempty"`
+}
+
+// VirtualMachineTemplate describes the VirtualMachines to create from a VirtualMachineBatch
+type VirtualMachineTemplate struct {
+	// +optional
+	Metadata VirtualMachineTemplateMeta `json:"metadata,omitempty"`
+
+	Spec VirtualMachineSpec `json:"spec"`
+}
+
+// VirtualMachineTemplateMeta is the metadata to set on each VirtualMachine created from a
+// VirtualMachineTemplate
+type VirtualMachineTemplateMeta struct {
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// VirtualMachineBatchStatus defines the observed state of VirtualMachineBatch
+type VirtualMachineBatchStatus struct {
+	// Created is the number of VirtualMachines from the batch that have been created.
+	// +optional
+	Created int32 `json:"created,omitempty"`
+	// Pending is the number of created VirtualMachines that are not yet running.
+	// +optional
+	Pending int32 `json:"pending,omitempty"`
+	// Running is the number of created VirtualMachines that are running.
+	// +optional
+	Running int32 `json:"running,omitempty"`
+	// Failed is the number of created VirtualMachines that have failed.
+	// +optional
+	Failed int32 `json:"failed,omitempty"`
+	// Phase is Completed once every VirtualMachine in the batch has been created and is no longer
+	// pending.
+	// +optional
+	Phase VmBatchPhase `json:"phase,omitempty"`
+}
+
+type VmBatchPhase string
+
+const (
+	// VmBatchCreating means that some VirtualMachines in the batch have not yet been created, or
+	// are not yet running.
+	VmBatchCreating VmBatchPhase = "Creating"
+	// VmBatchCompleted means that every VirtualMachine in the batch has been created and is
+	// either running or failed.
+	VmBatchCompleted VmBatchPhase = "Completed"
+)
+
+//+genclient
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:singular=neonvmbatch
+
+// VirtualMachineBatch is the Schema for the virtualmachinebatches API
+// +kubebuilder:printcolumn:name="Created",type=integer,JSONPath=`.status.created`
+// +kubebuilder:printcolumn:name="Pending",type=integer,JSONPath=`.status.pending`
+// +kubebuilder:printcolumn:name="Running",type=integer,JSONPath=`.status.running`
+// +kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type VirtualMachineBatch struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VirtualMachineBatchSpec   `json:"spec,omitempty"`
+	Status VirtualMachineBatchStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VirtualMachineBatchList contains a list of VirtualMachineBatch
+type VirtualMachineBatchList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VirtualMachineBatch `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VirtualMachineBatch{}, &VirtualMachineBatchList{}) //nolint:exhaustruct // just being used to provide the types
+}
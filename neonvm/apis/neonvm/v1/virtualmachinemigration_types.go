@@ -99,8 +99,27 @@ type VirtualMachineMigrationStatus struct {
 	TargetNode string `json:"targetNode,omitempty"`
 	// +optional
 	Info MigrationInfo `json:"info,omitempty"`
+	// FailureReason, if the migration failed before it was started, gives the reason why.
+	// +optional
+	FailureReason MigrationFailureReason `json:"failureReason,omitempty"`
 }
 
+// MigrationFailureReason is the reason that a VirtualMachineMigration failed the checks made
+// before starting to migrate the VM.
+type MigrationFailureReason string
+
+const (
+	// MigrationFailureTargetUnschedulable means that the target runner pod could not be scheduled
+	// onto any node in time (e.g., because no node has enough capacity).
+	MigrationFailureTargetUnschedulable MigrationFailureReason = "TargetUnschedulable"
+	// MigrationFailureTargetUnreachable means that the target runner pod started, but
+	// neonvm-controller could not connect to it.
+	MigrationFailureTargetUnreachable MigrationFailureReason = "TargetUnreachable"
+	// MigrationFailureIncompatibleTarget means that the target runner's QEMU can't receive the VM
+	// from the source runner (e.g., because they have different machine types).
+	MigrationFailureIncompatibleTarget MigrationFailureReason = "IncompatibleTarget"
+)
+
 type MigrationInfo struct {
 	// +optional
 	Status string `json:"status,omitempty"`
@@ -159,6 +178,7 @@ const (
 // +kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.status.targetPodName`
 // +kubebuilder:printcolumn:name="TargetIP",type=string,priority=1,JSONPath=`.status.targetPodIP`
 // +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Reason",type=string,priority=1,JSONPath=`.status.failureReason`
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type VirtualMachineMigration struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -0,0 +1,98 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// WarmPoolNameLabel is the label set on each VirtualMachine in a VirtualMachineWarmPool that
+	// has not yet been claimed, with the name of the pool. Like other labels, it's copied to the
+	// VM's runner pod, so that the scheduler plugin can account for the pool's capacity.
+	WarmPoolNameLabel = "vm.neon.tech/warm-pool"
+
+	// WarmPoolClaimedByLabel is set on a warm pool VirtualMachine once it has been claimed, with
+	// the name of the VirtualMachine that claimed it.
+	WarmPoolClaimedByLabel = "vm.neon.tech/warm-pool-claimed-by"
+
+	// WarmPoolClaimAnnotation may be set on a new VirtualMachine, with the name of a
+	// VirtualMachineWarmPool in the same namespace, to start the VM by taking over one of the
+	// pool's already booted VMs, rather than booting a new one.
+	//
+	// If the pool has no VMs available, or the VM isn't compatible with the pool's template, the
+	// VM is booted as usual.
+	WarmPoolClaimAnnotation = "vm.neon.tech/warm-pool-claim"
+)
+
+// VirtualMachineWarmPoolSpec defines the desired state of VirtualMachineWarmPool
+type VirtualMachineWarmPoolSpec struct {
+	// Replicas is the number of booted, unclaimed VirtualMachines to keep in the pool.
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+
+	// Template is used to create each VirtualMachine in the pool.
+	//
+	// A VirtualMachine can only claim one of the pool's VMs if its spec has the same shape, i.e.
+	// the same values for the fields fixed once the runner pod is created (e.g.
+	// .spec.guest.cpus.min/max, .spec.guest.memorySlots.min/max and non-ConfigMap/Secret
+	// .spec.disks), so there is typically one pool per size class. The claiming VM's command,
+	// args, env, ConfigMap and Secret disks, and TLS certificate are sent to the guest on claim.
+	Template VirtualMachineTemplate `json:"template"`
+}
+
+// VirtualMachineWarmPoolStatus defines the observed state of VirtualMachineWarmPool
+type VirtualMachineWarmPoolStatus struct {
+	// Ready is the number of unclaimed VirtualMachines in the pool that are running, and so can
+	// be claimed.
+	// +optional
+	Ready int32 `json:"ready,omitempty"`
+	// Booting is the number of unclaimed VirtualMachines in the pool that are not yet running.
+	// +optional
+	Booting int32 `json:"booting,omitempty"`
+}
+
+//+genclient
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:singular=neonvmwarmpool
+
+// VirtualMachineWarmPool is the Schema for the virtualmachinewarmpools API
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.spec.replicas`
+// +kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Booting",type=integer,JSONPath=`.status.booting`
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type VirtualMachineWarmPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VirtualMachineWarmPoolSpec   `json:"spec,omitempty"`
+	Status VirtualMachineWarmPoolStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VirtualMachineWarmPoolList contains a list of VirtualMachineWarmPool
+type VirtualMachineWarmPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VirtualMachineWarmPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VirtualMachineWarmPool{}, &VirtualMachineWarmPoolList{}) //nolint:exhaustruct // just being used to provide the types
+}
@@ -22,6 +22,7 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -232,9 +233,28 @@ func (in *GuestSettings) DeepCopy() *GuestSettings {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Hibernation) DeepCopyInto(out *Hibernation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Hibernation.
+func (in *Hibernation) DeepCopy() *Hibernation {
+	if in == nil {
+		return nil
+	}
+	out := new(Hibernation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPAllocation) DeepCopyInto(out *IPAllocation) {
 	*out = *in
+	if in.ReleasedAt != nil {
+		in, out := &in.ReleasedAt, &out.ReleasedAt
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAllocation.
@@ -312,7 +332,7 @@ func (in *IPPoolSpec) DeepCopyInto(out *IPPoolSpec) {
 		in, out := &in.Allocations, &out.Allocations
 		*out = make(map[string]IPAllocation, len(*in))
 		for key, val := range *in {
-			(*out)[key] = val
+			(*out)[key] = *val.DeepCopy()
 		}
 	}
 }
@@ -429,6 +449,32 @@ func (in *Port) DeepCopy() *Port {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourcesStage) DeepCopyInto(out *ResourcesStage) {
+	*out = *in
+	if in.CPUs != nil {
+		in, out := &in.CPUs, &out.CPUs
+		*out = new(MilliCPU)
+		**out = **in
+	}
+	if in.MemorySize != nil {
+		in, out := &in.MemorySize, &out.MemorySize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourcesStage.
+func (in *ResourcesStage) DeepCopy() *ResourcesStage {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourcesStage)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Revision) DeepCopyInto(out *Revision) {
 	*out = *in
@@ -542,6 +588,106 @@ func (in *VirtualMachine) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineBatch) DeepCopyInto(out *VirtualMachineBatch) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineBatch.
+func (in *VirtualMachineBatch) DeepCopy() *VirtualMachineBatch {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineBatch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtualMachineBatch) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineBatchList) DeepCopyInto(out *VirtualMachineBatchList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VirtualMachineBatch, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineBatchList.
+func (in *VirtualMachineBatchList) DeepCopy() *VirtualMachineBatchList {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineBatchList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtualMachineBatchList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineBatchSpec) DeepCopyInto(out *VirtualMachineBatchSpec) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.Template.DeepCopyInto(&out.Template)
+	if in.MaxPending != nil {
+		in, out := &in.MaxPending, &out.MaxPending
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineBatchSpec.
+func (in *VirtualMachineBatchSpec) DeepCopy() *VirtualMachineBatchSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineBatchSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineBatchStatus) DeepCopyInto(out *VirtualMachineBatchStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineBatchStatus.
+func (in *VirtualMachineBatchStatus) DeepCopy() *VirtualMachineBatchStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineBatchStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtualMachineList) DeepCopyInto(out *VirtualMachineList) {
 	*out = *in
@@ -684,6 +830,40 @@ func (in *VirtualMachineMigrationStatus) DeepCopy() *VirtualMachineMigrationStat
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineNetworkPolicy) DeepCopyInto(out *VirtualMachineNetworkPolicy) {
+	*out = *in
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = make([]networkingv1.NetworkPolicyIngressRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Egress != nil {
+		in, out := &in.Egress, &out.Egress
+		*out = make([]networkingv1.NetworkPolicyEgressRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PolicyTypes != nil {
+		in, out := &in.PolicyTypes, &out.PolicyTypes
+		*out = make([]networkingv1.PolicyType, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineNetworkPolicy.
+func (in *VirtualMachineNetworkPolicy) DeepCopy() *VirtualMachineNetworkPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineNetworkPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtualMachineResources) DeepCopyInto(out *VirtualMachineResources) {
 	*out = *in
@@ -702,6 +882,36 @@ func (in *VirtualMachineResources) DeepCopy() *VirtualMachineResources {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineResourcesStatus) DeepCopyInto(out *VirtualMachineResourcesStatus) {
+	*out = *in
+	if in.Requested != nil {
+		in, out := &in.Requested, &out.Requested
+		*out = new(ResourcesStage)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Granted != nil {
+		in, out := &in.Granted, &out.Granted
+		*out = new(ResourcesStage)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Applied != nil {
+		in, out := &in.Applied, &out.Applied
+		*out = new(ResourcesStage)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineResourcesStatus.
+func (in *VirtualMachineResourcesStatus) DeepCopy() *VirtualMachineResourcesStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineResourcesStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtualMachineSpec) DeepCopyInto(out *VirtualMachineSpec) {
 	*out = *in
@@ -800,11 +1010,26 @@ func (in *VirtualMachineSpec) DeepCopyInto(out *VirtualMachineSpec) {
 		*out = new(CpuScalingMode)
 		**out = **in
 	}
+	if in.MachineType != nil {
+		in, out := &in.MachineType, &out.MachineType
+		*out = new(MachineType)
+		**out = **in
+	}
 	if in.EnableNetworkMonitoring != nil {
 		in, out := &in.EnableNetworkMonitoring, &out.EnableNetworkMonitoring
 		*out = new(bool)
 		**out = **in
 	}
+	if in.Hibernation != nil {
+		in, out := &in.Hibernation, &out.Hibernation
+		*out = new(Hibernation)
+		**out = **in
+	}
+	if in.NetworkPolicy != nil {
+		in, out := &in.NetworkPolicy, &out.NetworkPolicy
+		*out = new(VirtualMachineNetworkPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineSpec.
@@ -842,6 +1067,15 @@ func (in *VirtualMachineStatus) DeepCopyInto(out *VirtualMachineStatus) {
 		*out = new(RevisionWithTime)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.HibernatedAt != nil {
+		in, out := &in.HibernatedAt, &out.HibernatedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(VirtualMachineResourcesStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineStatus.
@@ -854,6 +1088,52 @@ func (in *VirtualMachineStatus) DeepCopy() *VirtualMachineStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineTemplate) DeepCopyInto(out *VirtualMachineTemplate) {
+	*out = *in
+	in.Metadata.DeepCopyInto(&out.Metadata)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineTemplate.
+func (in *VirtualMachineTemplate) DeepCopy() *VirtualMachineTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineTemplateMeta) DeepCopyInto(out *VirtualMachineTemplateMeta) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineTemplateMeta.
+func (in *VirtualMachineTemplateMeta) DeepCopy() *VirtualMachineTemplateMeta {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineTemplateMeta)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtualMachineUsage) DeepCopyInto(out *VirtualMachineUsage) {
 	*out = *in
@@ -878,3 +1158,93 @@ func (in *VirtualMachineUsage) DeepCopy() *VirtualMachineUsage {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineWarmPool) DeepCopyInto(out *VirtualMachineWarmPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineWarmPool.
+func (in *VirtualMachineWarmPool) DeepCopy() *VirtualMachineWarmPool {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineWarmPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtualMachineWarmPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineWarmPoolList) DeepCopyInto(out *VirtualMachineWarmPoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VirtualMachineWarmPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineWarmPoolList.
+func (in *VirtualMachineWarmPoolList) DeepCopy() *VirtualMachineWarmPoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineWarmPoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtualMachineWarmPoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineWarmPoolSpec) DeepCopyInto(out *VirtualMachineWarmPoolSpec) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineWarmPoolSpec.
+func (in *VirtualMachineWarmPoolSpec) DeepCopy() *VirtualMachineWarmPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineWarmPoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtualMachineWarmPoolStatus) DeepCopyInto(out *VirtualMachineWarmPoolStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtualMachineWarmPoolStatus.
+func (in *VirtualMachineWarmPoolStatus) DeepCopy() *VirtualMachineWarmPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtualMachineWarmPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
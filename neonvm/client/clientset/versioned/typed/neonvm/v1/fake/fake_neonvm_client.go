@@ -35,10 +35,18 @@ func (c *FakeNeonvmV1) VirtualMachines(namespace string) v1.VirtualMachineInterf
 	return &FakeVirtualMachines{c, namespace}
 }
 
+func (c *FakeNeonvmV1) VirtualMachineBatches(namespace string) v1.VirtualMachineBatchInterface {
+	return &FakeVirtualMachineBatches{c, namespace}
+}
+
 func (c *FakeNeonvmV1) VirtualMachineMigrations(namespace string) v1.VirtualMachineMigrationInterface {
 	return &FakeVirtualMachineMigrations{c, namespace}
 }
 
+func (c *FakeNeonvmV1) VirtualMachineWarmPools(namespace string) v1.VirtualMachineWarmPoolInterface {
+	return &FakeVirtualMachineWarmPools{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeNeonvmV1) RESTClient() rest.Interface {
@@ -21,4 +21,8 @@ type IPPoolExpansion interface{}
 
 type VirtualMachineExpansion interface{}
 
+type VirtualMachineBatchExpansion interface{}
+
 type VirtualMachineMigrationExpansion interface{}
+
+type VirtualMachineWarmPoolExpansion interface{}
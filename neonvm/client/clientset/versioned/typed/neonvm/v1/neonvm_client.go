@@ -29,7 +29,9 @@ type NeonvmV1Interface interface {
 	RESTClient() rest.Interface
 	IPPoolsGetter
 	VirtualMachinesGetter
+	VirtualMachineBatchesGetter
 	VirtualMachineMigrationsGetter
+	VirtualMachineWarmPoolsGetter
 }
 
 // NeonvmV1Client is used to interact with features provided by the neonvm group.
@@ -45,10 +47,18 @@ func (c *NeonvmV1Client) VirtualMachines(namespace string) VirtualMachineInterfa
 	return newVirtualMachines(c, namespace)
 }
 
+func (c *NeonvmV1Client) VirtualMachineBatches(namespace string) VirtualMachineBatchInterface {
+	return newVirtualMachineBatches(c, namespace)
+}
+
 func (c *NeonvmV1Client) VirtualMachineMigrations(namespace string) VirtualMachineMigrationInterface {
 	return newVirtualMachineMigrations(c, namespace)
 }
 
+func (c *NeonvmV1Client) VirtualMachineWarmPools(namespace string) VirtualMachineWarmPoolInterface {
+	return newVirtualMachineWarmPools(c, namespace)
+}
+
 // NewForConfig creates a new NeonvmV1Client for the given config.
 // NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
 // where httpClient was generated with rest.HTTPClientFor(c).
@@ -56,8 +56,12 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Neonvm().V1().IPPools().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("virtualmachines"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Neonvm().V1().VirtualMachines().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("virtualmachinebatches"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Neonvm().V1().VirtualMachineBatches().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("virtualmachinemigrations"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Neonvm().V1().VirtualMachineMigrations().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("virtualmachinewarmpools"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Neonvm().V1().VirtualMachineWarmPools().Informer()}, nil
 
 	}
 
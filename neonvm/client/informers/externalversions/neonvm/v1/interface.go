@@ -27,8 +27,12 @@ type Interface interface {
 	IPPools() IPPoolInformer
 	// VirtualMachines returns a VirtualMachineInformer.
 	VirtualMachines() VirtualMachineInformer
+	// VirtualMachineBatches returns a VirtualMachineBatchInformer.
+	VirtualMachineBatches() VirtualMachineBatchInformer
 	// VirtualMachineMigrations returns a VirtualMachineMigrationInformer.
 	VirtualMachineMigrations() VirtualMachineMigrationInformer
+	// VirtualMachineWarmPools returns a VirtualMachineWarmPoolInformer.
+	VirtualMachineWarmPools() VirtualMachineWarmPoolInformer
 }
 
 type version struct {
@@ -52,7 +56,17 @@ func (v *version) VirtualMachines() VirtualMachineInformer {
 	return &virtualMachineInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
 
+// VirtualMachineBatches returns a VirtualMachineBatchInformer.
+func (v *version) VirtualMachineBatches() VirtualMachineBatchInformer {
+	return &virtualMachineBatchInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // VirtualMachineMigrations returns a VirtualMachineMigrationInformer.
 func (v *version) VirtualMachineMigrations() VirtualMachineMigrationInformer {
 	return &virtualMachineMigrationInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// VirtualMachineWarmPools returns a VirtualMachineWarmPoolInformer.
+func (v *version) VirtualMachineWarmPools() VirtualMachineWarmPoolInformer {
+	return &virtualMachineWarmPoolInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
@@ -33,6 +33,14 @@ type VirtualMachineListerExpansion interface{}
 // VirtualMachineNamespaceLister.
 type VirtualMachineNamespaceListerExpansion interface{}
 
+// VirtualMachineBatchListerExpansion allows custom methods to be added to
+// VirtualMachineBatchLister.
+type VirtualMachineBatchListerExpansion interface{}
+
+// VirtualMachineBatchNamespaceListerExpansion allows custom methods to be added to
+// VirtualMachineBatchNamespaceLister.
+type VirtualMachineBatchNamespaceListerExpansion interface{}
+
 // VirtualMachineMigrationListerExpansion allows custom methods to be added to
 // VirtualMachineMigrationLister.
 type VirtualMachineMigrationListerExpansion interface{}
@@ -40,3 +48,11 @@ type VirtualMachineMigrationListerExpansion interface{}
 // VirtualMachineMigrationNamespaceListerExpansion allows custom methods to be added to
 // VirtualMachineMigrationNamespaceLister.
 type VirtualMachineMigrationNamespaceListerExpansion interface{}
+
+// VirtualMachineWarmPoolListerExpansion allows custom methods to be added to
+// VirtualMachineWarmPoolLister.
+type VirtualMachineWarmPoolListerExpansion interface{}
+
+// VirtualMachineWarmPoolNamespaceListerExpansion allows custom methods to be added to
+// VirtualMachineWarmPoolNamespaceLister.
+type VirtualMachineWarmPoolNamespaceListerExpansion interface{}
@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/tychoish/fun/erc"
 
 	"github.com/neondatabase/autoscaling/pkg/agent/billing"
+	"github.com/neondatabase/autoscaling/pkg/agent/core"
 	"github.com/neondatabase/autoscaling/pkg/agent/scalingevents"
+	"github.com/neondatabase/autoscaling/pkg/agent/scalingstats"
 	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/reporting"
+	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
 type Config struct {
@@ -18,6 +22,9 @@ type Config struct {
 
 	Billing       billing.Config       `json:"billing"`
 	ScalingEvents scalingevents.Config `json:"scalingEvents"`
+	// ScalingStats, if not nil, enables reporting daily per-VM scaling statistics, for product
+	// usage analytics.
+	ScalingStats *scalingstats.Config `json:"scalingStats,omitempty"`
 
 	Scaling   ScalingConfig    `json:"scaling"`
 	Metrics   MetricsConfig    `json:"metrics"`
@@ -25,6 +32,16 @@ type Config struct {
 	Monitor   MonitorConfig    `json:"monitor"`
 	NeonVM    NeonVMConfig     `json:"neonvm"`
 	DumpState *DumpStateConfig `json:"dumpState"`
+
+	// APIHealth, if not nil, enables detecting when the API server is degraded, during which we
+	// defer non-critical writes (like recording VMs' steady-state resources) while still making
+	// the VM patches needed for scaling.
+	APIHealth *util.APIHealthConfig `json:"apiHealth,omitempty"`
+
+	// WatchStaleTimeoutSeconds, if not zero, gives the maximum duration, in seconds, without any
+	// events from one of our watch streams before we assume it has silently stalled, and restart
+	// it with a fresh re-list.
+	WatchStaleTimeoutSeconds uint `json:"watchStaleTimeoutSeconds,omitempty"`
 }
 
 type RateThresholdConfig struct {
@@ -80,16 +97,65 @@ type DumpStateConfig struct {
 type ScalingConfig struct {
 	// ComputeUnit is the desired ratio between CPU and memory that the autoscaler-agent should
 	// uphold when making changes to a VM
+	//
+	// ComputeUnit must be empty if ComputeUnitConfigPath is set.
 	ComputeUnit api.Resources `json:"computeUnit"`
+	// ComputeUnitConfigPath, if not empty, gives the path to the JSON-encoded api.ComputeUnitConfig
+	// that's shared with the scheduler plugin and neonvm-controller, allowing the Compute Unit to
+	// vary by VM family.
+	ComputeUnitConfigPath string `json:"computeUnitConfigPath,omitempty"`
+	// ComputeUnits is the Compute Unit config in use, either read from ComputeUnitConfigPath or
+	// with ComputeUnit as the default and no families. It is set by ReadConfig.
+	ComputeUnits api.ComputeUnitConfig `json:"-"`
 	// DefaultConfig gives the default scaling config, to be used if there is no configuration
 	// supplied with the "autoscaling.neon.tech/config" annotation.
 	DefaultConfig api.ScalingConfig `json:"defaultConfig"`
+	// Priority, if not empty, gives the order in which to make changes in different directions
+	// when they're pending at the same time -- for example, upscaling memory before downscaling
+	// CPU. Intents not included are ordered after those that are.
+	//
+	// If empty, all changes are made at once.
+	Priority []core.ScalingIntentKind `json:"priority,omitempty"`
+	// Webhooks, if not nil, enables consulting VMs' scaling webhooks before scaling them, so that
+	// external services can adjust or veto the changes.
+	Webhooks *ScalingWebhooksConfig `json:"webhooks,omitempty"`
+}
+
+// ScalingWebhooksConfig defines the scaling webhooks that VMs may select with the
+// "autoscaling.neon.tech/scaling-webhook" annotation, and how we make requests to them
+type ScalingWebhooksConfig struct {
+	// URLs gives the URL of each scaling webhook, by name.
+	//
+	// Webhooks are selected by name rather than by URL, so that VMs can't direct requests from the
+	// autoscaler-agent to arbitrary addresses.
+	URLs map[string]string `json:"urls"`
+	// RequestTimeoutSeconds gives the timeout duration, in seconds, for requests to a webhook.
+	RequestTimeoutSeconds uint `json:"requestTimeoutSeconds"`
+	// RetryFailedRequestSeconds gives the duration, in seconds, that we must wait after a failed
+	// request before making another one. Until then, VMs are scaled without consulting the webhook.
+	RetryFailedRequestSeconds uint `json:"retryFailedRequestSeconds"`
+	// ResponseValidSeconds gives the duration, in seconds, that a webhook's response is used for
+	// the same desired resources, before asking again.
+	ResponseValidSeconds uint `json:"responseValidSeconds"`
 }
 
 // MetricsConfig defines a few parameters for metrics requests to the VM
 type MetricsConfig struct {
 	System MetricsSourceConfig `json:"system"`
 	LFC    MetricsSourceConfig `json:"lfc"`
+
+	// Extra gives any additional metrics sources to scrape from each VM (e.g., a postgres
+	// exporter), each with their own scrape interval.
+	Extra []ExtraMetricsSourceConfig `json:"extra,omitempty"`
+	// MergeRules gives, for each metric name, how values for that metric from multiple Extra
+	// sources should be combined. Metrics without an entry take the maximum value.
+	MergeRules map[string]core.MetricsMergeRule `json:"mergeRules,omitempty"`
+	// Targets gives, for each metric name, the value of that metric (after merging values from
+	// the Extra sources) that a single Compute Unit is expected to handle. The VM's goal CU is
+	// then at least the metric's value divided by its target.
+	//
+	// Metrics without an entry are fetched but don't affect scaling.
+	Targets map[string]float64 `json:"targets,omitempty"`
 }
 
 type MetricsSourceConfig struct {
@@ -101,6 +167,32 @@ type MetricsSourceConfig struct {
 	RequestTimeoutSeconds uint `json:"requestTimeoutSeconds"`
 	// SecondsBetweenRequests sets the number of seconds to wait between metrics requests
 	SecondsBetweenRequests uint `json:"secondsBetweenRequests"`
+	// Path is the HTTP path that metrics are served on. If empty, defaults to "/metrics".
+	Path string `json:"path,omitempty"`
+}
+
+// ExtraMetricsSourceConfig defines an additional metrics source to fetch from each VM
+type ExtraMetricsSourceConfig struct {
+	// Name is the unique name of this metrics source, used in logs and the state dump.
+	Name string `json:"name"`
+	MetricsSourceConfig
+	// Metrics gives the names of the metrics to extract from the source. Each must be a gauge,
+	// counter, or untyped metric with exactly one sample.
+	Metrics []string `json:"metrics"`
+	// MaxAgeSeconds gives the duration, in seconds, after which the last values fetched from
+	// this source are no longer used for scaling decisions, if fetching has failed since.
+	//
+	// If zero, defaults to three times SecondsBetweenRequests.
+	MaxAgeSeconds uint `json:"maxAgeSeconds,omitempty"`
+}
+
+// MaxAge returns the duration after which values from this source are stale, accounting for the
+// default if MaxAgeSeconds is not set.
+func (c ExtraMetricsSourceConfig) MaxAge() time.Duration {
+	if c.MaxAgeSeconds != 0 {
+		return time.Second * time.Duration(c.MaxAgeSeconds)
+	}
+	return 3 * time.Second * time.Duration(c.SecondsBetweenRequests)
 }
 
 // SchedulerConfig defines a few parameters for scheduler requests
@@ -122,11 +214,49 @@ type SchedulerConfig struct {
 	// RetryDeniedUpscaleSeconds gives the duration, in seconds, that we must wait before resending
 	// a request for resources that were not approved
 	RetryDeniedUpscaleSeconds uint `json:"retryDeniedUpscaleSeconds"`
+	// PreapprovalHeadroomCU gives the number of Compute Units above each requested target that we
+	// ask the scheduler to preapprove, so that further upscaling within that range can be granted
+	// without a full round-trip through the scheduler's reservation logic.
+	//
+	// If zero, preapproval is disabled.
+	PreapprovalHeadroomCU uint16 `json:"preapprovalHeadroomCU,omitempty"`
 	// RequestPort defines the port to access the scheduler's ✨special✨ API with
 	RequestPort uint16 `json:"requestPort"`
 	// MaxFailedRequestRate defines the maximum rate of failed scheduler requests, above which
 	// a VM is considered stuck.
 	MaxFailedRequestRate RateThresholdConfig `json:"maxFailedRequestRate"`
+
+	// Fallback, if not nil, allows the autoscaler-agent to place new VM pods onto its own node
+	// while there's no scheduler available, based only on its view of the node and with
+	// conservative limits, so that small VMs can still be started during scheduler outages.
+	Fallback *FallbackSchedulingConfig `json:"fallback,omitempty"`
+}
+
+// FallbackSchedulingConfig defines when and how the autoscaler-agent places VM pods onto its node
+// while there's no scheduler available.
+//
+// Once the scheduler is back, it picks up these pods like any others already on the node, and
+// migrates VMs away if the node ended up above its watermark.
+type FallbackSchedulingConfig struct {
+	// UnavailableAfterSeconds gives how long there must be no ready scheduler before we start
+	// placing pods ourselves.
+	UnavailableAfterSeconds uint `json:"unavailableAfterSeconds"`
+	// PendingAfterSeconds gives how long a pod must have been waiting to be scheduled before we
+	// place it, so that we don't race with a scheduler that's only just started.
+	PendingAfterSeconds uint `json:"pendingAfterSeconds"`
+	// IntervalSeconds gives the duration, in seconds, between each check for pending pods. At most
+	// one pod is placed onto the node per interval.
+	IntervalSeconds uint `json:"intervalSeconds"`
+	// MaxPodResources gives the largest resource requests of a pod that we'll place. Larger pods
+	// wait for the scheduler to return.
+	MaxPodResources api.Resources `json:"maxPodResources"`
+	// MaxNodeUtilization gives the maximum fraction of the node's allocatable CPU and memory that
+	// may be requested by its pods after placing a pod. This should be well below the scheduler's
+	// watermark, because we can't see what's being placed onto the node by other means.
+	MaxNodeUtilization float64 `json:"maxNodeUtilization"`
+	// MaxPodsPerOutage gives the maximum number of pods we'll place onto the node each time the
+	// scheduler becomes unavailable.
+	MaxPodsPerOutage uint `json:"maxPodsPerOutage"`
 }
 
 // NeonVMConfig defines a few parameters for NeonVM requests
@@ -140,6 +270,22 @@ type NeonVMConfig struct {
 	// MaxFailedRequestRate defines the maximum rate of failed NeonVM requests, above which
 	// a VM is considered stuck.
 	MaxFailedRequestRate RateThresholdConfig `json:"maxFailedRequestRate"`
+
+	// SteadyStateSeconds, if non-zero, gives the duration, in seconds, that a VM must stay at the
+	// same size before we consider it to be the VM's steady-state size, recorded in the
+	// "autoscaling.neon.tech/steady-state-resources" annotation with the next VM patch.
+	//
+	// neonvm-controller uses the annotation to pick the size of the VM when restarting it.
+	SteadyStateSeconds uint `json:"steadyStateSeconds,omitempty"`
+
+	// NodeMemoryBudget, if non-zero, gives the maximum total memory of all VMs on the node. Any
+	// upscaling that would exceed it is refused (and retried later), even if the scheduler plugin
+	// approved it. Memory requested from NeonVM counts towards the budget as soon as the request
+	// is made, so that concurrent upscaling of different VMs can't exceed it.
+	//
+	// This is a defense-in-depth measure: overcommitting memory on the node may cause QEMU to be
+	// OOM-killed, taking down the VM.
+	NodeMemoryBudget api.Bytes `json:"nodeMemoryBudget,omitempty"`
 }
 
 func ReadConfig(path string) (*Config, error) {
@@ -160,6 +306,19 @@ func ReadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("Invalid config: %w", err)
 	}
 
+	if config.Scaling.ComputeUnitConfigPath != "" {
+		cuConfig, err := api.ReadComputeUnitConfig(config.Scaling.ComputeUnitConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		config.Scaling.ComputeUnits = *cuConfig
+	} else {
+		config.Scaling.ComputeUnits = api.ComputeUnitConfig{
+			Default:  config.Scaling.ComputeUnit,
+			Families: nil,
+		}
+	}
+
 	return &config, nil
 }
 
@@ -218,8 +377,30 @@ func (c *Config) validate() error {
 		erc.Whenf(ec, c.ScalingEvents.Clients.S3.PrefixInBucket == "", emptyTmpl, ".scalingEvents.clients.s3.prefixInBucket")
 	}
 
+	if c.ScalingStats != nil {
+		erc.Whenf(ec, c.ScalingStats.CUMultiplier == 0, zeroTmpl, ".scalingStats.cuMultiplier")
+		erc.Whenf(ec, c.ScalingStats.RegionName == "", emptyTmpl, ".scalingStats.regionName")
+		if c.ScalingStats.Clients.AzureBlob != nil {
+			validateBaseReportingConfig(&c.ScalingStats.Clients.AzureBlob.BaseClientConfig, ".scalingStats.clients.azureBlob")
+			validateAzureBlobReportingConfig(&c.ScalingStats.Clients.AzureBlob.AzureBlobStorageClientConfig, ".scalingStats.clients.azureBlob")
+			erc.Whenf(ec, c.ScalingStats.Clients.AzureBlob.PrefixInContainer == "", emptyTmpl, ".scalingStats.clients.azureBlob.prefixInContainer")
+		}
+		if c.ScalingStats.Clients.S3 != nil {
+			validateBaseReportingConfig(&c.ScalingStats.Clients.S3.BaseClientConfig, ".scalingStats.clients.s3")
+			validateS3ReportingConfig(&c.ScalingStats.Clients.S3.S3ClientConfig, ".scalingStats.clients.s3")
+			erc.Whenf(ec, c.ScalingStats.Clients.S3.PrefixInBucket == "", emptyTmpl, ".scalingStats.clients.s3.prefixInBucket")
+		}
+	}
+
 	erc.Whenf(ec, c.DumpState != nil && c.DumpState.Port == 0, zeroTmpl, ".dumpState.port")
 	erc.Whenf(ec, c.DumpState != nil && c.DumpState.TimeoutSeconds == 0, zeroTmpl, ".dumpState.timeoutSeconds")
+	if c.APIHealth != nil {
+		erc.Whenf(ec, c.APIHealth.WindowSeconds == 0, zeroTmpl, ".apiHealth.windowSeconds")
+		erc.Whenf(ec, c.APIHealth.BadRequestFraction <= 0 || c.APIHealth.BadRequestFraction > 1,
+			"field %q must be > 0 and <= 1", ".apiHealth.badRequestFraction")
+		erc.Whenf(ec, c.APIHealth.SlowRequestMillis == 0, zeroTmpl, ".apiHealth.slowRequestMillis")
+		erc.Whenf(ec, c.APIHealth.RecoverySeconds == 0, zeroTmpl, ".apiHealth.recoverySeconds")
+	}
 
 	validateMetricsConfig := func(cfg MetricsSourceConfig, key string) {
 		erc.Whenf(ec, cfg.Port == 0, zeroTmpl, fmt.Sprintf(".metrics.%s.port", key))
@@ -228,8 +409,47 @@ func (c *Config) validate() error {
 	}
 	validateMetricsConfig(c.Metrics.System, "system")
 	validateMetricsConfig(c.Metrics.LFC, "lfc")
-	erc.Whenf(ec, c.Scaling.ComputeUnit.VCPU == 0, zeroTmpl, ".scaling.computeUnit.vCPUs")
-	erc.Whenf(ec, c.Scaling.ComputeUnit.Mem == 0, zeroTmpl, ".scaling.computeUnit.mem")
+	extraNames := make(map[string]struct{})
+	for i, extra := range c.Metrics.Extra {
+		key := fmt.Sprintf("extra[%d]", i)
+		erc.Whenf(ec, extra.Name == "", emptyTmpl, fmt.Sprintf(".metrics.%s.name", key))
+		erc.Whenf(ec, len(extra.Metrics) == 0, emptyTmpl, fmt.Sprintf(".metrics.%s.metrics", key))
+		if _, ok := extraNames[extra.Name]; ok {
+			ec.Add(fmt.Errorf("field %q has duplicate value %q", fmt.Sprintf(".metrics.%s.name", key), extra.Name))
+		}
+		extraNames[extra.Name] = struct{}{}
+		validateMetricsConfig(extra.MetricsSourceConfig, key)
+	}
+	for metric, rule := range c.Metrics.MergeRules {
+		if err := rule.Validate(); err != nil {
+			ec.Add(fmt.Errorf("field %q: %w", fmt.Sprintf(".metrics.mergeRules[%q]", metric), err))
+		}
+	}
+	for metric, target := range c.Metrics.Targets {
+		erc.Whenf(ec, target <= 0, "field %q must be positive", fmt.Sprintf(".metrics.targets[%q]", metric))
+	}
+	if err := core.ValidateScalingPriority(c.Scaling.Priority); err != nil {
+		ec.Add(fmt.Errorf("field %q: %w", ".scaling.priority", err))
+	}
+	if c.Scaling.Webhooks != nil {
+		erc.Whenf(ec, len(c.Scaling.Webhooks.URLs) == 0, emptyTmpl, ".scaling.webhooks.urls")
+		for name, url := range c.Scaling.Webhooks.URLs {
+			erc.Whenf(ec, url == "", emptyTmpl, fmt.Sprintf(".scaling.webhooks.urls[%q]", name))
+		}
+		erc.Whenf(ec, c.Scaling.Webhooks.RequestTimeoutSeconds == 0, zeroTmpl, ".scaling.webhooks.requestTimeoutSeconds")
+		erc.Whenf(ec, c.Scaling.Webhooks.RetryFailedRequestSeconds == 0, zeroTmpl, ".scaling.webhooks.retryFailedRequestSeconds")
+		erc.Whenf(ec, c.Scaling.Webhooks.ResponseValidSeconds == 0, zeroTmpl, ".scaling.webhooks.responseValidSeconds")
+	}
+	if c.Scaling.ComputeUnitConfigPath == "" {
+		erc.Whenf(ec, c.Scaling.ComputeUnit.VCPU == 0, zeroTmpl, ".scaling.computeUnit.vCPUs")
+		erc.Whenf(ec, c.Scaling.ComputeUnit.Mem == 0, zeroTmpl, ".scaling.computeUnit.mem")
+	} else {
+		erc.Whenf(
+			ec,
+			c.Scaling.ComputeUnit != (api.Resources{VCPU: 0, Mem: 0}),
+			"field %q must be empty if %q is set", ".scaling.computeUnit", ".scaling.computeUnitConfigPath",
+		)
+	}
 	erc.Whenf(ec, c.NeonVM.RequestTimeoutSeconds == 0, zeroTmpl, ".scaling.requestTimeoutSeconds")
 	erc.Whenf(ec, c.NeonVM.RetryFailedRequestSeconds == 0, zeroTmpl, ".scaling.retryFailedRequestSeconds")
 	erc.Whenf(ec, c.NeonVM.MaxFailedRequestRate.IntervalSeconds == 0, zeroTmpl, ".neonvm.maxFailedRequestRate.intervalSeconds")
@@ -253,6 +473,15 @@ func (c *Config) validate() error {
 	erc.Whenf(ec, c.Scheduler.RetryDeniedUpscaleSeconds == 0, zeroTmpl, ".scheduler.retryDeniedUpscaleSeconds")
 	erc.Whenf(ec, c.Scheduler.SchedulerName == "", emptyTmpl, ".scheduler.schedulerName")
 	erc.Whenf(ec, c.Scheduler.MaxFailedRequestRate.IntervalSeconds == 0, zeroTmpl, ".monitor.maxFailedRequestRate.intervalSeconds")
+	if c.Scheduler.Fallback != nil {
+		erc.Whenf(ec, c.Scheduler.Fallback.UnavailableAfterSeconds == 0, zeroTmpl, ".scheduler.fallback.unavailableAfterSeconds")
+		erc.Whenf(ec, c.Scheduler.Fallback.IntervalSeconds == 0, zeroTmpl, ".scheduler.fallback.intervalSeconds")
+		erc.Whenf(ec, c.Scheduler.Fallback.MaxPodResources.VCPU == 0, zeroTmpl, ".scheduler.fallback.maxPodResources.vCPUs")
+		erc.Whenf(ec, c.Scheduler.Fallback.MaxPodResources.Mem == 0, zeroTmpl, ".scheduler.fallback.maxPodResources.mem")
+		erc.Whenf(ec, c.Scheduler.Fallback.MaxNodeUtilization <= 0 || c.Scheduler.Fallback.MaxNodeUtilization > 1,
+			"field %q must be > 0 and <= 1", ".scheduler.fallback.maxNodeUtilization")
+		erc.Whenf(ec, c.Scheduler.Fallback.MaxPodsPerOutage == 0, zeroTmpl, ".scheduler.fallback.maxPodsPerOutage")
+	}
 
 	return ec.Resolve()
 }
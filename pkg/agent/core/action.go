@@ -15,6 +15,7 @@ type ActionSet struct {
 	NeonVMRequest    *ActionNeonVMRequest    `json:"neonvmRequest,omitempty"`
 	MonitorDownscale *ActionMonitorDownscale `json:"monitorDownscale,omitempty"`
 	MonitorUpscale   *ActionMonitorUpscale   `json:"monitorUpscale,omitempty"`
+	ScalingWebhook   *ActionScalingWebhook   `json:"scalingWebhook,omitempty"`
 }
 
 type ActionWait struct {
@@ -26,6 +27,9 @@ type ActionPluginRequest struct {
 	Target         api.Resources         `json:"target"`
 	Metrics        *api.Metrics          `json:"metrics"`
 	TargetRevision vmv1.RevisionWithTime `json:"targetRevision"`
+	// Preapproval, if not nil, gives the resources that we'd like the scheduler plugin to
+	// preapprove, so that future requests within it can be approved immediately.
+	Preapproval *api.Resources `json:"preapproval"`
 }
 
 type ActionNeonVMRequest struct {
@@ -46,6 +50,15 @@ type ActionMonitorUpscale struct {
 	TargetRevision vmv1.RevisionWithTime `json:"targetRevision"`
 }
 
+type ActionScalingWebhook struct {
+	// Webhook is the name of the VM's scaling webhook
+	Webhook string        `json:"webhook"`
+	Current api.Resources `json:"current"`
+	Desired api.Resources `json:"desired"`
+	Min     api.Resources `json:"min"`
+	Max     api.Resources `json:"max"`
+}
+
 func addObjectPtr[T zapcore.ObjectMarshaler](enc zapcore.ObjectEncoder, key string, value *T) error {
 	if value != nil {
 		return enc.AddObject(key, *value)
@@ -61,6 +74,7 @@ func (s ActionSet) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	_ = addObjectPtr(enc, "neonvmRequest", s.NeonVMRequest)
 	_ = addObjectPtr(enc, "monitorDownscale", s.MonitorDownscale)
 	_ = addObjectPtr(enc, "monitorUpscale", s.MonitorUpscale)
+	_ = addObjectPtr(enc, "scalingWebhook", s.ScalingWebhook)
 	return nil
 }
 
@@ -98,3 +112,11 @@ func (a ActionMonitorUpscale) MarshalLogObject(enc zapcore.ObjectEncoder) error
 	_ = enc.AddObject("target", a.Target)
 	return nil
 }
+
+// MarshalLogObject implements zapcore.ObjectMarshaler, so that ActionScalingWebhook can be used with zap.Object
+func (a ActionScalingWebhook) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("webhook", a.Webhook)
+	_ = enc.AddObject("current", a.Current)
+	_ = enc.AddObject("desired", a.Desired)
+	return nil
+}
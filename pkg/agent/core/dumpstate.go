@@ -4,6 +4,7 @@ package core
 
 import (
 	"encoding/json"
+	"maps"
 	"time"
 
 	"github.com/neondatabase/autoscaling/pkg/api"
@@ -39,8 +40,10 @@ func (s *State) Dump() StateDump {
 			Plugin:               s.internal.Plugin.deepCopy(),
 			Monitor:              s.internal.Monitor.deepCopy(),
 			NeonVM:               s.internal.NeonVM.deepCopy(),
+			ScalingWebhook:       s.internal.ScalingWebhook.deepCopy(),
 			Metrics:              shallowCopy[SystemMetrics](s.internal.Metrics),
 			LFCMetrics:           shallowCopy[LFCMetrics](s.internal.LFCMetrics),
+			ExtraMetrics:         maps.Clone(s.internal.ExtraMetrics),
 			TargetRevision:       s.internal.TargetRevision,
 			LastDesiredResources: s.internal.LastDesiredResources,
 		},
@@ -3,7 +3,9 @@ package core
 // extracted components of how "goal CU" is determined
 
 import (
+	"maps"
 	"math"
+	"slices"
 
 	"github.com/samber/lo"
 	"go.uber.org/zap"
@@ -22,6 +24,8 @@ type ScalingGoalParts struct {
 	CPU *float64
 	Mem *float64
 	LFC *float64
+	// Extra is the goal CU from the merged values of any extra metrics that have targets.
+	Extra *float64
 }
 
 func (g *ScalingGoal) GoalCU() uint32 {
@@ -29,6 +33,7 @@ func (g *ScalingGoal) GoalCU() uint32 {
 		math.Round(lo.FromPtr(g.Parts.CPU)), // for historical compatibility, use round() instead of ceil()
 		lo.FromPtr(g.Parts.Mem),
 		lo.FromPtr(g.Parts.LFC),
+		lo.FromPtr(g.Parts.Extra),
 	)))
 }
 
@@ -75,6 +80,33 @@ func calculateGoalCU(
 	return ScalingGoal{HasAllMetrics: hasAllMetrics, Parts: parts}, logFields
 }
 
+// For extra metrics:
+// Goal compute unit is the largest (value) / (target) across all metrics with a target, where the
+// target is the value that a single compute unit can handle.
+//
+// Returns nil if none of the metrics with targets have values.
+func calculateExtraGoalCU(
+	values map[string]float64,
+	targets map[string]float64,
+) (*float64, []zap.Field) {
+	var goalCU *float64
+	var logFields []zap.Field
+	for _, metric := range slices.Sorted(maps.Keys(targets)) {
+		target := targets[metric]
+		value, ok := values[metric]
+		if !ok {
+			continue
+		}
+		metricGoalCU := value / target
+		goalCU = lo.ToPtr(max(lo.FromPtr(goalCU), metricGoalCU))
+		logFields = append(logFields, zap.Float64(metric, metricGoalCU))
+	}
+	if goalCU == nil {
+		return nil, nil
+	}
+	return goalCU, []zap.Field{zap.Dict("extraGoalCU", logFields...)}
+}
+
 // For CPU:
 // Goal compute unit is at the point where (CPUs) × (LoadAverageFractionTarget) == (load average),
 // which we can get by dividing LA by LAFT, and then dividing by the number of CPUs per CU
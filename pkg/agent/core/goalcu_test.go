@@ -24,6 +24,9 @@ func Test_calculateGoalCU(t *testing.T) {
 		LFCMinWaitBeforeDownscaleMinutes: lo.ToPtr(5),
 		CPUStableZoneRatio:               lo.ToPtr(0.0),
 		CPUMixedZoneRatio:                lo.ToPtr(0.0),
+		StartupBoostCU:                   nil,
+		StartupBoostSeconds:              nil,
+		StartupBoostDecaySeconds:         nil,
 	}
 
 	warn := func(msg string) {}
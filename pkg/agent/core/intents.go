@@ -0,0 +1,131 @@
+package core
+
+// Ordering of scaling in different directions, for when a VM's desired resources are above the
+// current resources for one resource, and below for another.
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// ScalingIntentKind is a single kind of change to a VM's resources, for one resource in one
+// direction.
+type ScalingIntentKind string
+
+const (
+	IntentUpscaleMemory   ScalingIntentKind = "upscaleMemory"
+	IntentUpscaleCPU      ScalingIntentKind = "upscaleCPU"
+	IntentDownscaleCPU    ScalingIntentKind = "downscaleCPU"
+	IntentDownscaleMemory ScalingIntentKind = "downscaleMemory"
+)
+
+// defaultIntentOrder is the order used for intents that aren't included in the configured priority.
+//
+// Upscaling comes first because it's urgent, with memory ahead of CPU because running out of memory
+// is worse than running out of CPU. Memory downscaling comes last because it requires the most
+// coordination with the vm-monitor.
+var defaultIntentOrder = []ScalingIntentKind{
+	IntentUpscaleMemory,
+	IntentUpscaleCPU,
+	IntentDownscaleCPU,
+	IntentDownscaleMemory,
+}
+
+// Validate returns an error if the ScalingIntentKind is not one of the known values.
+func (k ScalingIntentKind) Validate() error {
+	if !slices.Contains(defaultIntentOrder, k) {
+		return fmt.Errorf("unknown scaling intent %q", k)
+	}
+	return nil
+}
+
+func (k ScalingIntentKind) isUpscale() bool {
+	return k == IntentUpscaleMemory || k == IntentUpscaleCPU
+}
+
+// apply returns current, with the resource for this intent set to the value from target.
+func (k ScalingIntentKind) apply(current, target api.Resources) api.Resources {
+	switch k {
+	case IntentUpscaleCPU, IntentDownscaleCPU:
+		current.VCPU = target.VCPU
+	case IntentUpscaleMemory, IntentDownscaleMemory:
+		current.Mem = target.Mem
+	default:
+		panic(fmt.Errorf("unknown scaling intent %q", k))
+	}
+	return current
+}
+
+// ValidateScalingPriority returns an error if any of the intents in the priority are unknown or
+// duplicated.
+func ValidateScalingPriority(priority []ScalingIntentKind) error {
+	for i, k := range priority {
+		if err := k.Validate(); err != nil {
+			return err
+		}
+		if slices.Contains(priority[:i], k) {
+			return fmt.Errorf("duplicate scaling intent %q", k)
+		}
+	}
+	return nil
+}
+
+// pendingIntents returns the changes required to get from current to desired, in order of
+// priority.
+//
+// Intents are first ordered by their position in priority, and then by defaultIntentOrder for any
+// that aren't in priority.
+func pendingIntents(current, desired api.Resources, priority []ScalingIntentKind) []ScalingIntentKind {
+	var intents []ScalingIntentKind
+	if desired.Mem > current.Mem {
+		intents = append(intents, IntentUpscaleMemory)
+	} else if desired.Mem < current.Mem {
+		intents = append(intents, IntentDownscaleMemory)
+	}
+	if desired.VCPU > current.VCPU {
+		intents = append(intents, IntentUpscaleCPU)
+	} else if desired.VCPU < current.VCPU {
+		intents = append(intents, IntentDownscaleCPU)
+	}
+
+	rank := func(k ScalingIntentKind) int {
+		if i := slices.Index(priority, k); i != -1 {
+			return i
+		}
+		return len(priority) + slices.Index(defaultIntentOrder, k)
+	}
+	slices.SortFunc(intents, func(x, y ScalingIntentKind) int {
+		return rank(x) - rank(y)
+	})
+
+	return intents
+}
+
+// nextScalingStep returns the resources that should be targeted next, when moving from current to
+// desired with the given priority.
+//
+// The highest priority intent is always included, along with each following intent in the same
+// direction. Changes in the opposite direction are deferred until the earlier ones are complete.
+//
+// If priority is empty, all changes are made at once, and nextScalingStep returns desired.
+func nextScalingStep(current, desired api.Resources, priority []ScalingIntentKind) api.Resources {
+	if len(priority) == 0 {
+		return desired
+	}
+
+	intents := pendingIntents(current, desired, priority)
+	if len(intents) == 0 {
+		return desired
+	}
+
+	step := current
+	for _, k := range intents {
+		if k.isUpscale() != intents[0].isUpscale() {
+			break
+		}
+		step = k.apply(step, desired)
+	}
+	return step
+}
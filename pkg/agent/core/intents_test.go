@@ -0,0 +1,89 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func Test_nextScalingStep(t *testing.T) {
+	gb := api.Bytes(1 << 30 /* 1 Gi */)
+
+	upscaleFirst := []ScalingIntentKind{IntentUpscaleMemory, IntentUpscaleCPU}
+	downscaleCPUFirst := []ScalingIntentKind{IntentDownscaleCPU}
+
+	cases := []struct {
+		name     string
+		priority []ScalingIntentKind
+		current  api.Resources
+		desired  api.Resources
+
+		wantIntents []ScalingIntentKind
+		wantStep    api.Resources
+	}{
+		{
+			name:        "no-priority-combined",
+			priority:    nil,
+			current:     api.Resources{VCPU: 1000, Mem: 4 * gb},
+			desired:     api.Resources{VCPU: 500, Mem: 8 * gb},
+			wantIntents: []ScalingIntentKind{IntentUpscaleMemory, IntentDownscaleCPU},
+			wantStep:    api.Resources{VCPU: 500, Mem: 8 * gb},
+		},
+		{
+			name:        "no-change",
+			priority:    upscaleFirst,
+			current:     api.Resources{VCPU: 1000, Mem: 4 * gb},
+			desired:     api.Resources{VCPU: 1000, Mem: 4 * gb},
+			wantIntents: nil,
+			wantStep:    api.Resources{VCPU: 1000, Mem: 4 * gb},
+		},
+		{
+			name:        "same-direction-together",
+			priority:    upscaleFirst,
+			current:     api.Resources{VCPU: 1000, Mem: 4 * gb},
+			desired:     api.Resources{VCPU: 2000, Mem: 8 * gb},
+			wantIntents: []ScalingIntentKind{IntentUpscaleMemory, IntentUpscaleCPU},
+			wantStep:    api.Resources{VCPU: 2000, Mem: 8 * gb},
+		},
+		{
+			name:        "memory-upscale-before-cpu-downscale",
+			priority:    upscaleFirst,
+			current:     api.Resources{VCPU: 1000, Mem: 4 * gb},
+			desired:     api.Resources{VCPU: 500, Mem: 8 * gb},
+			wantIntents: []ScalingIntentKind{IntentUpscaleMemory, IntentDownscaleCPU},
+			wantStep:    api.Resources{VCPU: 1000, Mem: 8 * gb},
+		},
+		{
+			name:        "cpu-downscale-before-memory-upscale",
+			priority:    downscaleCPUFirst,
+			current:     api.Resources{VCPU: 1000, Mem: 4 * gb},
+			desired:     api.Resources{VCPU: 500, Mem: 8 * gb},
+			wantIntents: []ScalingIntentKind{IntentDownscaleCPU, IntentUpscaleMemory},
+			wantStep:    api.Resources{VCPU: 500, Mem: 4 * gb},
+		},
+		{
+			name:        "unlisted-intents-use-default-order",
+			priority:    []ScalingIntentKind{IntentDownscaleMemory},
+			current:     api.Resources{VCPU: 500, Mem: 8 * gb},
+			desired:     api.Resources{VCPU: 1000, Mem: 4 * gb},
+			wantIntents: []ScalingIntentKind{IntentDownscaleMemory, IntentUpscaleCPU},
+			wantStep:    api.Resources{VCPU: 500, Mem: 4 * gb},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.wantIntents, pendingIntents(c.current, c.desired, c.priority))
+			assert.Equal(t, c.wantStep, nextScalingStep(c.current, c.desired, c.priority))
+		})
+	}
+}
+
+func TestValidateScalingPriority(t *testing.T) {
+	assert.NoError(t, ValidateScalingPriority(nil))
+	assert.NoError(t, ValidateScalingPriority([]ScalingIntentKind{IntentUpscaleMemory, IntentDownscaleCPU}))
+	assert.Error(t, ValidateScalingPriority([]ScalingIntentKind{"sideways"}))
+	assert.Error(t, ValidateScalingPriority([]ScalingIntentKind{IntentUpscaleCPU, IntentUpscaleCPU}))
+}
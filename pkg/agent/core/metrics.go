@@ -12,6 +12,7 @@ import (
 
 	promtypes "github.com/prometheus/client_model/go"
 	promfmt "github.com/prometheus/common/expfmt"
+	"github.com/samber/lo"
 	"github.com/tychoish/fun/erc"
 
 	"github.com/neondatabase/autoscaling/pkg/api"
@@ -28,7 +29,7 @@ func (m SystemMetrics) ToAPI() api.Metrics {
 	return api.Metrics{
 		LoadAverage1Min:  float32(m.LoadAverage1Min),
 		LoadAverage5Min:  nil,
-		MemoryUsageBytes: nil,
+		MemoryUsageBytes: lo.ToPtr(float32(m.MemoryUsageBytes)),
 	}
 }
 
@@ -42,6 +43,96 @@ type LFCMetrics struct {
 	ApproximateworkingSetSizeBuckets []float64
 }
 
+// ExtraMetrics stores the values fetched from one of the additional metrics sources that may be
+// configured for the autoscaler-agent (e.g., a postgres exporter), beyond SystemMetrics and
+// LFCMetrics.
+type ExtraMetrics struct {
+	// Source is the name of the metrics source that these values were fetched from.
+	Source string
+	// Values maps each metric's name to its most recently fetched value.
+	Values map[string]float64
+	// ExpiresAt gives the time after which Values are too old to be used for scaling decisions.
+	//
+	// If zero, the values never expire.
+	ExpiresAt time.Time
+
+	// wanted gives the names of the metrics that should be extracted when parsing.
+	wanted []string
+}
+
+// NewExtraMetrics returns a new *ExtraMetrics that, when used with ParseMetrics, will extract the
+// metrics with the given names.
+func NewExtraMetrics(source string, wanted []string) *ExtraMetrics {
+	return &ExtraMetrics{
+		Source:    source,
+		Values:    nil,
+		ExpiresAt: time.Time{},
+		wanted:    wanted,
+	}
+}
+
+// MetricsMergeRule describes how values for the same metric from multiple ExtraMetrics sources are
+// combined into a single value.
+type MetricsMergeRule string
+
+const (
+	// MetricsMergeMax takes the largest value across all sources. This is the default, because
+	// it's the most conservative for scaling decisions.
+	MetricsMergeMax MetricsMergeRule = "max"
+	// MetricsMergeMin takes the smallest value across all sources.
+	MetricsMergeMin MetricsMergeRule = "min"
+	// MetricsMergeSum adds the values from all sources.
+	MetricsMergeSum MetricsMergeRule = "sum"
+)
+
+// Validate returns an error if the MetricsMergeRule is not one of the known values.
+func (r MetricsMergeRule) Validate() error {
+	switch r {
+	case MetricsMergeMax, MetricsMergeMin, MetricsMergeSum:
+		return nil
+	default:
+		return fmt.Errorf("unknown metrics merge rule %q", r)
+	}
+}
+
+// MergeExtraMetrics combines the values from each source into a single value per metric, using the
+// rules to decide how. Metrics without a rule use MetricsMergeMax.
+func MergeExtraMetrics(sources map[string]ExtraMetrics, rules map[string]MetricsMergeRule) map[string]float64 {
+	// iterate in a consistent order, so that floating-point sums are deterministic.
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	merged := make(map[string]float64)
+	for _, name := range names {
+		for metric, value := range sources[name].Values {
+			existing, ok := merged[metric]
+			if !ok {
+				merged[metric] = value
+				continue
+			}
+
+			rule, ok := rules[metric]
+			if !ok {
+				rule = MetricsMergeMax
+			}
+
+			switch rule {
+			case MetricsMergeMin:
+				merged[metric] = min(existing, value)
+			case MetricsMergeSum:
+				merged[metric] = existing + value
+			default: // MetricsMergeMax
+				merged[metric] = max(existing, value)
+			}
+		}
+	}
+
+	return merged
+}
+
 // FromPrometheus represents metric types that can be parsed from prometheus output.
 type FromPrometheus interface {
 	fromPrometheus(map[string]*promtypes.MetricFamily) error
@@ -73,6 +164,26 @@ func extractFloatGauge(mf *promtypes.MetricFamily) (float64, error) {
 	return mf.Metric[0].GetGauge().GetValue(), nil
 }
 
+// extractFloatSample is like extractFloatGauge, but also accepts counters and untyped metrics, which
+// are common in exporters that we don't control.
+func extractFloatSample(mf *promtypes.MetricFamily) (float64, error) {
+	if len(mf.Metric) != 1 {
+		return 0, fmt.Errorf("expected 1 metric, found %d", len(mf.Metric))
+	}
+
+	m := mf.Metric[0]
+	switch mf.GetType() {
+	case promtypes.MetricType_GAUGE:
+		return m.GetGauge().GetValue(), nil
+	case promtypes.MetricType_COUNTER:
+		return m.GetCounter().GetValue(), nil
+	case promtypes.MetricType_UNTYPED:
+		return m.GetUntyped().GetValue(), nil
+	default:
+		return 0, fmt.Errorf("unsupported metric type %s", mf.GetType())
+	}
+}
+
 // Helper function to return an error for a missing metric
 func missingMetric(name string) error {
 	return fmt.Errorf("missing expected metric %s", name)
@@ -149,6 +260,34 @@ func (m *LFCMetrics) fromPrometheus(mfs map[string]*promtypes.MetricFamily) erro
 	return nil
 }
 
+// fromPrometheus implements FromPrometheus, so ExtraMetrics can be used with ParseMetrics.
+func (m *ExtraMetrics) fromPrometheus(mfs map[string]*promtypes.MetricFamily) error {
+	ec := &erc.Collector{}
+
+	values := make(map[string]float64)
+	for _, name := range m.wanted {
+		mf := mfs[name]
+		if mf == nil {
+			ec.Add(missingMetric(name))
+			continue
+		}
+
+		f, err := extractFloatSample(mf)
+		if err != nil {
+			ec.Add(fmt.Errorf("metric %s: %w", name, err))
+			continue
+		}
+		values[name] = f
+	}
+
+	if err := ec.Resolve(); err != nil {
+		return err
+	}
+
+	m.Values = values
+	return nil
+}
+
 func extractWorkingSetSizeWindows(mfs map[string]*promtypes.MetricFamily) ([]float64, error) {
 	metricName := "lfc_approximate_working_set_size_windows"
 	mf := mfs[metricName]
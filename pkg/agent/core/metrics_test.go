@@ -0,0 +1,55 @@
+package core_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/neondatabase/autoscaling/pkg/agent/core"
+)
+
+func TestParseExtraMetrics(t *testing.T) {
+	content := strings.Join([]string{
+		"# TYPE pg_stat_activity_count gauge",
+		"pg_stat_activity_count 12",
+		"# TYPE pg_xact_commit counter",
+		"pg_xact_commit 3456",
+		"pg_untyped_value 7",
+		"# TYPE pg_ignored gauge",
+		"pg_ignored 1",
+		"",
+	}, "\n")
+
+	metrics := core.NewExtraMetrics("postgres", []string{"pg_stat_activity_count", "pg_xact_commit", "pg_untyped_value"})
+	require.NoError(t, core.ParseMetrics(strings.NewReader(content), metrics))
+	assert.Equal(t, "postgres", metrics.Source)
+	assert.Equal(t, map[string]float64{
+		"pg_stat_activity_count": 12,
+		"pg_xact_commit":         3456,
+		"pg_untyped_value":       7,
+	}, metrics.Values)
+
+	missing := core.NewExtraMetrics("postgres", []string{"pg_does_not_exist"})
+	assert.Error(t, core.ParseMetrics(strings.NewReader(content), missing))
+	assert.Nil(t, missing.Values)
+}
+
+func TestMergeExtraMetrics(t *testing.T) {
+	sources := map[string]core.ExtraMetrics{
+		"a": {Source: "a", Values: map[string]float64{"x": 1, "y": 2, "z": 3, "onlyA": 10}},
+		"b": {Source: "b", Values: map[string]float64{"x": 4, "y": 5, "z": 6}},
+	}
+	rules := map[string]core.MetricsMergeRule{
+		"y": core.MetricsMergeMin,
+		"z": core.MetricsMergeSum,
+	}
+
+	assert.Equal(t, map[string]float64{
+		"x":     4, // default is max
+		"y":     2,
+		"z":     9,
+		"onlyA": 10,
+	}, core.MergeExtraMetrics(sources, rules))
+}
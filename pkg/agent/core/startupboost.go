@@ -0,0 +1,61 @@
+package core
+
+// extracted logic for the temporary boost given to VMs just after they're started
+
+import (
+	"math"
+	"time"
+
+	"github.com/samber/lo"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// calculateStartupBoostCU returns the number of Compute Units above the VM's minimum that it should
+// currently be boosted by, along with the duration until that value next changes.
+//
+// If there's no boost, the returned duration is zero.
+func calculateStartupBoostCU(
+	cfg api.ScalingConfig,
+	coldStartAt *time.Time,
+	now time.Time,
+) (uint32, time.Duration) {
+	boostCU := lo.FromPtr(cfg.StartupBoostCU)
+	if coldStartAt == nil || boostCU <= 0 {
+		return 0, 0
+	}
+
+	holdFor := time.Second * time.Duration(lo.FromPtr(cfg.StartupBoostSeconds))
+	decayFor := time.Second * time.Duration(lo.FromPtr(cfg.StartupBoostDecaySeconds))
+
+	elapsed := now.Sub(*coldStartAt)
+	if elapsed < holdFor {
+		return uint32(boostCU), holdFor - elapsed
+	}
+
+	remaining := holdFor + decayFor - elapsed
+	if remaining <= 0 {
+		return 0, 0
+	}
+
+	// Decay linearly over decayFor, rounding up so that we only reach zero at the very end.
+	//
+	// With n = ceil(boostCU * remaining / decayFor), the value next changes once remaining falls
+	// to (n - 1) * decayFor / boostCU.
+	fraction := float64(remaining) / float64(decayFor)
+	current := uint32(math.Ceil(fraction * float64(boostCU)))
+	nextChangeAt := time.Duration(float64(current-1) / float64(boostCU) * float64(decayFor))
+	return current, remaining - nextChangeAt
+}
+
+// requiredCUForStartupBoost returns the number of Compute Units the VM should have while boosted by
+// boostCU above its minimum.
+func (s *state) requiredCUForStartupBoost(computeUnit api.Resources, boostCU uint32) uint32 {
+	minResources := s.VM.Min()
+
+	// note: ceil(x / M) is the number of whole Compute Units needed to reach x.
+	baseFromCPU := uint32((minResources.VCPU + computeUnit.VCPU - 1) / computeUnit.VCPU)
+	baseFromMem := uint32((minResources.Mem + computeUnit.Mem - 1) / computeUnit.Mem)
+
+	return max(baseFromCPU, baseFromMem) + boostCU
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func Test_calculateStartupBoostCU(t *testing.T) {
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	//nolint:exhaustruct // this is a test
+	cfg := api.ScalingConfig{
+		StartupBoostCU:           lo.ToPtr(4),
+		StartupBoostSeconds:      lo.ToPtr(60),
+		StartupBoostDecaySeconds: lo.ToPtr(40),
+	}
+
+	cases := []struct {
+		name        string
+		cfgUpdater  func(*api.ScalingConfig)
+		coldStartAt *time.Time
+		elapsed     time.Duration
+		wantCU      uint32
+		wantUntil   time.Duration
+	}{
+		{
+			name:        "not-cold-started",
+			cfgUpdater:  nil,
+			coldStartAt: nil,
+			elapsed:     0,
+			wantCU:      0,
+			wantUntil:   0,
+		},
+		{
+			name: "no-boost-configured",
+			cfgUpdater: func(c *api.ScalingConfig) {
+				c.StartupBoostCU = nil
+			},
+			coldStartAt: &startedAt,
+			elapsed:     0,
+			wantCU:      0,
+			wantUntil:   0,
+		},
+		{
+			name:        "full-boost",
+			cfgUpdater:  nil,
+			coldStartAt: &startedAt,
+			elapsed:     15 * time.Second,
+			wantCU:      4,
+			wantUntil:   45 * time.Second,
+		},
+		{
+			name:        "decay-start",
+			cfgUpdater:  nil,
+			coldStartAt: &startedAt,
+			elapsed:     60 * time.Second,
+			wantCU:      4,
+			wantUntil:   10 * time.Second,
+		},
+		{
+			name:        "decay-middle",
+			cfgUpdater:  nil,
+			coldStartAt: &startedAt,
+			elapsed:     75 * time.Second,
+			wantCU:      3,
+			wantUntil:   5 * time.Second,
+		},
+		{
+			name:        "decay-end",
+			cfgUpdater:  nil,
+			coldStartAt: &startedAt,
+			elapsed:     95 * time.Second,
+			wantCU:      1,
+			wantUntil:   5 * time.Second,
+		},
+		{
+			name:        "boost-over",
+			cfgUpdater:  nil,
+			coldStartAt: &startedAt,
+			elapsed:     100 * time.Second,
+			wantCU:      0,
+			wantUntil:   0,
+		},
+		{
+			name: "no-decay",
+			cfgUpdater: func(c *api.ScalingConfig) {
+				c.StartupBoostDecaySeconds = nil
+			},
+			coldStartAt: &startedAt,
+			elapsed:     60 * time.Second,
+			wantCU:      0,
+			wantUntil:   0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfgCopy := cfg
+			if c.cfgUpdater != nil {
+				c.cfgUpdater(&cfgCopy)
+			}
+
+			cu, until := calculateStartupBoostCU(cfgCopy, c.coldStartAt, startedAt.Add(c.elapsed))
+			assert.Equal(t, c.wantCU, cu)
+			assert.Equal(t, c.wantUntil, until)
+		})
+	}
+}
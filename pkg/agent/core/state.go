@@ -23,6 +23,7 @@ package core
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -41,11 +42,15 @@ type ObservabilityCallbacks struct {
 
 	ActualScaling       ReportActualScalingEventCallback
 	HypotheticalScaling ReportHypotheticalScalingEventCallback
+	StartupBoost        ReportStartupBoostCallback
 }
 
 type (
 	ReportActualScalingEventCallback       func(timestamp time.Time, current uint32, target uint32)
 	ReportHypotheticalScalingEventCallback func(timestamp time.Time, current uint32, target uint32, parts ScalingGoalParts)
+	// ReportStartupBoostCallback is called whenever the number of Compute Units that the VM is
+	// boosted by after starting changes, including when the boost ends (with boostCU = 0).
+	ReportStartupBoostCallback func(timestamp time.Time, boostCU uint32)
 )
 
 type RevisionSource interface {
@@ -77,6 +82,20 @@ type Config struct {
 	// that were not fully granted.
 	PluginDeniedRetryWait time.Duration
 
+	// PluginPreapprovalHeadroomCU, if non-zero, gives the number of Compute Units above the target
+	// resources that each request to the scheduler plugin should ask to have preapproved, so that
+	// upscaling within that amount can be approved immediately.
+	//
+	// Preapproval is capped at the VM's maximum resources.
+	PluginPreapprovalHeadroomCU uint16
+
+	// ScalingPriority, if not empty, gives the order in which changes to different resources in
+	// different directions should be made, when they can't all be made at once (e.g. memory upscale
+	// and CPU downscale).
+	//
+	// If empty, all changes are made together.
+	ScalingPriority []ScalingIntentKind
+
 	// MonitorDeniedDownscaleCooldown gives the time we must wait between making duplicate
 	// downscale requests to the vm-monitor where the previous failed.
 	MonitorDeniedDownscaleCooldown time.Duration
@@ -88,6 +107,25 @@ type Config struct {
 	// MonitorRetryWait gives the amount of time to wait to retry after a *failed* request.
 	MonitorRetryWait time.Duration
 
+	// ExtraMetricsMergeRules gives, for each metric name, how values from multiple extra metrics
+	// sources should be combined. Metrics without an entry use MetricsMergeMax.
+	ExtraMetricsMergeRules map[string]MetricsMergeRule
+
+	// ExtraMetricsTargets gives, for each metric name, the merged value of that metric from the
+	// extra metrics sources that a single Compute Unit is expected to handle. Metrics without an
+	// entry don't affect scaling.
+	ExtraMetricsTargets map[string]float64
+
+	// ColdStartAt, if not nil, gives the time at which the VM was started from scratch, from which
+	// any startup boost (see api.ScalingConfig.StartupBoostCU) is measured.
+	//
+	// If nil, the VM is not given a startup boost.
+	ColdStartAt *time.Time
+
+	// ScalingWebhook, if not nil, configures how we consult the VM's scaling webhook, if it has
+	// one (see api.AnnotationScalingWebhook). If nil, scaling webhooks are not used.
+	ScalingWebhook *ScalingWebhookConfig
+
 	// Log provides an outlet for (*State).NextActions() to give informative messages or warnings
 	// about conditions that are impeding its ability to execute.
 	Log LogConfig `json:"-"`
@@ -138,15 +176,26 @@ type state struct {
 	// NeonVM records all state relevant to the NeonVM k8s API
 	NeonVM neonvmState
 
+	// ScalingWebhook records all state relevant to communications with the VM's scaling webhook
+	ScalingWebhook scalingWebhookState
+
 	Metrics *SystemMetrics
 
 	LFCMetrics *LFCMetrics
 
+	// ExtraMetrics stores the most recent metrics from each additional metrics source, keyed by
+	// the name of the source.
+	ExtraMetrics map[string]ExtraMetrics
+
 	// TargetRevision is the revision agent works towards.
 	TargetRevision vmv1.Revision
 
 	// LastDesiredResources is the last target agent wanted to scale to.
 	LastDesiredResources *api.Resources
+
+	// StartupBoostCU is the number of Compute Units above its minimum that the VM is currently
+	// boosted by, as of the last calculation of the desired resources.
+	StartupBoostCU uint32
 }
 
 type pluginState struct {
@@ -163,6 +212,10 @@ type pluginState struct {
 
 	// CurrentRevision is the most recent revision the plugin has acknowledged.
 	CurrentRevision vmv1.Revision
+
+	// NodePressure, if not nil, stores the NodePressure from the most recent PluginResponse,
+	// meaning that the VM's node is under memory pressure and we've been asked to downscale.
+	NodePressure *api.NodePressureResponse
 }
 
 type pluginRequested struct {
@@ -253,6 +306,7 @@ func NewState(vm api.VmInfo, config Config) *State {
 				LastFailureAt:   nil,
 				Permit:          nil,
 				CurrentRevision: vmv1.ZeroRevision,
+				NodePressure:    nil,
 			},
 			Monitor: monitorState{
 				OngoingRequest:     nil,
@@ -270,10 +324,18 @@ func NewState(vm api.VmInfo, config Config) *State {
 				TargetRevision:   vmv1.ZeroRevision.WithTime(time.Time{}),
 				CurrentRevision:  vmv1.ZeroRevision,
 			},
+			ScalingWebhook: scalingWebhookState{
+				Wanted:         nil,
+				OngoingRequest: nil,
+				LastResponse:   nil,
+				LastFailureAt:  nil,
+			},
 			Metrics:              nil,
 			LFCMetrics:           nil,
+			ExtraMetrics:         make(map[string]ExtraMetrics),
 			LastDesiredResources: nil,
 			TargetRevision:       vmv1.ZeroRevision,
+			StartupBoostCU:       0,
 		},
 	}
 }
@@ -343,6 +405,11 @@ func (s *state) nextActions(now time.Time) ActionSet {
 	var monitorDownscaleRequiredWait *time.Duration
 	actions.MonitorDownscale, monitorDownscaleRequiredWait = s.calculateMonitorDownscaleAction(now, desiredResources, plannedUpscale)
 
+	// ----
+	// Requests to the scaling webhook
+	var scalingWebhookRequiredWait *time.Duration
+	actions.ScalingWebhook, scalingWebhookRequiredWait = s.calculateScalingWebhookAction(now)
+
 	// --- and that's all the request types! ---
 
 	// If there's anything waiting, we should also note how long we should wait for.
@@ -356,6 +423,7 @@ func (s *state) nextActions(now time.Time) ActionSet {
 		neonvmRequiredWait,
 		monitorUpscaleRequiredWait,
 		monitorDownscaleRequiredWait,
+		scalingWebhookRequiredWait,
 	}
 	for _, w := range requiredWaits {
 		if w != nil {
@@ -462,6 +530,7 @@ func (s *state) calculatePluginAction(
 				}
 			}(),
 			TargetRevision: s.TargetRevision.WithTime(now),
+			Preapproval:    s.pluginPreapproval(permittedRequestResources),
 		}, nil
 	} else {
 		if wantToRequestNewResources && waitingOnRetryBackoff {
@@ -475,6 +544,17 @@ func (s *state) calculatePluginAction(
 	}
 }
 
+// pluginPreapproval returns the resources that we should ask the scheduler plugin to preapprove,
+// given the target of the request, or nil if preapproval is disabled.
+func (s *state) pluginPreapproval(target api.Resources) *api.Resources {
+	if s.Config.PluginPreapprovalHeadroomCU == 0 {
+		return nil
+	}
+
+	headroom := s.Config.ComputeUnit.Mul(s.Config.PluginPreapprovalHeadroomCU)
+	return ptr(target.Add(headroom).Min(s.VM.Max()).Max(target))
+}
+
 func ptr[T any](t T) *T { return &t }
 
 func (s *state) calculateNeonVMAction(
@@ -753,6 +833,11 @@ func (s *state) desiredResourcesFromMetricsOrRequestedUpscaling(now time.Time) (
 		s.Metrics,
 		s.LFCMetrics,
 	)
+	s.expireExtraMetrics(now)
+	extraMetrics := MergeExtraMetrics(s.ExtraMetrics, s.Config.ExtraMetricsMergeRules)
+	extraGoalCU, extraLogFields := calculateExtraGoalCU(extraMetrics, s.Config.ExtraMetricsTargets)
+	sg.Parts.Extra = extraGoalCU
+	goalCULogFields = append(goalCULogFields, extraLogFields...)
 	goalCU := sg.GoalCU()
 	// If we don't have all the metrics we need, we'll later prevent downscaling to avoid flushing
 	// the VM's cache on autoscaler-agent restart if we have SystemMetrics but not LFCMetrics.
@@ -796,6 +881,19 @@ func (s *state) desiredResourcesFromMetricsOrRequestedUpscaling(now time.Time) (
 		}
 	}
 
+	var startupBoostAffectedResult bool
+
+	// Update goalCU based on any boost given to the VM just after it started
+	startupBoostCU, timeUntilStartupBoostChanged := calculateStartupBoostCU(s.scalingConfig(), s.Config.ColdStartAt, now)
+	s.updateStartupBoost(now, startupBoostCU)
+	if startupBoostCU > 0 {
+		reqCU := s.requiredCUForStartupBoost(s.Config.ComputeUnit, startupBoostCU)
+		if reqCU > initialGoalCU {
+			startupBoostAffectedResult = true
+			goalCU = max(goalCU, reqCU)
+		}
+	}
+
 	// resources for the desired "goal" compute units
 	goalResources := s.Config.ComputeUnit.Mul(uint16(goalCU))
 
@@ -807,9 +905,28 @@ func (s *state) desiredResourcesFromMetricsOrRequestedUpscaling(now time.Time) (
 		goalResources = goalResources.Max(s.VM.Using())
 	}
 
+	// If the node is under memory pressure, downscale a little if we can, rather than risk kubelet
+	// evicting the whole VM. We still respect the VM's minimum (below), and any downscaling denied
+	// by the vm-monitor takes priority.
+	if np := s.Plugin.NodePressure; np != nil {
+		// round down to the largest whole number of Compute Units within the maximum
+		cu := s.Config.ComputeUnit
+		maxCU := min(uint64(np.MaxResources.VCPU/cu.VCPU), uint64(np.MaxResources.Mem/cu.Mem), math.MaxUint16)
+		goalResources = goalResources.Min(cu.Mul(uint16(maxCU)))
+	}
+
 	// bound goalResources by the minimum and maximum resource amounts for the VM
 	result := goalResources.Min(s.VM.Max()).Max(s.VM.Min())
 
+	// Give the VM's scaling webhook (if any) a chance to adjust or veto the change. This happens
+	// before accounting for denied downscaling, so that the webhook can't cause us to downscale
+	// below what the vm-monitor allows.
+	var scalingWebhookAffectedResult bool
+	var timeUntilScalingWebhookResponseExpired time.Duration
+	if !s.VM.Config.ScalingFrozen {
+		result, scalingWebhookAffectedResult, timeUntilScalingWebhookResponseExpired = s.applyScalingWebhook(now, result)
+	}
+
 	// ... but if we aren't allowed to downscale, then we *must* make sure that the VM's usage value
 	// won't decrease to the previously denied amount, even if it's greater than the maximum.
 	//
@@ -846,6 +963,28 @@ func (s *state) desiredResourcesFromMetricsOrRequestedUpscaling(now time.Time) (
 		))
 	}
 
+	// If scaling is frozen for the VM (e.g., during incident response), don't make any changes. The
+	// VM stays at whatever size it's currently at, which may have been set manually.
+	if s.VM.Config.ScalingFrozen {
+		if result != s.VM.Using() {
+			s.info("Not scaling VM because scaling is frozen", zap.Object("desired", result))
+		}
+		result = s.VM.Using()
+	}
+
+	// If we're scaling in different directions for CPU and memory (e.g., upscaling memory while
+	// downscaling CPU), only take the highest priority step for now -- the rest will happen once
+	// that's done.
+	if step := nextScalingStep(s.VM.Using(), result, s.Config.ScalingPriority); step != result {
+		s.info(
+			"Deferring lower priority scaling",
+			zap.Object("desired", result),
+			zap.Object("step", step),
+			zap.Any("pendingIntents", pendingIntents(s.VM.Using(), result, s.Config.ScalingPriority)),
+		)
+		result = step
+	}
+
 	calculateWaitTime := func(actions ActionSet) *time.Duration {
 		var waiting bool
 		waitTime := time.Duration(int64(1<<63 - 1)) // time.Duration is an int64. As an "unset" value, use the maximum.
@@ -858,6 +997,20 @@ func (s *state) desiredResourcesFromMetricsOrRequestedUpscaling(now time.Time) (
 			waitTime = min(waitTime, timeUntilRequestedUpscalingExpired)
 			waiting = true
 		}
+		if startupBoostAffectedResult {
+			waitTime = min(waitTime, timeUntilStartupBoostChanged)
+			waiting = true
+		}
+		if scalingWebhookAffectedResult && timeUntilScalingWebhookResponseExpired > 0 {
+			waitTime = min(waitTime, timeUntilScalingWebhookResponseExpired)
+			waiting = true
+		}
+		// Extra metrics may be keeping the VM from downscaling, so make sure we recalculate once
+		// they expire.
+		if timeUntilExtraMetricsExpired, ok := s.timeUntilExtraMetricsExpired(now); ok && extraGoalCU != nil {
+			waitTime = min(waitTime, timeUntilExtraMetricsExpired)
+			waiting = true
+		}
 
 		if waiting {
 			return &waitTime
@@ -877,11 +1030,54 @@ func (s *state) desiredResourcesFromMetricsOrRequestedUpscaling(now time.Time) (
 		zap.Object("targetRevision", &s.TargetRevision),
 	}
 	logFields = append(logFields, goalCULogFields...)
+	if len(extraMetrics) != 0 {
+		logFields = append(logFields, zap.Any("extraMetrics", extraMetrics))
+	}
 	s.info("Calculated desired resources", logFields...)
 
 	return result, calculateWaitTime
 }
 
+// expireExtraMetrics removes the values from any extra metrics sources that are too old to be used
+// for scaling decisions. Values from a source are only replaced once they've been successfully
+// fetched again, so if fetching fails for long enough, this is what makes sure we don't keep using
+// old values indefinitely.
+func (s *state) expireExtraMetrics(now time.Time) {
+	for source, metrics := range s.ExtraMetrics {
+		if !metrics.ExpiresAt.IsZero() && !now.Before(metrics.ExpiresAt) {
+			s.info("Extra metrics expired", zap.String("source", source), zap.Time("expiredAt", metrics.ExpiresAt))
+			delete(s.ExtraMetrics, source)
+		}
+	}
+}
+
+// timeUntilExtraMetricsExpired returns the time until the values from the next extra metrics
+// source expire, or false if there are none that expire.
+func (s *state) timeUntilExtraMetricsExpired(now time.Time) (time.Duration, bool) {
+	var next *time.Time
+	for _, metrics := range s.ExtraMetrics {
+		if !metrics.ExpiresAt.IsZero() && (next == nil || metrics.ExpiresAt.Before(*next)) {
+			next = &metrics.ExpiresAt
+		}
+	}
+	if next == nil {
+		return 0, false
+	}
+	return next.Sub(now), true
+}
+
+func (s *state) updateStartupBoost(now time.Time, boostCU uint32) {
+	if boostCU == s.StartupBoostCU {
+		return
+	}
+
+	s.info("Startup boost changed", zap.Uint32("old", s.StartupBoostCU), zap.Uint32("new", boostCU))
+	s.StartupBoostCU = boostCU
+	if report := s.Config.ObservabilityCallbacks.StartupBoost; report != nil {
+		report(now, boostCU)
+	}
+}
+
 func (s *state) updateTargetRevision(now time.Time, desired api.Resources, current api.Resources) {
 	if s.LastDesiredResources == nil {
 		s.LastDesiredResources = &current
@@ -1054,6 +1250,11 @@ func (s *State) UpdatedVM(vm api.VmInfo) {
 	// - https://github.com/neondatabase/autoscaling/pull/371#issuecomment-1752110131
 	// - https://github.com/neondatabase/autoscaling/issues/462
 	vm.SetUsing(s.internal.VM.Using())
+	if vm.Config.ScalingWebhook != s.internal.VM.Config.ScalingWebhook {
+		// Responses from the old webhook don't apply to the new one.
+		s.internal.ScalingWebhook.LastResponse = nil
+		s.internal.ScalingWebhook.LastFailureAt = nil
+	}
 	s.internal.VM = vm
 	if vm.CurrentRevision != nil {
 		s.internal.updateNeonVMCurrentRevision(*vm.CurrentRevision)
@@ -1074,6 +1275,21 @@ func (s *State) UpdateLFCMetrics(metrics LFCMetrics) {
 	s.internal.LFCMetrics = &metrics
 }
 
+// UpdateExtraMetrics records the most recent values from one of the extra metrics sources,
+// replacing any previous values from the same source.
+//
+// The merged values from all sources are used in scaling decisions for any metrics that have a
+// target (see Config.ExtraMetricsTargets), until metrics.ExpiresAt.
+func (s *State) UpdateExtraMetrics(metrics ExtraMetrics) {
+	s.internal.ExtraMetrics[metrics.Source] = metrics
+}
+
+// MergedExtraMetrics returns the combined values from all extra metrics sources, using the
+// configured merge rules.
+func (s *State) MergedExtraMetrics() map[string]float64 {
+	return MergeExtraMetrics(s.internal.ExtraMetrics, s.internal.Config.ExtraMetricsMergeRules)
+}
+
 // PluginHandle provides write access to the scheduler plugin pieces of an UpdateState
 type PluginHandle struct {
 	s *state
@@ -1132,6 +1348,10 @@ func (h PluginHandle) RequestSuccessful(
 	// the process of moving the source of truth for ComputeUnit from the scheduler plugin to the
 	// autoscaler-agent.
 	h.s.Plugin.Permit = &resp.Permit
+	if resp.NodePressure != nil && h.s.Plugin.NodePressure == nil {
+		h.s.info("Scheduler plugin asked us to downscale due to node memory pressure", zap.Object("maxResources", resp.NodePressure.MaxResources))
+	}
+	h.s.Plugin.NodePressure = resp.NodePressure
 	revsource.Propagate(now,
 		targetRevision,
 		&h.s.Plugin.CurrentRevision,
@@ -228,6 +228,9 @@ func Test_DesiredResourcesFromMetricsOrRequestedUpscaling(t *testing.T) {
 					AlwaysMigrate:        false,
 					ScalingEnabled:       true,
 					ScalingConfig:        nil,
+					ComputeUnitFamily:    "",
+					ScalingFrozen:        false,
+					ScalingWebhook:       "",
 				},
 				CurrentRevision: nil,
 			}
@@ -246,15 +249,24 @@ func Test_DesiredResourcesFromMetricsOrRequestedUpscaling(t *testing.T) {
 					LFCMinWaitBeforeDownscaleMinutes: lo.ToPtr(5),
 					CPUStableZoneRatio:               lo.ToPtr(0.0),
 					CPUMixedZoneRatio:                lo.ToPtr(0.0),
+					StartupBoostCU:                   nil,
+					StartupBoostSeconds:              nil,
+					StartupBoostDecaySeconds:         nil,
 				},
 				// these don't really matter, because we're not using (*State).NextActions()
 				NeonVMRetryWait:                    time.Second,
 				PluginRequestTick:                  time.Second,
 				PluginRetryWait:                    time.Second,
 				PluginDeniedRetryWait:              time.Second,
+				PluginPreapprovalHeadroomCU:        0,
+				ScalingPriority:                    nil,
 				MonitorDeniedDownscaleCooldown:     time.Second,
 				MonitorRequestedUpscaleValidPeriod: time.Second,
 				MonitorRetryWait:                   time.Second,
+				ExtraMetricsMergeRules:             nil,
+				ExtraMetricsTargets:                nil,
+				ColdStartAt:                        nil,
+				ScalingWebhook:                     nil,
 				Log: core.LogConfig{
 					Info: nil,
 					Warn: func(msg string, fields ...zap.Field) {
@@ -268,6 +280,7 @@ func Test_DesiredResourcesFromMetricsOrRequestedUpscaling(t *testing.T) {
 					NeonVMLatency:       nil,
 					ActualScaling:       nil,
 					HypotheticalScaling: nil,
+					StartupBoost:        nil,
 				},
 			}
 		}
@@ -288,8 +301,9 @@ func Test_DesiredResourcesFromMetricsOrRequestedUpscaling(t *testing.T) {
 			// set lastApproved by simulating a scheduler request/response
 			state.Plugin().StartingRequest(now, c.schedulerApproved)
 			err := state.Plugin().RequestSuccessful(now, vmv1.ZeroRevision.WithTime(now), api.PluginResponse{
-				Permit:  c.schedulerApproved,
-				Migrate: nil,
+				Permit:       c.schedulerApproved,
+				Migrate:      nil,
+				NodePressure: nil,
 			})
 			if err != nil {
 				t.Errorf("state.Plugin().RequestSuccessful() failed: %s", err)
@@ -339,14 +353,23 @@ var DefaultInitialStateConfig = helpers.InitialStateConfig{
 			LFCMinWaitBeforeDownscaleMinutes: lo.ToPtr(15),
 			CPUStableZoneRatio:               lo.ToPtr(0.0),
 			CPUMixedZoneRatio:                lo.ToPtr(0.0),
+			StartupBoostCU:                   nil,
+			StartupBoostSeconds:              nil,
+			StartupBoostDecaySeconds:         nil,
 		},
 		NeonVMRetryWait:                    5 * time.Second,
 		PluginRequestTick:                  5 * time.Second,
 		PluginRetryWait:                    3 * time.Second,
 		PluginDeniedRetryWait:              2 * time.Second,
+		PluginPreapprovalHeadroomCU:        0,
+		ScalingPriority:                    nil,
 		MonitorDeniedDownscaleCooldown:     5 * time.Second,
 		MonitorRequestedUpscaleValidPeriod: 10 * time.Second,
 		MonitorRetryWait:                   3 * time.Second,
+		ExtraMetricsMergeRules:             nil,
+		ExtraMetricsTargets:                nil,
+		ColdStartAt:                        nil,
+		ScalingWebhook:                     nil,
 		Log: core.LogConfig{
 			Info: nil,
 			Warn: nil,
@@ -358,6 +381,7 @@ var DefaultInitialStateConfig = helpers.InitialStateConfig{
 			NeonVMLatency:       nil,
 			ActualScaling:       nil,
 			HypotheticalScaling: nil,
+			StartupBoost:        nil,
 		},
 	},
 }
@@ -387,13 +411,15 @@ func doInitialPluginRequest(
 			Target:         resources,
 			Metrics:        metrics,
 			TargetRevision: rev,
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resources)
 	clock.Inc(requestTime)
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), rev, api.PluginResponse{
-		Permit:  resources,
-		Migrate: nil,
+		Permit:       resources,
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 }
 
@@ -487,6 +513,7 @@ func TestBasicScaleUpAndDownFlow(t *testing.T) {
 			Target:         resForCU(2),
 			Metrics:        lo.ToPtr(lastMetrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	// start the request:
@@ -495,8 +522,9 @@ func TestBasicScaleUpAndDownFlow(t *testing.T) {
 	// should have nothing more to do; waiting on plugin request to come back
 	a.Call(nextActions).Equals(core.ActionSet{})
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(2),
-		Migrate: nil,
+		Permit:       resForCU(2),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 
 	// Scheduler approval is done, now we should be making the request to NeonVM
@@ -629,6 +657,7 @@ func TestBasicScaleUpAndDownFlow(t *testing.T) {
 			Target:         resForCU(1),
 			Metrics:        lo.ToPtr(lastMetrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 		// shouldn't have anything to say to the other components
 	})
@@ -637,8 +666,9 @@ func TestBasicScaleUpAndDownFlow(t *testing.T) {
 	// should have nothing more to do; waiting on plugin request to come back
 	a.Call(nextActions).Equals(core.ActionSet{})
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(1),
-		Migrate: nil,
+		Permit:       resForCU(1),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 
 	// Finally, check there's no leftover actions:
@@ -705,6 +735,7 @@ func TestPeriodicPluginRequest(t *testing.T) {
 					Target:         resources,
 					Metrics:        lo.ToPtr(metrics.ToAPI()),
 					TargetRevision: target,
+					Preapproval:    nil,
 				},
 			})
 			a.Do(state.Plugin().StartingRequest, clock.Now(), resources)
@@ -712,8 +743,9 @@ func TestPeriodicPluginRequest(t *testing.T) {
 			clock.Inc(reqDuration)
 			a.Call(state.NextActions, clock.Now()).Equals(core.ActionSet{})
 			a.NoError(state.Plugin().RequestSuccessful, clock.Now(), target, api.PluginResponse{
-				Permit:  resources,
-				Migrate: nil,
+				Permit:       resources,
+				Migrate:      nil,
+				NodePressure: nil,
 			})
 			clock.Inc(clockTick - reqDuration)
 		}
@@ -781,6 +813,7 @@ func TestPartialUpscaleThenFull(t *testing.T) {
 			Target:         resForCU(4),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: targetRevision,
+			Preapproval:    nil,
 		},
 	})
 
@@ -788,8 +821,9 @@ func TestPartialUpscaleThenFull(t *testing.T) {
 	clockTick()
 	a.Call(nextActions).Equals(core.ActionSet{})
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), targetRevision, api.PluginResponse{
-		Permit:  resForCU(3),
-		Migrate: nil,
+		Permit:       resForCU(3),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 
 	pluginLatencyObserver.assert(duration("0.1s"), revsource.Upscale)
@@ -834,6 +868,7 @@ func TestPartialUpscaleThenFull(t *testing.T) {
 			Target:         resForCU(4),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 
@@ -842,8 +877,9 @@ func TestPartialUpscaleThenFull(t *testing.T) {
 	clockTick()
 	a.Do(state.Monitor().UpscaleRequestSuccessful, clock.Now())
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), targetRevision, api.PluginResponse{
-		Permit:  resForCU(4),
-		Migrate: nil,
+		Permit:       resForCU(4),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 	pluginLatencyObserver.assert(duration("0.1s"), revsource.Upscale)
 	a.Call(nextActions).Equals(core.ActionSet{
@@ -1017,6 +1053,7 @@ func TestDeniedDownscalingIncreaseAndRetry(t *testing.T) {
 			Target:         resForCU(3),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(3))
@@ -1025,8 +1062,9 @@ func TestDeniedDownscalingIncreaseAndRetry(t *testing.T) {
 	})
 	clockTick()
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(3),
-		Migrate: nil,
+		Permit:       resForCU(3),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 	// ... And *now* there's nothing left to do but wait until downscale wait expires:
 	a.Call(nextActions).Equals(core.ActionSet{
@@ -1062,6 +1100,7 @@ func TestDeniedDownscalingIncreaseAndRetry(t *testing.T) {
 			Target:         resForCU(3),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(3))
@@ -1070,8 +1109,9 @@ func TestDeniedDownscalingIncreaseAndRetry(t *testing.T) {
 	})
 	clockTick()
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(3),
-		Migrate: nil,
+		Permit:       resForCU(3),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 	a.Call(nextActions).Equals(core.ActionSet{
 		Wait: &core.ActionWait{Duration: duration("0.9s")}, // yep, still waiting on retrying vm-monitor downscaling
@@ -1132,6 +1172,7 @@ func TestDeniedDownscalingIncreaseAndRetry(t *testing.T) {
 			Target:         resForCU(1),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(1))
@@ -1140,8 +1181,9 @@ func TestDeniedDownscalingIncreaseAndRetry(t *testing.T) {
 	})
 	clockTick()
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(1),
-		Migrate: nil,
+		Permit:       resForCU(1),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 	// And now there's truly nothing left to do. Back to waiting on plugin request tick :)
 	a.Call(nextActions).Equals(core.ActionSet{
@@ -1208,6 +1250,7 @@ func TestRequestedUpscale(t *testing.T) {
 			Target:         resForCU(2),
 			Metrics:        lo.ToPtr(lastMetrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(2))
@@ -1216,8 +1259,9 @@ func TestRequestedUpscale(t *testing.T) {
 		Wait: &core.ActionWait{Duration: duration("5.9s")}, // same waiting for requested upscale expiring
 	})
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(2),
-		Migrate: nil,
+		Permit:       resForCU(2),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 
 	// After approval from the scheduler plugin, now need to make NeonVM request:
@@ -1270,6 +1314,7 @@ func TestRequestedUpscale(t *testing.T) {
 			Target:         resForCU(2),
 			Metrics:        lo.ToPtr(lastMetrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(2))
@@ -1278,8 +1323,9 @@ func TestRequestedUpscale(t *testing.T) {
 		Wait: &core.ActionWait{Duration: duration("0.9s")}, // waiting for requested upscale expiring
 	})
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(2),
-		Migrate: nil,
+		Permit:       resForCU(2),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 
 	// Still should just be waiting on vm-monitor upscale expiring
@@ -1443,6 +1489,7 @@ func TestDownscalePivotBack(t *testing.T) {
 						Target:         resForCU(1),
 						Metrics:        lo.ToPtr(initialMetrics.ToAPI()),
 						TargetRevision: expectedRevision.WithTime(),
+						Preapproval:    nil,
 					},
 				})
 				a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(1))
@@ -1452,8 +1499,9 @@ func TestDownscalePivotBack(t *testing.T) {
 				*pluginWait = duration("4.9s") // reset because we just made a request
 				t.Log(" > finish plugin downscale")
 				a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-					Permit:  resForCU(1),
-					Migrate: nil,
+					Permit:       resForCU(1),
+					Migrate:      nil,
+					NodePressure: nil,
 				})
 			},
 			post: func(pluginWait *time.Duration) {
@@ -1464,6 +1512,7 @@ func TestDownscalePivotBack(t *testing.T) {
 						Target:         resForCU(2),
 						Metrics:        lo.ToPtr(newMetrics.ToAPI()),
 						TargetRevision: expectedRevision.WithTime(),
+						Preapproval:    nil,
 					},
 				})
 				a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(2))
@@ -1471,8 +1520,9 @@ func TestDownscalePivotBack(t *testing.T) {
 				*pluginWait = duration("4.9s") // reset because we just made a request
 				t.Log(" > finish plugin upscale")
 				a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-					Permit:  resForCU(2),
-					Migrate: nil,
+					Permit:       resForCU(2),
+					Migrate:      nil,
+					NodePressure: nil,
 				})
 			},
 		},
@@ -1630,13 +1680,15 @@ func TestBoundsChangeRequiresDownsale(t *testing.T) {
 			Target:         resForCU(1),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(1))
 	clockTick()
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(1),
-		Migrate: nil,
+		Permit:       resForCU(1),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 
 	// Update the VM to set currentCU==1 CU
@@ -1716,13 +1768,15 @@ func TestBoundsChangeRequiresUpscale(t *testing.T) {
 			Target:         resForCU(3),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(3))
 	clockTick()
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(3),
-		Migrate: nil,
+		Permit:       resForCU(3),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 	// Do NeonVM request for the upscaling
 	a.Call(nextActions).Equals(core.ActionSet{
@@ -1801,6 +1855,7 @@ func TestFailedRequestRetry(t *testing.T) {
 			Target:         resForCU(2),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(2))
@@ -1821,13 +1876,15 @@ func TestFailedRequestRetry(t *testing.T) {
 			Target:         resForCU(2),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(2))
 	clockTick()
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(2),
-		Migrate: nil,
+		Permit:       resForCU(2),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 
 	// Now, after plugin request is successful, we should be making a request to NeonVM.
@@ -1913,13 +1970,15 @@ func TestMetricsConcurrentUpdatedDuringDownscale(t *testing.T) {
 				Target:         resForCU(3),
 				Metrics:        nil,
 				TargetRevision: expectedRevision.WithTime(),
+				Preapproval:    nil,
 			},
 		})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(3))
 	clockTick()
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(3),
-		Migrate: nil,
+		Permit:       resForCU(3),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 
 	clockTick()
@@ -2008,6 +2067,7 @@ func TestMetricsConcurrentUpdatedDuringDownscale(t *testing.T) {
 				Target:         resForCU(2),
 				Metrics:        lo.ToPtr(metrics.ToAPI()),
 				TargetRevision: expectedRevision.WithTime(),
+				Preapproval:    nil,
 			},
 			NeonVMRequest: &core.ActionNeonVMRequest{
 				Current:        resForCU(2),
@@ -2021,8 +2081,9 @@ func TestMetricsConcurrentUpdatedDuringDownscale(t *testing.T) {
 	clockTick()
 
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(2),
-		Migrate: nil,
+		Permit:       resForCU(2),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 	// Still waiting for NeonVM request to complete
 	a.Call(nextActions).Equals(core.ActionSet{
@@ -2040,6 +2101,7 @@ func TestMetricsConcurrentUpdatedDuringDownscale(t *testing.T) {
 			Target:         resForCU(1),
 			Metrics:        lo.ToPtr(metrics.ToAPI()),
 			TargetRevision: expectedRevision.WithTime(),
+			Preapproval:    nil,
 		},
 	})
 	a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(1))
@@ -2047,11 +2109,197 @@ func TestMetricsConcurrentUpdatedDuringDownscale(t *testing.T) {
 	clockTick()
 
 	a.NoError(state.Plugin().RequestSuccessful, clock.Now(), expectedRevision.WithTime(), api.PluginResponse{
-		Permit:  resForCU(1),
-		Migrate: nil,
+		Permit:       resForCU(1),
+		Migrate:      nil,
+		NodePressure: nil,
 	})
 	// Nothing left to do
 	a.Call(nextActions).Equals(core.ActionSet{
 		Wait: &core.ActionWait{Duration: duration("4.9s")}, // plugin request tick wait
 	})
 }
+
+// Checks that when the scheduler plugin tells us the node is under memory pressure, the desired
+// resources are capped (but not below the VM's minimum), and that the cap is removed once the
+// plugin stops sending it.
+func TestNodePressureCapsDesiredResources(t *testing.T) {
+	a := helpers.NewAssert(t)
+	clock := helpers.NewFakeClock(t)
+	resForCU := DefaultComputeUnit.Mul
+
+	state := helpers.CreateInitialState(
+		DefaultInitialStateConfig,
+		helpers.WithStoredWarnings(a.StoredWarnings()),
+		helpers.WithTestingLogfWarnings(t),
+	)
+
+	state.Monitor().Active(true)
+
+	doInitialPluginRequest(a, state, clock, duration("0.1s"), nil, resForCU(1))
+
+	clock.Inc(duration("0.1s"))
+	a.Do(state.UpdateSystemMetrics, core.SystemMetrics{
+		LoadAverage1Min:   0.3,
+		LoadAverage5Min:   0.0,
+		MemoryUsageBytes:  0.0,
+		MemoryCachedBytes: 0.0,
+	})
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(2))
+
+	doPluginResponse := func(nodePressure *api.NodePressureResponse) {
+		a.Do(state.Plugin().StartingRequest, clock.Now(), resForCU(1))
+		clock.Inc(duration("0.1s"))
+		a.NoError(state.Plugin().RequestSuccessful, clock.Now(), vmv1.ZeroRevision.WithTime(clock.Now()), api.PluginResponse{
+			Permit:       resForCU(1),
+			Migrate:      nil,
+			NodePressure: nodePressure,
+		})
+	}
+
+	// Partial Compute Units are rounded down, but we still respect the VM's minimum of 1 CU.
+	doPluginResponse(&api.NodePressureResponse{
+		MaxResources: resForCU(1).Add(api.Resources{VCPU: 1, Mem: 1}),
+	})
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(1))
+
+	doPluginResponse(&api.NodePressureResponse{MaxResources: resForCU(0)})
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(1))
+
+	// Once the pressure is gone, we go back to the usual desired resources.
+	doPluginResponse(nil)
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(2))
+}
+
+// Checks that merged values from extra metrics sources are used for the desired resources, and that
+// they stop being used once they expire.
+func TestExtraMetricsAffectDesiredResources(t *testing.T) {
+	a := helpers.NewAssert(t)
+	clock := helpers.NewFakeClock(t)
+	resForCU := DefaultComputeUnit.Mul
+
+	state := helpers.CreateInitialState(
+		DefaultInitialStateConfig,
+		helpers.WithStoredWarnings(a.StoredWarnings()),
+		helpers.WithTestingLogfWarnings(t),
+		helpers.WithConfigSetting(func(c *core.Config) {
+			c.ExtraMetricsTargets = map[string]float64{"pg_connections": 10}
+		}),
+	)
+
+	state.Monitor().Active(true)
+
+	doInitialPluginRequest(a, state, clock, duration("0.1s"), nil, resForCU(1))
+
+	clock.Inc(duration("0.1s"))
+	a.Do(state.UpdateSystemMetrics, core.SystemMetrics{
+		LoadAverage1Min:   0.3,
+		LoadAverage5Min:   0.0,
+		MemoryUsageBytes:  0.0,
+		MemoryCachedBytes: 0.0,
+	})
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(2))
+
+	// Values are merged across sources (by default, taking the maximum), and only metrics with a
+	// target are used.
+	a.Do(state.UpdateExtraMetrics, core.ExtraMetrics{
+		Source:    "pgbouncer",
+		Values:    map[string]float64{"pg_connections": 15, "pg_other": 1000},
+		ExpiresAt: clock.Now().Add(duration("10s")),
+	})
+	a.Do(state.UpdateExtraMetrics, core.ExtraMetrics{
+		Source:    "postgres",
+		Values:    map[string]float64{"pg_connections": 25},
+		ExpiresAt: clock.Now().Add(duration("5s")),
+	})
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(3))
+
+	// Once the values from one source expire, the others are still used.
+	clock.Inc(duration("5s"))
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(2))
+	a.Do(state.UpdateExtraMetrics, core.ExtraMetrics{
+		Source:    "pgbouncer",
+		Values:    map[string]float64{"pg_connections": 35},
+		ExpiresAt: clock.Now().Add(duration("10s")),
+	})
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(4))
+
+	// ... and once they've all expired, we go back to the usual desired resources.
+	clock.Inc(duration("10s"))
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(2))
+	a.Call(state.MergedExtraMetrics).Equals(map[string]float64{})
+}
+
+// Checks that a VM's scaling webhook is consulted before changing its resources, that its
+// adjustments are kept within the VM's bounds, and that we scale without it if requests fail.
+func TestScalingWebhook(t *testing.T) {
+	a := helpers.NewAssert(t)
+	clock := helpers.NewFakeClock(t)
+	resForCU := DefaultComputeUnit.Mul
+
+	state := helpers.CreateInitialState(
+		DefaultInitialStateConfig,
+		helpers.WithStoredWarnings(a.StoredWarnings()),
+		helpers.WithTestingLogfWarnings(t),
+		helpers.WithScalingWebhook("plan-limits"),
+		helpers.WithConfigSetting(func(c *core.Config) {
+			c.ScalingWebhook = &core.ScalingWebhookConfig{
+				ResponseValidPeriod: duration("10s"),
+				RetryWait:           duration("5s"),
+			}
+		}),
+	)
+
+	state.Monitor().Active(true)
+
+	doInitialPluginRequest(a, state, clock, duration("0.1s"), nil, resForCU(1))
+
+	clock.Inc(duration("0.1s"))
+	a.Do(state.UpdateSystemMetrics, core.SystemMetrics{
+		LoadAverage1Min:   0.3,
+		LoadAverage5Min:   0.0,
+		MemoryUsageBytes:  0.0,
+		MemoryCachedBytes: 0.0,
+	})
+
+	webhookAction := func() *core.ActionScalingWebhook {
+		return state.NextActions(clock.Now()).ScalingWebhook
+	}
+	doWebhookRequest := func(resp api.ScalingWebhookResponse) {
+		a.Call(webhookAction).Equals(&core.ActionScalingWebhook{
+			Webhook: "plan-limits",
+			Current: resForCU(1),
+			Desired: resForCU(2),
+			Min:     resForCU(1),
+			Max:     resForCU(4),
+		})
+		a.Do(state.ScalingWebhook().StartingRequest, clock.Now(), resForCU(2))
+		// No more requests while one is ongoing
+		a.Call(webhookAction).Equals((*core.ActionScalingWebhook)(nil))
+		clock.Inc(duration("0.1s"))
+		a.Do(state.ScalingWebhook().RequestSuccessful, clock.Now(), resp)
+	}
+
+	// Until the webhook responds, we stay where we are.
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(1))
+
+	// The webhook vetoes the upscaling
+	doWebhookRequest(api.ScalingWebhookResponse{Veto: true, Resources: nil})
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(1))
+	a.Call(webhookAction).Equals((*core.ActionScalingWebhook)(nil))
+
+	// ... until its response is no longer valid, and we ask again. This time, it adjusts the
+	// resources, but they're capped to the VM's maximum.
+	clock.Inc(duration("10s"))
+	doWebhookRequest(api.ScalingWebhookResponse{Veto: false, Resources: lo.ToPtr(resForCU(5))})
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(4))
+
+	// If the webhook fails, we scale without it until it's time to retry.
+	clock.Inc(duration("10s"))
+	a.Do(state.ScalingWebhook().StartingRequest, clock.Now(), resForCU(2))
+	clock.Inc(duration("0.1s"))
+	a.Do(state.ScalingWebhook().RequestFailed, clock.Now())
+	a.WithWarnings("Scaling without consulting scaling webhook because of previous request failure").
+		Call(getDesiredResources, state, clock.Now()).Equals(resForCU(2))
+	clock.Inc(duration("5s"))
+	a.Call(getDesiredResources, state, clock.Now()).Equals(resForCU(1))
+}
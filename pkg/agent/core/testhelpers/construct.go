@@ -85,6 +85,9 @@ func CreateVmInfo(config InitialVmInfoConfig, opts ...VmInfoOpt) api.VmInfo {
 			AlwaysMigrate:        false,
 			ScalingConfig:        nil,
 			ScalingEnabled:       true,
+			ComputeUnitFamily:    "",
+			ScalingFrozen:        false,
+			ScalingWebhook:       "",
 		},
 		CurrentRevision: nil,
 	}
@@ -167,3 +170,9 @@ func WithCurrentRevision(rev vmv1.RevisionWithTime) VmInfoOpt {
 		vm.CurrentRevision = &rev
 	})
 }
+
+func WithScalingWebhook(name string) VmInfoOpt {
+	return vmInfoModifier(func(c InitialVmInfoConfig, vm *api.VmInfo) {
+		vm.Config.ScalingWebhook = name
+	})
+}
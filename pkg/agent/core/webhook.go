@@ -0,0 +1,156 @@
+package core
+
+// extracted logic for consulting a VM's scaling webhook, which may adjust or veto our desired
+// resources before we act on them.
+//
+// The webhook is only asked about changes: if the desired resources are equal to the VM's current
+// resources, there's nothing to ask about. While waiting for a response, the VM stays at its
+// current resources. If requests to the webhook fail, we scale as if it wasn't there until the
+// next attempt, so that a broken webhook can't prevent the VM from scaling.
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// ScalingWebhookConfig configures how we use a VM's scaling webhook, if it has one
+type ScalingWebhookConfig struct {
+	// ResponseValidPeriod gives the duration for which a response from the webhook is used for the
+	// same desired resources, before asking again.
+	ResponseValidPeriod time.Duration
+
+	// RetryWait gives the amount of time to wait to retry after a failed request. Until then, the
+	// desired resources are used without consulting the webhook.
+	RetryWait time.Duration
+}
+
+type scalingWebhookState struct {
+	// Wanted, if not nil, gives the desired resources that we need a response from the webhook
+	// for, as of the most recent calculation of the desired resources.
+	Wanted *api.Resources
+	// OngoingRequest, if not nil, gives the desired resources sent in the ongoing request to the
+	// webhook.
+	OngoingRequest *api.Resources
+	// LastResponse, if not nil, gives the most recent successful response from the webhook.
+	LastResponse *scalingWebhookResponse
+	// LastFailureAt, if not nil, gives the time of the most recent request failure.
+	LastFailureAt *time.Time
+}
+
+type scalingWebhookResponse struct {
+	At       time.Time
+	Desired  api.Resources
+	Response api.ScalingWebhookResponse
+}
+
+func (s *scalingWebhookState) deepCopy() scalingWebhookState {
+	return scalingWebhookState{
+		Wanted:         shallowCopy[api.Resources](s.Wanted),
+		OngoingRequest: shallowCopy[api.Resources](s.OngoingRequest),
+		LastResponse:   shallowCopy[scalingWebhookResponse](s.LastResponse),
+		LastFailureAt:  shallowCopy[time.Time](s.LastFailureAt),
+	}
+}
+
+// applyScalingWebhook returns the resources we should scale to instead of desired, according to
+// the VM's scaling webhook, along with whether the webhook affected the result and the duration
+// until its response is no longer valid.
+//
+// If the VM doesn't have a scaling webhook, desired is returned unchanged.
+func (s *state) applyScalingWebhook(now time.Time, desired api.Resources) (_ api.Resources, affected bool, _ time.Duration) {
+	s.ScalingWebhook.Wanted = nil
+
+	if s.Config.ScalingWebhook == nil || s.VM.Config.ScalingWebhook == "" || desired == s.VM.Using() {
+		return desired, false, 0
+	}
+
+	// Whatever the webhook says, we still need to stay within the VM's bounds.
+	current := s.VM.Using().Min(s.VM.Max()).Max(s.VM.Min())
+
+	if last := s.ScalingWebhook.LastResponse; last != nil && last.Desired == desired {
+		validUntil := last.At.Add(s.Config.ScalingWebhook.ResponseValidPeriod)
+		if now.Before(validUntil) {
+			result := desired
+			if last.Response.Veto {
+				result = current
+			} else if last.Response.Resources != nil {
+				result = last.Response.Resources.Min(s.VM.Max()).Max(s.VM.Min())
+			}
+			if result != desired {
+				s.info(
+					"Scaling webhook adjusted desired resources",
+					zap.String("webhook", s.VM.Config.ScalingWebhook),
+					zap.Object("desired", desired),
+					zap.Object("adjusted", result),
+				)
+			}
+			return result, result != desired, validUntil.Sub(now)
+		}
+	}
+
+	s.ScalingWebhook.Wanted = &desired
+
+	if failedAt := s.ScalingWebhook.LastFailureAt; failedAt != nil && now.Before(failedAt.Add(s.Config.ScalingWebhook.RetryWait)) {
+		s.warn("Scaling without consulting scaling webhook because of previous request failure")
+		return desired, false, 0
+	}
+
+	// Don't change anything until we hear back from the webhook.
+	return current, current != desired, 0
+}
+
+func (s *state) calculateScalingWebhookAction(now time.Time) (*ActionScalingWebhook, *time.Duration) {
+	if s.ScalingWebhook.Wanted == nil || s.ScalingWebhook.OngoingRequest != nil {
+		return nil, nil
+	}
+
+	if failedAt := s.ScalingWebhook.LastFailureAt; failedAt != nil {
+		timeUntilRetry := failedAt.Add(s.Config.ScalingWebhook.RetryWait).Sub(now)
+		if timeUntilRetry > 0 {
+			return nil, &timeUntilRetry
+		}
+	}
+
+	return &ActionScalingWebhook{
+		Webhook: s.VM.Config.ScalingWebhook,
+		Current: s.VM.Using(),
+		Desired: *s.ScalingWebhook.Wanted,
+		Min:     s.VM.Min(),
+		Max:     s.VM.Max(),
+	}, nil
+}
+
+// ScalingWebhookHandle provides write access to the scaling webhook pieces of an UpdateState
+type ScalingWebhookHandle struct {
+	s *state
+}
+
+func (s *State) ScalingWebhook() ScalingWebhookHandle {
+	return ScalingWebhookHandle{&s.internal}
+}
+
+func (h ScalingWebhookHandle) StartingRequest(now time.Time, desired api.Resources) {
+	h.s.ScalingWebhook.OngoingRequest = &desired
+}
+
+func (h ScalingWebhookHandle) RequestSuccessful(now time.Time, resp api.ScalingWebhookResponse) {
+	if h.s.ScalingWebhook.OngoingRequest == nil {
+		panic("received ScalingWebhook().RequestSuccessful() update without ongoing request")
+	}
+
+	h.s.ScalingWebhook.LastResponse = &scalingWebhookResponse{
+		At:       now,
+		Desired:  *h.s.ScalingWebhook.OngoingRequest,
+		Response: resp,
+	}
+	h.s.ScalingWebhook.OngoingRequest = nil
+	h.s.ScalingWebhook.LastFailureAt = nil
+}
+
+func (h ScalingWebhookHandle) RequestFailed(now time.Time) {
+	h.s.ScalingWebhook.OngoingRequest = nil
+	h.s.ScalingWebhook.LastFailureAt = &now
+}
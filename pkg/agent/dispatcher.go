@@ -17,6 +17,7 @@ import (
 	"nhooyr.io/websocket/wsjson"
 
 	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/api/protometrics"
 	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
@@ -178,7 +179,7 @@ func NewDispatcher(
 			}
 
 			startTime := time.Now()
-			_, err := disp.Call(ctx, logger, timeout, "HealthCheck", api.HealthCheck{})
+			_, err := disp.Call(ctx, logger, timeout, protometrics.MessageHealthCheck, api.HealthCheck{})
 			endTime := time.Now()
 
 			logFields := []zap.Field{
@@ -362,9 +363,12 @@ func (disp *Dispatcher) Call(
 	id := disp.lastTransactionID.Add(2)
 	sender, receiver := util.NewSingleSignalPair[waiterResult]()
 
+	start := time.Now()
 	status := "internal error"
+	errReason := "internal error"
 	defer func() {
 		disp.runner.global.metrics.monitorRequestsOutbound.WithLabelValues(messageType, status).Inc()
+		disp.runner.global.metrics.monitorProtocol.Observe(messageType, time.Since(start), errReason)
 	}()
 
 	// register the waiter *before* sending, so that we avoid a potential race where we'd get a
@@ -375,6 +379,7 @@ func (disp *Dispatcher) Call(
 		logger.Error("failed to send message", zap.Any("message", message), zap.Error(err))
 		disp.unregisterWaiter(id)
 		status = "[error: failed to send]"
+		errReason = "failed to send"
 		return nil, err
 	}
 
@@ -385,15 +390,18 @@ func (disp *Dispatcher) Call(
 	case result := <-receiver.Recv():
 		if result.err != nil {
 			status = fmt.Sprintf("[error: %s]", result.err)
+			errReason = "monitor error"
 			return nil, errors.New("monitor experienced an internal error")
 		}
 
 		status = "ok"
+		errReason = ""
 		return result.res, nil
 	case <-timer.C:
 		err := fmt.Errorf("timed out waiting %v for monitor response", timeout)
 		disp.unregisterWaiter(id)
 		status = "[error: timed out waiting for response]"
+		errReason = "timed out"
 		return nil, err
 	}
 }
@@ -583,8 +591,10 @@ func (disp *Dispatcher) run(ctx context.Context, logger *zap.Logger, upscaleRequ
 	// from us, with a new id.
 	handleUpscaleRequest := func(req api.UpscaleRequest) {
 		// TODO: it shouldn't be this function's responsibility to update metrics.
+		start := time.Now()
 		defer func() {
-			disp.runner.global.metrics.monitorRequestsInbound.WithLabelValues("UpscaleRequest", "ok").Inc()
+			disp.runner.global.metrics.monitorRequestsInbound.WithLabelValues(protometrics.MessageUpscaleRequest, "ok").Inc()
+			disp.runner.global.metrics.monitorProtocol.Observe(protometrics.MessageUpscaleRequest, time.Since(start), "")
 		}()
 
 		resourceReq := api.MoreResources{
@@ -12,6 +12,7 @@ import (
 	vmclient "github.com/neondatabase/autoscaling/neonvm/client/clientset/versioned"
 	"github.com/neondatabase/autoscaling/pkg/agent/billing"
 	"github.com/neondatabase/autoscaling/pkg/agent/scalingevents"
+	"github.com/neondatabase/autoscaling/pkg/agent/scalingstats"
 	"github.com/neondatabase/autoscaling/pkg/agent/schedwatch"
 	"github.com/neondatabase/autoscaling/pkg/util"
 	"github.com/neondatabase/autoscaling/pkg/util/taskgroup"
@@ -38,16 +39,25 @@ func (r MainRunner) Run(logger *zap.Logger, ctx context.Context) error {
 	perVMMetrics, vmPromReg := makePerVMMetrics()
 
 	watchMetrics := watch.NewMetrics("autoscaling_agent_watchers", globalPromReg)
+	staleWatch := makeStaleWatchSettings(r.Config, r.KubeClient, r.EnvArgs.K8sNodeName)
 
 	logger.Info("Starting VM watcher")
-	vmWatchStore, err := startVMWatcher(ctx, logger, r.Config, r.VMClient, watchMetrics, perVMMetrics, r.EnvArgs.K8sNodeName, pushToQueue)
+	vmWatchStore, err := startVMWatcher(ctx, logger, r.Config, r.VMClient, watchMetrics, staleWatch, perVMMetrics, r.EnvArgs.K8sNodeName, pushToQueue)
 	if err != nil {
 		return fmt.Errorf("Error starting VM watcher: %w", err)
 	}
 	defer vmWatchStore.Stop()
 	logger.Info("VM watcher started")
 
-	schedTracker, err := schedwatch.StartSchedulerWatcher(ctx, logger, r.KubeClient, watchMetrics, r.Config.Scheduler.SchedulerName)
+	schedTracker, err := schedwatch.StartSchedulerWatcher(
+		ctx,
+		logger,
+		r.KubeClient,
+		watchMetrics,
+		staleWatch.staleAfter,
+		staleWatch.onStaleFor("Scheduler Pod"),
+		r.Config.Scheduler.SchedulerName,
+	)
 	if err != nil {
 		return fmt.Errorf("Starting scheduler watch server: %w", err)
 	}
@@ -59,11 +69,21 @@ func (r MainRunner) Run(logger *zap.Logger, ctx context.Context) error {
 		return fmt.Errorf("Error creating scaling events reporter: %w", err)
 	}
 
+	var scalingStats *scalingstats.Aggregator
+	if r.Config.ScalingStats != nil {
+		scalingStatsMetrics := scalingstats.NewPromMetrics(globalPromReg)
+		scalingStats, err = scalingstats.NewAggregator(ctx, logger, r.Config.ScalingStats, scalingStatsMetrics)
+		if err != nil {
+			return fmt.Errorf("Error creating scaling stats aggregator: %w", err)
+		}
+	}
+
 	globalState := r.newAgentState(
 		logger,
 		r.EnvArgs.K8sPodIP,
 		schedTracker,
 		scalingReporter,
+		scalingStats,
 		globalMetrics,
 		perVMMetrics,
 	)
@@ -97,9 +117,29 @@ func (r MainRunner) Run(logger *zap.Logger, ctx context.Context) error {
 	tg.Go("scalingevents-run", func(logger *zap.Logger) error {
 		return scalingReporter.Run(tg.Ctx())
 	})
+	if scalingStats != nil {
+		tg.Go("scalingstats-run", func(logger *zap.Logger) error {
+			return scalingStats.Run(tg.Ctx())
+		})
+	}
 	tg.Go("billing", func(logger *zap.Logger) error {
 		return mc.Run(tg.Ctx(), logger, storeForNode)
 	})
+	if r.Config.Scheduler.Fallback != nil {
+		fallback := &fallbackScheduler{
+			config:        *r.Config.Scheduler.Fallback,
+			schedulerName: r.Config.Scheduler.SchedulerName,
+			nodeName:      r.EnvArgs.K8sNodeName,
+			kubeClient:    r.KubeClient,
+			schedTracker:  schedTracker,
+			metrics:       globalMetrics,
+			watchMetrics:  watchMetrics,
+			staleWatch:    staleWatch,
+		}
+		tg.Go("fallback-scheduling", func(logger *zap.Logger) error {
+			return fallback.run(tg.Ctx(), logger)
+		})
+	}
 	tg.Go("main-loop", func(logger *zap.Logger) error {
 		logger.Info("Entering main loop")
 		for {
@@ -12,14 +12,16 @@ import (
 	"go.uber.org/zap"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/agent/core"
 	"github.com/neondatabase/autoscaling/pkg/agent/executor"
 	"github.com/neondatabase/autoscaling/pkg/api"
 )
 
 var (
-	_ executor.PluginInterface  = (*execPluginInterface)(nil)
-	_ executor.NeonVMInterface  = (*execNeonVMInterface)(nil)
-	_ executor.MonitorInterface = (*execMonitorInterface)(nil)
+	_ executor.PluginInterface         = (*execPluginInterface)(nil)
+	_ executor.NeonVMInterface         = (*execNeonVMInterface)(nil)
+	_ executor.MonitorInterface        = (*execMonitorInterface)(nil)
+	_ executor.ScalingWebhookInterface = (*execScalingWebhookInterface)(nil)
 )
 
 /////////////////////////////////////////////////////////////
@@ -50,13 +52,14 @@ func (iface *execPluginInterface) Request(
 	logger *zap.Logger,
 	lastPermit *api.Resources,
 	target api.Resources,
+	preapproval *api.Resources,
 	metrics *api.Metrics,
 ) (*api.PluginResponse, error) {
 	if lastPermit != nil {
 		iface.runner.recordResourceChange(*lastPermit, target, iface.runner.global.metrics.schedulerRequestedChange)
 	}
 
-	resp, err := iface.runner.DoSchedulerRequest(ctx, logger, target, lastPermit, metrics)
+	resp, err := iface.runner.DoSchedulerRequest(ctx, logger, target, lastPermit, preapproval, metrics)
 
 	if err == nil && lastPermit != nil {
 		iface.runner.recordResourceChange(*lastPermit, resp.Permit, iface.runner.global.metrics.schedulerApprovedChange)
@@ -116,10 +119,32 @@ func (iface *execNeonVMInterface) Request(
 	current, target api.Resources,
 	targetRevision vmv1.RevisionWithTime,
 ) error {
+	budget := iface.runner.global.config.NeonVM.NodeMemoryBudget
+	if budget != 0 {
+		othersMem, ok, err := iface.runner.global.claimNodeMemory(ctx, iface.runner.podName, target.Mem, budget)
+		if err != nil {
+			return fmt.Errorf("Error checking node memory budget: %w", err)
+		}
+		if !ok {
+			iface.runner.global.metrics.neonvmBudgetRefusals.Inc()
+			logger.Warn(
+				"Refusing upscale that would exceed node memory budget",
+				zap.Object("current", current),
+				zap.Object("target", target),
+				zap.String("otherVMsMemory", fmt.Sprint(othersMem)),
+				zap.String("budget", fmt.Sprint(budget)),
+			)
+			return fmt.Errorf("upscaling to %v would exceed node memory budget of %v", target.Mem, budget)
+		}
+	}
+
 	iface.runner.recordResourceChange(current, target, iface.runner.global.metrics.neonvmRequestedChange)
 
-	err := iface.runner.doNeonVMRequest(ctx, target, targetRevision)
+	err := iface.runner.doNeonVMRequest(ctx, current, target, targetRevision)
 	if err != nil {
+		if budget != 0 {
+			iface.runner.global.releaseNodeMemory(iface.runner.status, current.Mem)
+		}
 		iface.runner.status.update(iface.runner.global, func(ps podStatus) podStatus {
 			ps.failedNeonVMRequestCounter.Inc()
 			return ps
@@ -235,3 +260,30 @@ func (h *execMonitorHandle) Upscale(ctx context.Context, logger *zap.Logger, cur
 
 	return err
 }
+
+//////////////////////////////////////////////////////////
+// Scaling webhook-related interface and implementation //
+//////////////////////////////////////////////////////////
+
+type execScalingWebhookInterface struct {
+	runner *Runner
+}
+
+func makeScalingWebhookInterface(r *Runner) *execScalingWebhookInterface {
+	return &execScalingWebhookInterface{runner: r}
+}
+
+// Request implements executor.ScalingWebhookInterface
+func (iface *execScalingWebhookInterface) Request(
+	ctx context.Context,
+	logger *zap.Logger,
+	action core.ActionScalingWebhook,
+) (*api.ScalingWebhookResponse, error) {
+	return iface.runner.doScalingWebhookRequest(ctx, logger, action.Webhook, api.ScalingWebhookRequest{
+		VM:      iface.runner.vmName,
+		Current: action.Current,
+		Desired: action.Desired,
+		Min:     action.Min,
+		Max:     action.Max,
+	})
+}
@@ -48,9 +48,10 @@ type ExecutorCore struct {
 }
 
 type ClientSet struct {
-	Plugin  PluginInterface
-	NeonVM  NeonVMInterface
-	Monitor MonitorInterface
+	Plugin         PluginInterface
+	NeonVM         NeonVMInterface
+	Monitor        MonitorInterface
+	ScalingWebhook ScalingWebhookInterface
 }
 
 func NewExecutorCore(stateLogger *zap.Logger, vm api.VmInfo, config Config) *ExecutorCore {
@@ -178,6 +179,15 @@ func (c ExecutorCoreUpdater) UpdateLFCMetrics(metrics core.LFCMetrics, withLock
 	})
 }
 
+// UpdateExtraMetrics calls (*core.State).UpdateExtraMetrics() on the inner core.State and runs
+// withLock while holding the lock.
+func (c ExecutorCoreUpdater) UpdateExtraMetrics(metrics core.ExtraMetrics, withLock func()) {
+	c.core.update(func(state *core.State) {
+		state.UpdateExtraMetrics(metrics)
+		withLock()
+	})
+}
+
 // UpdatedVM calls (*core.State).UpdatedVM() on the inner core.State and runs withLock while
 // holding the lock.
 func (c ExecutorCoreUpdater) UpdatedVM(vm api.VmInfo, withLock func()) {
@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/agent/core"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+type ScalingWebhookInterface interface {
+	Request(_ context.Context, _ *zap.Logger, _ core.ActionScalingWebhook) (*api.ScalingWebhookResponse, error)
+}
+
+func (c *ExecutorCoreWithClients) DoScalingWebhookRequests(ctx context.Context, logger *zap.Logger) {
+	var (
+		updates     util.BroadcastReceiver = c.updates.NewReceiver()
+		ifaceLogger *zap.Logger            = logger.Named("client")
+	)
+
+	for {
+		// Wait until the state's changed, or we're done.
+		select {
+		case <-ctx.Done():
+			return
+		case <-updates.Wait():
+			updates.Awake()
+		}
+
+		last := c.getActions()
+		if last.actions.ScalingWebhook == nil {
+			continue // nothing to do; wait until the state changes.
+		}
+
+		var startTime time.Time
+		action := *last.actions.ScalingWebhook
+
+		if updated := c.updateIfActionsUnchanged(last, func(state *core.State) {
+			logger.Info("Starting scaling webhook request", zap.Object("action", action))
+			startTime = time.Now()
+			state.ScalingWebhook().StartingRequest(startTime, action.Desired)
+		}); !updated {
+			continue // state has changed, retry.
+		}
+
+		resp, err := c.clients.ScalingWebhook.Request(ctx, ifaceLogger, action)
+		endTime := time.Now()
+
+		c.update(func(state *core.State) {
+			logFields := []zap.Field{
+				zap.Object("action", action),
+				zap.Duration("duration", endTime.Sub(startTime)),
+			}
+
+			if err != nil {
+				logger.Error("Scaling webhook request failed", append(logFields, zap.Error(err))...)
+				state.ScalingWebhook().RequestFailed(endTime)
+			} else {
+				logFields = append(logFields, zap.Any("response", resp))
+				logger.Info("Scaling webhook request successful", logFields...)
+				state.ScalingWebhook().RequestSuccessful(endTime, *resp)
+			}
+		})
+	}
+}
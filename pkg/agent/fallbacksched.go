@@ -0,0 +1,356 @@
+package agent
+
+// Fallback scheduling: placing new VM pods onto this node while there's no scheduler available.
+//
+// Without a scheduler, no new VMs can start. So if the scheduler has been unavailable for a while,
+// each autoscaler-agent binds pending VM pods to its own node, as long as they're small and the
+// node has plenty of room, judged only by the resource requests of the pods already on the node.
+// The node, the pods on it, and the pending pods are all kept up to date by watches, so that each
+// autoscaler-agent isn't repeatedly listing pods across the whole cluster during an outage.
+// If multiple autoscaler-agents try to place the same pod, binding it only succeeds for one of
+// them.
+//
+// Once the scheduler returns, it handles these pods like any other pods on their nodes. The pods
+// are annotated with api.AnnotationFallbackScheduled, so that they can be found afterwards.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/agent/schedwatch"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/watch"
+)
+
+type fallbackScheduler struct {
+	config        FallbackSchedulingConfig
+	schedulerName string
+	nodeName      string
+
+	kubeClient   kubernetes.Interface
+	schedTracker *schedwatch.SchedulerTracker
+	metrics      GlobalMetrics
+	watchMetrics watch.Metrics
+	staleWatch   staleWatchSettings
+}
+
+// fallbackStores are the watch stores that fallback scheduling decisions are made from.
+type fallbackStores struct {
+	// node has only the node that this autoscaler-agent is responsible for.
+	node *watch.Store[corev1.Node]
+	// nodePods are all pods bound to the node.
+	nodePods *watch.Store[corev1.Pod]
+	// pendingPods are the pods for our scheduler that haven't yet been bound to any node.
+	pendingPods *watch.Store[corev1.Pod]
+}
+
+func (s fallbackStores) stop() {
+	s.node.Stop()
+	s.nodePods.Stop()
+	s.pendingPods.Stop()
+}
+
+func (s fallbackStores) failing() bool {
+	return s.node.Failing() || s.nodePods.Failing() || s.pendingPods.Failing()
+}
+
+// run periodically checks whether the scheduler is available, placing pending pods onto the node
+// if it's been unavailable for long enough, until the context is canceled.
+func (f *fallbackScheduler) run(ctx context.Context, logger *zap.Logger) error {
+	stores, err := f.startWatchers(ctx, logger)
+	if err != nil {
+		return err
+	}
+	defer stores.stop()
+
+	ticker := time.NewTicker(time.Second * time.Duration(f.config.IntervalSeconds))
+	defer ticker.Stop()
+
+	unavailableAfter := time.Second * time.Duration(f.config.UnavailableAfterSeconds)
+
+	var unavailableSince *time.Time
+	var placed uint
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if f.schedTracker.Get() != nil {
+			if unavailableSince != nil {
+				logger.Info(
+					"Scheduler is available again, stopping fallback scheduling",
+					zap.Duration("outage", time.Since(*unavailableSince)),
+					zap.Uint("placedPods", placed),
+				)
+				f.metrics.fallbackSchedulingActive.Set(0)
+			}
+			unavailableSince = nil
+			placed = 0
+			continue
+		}
+
+		if unavailableSince == nil {
+			now := time.Now()
+			unavailableSince = &now
+			logger.Warn("No scheduler available")
+		}
+		if time.Since(*unavailableSince) < unavailableAfter || placed >= f.config.MaxPodsPerOutage {
+			continue
+		}
+		if placed == 0 {
+			logger.Warn(
+				"Scheduler has been unavailable for too long, starting fallback scheduling",
+				zap.Duration("outage", time.Since(*unavailableSince)),
+			)
+			f.metrics.fallbackSchedulingActive.Set(1)
+		}
+
+		ok, err := f.placePendingPod(ctx, logger, stores)
+		if err != nil {
+			f.metrics.fallbackScheduledPods.WithLabelValues("error").Inc()
+			logger.Warn("Failed to place pending pod onto node", zap.Error(err))
+		} else if ok {
+			f.metrics.fallbackScheduledPods.WithLabelValues("placed").Inc()
+			placed += 1
+		}
+	}
+}
+
+// startWatchers starts the watches for fallbackStores, waiting for the initial listing of each.
+func (f *fallbackScheduler) startWatchers(ctx context.Context, logger *zap.Logger) (fallbackStores, error) {
+	config := func(instance string) watch.Config {
+		return watch.Config{
+			ObjectNameLogField: "object",
+			Metrics: watch.MetricsConfig{
+				Metrics:  f.watchMetrics,
+				Instance: instance,
+			},
+			// Fallback scheduling only happens after the scheduler's been unavailable for a while,
+			// so we don't need to be particularly responsive.
+			RetryRelistAfter: util.NewTimeRange(time.Second, 4, 5),
+			RetryWatchAfter:  util.NewTimeRange(time.Second, 4, 5),
+			StaleAfter:       f.staleWatch.staleAfter,
+			OnStale:          f.staleWatch.onStaleFor(instance),
+		}
+	}
+	watchPods := func(instance string, selector fields.Selector) (*watch.Store[corev1.Pod], error) {
+		return watch.Watch(
+			ctx,
+			logger.Named("watch-"+instance),
+			f.kubeClient.CoreV1().Pods(corev1.NamespaceAll),
+			config(instance),
+			watch.Accessors[*corev1.PodList, corev1.Pod]{
+				Items: func(list *corev1.PodList) []corev1.Pod { return list.Items },
+			},
+			watch.InitModeSync,
+			metav1.ListOptions{FieldSelector: selector.String()},
+			watch.HandlerFuncs[*corev1.Pod]{},
+		)
+	}
+
+	node, err := watch.Watch(
+		ctx,
+		logger.Named("watch-fallback-node"),
+		f.kubeClient.CoreV1().Nodes(),
+		config("Fallback Node"),
+		watch.Accessors[*corev1.NodeList, corev1.Node]{
+			Items: func(list *corev1.NodeList) []corev1.Node { return list.Items },
+		},
+		watch.InitModeSync,
+		metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", f.nodeName).String()},
+		watch.HandlerFuncs[*corev1.Node]{},
+	)
+	if err != nil {
+		return fallbackStores{}, fmt.Errorf("could not start node watcher: %w", err)
+	}
+
+	nodePods, err := watchPods("Fallback Node Pods", fields.OneTermEqualSelector("spec.nodeName", f.nodeName))
+	if err != nil {
+		node.Stop()
+		return fallbackStores{}, fmt.Errorf("could not start watcher for pods on node: %w", err)
+	}
+
+	pendingPods, err := watchPods("Fallback Pending Pods", fields.AndSelectors(
+		fields.OneTermEqualSelector("spec.schedulerName", f.schedulerName),
+		fields.OneTermEqualSelector("spec.nodeName", ""),
+		fields.OneTermEqualSelector("status.phase", string(corev1.PodPending)),
+	))
+	if err != nil {
+		node.Stop()
+		nodePods.Stop()
+		return fallbackStores{}, fmt.Errorf("could not start watcher for pending pods: %w", err)
+	}
+
+	return fallbackStores{node: node, nodePods: nodePods, pendingPods: pendingPods}, nil
+}
+
+// placePendingPod binds the oldest pending pod that fits onto this node, if there is one.
+func (f *fallbackScheduler) placePendingPod(ctx context.Context, logger *zap.Logger, stores fallbackStores) (bool, error) {
+	// If any of the watches are failing, our view of the node may be out of date, and we could
+	// place more onto it than it has room for.
+	if stores.failing() {
+		return false, errors.New("watches for fallback scheduling are failing")
+	}
+
+	nodes := stores.node.Items()
+	if len(nodes) == 0 {
+		return false, errors.New("node not found")
+	}
+	node := nodes[0]
+	if !fallbackNodeAcceptsPods(node) {
+		return false, nil
+	}
+
+	var used api.Resources
+	for _, pod := range stores.nodePods.Items() {
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			used = used.Add(fallbackPodRequests(pod))
+		}
+	}
+
+	pendingPods := stores.pendingPods.Items()
+	slices.SortFunc(pendingPods, func(a, b *corev1.Pod) int {
+		return a.CreationTimestamp.Compare(b.CreationTimestamp.Time)
+	})
+
+	allocatable := api.Resources{
+		VCPU: vmv1.MilliCPUFromResourceQuantity(node.Status.Allocatable[corev1.ResourceCPU]),
+		Mem:  api.BytesFromResourceQuantity(node.Status.Allocatable[corev1.ResourceMemory]),
+	}
+	limit := api.Resources{
+		VCPU: vmv1.MilliCPU(float64(allocatable.VCPU) * f.config.MaxNodeUtilization),
+		Mem:  api.Bytes(float64(allocatable.Mem) * f.config.MaxNodeUtilization),
+	}
+	pendingAfter := time.Second * time.Duration(f.config.PendingAfterSeconds)
+
+	for _, pod := range pendingPods {
+		if pod.DeletionTimestamp != nil || time.Since(pod.CreationTimestamp.Time) < pendingAfter {
+			continue
+		}
+		if !fallbackPodFitsNode(pod, node) {
+			continue
+		}
+		requests := fallbackPodRequests(pod)
+		if requests.HasFieldGreaterThan(f.config.MaxPodResources) || used.Add(requests).HasFieldGreaterThan(limit) {
+			continue
+		}
+
+		logger := logger.With(zap.Object("pod", podName(pod)), zap.Object("requests", requests))
+		if err := f.bind(ctx, pod); err != nil {
+			if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+				// Another autoscaler-agent got there first, or the pod was deleted. Try again
+				// next time.
+				logger.Info("Could not place pod onto node, it was already scheduled or deleted", zap.Error(err))
+				return false, nil
+			}
+			return false, fmt.Errorf("could not bind pod %s: %w", podName(pod), err)
+		}
+		logger.Warn("Placed pod onto node while the scheduler is unavailable")
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (f *fallbackScheduler) bind(ctx context.Context, pod *corev1.Pod) error {
+	//nolint:exhaustruct // only the fields that the API server uses
+	binding := &corev1.Binding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			UID:       pod.UID,
+			// Annotations on the binding are copied to the pod
+			Annotations: map[string]string{
+				api.AnnotationFallbackScheduled: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+		Target: corev1.ObjectReference{
+			Kind: "Node",
+			Name: f.nodeName,
+		},
+	}
+	return f.kubeClient.CoreV1().Pods(pod.Namespace).Bind(ctx, binding, metav1.CreateOptions{})
+}
+
+func podName(pod *corev1.Pod) util.NamespacedName {
+	return util.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+}
+
+// fallbackNodeAcceptsPods returns whether the node is ready and schedulable, without any taints
+// that would keep pods off of it.
+//
+// We don't bother checking tolerations, and just refuse to place pods onto nodes with taints.
+func fallbackNodeAcceptsPods(node *corev1.Node) bool {
+	if node.Spec.Unschedulable || node.DeletionTimestamp != nil {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// fallbackPodFitsNode returns whether the pod's constraints on placement allow it to be placed onto
+// the node.
+//
+// Because we only have a node-local view, we don't attempt to evaluate any constraints that depend
+// on other pods (affinity, anti-affinity, or topology spread), and skip any pods that have them.
+//
+// We also skip pods with persistent volumes: the volumes may only be reachable from some nodes, and
+// volumes with delayed binding are only provisioned once the scheduler has picked a node.
+func fallbackPodFitsNode(pod *corev1.Pod, node *corev1.Node) bool {
+	if pod.Spec.Affinity != nil || len(pod.Spec.TopologySpreadConstraints) != 0 {
+		return false
+	}
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil || vol.Ephemeral != nil {
+			return false
+		}
+	}
+	return labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels))
+}
+
+// fallbackPodRequests returns the CPU and memory requested by the pod, including its overhead.
+func fallbackPodRequests(pod *corev1.Pod) api.Resources {
+	sum := func(list corev1.ResourceList) api.Resources {
+		return api.Resources{
+			VCPU: vmv1.MilliCPUFromResourceQuantity(list[corev1.ResourceCPU]),
+			Mem:  api.BytesFromResourceQuantity(list[corev1.ResourceMemory]),
+		}
+	}
+
+	var total api.Resources
+	for _, c := range pod.Spec.Containers {
+		total = total.Add(sum(c.Resources.Requests))
+	}
+	// Init containers run one at a time before the regular containers, so the pod needs at least
+	// as much as the largest of them.
+	for _, c := range pod.Spec.InitContainers {
+		total = total.Max(sum(c.Resources.Requests))
+	}
+	return total.Add(sum(pod.Spec.Overhead))
+}
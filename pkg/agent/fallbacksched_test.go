@@ -0,0 +1,225 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func TestFallbackNodeAcceptsPods(t *testing.T) {
+	ready := corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionTrue}
+	notReady := corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionFalse}
+	now := metav1.Now()
+
+	cases := []struct {
+		name       string
+		node       corev1.Node
+		expectedOk bool
+	}{
+		{
+			name: "ready",
+			node: corev1.Node{
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{ready}},
+			},
+			expectedOk: true,
+		},
+		{
+			name: "not ready",
+			node: corev1.Node{
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{notReady}},
+			},
+			expectedOk: false,
+		},
+		{
+			name:       "no ready condition",
+			node:       corev1.Node{},
+			expectedOk: false,
+		},
+		{
+			name: "unschedulable",
+			node: corev1.Node{
+				Spec:   corev1.NodeSpec{Unschedulable: true},
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{ready}},
+			},
+			expectedOk: false,
+		},
+		{
+			name: "being deleted",
+			node: corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now},
+				Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{ready}},
+			},
+			expectedOk: false,
+		},
+		{
+			name: "NoSchedule taint",
+			node: corev1.Node{
+				Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+					{Key: "example.com/taint", Effect: corev1.TaintEffectNoSchedule},
+				}},
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{ready}},
+			},
+			expectedOk: false,
+		},
+		{
+			name: "NoExecute taint",
+			node: corev1.Node{
+				Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+					{Key: "example.com/taint", Effect: corev1.TaintEffectNoExecute},
+				}},
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{ready}},
+			},
+			expectedOk: false,
+		},
+		{
+			name: "PreferNoSchedule taint",
+			node: corev1.Node{
+				Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+					{Key: "example.com/taint", Effect: corev1.TaintEffectPreferNoSchedule},
+				}},
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{ready}},
+			},
+			expectedOk: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expectedOk, fallbackNodeAcceptsPods(&c.node))
+		})
+	}
+}
+
+func TestFallbackPodFitsNode(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"pool": "vms", "zone": "a"}},
+	}
+
+	cases := []struct {
+		name       string
+		spec       corev1.PodSpec
+		expectedOk bool
+	}{
+		{
+			name:       "no constraints",
+			spec:       corev1.PodSpec{},
+			expectedOk: true,
+		},
+		{
+			name:       "matching node selector",
+			spec:       corev1.PodSpec{NodeSelector: map[string]string{"pool": "vms"}},
+			expectedOk: true,
+		},
+		{
+			name:       "mismatched node selector",
+			spec:       corev1.PodSpec{NodeSelector: map[string]string{"pool": "other"}},
+			expectedOk: false,
+		},
+		{
+			name:       "affinity",
+			spec:       corev1.PodSpec{Affinity: &corev1.Affinity{}},
+			expectedOk: false,
+		},
+		{
+			name: "topology spread",
+			spec: corev1.PodSpec{TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+				{MaxSkew: 1, TopologyKey: "zone", WhenUnsatisfiable: corev1.DoNotSchedule},
+			}},
+			expectedOk: false,
+		},
+		{
+			name: "non-persistent volume",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			}},
+			expectedOk: true,
+		},
+		{
+			name: "persistent volume claim",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+				}},
+			}},
+			expectedOk: false,
+		},
+		{
+			name: "generic ephemeral volume",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "scratch", VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{}}},
+			}},
+			expectedOk: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expectedOk, fallbackPodFitsNode(&corev1.Pod{Spec: c.spec}, node))
+		})
+	}
+}
+
+func TestFallbackPodRequests(t *testing.T) {
+	requests := func(cpu, mem string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(mem),
+		}}
+	}
+
+	const gib = 1024 * 1024 * 1024
+
+	cases := []struct {
+		name     string
+		spec     corev1.PodSpec
+		expected api.Resources
+	}{
+		{
+			name:     "no requests",
+			spec:     corev1.PodSpec{Containers: []corev1.Container{{Name: "a"}}},
+			expected: api.Resources{VCPU: 0, Mem: 0},
+		},
+		{
+			name: "containers are summed",
+			spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "a", Resources: requests("1", "1Gi")},
+				{Name: "b", Resources: requests("500m", "2Gi")},
+			}},
+			expected: api.Resources{VCPU: 1500, Mem: 3 * gib},
+		},
+		{
+			name: "largest init container",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{
+					{Name: "init-a", Resources: requests("2", "512Mi")},
+					{Name: "init-b", Resources: requests("250m", "4Gi")},
+				},
+				Containers: []corev1.Container{{Name: "a", Resources: requests("1", "1Gi")}},
+			},
+			expected: api.Resources{VCPU: 2000, Mem: 4 * gib},
+		},
+		{
+			name: "overhead",
+			spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "a", Resources: requests("1", "1Gi")}},
+				Overhead: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+			expected: api.Resources{VCPU: 1100, Mem: 2 * gib},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, fallbackPodRequests(&corev1.Pod{Spec: c.spec}))
+		})
+	}
+}
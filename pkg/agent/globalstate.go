@@ -17,6 +17,7 @@ import (
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	vmclient "github.com/neondatabase/autoscaling/neonvm/client/clientset/versioned"
 	"github.com/neondatabase/autoscaling/pkg/agent/scalingevents"
+	"github.com/neondatabase/autoscaling/pkg/agent/scalingstats"
 	"github.com/neondatabase/autoscaling/pkg/agent/schedwatch"
 	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/util"
@@ -42,7 +43,13 @@ type agentState struct {
 	metrics      GlobalMetrics
 	vmMetrics    *PerVMMetrics
 
+	// apiHealth tracks whether the API server is degraded, if the config's APIHealth is set.
+	// Otherwise, it's nil.
+	apiHealth *util.APIHealth
+
 	scalingReporter *scalingevents.Reporter
+	// scalingStats is nil if the config's ScalingStats is not set.
+	scalingStats *scalingstats.Aggregator
 }
 
 func (r MainRunner) newAgentState(
@@ -50,6 +57,7 @@ func (r MainRunner) newAgentState(
 	podIP string,
 	schedTracker *schedwatch.SchedulerTracker,
 	scalingReporter *scalingevents.Reporter,
+	scalingStats *scalingstats.Aggregator,
 	globalMetrics GlobalMetrics,
 	perVMMetrics *PerVMMetrics,
 ) *agentState {
@@ -65,7 +73,18 @@ func (r MainRunner) newAgentState(
 		metrics:      globalMetrics,
 		vmMetrics:    perVMMetrics,
 
+		apiHealth: util.NewAPIHealth(r.Config.APIHealth, func(degraded bool) {
+			if degraded {
+				baseLogger.Warn("API server is degraded, deferring non-critical writes")
+				globalMetrics.apiDegraded.Set(1)
+			} else {
+				baseLogger.Info("API server has recovered, no longer deferring non-critical writes")
+				globalMetrics.apiDegraded.Set(0)
+			}
+		}),
+
 		scalingReporter: scalingReporter,
+		scalingStats:    scalingStats,
 	}
 }
 
@@ -154,12 +173,15 @@ func (s *agentState) handleVMEventAdded(
 			endState:           nil,
 			previousEndStates:  nil,
 			vmInfo:             event.vmInfo,
+			memoryClaim:        0,
 			endpointID:         event.endpointID,
 			endpointAssignedAt: &now,
 			state:              "", // Explicitly set state to empty so that the initial state update does no decrement
 			stateUpdatedAt:     now,
 
 			startTime:                     now,
+			coldStartAt:                   event.coldStartAt,
+			startupBoostCU:                0,
 			lastSuccessfulMonitorComm:     nil,
 			failedMonitorRequestCounter:   util.NewRecentCounter(time.Duration(s.config.Monitor.MaxFailedRequestRate.IntervalSeconds) * time.Second),
 			failedNeonVMRequestCounter:    util.NewRecentCounter(time.Duration(s.config.NeonVM.MaxFailedRequestRate.IntervalSeconds) * time.Second),
@@ -337,6 +359,76 @@ func (s *agentState) TriggerRestartIfNecessary(runnerCtx context.Context, logger
 	}()
 }
 
+// claimNodeMemory checks whether the VM with the given pod can scale to the target memory without
+// the total memory of all VMs on the node exceeding the budget and, if so, claims it -- so that
+// it's counted for every other VM's check until the VM's own request has taken effect.
+//
+// The check and the claim happen together while holding s.lock, so that concurrent upscaling of
+// different VMs can't each fit in the same remaining budget. Decreases are always allowed.
+//
+// Returns the memory used or claimed by other VMs, and whether the target was claimed.
+func (s *agentState) claimNodeMemory(
+	ctx context.Context,
+	podName util.NamespacedName,
+	target api.Bytes,
+	budget api.Bytes,
+) (othersMem api.Bytes, ok bool, _ error) {
+	if err := s.lock.TryLock(ctx); err != nil {
+		return 0, false, err
+	}
+	defer s.lock.Unlock()
+
+	othersMem = s.memoryOfOtherVMs(podName)
+
+	pod, exists := s.pods[podName]
+	if !exists {
+		// Nothing to record the claim on; just check it.
+		return othersMem, othersMem+target <= budget, nil
+	}
+
+	pod.status.mu.Lock()
+	defer pod.status.mu.Unlock()
+
+	if target > nodeMemoryOf(pod.status.podStatus) && othersMem+target > budget {
+		return othersMem, false, nil
+	}
+	pod.status.memoryClaim = target
+	return othersMem, true, nil
+}
+
+// releaseNodeMemory lowers the memory claimed by the VM to what it had before a request that
+// failed. Refer to claimNodeMemory.
+func (s *agentState) releaseNodeMemory(status *lockedPodStatus, previous api.Bytes) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+
+	status.memoryClaim = min(status.memoryClaim, previous)
+}
+
+// memoryOfOtherVMs returns the total memory used or claimed by all VMs on the node, except for the
+// one with the given pod.
+//
+// NOTE: this function expects that the caller has acquired s.lock.
+func (s *agentState) memoryOfOtherVMs(exclude util.NamespacedName) api.Bytes {
+	var total api.Bytes
+	for podName, pod := range s.pods {
+		if podName == exclude {
+			continue
+		}
+
+		pod.status.mu.Lock()
+		total += nodeMemoryOf(pod.status.podStatus)
+		pod.status.mu.Unlock()
+	}
+
+	return total
+}
+
+// nodeMemoryOf returns the memory that's counted towards the node's memory budget for the VM.
+func nodeMemoryOf(status podStatus) api.Bytes {
+	return max(status.vmInfo.Using().Mem, status.memoryClaim)
+}
+
 func (s *agentState) loggerForRunner(restartCount int, vmName, podName util.NamespacedName) *zap.Logger {
 	return s.baseLogger.Named("runner").With(
 		zap.Int("restarts", restartCount),
@@ -347,7 +439,7 @@ func (s *agentState) loggerForRunner(restartCount int, vmName, podName util.Name
 
 // NB: caller must set Runner.status after creation
 func (s *agentState) newRunner(vmInfo api.VmInfo, podName util.NamespacedName, podIP string) *Runner {
-	return &Runner{
+	r := &Runner{
 		global: s,
 		status: nil, // set by caller
 
@@ -356,15 +448,24 @@ func (s *agentState) newRunner(vmInfo api.VmInfo, podName util.NamespacedName, p
 		podName:     podName,
 		podIP:       podIP,
 		memSlotSize: vmInfo.Mem.SlotSize,
+		computeUnit: s.config.Scaling.ComputeUnits.ForFamily(vmInfo.Config.ComputeUnitFamily),
 		lock:        util.NewChanMutex(),
 
 		executorStateDump: nil, // set by (*Runner).Run
 
+		lastNeonVMRequest: time.Time{},
+		confirmed:         atomic.Pointer[api.Resources]{},
+
 		monitor: nil,
 
 		backgroundWorkerCount: atomic.Int64{},
 		backgroundPanic:       make(chan error),
 	}
+	// Until we patch the VM ourselves, the resources it's currently using are all that we can
+	// confirm.
+	using := vmInfo.Using()
+	r.confirmed.Store(&using)
+	return r
 }
 
 type podState struct {
@@ -407,6 +508,15 @@ type lockedPodStatus struct {
 type podStatus struct {
 	startTime time.Time
 
+	// coldStartAt, if not nil, gives the time at which we saw the VM start from scratch, for the
+	// purposes of the VM's startup boost. Unlike startTime, it's not reset when the Runner restarts.
+	//
+	// NB: this value is never changed.
+	coldStartAt *time.Time
+	// startupBoostCU is the number of Compute Units that the VM is currently boosted by, after
+	// starting.
+	startupBoostCU uint32
+
 	// if true, the corresponding podState is no longer included in the global pod map
 	deleted bool
 
@@ -426,6 +536,11 @@ type podStatus struct {
 	// here, where we don't have to rely on the Runner being well-behaved w.r.t. locking.
 	vmInfo api.VmInfo
 
+	// memoryClaim is the memory that the VM's most recent NeonVM request asked for, if the config's
+	// NodeMemoryBudget is set. Until vmInfo reflects the request, the VM is counted as using the
+	// larger of the two. Refer to (*agentState).claimNodeMemory.
+	memoryClaim api.Bytes
+
 	// endpointID, if non-empty, stores the ID of the endpoint associated with the VM
 	endpointID string
 
@@ -439,6 +554,9 @@ type podStatus struct {
 type podStatusDump struct {
 	StartTime time.Time `json:"startTime"`
 
+	ColdStartAt    *time.Time `json:"coldStartAt"`
+	StartupBoostCU uint32     `json:"startupBoostCU"`
+
 	EndState          *podStatusEndState  `json:"endState"`
 	PreviousEndStates []podStatusEndState `json:"previousEndStates"`
 
@@ -607,6 +725,9 @@ func (s *lockedPodStatus) dump() podStatusDump {
 		EndpointAssignedAt: s.endpointAssignedAt, // ok to share the pointer, because it's not updated
 		StartTime:          s.startTime,
 
+		ColdStartAt:    s.coldStartAt, // ok to share the pointer, because it's not updated
+		StartupBoostCU: s.startupBoostCU,
+
 		State:          s.state,
 		StateUpdatedAt: s.stateUpdatedAt,
 
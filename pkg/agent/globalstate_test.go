@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+func TestMemoryOfOtherVMs(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	podName := func(name string) util.NamespacedName {
+		return util.NamespacedName{Namespace: "default", Name: name}
+	}
+
+	cases := []struct {
+		name string
+		// memory slots of 1 GiB in use by each VM on the node
+		slots    map[string]uint16
+		exclude  string
+		expected api.Bytes
+	}{
+		{
+			name:     "no VMs",
+			slots:    map[string]uint16{},
+			exclude:  "vm-a",
+			expected: 0,
+		},
+		{
+			name:     "only the excluded VM",
+			slots:    map[string]uint16{"vm-a": 4},
+			exclude:  "vm-a",
+			expected: 0,
+		},
+		{
+			name:     "other VMs",
+			slots:    map[string]uint16{"vm-a": 4, "vm-b": 2, "vm-c": 8},
+			exclude:  "vm-a",
+			expected: 10 * gib,
+		},
+		{
+			name:     "excluded VM not on the node",
+			slots:    map[string]uint16{"vm-b": 2, "vm-c": 8},
+			exclude:  "vm-a",
+			expected: 10 * gib,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &agentState{ //nolint:exhaustruct // only need the pods for this test
+				lock: util.NewChanMutex(),
+				pods: make(map[util.NamespacedName]*podState),
+			}
+			for name, slots := range c.slots {
+				s.pods[podName(name)] = &podState{ //nolint:exhaustruct // only need the status for this test
+					podName: podName(name),
+					status: &lockedPodStatus{ //nolint:exhaustruct // only need the VM info for this test
+						podStatus: podStatus{ //nolint:exhaustruct // only need the VM info for this test
+							vmInfo: api.VmInfo{ //nolint:exhaustruct // only need the memory for this test
+								Mem: api.VmMemInfo{Min: 1, Max: 16, Use: slots, SlotSize: gib},
+							},
+						},
+					},
+				}
+			}
+
+			assert.Equal(t, c.expected, s.memoryOfOtherVMs(podName(c.exclude)))
+		})
+	}
+}
+
+// Memory claimed by a VM's request is counted against the node memory budget before the VM's own
+// status reflects it, so that two VMs can't both upscale into the same remaining budget.
+func TestClaimNodeMemory(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	vmA := util.NamespacedName{Namespace: "default", Name: "vm-a"}
+	vmB := util.NamespacedName{Namespace: "default", Name: "vm-b"}
+
+	s := &agentState{ //nolint:exhaustruct // only need the pods for this test
+		lock: util.NewChanMutex(),
+		pods: make(map[util.NamespacedName]*podState),
+	}
+	for _, name := range []util.NamespacedName{vmA, vmB} {
+		s.pods[name] = &podState{ //nolint:exhaustruct // only need the status for this test
+			podName: name,
+			status: &lockedPodStatus{ //nolint:exhaustruct // only need the VM info for this test
+				podStatus: podStatus{ //nolint:exhaustruct // only need the VM info for this test
+					vmInfo: api.VmInfo{ //nolint:exhaustruct // only need the memory for this test
+						Mem: api.VmMemInfo{Min: 1, Max: 16, Use: 4, SlotSize: gib},
+					},
+				},
+			},
+		}
+	}
+
+	const budget = 12 * gib
+	ctx := context.Background()
+
+	// Both VMs use 4 GiB, so there's room for only one of them to upscale to 8 GiB.
+	othersMem, ok, err := s.claimNodeMemory(ctx, vmA, 8*gib, budget)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, api.Bytes(4*gib), othersMem)
+
+	othersMem, ok, err = s.claimNodeMemory(ctx, vmB, 8*gib, budget)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, api.Bytes(8*gib), othersMem)
+
+	// Downscaling is always allowed, and lowers the claim.
+	_, ok, err = s.claimNodeMemory(ctx, vmB, 2*gib, budget)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Once vm-a's request fails, its claim is released, and vm-b can use the memory instead.
+	s.releaseNodeMemory(s.pods[vmA].status, 4*gib)
+	othersMem, ok, err = s.claimNodeMemory(ctx, vmB, 8*gib, budget)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, api.Bytes(4*gib), othersMem)
+}
+
+func TestClaimNodeMemoryCanceled(t *testing.T) {
+	s := &agentState{ //nolint:exhaustruct // only need the lock for this test
+		lock: util.NewChanMutex(),
+		pods: make(map[util.NamespacedName]*podState),
+	}
+
+	// While the lock is held, a canceled context returns an error instead of waiting.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := s.claimNodeMemory(ctx, util.NamespacedName{Namespace: "default", Name: "vm-a"}, 0, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+}
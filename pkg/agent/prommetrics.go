@@ -11,6 +11,7 @@ import (
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	"github.com/neondatabase/autoscaling/pkg/agent/core/revsource"
 	"github.com/neondatabase/autoscaling/pkg/agent/scalingevents"
+	"github.com/neondatabase/autoscaling/pkg/api/protometrics"
 	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
@@ -18,6 +19,7 @@ type GlobalMetrics struct {
 	schedulerRequests        *prometheus.CounterVec
 	schedulerRequestedChange resourceChangePair
 	schedulerApprovedChange  resourceChangePair
+	schedulerVersionSkew     *prometheus.CounterVec
 
 	scalingFullDeniesTotal       *prometheus.CounterVec
 	scalingPartialApprovalsTotal *prometheus.CounterVec
@@ -26,9 +28,19 @@ type GlobalMetrics struct {
 	monitorRequestsInbound  *prometheus.CounterVec
 	monitorRequestedChange  resourceChangePair
 	monitorApprovedChange   resourceChangePair
+	monitorProtocol         *protometrics.Metrics
 
 	neonvmRequestsOutbound *prometheus.CounterVec
 	neonvmRequestedChange  resourceChangePair
+	neonvmBudgetRefusals   prometheus.Counter
+
+	scalingWebhookRequests *prometheus.CounterVec
+
+	apiDegraded    prometheus.Gauge
+	deferredWrites *prometheus.CounterVec
+
+	fallbackSchedulingActive prometheus.Gauge
+	fallbackScheduledPods    *prometheus.CounterVec
 
 	runnersCount       *prometheus.GaugeVec
 	runnerThreadPanics prometheus.Counter
@@ -136,6 +148,13 @@ func makeGlobalMetrics() (GlobalMetrics, *prometheus.Registry) {
 				[]string{directionLabel},
 			)),
 		},
+		schedulerVersionSkew: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_agent_scheduler_plugin_version_skew_total",
+				Help: "Number of responses from the scheduler plugin with unsupported versions, by peer version and action",
+			},
+			[]string{"peer_version", "action"},
+		)),
 		// ---- scaling denies related metrics ----
 		scalingFullDeniesTotal: util.RegisterMetric(reg, prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -198,6 +217,7 @@ func makeGlobalMetrics() (GlobalMetrics, *prometheus.Registry) {
 				[]string{directionLabel},
 			)),
 		},
+		monitorProtocol: protometrics.New(reg, "autoscaling_agent_monitor_protocol"),
 
 		// ---- NEONVM ----
 		neonvmRequestsOutbound: util.RegisterMetric(reg, prometheus.NewCounterVec(
@@ -225,6 +245,51 @@ func makeGlobalMetrics() (GlobalMetrics, *prometheus.Registry) {
 				[]string{directionLabel},
 			)),
 		},
+		neonvmBudgetRefusals: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "autoscaling_agent_neonvm_node_memory_budget_refusals_total",
+				Help: "Number of NeonVM upscale requests refused because they would exceed the node's memory budget",
+			},
+		)),
+
+		// ---- SCALING WEBHOOKS ----
+		scalingWebhookRequests: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_agent_scaling_webhook_requests_total",
+				Help: "Number of attempted HTTP requests to VMs' scaling webhooks by autoscaler-agents",
+			},
+			[]string{"webhook", "code"},
+		)),
+
+		// ---- API SERVER HEALTH ----
+		apiDegraded: util.RegisterMetric(reg, prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_agent_api_degraded",
+				Help: "Whether the API server is considered degraded, so non-critical writes are deferred",
+			},
+		)),
+		deferredWrites: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_agent_deferred_writes_total",
+				Help: "Number of non-critical writes deferred because the API server was degraded",
+			},
+			[]string{"kind"},
+		)),
+
+		// ---- FALLBACK SCHEDULING ----
+		fallbackSchedulingActive: util.RegisterMetric(reg, prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_agent_fallback_scheduling_active",
+				Help: "Whether the autoscaler-agent is placing pods on its node because the scheduler is unavailable",
+			},
+		)),
+		fallbackScheduledPods: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_agent_fallback_scheduled_pods_total",
+				Help: "Number of attempts by the autoscaler-agent to place pods on its node while the scheduler is unavailable",
+			},
+			[]string{"outcome"},
+		)),
 
 		// ---- RUNNER LIFECYCLE ----
 		runnersCount: util.RegisterMetric(reg, prometheus.NewGaugeVec(
@@ -354,11 +419,13 @@ type PerVMMetrics struct {
 	activeMu  sync.Mutex
 	activeVMs map[util.NamespacedName]vmMetadata
 
-	cpu          *prometheus.GaugeVec
-	memory       *prometheus.GaugeVec
-	restartCount *prometheus.GaugeVec
-	desiredCU    *prometheus.GaugeVec
-	extraIP      *prometheus.GaugeVec
+	cpu            *prometheus.GaugeVec
+	memory         *prometheus.GaugeVec
+	restartCount   *prometheus.GaugeVec
+	desiredCU      *prometheus.GaugeVec
+	extraIP        *prometheus.GaugeVec
+	scalingFrozen  *prometheus.GaugeVec
+	startupBoostCU *prometheus.GaugeVec
 }
 
 type vmMetadata struct {
@@ -421,10 +488,10 @@ func makePerVMMetrics() (*PerVMMetrics, *prometheus.Registry) {
 		desiredCU: util.RegisterMetric(reg, prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "autoscaling_vm_desired_cu",
-				Help: "Amount of Compute Units desired for a VM: the total, and the components for cpu, memory, and LFC",
+				Help: "Amount of Compute Units desired for a VM: the total, and the components for cpu, memory, LFC, and extra metrics",
 			},
 			makeLabels(
-				"component", // desired CU component: total, cpu, mem, lfc
+				"component", // desired CU component: total, cpu, mem, lfc, extra
 			),
 		)),
 		extraIP: util.RegisterMetric(reg, prometheus.NewGaugeVec(
@@ -434,6 +501,20 @@ func makePerVMMetrics() (*PerVMMetrics, *prometheus.Registry) {
 			},
 			makeLabels(),
 		)),
+		scalingFrozen: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_vm_scaling_frozen",
+				Help: "Whether autoscaling is frozen for the VM, by its .spec.scalingFrozen",
+			},
+			makeLabels(),
+		)),
+		startupBoostCU: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_vm_startup_boost_cu",
+				Help: "Amount of Compute Units above its minimum that a VM is currently boosted by, after starting",
+			},
+			makeLabels(),
+		)),
 	}
 
 	return metrics, reg
@@ -472,6 +553,10 @@ func (m *PerVMMetrics) deleteActive(vm *vmv1.VirtualMachine) {
 		"vm_namespace": vm.Namespace,
 		"vm_name":      vm.Name,
 	})
+	m.startupBoostCU.DeletePartialMatch(prometheus.Labels{
+		"vm_namespace": vm.Namespace,
+		"vm_name":      vm.Name,
+	})
 }
 
 // vmMetric is a data object that represents a single metric
@@ -506,6 +591,7 @@ func (m *PerVMMetrics) updateDesiredCU(
 		{"cpu", parts.CPU},
 		{"mem", parts.Mem},
 		{"lfc", parts.LFC},
+		{"extra", parts.Extra},
 	}
 
 	for _, p := range pairs {
@@ -523,3 +609,22 @@ func (m *PerVMMetrics) updateDesiredCU(
 		}
 	}
 }
+
+func (m *PerVMMetrics) updateStartupBoostCU(vm util.NamespacedName, cuMultiplier float64, boostCU uint32) {
+	m.activeMu.Lock()
+	defer m.activeMu.Unlock()
+
+	// Like updateDesiredCU, don't do anything if this VM is not known.
+	info, ok := m.activeVMs[vm]
+	if !ok {
+		return
+	}
+
+	labels := prometheus.Labels{
+		"vm_namespace": vm.Namespace,
+		"vm_name":      vm.Name,
+		"endpoint_id":  info.endpointID,
+		"project_id":   info.projectID,
+	}
+	m.startupBoostCU.With(labels).Set(float64(boostCU) * cuMultiplier)
+}
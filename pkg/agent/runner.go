@@ -30,6 +30,7 @@ import (
 
 	"go.uber.org/zap"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ktypes "k8s.io/apimachinery/pkg/types"
 
@@ -40,6 +41,8 @@ import (
 	"github.com/neondatabase/autoscaling/pkg/agent/scalingevents"
 	"github.com/neondatabase/autoscaling/pkg/agent/schedwatch"
 	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/api/cuarith"
+	"github.com/neondatabase/autoscaling/pkg/api/protometrics"
 	"github.com/neondatabase/autoscaling/pkg/util"
 	"github.com/neondatabase/autoscaling/pkg/util/patch"
 )
@@ -49,7 +52,7 @@ import (
 //
 // Currently, each autoscaler-agent supports only one version at a time. In the future, this may
 // change.
-const PluginProtocolVersion api.PluginProtoVersion = api.PluginProtoV5_0
+const PluginProtocolVersion api.PluginProtoVersion = api.PluginProtoV5_2
 
 // Runner is per-VM Pod god object responsible for handling everything
 //
@@ -70,6 +73,9 @@ type Runner struct {
 	podIP   string
 
 	memSlotSize api.Bytes
+	// computeUnit is the Compute Unit for the VM, selected from the shared ComputeUnitConfig by the
+	// VM's family when the Runner is created.
+	computeUnit api.Resources
 
 	// lock guards the values of all mutable fields - namely, scheduler and monitor (which may be
 	// read without the lock, but the lock must be acquired to lock them).
@@ -79,6 +85,18 @@ type Runner struct {
 	// "executor"
 	executorStateDump func() executor.StateDump
 
+	// lastNeonVMRequest is the time of the last successful request to NeonVM, used to determine
+	// whether the VM's size before the next request was its steady-state size.
+	//
+	// This field is only accessed by the NeonVM executor, so doesn't require holding lock.
+	lastNeonVMRequest time.Time
+
+	// confirmed is the resources that the VM was most recently patched to use, which are sent to
+	// the scheduler plugin to confirm the upscaling it reserved for the VM.
+	//
+	// It's set by the NeonVM executor and read by the plugin executor.
+	confirmed atomic.Pointer[api.Resources]
+
 	// monitor, if non nil, stores the current Dispatcher in use for communicating with the
 	// vm-monitor, alongside a generation number.
 	//
@@ -193,25 +211,43 @@ func (r *Runner) Run(ctx context.Context, logger *zap.Logger, vmInfoUpdated util
 	coreExecLogger := execLogger.Named("core")
 
 	vmInfo := getVmInfo()
+	coldStartAt := func() *time.Time {
+		r.status.mu.Lock()
+		defer r.status.mu.Unlock()
+		return r.status.coldStartAt
+	}()
 	var initialRevision int64
 	if vmInfo.CurrentRevision != nil {
 		initialRevision = vmInfo.CurrentRevision.Value
 	}
+	var scalingWebhookConfig *core.ScalingWebhookConfig
+	if cfg := r.global.config.Scaling.Webhooks; cfg != nil {
+		scalingWebhookConfig = &core.ScalingWebhookConfig{
+			ResponseValidPeriod: time.Second * time.Duration(cfg.ResponseValidSeconds),
+			RetryWait:           time.Second * time.Duration(cfg.RetryFailedRequestSeconds),
+		}
+	}
 	// "dsrl" stands for "desired scaling report limiter" -- helper to avoid spamming events.
 	dsrl := &desiredScalingReportLimiter{lastEvent: nil}
 	revisionSource := revsource.NewRevisionSource(initialRevision, WrapHistogramVec(&r.global.metrics.scalingLatency))
 	executorCore := executor.NewExecutorCore(coreExecLogger, vmInfo, executor.Config{
 		OnNextActions: r.global.metrics.runnerNextActions.Inc,
 		Core: core.Config{
-			ComputeUnit:                        r.global.config.Scaling.ComputeUnit,
+			ComputeUnit:                        r.computeUnit,
 			DefaultScalingConfig:               r.global.config.Scaling.DefaultConfig,
 			NeonVMRetryWait:                    time.Second * time.Duration(r.global.config.NeonVM.RetryFailedRequestSeconds),
 			PluginRequestTick:                  time.Second*time.Duration(r.global.config.Scheduler.RequestAtLeastEverySeconds) - pluginRequestJitter,
 			PluginRetryWait:                    time.Second * time.Duration(r.global.config.Scheduler.RetryFailedRequestSeconds),
 			PluginDeniedRetryWait:              time.Second * time.Duration(r.global.config.Scheduler.RetryDeniedUpscaleSeconds),
+			PluginPreapprovalHeadroomCU:        r.global.config.Scheduler.PreapprovalHeadroomCU,
+			ScalingPriority:                    r.global.config.Scaling.Priority,
 			MonitorDeniedDownscaleCooldown:     time.Second * time.Duration(r.global.config.Monitor.RetryDeniedDownscaleSeconds),
 			MonitorRequestedUpscaleValidPeriod: time.Second * time.Duration(r.global.config.Monitor.RequestedUpscaleValidSeconds),
 			MonitorRetryWait:                   time.Second * time.Duration(r.global.config.Monitor.RetryFailedRequestSeconds),
+			ExtraMetricsMergeRules:             r.global.config.Metrics.MergeRules,
+			ExtraMetricsTargets:                r.global.config.Metrics.Targets,
+			ColdStartAt:                        coldStartAt,
+			ScalingWebhook:                     scalingWebhookConfig,
 			Log: core.LogConfig{
 				Info: coreExecLogger.Info,
 				Warn: coreExecLogger.Warn,
@@ -224,11 +260,13 @@ func (r *Runner) Run(ctx context.Context, logger *zap.Logger, vmInfoUpdated util
 				ActualScaling:  r.reportScalingEvent,
 				HypotheticalScaling: func(ts time.Time, current, target uint32, parts core.ScalingGoalParts) {
 					r.reportDesiredScaling(dsrl, ts, current, target, scalingevents.GoalCUComponents{
-						CPU: parts.CPU,
-						Mem: parts.Mem,
-						LFC: parts.LFC,
+						CPU:   parts.CPU,
+						Mem:   parts.Mem,
+						LFC:   parts.LFC,
+						Extra: parts.Extra,
 					})
 				},
+				StartupBoost: r.reportStartupBoost,
 			},
 		},
 	})
@@ -240,12 +278,14 @@ func (r *Runner) Run(ctx context.Context, logger *zap.Logger, vmInfoUpdated util
 	pluginIface := makePluginInterface(r)
 	neonvmIface := makeNeonVMInterface(r)
 	monitorIface := makeMonitorInterface(r, executorCore, monitorGeneration)
+	scalingWebhookIface := makeScalingWebhookInterface(r)
 
 	// "ecwc" stands for "ExecutorCoreWithClients"
 	ecwc := executorCore.WithClients(executor.ClientSet{
-		Plugin:  pluginIface,
-		NeonVM:  neonvmIface,
-		Monitor: monitorIface,
+		Plugin:         pluginIface,
+		NeonVM:         neonvmIface,
+		Monitor:        monitorIface,
+		ScalingWebhook: scalingWebhookIface,
 	})
 
 	logger.Info("Starting background workers")
@@ -305,6 +345,25 @@ func (r *Runner) Run(ctx context.Context, logger *zap.Logger, vmInfoUpdated util
 			},
 		)
 	})
+	for _, extra := range r.global.config.Metrics.Extra {
+		r.spawnBackgroundWorker(ctx, logger, fmt.Sprintf("get %s metrics", extra.Name), func(ctx2 context.Context, logger2 *zap.Logger) {
+			getMetricsLoop(
+				r,
+				ctx2,
+				logger2,
+				extra.MetricsSourceConfig,
+				metricsMgr[*core.ExtraMetrics]{
+					kind:         extra.Name,
+					emptyMetrics: func() *core.ExtraMetrics { return core.NewExtraMetrics(extra.Name, extra.Metrics) },
+					isActive:     func() bool { return true },
+					updateMetrics: func(metrics *core.ExtraMetrics, withLock func()) {
+						metrics.ExpiresAt = time.Now().Add(extra.MaxAge())
+						ecwc.Updater().UpdateExtraMetrics(*metrics, withLock)
+					},
+				},
+			)
+		})
+	}
 	r.spawnBackgroundWorker(ctx, logger.Named("vm-monitor"), "vm-monitor reconnection loop", func(ctx2 context.Context, logger2 *zap.Logger) {
 		r.connectToMonitorLoop(ctx2, logger2, monitorGeneration, monitorStateCallbacks{
 			reset: func(withLock func()) {
@@ -323,6 +382,7 @@ func (r *Runner) Run(ctx context.Context, logger *zap.Logger, vmInfoUpdated util
 	r.spawnBackgroundWorker(ctx, execLogger.Named("neonvm"), "executor: neonvm", ecwc.DoNeonVMRequests)
 	r.spawnBackgroundWorker(ctx, execLogger.Named("vm-monitor-downscale"), "executor: vm-monitor downscale", ecwc.DoMonitorDownscales)
 	r.spawnBackgroundWorker(ctx, execLogger.Named("vm-monitor-upscale"), "executor: vm-monitor upscale", ecwc.DoMonitorUpscales)
+	r.spawnBackgroundWorker(ctx, execLogger.Named("scaling-webhook"), "executor: scaling webhook", ecwc.DoScalingWebhookRequests)
 
 	// Note: Run doesn't terminate unless the parent context is cancelled - either because the VM
 	// pod was deleted, or the autoscaler-agent is exiting.
@@ -346,6 +406,8 @@ func (r *Runner) reportScalingEvent(timestamp time.Time, currentCU, targetCU uin
 		currentCU,
 		targetCU,
 	))
+
+	r.global.scalingStats.ObserveActual(timestamp, endpointID, currentCU, targetCU)
 }
 
 func (r *Runner) reportDesiredScaling(
@@ -366,6 +428,8 @@ func (r *Runner) reportDesiredScaling(
 		parts,
 	)
 
+	r.global.scalingStats.ObserveDesired(timestamp, endpointID, currentCU, targetCU)
+
 	rl.report(r.global.scalingReporter, r.global.scalingReporter.NewHypotheticalEvent(
 		timestamp,
 		endpointID,
@@ -375,6 +439,19 @@ func (r *Runner) reportDesiredScaling(
 	))
 }
 
+func (r *Runner) reportStartupBoost(timestamp time.Time, boostCU uint32) {
+	r.status.update(r.global, func(stat podStatus) podStatus {
+		stat.startupBoostCU = boostCU
+		return stat
+	})
+
+	r.global.vmMetrics.updateStartupBoostCU(
+		r.vmName,
+		r.global.config.ScalingEvents.CUMultiplier, // have to multiply before exposing as metrics here.
+		boostCU,
+	)
+}
+
 type desiredScalingReportLimiter struct {
 	lastEvent *scalingevents.ScalingEvent
 }
@@ -471,7 +548,7 @@ func (r *Runner) spawnBackgroundWorker(ctx context.Context, logger *zap.Logger,
 
 type metricsMgr[M core.FromPrometheus] struct {
 	// kind is the human-readable name representing this type of metrics.
-	// It's either "system" or "LFC".
+	// It's either "system", "LFC", or the name of an extra metrics source.
 	kind string
 
 	// emptyMetrics returns a new M
@@ -689,7 +766,11 @@ func doMetricsRequest(
 	metrics core.FromPrometheus,
 	config MetricsSourceConfig,
 ) error {
-	url := fmt.Sprintf("http://%s:%d/metrics", r.podIP, config.Port)
+	path := config.Path
+	if path == "" {
+		path = "/metrics"
+	}
+	url := fmt.Sprintf("http://%s:%d%s", r.podIP, config.Port, path)
 
 	timeout := time.Second * time.Duration(config.RequestTimeoutSeconds)
 	reqCtx, cancel := context.WithTimeout(ctx, timeout)
@@ -723,9 +804,13 @@ func doMetricsRequest(
 
 func (r *Runner) doNeonVMRequest(
 	ctx context.Context,
-	target api.Resources,
+	current, target api.Resources,
 	targetRevision vmv1.RevisionWithTime,
 ) error {
+	now := time.Now()
+
+	memSlots, _ := cuarith.SlotsFromBytes(target.Mem, r.memSlotSize, math.MaxUint32)
+
 	patches := []patch.Operation{{
 		Op:    patch.OpReplace,
 		Path:  "/spec/guest/cpus/use",
@@ -733,27 +818,84 @@ func (r *Runner) doNeonVMRequest(
 	}, {
 		Op:    patch.OpReplace,
 		Path:  "/spec/guest/memorySlots/use",
-		Value: uint32(target.Mem / r.memSlotSize),
+		Value: uint32(memSlots),
 	}, {
 		Op:    patch.OpReplace,
 		Path:  "/spec/targetRevision",
 		Value: targetRevision,
 	}}
 
-	patchPayload, err := json.Marshal(patches)
-	if err != nil {
-		panic(fmt.Errorf("Error marshalling JSON patch: %w", err))
+	// If the VM has been at its current size for long enough, record that as its steady state, so
+	// that it can be restarted at that size.
+	steadyStateDuration := time.Second * time.Duration(r.global.config.NeonVM.SteadyStateSeconds)
+	isSteadyState := steadyStateDuration != 0 &&
+		!r.lastNeonVMRequest.IsZero() &&
+		now.Sub(r.lastNeonVMRequest) >= steadyStateDuration
+	// Recording the steady state is nice to have, but not necessary for scaling, so we skip it if
+	// the API server is degraded. It'll be recorded once the VM is steady again.
+	if isSteadyState && r.global.apiHealth.Degraded() {
+		r.global.metrics.deferredWrites.WithLabelValues("steady-state-annotation").Inc()
+		isSteadyState = false
+	}
+	if isSteadyState {
+		steadyStateJSON, err := json.Marshal(current)
+		if err != nil {
+			panic(fmt.Errorf("Error marshalling JSON: %w", err))
+		}
+		patches = append(patches, patch.Operation{
+			Op: patch.OpAdd,
+			Path: fmt.Sprintf(
+				"/metadata/annotations/%s",
+				patch.PathEscape(api.AnnotationSteadyStateResources),
+			),
+			Value: string(steadyStateJSON),
+		})
 	}
 
 	timeout := time.Second * time.Duration(r.global.config.NeonVM.RequestTimeoutSeconds)
 	requestCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	// FIXME: We should check the returned VM object here, in case the values are different.
-	//
-	// Also relevant: <https://github.com/neondatabase/autoscaling/issues/23>
-	_, err = r.global.vmClient.NeonvmV1().VirtualMachines(r.vmName.Namespace).
-		Patch(requestCtx, r.vmName.Name, ktypes.JSONPatchType, patchPayload, metav1.PatchOptions{})
+	doPatch := func(patches []patch.Operation) error {
+		patchPayload, err := json.Marshal(patches)
+		if err != nil {
+			panic(fmt.Errorf("Error marshalling JSON patch: %w", err))
+		}
+
+		// FIXME: We should check the returned VM object here, in case the values are different.
+		//
+		// Also relevant: <https://github.com/neondatabase/autoscaling/issues/23>
+		start := time.Now()
+		_, err = r.global.vmClient.NeonvmV1().VirtualMachines(r.vmName.Namespace).
+			Patch(requestCtx, r.vmName.Name, ktypes.JSONPatchType, patchPayload, metav1.PatchOptions{})
+		r.global.apiHealth.Observe(time.Since(start), err)
+		return err
+	}
+
+	err := doPatch(patches)
+	// If the VM has no annotations at all, adding the steady state annotation fails because the
+	// 'annotations' field doesn't exist. The scheduler plugin usually adds its own annotations
+	// first, so rather than always checking, we only retry -- creating the field only if it's
+	// still missing -- when the first attempt was rejected.
+	if err != nil && isSteadyState && apierrors.IsInvalid(err) {
+		addPatches := append([]patch.Operation{
+			{
+				Op:    patch.OpTest,
+				Path:  "/metadata/annotations",
+				Value: (*struct{})(nil), // typed nil, so that it shows up as 'null'
+			},
+			{
+				Op:    patch.OpAdd,
+				Path:  "/metadata/annotations",
+				Value: struct{}{},
+			},
+		}, patches...)
+		// If the test failed, the annotations already existed, so the original error is the
+		// relevant one.
+		if retryErr := doPatch(addPatches); retryErr == nil || !apierrors.IsInvalid(retryErr) {
+			err = retryErr
+		}
+	}
 	if err != nil {
 		errMsg := util.RootError(err).Error()
 		// Some error messages contain the object name. We could try to filter them all out, but
@@ -764,6 +906,8 @@ func (r *Runner) doNeonVMRequest(
 	}
 
 	r.global.metrics.neonvmRequestsOutbound.WithLabelValues("ok").Inc()
+	r.lastNeonVMRequest = now
+	r.confirmed.Store(&target)
 	return nil
 }
 
@@ -809,7 +953,7 @@ func doMonitorDownscale(
 
 	timeout := time.Second * time.Duration(r.global.config.Monitor.ResponseTimeoutSeconds)
 
-	res, err := dispatcher.Call(ctx, logger, timeout, "DownscaleRequest", api.DownscaleRequest{
+	res, err := dispatcher.Call(ctx, logger, timeout, protometrics.MessageDownscaleRequest, api.DownscaleRequest{
 		Target: rawResources,
 	})
 	if err != nil {
@@ -830,7 +974,7 @@ func doMonitorUpscale(
 
 	timeout := time.Second * time.Duration(r.global.config.Monitor.ResponseTimeoutSeconds)
 
-	_, err := dispatcher.Call(ctx, logger, timeout, "UpscaleNotification", api.UpscaleNotification{
+	_, err := dispatcher.Call(ctx, logger, timeout, protometrics.MessageUpscaleNotification, api.UpscaleNotification{
 		Granted: rawResources,
 	})
 	return err
@@ -842,15 +986,18 @@ func (r *Runner) DoSchedulerRequest(
 	logger *zap.Logger,
 	resources api.Resources,
 	lastPermit *api.Resources,
+	preapproval *api.Resources,
 	metrics *api.Metrics,
 ) (_ *api.PluginResponse, err error) {
 	reqData := &api.AgentRequest{
 		ProtoVersion: PluginProtocolVersion,
 		Pod:          r.podName,
-		ComputeUnit:  r.global.config.Scaling.ComputeUnit,
+		ComputeUnit:  r.computeUnit,
 		Resources:    resources,
 		LastPermit:   lastPermit,
 		Metrics:      metrics,
+		Preapproval:  preapproval,
+		Confirmed:    r.confirmed.Load(),
 	}
 
 	// make sure we log any error we're returning:
@@ -884,6 +1031,7 @@ func (r *Runner) DoSchedulerRequest(
 		return nil, fmt.Errorf("Error building request to %q: %w", url, err)
 	}
 	request.Header.Set("content-type", "application/json")
+	api.SetComponentVersionHeader(request.Header, api.ComponentAutoscalerAgent)
 
 	logger.Debug("Sending request to scheduler", zap.Any("request", reqData))
 
@@ -897,6 +1045,16 @@ func (r *Runner) DoSchedulerRequest(
 
 	r.global.metrics.schedulerRequests.WithLabelValues(strconv.Itoa(response.StatusCode)).Inc()
 
+	if skew := api.CheckPeerVersionHeader(
+		api.ComponentAutoscalerAgent, api.ComponentSchedulerPlugin, response.Header,
+	); skew != nil {
+		r.global.metrics.schedulerVersionSkew.WithLabelValues(skew.PeerVersion.String(), skew.Action()).Inc()
+		if skew.Refuse {
+			return nil, fmt.Errorf("Refusing response from incompatible scheduler: %w", skew)
+		}
+		logger.Warn("Received response from scheduler with unsupported version skew", zap.Error(skew))
+	}
+
 	respBody, err := io.ReadAll(response.Body)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading body for response: %w", err)
@@ -921,3 +1079,65 @@ func (r *Runner) DoSchedulerRequest(
 
 	return &respData, nil
 }
+
+// doScalingWebhookRequest sends the request to the scaling webhook with the given name, returning
+// its response.
+func (r *Runner) doScalingWebhookRequest(
+	ctx context.Context,
+	logger *zap.Logger,
+	webhook string,
+	reqData api.ScalingWebhookRequest,
+) (*api.ScalingWebhookResponse, error) {
+	cfg := r.global.config.Scaling.Webhooks
+	if cfg == nil {
+		panic(errors.New("scaling webhook request made without .scaling.webhooks config"))
+	}
+	url, ok := cfg.URLs[webhook]
+	if !ok {
+		r.global.metrics.scalingWebhookRequests.WithLabelValues(webhook, "[unknown webhook]").Inc()
+		return nil, fmt.Errorf("unknown scaling webhook %q", webhook)
+	}
+
+	reqBody, err := json.Marshal(reqData)
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding request JSON: %w", err)
+	}
+
+	timeout := time.Second * time.Duration(cfg.RequestTimeoutSeconds)
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(reqCtx, http.MethodPost, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("Error building request to %q: %w", url, err)
+	}
+	request.Header.Set("content-type", "application/json")
+
+	logger.Debug("Sending request to scaling webhook", zap.String("webhook", webhook), zap.Any("request", reqData))
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		description := fmt.Sprintf("[error doing request: %s]", util.RootError(err))
+		r.global.metrics.scalingWebhookRequests.WithLabelValues(webhook, description).Inc()
+		return nil, fmt.Errorf("Error doing request: %w", err)
+	}
+	defer response.Body.Close()
+
+	r.global.metrics.scalingWebhookRequests.WithLabelValues(webhook, strconv.Itoa(response.StatusCode)).Inc()
+
+	respBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading body for response: %w", err)
+	}
+
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("Received response status %d body %q", response.StatusCode, string(respBody))
+	}
+
+	var respData api.ScalingWebhookResponse
+	if err := json.Unmarshal(respBody, &respData); err != nil {
+		return nil, fmt.Errorf("Bad JSON response: %w", err)
+	}
+
+	return &respData, nil
+}
@@ -50,6 +50,9 @@ type GoalCUComponents struct {
 	CPU *float64 `json:"cpu,omitempty"`
 	Mem *float64 `json:"mem,omitempty"`
 	LFC *float64 `json:"lfc,omitempty"`
+	// Extra is the goal CU from the metrics fetched from the autoscaler-agent's additional
+	// metrics sources, if any have targets configured.
+	Extra *float64 `json:"extra,omitempty"`
 }
 
 type scalingEventKind string
@@ -145,9 +148,10 @@ func (r *Reporter) NewHypotheticalEvent(
 		CurrentMilliCU: convertToMilliCU(currentCU, r.conf.CUMultiplier),
 		TargetMilliCU:  convertToMilliCU(targetCU, r.conf.CUMultiplier),
 		GoalComponents: &GoalCUComponents{
-			CPU: convertFloat(goalCUs.CPU),
-			Mem: convertFloat(goalCUs.Mem),
-			LFC: convertFloat(goalCUs.LFC),
+			CPU:   convertFloat(goalCUs.CPU),
+			Mem:   convertFloat(goalCUs.Mem),
+			LFC:   convertFloat(goalCUs.LFC),
+			Extra: convertFloat(goalCUs.Extra),
 		},
 	}
 }
@@ -0,0 +1,281 @@
+package scalingstats
+
+// Aggregation of each VM's scaling into daily statistics, for product usage analytics.
+//
+// This is separate from billing and scaling events: instead of reporting each change as it
+// happens, we accumulate the time spent at each size (and how much time the VM spent wanting to be
+// bigger than it was) over each UTC day, and report a single record per VM per day.
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/reporting"
+)
+
+type Config struct {
+	// CUMultiplier sets the ratio between our internal compute unit and the one that should be
+	// reported.
+	//
+	// This has the same meaning as the equivalent field for scaling events.
+	CUMultiplier float64 `json:"cuMultiplier"`
+
+	// RegionName is the name of the region that the reporting autoscaler-agent is in.
+	RegionName string `json:"regionName"`
+
+	// FlushGraceSeconds gives the duration, in seconds, after the end of each UTC day that we
+	// wait before reporting the stats for VMs we haven't heard from since the previous day.
+	FlushGraceSeconds uint `json:"flushGraceSeconds"`
+
+	Clients ClientsConfig `json:"clients"`
+}
+
+// DailyStats is the summary of a single VM's scaling over a single UTC day.
+//
+// VMs that move between autoscaler-agents during the day (e.g. due to live migration) will have
+// multiple records for the same day, which should be summed.
+type DailyStats struct {
+	// Date is the UTC day that the stats cover, in YYYY-MM-DD format.
+	Date       string `json:"date"`
+	Region     string `json:"region"`
+	EndpointID string `json:"endpoint_id"`
+
+	// SecondsAtMilliCU gives the number of seconds that the VM spent at each size, keyed by the
+	// size in thousandths of a Compute Unit.
+	SecondsAtMilliCU map[uint32]float64 `json:"seconds_at_milli_cu"`
+	// Upscales and Downscales count the number of times that the VM was scaled in each direction.
+	Upscales   uint `json:"upscales"`
+	Downscales uint `json:"downscales"`
+	// ThrottledSeconds is the number of seconds that the VM wanted to be larger than it was.
+	ThrottledSeconds float64 `json:"throttled_seconds"`
+}
+
+type Aggregator struct {
+	conf    *Config
+	sink    *reporting.EventSink[DailyStats]
+	metrics PromMetrics
+
+	mu  sync.Mutex
+	vms map[string]*vmStats
+}
+
+// vmStats stores the in-progress stats for a single VM, on the day given by dayStart.
+type vmStats struct {
+	dayStart time.Time
+
+	// lastObserved is the time up until which we've accounted for the VM.
+	lastObserved time.Time
+	// currentCU is the size of the VM at lastObserved.
+	currentCU uint32
+	// throttled is whether the VM wanted to be larger than currentCU at lastObserved.
+	throttled bool
+
+	secondsAtCU      map[uint32]float64
+	upscales         uint
+	downscales       uint
+	throttledSeconds float64
+}
+
+func NewAggregator(
+	ctx context.Context,
+	parentLogger *zap.Logger,
+	conf *Config,
+	metrics PromMetrics,
+) (*Aggregator, error) {
+	logger := parentLogger.Named("scalingstats")
+
+	clients, err := createClients(ctx, logger, conf.Clients)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := reporting.NewEventSink(logger, metrics.reporting, clients...)
+
+	return &Aggregator{
+		conf:    conf,
+		sink:    sink,
+		metrics: metrics,
+		mu:      sync.Mutex{},
+		vms:     make(map[string]*vmStats),
+	}, nil
+}
+
+// Run periodically reports the stats for VMs that we haven't heard from since the previous day,
+// and calls the underlying reporting.EventSink's Run() method, until the context expires.
+func (a *Aggregator) Run(ctx context.Context) error {
+	go a.runFlusher(ctx)
+
+	if err := a.sink.Run(ctx); err != nil {
+		return fmt.Errorf("scaling stats sink failed: %w", err)
+	}
+	return nil
+}
+
+func (a *Aggregator) runFlusher(ctx context.Context) {
+	grace := time.Second * time.Duration(a.conf.FlushGraceSeconds)
+	for {
+		now := time.Now()
+		next := startOfDay(now).Add(24 * time.Hour).Add(grace)
+		if now.Before(startOfDay(now).Add(grace)) {
+			next = startOfDay(now).Add(grace)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+
+		a.flushStale(time.Now())
+	}
+}
+
+// flushStale reports and removes the stats for all VMs that were last observed before the
+// current day.
+//
+// VMs that are still running are expected to have been observed since the start of the day. The
+// rest are either gone from this node, or will start accumulating from scratch the next time
+// they're observed.
+func (a *Aggregator) flushStale(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	today := startOfDay(now)
+	for endpointID, vm := range a.vms {
+		if vm.dayStart.Before(today) {
+			a.emit(endpointID, vm)
+			delete(a.vms, endpointID)
+		}
+	}
+	a.metrics.trackedVMs.Set(float64(len(a.vms)))
+}
+
+// ObserveActual records that the VM is being scaled from currentCU to targetCU.
+//
+// A nil *Aggregator ignores all observations.
+func (a *Aggregator) ObserveActual(timestamp time.Time, endpointID string, currentCU, targetCU uint32) {
+	if a == nil || endpointID == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	vm := a.advance(timestamp, endpointID, currentCU)
+	switch {
+	case targetCU > currentCU:
+		vm.upscales += 1
+	case targetCU < currentCU:
+		vm.downscales += 1
+	}
+	vm.currentCU = targetCU
+}
+
+// ObserveDesired records that the VM, currently at currentCU, would like to be at targetCU.
+//
+// A nil *Aggregator ignores all observations.
+func (a *Aggregator) ObserveDesired(timestamp time.Time, endpointID string, currentCU, targetCU uint32) {
+	if a == nil || endpointID == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	vm := a.advance(timestamp, endpointID, currentCU)
+	vm.throttled = targetCU > currentCU
+}
+
+// advance accounts for the VM up until now, reporting the stats for any days that have ended in
+// the meantime, and returns the VM's stats, with currentCU set.
+//
+// NOTE: this method expects that the caller has acquired a.mu.
+func (a *Aggregator) advance(now time.Time, endpointID string, currentCU uint32) *vmStats {
+	vm, ok := a.vms[endpointID]
+	if !ok {
+		vm = newVMStats(now, currentCU)
+		a.vms[endpointID] = vm
+		a.metrics.trackedVMs.Set(float64(len(a.vms)))
+		return vm
+	}
+
+	for {
+		dayEnd := vm.dayStart.Add(24 * time.Hour)
+		if now.Before(dayEnd) {
+			break
+		}
+		vm.accumulate(dayEnd)
+		a.emit(endpointID, vm)
+
+		throttled := vm.throttled
+		*vm = *newVMStats(dayEnd, vm.currentCU)
+		// carry over whether the VM was throttled, because it's still accurate until we hear
+		// otherwise.
+		vm.throttled = throttled
+	}
+
+	vm.accumulate(now)
+	vm.currentCU = currentCU
+	return vm
+}
+
+// emit submits the stats for the VM to the sink.
+//
+// NOTE: this method expects that the caller has acquired a.mu.
+func (a *Aggregator) emit(endpointID string, vm *vmStats) {
+	secondsAtMilliCU := make(map[uint32]float64, len(vm.secondsAtCU))
+	for cu, seconds := range vm.secondsAtCU {
+		secondsAtMilliCU[convertToMilliCU(cu, a.conf.CUMultiplier)] += seconds
+	}
+
+	a.metrics.statsEmitted.Inc()
+	a.sink.Enqueue(DailyStats{
+		Date:             vm.dayStart.Format(time.DateOnly),
+		Region:           a.conf.RegionName,
+		EndpointID:       endpointID,
+		SecondsAtMilliCU: secondsAtMilliCU,
+		Upscales:         vm.upscales,
+		Downscales:       vm.downscales,
+		ThrottledSeconds: vm.throttledSeconds,
+	})
+}
+
+func newVMStats(now time.Time, currentCU uint32) *vmStats {
+	return &vmStats{
+		dayStart:         startOfDay(now),
+		lastObserved:     now,
+		currentCU:        currentCU,
+		throttled:        false,
+		secondsAtCU:      make(map[uint32]float64),
+		upscales:         0,
+		downscales:       0,
+		throttledSeconds: 0,
+	}
+}
+
+// accumulate adds the time from s.lastObserved until now to the stats.
+func (s *vmStats) accumulate(now time.Time) {
+	seconds := now.Sub(s.lastObserved).Seconds()
+	if seconds <= 0 {
+		return
+	}
+
+	s.secondsAtCU[s.currentCU] += seconds
+	if s.throttled {
+		s.throttledSeconds += seconds
+	}
+	s.lastObserved = now
+}
+
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func convertToMilliCU(cu uint32, multiplier float64) uint32 {
+	return uint32(math.Round(1000 * float64(cu) * multiplier))
+}
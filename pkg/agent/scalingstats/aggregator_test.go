@@ -0,0 +1,200 @@
+package scalingstats
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/reporting"
+)
+
+// recordingBatch is a reporting.BatchBuilder that keeps every event it's given.
+type recordingBatch struct {
+	mu     *sync.Mutex
+	events *[]DailyStats
+}
+
+func (b recordingBatch) Add(event DailyStats) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*b.events = append(*b.events, event)
+}
+
+func (b recordingBatch) Finish() []byte {
+	return nil
+}
+
+func newTestAggregator(multiplier float64) (*Aggregator, *[]DailyStats) {
+	var events []DailyStats
+	mu := &sync.Mutex{}
+
+	metrics := NewPromMetrics(prometheus.NewRegistry())
+	client := reporting.Client[DailyStats]{
+		Name: "test",
+		Base: nil,
+		BaseConfig: reporting.BaseClientConfig{
+			PushEverySeconds:          60,
+			PushRequestTimeoutSeconds: 10,
+			MaxBatchSize:              1000,
+		},
+		NewBatchBuilder: func() reporting.BatchBuilder[DailyStats] {
+			return recordingBatch{mu: mu, events: &events}
+		},
+	}
+
+	conf := &Config{
+		CUMultiplier:      multiplier,
+		RegionName:        "test-region",
+		FlushGraceSeconds: 60,
+		Clients:           ClientsConfig{}, //nolint:exhaustruct // no clients are created from the config
+	}
+
+	return &Aggregator{
+		conf:    conf,
+		sink:    reporting.NewEventSink(zap.NewNop(), metrics.reporting, client),
+		metrics: metrics,
+		mu:      sync.Mutex{},
+		vms:     make(map[string]*vmStats),
+	}, &events
+}
+
+func TestAggregator(t *testing.T) {
+	day := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+
+	type observation struct {
+		at         time.Duration // since the start of day
+		desired    bool          // ObserveDesired instead of ObserveActual
+		endpointID string
+		currentCU  uint32
+		targetCU   uint32
+	}
+
+	cases := []struct {
+		name         string
+		multiplier   float64
+		observations []observation
+		// flushAt is the time, since the start of day, to flush any stale stats
+		flushAt  time.Duration
+		expected []DailyStats
+	}{
+		{
+			name:       "single day",
+			multiplier: 1,
+			observations: []observation{
+				{at: 1 * time.Hour, desired: false, endpointID: "ep-1", currentCU: 1, targetCU: 2},
+				{at: 3 * time.Hour, desired: false, endpointID: "ep-1", currentCU: 2, targetCU: 1},
+				{at: 4 * time.Hour, desired: false, endpointID: "ep-1", currentCU: 1, targetCU: 1},
+			},
+			flushAt: 24*time.Hour + time.Minute,
+			expected: []DailyStats{
+				{
+					Date:             "2024-06-03",
+					Region:           "test-region",
+					EndpointID:       "ep-1",
+					SecondsAtMilliCU: map[uint32]float64{2000: 7200, 1000: 3600},
+					Upscales:         1,
+					Downscales:       1,
+					ThrottledSeconds: 0,
+				},
+			},
+		},
+		{
+			name:       "across midnight",
+			multiplier: 1,
+			observations: []observation{
+				{at: 23 * time.Hour, desired: false, endpointID: "ep-1", currentCU: 1, targetCU: 1},
+				{at: 23 * time.Hour, desired: true, endpointID: "ep-1", currentCU: 1, targetCU: 2},
+				{at: 25 * time.Hour, desired: false, endpointID: "ep-1", currentCU: 1, targetCU: 2},
+			},
+			flushAt: 48*time.Hour + time.Minute,
+			expected: []DailyStats{
+				{
+					Date:             "2024-06-03",
+					Region:           "test-region",
+					EndpointID:       "ep-1",
+					SecondsAtMilliCU: map[uint32]float64{1000: 3600},
+					Upscales:         0,
+					Downscales:       0,
+					ThrottledSeconds: 3600,
+				},
+				{
+					// being throttled is carried over into the next day
+					Date:             "2024-06-04",
+					Region:           "test-region",
+					EndpointID:       "ep-1",
+					SecondsAtMilliCU: map[uint32]float64{1000: 3600},
+					Upscales:         1,
+					Downscales:       0,
+					ThrottledSeconds: 3600,
+				},
+			},
+		},
+		{
+			name:       "CU multiplier",
+			multiplier: 0.25,
+			observations: []observation{
+				{at: 0, desired: false, endpointID: "ep-1", currentCU: 4, targetCU: 4},
+				{at: 10 * time.Minute, desired: false, endpointID: "ep-1", currentCU: 4, targetCU: 4},
+			},
+			flushAt: 24*time.Hour + time.Minute,
+			expected: []DailyStats{
+				{
+					Date:             "2024-06-03",
+					Region:           "test-region",
+					EndpointID:       "ep-1",
+					SecondsAtMilliCU: map[uint32]float64{1000: 600},
+					Upscales:         0,
+					Downscales:       0,
+					ThrottledSeconds: 0,
+				},
+			},
+		},
+		{
+			name:       "not yet stale",
+			multiplier: 1,
+			observations: []observation{
+				{at: time.Hour, desired: false, endpointID: "ep-1", currentCU: 1, targetCU: 2},
+			},
+			flushAt:  2 * time.Hour,
+			expected: nil,
+		},
+		{
+			name:       "no endpoint ID",
+			multiplier: 1,
+			observations: []observation{
+				{at: time.Hour, desired: false, endpointID: "", currentCU: 1, targetCU: 2},
+				{at: 2 * time.Hour, desired: false, endpointID: "", currentCU: 2, targetCU: 1},
+			},
+			flushAt:  24*time.Hour + time.Minute,
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, events := newTestAggregator(c.multiplier)
+
+			for _, o := range c.observations {
+				if o.desired {
+					a.ObserveDesired(day.Add(o.at), o.endpointID, o.currentCU, o.targetCU)
+				} else {
+					a.ObserveActual(day.Add(o.at), o.endpointID, o.currentCU, o.targetCU)
+				}
+			}
+			a.flushStale(day.Add(c.flushAt))
+
+			assert.Equal(t, c.expected, *events)
+		})
+	}
+}
+
+func TestNilAggregator(t *testing.T) {
+	var a *Aggregator
+	// Should not panic
+	a.ObserveActual(time.Now(), "ep-1", 1, 2)
+	a.ObserveDesired(time.Now(), "ep-1", 1, 2)
+}
@@ -0,0 +1,94 @@
+package scalingstats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lithammer/shortuuid"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/reporting"
+)
+
+type ClientsConfig struct {
+	AzureBlob *AzureBlobStorageClientConfig `json:"azureBlob"`
+	S3        *S3ClientConfig               `json:"s3"`
+}
+
+type S3ClientConfig struct {
+	reporting.BaseClientConfig
+	reporting.S3ClientConfig
+	PrefixInBucket string `json:"prefixInBucket"`
+}
+
+type AzureBlobStorageClientConfig struct {
+	reporting.BaseClientConfig
+	reporting.AzureBlobStorageClientConfig
+	PrefixInContainer string `json:"prefixInContainer"`
+}
+
+type statsClient = reporting.Client[DailyStats]
+
+func createClients(ctx context.Context, logger *zap.Logger, cfg ClientsConfig) ([]statsClient, error) {
+	var clients []statsClient
+
+	if c := cfg.AzureBlob; c != nil {
+		generateKey := newBlobStorageKeyGenerator(c.PrefixInContainer)
+		client, err := reporting.NewAzureBlobStorageClient(c.AzureBlobStorageClientConfig, generateKey)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Azure Blob Storage client: %w", err)
+		}
+		logger.Info("Created Azure Blob Storage client for scaling stats", zap.Any("config", c))
+
+		clients = append(clients, statsClient{
+			Name:            "azureblob",
+			Base:            client,
+			BaseConfig:      c.BaseClientConfig,
+			NewBatchBuilder: jsonLinesBatch(reporting.NewGZIPBuffer),
+		})
+	}
+	if c := cfg.S3; c != nil {
+		generateKey := newBlobStorageKeyGenerator(c.PrefixInBucket)
+		client, err := reporting.NewS3Client(ctx, c.S3ClientConfig, generateKey)
+		if err != nil {
+			return nil, fmt.Errorf("error creating S3 client: %w", err)
+		}
+		logger.Info("Created S3 client for scaling stats", zap.Any("config", c))
+
+		clients = append(clients, statsClient{
+			Name:            "s3",
+			Base:            client,
+			BaseConfig:      c.BaseClientConfig,
+			NewBatchBuilder: jsonLinesBatch(reporting.NewGZIPBuffer),
+		})
+	}
+
+	return clients, nil
+}
+
+func jsonLinesBatch[B reporting.IOBuffer](buf func() B) func() reporting.BatchBuilder[DailyStats] {
+	return func() reporting.BatchBuilder[DailyStats] {
+		return reporting.NewJSONLinesBuilder[DailyStats](buf())
+	}
+}
+
+// Returns a function to generate keys for the placement of scaling stats data into blob storage.
+//
+// Example: prefix/2024/10/31/stats_{uuid}.ndjson.gz
+//
+// Stats are only produced once per day for each VM, so unlike scaling events, the key doesn't
+// include the hour.
+func newBlobStorageKeyGenerator(prefix string) func() string {
+	return func() string {
+		now := time.Now().UTC()
+		id := shortuuid.New()
+
+		return fmt.Sprintf(
+			"%s/%d/%02d/%02d/stats_%s.ndjson.gz",
+			prefix,
+			now.Year(), now.Month(), now.Day(),
+			id,
+		)
+	}
+}
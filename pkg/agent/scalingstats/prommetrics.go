@@ -0,0 +1,34 @@
+package scalingstats
+
+// Prometheus metrics for the agent's scaling statistics subsystem
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/neondatabase/autoscaling/pkg/reporting"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+type PromMetrics struct {
+	reporting    *reporting.EventSinkMetrics
+	trackedVMs   prometheus.Gauge
+	statsEmitted prometheus.Counter
+}
+
+func NewPromMetrics(reg prometheus.Registerer) PromMetrics {
+	return PromMetrics{
+		reporting: reporting.NewEventSinkMetrics("autoscaling_agent_scalingstats", reg),
+		trackedVMs: util.RegisterMetric(reg, prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_agent_scaling_stats_tracked_vms",
+				Help: "Number of VMs that scaling statistics are currently being accumulated for",
+			},
+		)),
+		statsEmitted: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "autoscaling_agent_scaling_stats_emitted_total",
+				Help: "Total number of daily per-VM scaling statistics records generated",
+			},
+		)),
+	}
+}
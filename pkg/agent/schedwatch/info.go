@@ -8,6 +8,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
+	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
@@ -16,6 +17,8 @@ type SchedulerInfo struct {
 	UID               types.UID
 	IP                string
 	CreationTimestamp time.Time
+	// Leader is true if the scheduler has marked itself as the leader, with api.LabelSchedulerLeader.
+	Leader bool
 }
 
 // MarshalLogObject implements zapcore.ObjectMarshaler
@@ -26,6 +29,7 @@ func (s SchedulerInfo) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("uid", string(s.UID))
 	enc.AddString("ip", string(s.IP))
 	enc.AddTime("creationTimestamp", s.CreationTimestamp)
+	enc.AddBool("leader", s.Leader)
 	return nil
 }
 
@@ -35,5 +39,6 @@ func newSchedulerInfo(pod *corev1.Pod) SchedulerInfo {
 		UID:               pod.UID,
 		IP:                pod.Status.PodIP,
 		CreationTimestamp: pod.CreationTimestamp.Time,
+		Leader:            pod.Labels[api.LabelSchedulerLeader] == "true",
 	}
 }
@@ -51,6 +51,8 @@ func StartSchedulerWatcher(
 	parentLogger *zap.Logger,
 	kubeClient *kubernetes.Clientset,
 	metrics watch.Metrics,
+	staleAfter *time.Duration,
+	onStale func(),
 	schedulerName string,
 ) (*SchedulerTracker, error) {
 	logger := parentLogger.Named("watch-schedulers")
@@ -76,6 +78,8 @@ func StartSchedulerWatcher(
 			// FIXME: make these configurable.
 			RetryRelistAfter: util.NewTimeRange(time.Second, 4, 5),
 			RetryWatchAfter:  util.NewTimeRange(time.Second, 4, 5),
+			StaleAfter:       staleAfter,
+			OnStale:          onStale,
 		},
 		watch.Accessors[*corev1.PodList, corev1.Pod]{
 			Items: func(list *corev1.PodList) []corev1.Pod { return list.Items },
@@ -98,6 +102,10 @@ func StartSchedulerWatcher(
 					info := newSchedulerInfo(newPod)
 					logger.Info("Existing scheduler became ready", zap.Object("scheduler", info))
 					sp.add(logger, &info)
+				} else if oldReady && newReady && newSchedulerInfo(oldPod).Leader != newSchedulerInfo(newPod).Leader {
+					info := newSchedulerInfo(newPod)
+					logger.Info("Existing scheduler leadership changed", zap.Object("scheduler", info))
+					sp.add(logger, &info)
 				} else if oldReady && !newReady {
 					info := newSchedulerInfo(newPod)
 					logger.Info("Existing scheduler no longer ready", zap.Object("scheduler", info))
@@ -144,11 +152,21 @@ func (s *schedPods) remove(logger *zap.Logger, pod *SchedulerInfo) {
 // s.mu MUST be exclusively locked while calling reconcile.
 func (s *schedPods) reconcile(logger *zap.Logger) {
 	var newCurrent *SchedulerInfo
-	// There's *basically* guaranteed to be ≤ 2 scheduler pods because the scheduler deployment has
-	// replicas=1, so "just" looping here is fine; it's not worth a more complex data structure.
+	// There's *basically* guaranteed to be only a few scheduler pods (≤ 2 with replicas=1, or one
+	// more than the number of replicas with leader election), so "just" looping here is fine; it's
+	// not worth a more complex data structure.
 	for _, pod := range s.pods {
-		// Use the pod if we don't already have one, or if it was created more recently than
-		// whatever we've seen so far.
+		// If the scheduler runs with leader election, only the leader handles our requests, so
+		// always prefer it over standby replicas.
+		if newCurrent != nil && newCurrent.Leader != pod.Leader {
+			if pod.Leader {
+				newCurrent = pod
+			}
+			continue
+		}
+
+		// Otherwise, use the pod if we don't already have one, or if it was created more recently
+		// than whatever we've seen so far.
 		// The ordering isn't *too* important here, but we need to pick one to be consistent, and
 		// preferring a newer scheduler (remember: the pod is 'Ready') is likely to be more correct.
 		if newCurrent == nil || newCurrent.CreationTimestamp.Before(pod.CreationTimestamp) {
@@ -9,12 +9,18 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	vmclient "github.com/neondatabase/autoscaling/neonvm/client/clientset/versioned"
@@ -23,6 +29,51 @@ import (
 	"github.com/neondatabase/autoscaling/pkg/util/watch"
 )
 
+// staleWatchSettings are the settings for detecting stale watch streams, shared by all of the
+// agent's watches.
+type staleWatchSettings struct {
+	staleAfter *time.Duration
+	// onStale is called with the name of the watch that was detected as stale. It may be nil.
+	onStale func(instance string)
+}
+
+// onStaleFor returns the watch.Config OnStale callback for the named watch.
+func (s staleWatchSettings) onStaleFor(instance string) func() {
+	if s.onStale == nil {
+		return nil
+	}
+	return func() { s.onStale(instance) }
+}
+
+func makeStaleWatchSettings(config *Config, kubeClient kubernetes.Interface, nodeName string) staleWatchSettings {
+	if config.WatchStaleTimeoutSeconds == 0 {
+		return staleWatchSettings{staleAfter: nil, onStale: nil}
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "autoscaler-agent", Host: nodeName})
+
+	// Our watches aren't about any particular object, so we attach the Events to the Node that
+	// this autoscaler-agent is responsible for.
+	nodeRef := &corev1.ObjectReference{ //nolint:exhaustruct // only the fields needed to refer to a Node
+		Kind:       "Node",
+		APIVersion: "v1",
+		Name:       nodeName,
+		UID:        types.UID(nodeName), // matches kubelet, which uses the node name as the UID for events.
+	}
+
+	return staleWatchSettings{
+		staleAfter: lo.ToPtr(time.Second * time.Duration(config.WatchStaleTimeoutSeconds)),
+		onStale: func(instance string) {
+			recorder.Eventf(
+				nodeRef, corev1.EventTypeWarning, "StaleWatch",
+				"autoscaler-agent watch on %s was stale; restarting it with a fresh re-list", instance,
+			)
+		},
+	}
+}
+
 type vmEvent struct {
 	kind    vmEventKind
 	vmInfo  api.VmInfo
@@ -30,6 +81,8 @@ type vmEvent struct {
 	podIP   string
 	// if present, the ID of the endpoint associated with the VM. May be empty.
 	endpointID string
+	// if not nil, the time at which we saw the VM start from scratch. Only set for added events.
+	coldStartAt *time.Time
 }
 
 const (
@@ -43,6 +96,9 @@ func (ev vmEvent) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("podName", ev.podName)
 	enc.AddString("podIP", ev.podIP)
 	enc.AddString("endpointID", ev.endpointID)
+	if ev.coldStartAt != nil {
+		enc.AddTime("coldStartAt", *ev.coldStartAt)
+	}
 	if err := enc.AddReflected("vmInfo", ev.vmInfo); err != nil {
 		return err
 	}
@@ -64,6 +120,7 @@ func startVMWatcher(
 	config *Config,
 	vmClient *vmclient.Clientset,
 	metrics watch.Metrics,
+	staleWatch staleWatchSettings,
 	perVMMetrics *PerVMMetrics,
 	nodeName string,
 	submitEvent func(vmEvent),
@@ -83,6 +140,8 @@ func startVMWatcher(
 			// We want to be relatively snappy; don't wait for too long before retrying.
 			RetryRelistAfter: util.NewTimeRange(time.Millisecond, 500, 1000),
 			RetryWatchAfter:  util.NewTimeRange(time.Millisecond, 500, 1000),
+			StaleAfter:       staleWatch.staleAfter,
+			OnStale:          staleWatch.onStaleFor("VirtualMachines"),
 		},
 		watch.Accessors[*vmv1.VirtualMachineList, vmv1.VirtualMachine]{
 			Items: func(list *vmv1.VirtualMachineList) []vmv1.VirtualMachine { return list.Items },
@@ -94,7 +153,9 @@ func startVMWatcher(
 				setVMMetrics(perVMMetrics, vm, nodeName)
 
 				if vmIsOurResponsibility(vm, config, nodeName) {
-					event, err := makeVMEvent(logger, vm, vmEventAdded)
+					// We don't know when preexisting VMs were started, so they can't get a startup
+					// boost.
+					event, err := makeVMEvent(logger, vm, vmEventAdded, nil)
 					if err != nil {
 						logger.Error(
 							"Failed to create vmEvent for added VM",
@@ -116,10 +177,14 @@ func startVMWatcher(
 
 				var vmForEvent *vmv1.VirtualMachine
 				var eventKind vmEventKind
+				var coldStartAt *time.Time
 
 				if !oldIsOurs && newIsOurs {
 					vmForEvent = newVM
 					eventKind = vmEventAdded
+					if vmColdStarted(oldVM) {
+						coldStartAt = lo.ToPtr(time.Now())
+					}
 				} else if oldIsOurs && !newIsOurs {
 					vmForEvent = oldVM
 					eventKind = vmEventDeleted
@@ -128,7 +193,7 @@ func startVMWatcher(
 					eventKind = vmEventUpdated
 				}
 
-				event, err := makeVMEvent(logger, vmForEvent, eventKind)
+				event, err := makeVMEvent(logger, vmForEvent, eventKind, coldStartAt)
 				if err != nil {
 					logger.Error(
 						"Failed to create vmEvent for updated VM",
@@ -143,7 +208,7 @@ func startVMWatcher(
 				deleteVMMetrics(perVMMetrics, vm, nodeName)
 
 				if vmIsOurResponsibility(vm, config, nodeName) {
-					event, err := makeVMEvent(logger, vm, vmEventDeleted)
+					event, err := makeVMEvent(logger, vm, vmEventDeleted, nil)
 					if err != nil {
 						logger.Error(
 							"Failed to create vmEvent for deleted VM",
@@ -158,7 +223,16 @@ func startVMWatcher(
 	)
 }
 
-func makeVMEvent(logger *zap.Logger, vm *vmv1.VirtualMachine, kind vmEventKind) (vmEvent, error) {
+// vmColdStarted returns whether a VM that's now our responsibility has just been started from
+// scratch, given its previous state.
+//
+// VMs that were migrated to this node or resumed from hibernation keep their memory, so they don't
+// count as cold starts.
+func vmColdStarted(oldVM *vmv1.VirtualMachine) bool {
+	return oldVM.Status.Phase == vmv1.VmPending && oldVM.Status.HibernatedAt == nil
+}
+
+func makeVMEvent(logger *zap.Logger, vm *vmv1.VirtualMachine, kind vmEventKind, coldStartAt *time.Time) (vmEvent, error) {
 	info, err := api.ExtractVmInfo(logger, vm)
 	if err != nil {
 		return vmEvent{}, fmt.Errorf("Error extracting VM info: %w", err)
@@ -170,11 +244,12 @@ func makeVMEvent(logger *zap.Logger, vm *vmv1.VirtualMachine, kind vmEventKind)
 	}
 
 	return vmEvent{
-		kind:       kind,
-		vmInfo:     *info,
-		podName:    vm.Status.PodName,
-		podIP:      vm.Status.PodIP,
-		endpointID: endpointID,
+		kind:        kind,
+		vmInfo:      *info,
+		podName:     vm.Status.PodName,
+		podIP:       vm.Status.PodIP,
+		endpointID:  endpointID,
+		coldStartAt: coldStartAt,
 	}, nil
 }
 
@@ -317,6 +392,23 @@ func makeVMExtraIPMetrics(vm *vmv1.VirtualMachine) []vmMetric {
 	}
 }
 
+func makeVMScalingFrozenMetrics(vm *vmv1.VirtualMachine) []vmMetric {
+	endpointID := vm.Labels[endpointLabel]
+	projectID := vm.Labels[projectLabel]
+	labels := makePerVMMetricsLabels(vm.Namespace, vm.Name, endpointID, projectID, "")
+
+	value := 0
+	if vm.Spec.ScalingFrozen {
+		value = 1
+	}
+	return []vmMetric{
+		{
+			labels: labels,
+			value:  float64(value),
+		},
+	}
+}
+
 // gaugeSpec groups a source of metrics (maker) with a destination (gauge).
 type gaugeSpec struct {
 	maker func(*vmv1.VirtualMachine) []vmMetric
@@ -344,6 +436,10 @@ func getGaugeSpecs(perVMMetrics *PerVMMetrics) []gaugeSpec {
 			maker: makeVMExtraIPMetrics,
 			gauge: perVMMetrics.extraIP,
 		},
+		{
+			maker: makeVMScalingFrozenMetrics,
+			gauge: perVMMetrics.scalingFrozen,
+		},
 	}
 }
 
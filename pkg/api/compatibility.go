@@ -0,0 +1,270 @@
+package api
+
+// Version skew between components
+//
+// Each of our HTTP-based protocols has its own protocol version, but those only describe the shape
+// of the messages. Partial upgrades can still produce combinations of components that speak the
+// same protocol version and disagree on what it means. To catch those, each component sends its
+// build version in the ComponentVersionHeader on every request and response, and the receiving
+// side checks the pair of versions against DefaultCompatibilityMatrix.
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Component is the name of one of the binaries built from this repo.
+type Component string
+
+const (
+	ComponentNeonVMController Component = "neonvm-controller"
+	ComponentNeonVMRunner     Component = "neonvm-runner"
+	ComponentNeonVMDaemon     Component = "neonvm-daemon"
+	ComponentAutoscalerAgent  Component = "autoscaler-agent"
+	ComponentSchedulerPlugin  Component = "autoscale-scheduler"
+)
+
+// BuildVersion is the version of the repo that the component was built from, in the format
+// produced by 'git describe --long --dirty'.
+//
+// It is set at build time with:
+//
+//	-ldflags "-X github.com/neondatabase/autoscaling/pkg/api.BuildVersion=$(GIT_INFO)"
+//
+// and is empty for builds without it, which skip all version checks.
+var BuildVersion string
+
+// ComponentVersionHeader is the HTTP header used to exchange component versions, with values in
+// the format "<component>/<version>", e.g. "neonvm-runner/v0.45.0".
+const ComponentVersionHeader = "X-Autoscaling-Component-Version"
+
+// ComponentVersion is the semantic version of a component.
+type ComponentVersion struct {
+	Major uint
+	Minor uint
+	Patch uint
+}
+
+// ParseComponentVersion parses a version in the format produced by 'git describe', e.g.
+// "v0.45.0-12-g3f8a2c1-dirty". Anything after the patch version is ignored.
+func ParseComponentVersion(s string) (ComponentVersion, error) {
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return ComponentVersion{}, fmt.Errorf("invalid version %q: expected vMAJOR.MINOR.PATCH", s)
+	}
+
+	var nums [3]uint
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return ComponentVersion{}, fmt.Errorf("invalid version %q: %w", s, err)
+		}
+		nums[i] = uint(n)
+	}
+	return ComponentVersion{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+func (v ComponentVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0, or 1 if v is less than, equal to, or greater than other, respectively.
+func (v ComponentVersion) Compare(other ComponentVersion) int {
+	for _, pair := range [][2]uint{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if pair[0] < pair[1] {
+			return -1
+		} else if pair[0] > pair[1] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// CompatibilityRule describes the versions of a peer that a component works with.
+//
+// For each pair of components, the rule with the latest Since that's not after the component's
+// version is used.
+type CompatibilityRule struct {
+	Component Component
+	Peer      Component
+	// Since is the first version of Component that the rule applies to.
+	Since ComponentVersion
+	// MinPeer is the oldest version of Peer that works with Component. Older peers are refused.
+	MinPeer ComponentVersion
+	// MaxMinorSkew is the number of minor versions that Peer may differ from Component by, in
+	// either direction, before we warn about it. Zero means there is no limit.
+	MaxMinorSkew uint
+}
+
+// CompatibilityMatrix is the set of rules for checking the versions of pairs of components.
+//
+// Each direction is checked separately, so a rule for (A, B) doesn't apply to B checking A.
+type CompatibilityMatrix []CompatibilityRule
+
+// DefaultCompatibilityMatrix is the compatibility matrix used by all components.
+//
+// When making a change that breaks an older version of a peer, add a rule here with Since set to
+// the version with the change, and MinPeer set to the oldest version of the peer that still works.
+var DefaultCompatibilityMatrix = CompatibilityMatrix{
+	// The scheduler plugin and autoscaler-agent are deployed together, so large skew only happens
+	// during partial rollouts.
+	{
+		Component:    ComponentAutoscalerAgent,
+		Peer:         ComponentSchedulerPlugin,
+		Since:        ComponentVersion{},
+		MinPeer:      ComponentVersion{},
+		MaxMinorSkew: 2,
+	},
+	{
+		Component:    ComponentSchedulerPlugin,
+		Peer:         ComponentAutoscalerAgent,
+		Since:        ComponentVersion{},
+		MinPeer:      ComponentVersion{},
+		MaxMinorSkew: 2,
+	},
+	// Runner pods live as long as their VMs, so they are commonly many versions behind the
+	// controller. We only refuse runners that are known to be broken.
+	{
+		Component:    ComponentNeonVMController,
+		Peer:         ComponentNeonVMRunner,
+		Since:        ComponentVersion{},
+		MinPeer:      ComponentVersion{},
+		MaxMinorSkew: 0,
+	},
+	{
+		Component:    ComponentNeonVMRunner,
+		Peer:         ComponentNeonVMController,
+		Since:        ComponentVersion{},
+		MinPeer:      ComponentVersion{},
+		MaxMinorSkew: 0,
+	},
+	// neonvm-daemon is baked into VM images by vm-builder, so it can be arbitrarily old.
+	{
+		Component:    ComponentNeonVMRunner,
+		Peer:         ComponentNeonVMDaemon,
+		Since:        ComponentVersion{},
+		MinPeer:      ComponentVersion{},
+		MaxMinorSkew: 0,
+	},
+}
+
+// VersionSkewError is returned by CompatibilityMatrix.Check for unsupported version skew.
+type VersionSkewError struct {
+	Component   Component
+	Version     ComponentVersion
+	Peer        Component
+	PeerVersion ComponentVersion
+	// Refuse is true if the peer is known not to work with this component, and false if the skew
+	// is just larger than we'd expect.
+	Refuse bool
+}
+
+func (e *VersionSkewError) Error() string {
+	if e.Refuse {
+		return fmt.Sprintf(
+			"%s %s is not compatible with %s %s", e.Peer, e.PeerVersion, e.Component, e.Version,
+		)
+	}
+	return fmt.Sprintf(
+		"%s %s is too far from %s %s", e.Peer, e.PeerVersion, e.Component, e.Version,
+	)
+}
+
+// Action returns "refuse" or "warn", depending on e.Refuse. It's intended for use in metrics.
+func (e *VersionSkewError) Action() string {
+	if e.Refuse {
+		return "refuse"
+	}
+	return "warn"
+}
+
+// Check returns a non-nil *VersionSkewError if the peer's version isn't supported by the
+// component, or nil if it is or there is no rule for the pair of components.
+func (m CompatibilityMatrix) Check(
+	component Component,
+	version ComponentVersion,
+	peer Component,
+	peerVersion ComponentVersion,
+) *VersionSkewError {
+	var rule *CompatibilityRule
+	for i := range m {
+		r := &m[i]
+		if r.Component != component || r.Peer != peer || r.Since.Compare(version) > 0 {
+			continue
+		}
+		if rule == nil || r.Since.Compare(rule.Since) > 0 {
+			rule = r
+		}
+	}
+	if rule == nil {
+		return nil
+	}
+
+	skewErr := &VersionSkewError{
+		Component:   component,
+		Version:     version,
+		Peer:        peer,
+		PeerVersion: peerVersion,
+		Refuse:      false,
+	}
+
+	if peerVersion.Compare(rule.MinPeer) < 0 {
+		skewErr.Refuse = true
+		return skewErr
+	}
+
+	if rule.MaxMinorSkew != 0 {
+		var skew uint
+		if version.Major != peerVersion.Major {
+			// Different major versions are always too far apart
+			skew = rule.MaxMinorSkew + 1
+		} else if version.Minor > peerVersion.Minor {
+			skew = version.Minor - peerVersion.Minor
+		} else {
+			skew = peerVersion.Minor - version.Minor
+		}
+		if skew > rule.MaxMinorSkew {
+			return skewErr
+		}
+	}
+
+	return nil
+}
+
+// SetComponentVersionHeader sets the ComponentVersionHeader to the component's BuildVersion, if it
+// has one.
+func SetComponentVersionHeader(header http.Header, component Component) {
+	if BuildVersion != "" {
+		header.Set(ComponentVersionHeader, fmt.Sprintf("%s/%s", component, BuildVersion))
+	}
+}
+
+// CheckPeerVersionHeader checks the version of the peer from the ComponentVersionHeader against
+// DefaultCompatibilityMatrix, returning a non-nil *VersionSkewError if it's not supported.
+//
+// Missing or unparseable versions on either side -- e.g. from development builds, or from peers
+// that predate the header -- are not checked.
+func CheckPeerVersionHeader(component Component, peer Component, header http.Header) *VersionSkewError {
+	version, err := ParseComponentVersion(BuildVersion)
+	if err != nil {
+		return nil
+	}
+
+	value := header.Get(ComponentVersionHeader)
+	if value == "" {
+		return nil
+	}
+	name, peerVersionString, ok := strings.Cut(value, "/")
+	if !ok || Component(name) != peer {
+		return nil
+	}
+	peerVersion, err := ParseComponentVersion(peerVersionString)
+	if err != nil {
+		return nil
+	}
+
+	return DefaultCompatibilityMatrix.Check(component, version, peer, peerVersion)
+}
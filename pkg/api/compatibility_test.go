@@ -0,0 +1,224 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func version(major, minor, patch uint) api.ComponentVersion {
+	return api.ComponentVersion{Major: major, Minor: minor, Patch: patch}
+}
+
+func TestParseComponentVersion(t *testing.T) {
+	cases := []struct {
+		input     string
+		expectErr bool
+		expected  api.ComponentVersion
+	}{
+		{input: "v0.45.0", expectErr: false, expected: version(0, 45, 0)},
+		{input: "1.2.3", expectErr: false, expected: version(1, 2, 3)},
+		{input: "v0.45.0-12-g3f8a2c1", expectErr: false, expected: version(0, 45, 0)},
+		{input: "v0.45.0-12-g3f8a2c1-dirty", expectErr: false, expected: version(0, 45, 0)},
+		{input: "", expectErr: true, expected: version(0, 0, 0)},
+		{input: "v0.45", expectErr: true, expected: version(0, 0, 0)},
+		{input: "v0.45.x", expectErr: true, expected: version(0, 0, 0)},
+		{input: "3f8a2c1", expectErr: true, expected: version(0, 0, 0)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			v, err := api.ParseComponentVersion(c.input)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, v)
+		})
+	}
+}
+
+func TestComponentVersionCompare(t *testing.T) {
+	cases := []struct {
+		a, b     api.ComponentVersion
+		expected int
+	}{
+		{a: version(1, 2, 3), b: version(1, 2, 3), expected: 0},
+		{a: version(1, 2, 3), b: version(1, 2, 4), expected: -1},
+		{a: version(1, 3, 0), b: version(1, 2, 9), expected: 1},
+		{a: version(0, 99, 99), b: version(1, 0, 0), expected: -1},
+		{a: version(2, 0, 0), b: version(1, 99, 99), expected: 1},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.a.Compare(c.b), "%v vs %v", c.a, c.b)
+	}
+}
+
+func TestCompatibilityMatrixCheck(t *testing.T) {
+	const (
+		comp = api.ComponentAutoscalerAgent
+		peer = api.ComponentSchedulerPlugin
+	)
+
+	matrix := api.CompatibilityMatrix{
+		{Component: comp, Peer: peer, Since: version(0, 0, 0), MinPeer: version(0, 0, 0), MaxMinorSkew: 2},
+		// from v0.50.0, peers older than v0.48.0 are refused
+		{Component: comp, Peer: peer, Since: version(0, 50, 0), MinPeer: version(0, 48, 0), MaxMinorSkew: 2},
+		// only limits the skew, no minimum
+		{Component: api.ComponentNeonVMController, Peer: api.ComponentNeonVMRunner, Since: version(0, 0, 0), MinPeer: version(0, 0, 0), MaxMinorSkew: 0},
+	}
+
+	cases := []struct {
+		name        string
+		component   api.Component
+		version     api.ComponentVersion
+		peer        api.Component
+		peerVersion api.ComponentVersion
+		// expectedAction is "", "warn", or "refuse"
+		expectedAction string
+	}{
+		{
+			name:           "same version",
+			component:      comp,
+			version:        version(0, 45, 0),
+			peer:           peer,
+			peerVersion:    version(0, 45, 0),
+			expectedAction: "",
+		},
+		{
+			name:           "within skew",
+			component:      comp,
+			version:        version(0, 45, 0),
+			peer:           peer,
+			peerVersion:    version(0, 43, 7),
+			expectedAction: "",
+		},
+		{
+			name:           "peer too old",
+			component:      comp,
+			version:        version(0, 45, 0),
+			peer:           peer,
+			peerVersion:    version(0, 42, 0),
+			expectedAction: "warn",
+		},
+		{
+			name:           "peer too new",
+			component:      comp,
+			version:        version(0, 45, 0),
+			peer:           peer,
+			peerVersion:    version(0, 48, 0),
+			expectedAction: "warn",
+		},
+		{
+			name:           "different major version",
+			component:      comp,
+			version:        version(1, 0, 0),
+			peer:           peer,
+			peerVersion:    version(0, 99, 0),
+			expectedAction: "warn",
+		},
+		{
+			name:           "later rule refuses old peer",
+			component:      comp,
+			version:        version(0, 50, 0),
+			peer:           peer,
+			peerVersion:    version(0, 47, 9),
+			expectedAction: "refuse",
+		},
+		{
+			name:           "later rule allows new enough peer",
+			component:      comp,
+			version:        version(0, 50, 1),
+			peer:           peer,
+			peerVersion:    version(0, 48, 0),
+			expectedAction: "",
+		},
+		{
+			name:           "no skew limit",
+			component:      api.ComponentNeonVMController,
+			version:        version(0, 50, 0),
+			peer:           api.ComponentNeonVMRunner,
+			peerVersion:    version(0, 10, 0),
+			expectedAction: "",
+		},
+		{
+			name:           "rules are one direction only",
+			component:      peer,
+			version:        version(0, 50, 0),
+			peer:           comp,
+			peerVersion:    version(0, 10, 0),
+			expectedAction: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := matrix.Check(c.component, c.version, c.peer, c.peerVersion)
+			if c.expectedAction == "" {
+				assert.Nil(t, err)
+				return
+			}
+			if assert.NotNil(t, err) {
+				assert.Equal(t, c.expectedAction, err.Action())
+				assert.Equal(t, c.peerVersion, err.PeerVersion)
+			}
+		})
+	}
+}
+
+func TestCheckPeerVersionHeader(t *testing.T) {
+	oldBuildVersion := api.BuildVersion
+	defer func() { api.BuildVersion = oldBuildVersion }()
+
+	cases := []struct {
+		name         string
+		buildVersion string
+		header       string
+		expectSkew   bool
+	}{
+		{name: "no build version", buildVersion: "", header: "autoscale-scheduler/v0.10.0", expectSkew: false},
+		{name: "no header", buildVersion: "v0.45.0", header: "", expectSkew: false},
+		{name: "wrong component", buildVersion: "v0.45.0", header: "neonvm-runner/v0.10.0", expectSkew: false},
+		{name: "malformed header", buildVersion: "v0.45.0", header: "autoscale-scheduler", expectSkew: false},
+		{name: "unparseable peer version", buildVersion: "v0.45.0", header: "autoscale-scheduler/3f8a2c1", expectSkew: false},
+		{name: "compatible", buildVersion: "v0.45.0-3-gabcdef0", header: "autoscale-scheduler/v0.44.2", expectSkew: false},
+		{name: "too far apart", buildVersion: "v0.45.0", header: "autoscale-scheduler/v0.10.0-dirty", expectSkew: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			api.BuildVersion = c.buildVersion
+
+			header := http.Header{}
+			if c.header != "" {
+				header.Set(api.ComponentVersionHeader, c.header)
+			}
+
+			err := api.CheckPeerVersionHeader(api.ComponentAutoscalerAgent, api.ComponentSchedulerPlugin, header)
+			if c.expectSkew {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestSetComponentVersionHeader(t *testing.T) {
+	oldBuildVersion := api.BuildVersion
+	defer func() { api.BuildVersion = oldBuildVersion }()
+
+	api.BuildVersion = ""
+	header := http.Header{}
+	api.SetComponentVersionHeader(header, api.ComponentAutoscalerAgent)
+	assert.Empty(t, header.Get(api.ComponentVersionHeader))
+
+	api.BuildVersion = "v0.45.0-3-gabcdef0"
+	api.SetComponentVersionHeader(header, api.ComponentAutoscalerAgent)
+	assert.Equal(t, "autoscaler-agent/v0.45.0-3-gabcdef0", header.Get(api.ComponentVersionHeader))
+}
@@ -0,0 +1,92 @@
+package api
+
+// Definition of the Compute Unit config that's shared between the autoscaler-agent, scheduler
+// plugin, and neonvm-controller.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelComputeUnitFamily is the label on a VM that selects which entry in the ComputeUnitConfig's
+// Families is used for the VM. VMs without the label use the default Compute Unit.
+const LabelComputeUnitFamily = "autoscaling.neon.tech/compute-unit-family"
+
+// ComputeUnitConfig defines the size of a Compute Unit (CU) for the cluster, plus any overrides for
+// particular families of VMs (e.g., memory-optimized endpoints with more memory per vCPU).
+//
+// This is typically stored in a ConfigMap that's mounted into each of the components that need to
+// agree on the definition of a CU.
+type ComputeUnitConfig struct {
+	// Default is the Compute Unit for VMs without a family, or whose family has no entry in
+	// Families.
+	Default Resources `json:"default"`
+	// Families gives the Compute Unit to use for VMs with a particular value of the
+	// LabelComputeUnitFamily label.
+	Families map[string]Resources `json:"families,omitempty"`
+}
+
+// ReadComputeUnitConfig reads and validates the JSON ComputeUnitConfig at the given path.
+func ReadComputeUnitConfig(path string) (*ComputeUnitConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening compute unit config file %q: %w", path, err)
+	}
+
+	defer file.Close()
+	var config ComputeUnitConfig
+	jsonDecoder := json.NewDecoder(file)
+	jsonDecoder.DisallowUnknownFields()
+	if err = jsonDecoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("Error decoding JSON compute unit config in %q: %w", path, err)
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid compute unit config: %w", err)
+	}
+
+	return &config, nil
+}
+
+// Validate returns an error if any of the Compute Units in the config have zero-valued fields.
+func (c ComputeUnitConfig) Validate() error {
+	if err := c.Default.ValidateNonZero(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+
+	for family, cu := range c.Families {
+		if family == "" {
+			return errors.New("families: family name cannot be empty")
+		}
+		if err := cu.ValidateNonZero(); err != nil {
+			return fmt.Errorf("families[%q]: %w", family, err)
+		}
+	}
+
+	return nil
+}
+
+// ForFamily returns the Compute Unit for the family, falling back to the default if there is no
+// entry for the family (or it's empty).
+func (c ComputeUnitConfig) ForFamily(family string) Resources {
+	if cu, ok := c.Families[family]; ok {
+		return cu
+	}
+	return c.Default
+}
+
+// ForObject returns the Compute Unit that should be used for the object, based on its
+// LabelComputeUnitFamily label.
+func (c ComputeUnitConfig) ForObject(obj metav1.ObjectMetaAccessor) Resources {
+	return c.ForFamily(ComputeUnitFamily(obj))
+}
+
+// ComputeUnitFamily returns the value of the object's LabelComputeUnitFamily label, or the empty
+// string if it's not present.
+func ComputeUnitFamily(obj metav1.ObjectMetaAccessor) string {
+	return obj.GetObjectMeta().GetLabels()[LabelComputeUnitFamily]
+}
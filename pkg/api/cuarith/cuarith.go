@@ -0,0 +1,83 @@
+// Package cuarith provides overflow-safe conversions between Compute Units, CPU, memory, and
+// memory slots.
+//
+// It's shared by the scheduler plugin, autoscaler-agent, and neonvm-controller so that they all
+// round in the same way. All functions are generic over unsigned integer types, so that they can
+// be used with vmv1.MilliCPU, api.Bytes, etc.
+package cuarith
+
+import (
+	"math/bits"
+)
+
+// Unsigned is the set of unsigned integer types that this package operates on.
+type Unsigned interface {
+	~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// maxOf returns the maximum value of T.
+func maxOf[T Unsigned]() T {
+	return ^T(0)
+}
+
+// Mul returns value * factor, or false if the result would overflow T.
+func Mul[T Unsigned](value T, factor uint64) (_ T, ok bool) {
+	hi, lo := bits.Mul64(uint64(value), factor)
+	if hi != 0 || lo > uint64(maxOf[T]()) {
+		return 0, false
+	}
+	return T(lo), true
+}
+
+// SaturatingMul returns value * factor, or the maximum value of T if that would overflow.
+func SaturatingMul[T Unsigned](value T, factor uint64) T {
+	result, ok := Mul(value, factor)
+	if !ok {
+		return maxOf[T]()
+	}
+	return result
+}
+
+// Div returns the number of whole units in value (i.e. value / unit, rounded down), and whether
+// value is an exact multiple of unit.
+//
+// If unit is zero, Div returns (0, false).
+func Div[T Unsigned](value, unit T) (quotient uint64, exact bool) {
+	if unit == 0 {
+		return 0, false
+	}
+	return uint64(value / unit), value%unit == 0
+}
+
+// BytesFromSlots returns the number of bytes in the given number of memory slots, saturating at
+// the maximum value of B.
+func BytesFromSlots[B Unsigned](slots uint64, slotSize B) B {
+	return SaturatingMul(slotSize, slots)
+}
+
+// SlotsFromBytes returns the number of whole memory slots that fit in bytes, capped at maxSlots,
+// and whether bytes was exactly that number of slots.
+//
+// If slotSize is zero, SlotsFromBytes returns (0, false).
+func SlotsFromBytes[B Unsigned](bytes, slotSize B, maxSlots uint64) (slots uint64, exact bool) {
+	slots, exact = Div(bytes, slotSize)
+	if slots > maxSlots {
+		return maxSlots, false
+	}
+	return slots, exact
+}
+
+// CUs returns the number of Compute Units equal to the CPU and memory amounts, given the amounts
+// in a single Compute Unit.
+//
+// If the amounts are not both the same integer multiple of the Compute Unit (or the multiple does
+// not fit in a uint16), CUs returns (0, false).
+func CUs[C Unsigned, B Unsigned](cpu C, mem B, cuCPU C, cuMem B) (_ uint16, ok bool) {
+	cpuFactor, cpuOk := Div(cpu, cuCPU)
+	memFactor, memOk := Div(mem, cuMem)
+
+	if !cpuOk || !memOk || cpuFactor != memFactor || cpuFactor > uint64(maxOf[uint16]()) {
+		return 0, false
+	}
+	return uint16(cpuFactor), true
+}
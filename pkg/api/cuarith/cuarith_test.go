@@ -0,0 +1,137 @@
+package cuarith_test
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/api/cuarith"
+)
+
+func TestMul(t *testing.T) {
+	cases := []struct {
+		name   string
+		value  uint32
+		factor uint64
+		result uint32
+		ok     bool
+	}{
+		{"zero", 0, math.MaxUint64, 0, true},
+		{"simple", 250, 4, 1000, true},
+		{"max", math.MaxUint32, 1, math.MaxUint32, true},
+		{"overflow", math.MaxUint32/2 + 1, 2, 0, false},
+		{"overflow-64", 2, math.MaxUint64, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, ok := cuarith.Mul(c.value, c.factor)
+			assert.Equal(t, c.result, result)
+			assert.Equal(t, c.ok, ok)
+		})
+	}
+
+	assert.Equal(t, uint32(math.MaxUint32), cuarith.SaturatingMul(uint32(math.MaxUint32/2+1), 2))
+}
+
+func TestCUs(t *testing.T) {
+	const gib = 1 << 30
+
+	cases := []struct {
+		name   string
+		cpu    uint32
+		mem    uint64
+		result uint16
+		ok     bool
+	}{
+		{"zero", 0, 0, 0, true},
+		{"exact", 1000, 4 * gib, 4, true},
+		{"mismatched", 1000, 2 * gib, 0, false},
+		{"cpu-not-multiple", 1100, 4 * gib, 0, false},
+		{"mem-not-multiple", 1000, 4*gib + 1, 0, false},
+		{"too-many-cus", 250 * (math.MaxUint16 + 1), gib * (math.MaxUint16 + 1), 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, ok := cuarith.CUs(c.cpu, c.mem, 250, uint64(gib))
+			assert.Equal(t, c.result, result)
+			assert.Equal(t, c.ok, ok)
+		})
+	}
+
+	// A zero-sized Compute Unit is never valid:
+	_, ok := cuarith.CUs(uint32(1000), uint64(gib), 0, uint64(gib))
+	assert.False(t, ok)
+}
+
+func TestSlotsFromBytes(t *testing.T) {
+	const slotSize = 1 << 30
+
+	slots, exact := cuarith.SlotsFromBytes(uint64(3*slotSize), slotSize, math.MaxUint16)
+	assert.Equal(t, uint64(3), slots)
+	assert.True(t, exact)
+
+	slots, exact = cuarith.SlotsFromBytes(uint64(3*slotSize+1), slotSize, math.MaxUint16)
+	assert.Equal(t, uint64(3), slots)
+	assert.False(t, exact)
+
+	slots, exact = cuarith.SlotsFromBytes(uint64(3*slotSize-1), slotSize, math.MaxUint16)
+	assert.Equal(t, uint64(2), slots)
+	assert.False(t, exact)
+
+	slots, exact = cuarith.SlotsFromBytes(uint64(math.MaxUint64), slotSize, math.MaxUint16)
+	assert.Equal(t, uint64(math.MaxUint16), slots)
+	assert.False(t, exact)
+
+	slots, exact = cuarith.SlotsFromBytes(uint64(slotSize), 0, math.MaxUint16)
+	assert.Equal(t, uint64(0), slots)
+	assert.False(t, exact)
+}
+
+func FuzzMul(f *testing.F) {
+	f.Add(uint64(250), uint64(4))
+	f.Add(uint64(math.MaxUint64), uint64(2))
+	f.Add(uint64(0), uint64(math.MaxUint64))
+
+	f.Fuzz(func(t *testing.T, value uint64, factor uint64) {
+		expected := new(big.Int).Mul(new(big.Int).SetUint64(value), new(big.Int).SetUint64(factor))
+
+		result, ok := cuarith.Mul(value, factor)
+		if expected.IsUint64() {
+			assert.True(t, ok)
+			assert.Equal(t, expected.Uint64(), result)
+		} else {
+			assert.False(t, ok)
+			assert.Equal(t, uint64(math.MaxUint64), cuarith.SaturatingMul(value, factor))
+		}
+	})
+}
+
+func FuzzSlotsRoundTrip(f *testing.F) {
+	f.Add(uint64(4), uint64(1<<30))
+	f.Add(uint64(math.MaxUint16), uint64(1<<40))
+	f.Add(uint64(1), uint64(0))
+
+	f.Fuzz(func(t *testing.T, slots uint64, slotSize uint64) {
+		bytes, ok := cuarith.Mul(slotSize, slots)
+		if !ok || slotSize == 0 {
+			return
+		}
+
+		assert.Equal(t, bytes, cuarith.BytesFromSlots(slots, slotSize))
+
+		gotSlots, exact := cuarith.SlotsFromBytes(bytes, slotSize, math.MaxUint64)
+		assert.Equal(t, slots, gotSlots)
+		assert.True(t, exact)
+
+		// One byte less is never a whole number of slots, and rounds down.
+		if bytes != 0 {
+			gotSlots, exact = cuarith.SlotsFromBytes(bytes-1, slotSize, math.MaxUint64)
+			assert.Equal(t, slots-1, gotSlots)
+			assert.Equal(t, slotSize == 1, exact)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package api
+
+// Definition of the summaries that scheduler plugins push to the central federation service, so
+// that it can make cross-cluster placement recommendations for new endpoints -- and of those
+// recommendations, as served by neonvm-controller when its -federation-bind-address flag is set.
+
+import (
+	"time"
+)
+
+// FederationClusterSummary is the capacity and pressure summary of a single cluster, sent by the
+// scheduler plugin to the federation endpoint.
+//
+// Each summary is a complete snapshot: the federation service is expected to replace any previous
+// summary from the same cluster, and may treat clusters that haven't pushed recently as stale.
+type FederationClusterSummary struct {
+	// Cluster is the name of the cluster, as configured in the scheduler plugin.
+	Cluster string `json:"cluster"`
+	// Timestamp is when the summary was produced.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Nodes is the number of nodes known to the scheduler plugin.
+	Nodes int `json:"nodes"`
+	// NodesAboveWatermark is the number of nodes with reserved CPU or memory above the watermark,
+	// which are typically trying to migrate VMs away.
+	NodesAboveWatermark int `json:"nodesAboveWatermark"`
+
+	// Total is the sum of resources on all nodes.
+	Total Resources `json:"total"`
+	// Reserved is the sum of resources reserved across all nodes.
+	Reserved Resources `json:"reserved"`
+	// Watermark is the sum of each node's watermark.
+	Watermark Resources `json:"watermark"`
+	// Migrating is the sum of resources that are expected to be removed from nodes by ongoing live
+	// migrations.
+	Migrating Resources `json:"migrating"`
+}
+
+// FederationRecommendation is the response from the federation service's recommendation endpoint:
+// the clusters that a new endpoint with the requested resources would fit in, best first.
+type FederationRecommendation struct {
+	Clusters []FederationClusterFit `json:"clusters"`
+}
+
+// FederationClusterFit is a single cluster in a FederationRecommendation.
+type FederationClusterFit struct {
+	// Cluster is the name of the cluster, from its FederationClusterSummary.
+	Cluster string `json:"cluster"`
+	// Timestamp is the time of the summary that the recommendation is based on.
+	Timestamp time.Time `json:"timestamp"`
+	// Headroom is the cluster's total watermark, minus the resources reserved in it.
+	Headroom Resources `json:"headroom"`
+	// Fits is the number of endpoints with the requested resources that fit in the Headroom.
+	Fits uint64 `json:"fits"`
+}
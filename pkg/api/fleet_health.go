@@ -0,0 +1,42 @@
+package api
+
+// Definition of the fleet health report that the scheduler plugin periodically writes to a
+// ConfigMap, if enabled.
+
+import (
+	"time"
+)
+
+// FleetHealthConfigMapKey is the key in the ConfigMap's data that holds the JSON-encoded
+// FleetHealth.
+const FleetHealthConfigMapKey = "health.json"
+
+// FleetHealth is a summary of the health of the VMs and nodes in a cluster, as seen by the
+// scheduler plugin.
+//
+// Each report is a complete snapshot, replacing the previous one. Consumers should check Timestamp
+// to detect when the plugin has stopped updating it.
+type FleetHealth struct {
+	// Timestamp is when the report was produced.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Nodes is the number of nodes known to the scheduler plugin.
+	Nodes int `json:"nodes"`
+	// NodesAboveWatermark is the number of nodes with reserved CPU or memory above the watermark,
+	// which are typically trying to migrate VMs away.
+	NodesAboveWatermark int `json:"nodesAboveWatermark"`
+
+	// VMs is the number of VMs on the nodes known to the scheduler plugin.
+	VMs int `json:"vms"`
+	// VMsFailingToScale is the number of VMs that have requested more CPU or memory than they've
+	// been granted, typically because there's no room on their node.
+	VMsFailingToScale int `json:"vmsFailingToScale"`
+
+	// StuckMigrations is the number of migrations created by the scheduler plugin that haven't
+	// finished within the configured time.
+	StuckMigrations int `json:"stuckMigrations"`
+
+	// FailingReconciles gives the number of objects of each kind (e.g. "Pod") that are failing to
+	// be reconciled by the scheduler plugin, and are being retried with backoff.
+	FailingReconciles map[string]int `json:"failingReconciles"`
+}
@@ -0,0 +1,88 @@
+// Package protometrics defines RED (rate, errors, duration) metrics for the protocol between the
+// autoscaler-agent and vm-monitor.
+//
+// The metrics are defined here, rather than in the autoscaler-agent, so that either side of the
+// connection can use them with the same labels.
+package protometrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// Message types that are tracked by the metrics.
+//
+// These match the "type" field in the messages sent over the websocket.
+const (
+	MessageDownscaleRequest    = "DownscaleRequest"
+	MessageUpscaleNotification = "UpscaleNotification"
+	MessageUpscaleRequest      = "UpscaleRequest"
+	MessageHealthCheck         = "HealthCheck"
+)
+
+var durationBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5, 10, 30, 60,
+}
+
+// Metrics stores the RED metrics for one side of the agent<->monitor protocol.
+type Metrics struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// New creates and registers the metrics, with each metric's name starting with namePrefix (e.g.,
+// "autoscaling_agent_monitor_protocol").
+func New(reg prometheus.Registerer, namePrefix string) *Metrics {
+	m := &Metrics{
+		requests: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: namePrefix + "_requests_total",
+				Help: "Number of agent<->monitor protocol requests, by message type",
+			},
+			[]string{"message_type"},
+		)),
+		errors: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: namePrefix + "_errors_total",
+				Help: "Number of failed agent<->monitor protocol requests, by message type and reason",
+			},
+			[]string{"message_type", "reason"},
+		)),
+		duration: util.RegisterMetric(reg, prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    namePrefix + "_duration_seconds",
+				Help:    "Duration of agent<->monitor protocol requests, by message type",
+				Buckets: durationBuckets,
+			},
+			[]string{"message_type"},
+		)),
+	}
+
+	// Pre-fill the known message types, so that there's always *some* value.
+	for _, t := range []string{
+		MessageDownscaleRequest,
+		MessageUpscaleNotification,
+		MessageUpscaleRequest,
+		MessageHealthCheck,
+	} {
+		m.requests.WithLabelValues(t).Add(0.0)
+	}
+
+	return m
+}
+
+// Observe records a single request with the message type, taking the given duration.
+//
+// If errReason is not empty, the request is also counted as an error with that reason. The reason
+// is used as a metric label, so it should be from a small, fixed set of values.
+func (m *Metrics) Observe(messageType string, duration time.Duration, errReason string) {
+	m.requests.WithLabelValues(messageType).Inc()
+	m.duration.WithLabelValues(messageType).Observe(duration.Seconds())
+	if errReason != "" {
+		m.errors.WithLabelValues(messageType, errReason).Inc()
+	}
+}
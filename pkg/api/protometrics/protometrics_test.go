@@ -0,0 +1,104 @@
+package protometrics_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/neondatabase/autoscaling/pkg/api/protometrics"
+)
+
+func TestObserve(t *testing.T) {
+	type observation struct {
+		messageType string
+		errReason   string
+	}
+
+	cases := []struct {
+		name         string
+		observations []observation
+		// expected samples of the requests and errors metrics, in the text exposition format
+		requests string
+		errors   string
+		// expected number of observations in the duration histogram
+		durations uint64
+	}{
+		{
+			name:         "no requests",
+			observations: nil,
+			requests: `
+				test_requests_total{message_type="DownscaleRequest"} 0
+				test_requests_total{message_type="HealthCheck"} 0
+				test_requests_total{message_type="UpscaleNotification"} 0
+				test_requests_total{message_type="UpscaleRequest"} 0
+			`,
+			errors:    "",
+			durations: 0,
+		},
+		{
+			name: "successes and errors",
+			observations: []observation{
+				{messageType: protometrics.MessageDownscaleRequest, errReason: ""},
+				{messageType: protometrics.MessageDownscaleRequest, errReason: "timed out"},
+				{messageType: protometrics.MessageHealthCheck, errReason: ""},
+				{messageType: protometrics.MessageHealthCheck, errReason: "timed out"},
+				{messageType: protometrics.MessageHealthCheck, errReason: "monitor error"},
+			},
+			requests: `
+				test_requests_total{message_type="DownscaleRequest"} 2
+				test_requests_total{message_type="HealthCheck"} 3
+				test_requests_total{message_type="UpscaleNotification"} 0
+				test_requests_total{message_type="UpscaleRequest"} 0
+			`,
+			errors: `
+				test_errors_total{message_type="DownscaleRequest",reason="timed out"} 1
+				test_errors_total{message_type="HealthCheck",reason="monitor error"} 1
+				test_errors_total{message_type="HealthCheck",reason="timed out"} 1
+			`,
+			durations: 5,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			m := protometrics.New(reg, "test")
+
+			for _, o := range c.observations {
+				m.Observe(o.messageType, time.Millisecond, o.errReason)
+			}
+
+			expected := `
+				# HELP test_requests_total Number of agent<->monitor protocol requests, by message type
+				# TYPE test_requests_total counter
+			` + c.requests
+			if c.errors != "" {
+				expected += `
+					# HELP test_errors_total Number of failed agent<->monitor protocol requests, by message type and reason
+					# TYPE test_errors_total counter
+				` + c.errors
+			}
+			assert.NoError(t, testutil.GatherAndCompare(
+				reg, strings.NewReader(expected), "test_requests_total", "test_errors_total",
+			))
+
+			families, err := reg.Gather()
+			require.NoError(t, err)
+			var durations uint64
+			for _, f := range families {
+				if f.GetName() != "test_duration_seconds" {
+					continue
+				}
+				for _, metric := range f.GetMetric() {
+					durations += metric.GetHistogram().GetSampleCount()
+				}
+			}
+			assert.Equal(t, c.durations, durations)
+		})
+	}
+}
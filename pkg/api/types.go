@@ -11,6 +11,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api/cuarith"
 	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
@@ -84,9 +85,25 @@ const (
 	// Changes from v4.0:
 	//
 	// * Removed AgentRequest.metrics fields loadAvg5M and memoryUsageBytes
+	PluginProtoV5_0
+
+	// PluginProtoV5_1 represents v5.1 of the agent<->scheduler plugin protocol.
+	//
+	// Changes from v5.0:
+	//
+	// * Adds PluginResponse.nodePressure
+	PluginProtoV5_1
+
+	// PluginProtoV5_2 represents v5.2 of the agent<->scheduler plugin protocol.
+	//
+	// Changes from v5.1:
+	//
+	// * Adds AgentRequest.confirmed
+	// * Upscaling approved by the scheduler plugin is a reservation that may be released if it is
+	//   not confirmed in time
 	//
 	// Currently the latest version.
-	PluginProtoV5_0
+	PluginProtoV5_2
 
 	// latestPluginProtoVersion represents the latest version of the agent<->scheduler plugin
 	// protocol
@@ -117,6 +134,10 @@ func (v PluginProtoVersion) String() string {
 		return "v4.0"
 	case PluginProtoV5_0:
 		return "v5.0"
+	case PluginProtoV5_1:
+		return "v5.1"
+	case PluginProtoV5_2:
+		return "v5.2"
 	default:
 		diff := v - latestPluginProtoVersion
 		return fmt.Sprintf("<unknown = %v + %d>", latestPluginProtoVersion, diff)
@@ -172,6 +193,23 @@ func (v PluginProtoVersion) IncludesExtendedMetrics() bool {
 	return v < PluginProtoV5_0
 }
 
+// SupportsNodePressure returns whether this version of the protocol allows the scheduler plugin to
+// send PluginResponse.NodePressure.
+//
+// This is true for version v5.1 and greater.
+func (v PluginProtoVersion) SupportsNodePressure() bool {
+	return v >= PluginProtoV5_1
+}
+
+// SupportsConfirmedReservations returns whether this version of the protocol allows the
+// autoscaler-agent to send AgentRequest.Confirmed, and for the scheduler plugin to release
+// approved upscaling that isn't confirmed in time.
+//
+// This is true for version v5.2 and greater.
+func (v PluginProtoVersion) SupportsConfirmedReservations() bool {
+	return v >= PluginProtoV5_2
+}
+
 // AgentRequest is the type of message sent from an autoscaler-agent to the scheduler plugin on
 // behalf of a Pod on the agent's node.
 //
@@ -202,6 +240,21 @@ type AgentRequest struct {
 	// In case of a failure, the new running scheduler uses LastPermit to recover the previous state.
 	// LastPermit may be nil.
 	LastPermit *Resources `json:"lastPermit"`
+	// Preapproval, if not nil, gives the plausible near-term maximum resources for the VM.
+	//
+	// The scheduler plugin will make a best-effort attempt to set aside resources up to this amount
+	// as a "soft" reservation, so that future requests for resources within it can be approved
+	// immediately, without waiting for the request to propagate through the VM and Pod objects.
+	Preapproval *Resources `json:"preapproval,omitempty"`
+	// Confirmed, if not nil, gives the resources that the autoscaler-agent has most recently set on
+	// the VM object.
+	//
+	// Upscaling approved by the scheduler plugin is only a reservation until the VM is patched to
+	// use it. If the approved resources aren't confirmed within the plugin's configured TTL (e.g.,
+	// because the autoscaler-agent crashed before patching the VM), the reservation is released.
+	//
+	// This field is only sent for protocol versions where SupportsConfirmedReservations() is true.
+	Confirmed *Resources `json:"confirmed,omitempty"`
 	// Metrics provides information about the VM's current load, so that the scheduler may
 	// prioritize which pods to migrate
 	//
@@ -215,7 +268,8 @@ type Metrics struct {
 	LoadAverage1Min float32 `json:"loadAvg1M"`
 	// DEPRECATED. Will be removed in an upcoming release.
 	LoadAverage5Min *float32 `json:"loadAvg5M,omitempty"`
-	// DEPRECATED. Will be removed in an upcoming release.
+	// MemoryUsageBytes, if not nil, gives the VM's current memory usage. It is used by the
+	// scheduler plugin if the plugin's usage blending is enabled.
 	MemoryUsageBytes *float32 `json:"memoryUsageBytes,omitempty"`
 }
 
@@ -373,29 +427,22 @@ func (r Resources) SaturatingSub(other Resources) Resources {
 	}
 }
 
-// Mul returns the result of multiplying each resource by factor
+// Mul returns the result of multiplying each resource by factor, with values that *would* overflow
+// instead set to their maximum.
 func (r Resources) Mul(factor uint16) Resources {
 	return Resources{
-		VCPU: vmv1.MilliCPU(factor) * r.VCPU,
-		Mem:  Bytes(factor) * r.Mem,
+		VCPU: cuarith.SaturatingMul(r.VCPU, uint64(factor)),
+		Mem:  cuarith.SaturatingMul(r.Mem, uint64(factor)),
 	}
 }
 
 // DivResources divides the resources by the smaller amount, returning the uint16 value such that
 // other.Mul(factor) is equal to the original resources.
 //
-// If r is not an integer multiple of other, then (0, false) will be returned.
+// If r is not an integer multiple of other (or other has zero CPU or memory, or the factor does
+// not fit in a uint16), then (0, false) will be returned.
 func (r Resources) DivResources(other Resources) (uint16, bool) {
-	cpuFactor := uint16(r.VCPU / other.VCPU)
-	cpuOk := r.VCPU%other.VCPU == 0
-	memFactor := uint16(r.Mem / other.Mem)
-	memOk := r.Mem%other.Mem == 0
-
-	if !cpuOk || !memOk || cpuFactor != memFactor {
-		return 0, false
-	}
-
-	return cpuFactor, true // already known equal to memFactor
+	return cuarith.CUs(r.VCPU, r.Mem, other.VCPU, other.Mem)
 }
 
 // AbsDiff returns a new Resources with each field F as the absolute value of the difference between
@@ -438,6 +485,23 @@ type PluginResponse struct {
 	// Migrate, if present, notifies the autoscaler-agent that its VM will be migrated away,
 	// alongside whatever other information may be useful.
 	Migrate *MigrateResponse `json:"migrate,omitempty"`
+
+	// NodePressure, if present, notifies the autoscaler-agent that its VM's node is under memory
+	// pressure, and that it should downscale the VM if it can.
+	//
+	// This is only sent for protocol versions where SupportsNodePressure() is true.
+	NodePressure *NodePressureResponse `json:"nodePressure,omitempty"`
+}
+
+// NodePressureResponse, when provided, asks the autoscaler-agent to downscale its VM to reduce
+// pressure on the node, as a gentler alternative to kubelet evicting pods.
+//
+// The request is advisory: the autoscaler-agent still respects the VM's minimum bounds, and the
+// vm-monitor may deny the downscaling. It is in effect until a PluginResponse without it is
+// received.
+type NodePressureResponse struct {
+	// MaxResources is the upper bound that the VM should be downscaled to.
+	MaxResources Resources `json:"maxResources"`
 }
 
 // MigrateResponse, when provided, is a notification to the autsocaler-agent that it will migrate
@@ -471,6 +535,39 @@ func (m MoreResources) And(cmp MoreResources) MoreResources {
 	}
 }
 
+////////////////////////////////////////
+// Agent <-> Scaling Webhook Messages //
+////////////////////////////////////////
+
+// ScalingWebhookRequest is the message the autoscaler-agent sends to a VM's scaling webhook (see
+// AnnotationScalingWebhook) before changing the VM's resources.
+//
+// All ScalingWebhookRequests expect a ScalingWebhookResponse.
+type ScalingWebhookRequest struct {
+	// VM is the namespaced name of the VM that's being scaled
+	VM util.NamespacedName `json:"vm"`
+	// Current gives the VM's current resources
+	Current Resources `json:"current"`
+	// Desired gives the resources that the autoscaler-agent wants to scale the VM to
+	Desired Resources `json:"desired"`
+	// Min and Max give the VM's scaling bounds. Any adjusted resources in the response will be
+	// clamped to be within them.
+	Min Resources `json:"min"`
+	Max Resources `json:"max"`
+}
+
+// ScalingWebhookResponse is the response to a ScalingWebhookRequest, allowing the scaling webhook
+// to accept, adjust, or veto the autoscaler-agent's desired resources.
+type ScalingWebhookResponse struct {
+	// Veto, if true, tells the autoscaler-agent to keep the VM at its current resources.
+	Veto bool `json:"veto,omitempty"`
+	// Resources, if not nil and Veto is false, gives the resources to scale the VM to instead of
+	// the desired resources in the request.
+	//
+	// If nil and Veto is false, the desired resources are accepted as-is.
+	Resources *Resources `json:"resources,omitempty"`
+}
+
 ////////////////////////////////////
 // Controller <-> Runner Messages //
 ////////////////////////////////////
@@ -485,6 +582,68 @@ type VCPUChange struct {
 // it represents the vCPU usage as controlled by cgroup
 type VCPUCgroup struct {
 	VCPUs vmv1.MilliCPU
+
+	// GuestVCPUs, if not nil, gives the CPUs that are online inside the guest, as reported by
+	// neonvm-daemon. It's nil if neonvm-daemon couldn't be reached, or with older runners.
+	GuestVCPUs *vmv1.MilliCPU `json:",omitempty"`
+}
+
+// HibernationState is the state of saving a VM's state to hibernate it, as reported by the runner
+type HibernationState string
+
+const (
+	HibernationStateNotStarted HibernationState = "NotStarted"
+	HibernationStateInProgress HibernationState = "InProgress"
+	HibernationStateCompleted  HibernationState = "Completed"
+	HibernationStateFailed     HibernationState = "Failed"
+)
+
+// HibernationStatus is used in runner to reply to controller about the progress of hibernating the
+// VM
+type HibernationStatus struct {
+	State HibernationState `json:"state"`
+	// Error, if State is HibernationStateFailed, describes why hibernating failed.
+	Error string `json:"error,omitempty"`
+}
+
+// DiskUsage is the usage of a filesystem in the guest, as reported by neonvm-daemon to the runner,
+// and by the runner to the controller.
+type DiskUsage struct {
+	// Name is the name of the disk in the VM spec, or "rootdisk" for the root disk. It's empty in
+	// responses from neonvm-daemon, which doesn't know the names of the disks.
+	Name      string `json:"name,omitempty"`
+	MountPath string `json:"mountPath"`
+
+	TotalBytes uint64 `json:"totalBytes"`
+	UsedBytes  uint64 `json:"usedBytes"`
+	// AvailableBytes is the space available to unprivileged users, which may be less than the
+	// space that's not used, because some may be reserved for root.
+	AvailableBytes uint64 `json:"availableBytes"`
+}
+
+// UsedFraction returns the fraction of the filesystem's usable space that is in use, from 0 to 1.
+//
+// Like df, this excludes the space reserved for root.
+func (d DiskUsage) UsedFraction() float64 {
+	usable := d.UsedBytes + d.AvailableBytes
+	if usable == 0 {
+		return 0
+	}
+	return float64(d.UsedBytes) / float64(usable)
+}
+
+// WarmPoolRebind is sent by the controller to the runner of a warm pool VM when it's claimed, with
+// the parts of the claiming VM's spec that the pool VM was booted without.
+//
+// The runner passes these on to the guest, which starts the workload once they've been received.
+// Sending the same WarmPoolRebind more than once is safe.
+type WarmPoolRebind struct {
+	Command []string      `json:"command,omitempty"`
+	Args    []string      `json:"args,omitempty"`
+	Env     []vmv1.EnvVar `json:"env,omitempty"`
+	// Files maps each directory in the guest to the files to write there, by name, e.g. for the
+	// claiming VM's ConfigMap and Secret disks and its TLS certificate.
+	Files map[string]map[string][]byte `json:"files,omitempty"`
 }
 
 // this a similar version type for controller <-> runner communications
@@ -6,6 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"time"
 
 	"github.com/samber/lo"
 	"github.com/tychoish/fun/erc"
@@ -16,6 +19,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api/cuarith"
 	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
@@ -28,11 +32,88 @@ const (
 	AnnotationAutoscalingUnit     = "autoscaling.neon.tech/scaling-unit"
 	AnnotationBillingEndpointID   = "autoscaling.neon.tech/billing-endpoint-id"
 
+	// AnnotationSteadyStateResources is set by the autoscaler-agent to the most recent resources
+	// that the VM stayed at for a while. When the VM's runner pod is restarted, neonvm-controller
+	// uses it as the VM's initial size, instead of whatever size the VM happened to be at just
+	// before the restart.
+	AnnotationSteadyStateResources = "autoscaling.neon.tech/steady-state-resources"
+
 	// For internal use only, between the autoscaler-agent and scheduler plugin:
 	InternalAnnotationResourcesRequested = "internal.autoscaling.neon.tech/resources-requested"
 	InternalAnnotationResourcesApproved  = "internal.autoscaling.neon.tech/resources-approved"
+
+	InternalAnnotationPreapprovalRequested = "internal.autoscaling.neon.tech/preapproval-requested"
+	InternalAnnotationResourcesPreapproved = "internal.autoscaling.neon.tech/resources-preapproved"
+
+	// InternalAnnotationResourcesConfirmed is set by the scheduler plugin to the resources that the
+	// autoscaler-agent has confirmed setting on the VM, and InternalAnnotationReservationExpiresAt
+	// to the time after which unconfirmed upscaling is released.
+	InternalAnnotationResourcesConfirmed   = "internal.autoscaling.neon.tech/resources-confirmed"
+	InternalAnnotationReservationExpiresAt = "internal.autoscaling.neon.tech/reservation-expires-at"
 )
 
+// LabelDisruptionGroup groups together VMs in the same namespace (e.g., a primary and its
+// replicas), so that at most one VM in the group may be migrating at any time.
+//
+// This is enforced both by the scheduler plugin when choosing VMs to migrate, and by
+// neonvm-controller when starting a VirtualMachineMigration.
+const LabelDisruptionGroup = "autoscaling.neon.tech/disruption-group"
+
+// AnnotationBusy may be set to "true" on a VM's pod while it's doing work that would be badly
+// affected by live migration (e.g., a bulk load), so that the scheduler plugin can defer migrating
+// it, if configured to do so.
+const AnnotationBusy = "autoscaling.neon.tech/busy"
+
+// AnnotationNetworkBandwidth may be set on a VM to the network bandwidth, in bits per second, that
+// it's expected to use (e.g. "2G"), so that the scheduler plugin can avoid placing too many
+// network-heavy VMs on the same node.
+//
+// Like other annotations on the VM, it's copied to the VM's runner pod.
+const AnnotationNetworkBandwidth = "autoscaling.neon.tech/network-bandwidth"
+
+// AnnotationNeverMigrate may be set to "true" on a VM to prevent it from ever being live-migrated,
+// regardless of LabelEnableAutoMigration. It's treated the same as
+// vmv1.VirtualMachineNeverMigrateLabel -- see IsMarkedNeverMigrate.
+//
+// Like other annotations on the VM, it's copied to the VM's runner pod.
+const AnnotationNeverMigrate = "autoscaling.neon.tech/never-migrate"
+
+// AnnotationMigrationCost may be set on a VM to a non-negative integer giving the relative cost of
+// live migrating it (e.g., because of its size or how disruptive it is for the workload). When
+// choosing VMs to migrate away from a node, the scheduler plugin prefers VMs with a lower cost,
+// before applying its configured policy. VMs without the annotation have a cost of zero.
+//
+// Like other annotations on the VM, it's copied to the VM's runner pod.
+const AnnotationMigrationCost = "autoscaling.neon.tech/migration-cost"
+
+// AnnotationStorageLocality may be set on a VM to request that its runner pod be placed close to
+// (or away from) the storage backing one of its network-attached volumes, with a value in the
+// format of StorageLocality, e.g. '{"persistentVolumeClaim":"pgdata","policy":"Colocate"}'.
+//
+// It's only used if neonvm-controller is configured with a storage topology key. The controller
+// resolves the claim's PersistentVolume to its topology, adds a preferred node affinity for it to
+// the runner pod, and records the result in InternalAnnotationStorageTopology.
+const AnnotationStorageLocality = "autoscaling.neon.tech/storage-locality"
+
+// InternalAnnotationStorageTopology is set by neonvm-controller on runner pods for VMs with
+// AnnotationStorageLocality, to the resolved StorageTopology, so that the scheduler plugin can
+// report on how well the preference was satisfied.
+const InternalAnnotationStorageTopology = "internal.autoscaling.neon.tech/storage-topology"
+
+// AnnotationScalingWebhook may be set on a VM to the name of one of the scaling webhooks in the
+// autoscaler-agent's config. If it is, the autoscaler-agent asks the webhook before changing the
+// VM's resources, so that it may adjust or veto the change (see ScalingWebhookRequest).
+const AnnotationScalingWebhook = "autoscaling.neon.tech/scaling-webhook"
+
+// AnnotationFallbackScheduled is set on a VM's pod by the autoscaler-agent when it placed the pod
+// onto its own node because the scheduler was unavailable, with the time it did so.
+const AnnotationFallbackScheduled = "autoscaling.neon.tech/fallback-scheduled"
+
+// LabelSchedulerLeader is set to "true" by the scheduler plugin on its own pod while it's the
+// leader, if leader election is enabled, so that autoscaler-agents send their requests to the
+// leader instead of a standby replica.
+const LabelSchedulerLeader = "autoscaling.neon.tech/scheduler-leader"
+
 func hasTrueLabel(obj metav1.ObjectMetaAccessor, labelName string) bool {
 	labels := obj.GetObjectMeta().GetLabels()
 	value, ok := labels[labelName]
@@ -54,6 +135,121 @@ func HasAlwaysMigrateLabel(obj metav1.ObjectMetaAccessor) bool {
 	return hasTrueLabel(obj, LabelTestingOnlyAlwaysMigrate)
 }
 
+// DisruptionGroup returns the value of the object's LabelDisruptionGroup label, or the empty string
+// if it's not in a disruption group.
+func DisruptionGroup(obj metav1.ObjectMetaAccessor) string {
+	return obj.GetObjectMeta().GetLabels()[LabelDisruptionGroup]
+}
+
+// IsMarkedBusy returns true iff the object has the AnnotationBusy annotation set to "true".
+func IsMarkedBusy(obj metav1.ObjectMetaAccessor) bool {
+	return obj.GetObjectMeta().GetAnnotations()[AnnotationBusy] == "true"
+}
+
+// IsMarkedNeverMigrate returns true iff the object has the AnnotationNeverMigrate annotation or
+// the vmv1.VirtualMachineNeverMigrateLabel label set to "true".
+//
+// This is shared by the scheduler plugin and neonvm-controller, so that both agree on which VMs
+// must not be migrated.
+func IsMarkedNeverMigrate(obj metav1.ObjectMetaAccessor) bool {
+	return obj.GetObjectMeta().GetAnnotations()[AnnotationNeverMigrate] == "true" ||
+		hasTrueLabel(obj, vmv1.VirtualMachineNeverMigrateLabel)
+}
+
+// ExtractMigrationCost returns the cost given by the object's AnnotationMigrationCost annotation,
+// or zero if the annotation is not present.
+func ExtractMigrationCost(obj metav1.ObjectMetaAccessor) (uint64, error) {
+	value, ok := obj.GetObjectMeta().GetAnnotations()[AnnotationMigrationCost]
+	if !ok {
+		return 0, nil
+	}
+
+	cost, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s annotation: %w", AnnotationMigrationCost, err)
+	}
+	return cost, nil
+}
+
+// ExtractNetworkBandwidth returns the bandwidth in bits per second given by the object's
+// AnnotationNetworkBandwidth annotation, or zero if the annotation is not present.
+func ExtractNetworkBandwidth(obj metav1.ObjectMetaAccessor) (uint64, error) {
+	value, ok := obj.GetObjectMeta().GetAnnotations()[AnnotationNetworkBandwidth]
+	if !ok {
+		return 0, nil
+	}
+
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s annotation: %w", AnnotationNetworkBandwidth, err)
+	} else if q.Sign() < 0 {
+		return 0, fmt.Errorf("%s annotation must not be negative", AnnotationNetworkBandwidth)
+	}
+	return uint64(q.Value()), nil
+}
+
+// StorageLocalityPolicy is whether a VM's runner pod should be placed close to or away from its
+// storage.
+type StorageLocalityPolicy string
+
+const (
+	// StorageLocalityColocate prefers nodes in the same topology domain as the storage, e.g. to
+	// reduce cross-AZ read latency and transfer costs.
+	StorageLocalityColocate StorageLocalityPolicy = "Colocate"
+	// StorageLocalityAntiColocate prefers nodes in other topology domains than the storage, e.g.
+	// so that a replica doesn't share a failure domain with its storage.
+	StorageLocalityAntiColocate StorageLocalityPolicy = "AntiColocate"
+)
+
+// StorageLocality is the value of the AnnotationStorageLocality annotation.
+type StorageLocality struct {
+	// PersistentVolumeClaim is the name of the claim, in the VM's namespace, whose volume's
+	// topology should be used.
+	PersistentVolumeClaim string `json:"persistentVolumeClaim"`
+	// Policy is whether to prefer nodes close to or away from the volume.
+	Policy StorageLocalityPolicy `json:"policy"`
+}
+
+func (l StorageLocality) validate() error {
+	if l.PersistentVolumeClaim == "" {
+		return errors.New("persistentVolumeClaim must be set")
+	}
+	switch l.Policy {
+	case StorageLocalityColocate, StorageLocalityAntiColocate:
+		return nil
+	default:
+		return fmt.Errorf("unknown policy %q", l.Policy)
+	}
+}
+
+// StorageTopology is the value of the InternalAnnotationStorageTopology annotation.
+type StorageTopology struct {
+	Policy StorageLocalityPolicy `json:"policy"`
+	// Key is the node label that describes the topology, e.g. "topology.kubernetes.io/zone".
+	Key string `json:"key"`
+	// Values are the values of Key where the storage is available.
+	Values []string `json:"values"`
+}
+
+// ExtractStorageLocality returns the value of the object's AnnotationStorageLocality annotation,
+// or nil if the annotation is not present.
+func ExtractStorageLocality(obj metav1.ObjectMetaAccessor) (*StorageLocality, error) {
+	locality, err := extractAnnotationJSON[StorageLocality](obj, AnnotationStorageLocality)
+	if err != nil || locality == nil {
+		return nil, err
+	}
+	if err := locality.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", AnnotationStorageLocality, err)
+	}
+	return locality, nil
+}
+
+// ExtractStorageTopology returns the value of the object's InternalAnnotationStorageTopology
+// annotation, or nil if the annotation is not present.
+func ExtractStorageTopology(obj metav1.ObjectMetaAccessor) (*StorageTopology, error) {
+	return extractAnnotationJSON[StorageTopology](obj, InternalAnnotationStorageTopology)
+}
+
 func extractAnnotationJSON[T any](obj metav1.ObjectMetaAccessor, annotation string) (*T, error) {
 	jsonString, ok := obj.GetObjectMeta().GetAnnotations()[annotation]
 	if !ok {
@@ -73,6 +269,12 @@ func ExtractScalingUnit(obj metav1.ObjectMetaAccessor) (*Resources, error) {
 	return extractAnnotationJSON[Resources](obj, AnnotationAutoscalingUnit)
 }
 
+// ExtractSteadyStateResources returns the resources stored in the object's
+// AnnotationSteadyStateResources annotation, or nil if the annotation is not present.
+func ExtractSteadyStateResources(obj metav1.ObjectMetaAccessor) (*Resources, error) {
+	return extractAnnotationJSON[Resources](obj, AnnotationSteadyStateResources)
+}
+
 func ExtractRequestedScaling(obj metav1.ObjectMetaAccessor) (*Resources, error) {
 	return extractAnnotationJSON[Resources](obj, InternalAnnotationResourcesRequested)
 }
@@ -81,6 +283,24 @@ func ExtractApprovedScaling(obj metav1.ObjectMetaAccessor) (*Resources, error) {
 	return extractAnnotationJSON[Resources](obj, InternalAnnotationResourcesApproved)
 }
 
+func ExtractPreapprovalRequest(obj metav1.ObjectMetaAccessor) (*Resources, error) {
+	return extractAnnotationJSON[Resources](obj, InternalAnnotationPreapprovalRequested)
+}
+
+func ExtractPreapprovedScaling(obj metav1.ObjectMetaAccessor) (*Resources, error) {
+	return extractAnnotationJSON[Resources](obj, InternalAnnotationResourcesPreapproved)
+}
+
+func ExtractConfirmedScaling(obj metav1.ObjectMetaAccessor) (*Resources, error) {
+	return extractAnnotationJSON[Resources](obj, InternalAnnotationResourcesConfirmed)
+}
+
+// ExtractReservationExpiry returns the time from the object's
+// InternalAnnotationReservationExpiresAt annotation, or nil if the annotation is not present.
+func ExtractReservationExpiry(obj metav1.ObjectMetaAccessor) (*time.Time, error) {
+	return extractAnnotationJSON[time.Time](obj, InternalAnnotationReservationExpiresAt)
+}
+
 // VmInfo is the subset of vmv1.VirtualMachineSpec that the scheduler plugin and autoscaler agent
 // care about. It takes various labels and annotations into account, so certain fields might be
 // different from what's strictly in the VirtualMachine object.
@@ -144,27 +364,42 @@ type VmConfig struct {
 	AlwaysMigrate  bool           `json:"alwaysMigrate"`
 	ScalingEnabled bool           `json:"scalingEnabled"`
 	ScalingConfig  *ScalingConfig `json:"scalingConfig,omitempty"`
+	// ComputeUnitFamily is the value of the VM's LabelComputeUnitFamily label, if present. It's
+	// used to select the VM's Compute Unit from the shared ComputeUnitConfig.
+	ComputeUnitFamily string `json:"computeUnitFamily,omitempty"`
+	// ScalingFrozen is true if the VM's .spec.scalingFrozen is set, in which case the
+	// autoscaler-agent must not change the VM's resources.
+	ScalingFrozen bool `json:"scalingFrozen,omitempty"`
+	// ScalingWebhook is the value of the VM's AnnotationScalingWebhook annotation, if present,
+	// giving the name of the scaling webhook to consult before changing the VM's resources.
+	ScalingWebhook string `json:"scalingWebhook,omitempty"`
 }
 
 // Using returns the Resources that this VmInfo says the VM is using
 func (vm VmInfo) Using() Resources {
 	return Resources{
 		VCPU: vm.Cpu.Use,
-		Mem:  vm.Mem.SlotSize * Bytes(vm.Mem.Use),
+		Mem:  cuarith.BytesFromSlots(uint64(vm.Mem.Use), vm.Mem.SlotSize),
 	}
 }
 
 // SetUsing sets the values of vm.{Cpu,Mem}.Use to those provided by r
 func (vm *VmInfo) SetUsing(r Resources) {
 	vm.Cpu.Use = r.VCPU
-	vm.Mem.Use = uint16(r.Mem / vm.Mem.SlotSize)
+	vm.Mem.Use = memSlotsFromBytes(r.Mem, vm.Mem.SlotSize)
+}
+
+// memSlotsFromBytes returns the number of whole memory slots in mem, capped at math.MaxUint16.
+func memSlotsFromBytes(mem Bytes, slotSize Bytes) uint16 {
+	slots, _ := cuarith.SlotsFromBytes(mem, slotSize, math.MaxUint16)
+	return uint16(slots)
 }
 
 // Min returns the Resources representing the minimum amount this VmInfo says the VM must reserve
 func (vm VmInfo) Min() Resources {
 	return Resources{
 		VCPU: vm.Cpu.Min,
-		Mem:  vm.Mem.SlotSize * Bytes(vm.Mem.Min),
+		Mem:  cuarith.BytesFromSlots(uint64(vm.Mem.Min), vm.Mem.SlotSize),
 	}
 }
 
@@ -172,7 +407,7 @@ func (vm VmInfo) Min() Resources {
 func (vm VmInfo) Max() Resources {
 	return Resources{
 		VCPU: vm.Cpu.Max,
-		Mem:  vm.Mem.SlotSize * Bytes(vm.Mem.Max),
+		Mem:  cuarith.BytesFromSlots(uint64(vm.Mem.Max), vm.Mem.SlotSize),
 	}
 }
 
@@ -188,6 +423,7 @@ func ExtractVmInfo(logger *zap.Logger, vm *vmv1.VirtualMachine) (*VmInfo, error)
 	}
 
 	info.CurrentRevision = vm.Status.CurrentRevision
+	info.Config.ScalingFrozen = vm.Spec.ScalingFrozen
 	return info, nil
 }
 
@@ -200,7 +436,13 @@ func ExtractVmInfoFromPod(logger *zap.Logger, pod *corev1.Pod) (*VmInfo, error)
 	}
 
 	vmName := pod.Labels[vmv1.VirtualMachineNameLabel]
-	return extractVmInfoGeneric(logger, vmName, pod, *resources)
+	info, err := extractVmInfoGeneric(logger, vmName, pod, *resources)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Config.ScalingFrozen = vmv1.ScalingFrozenForPod(pod)
+	return info, nil
 }
 
 func extractVmInfoGeneric(
@@ -226,6 +468,9 @@ func extractVmInfoGeneric(
 			AlwaysMigrate:        alwaysMigrate,
 			ScalingEnabled:       scalingEnabled,
 			ScalingConfig:        nil, // set below, maybe
+			ComputeUnitFamily:    ComputeUnitFamily(obj),
+			ScalingFrozen:        false, // set by caller
+			ScalingWebhook:       obj.GetObjectMeta().GetAnnotations()[AnnotationScalingWebhook],
 		},
 		CurrentRevision: nil, // set later, maybe
 	}
@@ -296,11 +541,8 @@ func (vm VmInfo) EqualScalingBounds(cmp VmInfo) bool {
 func (vm *VmInfo) applyBounds(b ScalingBounds) {
 	vm.Cpu.Min = vmv1.MilliCPUFromResourceQuantity(b.Min.CPU)
 	vm.Cpu.Max = vmv1.MilliCPUFromResourceQuantity(b.Max.CPU)
-
-	// FIXME: this will be incorrect if b.{Min,Max}.Mem.Value() is greater than
-	// (2^16-1) * info.Mem.SlotSize.Value().
-	vm.Mem.Min = uint16(BytesFromResourceQuantity(b.Min.Mem) / vm.Mem.SlotSize)
-	vm.Mem.Max = uint16(BytesFromResourceQuantity(b.Max.Mem) / vm.Mem.SlotSize)
+	vm.Mem.Min = memSlotsFromBytes(BytesFromResourceQuantity(b.Min.Mem), vm.Mem.SlotSize)
+	vm.Mem.Max = memSlotsFromBytes(BytesFromResourceQuantity(b.Max.Mem), vm.Mem.SlotSize)
 }
 
 // ScalingBounds is the type that we deserialize from the "autoscaling.neon.tech/bounds" annotation
@@ -415,6 +657,22 @@ type ScalingConfig struct {
 	// means that stable zone will be from 0.75*load5 to 1.25*load5, and mixed zone will be
 	// from 0.6*load5 to 0.75*load5, and from 1.25*load5 to 1.4*load5.
 	CPUMixedZoneRatio *float64 `json:"cpuMixedZoneRatio,omitempty"`
+
+	// StartupBoostCU, if non-zero, gives the number of Compute Units above the VM's minimum that
+	// the VM should be given for a short time after it's started from scratch, to hide the cost of
+	// warming up its caches. Like any other upscaling, the boost must be approved by the scheduler
+	// plugin, and is capped at the VM's maximum.
+	//
+	// This field is optional. If it's not set, there's no startup boost.
+	StartupBoostCU *int `json:"startupBoostCU,omitempty"`
+
+	// StartupBoostSeconds gives the duration after the VM is started for which the full
+	// StartupBoostCU applies.
+	StartupBoostSeconds *int `json:"startupBoostSeconds,omitempty"`
+
+	// StartupBoostDecaySeconds gives the duration after StartupBoostSeconds over which the boost
+	// decreases linearly back to zero. If not set, the boost ends all at once.
+	StartupBoostDecaySeconds *int `json:"startupBoostDecaySeconds,omitempty"`
 }
 
 // WithOverrides returns a new copy of defaults, where fields set in overrides replace the ones in
@@ -458,6 +716,16 @@ func (defaults ScalingConfig) WithOverrides(overrides *ScalingConfig) ScalingCon
 		defaults.CPUMixedZoneRatio = lo.ToPtr(*overrides.CPUMixedZoneRatio)
 	}
 
+	if overrides.StartupBoostCU != nil {
+		defaults.StartupBoostCU = lo.ToPtr(*overrides.StartupBoostCU)
+	}
+	if overrides.StartupBoostSeconds != nil {
+		defaults.StartupBoostSeconds = lo.ToPtr(*overrides.StartupBoostSeconds)
+	}
+	if overrides.StartupBoostDecaySeconds != nil {
+		defaults.StartupBoostDecaySeconds = lo.ToPtr(*overrides.StartupBoostDecaySeconds)
+	}
+
 	return defaults
 }
 
@@ -514,6 +782,20 @@ func (c *ScalingConfig) validate(requireAll bool) error {
 		erc.Whenf(ec, c.CPUMixedZoneRatio == nil, "%s is a required field", ".cpuMixedZoneRatio")
 	}
 
+	// The startup boost fields are optional, even for the defaults.
+	for _, f := range []struct {
+		name  string
+		value *int
+	}{
+		{".startupBoostCU", c.StartupBoostCU},
+		{".startupBoostSeconds", c.StartupBoostSeconds},
+		{".startupBoostDecaySeconds", c.StartupBoostDecaySeconds},
+	} {
+		if f.value != nil {
+			erc.Whenf(ec, *f.value < 0, "%s must be set to value >= 0", f.name)
+		}
+	}
+
 	// heads-up! some functions elsewhere depend on the concrete return type of this function.
 	return ec.Resolve()
 }
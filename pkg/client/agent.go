@@ -0,0 +1,48 @@
+package client
+
+// Client for the autoscaler-agent's state dump API, served on the port given by the agent's
+// .dumpState.port config (10300 by default).
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// AgentClient makes requests to a single autoscaler-agent
+type AgentClient struct {
+	baseURL string
+	opts    Options
+}
+
+// NewAgentClient returns an AgentClient for the autoscaler-agent's state dump server at baseURL --
+// for example, "http://10.0.0.1:10300".
+func NewAgentClient(baseURL string, opts Options) *AgentClient {
+	return &AgentClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		opts:    opts,
+	}
+}
+
+// AgentStateDump is the internal state of an autoscaler-agent, as returned by
+// (*AgentClient).DumpState().
+type AgentStateDump struct {
+	// Stopped is true if the autoscaler-agent has started shutting down
+	Stopped bool `json:"stopped"`
+	// Pods gives the state of each VM pod on the agent's node.
+	//
+	// The contents are internal to the autoscaler-agent, and may change between versions, so they
+	// are left as raw JSON.
+	Pods []json.RawMessage `json:"pods"`
+}
+
+// DumpState fetches the internal state of the autoscaler-agent
+func (c *AgentClient) DumpState(ctx context.Context) (*AgentStateDump, error) {
+	// The server expects a JSON body, even though it's empty.
+	var resp AgentStateDump
+	if err := doJSON(ctx, c.opts, http.MethodGet, c.baseURL+"/", struct{}{}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,144 @@
+// Package client provides Go clients for the HTTP APIs served by the scheduler plugin and the
+// autoscaler-agent, so that other services (and tests) don't need to hand-roll requests against
+// them.
+//
+// The request and response types are the ones defined in pkg/api, and are versioned as described
+// there. This package follows the same compatibility guarantees: changes to exported identifiers
+// are backwards-compatible within a major version of the repository.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// UserAgent is sent as the User-Agent header on every request made by this package.
+const UserAgent = "neondatabase-autoscaling-client/v1"
+
+// Options configures how requests are made. The zero value is valid, and uses the defaults
+// described on each field.
+type Options struct {
+	// HTTPClient, if not nil, is used to make requests. Otherwise, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Timeout, if not zero, gives the timeout for each individual attempt at a request.
+	Timeout time.Duration
+	// BearerToken, if not empty, is sent in the Authorization header of every request, for use
+	// with servers behind an authenticating proxy.
+	BearerToken string
+	// Retry configures retrying failed requests. If nil, requests are not retried.
+	Retry *RetryOptions
+}
+
+// RetryOptions configures retrying requests that failed because of a transport error or a 5XX or
+// 429 response.
+type RetryOptions struct {
+	// MaxAttempts gives the maximum number of attempts, including the first. If zero, there's no
+	// limit beyond the context passed to the request.
+	MaxAttempts uint
+	// MinWait gives the time to wait after the first failed attempt. The wait doubles after each
+	// failed attempt, up to MaxWait.
+	MinWait time.Duration
+	// MaxWait gives the maximum time to wait between attempts.
+	MaxWait time.Duration
+}
+
+// StatusError is returned when the server responds with a status other than 200 OK.
+type StatusError struct {
+	StatusCode int
+	// Body is the body of the response, which is typically a plain-text error message.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received response status %d body %q", e.StatusCode, e.Body)
+}
+
+// retryable returns whether the request should be retried after receiving this error
+func (e *StatusError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// doJSON makes a request with the JSON-encoded reqBody (if not nil) and decodes the JSON response
+// into respBody, retrying according to opts.
+func doJSON(ctx context.Context, opts Options, method, url string, reqBody any, respBody any) error {
+	var body []byte
+	if reqBody != nil {
+		var err error
+		if body, err = json.Marshal(reqBody); err != nil {
+			return fmt.Errorf("error encoding request JSON: %w", err)
+		}
+	}
+
+	wait := time.Duration(0)
+	for attempt := uint(1); ; attempt++ {
+		err := doJSONOnce(ctx, opts, method, url, body, respBody)
+		if err == nil {
+			return nil
+		}
+
+		var statusErr *StatusError
+		retryable := !errors.As(err, &statusErr) || statusErr.retryable()
+		if opts.Retry == nil || !retryable || ctx.Err() != nil ||
+			(opts.Retry.MaxAttempts != 0 && attempt >= opts.Retry.MaxAttempts) {
+			return err
+		}
+
+		wait = min(max(2*wait, opts.Retry.MinWait), opts.Retry.MaxWait)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up retrying: %w)", err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+func doJSONOnce(ctx context.Context, opts Options, method, url string, body []byte, respBody any) error {
+	if opts.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error building request to %q: %w", url, err)
+	}
+	request.Header.Set("user-agent", UserAgent)
+	if body != nil {
+		request.Header.Set("content-type", "application/json")
+	}
+	if opts.BearerToken != "" {
+		request.Header.Set("authorization", "Bearer "+opts.BearerToken)
+	}
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error doing request: %w", err)
+	}
+	defer response.Body.Close()
+
+	respBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("error reading body for response: %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: response.StatusCode, Body: string(respBytes)}
+	}
+
+	if err := json.Unmarshal(respBytes, respBody); err != nil {
+		return fmt.Errorf("bad JSON response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/client"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+func TestPluginClientRequest(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		// Fail the first attempt, to check that it's retried
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var req api.AgentRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, api.PluginProtoV5_2, req.ProtoVersion)
+
+		_ = json.NewEncoder(w).Encode(api.PluginResponse{
+			Permit:       req.Resources,
+			Migrate:      nil,
+			NodePressure: nil,
+		})
+	}))
+	defer server.Close()
+
+	c := client.NewPluginClient(server.URL, client.Options{
+		HTTPClient:  nil,
+		Timeout:     time.Second,
+		BearerToken: "secret",
+		Retry: &client.RetryOptions{
+			MaxAttempts: 3,
+			MinWait:     time.Millisecond,
+			MaxWait:     time.Millisecond,
+		},
+	})
+
+	resources := api.Resources{VCPU: 250, Mem: 1 << 30}
+	//nolint:exhaustruct // only the fields the test server checks
+	resp, err := c.Request(context.Background(), api.AgentRequest{
+		ProtoVersion: api.PluginProtoV5_2,
+		Pod:          util.NamespacedName{Namespace: "default", Name: "pod"},
+		Resources:    resources,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, resources, resp.Permit)
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("must be POST"))
+	}))
+	defer server.Close()
+
+	c := client.NewAgentClient(server.URL, client.Options{
+		HTTPClient:  nil,
+		Timeout:     0,
+		BearerToken: "",
+		Retry: &client.RetryOptions{
+			MaxAttempts: 3,
+			MinWait:     time.Millisecond,
+			MaxWait:     time.Millisecond,
+		},
+	})
+
+	_, err := c.DumpState(context.Background())
+	var statusErr *client.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusBadRequest, statusErr.StatusCode)
+	assert.Equal(t, "must be POST", statusErr.Body)
+	assert.Equal(t, int32(1), attempts.Load())
+}
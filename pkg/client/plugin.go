@@ -0,0 +1,59 @@
+package client
+
+// Client for the scheduler plugin's API, served on port 10299.
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// PluginClient makes requests to a single scheduler plugin
+type PluginClient struct {
+	baseURL string
+	opts    Options
+}
+
+// NewPluginClient returns a PluginClient for the scheduler plugin at baseURL -- for example,
+// "http://10.0.0.1:10299".
+func NewPluginClient(baseURL string, opts Options) *PluginClient {
+	return &PluginClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		opts:    opts,
+	}
+}
+
+// Request sends the AgentRequest to the scheduler plugin, on behalf of the Pod in the request.
+//
+// The caller is responsible for setting req.ProtoVersion to a version of the protocol that it
+// supports. If the scheduler plugin doesn't support that version, it responds with a 400 status,
+// returned as a *StatusError.
+func (c *PluginClient) Request(ctx context.Context, req api.AgentRequest) (*api.PluginResponse, error) {
+	var resp api.PluginResponse
+	if err := doJSON(ctx, c.opts, http.MethodPost, c.baseURL+"/", &req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ReevaluateResponse is the response to (*PluginClient).Reevaluate()
+type ReevaluateResponse struct {
+	// Nodes is the number of nodes that were queued for re-evaluation
+	Nodes int `json:"nodes"`
+}
+
+// Reevaluate asks the scheduler plugin to immediately re-evaluate every node's watermark status
+// and any necessary migrations.
+//
+// This endpoint is served by the scheduler plugin's debug server rather than on port 10299, so the
+// PluginClient must be created with the debug server's URL, and Options.BearerToken set to its
+// token.
+func (c *PluginClient) Reevaluate(ctx context.Context) (*ReevaluateResponse, error) {
+	var resp ReevaluateResponse
+	if err := doJSON(ctx, c.opts, http.MethodPost, c.baseURL+"/reevaluate", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
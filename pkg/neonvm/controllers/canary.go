@@ -0,0 +1,448 @@
+package controllers
+
+// Synthetic canary VM, for continuous end-to-end verification of the autoscaling stack.
+//
+// Periodically, we create a small VirtualMachine in a designated namespace and walk it through
+// each stage of its lifecycle: scheduling, boot, scaling up and down, migration, and deletion.
+// Each stage must complete within its timeout, and the outcome of each stage is exported as
+// metrics, so that alerts can fire when any part of the stack stops working.
+//
+// The canary VM has autoscaling enabled and is scheduled by the autoscaling scheduler, so scaling
+// goes through the same path as for any other VM: we only change the VM's scaling bounds
+// annotation, and the autoscaler-agent gets the new size approved by the scheduler plugin before
+// requesting it from NeonVM.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// CanaryStage is a single step in the lifecycle of the canary VM, used as the "stage" label on
+// the canary metrics.
+type CanaryStage string
+
+const (
+	CanaryStageSchedule  CanaryStage = "schedule"
+	CanaryStageBoot      CanaryStage = "boot"
+	CanaryStageScaleUp   CanaryStage = "scale_up"
+	CanaryStageScaleDown CanaryStage = "scale_down"
+	CanaryStageMigrate   CanaryStage = "migrate"
+	CanaryStageDelete    CanaryStage = "delete"
+)
+
+// CanaryStages lists all stages, in the order that they're run.
+var CanaryStages = []CanaryStage{
+	CanaryStageSchedule,
+	CanaryStageBoot,
+	CanaryStageScaleUp,
+	CanaryStageScaleDown,
+	CanaryStageMigrate,
+	CanaryStageDelete,
+}
+
+// Values of the "outcome" label on the vm_canary_stage_runs_total metric
+const (
+	CanaryOutcomeSuccess = "success"
+	CanaryOutcomeFailure = "failure"
+	CanaryOutcomeTimeout = "timeout"
+	// CanaryOutcomeSkipped is used for stages that weren't run because an earlier stage didn't
+	// succeed.
+	CanaryOutcomeSkipped = "skipped"
+)
+
+// canaryLabel is set on every canary VM, so that VMs left over from previous runs (e.g. if the
+// controller restarted partway through) can be found and cleaned up.
+const canaryLabel = "vm.neon.tech/canary"
+
+// canaryPollInterval is how often we check the canary VM's status while waiting for a stage to
+// complete.
+const canaryPollInterval = 2 * time.Second
+
+type CanaryConfig struct {
+	// Namespace is the namespace to create the canary VM in.
+	Namespace string
+	// Image is the root disk image for the canary VM.
+	Image string
+	// SchedulerName is the scheduler for the canary VM, which must be the autoscaling scheduler so
+	// that the scheduler plugin approves its scaling.
+	SchedulerName string
+	// Interval is the time between the start of each canary run.
+	Interval time.Duration
+	// DefaultStageTimeout is the timeout for each stage without an entry in StageTimeouts.
+	DefaultStageTimeout time.Duration
+	// StageTimeouts optionally overrides the timeout for individual stages.
+	StageTimeouts map[CanaryStage]time.Duration
+}
+
+func (c *CanaryConfig) timeout(stage CanaryStage) time.Duration {
+	if t, ok := c.StageTimeouts[stage]; ok {
+		return t
+	}
+	return c.DefaultStageTimeout
+}
+
+type CanaryMetrics struct {
+	stageRuns     *prometheus.CounterVec
+	stageDuration *prometheus.HistogramVec
+	stagePassing  *prometheus.GaugeVec
+}
+
+func MakeCanaryMetrics() CanaryMetrics {
+	return CanaryMetrics{
+		stageRuns: util.RegisterMetric(metrics.Registry, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vm_canary_stage_runs_total",
+				Help: "Number of times each stage of the canary VM's lifecycle was run, by outcome",
+			},
+			[]string{"stage", OutcomeLabel},
+		)),
+		stageDuration: util.RegisterMetric(metrics.Registry, prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "vm_canary_stage_duration_seconds",
+				Help:    "Time taken by each successful stage of the canary VM's lifecycle",
+				Buckets: []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 90, 120, 180, 240, 300, 450, 600},
+			},
+			[]string{"stage"},
+		)),
+		stagePassing: util.RegisterMetric(metrics.Registry, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vm_canary_stage_passing",
+				Help: "Set to 1 if the stage succeeded in the most recent canary run, 0 otherwise",
+			},
+			[]string{"stage"},
+		)),
+	}
+}
+
+func (m CanaryMetrics) record(stage CanaryStage, outcome string, duration time.Duration) {
+	m.stageRuns.WithLabelValues(string(stage), outcome).Inc()
+	if outcome == CanaryOutcomeSuccess {
+		m.stageDuration.WithLabelValues(string(stage)).Observe(duration.Seconds())
+		m.stagePassing.WithLabelValues(string(stage)).Set(1)
+	} else {
+		m.stagePassing.WithLabelValues(string(stage)).Set(0)
+	}
+}
+
+// Canary periodically runs the canary VM through its lifecycle. It implements manager.Runnable,
+// and - because it doesn't implement manager.LeaderElectionRunnable - only runs on the leader.
+type Canary struct {
+	Client  client.Client
+	Config  CanaryConfig
+	Metrics CanaryMetrics
+	Logger  logr.Logger
+}
+
+func (c *Canary) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Config.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce creates a new canary VM and runs it through all stages, recording the outcome of each.
+//
+// If a stage fails, all later stages are skipped, except for deletion, which is always attempted
+// once the VM has been created.
+func (c *Canary) runOnce(ctx context.Context) {
+	if err := c.cleanupLeftovers(ctx); err != nil {
+		c.Logger.Error(err, "Failed to clean up leftover canary VMs")
+	}
+
+	vm := c.newVM()
+	if err := c.Client.Create(ctx, vm); err != nil {
+		c.Logger.Error(err, "Failed to create canary VM")
+		c.Metrics.record(CanaryStageSchedule, CanaryOutcomeFailure, 0)
+		c.skipFrom(CanaryStageBoot)
+		return
+	}
+	logger := c.Logger.WithValues("VirtualMachine", client.ObjectKeyFromObject(vm))
+	logger.Info("Created canary VM")
+
+	stages := []struct {
+		stage CanaryStage
+		run   func(context.Context, *vmv1.VirtualMachine) error
+	}{
+		{CanaryStageSchedule, c.waitScheduled},
+		{CanaryStageBoot, c.waitRunning},
+		{CanaryStageScaleUp, c.scaleUp},
+		{CanaryStageScaleDown, c.scaleDown},
+		{CanaryStageMigrate, c.migrate},
+	}
+
+	for i, s := range stages {
+		if err := c.runStage(ctx, logger, s.stage, vm, s.run); err != nil {
+			for _, skipped := range stages[i+1:] {
+				c.Metrics.record(skipped.stage, CanaryOutcomeSkipped, 0)
+			}
+			break
+		}
+	}
+
+	_ = c.runStage(ctx, logger, CanaryStageDelete, vm, c.delete)
+}
+
+// runStage runs a single stage with its timeout, and records the outcome.
+func (c *Canary) runStage(
+	ctx context.Context,
+	logger logr.Logger,
+	stage CanaryStage,
+	vm *vmv1.VirtualMachine,
+	run func(context.Context, *vmv1.VirtualMachine) error,
+) error {
+	stageCtx, cancel := context.WithTimeout(ctx, c.Config.timeout(stage))
+	defer cancel()
+
+	start := time.Now()
+	err := run(stageCtx, vm)
+	duration := time.Since(start)
+
+	switch {
+	case err == nil:
+		logger.Info("Canary stage succeeded", "stage", stage, "duration", duration.String())
+		c.Metrics.record(stage, CanaryOutcomeSuccess, duration)
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		logger.Error(err, "Canary stage timed out", "stage", stage, "duration", duration.String())
+		c.Metrics.record(stage, CanaryOutcomeTimeout, duration)
+	default:
+		logger.Error(err, "Canary stage failed", "stage", stage, "duration", duration.String())
+		c.Metrics.record(stage, CanaryOutcomeFailure, duration)
+	}
+	return err
+}
+
+func (c *Canary) skipFrom(first CanaryStage) {
+	skipping := false
+	for _, stage := range CanaryStages {
+		skipping = skipping || stage == first
+		if skipping {
+			c.Metrics.record(stage, CanaryOutcomeSkipped, 0)
+		}
+	}
+}
+
+// cleanupLeftovers deletes any canary VMs that weren't deleted by previous runs.
+func (c *Canary) cleanupLeftovers(ctx context.Context) error {
+	var vms vmv1.VirtualMachineList
+	if err := c.Client.List(
+		ctx,
+		&vms,
+		client.InNamespace(c.Config.Namespace),
+		client.HasLabels{canaryLabel},
+	); err != nil {
+		return fmt.Errorf("could not list canary VMs: %w", err)
+	}
+
+	for i := range vms.Items {
+		vm := &vms.Items[i]
+		if vm.DeletionTimestamp != nil {
+			continue
+		}
+		c.Logger.Info("Deleting leftover canary VM", "VirtualMachine", client.ObjectKeyFromObject(vm))
+		if err := c.Client.Delete(ctx, vm); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete canary VM %q: %w", vm.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Canary) newVM() *vmv1.VirtualMachine {
+	// Start with the autoscaler-agent keeping the VM at its minimum size.
+	bounds, err := canaryScalingBounds(250, resource.MustParse("1Gi"))
+	if err != nil {
+		panic(err) // only fails if the bounds can't be encoded, which can't happen.
+	}
+
+	return &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // other fields are set by the API server
+			GenerateName: "canary-",
+			Namespace:    c.Config.Namespace,
+			Labels: map[string]string{
+				canaryLabel:                "true",
+				api.LabelEnableAutoscaling: "true",
+			},
+			Annotations: map[string]string{api.AnnotationAutoscalingBounds: bounds},
+		},
+		Spec: vmv1.VirtualMachineSpec{ //nolint:exhaustruct // other stuff will get defaulted
+			RestartPolicy: vmv1.RestartPolicyNever,
+			SchedulerName: c.Config.SchedulerName,
+			Guest: vmv1.Guest{ //nolint:exhaustruct // other stuff will get defaulted
+				CPUs:           vmv1.CPUs{Min: 250, Use: 250, Max: 1000},
+				MemorySlotSize: resource.MustParse("1Gi"),
+				MemorySlots:    vmv1.MemorySlots{Min: 1, Use: 1, Max: 2},
+				RootDisk: vmv1.RootDisk{ //nolint:exhaustruct // other stuff will get defaulted
+					Image:           c.Config.Image,
+					ImagePullPolicy: corev1.PullIfNotPresent,
+				},
+			},
+		},
+	}
+}
+
+// waitFor polls the canary VM until cond returns true, or the context is done.
+func (c *Canary) waitFor(
+	ctx context.Context,
+	vm *vmv1.VirtualMachine,
+	cond func(*vmv1.VirtualMachine) (bool, error),
+) error {
+	return wait.PollUntilContextCancel(ctx, canaryPollInterval, true, func(ctx context.Context) (bool, error) {
+		if err := c.Client.Get(ctx, client.ObjectKeyFromObject(vm), vm); err != nil {
+			return false, err
+		}
+		return cond(vm)
+	})
+}
+
+func canaryFailed(vm *vmv1.VirtualMachine) error {
+	if vm.Status.Phase == vmv1.VmFailed {
+		return errors.New("canary VM failed")
+	}
+	return nil
+}
+
+func (c *Canary) waitScheduled(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	return c.waitFor(ctx, vm, func(vm *vmv1.VirtualMachine) (bool, error) {
+		return vm.Status.Node != "", canaryFailed(vm)
+	})
+}
+
+func (c *Canary) waitRunning(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	return c.waitFor(ctx, vm, func(vm *vmv1.VirtualMachine) (bool, error) {
+		return vm.Status.Phase == vmv1.VmRunning, canaryFailed(vm)
+	})
+}
+
+func (c *Canary) scaleUp(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	return c.scaleTo(ctx, vm, vm.Spec.Guest.CPUs.Max, vm.Spec.Guest.MemorySlots.Max)
+}
+
+func (c *Canary) scaleDown(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	return c.scaleTo(ctx, vm, vm.Spec.Guest.CPUs.Min, vm.Spec.Guest.MemorySlots.Min)
+}
+
+// scaleTo pins the VM's scaling bounds to the CPU and memory, so that the autoscaler-agent scales
+// it there, and waits for the new size to be reflected in its status.
+func (c *Canary) scaleTo(ctx context.Context, vm *vmv1.VirtualMachine, cpu vmv1.MilliCPU, memSlots int32) error {
+	wantMem := vm.Spec.Guest.MemorySlotSize.DeepCopy()
+	wantMem.Mul(int64(memSlots))
+
+	bounds, err := canaryScalingBounds(cpu, wantMem)
+	if err != nil {
+		return err
+	}
+
+	patched := vm.DeepCopy()
+	if patched.Annotations == nil {
+		patched.Annotations = make(map[string]string)
+	}
+	patched.Annotations[api.AnnotationAutoscalingBounds] = bounds
+	if err := c.Client.Patch(ctx, patched, client.MergeFrom(vm)); err != nil {
+		return fmt.Errorf("could not patch canary VM: %w", err)
+	}
+	*vm = *patched
+
+	return c.waitFor(ctx, vm, func(vm *vmv1.VirtualMachine) (bool, error) {
+		done := vm.Status.Phase == vmv1.VmRunning &&
+			vm.Status.CPUs != nil && *vm.Status.CPUs == cpu &&
+			vm.Status.MemorySize != nil && vm.Status.MemorySize.Cmp(wantMem) == 0
+		return done, canaryFailed(vm)
+	})
+}
+
+// canaryScalingBounds returns the value of the api.AnnotationAutoscalingBounds annotation that pins
+// the VM to exactly the given CPU and memory.
+func canaryScalingBounds(cpu vmv1.MilliCPU, mem resource.Quantity) (string, error) {
+	size := api.ResourceBounds{CPU: *cpu.ToResourceQuantity(), Mem: mem}
+	encoded, err := json.Marshal(api.ScalingBounds{Min: size, Max: size})
+	if err != nil {
+		return "", fmt.Errorf("could not encode scaling bounds: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// migrate live-migrates the canary VM to another node, and waits for the migration to succeed.
+func (c *Canary) migrate(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	vmm := &vmv1.VirtualMachineMigration{
+		ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // other fields are set by the API server
+			GenerateName: fmt.Sprintf("%s-", vm.Name),
+			Namespace:    vm.Namespace,
+			Labels:       map[string]string{canaryLabel: "true"},
+		},
+		Spec: vmv1.VirtualMachineMigrationSpec{ //nolint:exhaustruct // other stuff will get defaulted
+			VmName:                     vm.Name,
+			PreventMigrationToSameHost: true,
+		},
+	}
+	if err := c.Client.Create(ctx, vmm); err != nil {
+		return fmt.Errorf("could not create migration for canary VM: %w", err)
+	}
+	// Clean up the migration regardless of the outcome. Use a fresh context so that this still
+	// happens if the stage timed out.
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if err := c.Client.Delete(cleanupCtx, vmm); err != nil && !apierrors.IsNotFound(err) {
+			c.Logger.Error(err, "Failed to delete canary migration", "VirtualMachineMigration", vmm.Name)
+		}
+	}()
+
+	err := wait.PollUntilContextCancel(ctx, canaryPollInterval, true, func(ctx context.Context) (bool, error) {
+		if err := c.Client.Get(ctx, client.ObjectKeyFromObject(vmm), vmm); err != nil {
+			return false, err
+		}
+		switch vmm.Status.Phase {
+		case vmv1.VmmSucceeded:
+			return true, nil
+		case vmv1.VmmFailed:
+			return false, errors.New("canary VM migration failed")
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Once the migration has completed, the VM should be back to running on the new node.
+	return c.waitRunning(ctx, vm)
+}
+
+func (c *Canary) delete(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	if err := c.Client.Delete(ctx, vm); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete canary VM: %w", err)
+	}
+
+	return wait.PollUntilContextCancel(ctx, canaryPollInterval, true, func(ctx context.Context) (bool, error) {
+		err := c.Client.Get(ctx, client.ObjectKeyFromObject(vm), vm)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func TestCanaryCleanupLeftovers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, vmv1.AddToScheme(scheme))
+
+	vm := func(namespace, name string, canary bool) *vmv1.VirtualMachine {
+		labels := map[string]string{}
+		if canary {
+			labels[canaryLabel] = "true"
+		}
+		//nolint:exhaustruct // This is a test
+		return &vmv1.VirtualMachine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		}
+	}
+
+	c := &Canary{
+		Client: fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(
+				vm("canary", "canary-old", true),
+				vm("canary", "not-a-canary", false),
+				vm("other", "canary-elsewhere", true),
+			).
+			Build(),
+		Config: CanaryConfig{
+			Namespace:           "canary",
+			Image:               "canary-img",
+			SchedulerName:       "autoscale-scheduler",
+			Interval:            time.Minute,
+			DefaultStageTimeout: time.Minute,
+			StageTimeouts:       map[CanaryStage]time.Duration{CanaryStageMigrate: 5 * time.Minute},
+		},
+		Metrics: CanaryMetrics{stageRuns: nil, stageDuration: nil, stagePassing: nil},
+		Logger:  logr.Discard(),
+	}
+
+	require.NoError(t, c.cleanupLeftovers(context.Background()))
+
+	var remaining vmv1.VirtualMachineList
+	require.NoError(t, c.Client.List(context.Background(), &remaining))
+	names := []string{}
+	for _, vm := range remaining.Items {
+		names = append(names, client.ObjectKeyFromObject(&vm).String())
+	}
+	assert.ElementsMatch(t, []string{"canary/not-a-canary", "other/canary-elsewhere"}, names)
+
+	assert.Equal(t, 5*time.Minute, c.Config.timeout(CanaryStageMigrate))
+	assert.Equal(t, time.Minute, c.Config.timeout(CanaryStageBoot))
+}
+
+// Scaling the canary only changes its scaling bounds, leaving the autoscaler-agent to actually
+// scale it, so that the canary goes through the same path as other VMs.
+func TestCanaryScaleThroughBounds(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, vmv1.AddToScheme(scheme))
+
+	c := &Canary{
+		Client: nil,
+		Config: CanaryConfig{
+			Namespace:           "canary",
+			Image:               "canary-img",
+			SchedulerName:       "autoscale-scheduler",
+			Interval:            time.Minute,
+			DefaultStageTimeout: time.Minute,
+			StageTimeouts:       nil,
+		},
+		Metrics: CanaryMetrics{stageRuns: nil, stageDuration: nil, stagePassing: nil},
+		Logger:  logr.Discard(),
+	}
+
+	vm := c.newVM()
+	vm.Name = "canary-vm"
+	assert.Equal(t, "true", vm.Labels[api.LabelEnableAutoscaling])
+	assert.Equal(t, "autoscale-scheduler", vm.Spec.SchedulerName)
+	assert.JSONEq(t, `{"min":{"cpu":"250m","mem":"1Gi"},"max":{"cpu":"250m","mem":"1Gi"}}`,
+		vm.Annotations[api.AnnotationAutoscalingBounds])
+
+	// Pretend that the autoscaler-agent has already scaled the VM up, so scaleUp returns once it's
+	// patched the bounds.
+	vm.Status.Phase = vmv1.VmRunning
+	vm.Status.CPUs = lo.ToPtr(vmv1.MilliCPU(1000))
+	vm.Status.MemorySize = lo.ToPtr(resource.MustParse("2Gi"))
+	c.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(vm).Build()
+
+	require.NoError(t, c.scaleUp(context.Background(), vm))
+
+	var updated vmv1.VirtualMachine
+	require.NoError(t, c.Client.Get(context.Background(), client.ObjectKeyFromObject(vm), &updated))
+	assert.JSONEq(t, `{"min":{"cpu":"1","mem":"2Gi"},"max":{"cpu":"1","mem":"2Gi"}}`,
+		updated.Annotations[api.AnnotationAutoscalingBounds])
+	assert.Equal(t, vmv1.MilliCPU(250), updated.Spec.Guest.CPUs.Use)
+	assert.Equal(t, int32(1), updated.Spec.Guest.MemorySlots.Use)
+}
@@ -3,9 +3,12 @@ package controllers
 import (
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
 )
 
 // ReconcilerConfig stores shared configuration for VirtualMachineReconciler and
@@ -54,4 +57,70 @@ type ReconcilerConfig struct {
 
 	// NADConfig is the configuration for the Network Attachment Definitions
 	NADConfig *NADConfig
+
+	// ComputeUnits, if not nil, is the Compute Unit config shared with the autoscaler-agent and
+	// scheduler plugin. It's used to warn about VMs whose memory slot size is incompatible with
+	// the Compute Unit for their family.
+	ComputeUnits *api.ComputeUnitConfig
+
+	// NodeGroupLabel, if not empty, is the label on Nodes whose value is used as the "node_group"
+	// label in VM startup latency metrics.
+	NodeGroupLabel string
+
+	// RootDiskCacheDir, if not empty, is the directory on each node used to cache VM root disk
+	// images, keyed by digest. VMs with root disk images that aren't pinned by digest don't use
+	// the cache.
+	RootDiskCacheDir string
+	// RootDiskCacheMaxSize is the total size above which neonvm-runner evicts the least recently
+	// used images from the root disk cache. Only meaningful if RootDiskCacheDir is set.
+	RootDiskCacheMaxSize resource.Quantity
+
+	// SyncRunnerPodRequests, if true, sets the runner pod's CPU and memory requests from the VM's
+	// current allocation, and keeps them in sync as the VM is scaled, using in-place pod resizing
+	// if InPlacePodResize is supported.
+	//
+	// Otherwise, the runner pod's resources are taken directly from .spec.podResources.
+	SyncRunnerPodRequests bool
+	// InPlacePodResize is how the cluster supports in-place pod resizing, as determined by
+	// DetectInPlacePodResize.
+	InPlacePodResize InPlacePodResizeMode
+
+	// ManageRunnerPodDisruptionBudgets, if true, makes the controller create a PodDisruptionBudget
+	// for each VM with vmv1.VirtualMachineImportanceClassLabel set to a class that isn't in
+	// DisruptableImportanceClasses, so that its runner pod can't be voluntarily evicted (e.g.,
+	// while draining nodes during cluster upgrades).
+	ManageRunnerPodDisruptionBudgets bool
+	// DisruptableImportanceClasses is the set of importance classes whose runner pods may be
+	// voluntarily disrupted. Only meaningful if ManageRunnerPodDisruptionBudgets is true.
+	DisruptableImportanceClasses map[string]struct{}
+
+	// MigrationTargetSchedulingTimeout, if not zero, is the maximum time that a migration's target
+	// runner pod may be unschedulable before the migration is failed. If zero, migrations wait
+	// indefinitely for the target pod to be scheduled.
+	MigrationTargetSchedulingTimeout time.Duration
+
+	// RunnerPodRecycling, if not nil, enables flagging and recycling runner pods that are older
+	// than a maximum age. See RunnerPodRecyclingConfig for more.
+	RunnerPodRecycling *RunnerPodRecyclingConfig
+
+	// DiskFullThreshold, if not zero, is the fraction of a disk's usable space in use (from 0 to
+	// 1) at or above which a running VM's disk is considered nearly full, setting the VM's DiskFull
+	// condition and emitting an event.
+	DiskFullThreshold float64
+
+	// StorageTopologyKey, if not empty, is the node label (e.g. "topology.kubernetes.io/zone")
+	// used to place runner pods for VMs with api.AnnotationStorageLocality close to or away from
+	// their storage. See storage_locality.go for more.
+	StorageTopologyKey string
+	// StorageLocalityWeight is the weight of the preferred node affinity term added for storage
+	// locality, from 1 to 100. If zero, defaults to DefaultStorageLocalityWeight.
+	StorageLocalityWeight int32
+
+	// NetworkPolicyControllerSelector, if not nil, selects the neonvm-controller pods (in any
+	// namespace) that are allowed to reach QMP and neonvm-runner for VMs with .spec.networkPolicy
+	// set.
+	NetworkPolicyControllerSelector *metav1.LabelSelector
+	// NetworkPolicyAgentSelector, if not nil, selects the autoscaler-agent pods (in any namespace)
+	// that are allowed to reach VMs with .spec.networkPolicy set, for metrics and the vm-monitor.
+	NetworkPolicyAgentSelector *metav1.LabelSelector
 }
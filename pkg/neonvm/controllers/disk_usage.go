@@ -0,0 +1,123 @@
+package controllers
+
+// Detecting VMs with disks that are nearly full, from the disk usage reported by the runner.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// getRunnerDiskUsage returns the usage of the VM's writable disks, as reported by the runner.
+//
+// If the runner is too old to report disk usage, this returns nil without error.
+func (r *VMReconciler) getRunnerDiskUsage(ctx context.Context, vm *vmv1.VirtualMachine) ([]api.DiskUsage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:%d/disk_usage", vm.Status.PodIP, vm.Spec.RunnerPort)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.doRunnerRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("getRunnerDiskUsage: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result []api.DiskUsage
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// updateDiskFullStatus checks the VM's disk usage, setting the DiskFull condition and emitting an
+// event when any of its disks become nearly full, if the DiskFullThreshold is configured.
+//
+// The condition is meant to be acted on by external automation, e.g. to resize the VM's disks.
+// Failures to get the disk usage are only logged, because this is best-effort.
+func (r *VMReconciler) updateDiskFullStatus(ctx context.Context, vm *vmv1.VirtualMachine) {
+	if r.Config.DiskFullThreshold == 0 {
+		return
+	}
+
+	log := log.FromContext(ctx)
+
+	usage, err := r.getRunnerDiskUsage(ctx, vm)
+	if err != nil {
+		log.Error(err, "Failed to get disk usage from runner", "VirtualMachine", vm.Name)
+		return
+	} else if usage == nil {
+		return
+	}
+
+	full := nearlyFullDisks(usage, r.Config.DiskFullThreshold)
+	existing := meta.FindStatusCondition(vm.Status.Conditions, typeDiskFullVirtualMachine)
+	wasFull := existing != nil && existing.Status == metav1.ConditionTrue
+
+	if len(full) != 0 {
+		message := fmt.Sprintf("Disks nearly full: %s", strings.Join(full, ", "))
+		meta.SetStatusCondition(&vm.Status.Conditions, metav1.Condition{
+			Type:    typeDiskFullVirtualMachine,
+			Status:  metav1.ConditionTrue,
+			Reason:  "DiskNearlyFull",
+			Message: message,
+		})
+		if !wasFull {
+			r.Recorder.Event(vm, "Warning", "DiskNearlyFull", message)
+		}
+	} else if existing != nil {
+		// Only set the condition to false if it was previously set, so that VMs that never had
+		// full disks don't get an extra condition.
+		meta.SetStatusCondition(&vm.Status.Conditions, metav1.Condition{
+			Type:    typeDiskFullVirtualMachine,
+			Status:  metav1.ConditionFalse,
+			Reason:  "DiskUsageOK",
+			Message: "No disks are nearly full",
+		})
+		if wasFull {
+			r.Recorder.Event(vm, "Normal", "DiskUsageOK", "No disks are nearly full")
+		}
+	}
+}
+
+// nearlyFullDisks returns a description of each disk whose used fraction is at least the
+// threshold, e.g. "pgdata (95% of 10Gi)".
+func nearlyFullDisks(usage []api.DiskUsage, threshold float64) []string {
+	var full []string
+	for _, u := range usage {
+		if fraction := u.UsedFraction(); fraction >= threshold {
+			full = append(full, fmt.Sprintf(
+				"%s (%.0f%% of %s)",
+				u.Name, fraction*100, api.Bytes(u.TotalBytes),
+			))
+		}
+	}
+	return full
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func TestNearlyFullDisks(t *testing.T) {
+	const gib = 1 << 30
+	usage := []api.DiskUsage{
+		{Name: "rootdisk", MountPath: "/", TotalBytes: 2 * gib, UsedBytes: gib, AvailableBytes: gib},
+		// 1Gi of the filesystem is reserved for root, so this is 95% full from the user's
+		// perspective, even though only 90% of the total size is used.
+		{Name: "pgdata", MountPath: "/var/db", TotalBytes: 20 * gib, UsedBytes: 18 * gib, AvailableBytes: gib},
+		{Name: "empty", MountPath: "/tmp", TotalBytes: 0, UsedBytes: 0, AvailableBytes: 0},
+	}
+
+	assert.Equal(t, []string{"pgdata (95% of 20Gi)"}, nearlyFullDisks(usage, 0.9))
+	assert.Equal(t, []string{"rootdisk (50% of 2Gi)", "pgdata (95% of 20Gi)"}, nearlyFullDisks(usage, 0.5))
+	assert.Empty(t, nearlyFullDisks(usage, 0.99))
+}
@@ -0,0 +1,102 @@
+package controllers
+
+// Eviction protection for runner pods, based on the VM's importance class, as configured by
+// (ReconcilerConfig).ManageRunnerPodDisruptionBudgets.
+
+import (
+	"context"
+	"fmt"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+// evictionProtected returns whether the VM's runner pod should be protected from voluntary
+// disruption, according to its importance class.
+//
+// VMs without an importance class are never protected.
+func evictionProtected(config *ReconcilerConfig, vm *vmv1.VirtualMachine) bool {
+	class, ok := vm.Labels[vmv1.VirtualMachineImportanceClassLabel]
+	if !ok {
+		return false
+	}
+	_, disruptable := config.DisruptableImportanceClasses[class]
+	return !disruptable
+}
+
+// reconcileDisruptionBudget creates or deletes the PodDisruptionBudget for the VM's runner pod, so
+// that it exists only if the VM is protected from eviction.
+//
+// The PodDisruptionBudget has the same name as the VM, and is owned by it, so it's removed along
+// with the VM.
+func (r *VMReconciler) reconcileDisruptionBudget(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	log := log.FromContext(ctx)
+
+	var existing policyv1.PodDisruptionBudget
+	err := r.Get(ctx, client.ObjectKeyFromObject(vm), &existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not get PodDisruptionBudget: %w", err)
+	}
+	exists := err == nil
+
+	protected := evictionProtected(r.Config, vm)
+
+	switch {
+	case protected && !exists:
+		pdb := disruptionBudgetSpec(vm)
+		if err := ctrl.SetControllerReference(vm, pdb, r.Scheme); err != nil {
+			return err
+		}
+
+		log.Info("Creating PodDisruptionBudget to protect runner pod from eviction",
+			"ImportanceClass", vm.Labels[vmv1.VirtualMachineImportanceClassLabel])
+		if err := r.Create(ctx, pdb); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("could not create PodDisruptionBudget: %w", err)
+		}
+	case !protected && exists:
+		// Only touch the PodDisruptionBudget if we made it.
+		if !metav1.IsControlledBy(&existing, vm) {
+			return nil
+		}
+
+		log.Info("Deleting PodDisruptionBudget for runner pod that may now be evicted",
+			"ImportanceClass", vm.Labels[vmv1.VirtualMachineImportanceClassLabel])
+		if err := r.Delete(ctx, &existing); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete PodDisruptionBudget: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// disruptionBudgetSpec returns the PodDisruptionBudget that prevents any voluntary eviction of the
+// VM's runner pods.
+func disruptionBudgetSpec(vm *vmv1.VirtualMachine) *policyv1.PodDisruptionBudget {
+	maxUnavailable := intstr.FromInt32(0)
+
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      vm.Name,
+			Namespace: vm.Namespace,
+			Labels: map[string]string{
+				vmv1.VirtualMachineNameLabel: vm.Name,
+			},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					vmv1.VirtualMachineNameLabel: vm.Name,
+				},
+			},
+		},
+	}
+}
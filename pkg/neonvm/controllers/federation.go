@@ -0,0 +1,214 @@
+package controllers
+
+// Receiving side of the scheduler plugin's federation mode, as enabled by neonvm-controller's
+// -federation-bind-address flag.
+//
+// Each cluster's scheduler plugin periodically POSTs an api.FederationClusterSummary to /summaries.
+// We keep the most recent summary from each cluster, and serve cross-cluster placement
+// recommendations for new endpoints at GET /recommendation?cpu=<quantity>&mem=<quantity>: the
+// clusters that the endpoint would fit in, ranked by how many more of the same size would fit.
+//
+// Recommendations are based on the totals for each cluster, not individual nodes, so they're only
+// approximate: a cluster with enough headroom overall may still not have a single node with room.
+//
+// The aggregator runs on every replica of the controller, and each replica only knows about the
+// summaries that were sent to it. With more than one replica, summaries should be routed to a
+// single one.
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// maxFederationSummarySize is the maximum size of a summary pushed to the aggregator.
+const maxFederationSummarySize = 64 << 10 // 64 KiB
+
+type FederationConfig struct {
+	// BindAddress is the address to serve on.
+	BindAddress string
+	// StaleAfter is how long a cluster's summary is used for recommendations after it was
+	// received, if no newer summary arrives.
+	StaleAfter time.Duration
+}
+
+// FederationAggregator collects the summaries pushed by each cluster's scheduler plugin, and serves
+// placement recommendations from them. It implements manager.Runnable and
+// manager.LeaderElectionRunnable.
+type FederationAggregator struct {
+	config FederationConfig
+	logger logr.Logger
+
+	mu        sync.Mutex
+	summaries map[string]receivedFederationSummary
+}
+
+func NewFederationAggregator(config FederationConfig, logger logr.Logger) *FederationAggregator {
+	return &FederationAggregator{
+		config:    config,
+		logger:    logger,
+		mu:        sync.Mutex{},
+		summaries: make(map[string]receivedFederationSummary),
+	}
+}
+
+type receivedFederationSummary struct {
+	summary    api.FederationClusterSummary
+	receivedAt time.Time
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The aggregator runs on every
+// replica, so that pushes are never refused.
+func (a *FederationAggregator) NeedLeaderElection() bool {
+	return false
+}
+
+func (a *FederationAggregator) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/summaries", a.serveSummaries)
+	mux.HandleFunc("/recommendation", a.serveRecommendation)
+
+	server := &http.Server{
+		Addr:    a.config.BindAddress,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.TODO())
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (a *FederationAggregator) serveSummaries(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(fmt.Sprintf("request method must be %s", http.MethodPost)))
+		return
+	}
+
+	var summary api.FederationClusterSummary
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFederationSummarySize)).Decode(&summary); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(fmt.Sprintf("bad request body: %s", err)))
+		return
+	}
+	if summary.Cluster == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("cluster must not be empty"))
+		return
+	}
+
+	if a.record(summary, time.Now()) {
+		a.logger.V(1).Info("Received federation summary", "cluster", summary.Cluster, "timestamp", summary.Timestamp)
+	} else {
+		a.logger.Info("Ignoring federation summary older than the one we have", "cluster", summary.Cluster, "timestamp", summary.Timestamp)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// record stores the summary, unless we already have a newer one from the same cluster -- e.g.
+// because pushes arrived out of order. Returns whether the summary was stored.
+func (a *FederationAggregator) record(summary api.FederationClusterSummary, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if existing, ok := a.summaries[summary.Cluster]; ok && existing.summary.Timestamp.After(summary.Timestamp) {
+		return false
+	}
+	a.summaries[summary.Cluster] = receivedFederationSummary{summary: summary, receivedAt: now}
+	return true
+}
+
+func (a *FederationAggregator) serveRecommendation(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write([]byte(fmt.Sprintf("request method must be %s", http.MethodGet)))
+		return
+	}
+
+	var requested api.Resources
+	for param, set := range map[string]func(resource.Quantity){
+		"cpu": func(q resource.Quantity) { requested.VCPU = vmv1.MilliCPUFromResourceQuantity(q) },
+		"mem": func(q resource.Quantity) { requested.Mem = api.BytesFromResourceQuantity(q) },
+	} {
+		q, err := resource.ParseQuantity(r.URL.Query().Get(param))
+		if err != nil || q.Sign() <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(fmt.Sprintf("query parameter %q must be a positive quantity", param)))
+			return
+		}
+		set(q)
+	}
+
+	responseBody, err := json.Marshal(a.recommend(requested, time.Now()))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("failed to marshal JSON response: %s", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(responseBody)
+}
+
+// recommend returns the clusters with recent summaries that an endpoint with the requested
+// resources would fit in, ordered by how many would fit, most first.
+//
+// requested must be non-zero for both CPU and memory.
+func (a *FederationAggregator) recommend(requested api.Resources, now time.Time) api.FederationRecommendation {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	clusters := []api.FederationClusterFit{}
+	for _, s := range a.summaries {
+		if now.Sub(s.receivedAt) > a.config.StaleAfter {
+			continue
+		}
+
+		headroom := api.Resources{
+			VCPU: util.SaturatingSub(s.summary.Watermark.VCPU, s.summary.Reserved.VCPU),
+			Mem:  util.SaturatingSub(s.summary.Watermark.Mem, s.summary.Reserved.Mem),
+		}
+		fits := min(uint64(headroom.VCPU/requested.VCPU), uint64(headroom.Mem/requested.Mem))
+		if fits == 0 {
+			continue
+		}
+
+		clusters = append(clusters, api.FederationClusterFit{
+			Cluster:   s.summary.Cluster,
+			Timestamp: s.summary.Timestamp,
+			Headroom:  headroom,
+			Fits:      fits,
+		})
+	}
+
+	slices.SortFunc(clusters, func(x, y api.FederationClusterFit) int {
+		return cmp.Or(cmp.Compare(y.Fits, x.Fits), cmp.Compare(x.Cluster, y.Cluster))
+	})
+
+	return api.FederationRecommendation{Clusters: clusters}
+}
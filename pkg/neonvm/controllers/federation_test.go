@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func TestFederationRecommend(t *testing.T) {
+	const gib = 1 << 30
+
+	a := NewFederationAggregator(FederationConfig{BindAddress: "", StaleAfter: time.Minute}, logr.Discard())
+	now := time.Now()
+
+	summary := func(cluster string, timestamp time.Time, watermark, reserved api.Resources) api.FederationClusterSummary {
+		return api.FederationClusterSummary{
+			Cluster:             cluster,
+			Timestamp:           timestamp,
+			Nodes:               1,
+			NodesAboveWatermark: 0,
+			Total:               watermark,
+			Reserved:            reserved,
+			Watermark:           watermark,
+			Migrating:           api.Resources{VCPU: 0, Mem: 0},
+		}
+	}
+
+	// cluster-a fits 2 (limited by memory), cluster-b fits 4, cluster-c fits none, and cluster-d
+	// is stale.
+	assert.True(t, a.record(summary("cluster-a", now, api.Resources{VCPU: 8000, Mem: 8 * gib}, api.Resources{VCPU: 0, Mem: 4 * gib}), now))
+	assert.True(t, a.record(summary("cluster-b", now, api.Resources{VCPU: 8000, Mem: 16 * gib}, api.Resources{VCPU: 4000, Mem: 0}), now))
+	assert.True(t, a.record(summary("cluster-c", now, api.Resources{VCPU: 8000, Mem: 8 * gib}, api.Resources{VCPU: 9000, Mem: 0}), now))
+	assert.True(t, a.record(summary("cluster-d", now, api.Resources{VCPU: 80000, Mem: 80 * gib}, api.Resources{VCPU: 0, Mem: 0}), now.Add(-2*time.Minute)))
+
+	// Summaries older than the one we have are ignored
+	assert.False(t, a.record(summary("cluster-b", now.Add(-time.Second), api.Resources{VCPU: 0, Mem: 0}, api.Resources{VCPU: 0, Mem: 0}), now))
+
+	rec := a.recommend(api.Resources{VCPU: 1000, Mem: 2 * gib}, now)
+	assert.Equal(t, []api.FederationClusterFit{
+		{Cluster: "cluster-b", Timestamp: now, Headroom: api.Resources{VCPU: 4000, Mem: 16 * gib}, Fits: 4},
+		{Cluster: "cluster-a", Timestamp: now, Headroom: api.Resources{VCPU: 8000, Mem: 4 * gib}, Fits: 2},
+	}, rec.Clusters)
+}
+
+func TestFederationAggregatorHTTP(t *testing.T) {
+	a := NewFederationAggregator(FederationConfig{BindAddress: "", StaleAfter: time.Minute}, logr.Discard())
+
+	body, err := json.Marshal(api.FederationClusterSummary{
+		Cluster:             "cluster-a",
+		Timestamp:           time.Now(),
+		Nodes:               1,
+		NodesAboveWatermark: 0,
+		Total:               api.Resources{VCPU: 4000, Mem: 4 << 30},
+		Reserved:            api.Resources{VCPU: 0, Mem: 0},
+		Watermark:           api.Resources{VCPU: 4000, Mem: 4 << 30},
+		Migrating:           api.Resources{VCPU: 0, Mem: 0},
+	})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	a.serveSummaries(recorder, httptest.NewRequest(http.MethodPost, "/summaries", strings.NewReader(string(body))))
+	assert.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	a.serveSummaries(recorder, httptest.NewRequest(http.MethodPost, "/summaries", strings.NewReader(`{"cluster": ""}`)))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	a.serveRecommendation(recorder, httptest.NewRequest(http.MethodGet, "/recommendation?cpu=1&mem=1Gi", nil))
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var rec api.FederationRecommendation
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rec))
+	require.Len(t, rec.Clusters, 1)
+	assert.Equal(t, "cluster-a", rec.Clusters[0].Cluster)
+	assert.Equal(t, uint64(4), rec.Clusters[0].Fits)
+
+	recorder = httptest.NewRecorder()
+	a.serveRecommendation(recorder, httptest.NewRequest(http.MethodGet, "/recommendation?cpu=1", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
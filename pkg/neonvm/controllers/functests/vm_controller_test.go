@@ -27,6 +27,7 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -106,16 +107,31 @@ var _ = Describe("VirtualMachine controller", func() {
 				Scheme:   k8sClient.Scheme(),
 				Recorder: nil,
 				Config: &controllers.ReconcilerConfig{
-					DisableRunnerCgroup:     false,
-					MaxConcurrentReconciles: 1,
-					SkipUpdateValidationFor: nil,
-					QEMUDiskCacheSettings:   "cache=none",
-					MemhpAutoMovableRatio:   "301",
-					FailurePendingPeriod:    1 * time.Minute,
-					FailingRefreshInterval:  1 * time.Minute,
-					AtMostOnePod:            false,
-					DefaultCPUScalingMode:   vmv1.CpuScalingModeQMP,
-					NADConfig:               nil,
+					DisableRunnerCgroup:              false,
+					MaxConcurrentReconciles:          1,
+					SkipUpdateValidationFor:          nil,
+					QEMUDiskCacheSettings:            "cache=none",
+					MemhpAutoMovableRatio:            "301",
+					FailurePendingPeriod:             1 * time.Minute,
+					FailingRefreshInterval:           1 * time.Minute,
+					AtMostOnePod:                     false,
+					DefaultCPUScalingMode:            vmv1.CpuScalingModeQMP,
+					NADConfig:                        nil,
+					ComputeUnits:                     nil,
+					NodeGroupLabel:                   "",
+					RootDiskCacheDir:                 "",
+					RootDiskCacheMaxSize:             resource.Quantity{},
+					SyncRunnerPodRequests:            false,
+					InPlacePodResize:                 controllers.InPlacePodResizeUnsupported,
+					ManageRunnerPodDisruptionBudgets: false,
+					DisruptableImportanceClasses:     nil,
+					MigrationTargetSchedulingTimeout: 0,
+					RunnerPodRecycling:               nil,
+					DiskFullThreshold:                0,
+					StorageTopologyKey:               "",
+					StorageLocalityWeight:            0,
+					NetworkPolicyControllerSelector:  nil,
+					NetworkPolicyAgentSelector:       nil,
 				},
 				IPAM: nil,
 			}
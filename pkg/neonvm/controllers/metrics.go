@@ -11,9 +11,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"github.com/neondatabase/autoscaling/pkg/neonvm/controllers/failurelag"
 	"github.com/neondatabase/autoscaling/pkg/util"
@@ -24,12 +26,30 @@ type ReconcilerMetrics struct {
 	vmCreationToRunnerCreationTime prometheus.Histogram
 	runnerCreationToVMRunningTime  prometheus.Histogram
 	vmCreationToVMRunningTime      prometheus.Histogram
+	vmStartupLatency               *prometheus.HistogramVec
 	vmRestartCounts                prometheus.Counter
+	vmScalingFrozen                *prometheus.GaugeVec
+	nodeDeletionsBlocked           prometheus.Counter
+	prePullPodsCreated             prometheus.Counter
+	runnerPodResizes               *prometheus.CounterVec
+	runnerPodAge                   *prometheus.GaugeVec
+	runnerPodRecycles              *prometheus.CounterVec
+	runnerVersionSkew              *prometheus.CounterVec
+	reconcileOutcomes              *prometheus.CounterVec
 	reconcileDuration              prometheus.HistogramVec
 }
 
 const OutcomeLabel = "outcome"
 
+// Values of the "stage" label on the vm_startup_latency_seconds metric
+const (
+	// StartupStagePodRunning is when the neonvm-runner container in the runner pod started
+	StartupStagePodRunning = "pod_running"
+	// StartupStageGuestReady is when the runner pod's readiness probe first passed, which requires
+	// neonvm-daemon inside the guest to be up.
+	StartupStageGuestReady = "guest_ready"
+)
+
 func MakeReconcilerMetrics() ReconcilerMetrics {
 	// Copied bucket values from controller runtime latency metric. We can
 	// adjust them in the future if needed.
@@ -67,12 +87,74 @@ func MakeReconcilerMetrics() ReconcilerMetrics {
 				Buckets: buckets,
 			},
 		)),
+		vmStartupLatency: util.RegisterMetric(metrics.Registry, prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "vm_startup_latency_seconds",
+				Help:    "Time duration from VirtualMachine.CreationTimestamp to each stage of the VM's first startup",
+				Buckets: buckets,
+			},
+			[]string{"stage", "size_class", "node_group"},
+		)),
 		vmRestartCounts: util.RegisterMetric(metrics.Registry, prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Name: "vm_restarts_count",
 				Help: "Total number of VM restarts across the cluster captured by VirtualMachine reconciler",
 			},
 		)),
+		vmScalingFrozen: util.RegisterMetric(metrics.Registry, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vm_scaling_frozen",
+				Help: "Set to 1 for each VirtualMachine that has autoscaling frozen by .spec.scalingFrozen",
+			},
+			[]string{"namespace", "name"},
+		)),
+		nodeDeletionsBlocked: util.RegisterMetric(metrics.Registry, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "vm_node_deletions_blocked_total",
+				Help: "Number of Node deletions rejected because the Node still hosts VMs that must not be migrated",
+			},
+		)),
+		prePullPodsCreated: util.RegisterMetric(metrics.Registry, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "vm_image_prepull_pods_created_total",
+				Help: "Number of pods created to pre-pull VM images onto nodes",
+			},
+		)),
+		runnerPodResizes: util.RegisterMetric(metrics.Registry, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vm_runner_pod_resizes_total",
+				Help: "Number of in-place updates to runner pod resource requests to match the VM, by outcome",
+			},
+			[]string{OutcomeLabel},
+		)),
+		runnerPodAge: util.RegisterMetric(metrics.Registry, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vm_runner_pod_age_seconds",
+				Help: "Age of each running VirtualMachine's runner pod, if runner pod recycling is enabled",
+			},
+			[]string{"namespace", "name"},
+		)),
+		runnerPodRecycles: util.RegisterMetric(metrics.Registry, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vm_runner_pod_recycles_total",
+				Help: "Number of runner pods recycled for being older than the maximum age, by action",
+			},
+			[]string{"action"},
+		)),
+		runnerVersionSkew: util.RegisterMetric(metrics.Registry, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "vm_runner_version_skew_total",
+				Help: "Number of responses from runners with unsupported versions, by runner version and action",
+			},
+			[]string{"runner_version", "action"},
+		)),
+		reconcileOutcomes: util.RegisterMetric(metrics.Registry, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "reconcile_outcomes_total",
+				Help: "Number of reconciles for each specific controller, by what triggered them and what they did",
+			},
+			[]string{"controller", "reason", "result"},
+		)),
 		reconcileDuration: *util.RegisterMetric(metrics.Registry, prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "reconcile_duration_seconds",
@@ -107,6 +189,7 @@ type wrappedReconciler struct {
 
 	failing     *failurelag.Tracker[client.ObjectKey]
 	conflicting *failurelag.Tracker[client.ObjectKey]
+	triggers    *reconcileTriggers
 }
 
 // ReconcilerWithMetrics is a Reconciler produced by WithMetrics that can return a snapshot of the
@@ -116,6 +199,14 @@ type ReconcilerWithMetrics interface {
 
 	Snapshot() ReconcileSnapshot
 	FailingRefresher() FailingRefresher
+
+	// ForPredicate returns the predicate to use with the controller's For() watch, so that the
+	// reason for each reconcile can be recorded.
+	ForPredicate() predicate.Predicate
+	// OwnsPredicate returns the predicate to use with each of the controller's Owns() watches,
+	// so that the reason for each reconcile can be recorded. ownerKind is the kind of the
+	// controller's For() object.
+	OwnsPredicate(ownerKind schema.GroupKind) predicate.Predicate
 }
 
 // ReconcileSnapshot provides a glimpse into the current state of ongoing reconciles
@@ -153,10 +244,19 @@ func WithMetrics(
 		ControllerName:         cntrlName,
 		failing:                failurelag.NewTracker[client.ObjectKey](failurePendingPeriod),
 		conflicting:            failurelag.NewTracker[client.ObjectKey](failurePendingPeriod),
+		triggers:               newReconcileTriggers(),
 		refreshFailingInterval: refreshFailingInterval,
 	}
 }
 
+func (d *wrappedReconciler) ForPredicate() predicate.Predicate {
+	return d.triggers.forPredicate()
+}
+
+func (d *wrappedReconciler) OwnsPredicate(ownerKind schema.GroupKind) predicate.Predicate {
+	return d.triggers.ownsPredicate(ownerKind)
+}
+
 func (d *wrappedReconciler) refreshFailing(
 	log logr.Logger,
 	outcome ReconcileOutcome,
@@ -210,10 +310,21 @@ func (f FailingRefresher) Start(ctx context.Context) error {
 func (d *wrappedReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
+	reason := d.triggers.take(req.NamespacedName)
+	ctx, writes := withWriteCounter(ctx)
+
 	now := time.Now()
 	res, err := d.Reconciler.Reconcile(ctx, req)
 	duration := time.Since(now)
 
+	result := ReconcileResultNoOp
+	if err != nil {
+		result = ReconcileResultFailed
+	} else if writes.Load() != 0 {
+		result = ReconcileResultUpdated
+	}
+	d.Metrics.reconcileOutcomes.WithLabelValues(d.ControllerName, string(reason), string(result)).Inc()
+
 	outcome := SuccessOutcome
 	if err != nil {
 		if errors.IsConflict(err) {
@@ -238,7 +349,8 @@ func (d *wrappedReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	} else {
 		d.failing.RecordSuccess(req.NamespacedName)
 		d.conflicting.RecordSuccess(req.NamespacedName)
-		log.Info("Successful reconciliation", "duration", duration.String(), "requeueAfter", res.RequeueAfter)
+		log.Info("Successful reconciliation", "duration", duration.String(), "requeueAfter", res.RequeueAfter,
+			"reason", reason, "result", result)
 	}
 	d.Metrics.ObserveReconcileDuration(outcome, duration)
 	d.Metrics.failing.WithLabelValues(d.ControllerName,
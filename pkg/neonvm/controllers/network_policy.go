@@ -0,0 +1,175 @@
+package controllers
+
+// Restricting VMs' traffic, as configured by .spec.networkPolicy in the VirtualMachine.
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+// reconcileNetworkPolicy creates, updates, or deletes the NetworkPolicy for the VM's runner pods,
+// so that it matches the VM's .spec.networkPolicy.
+//
+// The NetworkPolicy has the same name as the VM, and is owned by it, so it's removed along with
+// the VM. Because it's created during the same reconcile that creates the runner pod, the VM is
+// never started without its rules in place.
+func (r *VMReconciler) reconcileNetworkPolicy(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	log := log.FromContext(ctx)
+
+	var existing networkingv1.NetworkPolicy
+	err := r.Get(ctx, client.ObjectKeyFromObject(vm), &existing)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not get NetworkPolicy: %w", err)
+	}
+	exists := err == nil
+
+	// Only touch the NetworkPolicy if we made it.
+	if exists && !metav1.IsControlledBy(&existing, vm) {
+		return nil
+	}
+
+	switch {
+	case vm.Spec.NetworkPolicy != nil && !exists:
+		policy := r.networkPolicySpec(vm)
+		if err := ctrl.SetControllerReference(vm, policy, r.Scheme); err != nil {
+			return err
+		}
+
+		log.Info("Creating NetworkPolicy for VirtualMachine")
+		if err := r.Create(ctx, policy); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("could not create NetworkPolicy: %w", err)
+		}
+	case vm.Spec.NetworkPolicy != nil && exists:
+		desired := r.networkPolicySpec(vm)
+		if DeepEqual(existing.Spec, desired.Spec) {
+			return nil
+		}
+
+		log.Info("Updating NetworkPolicy for VirtualMachine")
+		existing.Spec = desired.Spec
+		if err := r.Update(ctx, &existing); err != nil {
+			return fmt.Errorf("could not update NetworkPolicy: %w", err)
+		}
+	case vm.Spec.NetworkPolicy == nil && exists:
+		log.Info("Deleting NetworkPolicy for VirtualMachine that no longer has one")
+		if err := r.Delete(ctx, &existing); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete NetworkPolicy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// networkPolicySpec returns the NetworkPolicy that applies the VM's .spec.networkPolicy to its
+// runner pods.
+//
+// In addition to the VM's own rules, the NetworkPolicy always allows:
+//
+//   - neonvm-controller to reach QMP and neonvm-runner, so that it can still manage the VM;
+//   - other runner pods to reach the migration port, and, if egress is restricted, the runner pods
+//     to reach other runner pods' migration port, so that live migration continues to work; and
+//   - the autoscaler-agent to reach any port, for the VM's metrics and vm-monitor.
+//
+// vm.Spec.NetworkPolicy must not be nil.
+func (r *VMReconciler) networkPolicySpec(vm *vmv1.VirtualMachine) *networkingv1.NetworkPolicy {
+	rules := vm.Spec.NetworkPolicy.DeepCopy()
+
+	tcpPorts := func(ports ...int32) []networkingv1.NetworkPolicyPort {
+		tcp := corev1.ProtocolTCP
+		var policyPorts []networkingv1.NetworkPolicyPort
+		for _, port := range ports {
+			p := intstr.FromInt32(port)
+			policyPorts = append(policyPorts, networkingv1.NetworkPolicyPort{
+				Protocol: &tcp,
+				Port:     &p,
+				EndPort:  nil,
+			})
+		}
+		return policyPorts
+	}
+	// podsInAnyNamespace returns the peer for pods matching the selector, in any namespace.
+	podsInAnyNamespace := func(selector *metav1.LabelSelector) []networkingv1.NetworkPolicyPeer {
+		return []networkingv1.NetworkPolicyPeer{{
+			PodSelector:       selector,
+			NamespaceSelector: &metav1.LabelSelector{}, //nolint:exhaustruct // empty selector matches all namespaces
+			IPBlock:           nil,
+		}}
+	}
+
+	runnerPods := podsInAnyNamespace(&metav1.LabelSelector{
+		MatchLabels: nil,
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      vmv1.VirtualMachineNameLabel,
+			Operator: metav1.LabelSelectorOpExists,
+			Values:   nil,
+		}},
+	})
+
+	ingress := append(rules.Ingress, networkingv1.NetworkPolicyIngressRule{
+		Ports: tcpPorts(vmv1.MigrationPort),
+		From:  runnerPods,
+	})
+	if selector := r.Config.NetworkPolicyControllerSelector; selector != nil {
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			Ports: tcpPorts(vm.Spec.QMP, vm.Spec.QMPManual, vm.Spec.RunnerPort),
+			From:  podsInAnyNamespace(selector.DeepCopy()),
+		})
+	}
+	if selector := r.Config.NetworkPolicyAgentSelector; selector != nil {
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			Ports: nil, // any port
+			From:  podsInAnyNamespace(selector.DeepCopy()),
+		})
+	}
+
+	egress := rules.Egress
+	if restrictsEgress(rules) {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{
+			Ports: tcpPorts(vmv1.MigrationPort),
+			To:    runnerPods,
+		})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      vm.Name,
+			Namespace: vm.Namespace,
+			Labels: map[string]string{
+				vmv1.VirtualMachineNameLabel: vm.Name,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					vmv1.VirtualMachineNameLabel: vm.Name,
+				},
+			},
+			Ingress:     ingress,
+			Egress:      egress,
+			PolicyTypes: rules.PolicyTypes,
+		},
+	}
+}
+
+// restrictsEgress returns whether the NetworkPolicy rules restrict egress traffic, following the
+// defaults for NetworkPolicySpec.PolicyTypes: if it's empty, egress is only restricted if there are
+// egress rules.
+func restrictsEgress(rules *vmv1.VirtualMachineNetworkPolicy) bool {
+	if len(rules.PolicyTypes) == 0 {
+		return len(rules.Egress) != 0
+	}
+	return slices.Contains(rules.PolicyTypes, networkingv1.PolicyTypeEgress)
+}
@@ -0,0 +1,112 @@
+package controllers
+
+// Validating webhook for Node deletion, so that nodes hosting VMs that must never be migrated are
+// not silently removed.
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+//+kubebuilder:webhook:path=/validate--v1-node,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=nodes,verbs=delete,versions=v1,name=vnode.vm.neon.tech,admissionReviewVersions=v1
+
+// NodeWebhook rejects deletion of Nodes that still host VMs that must never be migrated (see
+// api.IsMarkedNeverMigrate), unless the Node has the
+// vmv1.NodeAllowUnmigratableVMsDeletionAnnotation.
+type NodeWebhook struct {
+	Client   client.Reader
+	Recorder record.EventRecorder
+	Metrics  ReconcilerMetrics
+}
+
+func (w *NodeWebhook) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&corev1.Node{}).
+		WithValidator(w).
+		Complete()
+}
+
+var _ webhook.CustomValidator = (*NodeWebhook)(nil)
+
+// ValidateCreate implements webhook.CustomValidator
+func (w *NodeWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// ValidateUpdate implements webhook.CustomValidator
+func (w *NodeWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// ValidateDelete implements webhook.CustomValidator
+func (w *NodeWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	log := log.FromContext(ctx)
+	node := obj.(*corev1.Node)
+
+	vms, err := w.unmigratableVMsOnNode(ctx, node.Name)
+	if err != nil {
+		// Don't block node deletion on our own failures; the webhook's failurePolicy is "ignore"
+		// for the same reason.
+		log.Error(err, "Failed to list unmigratable VMs on Node, allowing deletion", "Node", node.Name)
+		return admission.Warnings{"could not check for unmigratable VMs on node"}, nil
+	} else if len(vms) == 0 {
+		return nil, nil
+	}
+
+	msg := fmt.Sprintf(
+		"node still hosts %d VM(s) that must not be migrated: %s",
+		len(vms), strings.Join(vms, ", "),
+	)
+
+	if node.Annotations[vmv1.NodeAllowUnmigratableVMsDeletionAnnotation] == "true" {
+		log.Info("Allowing deletion of Node with unmigratable VMs due to override annotation", "Node", node.Name, "VMs", vms)
+		return admission.Warnings{fmt.Sprintf("deleting anyway due to %q annotation: %s", vmv1.NodeAllowUnmigratableVMsDeletionAnnotation, msg)}, nil
+	}
+
+	log.Info("Rejecting deletion of Node with unmigratable VMs", "Node", node.Name, "VMs", vms)
+	w.Recorder.Event(node, corev1.EventTypeWarning, "UnmigratableVMs", fmt.Sprintf("Rejected Node deletion: %s", msg))
+	w.Metrics.nodeDeletionsBlocked.Inc()
+
+	return nil, fmt.Errorf(
+		"%s; migrate or stop them first, or set the %q annotation on the node to \"true\" to delete anyway",
+		msg, vmv1.NodeAllowUnmigratableVMsDeletionAnnotation,
+	)
+}
+
+// unmigratableVMsOnNode returns the namespaced names of the VMs with runner pods on the node that
+// are marked as never to be migrated, by label or annotation.
+func (w *NodeWebhook) unmigratableVMsOnNode(ctx context.Context, nodeName string) ([]string, error) {
+	// The annotation can't be selected on, so we have to list all runner pods.
+	var pods corev1.PodList
+	err := w.Client.List(ctx, &pods, client.HasLabels{vmv1.VirtualMachineNameLabel})
+	if err != nil {
+		return nil, err
+	}
+
+	var vms []string
+	for _, pod := range pods.Items {
+		vmName := pod.Labels[vmv1.VirtualMachineNameLabel]
+		if pod.Spec.NodeName != nodeName || !api.IsMarkedNeverMigrate(&pod) {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		vms = append(vms, fmt.Sprintf("%s/%s", pod.Namespace, vmName))
+	}
+	return vms, nil
+}
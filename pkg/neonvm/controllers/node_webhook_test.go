@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func TestNodeWebhookValidateDelete(t *testing.T) {
+	params := newTestParams(t)
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, corev1.AddToScheme(scheme))
+
+	runnerPod := func(name string, nodeName string, neverMigrateLabel bool, neverMigrateAnnotation bool) *corev1.Pod {
+		labels := map[string]string{vmv1.VirtualMachineNameLabel: name}
+		if neverMigrateLabel {
+			labels[vmv1.VirtualMachineNeverMigrateLabel] = "true"
+		}
+		annotations := map[string]string{}
+		if neverMigrateAnnotation {
+			annotations[api.AnnotationNeverMigrate] = "true"
+		}
+		//nolint:exhaustruct // This is a test
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name + "-runner",
+				Namespace:   "default",
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Spec:   corev1.PodSpec{NodeName: nodeName},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+
+	client := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(
+			runnerPod("pinned", "node-a", true, false),
+			runnerPod("movable", "node-b", false, false),
+			runnerPod("pinned-elsewhere", "node-c", true, false),
+			runnerPod("pinned-by-annotation", "node-d", false, true),
+		).
+		Build()
+
+	params.mockRecorder.On("Event", mock.Anything, corev1.EventTypeWarning, "UnmigratableVMs", mock.Anything)
+
+	w := &NodeWebhook{
+		Client:   client,
+		Recorder: params.mockRecorder,
+		Metrics:  testReconcilerMetrics,
+	}
+
+	node := func(name string, annotations map[string]string) *corev1.Node {
+		//nolint:exhaustruct // This is a test
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+	}
+
+	// Node with an unmigratable VM: rejected
+	_, err := w.ValidateDelete(params.ctx, node("node-a", nil))
+	assert.ErrorContains(t, err, "default/pinned")
+	params.mockRecorder.AssertNumberOfCalls(t, "Event", 1)
+
+	// Node with a VM that's unmigratable because of the annotation: also rejected
+	_, err = w.ValidateDelete(params.ctx, node("node-d", nil))
+	assert.ErrorContains(t, err, "default/pinned-by-annotation")
+	params.mockRecorder.AssertNumberOfCalls(t, "Event", 2)
+
+	// Node with only migratable VMs: allowed
+	warnings, err := w.ValidateDelete(params.ctx, node("node-b", nil))
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	// Override annotation: allowed, with a warning
+	override := map[string]string{vmv1.NodeAllowUnmigratableVMsDeletionAnnotation: "true"}
+	warnings, err = w.ValidateDelete(params.ctx, node("node-c", override))
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 1)
+	params.mockRecorder.AssertNumberOfCalls(t, "Event", 2)
+}
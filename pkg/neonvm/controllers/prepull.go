@@ -0,0 +1,154 @@
+package controllers
+
+// Pre-pulling of VM images onto candidate nodes, as requested by the
+// vmv1.VirtualMachinePrePullNodesAnnotation.
+//
+// The annotation may be set by hand (e.g. for all nodes in a group before an upgrade), and is set
+// by the scheduler plugin when it nominates a node for a VM that's waiting for room to be made on
+// it, if its preemption.prePullImages is enabled.
+//
+// For each requested node, we create a short-lived pod bound directly to the node, with one
+// container for each image. The containers themselves don't need to successfully run -- by the
+// time kubelet tries to start them, the images have already been pulled.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+// reconcilePrePull creates pre-pull pods for each node in the VM's
+// vmv1.VirtualMachinePrePullNodesAnnotation, and deletes any existing pre-pull pods that are no
+// longer requested.
+//
+// Pre-pull pods are left in place after they complete, so that we don't re-create them. They're
+// deleted when the annotation changes, or with the VM.
+func (r *VMReconciler) reconcilePrePull(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	log := log.FromContext(ctx)
+
+	desired := make(map[string]*corev1.Pod)
+	if value := vm.Annotations[vmv1.VirtualMachinePrePullNodesAnnotation]; value != "" {
+		images, err := imagesForVirtualMachine(vm)
+		if err != nil {
+			return err
+		}
+		for _, node := range strings.Split(value, ",") {
+			if node = strings.TrimSpace(node); node != "" {
+				pod := prePullPodSpec(vm, node, images)
+				desired[pod.Name] = pod
+			}
+		}
+	}
+
+	var existing corev1.PodList
+	if err := r.List(
+		ctx,
+		&existing,
+		client.InNamespace(vm.Namespace),
+		client.MatchingLabels{vmv1.VirtualMachinePrePullLabel: vm.Name},
+	); err != nil {
+		return fmt.Errorf("could not list pre-pull pods: %w", err)
+	}
+
+	for i := range existing.Items {
+		pod := &existing.Items[i]
+		if _, ok := desired[pod.Name]; ok {
+			delete(desired, pod.Name) // already exists; nothing to do.
+			continue
+		}
+
+		log.Info("Deleting pre-pull pod that is no longer requested", "Pod", pod.Name, "Node", pod.Spec.NodeName)
+		if err := r.Delete(ctx, pod); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete pre-pull pod %q: %w", pod.Name, err)
+		}
+	}
+
+	for _, pod := range desired {
+		if err := ctrl.SetControllerReference(vm, pod, r.Scheme); err != nil {
+			return err
+		}
+
+		log.Info("Creating pod to pre-pull VM images", "Pod", pod.Name, "Node", pod.Spec.NodeName)
+		if err := r.Create(ctx, pod); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				continue // the cache was out of date; it was already created.
+			}
+			return fmt.Errorf("could not create pre-pull pod %q: %w", pod.Name, err)
+		}
+		r.Metrics.prePullPodsCreated.Inc()
+	}
+
+	return nil
+}
+
+// imagesForVirtualMachine returns the container images that a runner pod for the VM would use.
+func imagesForVirtualMachine(vm *vmv1.VirtualMachine) ([]string, error) {
+	runnerImage, err := imageForVmRunner()
+	if err != nil {
+		return nil, err
+	}
+	if vm.Spec.RunnerImage != nil {
+		runnerImage = *vm.Spec.RunnerImage
+	}
+
+	images := []string{runnerImage, vm.Spec.Guest.RootDisk.Image}
+	if vm.Spec.Guest.KernelImage != nil {
+		images = append(images, *vm.Spec.Guest.KernelImage)
+	}
+	return lo.Uniq(lo.Compact(images)), nil
+}
+
+// prePullPodSpec returns the pod that pulls the images onto the node.
+//
+// The name of the pod is derived from the node and images, so that changing either results in a
+// new pod.
+func prePullPodSpec(vm *vmv1.VirtualMachine, nodeName string, images []string) *corev1.Pod {
+	hash := sha256.Sum256([]byte(strings.Join(append([]string{nodeName}, images...), "\n")))
+
+	var containers []corev1.Container
+	for i, image := range images {
+		containers = append(containers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			// The image may not have 'true', but that's fine -- it's already been pulled by the
+			// time the container fails to start.
+			Command: []string{"true"},
+		})
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-prepull-%s", vm.Name, hex.EncodeToString(hash[:])[:10]),
+			Namespace: vm.Namespace,
+			Labels: map[string]string{
+				vmv1.VirtualMachinePrePullLabel: vm.Name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			// Bind directly to the node, bypassing the scheduler: we want the images on *this*
+			// node, regardless of whether a VM could currently fit there.
+			NodeName:                     nodeName,
+			RestartPolicy:                corev1.RestartPolicyNever,
+			AutomountServiceAccountToken: lo.ToPtr(false),
+			ImagePullSecrets:             vm.Spec.ImagePullSecrets,
+			Tolerations: []corev1.Toleration{{
+				Operator: corev1.TolerationOpExists,
+			}},
+			Containers: containers,
+		},
+	}
+}
@@ -0,0 +1,314 @@
+package controllers
+
+// Audited proxy for running an allowlisted set of QMP commands against VMs, for break-glass
+// operations, as enabled by neonvm-controller's -enable-qmp-proxy flag.
+//
+// The proxy is served by the webhook server (so that it uses the same TLS certificate), at
+// POST /qmp/<namespace>/<name>, with a body like {"execute": "query-status", "arguments": {}}.
+//
+// Callers must authenticate with a bearer token, and must be allowed to "create" the
+// "virtualmachines/qmp" subresource of the VM. Every command is logged and recorded as an event on
+// the VirtualMachine, along with who ran it.
+//
+// Commands that write files in the runner pod (dump-guest-memory and blockdev-snapshot-sync) may
+// only write to new files in /vm/images with the "qmp-proxy-" prefix, in a limited set of formats.
+// Memory dumps are always run in the background; use query-dump to check on their progress.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+const qmpProxyPathPrefix = "/qmp/"
+
+const (
+	// qmpProxyOutputDir is the directory in the runner pod that files written by proxied QMP
+	// commands must be in.
+	qmpProxyOutputDir = "/vm/images"
+	// qmpProxyOutputPrefix must start the names of files written by proxied QMP commands, so that
+	// they can't overwrite anything else in qmpProxyOutputDir (like the VM's root disk).
+	qmpProxyOutputPrefix = "qmp-proxy-"
+)
+
+// DefaultQMPProxyAllowedCommands is the set of QMP commands that the proxy allows by default.
+var DefaultQMPProxyAllowedCommands = []string{
+	"query-status",
+	"query-dump",
+	"dump-guest-memory",
+	"blockdev-snapshot-sync",
+}
+
+// qmpProxyArgumentCheckers restricts the arguments of QMP commands that write files in the runner
+// pod. Each checker returns the arguments to run the command with, or an error if they're not
+// allowed.
+var qmpProxyArgumentCheckers = map[string]func(json.RawMessage) (json.RawMessage, error){
+	"dump-guest-memory":      checkDumpGuestMemoryArguments,
+	"blockdev-snapshot-sync": checkBlockdevSnapshotSyncArguments,
+}
+
+// QMPProxyRequest is the body of a request to the QMP proxy, in the same format as a QMP command.
+type QMPProxyRequest struct {
+	Execute   string          `json:"execute"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type QMPProxy struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+	Logger   logr.Logger
+	// AllowedCommands is the set of QMP commands that may be run through the proxy.
+	AllowedCommands map[string]struct{}
+}
+
+func (p *QMPProxy) SetupWithManager(mgr ctrl.Manager) {
+	mgr.GetWebhookServer().Register(qmpProxyPathPrefix, p)
+}
+
+// ServeHTTP implements http.Handler
+func (p *QMPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("request method must be %s", http.MethodPost), http.StatusMethodNotAllowed)
+		return
+	}
+
+	namespace, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, qmpProxyPathPrefix), "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		http.Error(w, fmt.Sprintf("path must be %s<namespace>/<name>", qmpProxyPathPrefix), http.StatusNotFound)
+		return
+	}
+	vmName := client.ObjectKey{Namespace: namespace, Name: name}
+	log := p.Logger.WithValues("VirtualMachine", vmName)
+
+	user, status, err := p.authorize(r, vmName)
+	if err != nil {
+		log.Info("Rejected QMP proxy request", "reason", err.Error())
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log = log.WithValues("user", user.Username, "groups", user.Groups)
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not read body: %s", err), http.StatusBadRequest)
+		return
+	}
+	var req QMPProxyRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("could not parse body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if _, ok := p.AllowedCommands[req.Execute]; !ok {
+		log.Info("Rejected QMP proxy request for command that isn't allowed", "command", req.Execute)
+		http.Error(w, fmt.Sprintf("QMP command %q is not allowed", req.Execute), http.StatusForbidden)
+		return
+	}
+	if check, ok := qmpProxyArgumentCheckers[req.Execute]; ok {
+		args, err := check(req.Arguments)
+		if err != nil {
+			log.Info("Rejected QMP proxy request with arguments that aren't allowed", "command", req.Execute, "reason", err.Error())
+			http.Error(w, fmt.Sprintf("invalid arguments for QMP command %q: %s", req.Execute, err), http.StatusBadRequest)
+			return
+		}
+		req.Arguments = args
+	}
+
+	vm := &vmv1.VirtualMachine{}
+	if err := p.Client.Get(r.Context(), vmName, vm); err != nil {
+		if apierrors.IsNotFound(err) {
+			http.Error(w, "VirtualMachine not found", http.StatusNotFound)
+		} else {
+			log.Error(err, "Failed to get VirtualMachine for QMP proxy request")
+			http.Error(w, "failed to get VirtualMachine", http.StatusInternalServerError)
+		}
+		return
+	}
+	if vm.Status.Phase != vmv1.VmRunning || vm.Status.PodIP == "" {
+		http.Error(w, fmt.Sprintf("VirtualMachine is not running (phase %q)", vm.Status.Phase), http.StatusConflict)
+		return
+	}
+
+	// Log before running the command as well as after, so that there's a record of it even if
+	// something goes wrong in the meantime.
+	log.Info("Running QMP command through proxy", "command", req.Execute, "arguments", string(req.Arguments))
+
+	result, err := runProxiedQMPCommand(vm, req)
+	if err != nil {
+		log.Error(err, "QMP command through proxy failed", "command", req.Execute)
+		p.Recorder.Eventf(vm, "Warning", "QMPProxyCommand",
+			"QMP command %q from %s failed: %s", req.Execute, user.Username, err)
+		http.Error(w, fmt.Sprintf("QMP command failed: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	log.Info("QMP command through proxy succeeded", "command", req.Execute)
+	p.Recorder.Eventf(vm, "Normal", "QMPProxyCommand", "Ran QMP command %q from %s", req.Execute, user.Username)
+
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(result)
+}
+
+// authorize checks that the request's bearer token belongs to a user that's allowed to run QMP
+// commands on the VM, returning the user if so, or the HTTP status to respond with if not.
+func (p *QMPProxy) authorize(r *http.Request, vmName client.ObjectKey) (*authenticationv1.UserInfo, int, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, http.StatusUnauthorized, errors.New("missing bearer token")
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	}
+	if err := p.Client.Create(r.Context(), review); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("could not review token: %w", err)
+	} else if !review.Status.Authenticated {
+		return nil, http.StatusUnauthorized, errors.New("invalid bearer token")
+	}
+	user := review.Status.User
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   vmName.Namespace,
+				Verb:        "create",
+				Group:       vmv1.SchemeGroupVersion.Group,
+				Resource:    "virtualmachines",
+				Subresource: "qmp",
+				Name:        vmName.Name,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			Extra:  extra,
+			UID:    user.UID,
+		},
+	}
+	if err := p.Client.Create(r.Context(), access); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("could not review access: %w", err)
+	} else if !access.Status.Allowed {
+		return nil, http.StatusForbidden, fmt.Errorf("user %q may not run QMP commands on VirtualMachine %s", user.Username, vmName)
+	}
+
+	return &user, 0, nil
+}
+
+// checkQMPProxyOutputPath returns an error if the path isn't a file directly in qmpProxyOutputDir
+// that starts with qmpProxyOutputPrefix.
+func checkQMPProxyOutputPath(path string) error {
+	if filepath.Clean(path) != path || filepath.Dir(path) != qmpProxyOutputDir ||
+		!strings.HasPrefix(filepath.Base(path), qmpProxyOutputPrefix) {
+		return fmt.Errorf("path %q must be a file in %s starting with %q", path, qmpProxyOutputDir, qmpProxyOutputPrefix)
+	}
+	return nil
+}
+
+// decodeQMPProxyArguments parses the arguments into v, rejecting any fields that v doesn't have.
+func decodeQMPProxyArguments(args json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("could not parse arguments: %w", err)
+	}
+	return nil
+}
+
+type dumpGuestMemoryArguments struct {
+	Paging   bool   `json:"paging"`
+	Protocol string `json:"protocol"`
+	Detach   bool   `json:"detach"`
+	Begin    *int64 `json:"begin,omitempty"`
+	Length   *int64 `json:"length,omitempty"`
+	Format   string `json:"format,omitempty"`
+}
+
+// checkDumpGuestMemoryArguments only allows dumping to a file in qmpProxyOutputDir, and always runs
+// the dump in the background, so that it doesn't block the QMP monitor.
+func checkDumpGuestMemoryArguments(raw json.RawMessage) (json.RawMessage, error) {
+	var args dumpGuestMemoryArguments
+	if err := decodeQMPProxyArguments(raw, &args); err != nil {
+		return nil, err
+	}
+
+	path, ok := strings.CutPrefix(args.Protocol, "file:")
+	if !ok {
+		return nil, fmt.Errorf("protocol %q must be a file", args.Protocol)
+	}
+	if err := checkQMPProxyOutputPath(path); err != nil {
+		return nil, err
+	}
+	if !slices.Contains([]string{"", "elf", "kdump-zlib", "kdump-lzo", "kdump-snappy"}, args.Format) {
+		return nil, fmt.Errorf("format %q is not allowed", args.Format)
+	}
+
+	args.Detach = true
+	return json.Marshal(args)
+}
+
+type blockdevSnapshotSyncArguments struct {
+	Device           string `json:"device,omitempty"`
+	NodeName         string `json:"node-name,omitempty"`
+	SnapshotFile     string `json:"snapshot-file"`
+	SnapshotNodeName string `json:"snapshot-node-name,omitempty"`
+	Format           string `json:"format,omitempty"`
+	Mode             string `json:"mode,omitempty"`
+}
+
+// checkBlockdevSnapshotSyncArguments only allows creating new qcow2 snapshots in qmpProxyOutputDir.
+func checkBlockdevSnapshotSyncArguments(raw json.RawMessage) (json.RawMessage, error) {
+	var args blockdevSnapshotSyncArguments
+	if err := decodeQMPProxyArguments(raw, &args); err != nil {
+		return nil, err
+	}
+
+	if err := checkQMPProxyOutputPath(args.SnapshotFile); err != nil {
+		return nil, err
+	}
+	if args.Format != "" && args.Format != "qcow2" {
+		return nil, fmt.Errorf("format %q is not allowed", args.Format)
+	}
+	// "existing" would reuse whatever file is already at the path, rather than creating it.
+	if args.Mode != "" && args.Mode != "absolute-paths" {
+		return nil, fmt.Errorf("mode %q is not allowed", args.Mode)
+	}
+
+	return json.Marshal(args)
+}
+
+func runProxiedQMPCommand(vm *vmv1.VirtualMachine, req QMPProxyRequest) ([]byte, error) {
+	cmd, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal command: %w", err)
+	}
+
+	mon, err := QmpConnect(QmpAddr(vm))
+	if err != nil {
+		return nil, err
+	}
+	defer mon.Disconnect() //nolint:errcheck // nothing to do with error when deferred.
+
+	return mon.Run(cmd)
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestQMPProxyRejectsBadRequests(t *testing.T) {
+	proxy := &QMPProxy{
+		Client:          fake.NewClientBuilder().Build(),
+		Recorder:        record.NewFakeRecorder(10),
+		Logger:          logr.Discard(),
+		AllowedCommands: map[string]struct{}{"query-status": {}},
+	}
+
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{
+			name:       "wrong-method",
+			method:     http.MethodGet,
+			path:       "/qmp/default/example",
+			token:      "token",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "missing-name",
+			method:     http.MethodPost,
+			path:       "/qmp/default",
+			token:      "token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "extra-path",
+			method:     http.MethodPost,
+			path:       "/qmp/default/example/more",
+			token:      "token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "missing-token",
+			method:     http.MethodPost,
+			path:       "/qmp/default/example",
+			token:      "",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(`{"execute": "query-status"}`))
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			w := httptest.NewRecorder()
+
+			proxy.ServeHTTP(w, req)
+			assert.Equal(t, c.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestQMPProxyArgumentCheckers(t *testing.T) {
+	cases := []struct {
+		name     string
+		command  string
+		args     string
+		expected string // empty if the arguments should be rejected
+	}{
+		{
+			name:     "dump-detached",
+			command:  "dump-guest-memory",
+			args:     `{"paging": false, "protocol": "file:/vm/images/qmp-proxy-dump.elf"}`,
+			expected: `{"paging":false,"protocol":"file:/vm/images/qmp-proxy-dump.elf","detach":true}`,
+		},
+		{
+			name:     "dump-kdump",
+			command:  "dump-guest-memory",
+			args:     `{"paging": false, "protocol": "file:/vm/images/qmp-proxy-dump", "format": "kdump-zlib", "detach": false}`,
+			expected: `{"paging":false,"protocol":"file:/vm/images/qmp-proxy-dump","detach":true,"format":"kdump-zlib"}`,
+		},
+		{
+			name:     "dump-fd",
+			command:  "dump-guest-memory",
+			args:     `{"paging": false, "protocol": "fd:dump"}`,
+			expected: "",
+		},
+		{
+			name:     "dump-outside-dir",
+			command:  "dump-guest-memory",
+			args:     `{"paging": false, "protocol": "file:/etc/qmp-proxy-dump"}`,
+			expected: "",
+		},
+		{
+			name:     "dump-traversal",
+			command:  "dump-guest-memory",
+			args:     `{"paging": false, "protocol": "file:/vm/images/qmp-proxy-x/../rootdisk.qcow2"}`,
+			expected: "",
+		},
+		{
+			name:     "dump-without-prefix",
+			command:  "dump-guest-memory",
+			args:     `{"paging": false, "protocol": "file:/vm/images/rootdisk.qcow2"}`,
+			expected: "",
+		},
+		{
+			name:     "dump-unknown-format",
+			command:  "dump-guest-memory",
+			args:     `{"paging": false, "protocol": "file:/vm/images/qmp-proxy-dump", "format": "other"}`,
+			expected: "",
+		},
+		{
+			name:     "dump-unknown-field",
+			command:  "dump-guest-memory",
+			args:     `{"paging": false, "protocol": "file:/vm/images/qmp-proxy-dump", "other": 1}`,
+			expected: "",
+		},
+		{
+			name:     "dump-missing-arguments",
+			command:  "dump-guest-memory",
+			args:     ``,
+			expected: "",
+		},
+		{
+			name:     "snapshot",
+			command:  "blockdev-snapshot-sync",
+			args:     `{"device": "rootdisk", "snapshot-file": "/vm/images/qmp-proxy-snap.qcow2", "format": "qcow2"}`,
+			expected: `{"device":"rootdisk","snapshot-file":"/vm/images/qmp-proxy-snap.qcow2","format":"qcow2"}`,
+		},
+		{
+			name:     "snapshot-outside-dir",
+			command:  "blockdev-snapshot-sync",
+			args:     `{"device": "rootdisk", "snapshot-file": "/tmp/qmp-proxy-snap.qcow2"}`,
+			expected: "",
+		},
+		{
+			name:     "snapshot-raw",
+			command:  "blockdev-snapshot-sync",
+			args:     `{"device": "rootdisk", "snapshot-file": "/vm/images/qmp-proxy-snap", "format": "raw"}`,
+			expected: "",
+		},
+		{
+			name:     "snapshot-existing",
+			command:  "blockdev-snapshot-sync",
+			args:     `{"device": "rootdisk", "snapshot-file": "/vm/images/qmp-proxy-snap.qcow2", "mode": "existing"}`,
+			expected: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args, err := qmpProxyArgumentCheckers[c.command](json.RawMessage(c.args))
+			if c.expected == "" {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, c.expected, string(args))
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package controllers
+
+// Tracking why each reconcile happened and whether it did anything, so that we can tell how much
+// reconcile traffic is real work vs churn.
+//
+// The reason is recorded by predicates on the controller's watches, and consumed by the next
+// reconcile of the object. Reconciles without a recorded reason were requeued by a previous
+// reconcile, so they're counted as periodic resyncs. Updates from the informers' periodic resync
+// (where the object's resourceVersion hasn't changed) don't record a reason, so they're counted
+// the same way.
+//
+// The result is determined by counting writes made through the client returned by
+// WithWriteTracking during the reconcile.
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ReconcileReason is why an object was reconciled, used as the "reason" label on the
+// reconcile_outcomes_total metric
+type ReconcileReason string
+
+const (
+	// ReconcileReasonSpecChange is for reconciles triggered by the object being created, or by a
+	// change to its spec (i.e., its generation).
+	ReconcileReasonSpecChange ReconcileReason = "spec-change"
+	// ReconcileReasonStatusDrift is for reconciles triggered by changes to the object that don't
+	// change its generation, e.g. to its status or metadata.
+	ReconcileReasonStatusDrift ReconcileReason = "status-drift"
+	// ReconcileReasonExternalEvent is for reconciles triggered by changes to objects owned by the
+	// reconciled object (e.g., runner pods), or by the object being deleted.
+	ReconcileReasonExternalEvent ReconcileReason = "external-event"
+	// ReconcileReasonPeriodicResync is for reconciles that weren't triggered by any watch event,
+	// i.e. they were requeued.
+	ReconcileReasonPeriodicResync ReconcileReason = "periodic-resync"
+)
+
+// priority returns the relative priority of the reason, for when there's more than one event
+// before the object is reconciled.
+func (r ReconcileReason) priority() int {
+	switch r {
+	case ReconcileReasonSpecChange:
+		return 3
+	case ReconcileReasonExternalEvent:
+		return 2
+	case ReconcileReasonStatusDrift:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ReconcileResult is what a reconcile did, used as the "result" label on the
+// reconcile_outcomes_total metric
+type ReconcileResult string
+
+const (
+	// ReconcileResultNoOp is for successful reconciles that made no changes
+	ReconcileResultNoOp ReconcileResult = "no-op"
+	// ReconcileResultUpdated is for successful reconciles that wrote to the API server
+	ReconcileResultUpdated ReconcileResult = "updated"
+	// ReconcileResultFailed is for reconciles that returned an error
+	ReconcileResultFailed ReconcileResult = "failed"
+)
+
+// reconcileTriggers records the reason for the next reconcile of each object
+type reconcileTriggers struct {
+	mu      sync.Mutex
+	pending map[client.ObjectKey]ReconcileReason
+}
+
+func newReconcileTriggers() *reconcileTriggers {
+	return &reconcileTriggers{
+		mu:      sync.Mutex{},
+		pending: make(map[client.ObjectKey]ReconcileReason),
+	}
+}
+
+func (t *reconcileTriggers) record(key client.ObjectKey, reason ReconcileReason) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if existing, ok := t.pending[key]; !ok || reason.priority() > existing.priority() {
+		t.pending[key] = reason
+	}
+}
+
+// take returns the reason the object is being reconciled, and clears it for the next reconcile
+func (t *reconcileTriggers) take(key client.ObjectKey) ReconcileReason {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reason, ok := t.pending[key]
+	if !ok {
+		return ReconcileReasonPeriodicResync
+	}
+	delete(t.pending, key)
+	return reason
+}
+
+// forPredicate returns a predicate for the controller's For() watch that records the reason for
+// reconciling the object. It never filters out any events.
+func (t *reconcileTriggers) forPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			t.record(client.ObjectKeyFromObject(e.Object), ReconcileReasonSpecChange)
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if isResync(e) {
+				return true
+			}
+			reason := ReconcileReasonStatusDrift
+			if e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() {
+				reason = ReconcileReasonSpecChange
+			}
+			t.record(client.ObjectKeyFromObject(e.ObjectNew), reason)
+			return true
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			t.record(client.ObjectKeyFromObject(e.Object), ReconcileReasonExternalEvent)
+			return true
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			t.record(client.ObjectKeyFromObject(e.Object), ReconcileReasonExternalEvent)
+			return true
+		},
+	}
+}
+
+// ownsPredicate returns a predicate for the controller's Owns() watches that records the reason
+// for reconciling the owner of the object, if its controller is of the given kind. It never
+// filters out any events.
+func (t *reconcileTriggers) ownsPredicate(ownerKind schema.GroupKind) predicate.Predicate {
+	recordOwner := func(obj client.Object) {
+		owner := metav1.GetControllerOf(obj)
+		if owner == nil {
+			return
+		}
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil || gv.Group != ownerKind.Group || owner.Kind != ownerKind.Kind {
+			return
+		}
+		key := client.ObjectKey{Namespace: obj.GetNamespace(), Name: owner.Name}
+		t.record(key, ReconcileReasonExternalEvent)
+	}
+
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			recordOwner(e.Object)
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !isResync(e) {
+				recordOwner(e.ObjectNew)
+			}
+			return true
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			recordOwner(e.Object)
+			return true
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			recordOwner(e.Object)
+			return true
+		},
+	}
+}
+
+// isResync returns whether the update is from the informer's periodic resync, rather than an
+// actual change to the object
+func isResync(e event.UpdateEvent) bool {
+	return e.ObjectOld.GetResourceVersion() == e.ObjectNew.GetResourceVersion()
+}
+
+type writeCounterKey struct{}
+
+// withWriteCounter returns a context that counts the writes made with it through a client from
+// WithWriteTracking
+func withWriteCounter(ctx context.Context) (context.Context, *atomic.Int64) {
+	counter := new(atomic.Int64)
+	return context.WithValue(ctx, writeCounterKey{}, counter), counter
+}
+
+func recordWrite(ctx context.Context) {
+	if counter, ok := ctx.Value(writeCounterKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+}
+
+// WithWriteTracking wraps the client so that writes made during a reconcile are counted, to
+// distinguish reconciles that updated something from ones that didn't.
+func WithWriteTracking(c client.Client) client.Client {
+	return &writeTrackingClient{Client: c}
+}
+
+type writeTrackingClient struct {
+	client.Client
+}
+
+func (c *writeTrackingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	recordWrite(ctx)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *writeTrackingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	recordWrite(ctx)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *writeTrackingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	recordWrite(ctx)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *writeTrackingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	recordWrite(ctx)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *writeTrackingClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	recordWrite(ctx)
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *writeTrackingClient) Status() client.SubResourceWriter {
+	return &writeTrackingSubResourceClient{SubResourceClient: c.Client.SubResource("status")}
+}
+
+func (c *writeTrackingClient) SubResource(subResource string) client.SubResourceClient {
+	return &writeTrackingSubResourceClient{SubResourceClient: c.Client.SubResource(subResource)}
+}
+
+type writeTrackingSubResourceClient struct {
+	client.SubResourceClient
+}
+
+func (c *writeTrackingSubResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	recordWrite(ctx)
+	return c.SubResourceClient.Create(ctx, obj, subResource, opts...)
+}
+
+func (c *writeTrackingSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	recordWrite(ctx)
+	return c.SubResourceClient.Update(ctx, obj, opts...)
+}
+
+func (c *writeTrackingSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	recordWrite(ctx)
+	return c.SubResourceClient.Patch(ctx, obj, patch, opts...)
+}
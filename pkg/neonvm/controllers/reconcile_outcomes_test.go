@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+func TestReconcileTriggers(t *testing.T) {
+	triggers := newReconcileTriggers()
+	forPredicate := triggers.forPredicate()
+	ownsPredicate := triggers.ownsPredicate(vmv1.SchemeGroupVersion.WithKind("VirtualMachine").GroupKind())
+
+	vm := defaultVm()
+	key := client.ObjectKeyFromObject(vm)
+
+	// Nothing recorded: the reconcile was requeued
+	assert.Equal(t, ReconcileReasonPeriodicResync, triggers.take(key))
+
+	updated := vm.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Status.Phase = vmv1.VmRunning
+	assert.True(t, forPredicate.Update(event.UpdateEvent{ObjectOld: vm, ObjectNew: updated}))
+	assert.Equal(t, ReconcileReasonStatusDrift, triggers.take(key))
+	assert.Equal(t, ReconcileReasonPeriodicResync, triggers.take(key))
+
+	// Informer resyncs don't change the resourceVersion, and aren't status drift
+	assert.True(t, forPredicate.Update(event.UpdateEvent{ObjectOld: updated, ObjectNew: updated}))
+	assert.Equal(t, ReconcileReasonPeriodicResync, triggers.take(key))
+
+	//nolint:exhaustruct // this is a test
+	runner := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "runner",
+			Namespace: vm.Namespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: vmv1.SchemeGroupVersion.String(),
+				Kind:       "VirtualMachine",
+				Name:       vm.Name,
+				UID:        vm.UID,
+				Controller: lo.ToPtr(true),
+			}},
+		},
+	}
+	updatedRunner := runner.DeepCopy()
+	updatedRunner.ResourceVersion = "2"
+	assert.True(t, ownsPredicate.Update(event.UpdateEvent{ObjectOld: runner, ObjectNew: updatedRunner}))
+	assert.Equal(t, ReconcileReasonExternalEvent, triggers.take(key))
+
+	// ... but not for resyncs of the owned object
+	assert.True(t, ownsPredicate.Update(event.UpdateEvent{ObjectOld: updatedRunner, ObjectNew: updatedRunner}))
+	assert.Equal(t, ReconcileReasonPeriodicResync, triggers.take(key))
+
+	// Objects controlled by something else with the same name aren't attributed to the VM
+	//nolint:exhaustruct // this is a test
+	for _, owner := range []metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: vm.Name, UID: "rs", Controller: lo.ToPtr(true)},
+		{APIVersion: "example.com/v1", Kind: "VirtualMachine", Name: vm.Name, UID: "other", Controller: lo.ToPtr(true)},
+	} {
+		other := runner.DeepCopy()
+		other.OwnerReferences = []metav1.OwnerReference{owner}
+		assert.True(t, ownsPredicate.Create(event.CreateEvent{Object: other}))
+		assert.Equal(t, ReconcileReasonPeriodicResync, triggers.take(key))
+	}
+
+	// With multiple events before the reconcile, spec changes take precedence
+	updated = vm.DeepCopy()
+	updated.ResourceVersion = "3"
+	updated.Generation += 1
+	assert.True(t, ownsPredicate.Update(event.UpdateEvent{ObjectOld: runner, ObjectNew: updatedRunner}))
+	assert.True(t, forPredicate.Update(event.UpdateEvent{ObjectOld: vm, ObjectNew: updated}))
+	assert.True(t, forPredicate.Update(event.UpdateEvent{ObjectOld: updated, ObjectNew: updated}))
+	assert.Equal(t, ReconcileReasonSpecChange, triggers.take(key))
+}
+
+func TestWriteTracking(t *testing.T) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachine{})
+	c := WithWriteTracking(fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&vmv1.VirtualMachine{}).
+		Build())
+
+	ctx, writes := withWriteCounter(context.Background())
+
+	vm := defaultVm()
+	require.NoError(t, c.Create(ctx, vm))
+	assert.Equal(t, int64(1), writes.Load())
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(vm), vm))
+	assert.Equal(t, int64(1), writes.Load())
+
+	vm.Status.Phase = vmv1.VmRunning
+	require.NoError(t, c.Status().Update(ctx, vm))
+	assert.Equal(t, int64(2), writes.Load())
+
+	// Writes without a counter in the context aren't counted anywhere
+	require.NoError(t, c.Delete(context.Background(), vm))
+	assert.Equal(t, int64(2), writes.Load())
+}
@@ -0,0 +1,325 @@
+package controllers
+
+// Recycling runner pods that have been running for longer than a maximum age, so that fixes to the
+// kernel, QEMU, or neonvm-runner eventually reach long-lived VMs.
+//
+// Old runner pods are always flagged with the RunnerPodExpired condition. Depending on the
+// configured action, the VM is then also moved onto a fresh runner pod during the maintenance
+// window, either by live migration or by restarting it.
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/neonvm/controllers/buildtag"
+)
+
+// typeRunnerPodExpiredVirtualMachine represents whether the VM's runner pod is older than the
+// maximum runner pod age
+const typeRunnerPodExpiredVirtualMachine = "RunnerPodExpired"
+
+// runnerPodRestartingReason is the reason given on the RunnerPodExpired condition while the VM is
+// restarting to recycle its runner pod, so that we can limit how many are ongoing at once.
+const runnerPodRestartingReason = "Restarting"
+
+// recyclingMigrationLabel is the label set on VirtualMachineMigrations created to recycle a VM's
+// runner pod, so that we can limit how many are ongoing at once.
+const recyclingMigrationLabel = "vm.neon.tech/runner-pod-recycling"
+
+// RecyclingAction is what the controller does with VMs whose runner pods are older than the
+// maximum age
+type RecyclingAction string
+
+const (
+	// RecyclingActionFlag only sets the RunnerPodExpired condition on the VM
+	RecyclingActionFlag RecyclingAction = "flag"
+	// RecyclingActionMigrate live-migrates the VM onto a new runner pod
+	RecyclingActionMigrate RecyclingAction = "migrate"
+	// RecyclingActionRestart deletes the runner pod and restarts the VM in a new one
+	RecyclingActionRestart RecyclingAction = "restart"
+)
+
+// RunnerPodRecyclingConfig configures recycling of old runner pods
+type RunnerPodRecyclingConfig struct {
+	// MaxAge is the default maximum age of runner pods. It can be overridden for individual VMs
+	// with vmv1.VirtualMachineMaxRunnerPodAgeAnnotation.
+	MaxAge time.Duration
+	// Action is what to do with VMs whose runner pods are older than the maximum age
+	Action RecyclingAction
+	// Window, if not nil, is the daily maintenance window during which runner pods may be
+	// migrated or restarted. If nil, they may be recycled at any time.
+	Window *MaintenanceWindow
+	// MaxConcurrentMigrations is the maximum number of migrations for recycling that may be
+	// ongoing across the cluster at once. Only meaningful for RecyclingActionMigrate.
+	MaxConcurrentMigrations int
+	// MaxConcurrentRestarts is the maximum number of VMs that may be restarting for recycling
+	// across the cluster at once. Only meaningful for RecyclingActionRestart.
+	MaxConcurrentRestarts int
+}
+
+// MaintenanceWindow is a daily window of time, in UTC
+type MaintenanceWindow struct {
+	// Start is the start of the window, as an offset from midnight
+	Start time.Duration
+	// Duration is the length of the window
+	Duration time.Duration
+}
+
+// ParseMaintenanceWindow parses a MaintenanceWindow in the format "HH:MM-HH:MM", in UTC.
+//
+// The window may wrap around midnight, e.g. "22:00-02:00".
+func ParseMaintenanceWindow(value string) (*MaintenanceWindow, error) {
+	startStr, endStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected format HH:MM-HH:MM")
+	}
+
+	parseTime := func(s string) (time.Duration, error) {
+		t, err := time.Parse("15:04", s)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+	}
+
+	start, err := parseTime(startStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time: %w", err)
+	}
+	end, err := parseTime(endStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end time: %w", err)
+	}
+
+	duration := end - start
+	if duration <= 0 {
+		duration += 24 * time.Hour
+	}
+	return &MaintenanceWindow{Start: start, Duration: duration}, nil
+}
+
+// Contains returns whether t is within the window
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	sinceMidnight := t.Sub(midnight)
+
+	// If the window wraps around midnight, t may be in the window that started yesterday.
+	for _, start := range []time.Duration{w.Start, w.Start - 24*time.Hour} {
+		if sinceMidnight >= start && sinceMidnight < start+w.Duration {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRunnerPodAge returns the maximum age of the VM's runner pod, or zero if it shouldn't be
+// recycled
+func (c *RunnerPodRecyclingConfig) maxRunnerPodAge(vm *vmv1.VirtualMachine) (time.Duration, error) {
+	value, ok := vm.Annotations[vmv1.VirtualMachineMaxRunnerPodAgeAnnotation]
+	if !ok {
+		return c.MaxAge, nil
+	}
+	maxAge, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation: %w", vmv1.VirtualMachineMaxRunnerPodAgeAnnotation, err)
+	}
+	return maxAge, nil
+}
+
+// reconcileRunnerPodRecycling flags the running VM if its runner pod is older than the maximum
+// age, and migrates or restarts it if configured to, returning whether the VM was restarted.
+//
+// If the VM was restarted, the caller must not continue reconciling the old runner pod.
+func (r *VMReconciler) reconcileRunnerPodRecycling(
+	ctx context.Context,
+	vm *vmv1.VirtualMachine,
+	runner *corev1.Pod,
+) (restarted bool, _ error) {
+	log := log.FromContext(ctx)
+
+	cfg := r.Config.RunnerPodRecycling
+	if cfg == nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	age := now.Sub(runner.CreationTimestamp.Time)
+	r.Metrics.runnerPodAge.WithLabelValues(vm.Namespace, vm.Name).Set(age.Seconds())
+
+	maxAge, err := cfg.maxRunnerPodAge(vm)
+	if err != nil {
+		// Don't fail the rest of the reconcile because of a bad annotation; just don't recycle.
+		log.Error(err, "Failed to get maximum runner pod age for VirtualMachine")
+		return false, nil
+	}
+
+	if maxAge == 0 || age < maxAge {
+		// Only set the condition to false if it was previously set, so that VMs with young
+		// runner pods don't get an extra condition.
+		if meta.FindStatusCondition(vm.Status.Conditions, typeRunnerPodExpiredVirtualMachine) != nil {
+			meta.SetStatusCondition(&vm.Status.Conditions, metav1.Condition{
+				Type:    typeRunnerPodExpiredVirtualMachine,
+				Status:  metav1.ConditionFalse,
+				Reason:  "NotExpired",
+				Message: fmt.Sprintf("Pod (%s) is younger than the maximum age", runner.Name),
+			})
+		}
+		return false, nil
+	}
+
+	if !meta.IsStatusConditionTrue(vm.Status.Conditions, typeRunnerPodExpiredVirtualMachine) {
+		r.Recorder.Eventf(vm, corev1.EventTypeNormal, "RunnerPodExpired",
+			"Runner pod %s is older than the maximum age of %s", runner.Name, maxAge)
+	}
+	meta.SetStatusCondition(&vm.Status.Conditions, metav1.Condition{
+		Type:    typeRunnerPodExpiredVirtualMachine,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Expired",
+		Message: fmt.Sprintf("Pod (%s) was created at %s, more than %s ago", runner.Name, runner.CreationTimestamp.UTC().Format(time.RFC3339), maxAge),
+	})
+
+	if cfg.Window != nil && !cfg.Window.Contains(now) {
+		return false, nil
+	}
+
+	switch cfg.Action {
+	case RecyclingActionFlag:
+		return false, nil
+	case RecyclingActionMigrate:
+		return false, r.recycleByMigration(ctx, vm, cfg)
+	case RecyclingActionRestart:
+		return r.recycleByRestart(ctx, vm, runner, cfg)
+	default:
+		return false, fmt.Errorf("unknown runner pod recycling action %q", cfg.Action)
+	}
+}
+
+// recycleByRestart deletes the VM's runner pod and restarts it in a new one, unless there are too
+// many VMs restarting for recycling across the cluster, returning whether the VM was restarted.
+func (r *VMReconciler) recycleByRestart(
+	ctx context.Context,
+	vm *vmv1.VirtualMachine,
+	runner *corev1.Pod,
+	cfg *RunnerPodRecyclingConfig,
+) (restarted bool, _ error) {
+	log := log.FromContext(ctx)
+
+	if buildtag.NeverDeleteRunnerPods || !runner.DeletionTimestamp.IsZero() {
+		// We can't start a new runner pod unless the old one is gone.
+		return false, nil
+	}
+
+	// VMs restarted for recycling keep runnerPodRestartingReason on their RunnerPodExpired
+	// condition until they're running again, on a new runner pod.
+	var vms vmv1.VirtualMachineList
+	if err := r.List(ctx, &vms); err != nil {
+		return false, fmt.Errorf("failed to list VirtualMachines: %w", err)
+	}
+	ongoing := 0
+	for i := range vms.Items {
+		other := &vms.Items[i]
+		if other.Status.Phase == vmv1.VmRunning {
+			continue
+		}
+		cond := meta.FindStatusCondition(other.Status.Conditions, typeRunnerPodExpiredVirtualMachine)
+		if cond != nil && cond.Status == metav1.ConditionTrue && cond.Reason == runnerPodRestartingReason {
+			ongoing += 1
+		}
+	}
+	if ongoing >= cfg.MaxConcurrentRestarts {
+		return false, nil
+	}
+
+	age := time.Since(runner.CreationTimestamp.Time)
+	log.Info("Restarting VirtualMachine to recycle old runner pod", "Pod.Name", runner.Name, "age", age)
+	if err := r.deleteRunnerPodIfEnabled(ctx, vm, runner); err != nil {
+		return false, fmt.Errorf("failed to delete old runner pod: %w", err)
+	}
+	r.Metrics.runnerPodRecycles.WithLabelValues(string(RecyclingActionRestart)).Inc()
+
+	meta.SetStatusCondition(&vm.Status.Conditions, metav1.Condition{
+		Type:    typeRunnerPodExpiredVirtualMachine,
+		Status:  metav1.ConditionTrue,
+		Reason:  runnerPodRestartingReason,
+		Message: fmt.Sprintf("Pod (%s) was deleted to restart the VM in a new runner pod", runner.Name),
+	})
+
+	// NB: This mirrors the restart from VmSucceeded/VmFailed, regardless of .spec.restartPolicy
+	// because the VM didn't stop on its own.
+	vm.Cleanup()
+	vm.Status.Phase = vmv1.VmPending
+	vm.Status.RestartCount += 1
+	r.Metrics.vmRestartCounts.Inc()
+	return true, nil
+}
+
+// recycleByMigration creates a migration for the VM onto a new runner pod, unless there's already
+// one for the VM, or there are too many ongoing across the cluster.
+func (r *VMReconciler) recycleByMigration(ctx context.Context, vm *vmv1.VirtualMachine, cfg *RunnerPodRecyclingConfig) error {
+	log := log.FromContext(ctx)
+
+	if api.IsMarkedNeverMigrate(vm) || vm.Spec.UsesMicroVM() {
+		return nil
+	}
+
+	var migrations vmv1.VirtualMachineMigrationList
+	if err := r.List(ctx, &migrations, client.MatchingLabels{recyclingMigrationLabel: "true"}); err != nil {
+		return fmt.Errorf("failed to list VirtualMachineMigrations: %w", err)
+	}
+	ongoing := 0
+	for i := range migrations.Items {
+		m := &migrations.Items[i]
+		done := m.Status.Phase == vmv1.VmmSucceeded || m.Status.Phase == vmv1.VmmFailed
+		if m.Namespace == vm.Namespace && m.Spec.VmName == vm.Name {
+			if m.Status.Phase != vmv1.VmmSucceeded {
+				// Already being recycled. If the migration failed, it's left for someone to
+				// look at, rather than trying again.
+				return nil
+			}
+			// Left over from the last time the VM was recycled; remove it so we can try again.
+			if err := r.Delete(ctx, m); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete previous VirtualMachineMigration: %w", err)
+			}
+			continue
+		}
+		if !done {
+			ongoing += 1
+		}
+	}
+	if ongoing >= cfg.MaxConcurrentMigrations {
+		return nil
+	}
+
+	vmm := &vmv1.VirtualMachineMigration{
+		ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // other fields are set by the API server
+			GenerateName: fmt.Sprintf("%s-recycle-", vm.Name),
+			Namespace:    vm.Namespace,
+			Labels:       map[string]string{recyclingMigrationLabel: "true"},
+		},
+		Spec: vmv1.VirtualMachineMigrationSpec{ //nolint:exhaustruct // other stuff will get defaulted
+			VmName: vm.Name,
+		},
+	}
+	if err := r.Create(ctx, vmm); err != nil {
+		return fmt.Errorf("failed to create VirtualMachineMigration to recycle old runner pod: %w", err)
+	}
+
+	log.Info("Created VirtualMachineMigration to recycle old runner pod", "VirtualMachineMigration", vmm.Name)
+	r.Recorder.Eventf(vm, corev1.EventTypeNormal, "RunnerPodExpired",
+		"Migrating to a new runner pod with VirtualMachineMigration %s", vmm.Name)
+	r.Metrics.runnerPodRecycles.WithLabelValues(string(RecyclingActionMigrate)).Inc()
+	return nil
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.UTC)
+	}
+
+	window, err := ParseMaintenanceWindow("02:00-04:30")
+	require.NoError(t, err)
+	assert.Equal(t, MaintenanceWindow{Start: 2 * time.Hour, Duration: 150 * time.Minute}, *window)
+	assert.False(t, window.Contains(at(1, 59)))
+	assert.True(t, window.Contains(at(2, 0)))
+	assert.True(t, window.Contains(at(4, 29)))
+	assert.False(t, window.Contains(at(4, 30)))
+
+	// Wrapping around midnight
+	window, err = ParseMaintenanceWindow("22:00-01:00")
+	require.NoError(t, err)
+	assert.Equal(t, MaintenanceWindow{Start: 22 * time.Hour, Duration: 3 * time.Hour}, *window)
+	assert.True(t, window.Contains(at(23, 0)))
+	assert.True(t, window.Contains(at(0, 30)))
+	assert.False(t, window.Contains(at(1, 0)))
+	assert.False(t, window.Contains(at(21, 59)))
+
+	for _, invalid := range []string{"", "02:00", "2am-4am", "02:00-25:00"} {
+		_, err := ParseMaintenanceWindow(invalid)
+		assert.Error(t, err, "input %q", invalid)
+	}
+}
+
+func TestReconcileRunnerPodRecycling(t *testing.T) {
+	setup := func(t *testing.T, action RecyclingAction, podAge time.Duration) (*testParams, *vmv1.VirtualMachine, *corev1.Pod) {
+		params := newTestParams(t)
+		params.r.Scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachineMigration{}, &vmv1.VirtualMachineMigrationList{})
+		params.r.Scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachineList{})
+		params.r.Config.RunnerPodRecycling = &RunnerPodRecyclingConfig{
+			MaxAge:                  24 * time.Hour,
+			Action:                  action,
+			Window:                  nil,
+			MaxConcurrentMigrations: 1,
+			MaxConcurrentRestarts:   1,
+		}
+
+		vm := defaultVm()
+		vm.Status.Phase = vmv1.VmRunning
+		vm.Status.PodName = "test-vm-runner"
+		vm = params.initVM(vm)
+
+		//nolint:exhaustruct // this is a test
+		runner := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              vm.Status.PodName,
+				Namespace:         vm.Namespace,
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-podAge)),
+			},
+		}
+		require.NoError(t, params.client.Create(params.ctx, runner))
+
+		return params, vm, runner
+	}
+
+	t.Run("young", func(t *testing.T) {
+		params, vm, runner := setup(t, RecyclingActionMigrate, time.Hour)
+
+		restarted, err := params.r.reconcileRunnerPodRecycling(params.ctx, vm, runner)
+		require.NoError(t, err)
+		assert.False(t, restarted)
+		assert.Nil(t, meta.FindStatusCondition(vm.Status.Conditions, typeRunnerPodExpiredVirtualMachine))
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		params, vm, runner := setup(t, RecyclingActionFlag, 48*time.Hour)
+		params.mockRecorder.On("Eventf", mock.Anything, "Normal", "RunnerPodExpired", mock.Anything, mock.Anything)
+
+		restarted, err := params.r.reconcileRunnerPodRecycling(params.ctx, vm, runner)
+		require.NoError(t, err)
+		assert.False(t, restarted)
+		assert.True(t, meta.IsStatusConditionTrue(vm.Status.Conditions, typeRunnerPodExpiredVirtualMachine))
+
+		var migrations vmv1.VirtualMachineMigrationList
+		require.NoError(t, params.client.List(params.ctx, &migrations))
+		assert.Empty(t, migrations.Items)
+	})
+
+	t.Run("overridden", func(t *testing.T) {
+		params, vm, runner := setup(t, RecyclingActionFlag, 48*time.Hour)
+		vm.Annotations = map[string]string{vmv1.VirtualMachineMaxRunnerPodAgeAnnotation: "0"}
+
+		restarted, err := params.r.reconcileRunnerPodRecycling(params.ctx, vm, runner)
+		require.NoError(t, err)
+		assert.False(t, restarted)
+		assert.Nil(t, meta.FindStatusCondition(vm.Status.Conditions, typeRunnerPodExpiredVirtualMachine))
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		params, vm, runner := setup(t, RecyclingActionMigrate, 48*time.Hour)
+		params.mockRecorder.On("Eventf", mock.Anything, "Normal", "RunnerPodExpired", mock.Anything, mock.Anything)
+
+		for range 2 {
+			restarted, err := params.r.reconcileRunnerPodRecycling(params.ctx, vm, runner)
+			require.NoError(t, err)
+			assert.False(t, restarted)
+		}
+
+		// Only one migration is created, even across multiple reconciles
+		var migrations vmv1.VirtualMachineMigrationList
+		require.NoError(t, params.client.List(params.ctx, &migrations))
+		require.Len(t, migrations.Items, 1)
+		assert.Equal(t, vm.Name, migrations.Items[0].Spec.VmName)
+		assert.Equal(t, "true", migrations.Items[0].Labels[recyclingMigrationLabel])
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		params, vm, runner := setup(t, RecyclingActionRestart, 48*time.Hour)
+		params.mockRecorder.On("Eventf", mock.Anything, "Normal", "RunnerPodExpired", mock.Anything, mock.Anything)
+		params.mockRecorder.On("Event", mock.Anything, "Normal", "Deleted", mock.Anything)
+
+		restarted, err := params.r.reconcileRunnerPodRecycling(params.ctx, vm, runner)
+		require.NoError(t, err)
+		assert.True(t, restarted)
+		assert.Equal(t, vmv1.VmPending, vm.Status.Phase)
+		assert.Equal(t, "", vm.Status.PodName)
+		assert.Equal(t, int32(1), vm.Status.RestartCount)
+		cond := meta.FindStatusCondition(vm.Status.Conditions, typeRunnerPodExpiredVirtualMachine)
+		require.NotNil(t, cond)
+		assert.Equal(t, runnerPodRestartingReason, cond.Reason)
+	})
+
+	t.Run("restart limit", func(t *testing.T) {
+		params, vm, runner := setup(t, RecyclingActionRestart, 48*time.Hour)
+		params.mockRecorder.On("Eventf", mock.Anything, "Normal", "RunnerPodExpired", mock.Anything, mock.Anything)
+
+		// Another VM is still restarting to recycle its runner pod
+		other := defaultVm()
+		other.Name = "other-vm"
+		other.Status.Phase = vmv1.VmPending
+		other.Status.Conditions = []metav1.Condition{{
+			Type:               typeRunnerPodExpiredVirtualMachine,
+			Status:             metav1.ConditionTrue,
+			Reason:             runnerPodRestartingReason,
+			Message:            "",
+			ObservedGeneration: 0,
+			LastTransitionTime: metav1.Now(),
+		}}
+		require.NoError(t, params.client.Create(params.ctx, other))
+
+		restarted, err := params.r.reconcileRunnerPodRecycling(params.ctx, vm, runner)
+		require.NoError(t, err)
+		assert.False(t, restarted)
+		assert.Equal(t, vmv1.VmRunning, vm.Status.Phase)
+		assert.Equal(t, runner.Name, vm.Status.PodName)
+
+		// Once the other VM is running again, this one can be restarted
+		other.Status.Phase = vmv1.VmRunning
+		require.NoError(t, params.client.Status().Update(params.ctx, other))
+		params.mockRecorder.On("Event", mock.Anything, "Normal", "Deleted", mock.Anything)
+
+		restarted, err = params.r.reconcileRunnerPodRecycling(params.ctx, vm, runner)
+		require.NoError(t, err)
+		assert.True(t, restarted)
+	})
+}
@@ -0,0 +1,97 @@
+package controllers
+
+// Maintaining .status.resources, which shows how far the most recent change to the VM's resources
+// has progressed: requested in the spec, granted to the VM by the controller, and applied in the
+// guest.
+
+import (
+	"time"
+
+	"github.com/samber/lo"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+// recordRequestedResources updates .status.resources.requested from the VM's spec.
+//
+// The autoscaler-agent sets .spec.targetRevision alongside its requests, so if it's present, we
+// use its time rather than the time we noticed the change.
+func recordRequestedResources(vm *vmv1.VirtualMachine) {
+	cpus := vm.Spec.Guest.CPUs.Use
+	memorySize := resource.NewQuantity(
+		int64(vm.Spec.Guest.MemorySlots.Use)*vm.Spec.Guest.MemorySlotSize.Value(),
+		resource.BinarySI,
+	)
+
+	at := time.Now()
+	if rev := vm.Spec.TargetRevision; rev != nil && !rev.UpdatedAt.IsZero() {
+		at = rev.UpdatedAt.Time
+	}
+
+	setResourcesStage(&resourcesStatus(vm).Requested, &cpus, memorySize, at)
+}
+
+// recordGrantedResources updates .status.resources.granted with the resources the controller has
+// made available to the VM. Either of cpus or memorySize may be nil if they're unknown.
+func recordGrantedResources(vm *vmv1.VirtualMachine, cpus *vmv1.MilliCPU, memorySize *resource.Quantity) {
+	setResourcesStage(&resourcesStatus(vm).Granted, cpus, memorySize, time.Now())
+}
+
+// recordAppliedResources updates .status.resources.applied with the resources in use by the
+// guest. Either of cpus or memorySize may be nil if they're unknown.
+func recordAppliedResources(vm *vmv1.VirtualMachine, cpus *vmv1.MilliCPU, memorySize *resource.Quantity) {
+	setResourcesStage(&resourcesStatus(vm).Applied, cpus, memorySize, time.Now())
+}
+
+func resourcesStatus(vm *vmv1.VirtualMachine) *vmv1.VirtualMachineResourcesStatus {
+	if vm.Status.Resources == nil {
+		vm.Status.Resources = &vmv1.VirtualMachineResourcesStatus{
+			Requested: nil,
+			Granted:   nil,
+			Applied:   nil,
+		}
+	}
+	return vm.Status.Resources
+}
+
+// setResourcesStage updates the stage with the new values for cpus and memorySize, leaving the
+// existing value of either if it's nil. The stage's transition time is only updated if the
+// resources changed.
+func setResourcesStage(
+	stage **vmv1.ResourcesStage,
+	cpus *vmv1.MilliCPU,
+	memorySize *resource.Quantity,
+	at time.Time,
+) {
+	if cpus == nil && memorySize == nil {
+		return
+	}
+
+	if *stage == nil {
+		*stage = &vmv1.ResourcesStage{
+			CPUs:               nil,
+			MemorySize:         nil,
+			LastTransitionTime: metav1.Time{},
+		}
+	}
+	s := *stage
+
+	changed := false
+	if cpus != nil && (s.CPUs == nil || *s.CPUs != *cpus) {
+		s.CPUs = lo.ToPtr(*cpus)
+		changed = true
+	}
+	if memorySize != nil && (s.MemorySize == nil || !s.MemorySize.Equal(*memorySize)) {
+		s.MemorySize = lo.ToPtr(memorySize.DeepCopy())
+		changed = true
+	}
+
+	if changed {
+		// Round to the same precision as when serialized, so that comparing against the status
+		// read back from the API server doesn't see a difference.
+		s.LastTransitionTime = metav1.NewTime(at.Truncate(time.Second))
+	}
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+func TestResourcesStatus(t *testing.T) {
+	vm := defaultVm()
+	vm.Spec.Guest.CPUs.Use = 2000
+	vm.Spec.Guest.MemorySlots.Use = 2
+	vm.Spec.Guest.MemorySlotSize = resource.MustParse("1Gi")
+
+	requestedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	vm.Spec.TargetRevision = &vmv1.RevisionWithTime{
+		Revision:  vmv1.Revision{Value: 1, Flags: 0},
+		UpdatedAt: metav1.NewTime(requestedAt),
+	}
+
+	recordRequestedResources(vm)
+	require.NotNil(t, vm.Status.Resources)
+	requested := vm.Status.Resources.Requested
+	require.NotNil(t, requested)
+	assert.Equal(t, vmv1.MilliCPU(2000), *requested.CPUs)
+	assert.True(t, requested.MemorySize.Equal(resource.MustParse("2Gi")))
+	assert.Equal(t, requestedAt, requested.LastTransitionTime.Time)
+	assert.Nil(t, vm.Status.Resources.Granted)
+	assert.Nil(t, vm.Status.Resources.Applied)
+
+	// Granting CPUs and memory separately updates each, keeping the other
+	recordGrantedResources(vm, lo.ToPtr(vmv1.MilliCPU(2000)), nil)
+	granted := vm.Status.Resources.Granted
+	require.NotNil(t, granted)
+	assert.Equal(t, vmv1.MilliCPU(2000), *granted.CPUs)
+	assert.Nil(t, granted.MemorySize)
+
+	recordGrantedResources(vm, nil, lo.ToPtr(resource.MustParse("2Gi")))
+	assert.Equal(t, vmv1.MilliCPU(2000), *granted.CPUs)
+	assert.True(t, granted.MemorySize.Equal(resource.MustParse("2Gi")))
+
+	// The transition time only changes if the resources do
+	granted.LastTransitionTime = metav1.NewTime(requestedAt)
+	recordGrantedResources(vm, lo.ToPtr(vmv1.MilliCPU(2000)), lo.ToPtr(resource.MustParse("2Gi")))
+	assert.Equal(t, requestedAt, granted.LastTransitionTime.Time)
+	recordGrantedResources(vm, lo.ToPtr(vmv1.MilliCPU(3000)), nil)
+	assert.True(t, granted.LastTransitionTime.After(requestedAt))
+
+	// Restarting the VM resets everything except the request
+	recordAppliedResources(vm, lo.ToPtr(vmv1.MilliCPU(2000)), nil)
+	vm.Cleanup()
+	assert.NotNil(t, vm.Status.Resources.Requested)
+	assert.Nil(t, vm.Status.Resources.Granted)
+	assert.Nil(t, vm.Status.Resources.Applied)
+}
@@ -0,0 +1,91 @@
+package controllers
+
+// Support for the node-local root disk image cache, as configured by
+// (ReconcilerConfig).RootDiskCacheDir.
+//
+// When the cache is enabled and the VM's root disk image is pinned by digest, the init container
+// copies the image into a directory on the host keyed by that digest (if it's not already there),
+// and neonvm-runner creates the VM's root disk as a thin overlay on top of it. Eviction is handled
+// by neonvm-runner; see neonvm-runner/cmd/diskcache.go for more.
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/samber/lo"
+
+	corev1 "k8s.io/api/core/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+const (
+	rootDiskCacheVolumeName = "rootdiskcache"
+	rootDiskCacheMountPath  = "/vm/cache"
+)
+
+// imageDigestRegexp matches the digest at the end of an image reference, like
+// 'neondatabase/vm-postgres@sha256:abcd...'.
+var imageDigestRegexp = regexp.MustCompile(`@([a-z0-9]+):([a-f0-9]{32,})$`)
+
+// rootDiskCacheKey returns the key for the VM's root disk image in the node-local cache, or false
+// if the cache is disabled or can't be used for this VM.
+//
+// Tags are mutable, so the cache is only used for images referenced by digest.
+func rootDiskCacheKey(config *ReconcilerConfig, vm *vmv1.VirtualMachine) (string, bool) {
+	if config.RootDiskCacheDir == "" {
+		return "", false
+	}
+
+	match := imageDigestRegexp.FindStringSubmatch(vm.Spec.Guest.RootDisk.Image)
+	if match == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s-%s", match[1], match[2]), true
+}
+
+// applyRootDiskCache updates the runner pod to use the node-local root disk image cache, with the
+// VM's image stored under the given key.
+func applyRootDiskCache(pod *corev1.Pod, config *ReconcilerConfig, key string) {
+	mount := corev1.VolumeMount{
+		Name:      rootDiskCacheVolumeName,
+		MountPath: rootDiskCacheMountPath,
+	}
+
+	// Populate the cache instead of moving the image into the pod's own volume. Copying to a
+	// temporary file first means that other pods never see a partially copied image.
+	//
+	// Touching the cached image marks it as recently used, so that it isn't evicted before
+	// neonvm-runner takes its lock.
+	dir := fmt.Sprintf("%s/%s", rootDiskCacheMountPath, key)
+	init := &pod.Spec.InitContainers[0]
+	init.VolumeMounts = append(init.VolumeMounts, mount)
+	init.Command = []string{
+		"sh", "-c",
+		fmt.Sprintf("if [ ! -f %[1]s/disk.qcow2 ]; then ", dir) +
+			fmt.Sprintf("mkdir -p %[1]s && cp /disk.qcow2 %[1]s/disk.qcow2.$HOSTNAME && ", dir) +
+			fmt.Sprintf("chmod 0644 %[1]s/disk.qcow2.$HOSTNAME && mv %[1]s/disk.qcow2.$HOSTNAME %[1]s/disk.qcow2 && ", dir) +
+			"touch /vm/images/rootdisk-cache-miss; fi && " +
+			fmt.Sprintf("touch %s/disk.qcow2 && ", dir) +
+			"sysctl -w net.ipv4.ip_forward=1",
+	}
+
+	runner := &pod.Spec.Containers[0]
+	runner.VolumeMounts = append(runner.VolumeMounts, mount)
+	runner.Args = append(
+		runner.Args,
+		fmt.Sprintf("-rootdisk-cache-dir=%s", rootDiskCacheMountPath),
+		fmt.Sprintf("-rootdisk-cache-key=%s", key),
+		fmt.Sprintf("-rootdisk-cache-max-size=%d", config.RootDiskCacheMaxSize.Value()),
+	)
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: rootDiskCacheVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: config.RootDiskCacheDir,
+				Type: lo.ToPtr(corev1.HostPathDirectoryOrCreate),
+			},
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"net/http"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// doRunnerRequest sends the request to a runner pod, checking the runner's version against ours.
+//
+// Responses from runners with incompatible versions are refused, returning an
+// *api.VersionSkewError.
+func (r *VMReconciler) doRunnerRequest(req *http.Request) (*http.Response, error) {
+	api.SetComponentVersionHeader(req.Header, api.ComponentNeonVMController)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	skew := api.CheckPeerVersionHeader(api.ComponentNeonVMController, api.ComponentNeonVMRunner, resp.Header)
+	if skew == nil {
+		return resp, nil
+	}
+
+	r.Metrics.runnerVersionSkew.WithLabelValues(skew.PeerVersion.String(), skew.Action()).Inc()
+	if skew.Refuse {
+		resp.Body.Close()
+		return nil, skew
+	}
+	log.FromContext(req.Context()).Info("Runner has unsupported version skew", "url", req.URL.String(), "error", skew.Error())
+	return resp, nil
+}
@@ -13,7 +13,7 @@ import (
 	"github.com/neondatabase/autoscaling/pkg/api"
 )
 
-func setRunnerCPULimits(ctx context.Context, vm *vmv1.VirtualMachine, cpu vmv1.MilliCPU) error {
+func (r *VMReconciler) setRunnerCPULimits(ctx context.Context, vm *vmv1.VirtualMachine, cpu vmv1.MilliCPU) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
@@ -32,7 +32,7 @@ func setRunnerCPULimits(ctx context.Context, vm *vmv1.VirtualMachine, cpu vmv1.M
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.doRunnerRequest(req)
 	if err != nil {
 		return err
 	}
@@ -44,7 +44,7 @@ func setRunnerCPULimits(ctx context.Context, vm *vmv1.VirtualMachine, cpu vmv1.M
 	return nil
 }
 
-func getRunnerCPULimits(ctx context.Context, vm *vmv1.VirtualMachine) (*api.VCPUCgroup, error) {
+func (r *VMReconciler) getRunnerCPULimits(ctx context.Context, vm *vmv1.VirtualMachine) (*api.VCPUCgroup, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
@@ -55,7 +55,7 @@ func getRunnerCPULimits(ctx context.Context, vm *vmv1.VirtualMachine) (*api.VCPU
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.doRunnerRequest(req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,168 @@
+package controllers
+
+// Keeping the runner pod's resource requests in sync with the VM's current allocation, as
+// configured by (ReconcilerConfig).SyncRunnerPodRequests.
+//
+// Without this, the runner pod's requests are fixed at creation time, so kubelet's accounting
+// (and eviction ordering) reflects the VM's size when it was created, rather than its current size.
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/discovery"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+// InPlacePodResizeMode describes how (and if) the cluster supports resizing pods in-place.
+type InPlacePodResizeMode string
+
+const (
+	// InPlacePodResizeUnsupported means that the cluster doesn't support in-place pod resizing.
+	InPlacePodResizeUnsupported InPlacePodResizeMode = ""
+	// InPlacePodResizeSubresource means that pods are resized via the 'pods/resize' subresource,
+	// as in Kubernetes 1.33+.
+	InPlacePodResizeSubresource InPlacePodResizeMode = "subresource"
+	// InPlacePodResizeSpec means that pods are resized by updating their spec directly, as in
+	// Kubernetes 1.27 through 1.32 with the InPlacePodVerticalScaling feature gate enabled.
+	InPlacePodResizeSpec InPlacePodResizeMode = "spec"
+)
+
+// DetectInPlacePodResize returns how the cluster supports in-place pod resizing.
+//
+// The 'pods/resize' subresource is advertised by the API server, so it's detected reliably. For
+// older versions, we can't tell from the API whether the feature gate is enabled, so we assume it
+// is for 1.27+ and rely on resize failures being reported by the vm_runner_pod_resizes_total
+// metric.
+func DetectInPlacePodResize(client discovery.DiscoveryInterface) (InPlacePodResizeMode, error) {
+	resources, err := client.ServerResourcesForGroupVersion("v1")
+	if err != nil {
+		return InPlacePodResizeUnsupported, fmt.Errorf("could not get server resources: %w", err)
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == "pods/resize" {
+			return InPlacePodResizeSubresource, nil
+		}
+	}
+
+	version, err := client.ServerVersion()
+	if err != nil {
+		return InPlacePodResizeUnsupported, fmt.Errorf("could not get server version: %w", err)
+	}
+	major, majorErr := strconv.Atoi(strings.TrimSuffix(version.Major, "+"))
+	minor, minorErr := strconv.Atoi(strings.TrimSuffix(version.Minor, "+"))
+	if majorErr != nil || minorErr != nil {
+		return InPlacePodResizeUnsupported, fmt.Errorf("could not parse server version %q", version.String())
+	}
+	if major > 1 || (major == 1 && minor >= 27) {
+		return InPlacePodResizeSpec, nil
+	}
+	return InPlacePodResizeUnsupported, nil
+}
+
+// runnerPodRequests returns the requests that the runner container should have, given the VM's
+// CPU and memory.
+//
+// Requests for other resources are taken from the base resources (from .spec.podResources), and
+// CPU and memory requests are capped at any limits from the base, because requests may not exceed
+// limits.
+func runnerPodRequests(base corev1.ResourceRequirements, cpu vmv1.MilliCPU, mem resource.Quantity) corev1.ResourceList {
+	requests := base.Requests.DeepCopy()
+	if requests == nil {
+		requests = corev1.ResourceList{}
+	}
+
+	requests[corev1.ResourceCPU] = *cpu.ToResourceQuantity()
+	requests[corev1.ResourceMemory] = mem
+
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		request := requests[name]
+		if limit, ok := base.Limits[name]; ok && request.Cmp(limit) > 0 {
+			requests[name] = limit
+		}
+	}
+
+	return requests
+}
+
+// requestsEqual returns whether the two sets of requests are semantically equal, ignoring
+// differences in representation (e.g. "1" vs "1000m").
+func requestsEqual(a, b corev1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, qa := range a {
+		if qb, ok := b[name]; !ok || qa.Cmp(qb) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// syncRunnerPodRequests updates the runner container's requests to match the given CPU and memory,
+// if they've drifted.
+//
+// This uses in-place pod resizing, so it does nothing if the cluster doesn't support it (see
+// DetectInPlacePodResize). Failures are only logged, because they shouldn't affect the VM itself.
+func (r *VMReconciler) syncRunnerPodRequests(
+	ctx context.Context,
+	vm *vmv1.VirtualMachine,
+	pod *corev1.Pod,
+	cpu vmv1.MilliCPU,
+	mem resource.Quantity,
+) {
+	if !r.Config.SyncRunnerPodRequests || r.Config.InPlacePodResize == InPlacePodResizeUnsupported {
+		return
+	}
+
+	log := log.FromContext(ctx)
+
+	var container *corev1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == "neonvm-runner" {
+			container = &pod.Spec.Containers[i]
+		}
+	}
+	if container == nil {
+		return
+	}
+
+	desired := runnerPodRequests(vm.Spec.PodResources, cpu, mem)
+	if requestsEqual(container.Resources.Requests, desired) {
+		return
+	}
+
+	log.Info(
+		"Updating runner pod resource requests to match VM",
+		"Pod", pod.Name,
+		"OldRequests", container.Resources.Requests,
+		"NewRequests", desired,
+	)
+
+	patch := client.StrategicMergeFrom(pod.DeepCopy())
+	container.Resources.Requests = desired
+
+	var err error
+	switch r.Config.InPlacePodResize {
+	case InPlacePodResizeSubresource:
+		err = r.SubResource("resize").Patch(ctx, pod, patch)
+	case InPlacePodResizeSpec:
+		err = r.Patch(ctx, pod, patch)
+	default:
+		panic(fmt.Errorf("unknown in-place pod resize mode %q", r.Config.InPlacePodResize))
+	}
+	if err != nil {
+		log.Error(err, "Failed to update runner pod resource requests", "Pod", pod.Name)
+		r.Metrics.runnerPodResizes.WithLabelValues("failure").Inc()
+		return
+	}
+	r.Metrics.runnerPodResizes.WithLabelValues("success").Inc()
+}
@@ -0,0 +1,186 @@
+package controllers
+
+// Placing runner pods close to (or away from) the storage backing their network-attached volumes.
+//
+// VMs opt in with the api.AnnotationStorageLocality annotation, naming the PersistentVolumeClaim
+// whose volume should be used. When creating a runner pod, we resolve the claim's volume to the
+// values of ReconcilerConfig.StorageTopologyKey where it's available, and add a preferred node
+// affinity term for (or against) those values.
+//
+// This is only a preference: if the volume can't be resolved, or no matching nodes have room, the
+// pod is scheduled as usual.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// DefaultStorageLocalityWeight is the default weight of the preferred node affinity term added for
+// storage locality.
+const DefaultStorageLocalityWeight = 50
+
+// resolveStorageTopology returns the topology of the storage named by the VM's
+// api.AnnotationStorageLocality annotation, or nil if storage locality is disabled, the VM doesn't
+// have the annotation, or the topology couldn't be determined.
+//
+// Problems with the annotation or the volume are logged rather than returned, so that they don't
+// block creating the runner pod.
+func resolveStorageTopology(
+	ctx context.Context,
+	c client.Client,
+	vm *vmv1.VirtualMachine,
+	config *ReconcilerConfig,
+) (*api.StorageTopology, error) {
+	if config.StorageTopologyKey == "" {
+		return nil, nil
+	}
+
+	logger := log.FromContext(ctx)
+
+	locality, err := api.ExtractStorageLocality(vm)
+	if err != nil {
+		logger.Error(err, "Ignoring storage locality for VM")
+		return nil, nil
+	} else if locality == nil {
+		return nil, nil
+	}
+
+	var pvc corev1.PersistentVolumeClaim
+	err = c.Get(ctx, types.NamespacedName{Namespace: vm.Namespace, Name: locality.PersistentVolumeClaim}, &pvc)
+	if apierrors.IsNotFound(err) {
+		logger.Info("Ignoring storage locality for VM because PersistentVolumeClaim does not exist",
+			"PersistentVolumeClaim", locality.PersistentVolumeClaim)
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get PersistentVolumeClaim %q: %w", locality.PersistentVolumeClaim, err)
+	}
+
+	if pvc.Spec.VolumeName == "" {
+		logger.Info("Ignoring storage locality for VM because PersistentVolumeClaim is not bound",
+			"PersistentVolumeClaim", pvc.Name)
+		return nil, nil
+	}
+
+	var pv corev1.PersistentVolume
+	err = c.Get(ctx, types.NamespacedName{Name: pvc.Spec.VolumeName}, &pv)
+	if apierrors.IsNotFound(err) {
+		logger.Info("Ignoring storage locality for VM because PersistentVolume does not exist",
+			"PersistentVolume", pvc.Spec.VolumeName)
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get PersistentVolume %q: %w", pvc.Spec.VolumeName, err)
+	}
+
+	values := volumeTopologyValues(&pv, config.StorageTopologyKey)
+	if len(values) == 0 {
+		logger.Info("Ignoring storage locality for VM because PersistentVolume has no known topology",
+			"PersistentVolume", pv.Name, "TopologyKey", config.StorageTopologyKey)
+		return nil, nil
+	}
+
+	return &api.StorageTopology{
+		Policy: locality.Policy,
+		Key:    config.StorageTopologyKey,
+		Values: values,
+	}, nil
+}
+
+// volumeTopologyValues returns the values of the topology key where the volume is available, from
+// the volume's required node affinity or, failing that, its labels.
+func volumeTopologyValues(pv *corev1.PersistentVolume, key string) []string {
+	var values []string
+	if pv.Spec.NodeAffinity != nil && pv.Spec.NodeAffinity.Required != nil {
+		for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+			for _, expr := range term.MatchExpressions {
+				if expr.Key == key && expr.Operator == corev1.NodeSelectorOpIn {
+					values = append(values, expr.Values...)
+				}
+			}
+		}
+	}
+	if len(values) == 0 {
+		if value, ok := pv.Labels[key]; ok && value != "" {
+			values = append(values, value)
+		}
+	}
+
+	slices.Sort(values)
+	return slices.Compact(values)
+}
+
+// applyStorageTopology adds a preferred node affinity term for the storage topology to the pod, and
+// records the topology in the pod's api.InternalAnnotationStorageTopology annotation.
+func applyStorageTopology(pod *corev1.Pod, topology *api.StorageTopology, weight int32) error {
+	topologyJSON, err := json.Marshal(topology)
+	if err != nil {
+		return fmt.Errorf("failed to marshal storage topology: %w", err)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[api.InternalAnnotationStorageTopology] = string(topologyJSON)
+
+	op := corev1.NodeSelectorOpIn
+	if topology.Policy == api.StorageLocalityAntiColocate {
+		op = corev1.NodeSelectorOpNotIn
+	}
+
+	// The pod's affinity may be shared with the VM's spec, so copy it before changing it.
+	affinity := pod.Spec.Affinity.DeepCopy()
+	if affinity == nil {
+		affinity = &corev1.Affinity{} //nolint:exhaustruct // only node affinity is needed
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{} //nolint:exhaustruct // only preferred terms are needed
+	}
+	affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+		affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		corev1.PreferredSchedulingTerm{
+			Weight: weight,
+			Preference: corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{
+					{
+						Key:      topology.Key,
+						Operator: op,
+						Values:   topology.Values,
+					},
+				},
+				MatchFields: nil,
+			},
+		},
+	)
+	pod.Spec.Affinity = affinity
+	return nil
+}
+
+// withStorageLocality resolves the VM's storage topology and applies it to the runner pod, if
+// storage locality is enabled and requested by the VM.
+func withStorageLocality(
+	ctx context.Context,
+	c client.Client,
+	vm *vmv1.VirtualMachine,
+	pod *corev1.Pod,
+	config *ReconcilerConfig,
+) error {
+	topology, err := resolveStorageTopology(ctx, c, vm, config)
+	if err != nil || topology == nil {
+		return err
+	}
+
+	weight := config.StorageLocalityWeight
+	if weight == 0 {
+		weight = DefaultStorageLocalityWeight
+	}
+	return applyStorageTopology(pod, topology, weight)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+const zoneKey = "topology.kubernetes.io/zone"
+
+func TestVolumeTopologyValues(t *testing.T) {
+	//nolint:exhaustruct // only the relevant fields are set
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{zoneKey: "zone-c"},
+		},
+	}
+	// Without node affinity, the labels are used.
+	assert.Equal(t, []string{"zone-c"}, volumeTopologyValues(pv, zoneKey))
+	assert.Empty(t, volumeTopologyValues(pv, "other-key"))
+
+	//nolint:exhaustruct // only the relevant fields are set
+	pv.Spec.NodeAffinity = &corev1.VolumeNodeAffinity{
+		Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: zoneKey, Operator: corev1.NodeSelectorOpIn, Values: []string{"zone-b", "zone-a"}},
+				}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: zoneKey, Operator: corev1.NodeSelectorOpIn, Values: []string{"zone-a"}},
+					{Key: "other-key", Operator: corev1.NodeSelectorOpExists},
+				}},
+			},
+		},
+	}
+	// With node affinity, it takes precedence over the labels.
+	assert.Equal(t, []string{"zone-a", "zone-b"}, volumeTopologyValues(pv, zoneKey))
+}
+
+func TestApplyStorageTopology(t *testing.T) {
+	cases := []struct {
+		policy api.StorageLocalityPolicy
+		op     corev1.NodeSelectorOperator
+	}{
+		{policy: api.StorageLocalityColocate, op: corev1.NodeSelectorOpIn},
+		{policy: api.StorageLocalityAntiColocate, op: corev1.NodeSelectorOpNotIn},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			//nolint:exhaustruct // only node affinity is needed
+			vmAffinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}
+			//nolint:exhaustruct // only the relevant fields are set
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Affinity: vmAffinity}}
+
+			topology := &api.StorageTopology{Policy: c.policy, Key: zoneKey, Values: []string{"zone-a"}}
+			require.NoError(t, applyStorageTopology(pod, topology, 30))
+
+			preferred := pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			require.Len(t, preferred, 1)
+			assert.Equal(t, int32(30), preferred[0].Weight)
+			assert.Equal(t, []corev1.NodeSelectorRequirement{
+				{Key: zoneKey, Operator: c.op, Values: []string{"zone-a"}},
+			}, preferred[0].Preference.MatchExpressions)
+
+			// The original affinity, shared with the VM, must not be changed.
+			assert.Empty(t, vmAffinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution)
+
+			annotated, err := api.ExtractStorageTopology(pod)
+			require.NoError(t, err)
+			assert.Equal(t, topology, annotated)
+		})
+	}
+}
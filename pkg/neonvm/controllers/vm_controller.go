@@ -26,22 +26,27 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"reflect"
 	"strconv"
 	"time"
 
 	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/go-logr/logr"
 	nadapiv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	"github.com/samber/lo"
 	"golang.org/x/crypto/ssh"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -54,6 +59,7 @@ import (
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/api/cuarith"
 	"github.com/neondatabase/autoscaling/pkg/neonvm/controllers/buildtag"
 	"github.com/neondatabase/autoscaling/pkg/neonvm/ipam"
 	"github.com/neondatabase/autoscaling/pkg/util/patch"
@@ -69,6 +75,11 @@ const (
 	typeAvailableVirtualMachine = "Available"
 	// typeDegradedVirtualMachine represents the status used when the custom resource is deleted and the finalizer operations are must to occur.
 	typeDegradedVirtualMachine = "Degraded"
+	// typeScalingFrozenVirtualMachine represents whether autoscaling is frozen for the VM, via .spec.scalingFrozen
+	typeScalingFrozenVirtualMachine = "ScalingFrozen"
+	// typeDiskFullVirtualMachine represents whether any of the VM's disks are nearly full, if
+	// ReconcilerConfig.DiskFullThreshold is set
+	typeDiskFullVirtualMachine = "DiskFull"
 )
 
 const (
@@ -98,9 +109,18 @@ type VMReconciler struct {
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods/resize,verbs=patch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumes,verbs=get;list;watch
 //+kubebuilder:rbac:groups=vm.neon.tech,resources=ippools,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=vm.neon.tech,resources=ippools/finalizers,verbs=update
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;delete
 //+kubebuilder:rbac:groups=k8s.cni.cncf.io,resources=network-attachment-definitions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
 //+kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -171,6 +191,13 @@ func (r *VMReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Re
 		return ctrl.Result{}, nil
 	}
 
+	if claimedBy, ok := vm.Labels[vmv1.WarmPoolClaimedByLabel]; ok {
+		// This VM was claimed from a warm pool, so its runner pod now belongs to (or is about to
+		// belong to) another VM. It'll be deleted once the claim is complete.
+		log.Info("Skipping reconcile of VirtualMachine claimed from warm pool", "ClaimedBy", claimedBy)
+		return ctrl.Result{}, nil
+	}
+
 	// examine for nil values that should be defaulted
 	// this part is done for values that we want eventually explicitly override in the kube-api storage
 	// to a default value.
@@ -185,8 +212,13 @@ func (r *VMReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Re
 
 		// examine cpuScalingMode and set it to the default value if it is not set
 		if vm.Spec.CpuScalingMode == nil {
-			log.Info("Setting default CPU scaling mode", "default", r.Config.DefaultCPUScalingMode)
-			vm.Spec.CpuScalingMode = lo.ToPtr(r.Config.DefaultCPUScalingMode)
+			defaultMode := r.Config.DefaultCPUScalingMode
+			// microvm has no CPU hotplug, so the only mode it supports is sysfs.
+			if vm.Spec.UsesMicroVM() {
+				defaultMode = vmv1.CpuScalingModeSysfs
+			}
+			log.Info("Setting default CPU scaling mode", "default", defaultMode)
+			vm.Spec.CpuScalingMode = lo.ToPtr(defaultMode)
 			changed = true
 		}
 
@@ -199,6 +231,18 @@ func (r *VMReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Re
 		}
 	}
 
+	// If the runner pod is about to be restarted, start the VM at its recent steady-state size
+	// (if the autoscaler-agent recorded one), rather than whatever size it was before the restart.
+	if vm.Status.Phase == vmv1.VmPending && vm.Status.PodName == "" && vm.HasRestarted() {
+		if setSteadyStateResources(log, &vm) {
+			if err := r.tryUpdateVM(ctx, &vm); err != nil {
+				log.Error(err, "Failed to set steady-state resources for VirtualMachine")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{Requeue: true}, nil
+		}
+	}
+
 	statusBefore := vm.Status.DeepCopy()
 	if err := r.doReconcile(ctx, &vm); err != nil {
 		r.Recorder.Eventf(&vm, corev1.EventTypeWarning, "Failed",
@@ -221,7 +265,7 @@ func (r *VMReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Re
 	// Only quickly requeue if we're scaling or migrating. Otherwise, we aren't expecting any
 	// changes from QEMU, and it's wasteful to repeatedly check.
 	requeueAfter := time.Second
-	if vm.Status.Phase == vmv1.VmPending || vm.Status.Phase == vmv1.VmRunning {
+	if vm.Status.Phase == vmv1.VmPending || vm.Status.Phase == vmv1.VmRunning || vm.Status.Phase == vmv1.VmHibernated {
 		requeueAfter = 15 * time.Second
 	}
 
@@ -238,6 +282,9 @@ func (r *VMReconciler) doFinalizerOperationsForVirtualMachine(ctx context.Contex
 
 	log := log.FromContext(ctx)
 
+	r.Metrics.vmScalingFrozen.DeleteLabelValues(vm.Namespace, vm.Name)
+	r.Metrics.runnerPodAge.DeleteLabelValues(vm.Namespace, vm.Name)
+
 	// The following implementation will raise an event
 	r.Recorder.Event(vm, "Warning", "Deleting",
 		fmt.Sprintf("Custom Resource %s is being deleted from the namespace %s",
@@ -327,6 +374,122 @@ func (r *VMReconciler) updateVMStatusMemory(
 	}
 }
 
+// setSteadyStateResources updates the VM's .spec.guest.{cpus,memorySlots}.use to match the
+// resources in its api.AnnotationSteadyStateResources annotation, clamped to the VM's bounds.
+//
+// Returns whether the spec was changed.
+func setSteadyStateResources(log logr.Logger, vm *vmv1.VirtualMachine) (changed bool) {
+	steadyState, err := api.ExtractSteadyStateResources(vm)
+	if err != nil {
+		log.Error(err, "Failed to extract steady-state resources; ignoring", "VirtualMachine", vm.Name)
+		return false
+	} else if steadyState == nil {
+		return false
+	}
+
+	cpus := &vm.Spec.Guest.CPUs
+	memSlots := &vm.Spec.Guest.MemorySlots
+
+	cpuUse := min(max(steadyState.VCPU, cpus.Min), cpus.Max)
+
+	slotSize := api.BytesFromResourceQuantity(vm.Spec.Guest.MemorySlotSize)
+	slots, _ := cuarith.SlotsFromBytes(steadyState.Mem, slotSize, math.MaxInt32)
+	memUse := min(max(int32(slots), memSlots.Min), memSlots.Max)
+
+	if cpuUse == cpus.Use && memUse == memSlots.Use {
+		return false
+	}
+
+	log.Info("Setting VM resources to steady-state value before restart",
+		"VirtualMachine", vm.Name,
+		"CPUs", cpuUse, "MemorySlots", memUse,
+		"previousCPUs", cpus.Use, "previousMemorySlots", memSlots.Use)
+	cpus.Use = cpuUse
+	memSlots.Use = memUse
+	return true
+}
+
+// checkComputeUnitCompatibility emits a warning event if the Compute Unit for the VM's family is
+// not a whole number of memory slots, because autoscaling would then be unable to keep the VM's
+// memory at exact multiples of the Compute Unit.
+func (r *VMReconciler) checkComputeUnitCompatibility(vm *vmv1.VirtualMachine) {
+	if r.Config.ComputeUnits == nil {
+		return
+	}
+
+	cu := r.Config.ComputeUnits.ForObject(vm)
+	slotSize := api.BytesFromResourceQuantity(vm.Spec.Guest.MemorySlotSize)
+	if slotSize != 0 && cu.Mem%slotSize != 0 {
+		r.Recorder.Eventf(vm, corev1.EventTypeWarning, "ComputeUnit",
+			"Compute Unit memory %s for family %q is not a multiple of memory slot size %s",
+			cu.Mem.ToResourceQuantity(), api.ComputeUnitFamily(vm), slotSize.ToResourceQuantity())
+	}
+}
+
+// observeStartupLatency records the time from the VM's creation until its runner container started
+// and until the guest became ready, labeled by the VM's size class and node group.
+//
+// The guest is ready when the runner pod first became Ready: its readiness probe requires
+// neonvm-daemon inside the guest to be up.
+func (r *VMReconciler) observeStartupLatency(
+	ctx context.Context,
+	vm *vmv1.VirtualMachine,
+	runner *corev1.Pod,
+) {
+	sizeClass := r.sizeClass(vm)
+	nodeGroup := r.nodeGroup(ctx, runner.Spec.NodeName)
+
+	for _, c := range runner.Status.ContainerStatuses {
+		if c.Name == runnerContainerName && c.State.Running != nil {
+			d := c.State.Running.StartedAt.Sub(vm.CreationTimestamp.Time)
+			r.Metrics.vmStartupLatency.WithLabelValues(StartupStagePodRunning, sizeClass, nodeGroup).Observe(d.Seconds())
+		}
+	}
+
+	for _, c := range runner.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+			d := c.LastTransitionTime.Sub(vm.CreationTimestamp.Time)
+			r.Metrics.vmStartupLatency.WithLabelValues(StartupStageGuestReady, sizeClass, nodeGroup).Observe(d.Seconds())
+		}
+	}
+}
+
+// sizeClass returns the VM's initial size as a number of Compute Units, for use as a metric label.
+//
+// If there's no Compute Unit config, sizeClass returns "unknown". If the VM's size is not an exact
+// number of Compute Units, it returns "other".
+func (r *VMReconciler) sizeClass(vm *vmv1.VirtualMachine) string {
+	if r.Config.ComputeUnits == nil {
+		return "unknown"
+	}
+
+	slotSize := api.BytesFromResourceQuantity(vm.Spec.Guest.MemorySlotSize)
+	size := api.Resources{
+		VCPU: vm.Spec.Guest.CPUs.Use,
+		Mem:  cuarith.BytesFromSlots(uint64(vm.Spec.Guest.MemorySlots.Use), slotSize),
+	}
+	cus, ok := size.DivResources(r.Config.ComputeUnits.ForObject(vm))
+	if !ok {
+		return "other"
+	}
+	return strconv.Itoa(int(cus))
+}
+
+// nodeGroup returns the value of the configured NodeGroupLabel on the node, or the empty string if
+// it's not configured or the node couldn't be fetched.
+func (r *VMReconciler) nodeGroup(ctx context.Context, nodeName string) string {
+	if r.Config.NodeGroupLabel == "" || nodeName == "" {
+		return ""
+	}
+
+	node := &corev1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to get Node for VM startup latency metrics", "Node", nodeName)
+		return ""
+	}
+	return node.Labels[r.Config.NodeGroupLabel]
+}
+
 func (r *VMReconciler) acquireOverlayIP(ctx context.Context, vm *vmv1.VirtualMachine) error {
 	if vm.Spec.ExtraNetwork == nil || !vm.Spec.ExtraNetwork.Enable || len(vm.Status.ExtraNetIP) != 0 {
 		// If the VM has extra network disabled or already has an IP, do nothing.
@@ -355,6 +518,26 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 		meta.SetStatusCondition(&vm.Status.Conditions, metav1.Condition{Type: typeAvailableVirtualMachine, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
 	}
 
+	r.updateScalingFrozenStatus(vm)
+	recordRequestedResources(vm)
+
+	// Pre-pulling images is best-effort, so failures shouldn't block the rest of reconciling.
+	if err := r.reconcilePrePull(ctx, vm); err != nil {
+		log.Error(err, "Failed to reconcile image pre-pull pods for VirtualMachine")
+	}
+
+	if r.Config.ManageRunnerPodDisruptionBudgets {
+		if err := r.reconcileDisruptionBudget(ctx, vm); err != nil {
+			log.Error(err, "Failed to reconcile PodDisruptionBudget for VirtualMachine")
+			return err
+		}
+	}
+
+	if err := r.reconcileNetworkPolicy(ctx, vm); err != nil {
+		log.Error(err, "Failed to reconcile NetworkPolicy for VirtualMachine")
+		return err
+	}
+
 	// NB: .Spec.EnableSSH guaranteed non-nil because the k8s API server sets the default for us.
 	enableSSH := *vm.Spec.EnableSSH
 
@@ -371,8 +554,10 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 	}
 
 	// check if the certificate needs renewal for this running VM.
+	var certSecret *corev1.Secret
 	if enableTLS {
-		certSecret, err := r.reconcileCertificateSecret(ctx, vm)
+		var err error
+		certSecret, err = r.reconcileCertificateSecret(ctx, vm)
 		if err != nil {
 			return err
 		}
@@ -385,6 +570,15 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 	switch vm.Status.Phase {
 
 	case "":
+		claimed, err := r.claimWarmPoolVM(ctx, vm)
+		if err != nil {
+			log.Error(err, "Failed to claim VirtualMachine from warm pool")
+			return err
+		} else if claimed {
+			// Already running, in the runner pod of the VM we claimed.
+			return nil
+		}
+
 		if err := r.acquireOverlayIP(ctx, vm); err != nil {
 			if errors.Is(err, ipam.ErrAgain) {
 				// We are being rate limited by IPAM, let's try again later.
@@ -403,6 +597,7 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 			if err := vm.Spec.Guest.ValidateMemorySize(); err != nil {
 				return fmt.Errorf("Failed to validate memory size for VM: %w", err)
 			}
+			r.checkComputeUnitCompatibility(vm)
 
 			// Update the .Status on API Server to avoid creating multiple pods for a single VM
 			// See https://github.com/neondatabase/autoscaling/issues/794 for the context
@@ -444,7 +639,7 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 			}
 
 			// Define a new pod
-			pod, err := r.podForVirtualMachine(vm, sshSecret)
+			pod, err := r.podForVirtualMachine(ctx, vm, sshSecret)
 			if err != nil {
 				log.Error(err, "Failed to define new Pod resource for VirtualMachine")
 				return err
@@ -482,6 +677,11 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 		case runnerRunning:
 			vm.Status.PodIP = vmRunner.Status.PodIP
 			vm.Status.Phase = vmv1.VmRunning
+			if vm.Status.HibernatedAt != nil {
+				r.Recorder.Event(vm, "Normal", "Resumed",
+					fmt.Sprintf("VirtualMachine %s resumed from hibernation at %s", vm.Name, vm.Status.HibernatedAt.Format(time.RFC3339)))
+				vm.Status.HibernatedAt = nil
+			}
 			meta.SetStatusCondition(&vm.Status.Conditions,
 				metav1.Condition{
 					Type:    typeAvailableVirtualMachine,
@@ -498,6 +698,7 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 					d := now.Sub(vm.CreationTimestamp.Time)
 					r.Metrics.vmCreationToVMRunningTime.Observe(d.Seconds())
 					log.Info("VM creation to VM running time", "duration(sec)", d.Seconds())
+					r.observeStartupLatency(ctx, vm, vmRunner)
 				}
 			}
 		case runnerSucceeded:
@@ -552,6 +753,20 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 		// runner pod found, check/update phase now
 		switch runnerStatus(vmRunner) {
 		case runnerRunning:
+			// Recycling is best-effort, so failures shouldn't block the rest of reconciling.
+			restarted, err := r.reconcileRunnerPodRecycling(ctx, vm, vmRunner)
+			if err != nil {
+				log.Error(err, "Failed to recycle old runner pod", "VirtualMachine", vm.Name)
+			} else if restarted {
+				return nil
+			}
+
+			// The guest keeps its current certificate until this succeeds, so we don't need to
+			// block the rest of reconciling on it.
+			if err := r.reconcileWarmPoolTLS(ctx, vm, vmRunner, certSecret); err != nil {
+				log.Error(err, "Failed to send TLS certificate to VM claimed from warm pool", "VirtualMachine", vm.Name)
+			}
+
 			// update status by IP of runner pod
 			vm.Status.PodIP = vmRunner.Status.PodIP
 			// update phase
@@ -571,7 +786,7 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 			}
 
 			// get cgroups CPU details from runner pod
-			cgroupUsage, err := getRunnerCPULimits(ctx, vm)
+			cgroupUsage, err := r.getRunnerCPULimits(ctx, vm)
 			if err != nil {
 				log.Error(err, "Failed to get CPU details from runner", "VirtualMachine", vm.Name)
 				return err
@@ -612,6 +827,19 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 			// update status by memory sizes used in the VM
 			r.updateVMStatusMemory(vm, memorySize)
 
+			// Granted memory is otherwise only changed while scaling, in doVirtioMemScaling.
+			var grantedMemory *resource.Quantity
+			if vm.Status.Resources == nil || vm.Status.Resources.Granted == nil {
+				grantedMemory = memorySize
+			}
+			recordGrantedResources(vm, vm.Status.CPUs, grantedMemory)
+			recordAppliedResources(vm, cgroupUsage.GuestVCPUs, memorySize)
+
+			// with the VM's status up-to-date, make sure the runner pod reflects its size
+			r.syncRunnerPodRequests(ctx, vm, vmRunner, *vm.Status.CPUs, *vm.Status.MemorySize)
+
+			r.updateDiskFullStatus(ctx, vm)
+
 			// check if need hotplug/unplug CPU or memory
 			// compare guest spec and count of plugged
 
@@ -633,6 +861,12 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 					"Memory in spec", memorySizeFromSpec)
 				vm.Status.Phase = vmv1.VmScaling
 			}
+
+			// Only start hibernating once any scaling has finished.
+			if vm.Status.Phase == vmv1.VmRunning && vm.Spec.HibernationRequested() && r.canHibernate(vm, vmRunner) {
+				log.Info("VM goes into hibernation")
+				vm.Status.Phase = vmv1.VmHibernating
+			}
 		case runnerSucceeded:
 			vm.Status.Phase = vmv1.VmSucceeded
 			meta.SetStatusCondition(&vm.Status.Conditions,
@@ -720,11 +954,26 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 			return err
 		}
 
+		// Grow the runner pod before the guest, so that the pod is never smaller than the VM. The
+		// pod is shrunk after downscaling, when the VM is Running again.
+		if vm.Status.CPUs != nil && vm.Status.MemorySize != nil {
+			cpu := max(vm.Spec.Guest.CPUs.Use, *vm.Status.CPUs)
+			mem := *resource.NewQuantity(
+				int64(vm.Spec.Guest.MemorySlots.Use)*vm.Spec.Guest.MemorySlotSize.Value(),
+				resource.BinarySI,
+			)
+			if vm.Status.MemorySize.Cmp(mem) > 0 {
+				mem = *vm.Status.MemorySize
+			}
+			r.syncRunnerPodRequests(ctx, vm, vmRunner, cpu, mem)
+		}
+
 		cpuScaled, err := r.handleCPUScaling(ctx, vm, vmRunner)
 		if err != nil {
 			log.Error(err, "failed to handle CPU scaling")
 			return err
 		}
+		recordGrantedResources(vm, vm.Status.CPUs, nil)
 		ramScaled := false
 
 		// do hotplug/unplug Memory
@@ -738,7 +987,26 @@ func (r *VMReconciler) doReconcile(ctx context.Context, vm *vmv1.VirtualMachine)
 			vm.Status.Phase = vmv1.VmRunning
 		}
 
+	case vmv1.VmHibernating:
+		if err := r.reconcileHibernating(ctx, vm); err != nil {
+			return err
+		}
+
+	case vmv1.VmHibernated:
+		if !vm.Spec.HibernationRequested() {
+			log.Info("Resuming VM from hibernation")
+			vm.Status.Phase = vmv1.VmPending
+		}
+
 	case vmv1.VmSucceeded, vmv1.VmFailed:
+		// If the VM failed while resuming from hibernation, we can't rely on the saved state, so
+		// the VM must be started from scratch.
+		if vm.Status.HibernatedAt != nil {
+			r.Recorder.Event(vm, "Warning", "ResumeFailed",
+				fmt.Sprintf("VirtualMachine %s failed to resume from hibernation, discarding saved state", vm.Name))
+			vm.Status.HibernatedAt = nil
+		}
+
 		// Always delete runner pod. Otherwise, we could end up with one container succeeded/failed
 		// but the other one still running (meaning that the pod still ends up Running).
 		vmRunner := &corev1.Pod{}
@@ -827,6 +1095,7 @@ func (r *VMReconciler) doVirtioMemScaling(vm *vmv1.VirtualMachine) (done bool, _
 		}
 		r.Recorder.Eventf(vm, "Normal", reason, "Set virtio-mem size for %v total memory", goalTotalSize)
 	}
+	recordGrantedResources(vm, nil, goalTotalSize)
 
 	// Maybe we're already using the amount we want?
 	// Update the status to reflect the current size - and if it matches goalTotalSize, ram
@@ -838,6 +1107,7 @@ func (r *VMReconciler) doVirtioMemScaling(vm *vmv1.VirtualMachine) (done bool, _
 
 	done = currentTotalSize.Value() == goalTotalSize.Value()
 	r.updateVMStatusMemory(vm, currentTotalSize)
+	recordAppliedResources(vm, nil, currentTotalSize)
 	return done, nil
 }
 
@@ -968,6 +1238,10 @@ func updatePodMetadataIfNecessary(ctx context.Context, c client.Client, vm *vmv1
 				"k8s.v1.cni.cncf.io/networks":        true,
 				"k8s.v1.cni.cncf.io/network-status":  true,
 				"k8s.v1.cni.cncf.io/networks-status": true,
+				// Set when the pod is created, from the VM's storage
+				api.InternalAnnotationStorageTopology: true,
+				// Set when the pod is claimed from a warm pool
+				warmPoolTLSVersionAnnotation: true,
 			},
 		},
 	}
@@ -1096,6 +1370,7 @@ func extractVirtualMachineOvercommitSettingsJSON(spec vmv1.VirtualMachineSpec) *
 
 // podForVirtualMachine returns a VirtualMachine Pod object
 func (r *VMReconciler) podForVirtualMachine(
+	ctx context.Context,
 	vm *vmv1.VirtualMachine,
 	sshSecret *corev1.Secret,
 ) (*corev1.Pod, error) {
@@ -1104,6 +1379,10 @@ func (r *VMReconciler) podForVirtualMachine(
 		return nil, err
 	}
 
+	if err := withStorageLocality(ctx, r.Client, vm, pod, r.Config); err != nil {
+		return nil, err
+	}
+
 	// Set the ownerRef for the Pod
 	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/owners-dependents/
 	if err := ctrl.SetControllerReference(vm, pod, r.Scheme); err != nil {
@@ -1208,6 +1487,31 @@ func (r *VMReconciler) certSecretForVirtualMachine(
 	return secret, nil
 }
 
+// updateScalingFrozenStatus sets the ScalingFrozen condition and metric to match .spec.scalingFrozen
+func (r *VMReconciler) updateScalingFrozenStatus(vm *vmv1.VirtualMachine) {
+	if vm.Spec.ScalingFrozen {
+		meta.SetStatusCondition(&vm.Status.Conditions, metav1.Condition{
+			Type:    typeScalingFrozenVirtualMachine,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Frozen",
+			Message: "Autoscaling is frozen by .spec.scalingFrozen",
+		})
+		r.Metrics.vmScalingFrozen.WithLabelValues(vm.Namespace, vm.Name).Set(1)
+	} else {
+		// Only set the condition to false if it was previously set, so that VMs that never had
+		// scaling frozen don't get an extra condition.
+		if meta.FindStatusCondition(vm.Status.Conditions, typeScalingFrozenVirtualMachine) != nil {
+			meta.SetStatusCondition(&vm.Status.Conditions, metav1.Condition{
+				Type:    typeScalingFrozenVirtualMachine,
+				Status:  metav1.ConditionFalse,
+				Reason:  "NotFrozen",
+				Message: "Autoscaling is not frozen",
+			})
+		}
+		r.Metrics.vmScalingFrozen.DeleteLabelValues(vm.Namespace, vm.Name)
+	}
+}
+
 // labelsForVirtualMachine returns the labels for selecting the resources
 // More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
 func labelsForVirtualMachine(vm *vmv1.VirtualMachine, runnerVersion *api.RunnerProtoVersion) map[string]string {
@@ -1243,6 +1547,9 @@ func annotationsForVirtualMachine(vm *vmv1.VirtualMachine) map[string]string {
 	if ann := extractVirtualMachineOvercommitSettingsJSON(vm.Spec); ann != nil {
 		a[vmv1.VirtualMachineOvercommitAnnotation] = *ann
 	}
+	if vm.Spec.ScalingFrozen {
+		a[vmv1.VirtualMachineScalingFrozenAnnotation] = "true"
+	}
 	return a
 }
 
@@ -1424,6 +1731,9 @@ func podSpec(
 							"-qemu-disk-cache-settings", config.QEMUDiskCacheSettings,
 							"-memhp-auto-movable-ratio", memhpAutoMovableRatio,
 						)
+						if _, ok := vm.Labels[vmv1.WarmPoolNameLabel]; ok {
+							cmd = append(cmd, "-warm-pool")
+						}
 						// put these last, so that the earlier args are easier to see (because these
 						// can get quite large)
 						cmd = append(
@@ -1584,6 +1894,18 @@ func podSpec(
 		pod.Spec.Containers[0].Args = append(pod.Spec.Containers[0].Args, fmt.Sprintf("-appendKernelCmdline=%s", *vm.Spec.Guest.AppendKernelCmdline))
 	}
 
+	if config.SyncRunnerPodRequests {
+		memUse := resource.NewQuantity(
+			int64(vm.Spec.Guest.MemorySlots.Use)*vm.Spec.Guest.MemorySlotSize.Value(),
+			resource.BinarySI,
+		)
+		pod.Spec.Containers[0].Resources.Requests = runnerPodRequests(vm.Spec.PodResources, vm.Spec.Guest.CPUs.Use, *memUse)
+	}
+
+	if key, ok := rootDiskCacheKey(config, vm); ok {
+		applyRootDiskCache(pod, config, key)
+	}
+
 	// Add any InitContainers that were specified by the spec
 	pod.Spec.InitContainers = append(pod.Spec.InitContainers, vm.Spec.ExtraInitContainers...)
 
@@ -1695,6 +2017,10 @@ func podSpec(
 		})
 	}
 
+	if vm.Spec.Hibernation != nil {
+		addHibernationState(pod, vm)
+	}
+
 	// use multus network to add extra network interface
 	if vm.Spec.ExtraNetwork != nil && vm.Spec.ExtraNetwork.Enable {
 		var nadNetwork string
@@ -1721,11 +2047,17 @@ func (r *VMReconciler) SetupWithManager(mgr ctrl.Manager) (ReconcilerWithMetrics
 		r.Config.FailurePendingPeriod,
 		r.Config.FailingRefreshInterval,
 	)
-	err := ctrl.NewControllerManagedBy(mgr).
-		For(&vmv1.VirtualMachine{}).
-		Owns(&certv1.CertificateRequest{}).
-		Owns(&corev1.Secret{}).
-		Owns(&corev1.Pod{}).
+	owned := builder.WithPredicates(reconciler.OwnsPredicate(vmv1.SchemeGroupVersion.WithKind("VirtualMachine").GroupKind()))
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&vmv1.VirtualMachine{}, builder.WithPredicates(reconciler.ForPredicate())).
+		Owns(&certv1.CertificateRequest{}, owned).
+		Owns(&corev1.Secret{}, owned).
+		Owns(&corev1.Pod{}, owned).
+		Owns(&networkingv1.NetworkPolicy{}, owned)
+	if r.Config.ManageRunnerPodDisruptionBudgets {
+		b = b.Owns(&policyv1.PodDisruptionBudget{}, owned)
+	}
+	err := b.
 		WithOptions(controller.Options{MaxConcurrentReconciles: r.Config.MaxConcurrentReconciles}).
 		Named(cntrlName).
 		Complete(reconciler)
@@ -42,7 +42,7 @@ func (r *VMReconciler) handleCPUScalingQMP(ctx context.Context, vm *vmv1.Virtual
 	specCPU := vm.Spec.Guest.CPUs.Use
 
 	// get cgroups CPU details from runner pod
-	cgroupUsage, err := getRunnerCPULimits(ctx, vm)
+	cgroupUsage, err := r.getRunnerCPULimits(ctx, vm)
 	if err != nil {
 		log.Error(err, "Failed to get CPU details from runner", "VirtualMachine", vm.Name)
 		return false, err
@@ -98,7 +98,7 @@ func (r *VMReconciler) handleCPUScalingSysfs(ctx context.Context, vm *vmv1.Virtu
 	log := log.FromContext(ctx)
 	specCPU := vm.Spec.Guest.CPUs.Use
 
-	cgroupUsage, err := getRunnerCPULimits(ctx, vm)
+	cgroupUsage, err := r.getRunnerCPULimits(ctx, vm)
 	if err != nil {
 		log.Error(err, "Failed to get CPU details from runner", "VirtualMachine", vm.Name)
 		return false, err
@@ -112,7 +112,7 @@ func (r *VMReconciler) handleCPUScalingSysfs(ctx context.Context, vm *vmv1.Virtu
 
 func (r *VMReconciler) handleCgroupCPUUpdate(ctx context.Context, vm *vmv1.VirtualMachine, cgroupUsage *api.VCPUCgroup) (bool, error) {
 	specCPU := vm.Spec.Guest.CPUs.Use
-	if err := setRunnerCPULimits(ctx, vm, specCPU); err != nil {
+	if err := r.setRunnerCPULimits(ctx, vm, specCPU); err != nil {
 		return false, err
 	}
 	reason := "ScaleDown"
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+const (
+	hibernationStateVolumeName = "hibernation-state"
+	// hibernationStateMountPath must match the directory the runner saves the VM's state in.
+	hibernationStateMountPath = "/vm/hibernation"
+)
+
+// addHibernationState mounts the VM's hibernation state volume into the runner pod, and tells the
+// runner to resume from it if the VM was hibernated.
+func addHibernationState(pod *corev1.Pod, vm *vmv1.VirtualMachine) {
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      hibernationStateVolumeName,
+		MountPath: hibernationStateMountPath,
+	})
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: hibernationStateVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: vm.Spec.Hibernation.StateVolumeClaimName,
+			},
+		},
+	})
+
+	if vm.Status.HibernatedAt != nil {
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "RESTORE_HIBERNATION_STATE", Value: "true"})
+	}
+}
+
+// canHibernate returns whether the VM can start hibernating, emitting an event if it can't.
+func (r *VMReconciler) canHibernate(vm *vmv1.VirtualMachine, runner *corev1.Pod) bool {
+	// The webhook prevents creating VMs with hibernation and the QMP CPU scaling mode, but the
+	// default may have changed since the VM was created.
+	if vm.Spec.CpuScalingMode == nil || *vm.Spec.CpuScalingMode != vmv1.CpuScalingModeSysfs {
+		r.Recorder.Event(vm, "Warning", "HibernationNotSupported",
+			"Hibernation is only supported with the sysfs CPU scaling mode")
+		return false
+	}
+
+	hasVolume := false
+	for _, v := range runner.Spec.Volumes {
+		if v.Name == hibernationStateVolumeName {
+			hasVolume = true
+			break
+		}
+	}
+	if !hasVolume {
+		r.Recorder.Event(vm, "Warning", "HibernationNotSupported",
+			fmt.Sprintf("Runner pod %s has no hibernation state volume", runner.Name))
+		return false
+	}
+
+	return true
+}
+
+// reconcileHibernating handles the VmHibernating phase: asking the runner to save the VM's state,
+// and then removing the runner pod once that's done.
+func (r *VMReconciler) reconcileHibernating(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	log := log.FromContext(ctx)
+
+	vmRunner := &corev1.Pod{}
+	err := r.Get(ctx, types.NamespacedName{Name: vm.Status.PodName, Namespace: vm.Namespace}, vmRunner)
+	if err != nil && apierrors.IsNotFound(err) {
+		if vm.Status.HibernatedAt != nil {
+			// State was saved, and the runner pod is gone. We're done.
+			log.Info("VM hibernated, runner pod removed")
+			// NB: Cleanup() leaves .Status.HibernatedAt, which we need to resume the VM.
+			vm.Cleanup()
+			vm.Status.Phase = vmv1.VmHibernated
+			return nil
+		}
+
+		r.Recorder.Event(vm, "Warning", "NotFound",
+			fmt.Sprintf("runner pod %s not found", vm.Status.PodName))
+		vm.Status.Phase = vmv1.VmFailed
+		return nil
+	} else if err != nil {
+		log.Error(err, "Failed to get runner Pod")
+		return err
+	}
+
+	if vm.Status.HibernatedAt != nil {
+		// Already saved the state; just need to wait for the pod to be removed.
+		if vmRunner.DeletionTimestamp == nil {
+			return r.deleteRunnerPodIfEnabled(ctx, vm, vmRunner)
+		}
+		return nil
+	}
+
+	switch runnerStatus(vmRunner) {
+	case runnerSucceeded, runnerFailed:
+		r.Recorder.Event(vm, "Warning", "HibernationFailed",
+			fmt.Sprintf("runner pod %s exited before VM state was saved", vmRunner.Name))
+		vm.Status.Phase = vmv1.VmFailed
+		return nil
+	case runnerRunning:
+		// continue below
+	}
+
+	status, err := r.getRunnerHibernationStatus(ctx, vm)
+	if err != nil {
+		log.Error(err, "Failed to get hibernation status from runner", "VirtualMachine", vm.Name)
+		return err
+	}
+
+	switch status.State {
+	case api.HibernationStateNotStarted:
+		if !vm.Spec.HibernationRequested() {
+			// Hibernation was cancelled before it started.
+			vm.Status.Phase = vmv1.VmRunning
+			return nil
+		}
+		if err := r.startRunnerHibernation(ctx, vm); err != nil {
+			log.Error(err, "Failed to start hibernating VM", "VirtualMachine", vm.Name)
+			return err
+		}
+		r.Recorder.Event(vm, "Normal", "Hibernating",
+			fmt.Sprintf("Saving state of VirtualMachine %s", vm.Name))
+	case api.HibernationStateInProgress:
+		// wait until it's done
+	case api.HibernationStateFailed:
+		// The runner resumes the VM if saving its state failed, so it's safe to go back to Running.
+		// If hibernation is still requested, we'll try again.
+		r.Recorder.Event(vm, "Warning", "HibernationFailed",
+			fmt.Sprintf("Failed to save state of VirtualMachine %s: %s", vm.Name, status.Error))
+		vm.Status.Phase = vmv1.VmRunning
+	case api.HibernationStateCompleted:
+		// Record that the state was saved before removing the runner pod, so that we know to
+		// resume from it even if something goes wrong in the meantime.
+		now := metav1.Now()
+		vm.Status.HibernatedAt = &now
+		r.Recorder.Event(vm, "Normal", "Hibernated",
+			fmt.Sprintf("Saved state of VirtualMachine %s", vm.Name))
+	default:
+		return fmt.Errorf("unknown hibernation state %q", status.State)
+	}
+
+	return nil
+}
+
+func (r *VMReconciler) startRunnerHibernation(ctx context.Context, vm *vmv1.VirtualMachine) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:%d/hibernate", vm.Status.PodIP, vm.Spec.RunnerPort)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.doRunnerRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 202 {
+		return fmt.Errorf("startRunnerHibernation: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (r *VMReconciler) getRunnerHibernationStatus(ctx context.Context, vm *vmv1.VirtualMachine) (*api.HibernationStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:%d/hibernate", vm.Status.PodIP, vm.Spec.RunnerPort)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.doRunnerRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("getRunnerHibernationStatus: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result api.HibernationStatus
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
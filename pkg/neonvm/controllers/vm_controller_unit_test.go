@@ -3,11 +3,14 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	certv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -20,11 +23,15 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
 )
 
 type mockRecorder struct {
@@ -97,8 +104,11 @@ func newTestParams(t *testing.T) *testParams {
 	scheme := runtime.NewScheme()
 	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachine{})
 	scheme.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Pod{})
+	scheme.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.PodList{})
 	scheme.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Secret{})
 	scheme.AddKnownTypes(certv1.SchemeGroupVersion, &certv1.CertificateRequest{})
+	scheme.AddKnownTypes(policyv1.SchemeGroupVersion, &policyv1.PodDisruptionBudget{})
+	scheme.AddKnownTypes(networkingv1.SchemeGroupVersion, &networkingv1.NetworkPolicy{})
 
 	params := &testParams{
 		t:   t,
@@ -118,16 +128,31 @@ func newTestParams(t *testing.T) *testParams {
 		Recorder: params.mockRecorder,
 		Scheme:   scheme,
 		Config: &ReconcilerConfig{
-			DisableRunnerCgroup:     false,
-			MaxConcurrentReconciles: 10,
-			SkipUpdateValidationFor: nil,
-			QEMUDiskCacheSettings:   "",
-			MemhpAutoMovableRatio:   "301",
-			FailurePendingPeriod:    time.Minute,
-			FailingRefreshInterval:  time.Minute,
-			AtMostOnePod:            false,
-			DefaultCPUScalingMode:   vmv1.CpuScalingModeQMP,
-			NADConfig:               nil,
+			DisableRunnerCgroup:              false,
+			MaxConcurrentReconciles:          10,
+			SkipUpdateValidationFor:          nil,
+			QEMUDiskCacheSettings:            "",
+			MemhpAutoMovableRatio:            "301",
+			FailurePendingPeriod:             time.Minute,
+			FailingRefreshInterval:           time.Minute,
+			AtMostOnePod:                     false,
+			DefaultCPUScalingMode:            vmv1.CpuScalingModeQMP,
+			NADConfig:                        nil,
+			ComputeUnits:                     nil,
+			NodeGroupLabel:                   "",
+			RootDiskCacheDir:                 "",
+			RootDiskCacheMaxSize:             resource.Quantity{},
+			SyncRunnerPodRequests:            false,
+			InPlacePodResize:                 InPlacePodResizeUnsupported,
+			ManageRunnerPodDisruptionBudgets: false,
+			DisruptableImportanceClasses:     nil,
+			MigrationTargetSchedulingTimeout: 0,
+			RunnerPodRecycling:               nil,
+			DiskFullThreshold:                0,
+			StorageTopologyKey:               "",
+			StorageLocalityWeight:            0,
+			NetworkPolicyControllerSelector:  nil,
+			NetworkPolicyAgentSelector:       nil,
 		},
 		Metrics: testReconcilerMetrics,
 		IPAM:    nil,
@@ -324,3 +349,270 @@ func TestNodeAffinity(t *testing.T) {
 		assert.Equal(t, "amd64", affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[1].MatchExpressions[1].Values[0])
 	})
 }
+
+func TestSetSteadyStateResources(t *testing.T) {
+	logger := zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stdout))
+
+	// No annotation: nothing changes
+	vm := defaultVm()
+	assert.False(t, setSteadyStateResources(logger, vm))
+	assert.Equal(t, vmv1.MilliCPU(1500), vm.Spec.Guest.CPUs.Use)
+	assert.Equal(t, int32(2), vm.Spec.Guest.MemorySlots.Use)
+
+	// Annotation within bounds
+	vm = defaultVm()
+	vm.Annotations = map[string]string{
+		api.AnnotationSteadyStateResources: `{"vCPUs":1.75,"mem":"4Gi"}`,
+	}
+	assert.True(t, setSteadyStateResources(logger, vm))
+	assert.Equal(t, vmv1.MilliCPU(1750), vm.Spec.Guest.CPUs.Use)
+	assert.Equal(t, int32(4), vm.Spec.Guest.MemorySlots.Use)
+	// Applying again should be a no-op
+	assert.False(t, setSteadyStateResources(logger, vm))
+
+	// Annotation out of bounds is clamped
+	vm = defaultVm()
+	vm.Annotations = map[string]string{
+		api.AnnotationSteadyStateResources: `{"vCPUs":4,"mem":"512Mi"}`,
+	}
+	assert.True(t, setSteadyStateResources(logger, vm))
+	assert.Equal(t, vmv1.MilliCPU(2000), vm.Spec.Guest.CPUs.Use)
+	assert.Equal(t, int32(1), vm.Spec.Guest.MemorySlots.Use)
+
+	// Invalid annotation is ignored
+	vm = defaultVm()
+	vm.Annotations = map[string]string{
+		api.AnnotationSteadyStateResources: `not json`,
+	}
+	assert.False(t, setSteadyStateResources(logger, vm))
+}
+
+func TestPrePull(t *testing.T) {
+	params := newTestParams(t)
+	origVM := defaultVm()
+	origVM.Annotations = map[string]string{
+		vmv1.VirtualMachinePrePullNodesAnnotation: "node-a, node-b",
+	}
+	origVM.Spec.Guest.RootDisk.Image = "rootdisk-img"
+	vm := params.initVM(origVM)
+
+	listPrePullPods := func() []corev1.Pod {
+		var pods corev1.PodList
+		err := params.client.List(params.ctx, &pods, client.MatchingLabels{
+			vmv1.VirtualMachinePrePullLabel: vm.Name,
+		})
+		require.NoError(t, err)
+		return pods.Items
+	}
+
+	created := testutil.ToFloat64(params.r.Metrics.prePullPodsCreated)
+	require.NoError(t, params.r.reconcilePrePull(params.ctx, vm))
+	pods := listPrePullPods()
+	assert.Equal(t, created+2, testutil.ToFloat64(params.r.Metrics.prePullPodsCreated))
+	assert.ElementsMatch(t, []string{"node-a", "node-b"}, lo.Map(pods, func(p corev1.Pod, _ int) string {
+		return p.Spec.NodeName
+	}))
+	for _, pod := range pods {
+		assert.NotContains(t, pod.Labels, vmv1.VirtualMachineNameLabel)
+		assert.Equal(t, []string{"vm-runner-img", "rootdisk-img", "kernel-img"}, lo.Map(
+			pod.Spec.Containers,
+			func(c corev1.Container, _ int) string { return c.Image },
+		))
+	}
+
+	// Reconciling again doesn't change anything
+	require.NoError(t, params.r.reconcilePrePull(params.ctx, vm))
+	assert.Len(t, listPrePullPods(), 2)
+	assert.Equal(t, created+2, testutil.ToFloat64(params.r.Metrics.prePullPodsCreated))
+
+	// Removing a node deletes its pod
+	vm.Annotations[vmv1.VirtualMachinePrePullNodesAnnotation] = "node-b"
+	require.NoError(t, params.r.reconcilePrePull(params.ctx, vm))
+	pods = listPrePullPods()
+	require.Len(t, pods, 1)
+	assert.Equal(t, "node-b", pods[0].Spec.NodeName)
+}
+
+func TestDisruptionBudget(t *testing.T) {
+	params := newTestParams(t)
+	params.r.Config.ManageRunnerPodDisruptionBudgets = true
+	params.r.Config.DisruptableImportanceClasses = map[string]struct{}{"replica": {}}
+
+	origVM := defaultVm()
+	origVM.Labels = map[string]string{
+		vmv1.VirtualMachineImportanceClassLabel: "primary",
+	}
+	vm := params.initVM(origVM)
+
+	getPDB := func() (*policyv1.PodDisruptionBudget, bool) {
+		var pdb policyv1.PodDisruptionBudget
+		err := params.client.Get(params.ctx, client.ObjectKeyFromObject(vm), &pdb)
+		if apierrors.IsNotFound(err) {
+			return nil, false
+		}
+		require.NoError(t, err)
+		return &pdb, true
+	}
+
+	// Protected classes get a PodDisruptionBudget selecting the runner pod
+	require.NoError(t, params.r.reconcileDisruptionBudget(params.ctx, vm))
+	pdb, ok := getPDB()
+	require.True(t, ok)
+	assert.Equal(t, 0, pdb.Spec.MaxUnavailable.IntValue())
+	assert.Equal(t, map[string]string{vmv1.VirtualMachineNameLabel: vm.Name}, pdb.Spec.Selector.MatchLabels)
+	assert.True(t, metav1.IsControlledBy(pdb, vm))
+
+	// Reconciling again doesn't change anything
+	require.NoError(t, params.r.reconcileDisruptionBudget(params.ctx, vm))
+	_, ok = getPDB()
+	assert.True(t, ok)
+
+	// Changing to a disruptable class removes it
+	vm.Labels[vmv1.VirtualMachineImportanceClassLabel] = "replica"
+	require.NoError(t, params.r.reconcileDisruptionBudget(params.ctx, vm))
+	_, ok = getPDB()
+	assert.False(t, ok)
+
+	// VMs without an importance class aren't protected
+	delete(vm.Labels, vmv1.VirtualMachineImportanceClassLabel)
+	assert.False(t, evictionProtected(params.r.Config, vm))
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	params := newTestParams(t)
+	controllerSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"control-plane": "controller"}}
+	agentSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"name": "autoscaler-agent"}}
+	params.r.Config.NetworkPolicyControllerSelector = controllerSelector
+	params.r.Config.NetworkPolicyAgentSelector = agentSelector
+
+	origVM := defaultVm()
+	origVM.Spec.NetworkPolicy = &vmv1.VirtualMachineNetworkPolicy{
+		Ingress: []networkingv1.NetworkPolicyIngressRule{{
+			From: []networkingv1.NetworkPolicyPeer{{
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "proxy"},
+				},
+			}},
+		}},
+		Egress:      nil,
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+	}
+	vm := params.initVM(origVM)
+
+	getPolicy := func() (*networkingv1.NetworkPolicy, bool) {
+		var policy networkingv1.NetworkPolicy
+		err := params.client.Get(params.ctx, client.ObjectKeyFromObject(vm), &policy)
+		if apierrors.IsNotFound(err) {
+			return nil, false
+		}
+		require.NoError(t, err)
+		return &policy, true
+	}
+
+	// The NetworkPolicy selects the runner pods, with the VM's rules plus access to the runner
+	require.NoError(t, params.r.reconcileNetworkPolicy(params.ctx, vm))
+	policy, ok := getPolicy()
+	require.True(t, ok)
+	assert.True(t, metav1.IsControlledBy(policy, vm))
+	assert.Equal(t, map[string]string{vmv1.VirtualMachineNameLabel: vm.Name}, policy.Spec.PodSelector.MatchLabels)
+	require.Len(t, policy.Spec.Ingress, 4)
+	assert.Equal(t, vm.Spec.NetworkPolicy.Ingress[0], policy.Spec.Ingress[0])
+	// Nothing is allowed from anywhere.
+	for _, rule := range policy.Spec.Ingress {
+		assert.NotEmpty(t, rule.From)
+	}
+	// Other runner pods can reach the migration port:
+	runnerPeer := policy.Spec.Ingress[1].From[0]
+	assert.Equal(t, vmv1.VirtualMachineNameLabel, runnerPeer.PodSelector.MatchExpressions[0].Key)
+	assert.Equal(t, metav1.LabelSelectorOpExists, runnerPeer.PodSelector.MatchExpressions[0].Operator)
+	require.Len(t, policy.Spec.Ingress[1].Ports, 1)
+	assert.Equal(t, vmv1.MigrationPort, policy.Spec.Ingress[1].Ports[0].Port.IntVal)
+	// neonvm-controller can reach QMP and the runner:
+	assert.Equal(t, controllerSelector, policy.Spec.Ingress[2].From[0].PodSelector)
+	assert.Len(t, policy.Spec.Ingress[2].Ports, 3)
+	// autoscaler-agent can reach any port:
+	assert.Equal(t, agentSelector, policy.Spec.Ingress[3].From[0].PodSelector)
+	assert.Nil(t, policy.Spec.Ingress[3].Ports)
+	// Egress isn't restricted, so it's left alone.
+	assert.Nil(t, policy.Spec.Egress)
+
+	// Changes to the rules are applied, and if egress is restricted, runner pods can still reach
+	// other runner pods' migration port.
+	vm.Spec.NetworkPolicy.PolicyTypes = append(vm.Spec.NetworkPolicy.PolicyTypes, networkingv1.PolicyTypeEgress)
+	require.NoError(t, params.r.reconcileNetworkPolicy(params.ctx, vm))
+	policy, ok = getPolicy()
+	require.True(t, ok)
+	assert.Equal(t, vm.Spec.NetworkPolicy.PolicyTypes, policy.Spec.PolicyTypes)
+	require.Len(t, policy.Spec.Egress, 1)
+	assert.Equal(t, runnerPeer, policy.Spec.Egress[0].To[0])
+	assert.Equal(t, vmv1.MigrationPort, policy.Spec.Egress[0].Ports[0].Port.IntVal)
+
+	// Removing the rules removes the NetworkPolicy
+	vm.Spec.NetworkPolicy = nil
+	require.NoError(t, params.r.reconcileNetworkPolicy(params.ctx, vm))
+	_, ok = getPolicy()
+	assert.False(t, ok)
+}
+
+func TestRootDiskCache(t *testing.T) {
+	params := newTestParams(t)
+	params.r.Config.RootDiskCacheDir = "/var/lib/neonvm/rootdisks"
+	params.r.Config.RootDiskCacheMaxSize = resource.MustParse("10Gi")
+
+	vm := defaultVm()
+	vm.Status.PodName = "test-vm-pod"
+	vm.Spec.CpuScalingMode = lo.ToPtr(vmv1.CpuScalingModeQMP)
+	vm.Spec.TargetArchitecture = lo.ToPtr(vmv1.CPUArchitectureAMD64)
+
+	// Images referenced by tag don't use the cache
+	vm.Spec.Guest.RootDisk.Image = "vm-postgres:16"
+	_, ok := rootDiskCacheKey(params.r.Config, vm)
+	assert.False(t, ok)
+
+	digest := strings.Repeat("ab", 32)
+	vm.Spec.Guest.RootDisk.Image = fmt.Sprintf("vm-postgres@sha256:%s", digest)
+	key, ok := rootDiskCacheKey(params.r.Config, vm)
+	require.True(t, ok)
+	assert.Equal(t, fmt.Sprintf("sha256-%s", digest), key)
+
+	pod, err := podSpec(vm, nil, params.r.Config)
+	require.NoError(t, err)
+
+	assert.Contains(t, pod.Spec.Containers[0].Args, fmt.Sprintf("-rootdisk-cache-key=%s", key))
+	assert.Contains(t, pod.Spec.Containers[0].Args, fmt.Sprintf("-rootdisk-cache-max-size=%d", 10<<30))
+	assert.Contains(t, pod.Spec.InitContainers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      rootDiskCacheVolumeName,
+		MountPath: rootDiskCacheMountPath,
+	})
+	assert.Contains(t, pod.Spec.InitContainers[0].Command[2], fmt.Sprintf("/vm/cache/%s/disk.qcow2", key))
+}
+
+func TestRunnerPodRequests(t *testing.T) {
+	base := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("4Gi"),
+		},
+	}
+
+	requests := runnerPodRequests(base, vmv1.MilliCPU(1500), resource.MustParse("2Gi"))
+	assert.True(t, requestsEqual(requests, corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse("1500m"),
+		corev1.ResourceMemory:           resource.MustParse("2Gi"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+	}))
+	// The base shouldn't be modified
+	assert.Len(t, base.Requests, 1)
+
+	// Requests are capped at the limits
+	requests = runnerPodRequests(base, vmv1.MilliCPU(1500), resource.MustParse("8Gi"))
+	assert.Equal(t, 0, requests.Memory().Cmp(resource.MustParse("4Gi")))
+
+	// Representation doesn't matter for equality
+	assert.True(t, requestsEqual(
+		corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+		corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1000m")},
+	))
+}
@@ -67,6 +67,10 @@ type QmpObject struct {
 	Type string `json:"type"`
 }
 
+type QmpMachineType struct {
+	Return string `json:"return"`
+}
+
 type QmpMigrationInfo struct {
 	Return MigrationInfo `json:"return"`
 }
@@ -339,6 +343,29 @@ func QmpGetMemorySize(ip string, port int32) (*resource.Quantity, error) {
 	return resource.NewQuantity(result.Return.BaseMemory+result.Return.PluggedMemory, resource.BinarySI), nil
 }
 
+// QmpGetMachineType returns the QOM type of the VM's machine (e.g. "pc-q35-8.2-machine"), which
+// must match between the source and target of a live migration.
+func QmpGetMachineType(ip string, port int32) (string, error) {
+	mon, err := QmpConnect(ip, port)
+	if err != nil {
+		return "", err
+	}
+	defer mon.Disconnect() //nolint:errcheck // nothing to do with error when deferred. TODO: log it?
+
+	qmpcmd := []byte(`{"execute": "qom-get", "arguments": {"path": "/machine", "property": "type"}}`)
+	raw, err := mon.Run(qmpcmd)
+	if err != nil {
+		return "", err
+	}
+
+	var result QmpMachineType
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return "", fmt.Errorf("error unmarshaling json: %w", err)
+	}
+
+	return result.Return, nil
+}
+
 func QmpStartMigration(virtualmachine *vmv1.VirtualMachine, virtualmachinemigration *vmv1.VirtualMachineMigration) error {
 	// QMP port
 	port := virtualmachine.Spec.QMP
@@ -0,0 +1,342 @@
+package controllers
+
+// Creating many VirtualMachines at once, as described by a VirtualMachineBatch.
+//
+// Creating hundreds of VMs individually overloads both IPAM (every IP allocation is a serialized
+// update to the IP pool) and the scheduler (every runner pod is pending at the same time). Instead,
+// the batch controller creates VMs in waves of at most .spec.maxPending at a time, reserving the
+// overlay IPs for each wave with a single IP pool update, and only starting the next VM once one
+// from the current wave is running.
+//
+// IPs reserved for VMs that end up not being created are released again: immediately, if creating
+// the VMs fails, and otherwise by the batch's finalizer -- e.g. if the controller restarted between
+// reserving the IPs and creating the VMs. Once a VM exists, its IP is released by the VM
+// controller when the VM is deleted.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/neonvm/ipam"
+)
+
+// defaultBatchMaxPending is used if a VirtualMachineBatch's .spec.maxPending is not set.
+const defaultBatchMaxPending = 20
+
+// virtualmachinebatchFinalizer is set on VirtualMachineBatches that reserve overlay IPs, so that
+// any IPs reserved for VMs that were never created can be released when the batch is deleted.
+const virtualmachinebatchFinalizer = "vm.neon.tech/batch-finalizer"
+
+// VirtualMachineBatchReconciler reconciles a VirtualMachineBatch object
+type VirtualMachineBatchReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Config   *ReconcilerConfig
+	IPAM     *ipam.IPAM
+
+	Metrics ReconcilerMetrics
+}
+
+//+kubebuilder:rbac:groups=vm.neon.tech,resources=virtualmachinebatches,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=vm.neon.tech,resources=virtualmachinebatches/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=vm.neon.tech,resources=virtualmachinebatches/finalizers,verbs=update
+
+func (r *VirtualMachineBatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	batch := new(vmv1.VirtualMachineBatch)
+	if err := r.Get(ctx, req.NamespacedName, batch); err != nil {
+		// ignore error and stop reconcile loop if object not found (already deleted?)
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Unable to fetch VirtualMachineBatch")
+		return ctrl.Result{}, err
+	}
+	if batch.DeletionTimestamp.IsZero() && r.usesIPAM(batch) && !controllerutil.ContainsFinalizer(batch, virtualmachinebatchFinalizer) {
+		log.Info("Adding Finalizer for VirtualMachineBatch")
+		controllerutil.AddFinalizer(batch, virtualmachinebatchFinalizer)
+		if err := r.Update(ctx, batch); err != nil {
+			log.Error(err, "Failed to add finalizer to VirtualMachineBatch")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	var vms vmv1.VirtualMachineList
+	if err := r.List(ctx, &vms, client.InNamespace(batch.Namespace)); err != nil {
+		log.Error(err, "Failed to list VirtualMachines")
+		return ctrl.Result{}, err
+	}
+	existing := make(map[string]*vmv1.VirtualMachine, len(vms.Items))
+	for i := range vms.Items {
+		existing[vms.Items[i].Name] = &vms.Items[i]
+	}
+
+	if !batch.DeletionTimestamp.IsZero() {
+		// The VMs we created are owned by the batch, so they'll be removed with it. We only need to
+		// release any IPs reserved for VMs that were never created.
+		if controllerutil.ContainsFinalizer(batch, virtualmachinebatchFinalizer) {
+			var unused []string
+			for _, name := range batch.Spec.Names {
+				if _, ok := existing[name]; !ok {
+					unused = append(unused, name)
+				}
+			}
+			if err := r.releaseIPs(ctx, batch, unused); err != nil {
+				return ctrl.Result{}, err
+			}
+
+			log.Info("Removing Finalizer for VirtualMachineBatch")
+			controllerutil.RemoveFinalizer(batch, virtualmachinebatchFinalizer)
+			if err := r.Update(ctx, batch); err != nil {
+				log.Error(err, "Failed to remove finalizer from VirtualMachineBatch")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	status, missing := batchStatus(batch, existing)
+
+	maxPending := int32(defaultBatchMaxPending)
+	if batch.Spec.MaxPending != nil {
+		maxPending = *batch.Spec.MaxPending
+	}
+	if room := int(maxPending - status.Pending); len(missing) > room {
+		missing = missing[:max(0, room)]
+	}
+
+	if len(missing) != 0 {
+		created, err := r.createVMs(ctx, batch, missing)
+		status.Created += created
+		status.Pending += created
+		if err != nil {
+			if errors.Is(err, ipam.ErrAgain) {
+				// We are being rate limited by IPAM, let's try again later.
+				return ctrl.Result{RequeueAfter: time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	if status != batch.Status {
+		if status.Phase != batch.Status.Phase && status.Phase == vmv1.VmBatchCompleted {
+			message := fmt.Sprintf("All %d VirtualMachines were created", len(batch.Spec.Names))
+			log.Info(message)
+			r.Recorder.Event(batch, "Normal", "Completed", message)
+		}
+		batch.Status = status
+		if err := r.Status().Update(ctx, batch); err != nil {
+			log.Error(err, "Failed to update VirtualMachineBatch status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// batchStatus returns the current status of the batch, given the VMs that exist in its namespace,
+// along with the names of the VMs that have not yet been created, in order.
+//
+// VMs with one of the batch's names that weren't created by the batch are counted as failed.
+func batchStatus(
+	batch *vmv1.VirtualMachineBatch,
+	existing map[string]*vmv1.VirtualMachine,
+) (_ vmv1.VirtualMachineBatchStatus, missing []string) {
+	var status vmv1.VirtualMachineBatchStatus
+
+	for _, name := range batch.Spec.Names {
+		vm, ok := existing[name]
+		switch {
+		case !ok:
+			missing = append(missing, name)
+			continue
+		case !metav1.IsControlledBy(vm, batch):
+			status.Failed += 1
+			continue
+		}
+
+		status.Created += 1
+		switch vm.Status.Phase {
+		case "", vmv1.VmPending:
+			status.Pending += 1
+		case vmv1.VmFailed:
+			status.Failed += 1
+		default:
+			status.Running += 1
+		}
+	}
+
+	if len(missing) == 0 && status.Pending == 0 {
+		status.Phase = vmv1.VmBatchCompleted
+	} else {
+		status.Phase = vmv1.VmBatchCreating
+	}
+
+	return status, missing
+}
+
+// usesIPAM returns whether the batch's VMs use the overlay network, with IPs reserved by the batch
+func (r *VirtualMachineBatchReconciler) usesIPAM(batch *vmv1.VirtualMachineBatch) bool {
+	extraNet := batch.Spec.Template.Spec.ExtraNetwork
+	return r.IPAM != nil && extraNet != nil && extraNet.Enable
+}
+
+// releaseIPs releases any overlay IPs reserved for the batch's VMs with the given names, which
+// must not exist.
+func (r *VirtualMachineBatchReconciler) releaseIPs(ctx context.Context, batch *vmv1.VirtualMachineBatch, names []string) error {
+	if !r.usesIPAM(batch) || len(names) == 0 {
+		return nil
+	}
+
+	log := log.FromContext(ctx)
+
+	vmNames := make([]types.NamespacedName, len(names))
+	for i, name := range names {
+		vmNames[i] = types.NamespacedName{Namespace: batch.Namespace, Name: name}
+	}
+	if err := r.IPAM.ReleaseIPs(ctx, vmNames); err != nil {
+		log.Error(err, "Failed to release overlay IPs for VirtualMachines that weren't created", "count", len(names))
+		return err
+	}
+	log.Info("Released overlay IPs for VirtualMachines that weren't created", "count", len(names))
+	return nil
+}
+
+// createVMs creates the VMs with the given names from the batch's template, returning the number
+// that were created.
+//
+// If the VMs use the overlay network, their IPs are reserved upfront with a single request to
+// IPAM, so that the VM controller doesn't need to allocate them individually. If creating the VMs
+// fails, the IPs reserved for the ones that weren't created are released.
+func (r *VirtualMachineBatchReconciler) createVMs(
+	ctx context.Context,
+	batch *vmv1.VirtualMachineBatch,
+	names []string,
+) (int32, error) {
+	log := log.FromContext(ctx)
+
+	var ips []string
+	var masks []string
+	if r.usesIPAM(batch) {
+		vmNames := make([]types.NamespacedName, len(names))
+		for i, name := range names {
+			vmNames[i] = types.NamespacedName{Namespace: batch.Namespace, Name: name}
+		}
+		acquired, err := r.IPAM.AcquireIPs(ctx, vmNames)
+		if err != nil {
+			if !errors.Is(err, ipam.ErrAgain) {
+				log.Error(err, "Failed to acquire overlay IPs for VirtualMachines", "count", len(names))
+			}
+			return 0, err
+		}
+		for _, ip := range acquired {
+			ips = append(ips, ip.IP.String())
+			masks = append(masks, fmt.Sprintf("%d.%d.%d.%d", ip.Mask[0], ip.Mask[1], ip.Mask[2], ip.Mask[3]))
+		}
+		log.Info("Acquired overlay IPs for VirtualMachines", "count", len(acquired))
+	}
+
+	var created int32
+	for i, name := range names {
+		vm, err := r.vmForBatch(batch, name)
+		if err != nil {
+			_ = r.releaseIPs(ctx, batch, names[i:])
+			return created, err
+		}
+
+		if err := r.Create(ctx, vm); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				// Will be picked up by the next reconcile.
+				continue
+			}
+			log.Error(err, "Failed to create VirtualMachine", "VirtualMachine", name)
+			r.Recorder.Eventf(batch, "Warning", "Failed", "Failed to create VirtualMachine %s: %s", name, err)
+			// If this fails, the batch's finalizer will release them eventually.
+			_ = r.releaseIPs(ctx, batch, names[i:])
+			return created, err
+		}
+		created += 1
+
+		if ips != nil {
+			// Hand over the IP we reserved, so that the VM controller doesn't have to. If the VM
+			// controller got there first, it will have acquired the same IP anyways.
+			vm.Status.ExtraNetIP = ips[i]
+			vm.Status.ExtraNetMask = masks[i]
+			if err := r.Status().Update(ctx, vm); err != nil && !apierrors.IsConflict(err) {
+				log.Error(err, "Failed to set overlay IP for VirtualMachine", "VirtualMachine", name)
+			}
+		}
+	}
+
+	log.Info("Created VirtualMachines for batch", "count", created)
+	return created, nil
+}
+
+// vmForBatch returns the VirtualMachine with the given name, created from the batch's template
+func (r *VirtualMachineBatchReconciler) vmForBatch(
+	batch *vmv1.VirtualMachineBatch,
+	name string,
+) (*vmv1.VirtualMachine, error) {
+	tmpl := batch.Spec.Template.DeepCopy()
+
+	labels := tmpl.Metadata.Labels
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[vmv1.VirtualMachineBatchNameLabel] = batch.Name
+
+	//nolint:exhaustruct // status is set by the VM controller
+	vm := &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   batch.Namespace,
+			Labels:      labels,
+			Annotations: tmpl.Metadata.Annotations,
+		},
+		Spec: tmpl.Spec,
+	}
+	if err := ctrl.SetControllerReference(batch, vm, r.Scheme); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+// Note that the VirtualMachines will be also watched, so that more are created as earlier ones
+// start running.
+func (r *VirtualMachineBatchReconciler) SetupWithManager(mgr ctrl.Manager) (ReconcilerWithMetrics, error) {
+	cntrlName := "virtualmachinebatch"
+	reconciler := WithMetrics(
+		withCatchPanic(r),
+		r.Metrics,
+		cntrlName,
+		r.Config.FailurePendingPeriod,
+		r.Config.FailingRefreshInterval,
+	)
+	err := ctrl.NewControllerManagedBy(mgr).
+		For(&vmv1.VirtualMachineBatch{}, builder.WithPredicates(reconciler.ForPredicate())).
+		Owns(&vmv1.VirtualMachine{}, builder.WithPredicates(reconciler.OwnsPredicate(vmv1.SchemeGroupVersion.WithKind("VirtualMachineBatch").GroupKind()))).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Config.MaxConcurrentReconciles}).
+		Named(cntrlName).
+		Complete(reconciler)
+	return reconciler, err
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	nadfake "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	nfake "github.com/neondatabase/autoscaling/neonvm/client/clientset/versioned/fake"
+	"github.com/neondatabase/autoscaling/pkg/neonvm/ipam"
+)
+
+func TestVirtualMachineBatchReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachine{}, &vmv1.VirtualMachineList{})
+	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachineBatch{})
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&vmv1.VirtualMachine{}).
+		WithStatusSubresource(&vmv1.VirtualMachineBatch{}).
+		Build()
+
+	//nolint:exhaustruct // only the fields used by the batch controller
+	r := &VirtualMachineBatchReconciler{
+		Client:   c,
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(10),
+		IPAM:     nil,
+	}
+
+	//nolint:exhaustruct // this is a test
+	batch := &vmv1.VirtualMachineBatch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "restore",
+			Namespace: "default",
+		},
+		Spec: vmv1.VirtualMachineBatchSpec{
+			Names: []string{"vm-a", "vm-b", "vm-c"},
+			Template: vmv1.VirtualMachineTemplate{
+				Metadata: vmv1.VirtualMachineTemplateMeta{
+					Labels:      map[string]string{"app": "restore"},
+					Annotations: nil,
+				},
+				Spec: defaultVm().Spec,
+			},
+			MaxPending: lo.ToPtr[int32](2),
+		},
+	}
+	require.NoError(t, c.Create(ctx, batch))
+
+	reconcileBatch := func() {
+		res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(batch)})
+		require.NoError(t, err)
+		require.Equal(t, reconcile.Result{}, res)
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(batch), batch))
+	}
+
+	// First wave: only maxPending VMs are created
+	reconcileBatch()
+	assert.Equal(t, vmv1.VirtualMachineBatchStatus{
+		Created: 2,
+		Pending: 2,
+		Running: 0,
+		Failed:  0,
+		Phase:   vmv1.VmBatchCreating,
+	}, batch.Status)
+
+	var vm vmv1.VirtualMachine
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "vm-a"}, &vm))
+	assert.Equal(t, "restore", vm.Labels[vmv1.VirtualMachineBatchNameLabel])
+	assert.Equal(t, "restore", vm.Labels["app"])
+	assert.True(t, metav1.IsControlledBy(&vm, batch))
+	err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "vm-c"}, &vm)
+	require.Error(t, err)
+
+	// No more VMs are created until one of them is running
+	reconcileBatch()
+	assert.Equal(t, int32(2), batch.Status.Created)
+
+	setPhase := func(name string, phase vmv1.VmPhase) {
+		var vm vmv1.VirtualMachine
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &vm))
+		vm.Status.Phase = phase
+		require.NoError(t, c.Status().Update(ctx, &vm))
+	}
+
+	setPhase("vm-a", vmv1.VmRunning)
+	reconcileBatch()
+	assert.Equal(t, vmv1.VirtualMachineBatchStatus{
+		Created: 3,
+		Pending: 2,
+		Running: 1,
+		Failed:  0,
+		Phase:   vmv1.VmBatchCreating,
+	}, batch.Status)
+
+	setPhase("vm-b", vmv1.VmFailed)
+	setPhase("vm-c", vmv1.VmRunning)
+	reconcileBatch()
+	assert.Equal(t, vmv1.VirtualMachineBatchStatus{
+		Created: 3,
+		Pending: 0,
+		Running: 2,
+		Failed:  1,
+		Phase:   vmv1.VmBatchCompleted,
+	}, batch.Status)
+}
+
+// IPs reserved for VMs that were never created are released when the batch is deleted
+func TestVirtualMachineBatchReleasesUnusedIPs(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachine{}, &vmv1.VirtualMachineList{})
+	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachineBatch{})
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&vmv1.VirtualMachine{}).
+		WithStatusSubresource(&vmv1.VirtualMachineBatch{}).
+		Build()
+
+	ipamClient := ipam.Client{
+		KubeClient: kfake.NewSimpleClientset(),
+		VMClient:   nfake.NewSimpleClientset(),
+		NADClient:  nadfake.NewSimpleClientset(),
+	}
+	//nolint:exhaustruct // this is a test
+	_, err := ipamClient.NADClient.K8sCniCncfIoV1().NetworkAttachmentDefinitions("default").Create(ctx, &nadv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "nad"},
+		Spec: nadv1.NetworkAttachmentDefinitionSpec{
+			Config: `{"ipam":{"ipRanges":[{"range":"10.100.123.0/24","range_start":"10.100.123.1","range_end":"10.100.123.254"}]}}`,
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	ipamInst, err := ipam.NewWithClient(&ipamClient, ipam.IPAMParams{
+		NadName:          "nad",
+		NadNamespace:     "default",
+		ConcurrencyLimit: 1,
+		MetricsReg:       prometheus.NewRegistry(),
+		QuarantinePeriod: 0,
+	})
+	require.NoError(t, err)
+	defer ipamInst.Close()
+
+	//nolint:exhaustruct // only the fields used by the batch controller
+	r := &VirtualMachineBatchReconciler{
+		Client:   c,
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(10),
+		IPAM:     ipamInst,
+	}
+
+	spec := defaultVm().Spec
+	spec.ExtraNetwork = &vmv1.ExtraNetwork{Enable: true, Interface: "net1", MultusNetwork: "default/nad"}
+	//nolint:exhaustruct // this is a test
+	batch := &vmv1.VirtualMachineBatch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "restore",
+			Namespace: "default",
+		},
+		Spec: vmv1.VirtualMachineBatchSpec{
+			Names: []string{"vm-a", "vm-b", "vm-c"},
+			Template: vmv1.VirtualMachineTemplate{
+				Metadata: vmv1.VirtualMachineTemplateMeta{Labels: nil, Annotations: nil},
+				Spec:     spec,
+			},
+			MaxPending: lo.ToPtr[int32](2),
+		},
+	}
+	require.NoError(t, c.Create(ctx, batch))
+
+	// An IP was reserved for vm-c, but it was never created (e.g. the controller restarted)
+	unusedIP, err := ipamInst.AcquireIP(ctx, types.NamespacedName{Namespace: "default", Name: "vm-c"})
+	require.NoError(t, err)
+
+	reconcileBatch := func() reconcile.Result {
+		res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(batch)})
+		require.NoError(t, err)
+		return res
+	}
+
+	// The finalizer is added first, then the first wave is created
+	assert.Equal(t, reconcile.Result{Requeue: true}, reconcileBatch())
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(batch), batch))
+	assert.Contains(t, batch.Finalizers, virtualmachinebatchFinalizer)
+	reconcileBatch()
+
+	var vm vmv1.VirtualMachine
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "vm-a"}, &vm))
+	assert.NotEmpty(t, vm.Status.ExtraNetIP)
+
+	// Deleting the batch releases the IP for vm-c, but not the ones for the VMs that exist
+	require.NoError(t, c.Delete(ctx, batch))
+	reconcileBatch()
+	err = c.Get(ctx, client.ObjectKeyFromObject(batch), batch)
+	assert.True(t, apierrors.IsNotFound(err))
+
+	ip, err := ipamInst.AcquireIP(ctx, types.NamespacedName{Namespace: "default", Name: "vm-d"})
+	require.NoError(t, err)
+	assert.Equal(t, unusedIP, ip)
+	ip, err = ipamInst.AcquireIP(ctx, types.NamespacedName{Namespace: "default", Name: "vm-a"})
+	require.NoError(t, err)
+	assert.Equal(t, vm.Status.ExtraNetIP, ip.IP.String())
+}
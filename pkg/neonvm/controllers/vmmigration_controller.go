@@ -21,9 +21,11 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sync"
 	"time"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -39,6 +41,7 @@ import (
 	"k8s.io/client-go/tools/record"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/neonvm/controllers/buildtag"
 )
 
@@ -59,7 +62,15 @@ type VirtualMachineMigrationReconciler struct {
 	Recorder record.EventRecorder
 	Config   *ReconcilerConfig
 
+	// APIReader reads directly from the API server, bypassing the informer cache. It's used where
+	// the cache may be stale enough to matter -- e.g. when checking other VMs in a disruption group.
+	APIReader client.Reader
+
 	Metrics ReconcilerMetrics
+
+	// disruptionGroupLocks maps "namespace/group" to a *sync.Mutex, held while checking whether
+	// the disruption group is busy and marking the VM as migrating.
+	disruptionGroupLocks sync.Map
 }
 
 func (r *VirtualMachineMigrationReconciler) createTargetPod(
@@ -92,7 +103,7 @@ func (r *VirtualMachineMigrationReconciler) createTargetPod(
 	}
 
 	// Define a new target pod
-	tpod, err := r.targetPodForVirtualMachine(vm, migration, sshSecret)
+	tpod, err := r.targetPodForVirtualMachine(ctx, vm, migration, sshSecret)
 	if err != nil {
 		logger.Error(err, "Failed to generate Target Pod spec")
 		return ctrl.Result{}, err
@@ -255,6 +266,42 @@ func (r *VirtualMachineMigrationReconciler) Reconcile(ctx context.Context, req c
 	}
 
 	if migration.Status.Phase == "" {
+		// microvm doesn't support live migration; fail early, before we touch the VM.
+		if vm.Spec.UsesMicroVM() {
+			message := fmt.Sprintf("VM (%s) uses machine type %q, which doesn't support live migration", vm.Name, vmv1.MachineTypeMicroVM)
+			log.Info(message)
+			r.Recorder.Event(migration, "Warning", "Failed", message)
+			meta.SetStatusCondition(&migration.Status.Conditions,
+				metav1.Condition{
+					Type:    typeDegradedVirtualMachineMigration,
+					Status:  metav1.ConditionTrue,
+					Reason:  "Reconciling",
+					Message: message,
+				})
+			migration.Status.Phase = vmv1.VmmFailed
+			return r.updateMigrationStatus(ctx, migration)
+		}
+
+		// Only one VM from each disruption group may be migrating at a time. If another VM in the
+		// group is already migrating, wait until it's done.
+		//
+		// The lock is held until the VM is marked as PreMigrating below, so that concurrent
+		// reconciles for VMs in the same group can't both see the group as free.
+		defer r.lockDisruptionGroup(vm)()
+		busyVM, err := r.disruptionGroupBusy(ctx, vm)
+		if err != nil {
+			log.Error(err, "Failed to check VM's disruption group")
+			return ctrl.Result{}, err
+		} else if busyVM != "" {
+			message := fmt.Sprintf(
+				"Waiting for VM %s in disruption group %q to finish migrating",
+				busyVM, api.DisruptionGroup(vm),
+			)
+			log.Info(message)
+			r.Recorder.Event(migration, "Normal", "DisruptionGroupBusy", message)
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+
 		// need change VM status asap to prevent autoscaler change CPU/RAM in VM
 		// but only if VM running
 		if vm.Status.Phase == vmv1.VmRunning {
@@ -327,6 +374,15 @@ func (r *VirtualMachineMigrationReconciler) Reconcile(ctx context.Context, req c
 			migration.Status.SourcePodIP = vm.Status.PodIP
 			migration.Status.TargetPodIP = targetRunner.Status.PodIP
 
+			// check that the target can actually receive the VM before we start copying it over
+			failure, err := checkMigrationTarget(vm, migration, targetRunner, time.Now())
+			if err != nil {
+				log.Error(err, "Failed to check migration target", "TargetPod.Name", targetRunner.Name)
+				return ctrl.Result{RequeueAfter: time.Second}, nil
+			} else if failure != nil {
+				return r.failMigrationPrecheck(ctx, migration, targetRunner, failure)
+			}
+
 			if *vm.Spec.CpuScalingMode == vmv1.CpuScalingModeQMP {
 				// do hotplugCPU in targetRunner before migration
 				log.Info("Syncing CPUs in Target runner", "TargetPod.Name", migration.Status.TargetPodName)
@@ -390,6 +446,11 @@ func (r *VirtualMachineMigrationReconciler) Reconcile(ctx context.Context, req c
 			migration.Status.Phase = vmv1.VmmFailed
 			return r.updateMigrationStatus(ctx, migration)
 		default:
+			// fail fast if the target can't be placed on any node
+			failure := targetUnschedulable(targetRunner, r.Config.MigrationTargetSchedulingTimeout, time.Now())
+			if failure != nil {
+				return r.failMigrationPrecheck(ctx, migration, targetRunner, failure)
+			}
 			// not sure what to do, so try rqueue
 			return ctrl.Result{RequeueAfter: time.Second}, nil
 		}
@@ -599,7 +660,7 @@ func (r *VirtualMachineMigrationReconciler) Reconcile(ctx context.Context, req c
 
 	case vmv1.VmmFailed:
 		// do additional VM status checks
-		if vm.Status.Phase == vmv1.VmMigrating {
+		if vm.Status.Phase == vmv1.VmMigrating || vm.Status.Phase == vmv1.VmPreMigrating {
 			// migration Failed and VM should back to Running state
 			vm.Status.Phase = vmv1.VmRunning
 			if err := r.Status().Update(ctx, vm); err != nil {
@@ -620,6 +681,52 @@ func (r *VirtualMachineMigrationReconciler) Reconcile(ctx context.Context, req c
 	return ctrl.Result{}, nil
 }
 
+// lockDisruptionGroup acquires the lock for the VM's disruption group, returning the function to
+// release it. If the VM is not in a disruption group, it does nothing.
+func (r *VirtualMachineMigrationReconciler) lockDisruptionGroup(vm *vmv1.VirtualMachine) (unlock func()) {
+	group := api.DisruptionGroup(vm)
+	if group == "" {
+		return func() {}
+	}
+
+	mu, _ := r.disruptionGroupLocks.LoadOrStore(fmt.Sprintf("%s/%s", vm.Namespace, group), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// disruptionGroupBusy returns the name of another VM in the same disruption group that is currently
+// migrating, or the empty string if there is none (or the VM is not in a disruption group).
+//
+// VMs are listed from the API server rather than the cache, so that a VM that was just marked as
+// PreMigrating is always seen.
+func (r *VirtualMachineMigrationReconciler) disruptionGroupBusy(ctx context.Context, vm *vmv1.VirtualMachine) (string, error) {
+	group := api.DisruptionGroup(vm)
+	if group == "" {
+		return "", nil
+	}
+
+	var vms vmv1.VirtualMachineList
+	if err := r.APIReader.List(
+		ctx,
+		&vms,
+		client.InNamespace(vm.Namespace),
+		client.MatchingLabels{api.LabelDisruptionGroup: group},
+	); err != nil {
+		return "", fmt.Errorf("could not list VMs in disruption group %q: %w", group, err)
+	}
+
+	for _, other := range vms.Items {
+		if other.Name == vm.Name {
+			continue
+		}
+		if other.Status.Phase == vmv1.VmPreMigrating || other.Status.Phase == vmv1.VmMigrating {
+			return other.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
 func (r *VirtualMachineMigrationReconciler) updateMigrationStatus(ctx context.Context, migration *vmv1.VirtualMachineMigration) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	if err := r.Status().Update(ctx, migration); err != nil {
@@ -696,8 +803,8 @@ func (r *VirtualMachineMigrationReconciler) SetupWithManager(mgr ctrl.Manager) (
 		r.Config.FailingRefreshInterval,
 	)
 	err := ctrl.NewControllerManagedBy(mgr).
-		For(&vmv1.VirtualMachineMigration{}).
-		Owns(&corev1.Pod{}).
+		For(&vmv1.VirtualMachineMigration{}, builder.WithPredicates(reconciler.ForPredicate())).
+		Owns(&corev1.Pod{}, builder.WithPredicates(reconciler.OwnsPredicate(vmv1.SchemeGroupVersion.WithKind("VirtualMachineMigration").GroupKind()))).
 		WithOptions(controller.Options{MaxConcurrentReconciles: r.Config.MaxConcurrentReconciles}).
 		Named(cntrlName).
 		Complete(reconciler)
@@ -706,6 +813,7 @@ func (r *VirtualMachineMigrationReconciler) SetupWithManager(mgr ctrl.Manager) (
 
 // targetPodForVirtualMachine returns a VirtualMachine Pod object
 func (r *VirtualMachineMigrationReconciler) targetPodForVirtualMachine(
+	ctx context.Context,
 	vm *vmv1.VirtualMachine,
 	migration *vmv1.VirtualMachineMigration,
 	sshSecret *corev1.Secret,
@@ -719,6 +827,10 @@ func (r *VirtualMachineMigrationReconciler) targetPodForVirtualMachine(
 		return nil, err
 	}
 
+	if err := withStorageLocality(ctx, r.Client, vm, pod, r.Config); err != nil {
+		return nil, err
+	}
+
 	// override pod name
 	pod.Name = migration.Status.TargetPodName
 
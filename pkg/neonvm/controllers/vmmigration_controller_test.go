@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,10 +17,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
 )
 
 type migrationTestParams struct {
@@ -38,7 +41,7 @@ func newMigrationTestParams(t *testing.T) *migrationTestParams {
 	ctx := log.IntoContext(context.Background(), logger)
 
 	scheme := runtime.NewScheme()
-	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachine{})
+	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachine{}, &vmv1.VirtualMachineList{})
 	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachineMigration{})
 	scheme.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Pod{})
 
@@ -60,18 +63,35 @@ func newMigrationTestParams(t *testing.T) *migrationTestParams {
 		Recorder: params.mockRecorder,
 		Scheme:   scheme,
 		Config: &ReconcilerConfig{
-			DisableRunnerCgroup:     false,
-			MaxConcurrentReconciles: 10,
-			SkipUpdateValidationFor: nil,
-			QEMUDiskCacheSettings:   "",
-			MemhpAutoMovableRatio:   "301",
-			FailurePendingPeriod:    time.Minute,
-			FailingRefreshInterval:  time.Minute,
-			AtMostOnePod:            false,
-			DefaultCPUScalingMode:   vmv1.CpuScalingModeQMP,
-			NADConfig:               nil,
+			DisableRunnerCgroup:              false,
+			MaxConcurrentReconciles:          10,
+			SkipUpdateValidationFor:          nil,
+			QEMUDiskCacheSettings:            "",
+			MemhpAutoMovableRatio:            "301",
+			FailurePendingPeriod:             time.Minute,
+			FailingRefreshInterval:           time.Minute,
+			AtMostOnePod:                     false,
+			DefaultCPUScalingMode:            vmv1.CpuScalingModeQMP,
+			NADConfig:                        nil,
+			ComputeUnits:                     nil,
+			NodeGroupLabel:                   "",
+			RootDiskCacheDir:                 "",
+			RootDiskCacheMaxSize:             resource.Quantity{},
+			SyncRunnerPodRequests:            false,
+			InPlacePodResize:                 InPlacePodResizeUnsupported,
+			ManageRunnerPodDisruptionBudgets: false,
+			DisruptableImportanceClasses:     nil,
+			MigrationTargetSchedulingTimeout: 0,
+			RunnerPodRecycling:               nil,
+			DiskFullThreshold:                0,
+			StorageTopologyKey:               "",
+			StorageLocalityWeight:            0,
+			NetworkPolicyControllerSelector:  nil,
+			NetworkPolicyAgentSelector:       nil,
 		},
-		Metrics: testReconcilerMetrics,
+		APIReader:            params.client,
+		Metrics:              testReconcilerMetrics,
+		disruptionGroupLocks: sync.Map{},
 	}
 
 	return params
@@ -220,6 +240,55 @@ func Test_VMM_twice_to_Pending(t *testing.T) {
 	// TODO: should it fail instead?
 }
 
+func Test_VMM_disruption_group_busy(t *testing.T) {
+	params := newMigrationTestParams(t)
+
+	vmA := defaultVm()
+	vmA.Name = "test-vm-a"
+	vmA.Labels = map[string]string{api.LabelDisruptionGroup: "group"}
+	vmA.Status.Phase = vmv1.VmRunning
+	vmA.Status.PodIP = "1.2.3.4"
+	params.createVM(vmA)
+
+	vmB := defaultVm()
+	vmB.Name = "test-vm-b"
+	vmB.Labels = map[string]string{api.LabelDisruptionGroup: "group"}
+	vmB.Status.Phase = vmv1.VmRunning
+	vmB.Status.PodIP = "1.2.3.5"
+	params.createVM(vmB)
+
+	newMigration := func(vm *vmv1.VirtualMachine) *vmv1.VirtualMachineMigration {
+		vmm := &vmv1.VirtualMachineMigration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      vm.Name + "-migration",
+				Namespace: vm.Namespace,
+			},
+			Spec: vmv1.VirtualMachineMigrationSpec{
+				VmName: vm.Name,
+			},
+		}
+		params.createMigration(vmm)
+		return vmm
+	}
+
+	vmmA := newMigration(vmA)
+	vmmB := newMigration(vmB)
+
+	params.migrationToPending(vmmA)
+
+	// vm-a is migrating, so vm-b's migration waits without touching the VM.
+	params.migrationPrePending(vmmB)
+	params.mockRecorder.On("Event", mock.Anything, "Normal", "DisruptionGroupBusy", mock.Anything)
+	res, err := params.r.Reconcile(params.ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(vmmB)})
+	require.NoError(t, err)
+	require.Equal(t, reconcile.Result{RequeueAfter: 5 * time.Second}, res)
+
+	params.refetchMigration(vmmB)
+	params.refetchVM(vmB)
+	require.Equal(t, vmv1.VmmPhase(""), vmmB.Status.Phase)
+	require.Equal(t, vmv1.VmRunning, vmB.Status.Phase)
+}
+
 func Test_VMM_to_Pending_then_removed(t *testing.T) {
 	params := newMigrationTestParams(t)
 	vm := defaultVm()
@@ -262,3 +331,74 @@ func Test_VMM_to_Pending_then_removed(t *testing.T) {
 	params.refetchVM(vm)
 	require.Equal(params.t, vm.Status.Phase, vmv1.VmRunning)
 }
+
+func Test_targetUnschedulable(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	podWithCondition := func(status corev1.ConditionStatus, reason string, since time.Duration) *corev1.Pod {
+		//nolint:exhaustruct // this is a test
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "target"},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{
+					Type:               corev1.PodScheduled,
+					Status:             status,
+					Reason:             reason,
+					Message:            "0/3 nodes are available: 3 Not enough resources for pod.",
+					LastTransitionTime: metav1.NewTime(now.Add(-since)),
+				}},
+			},
+		}
+	}
+
+	cases := []struct {
+		name    string
+		pod     *corev1.Pod
+		timeout time.Duration
+		fail    bool
+	}{
+		{
+			name:    "no-conditions",
+			pod:     &corev1.Pod{}, //nolint:exhaustruct // this is a test
+			timeout: time.Minute,
+			fail:    false,
+		},
+		{
+			name:    "scheduled",
+			pod:     podWithCondition(corev1.ConditionTrue, "", 5*time.Minute),
+			timeout: time.Minute,
+			fail:    false,
+		},
+		{
+			name:    "unschedulable-within-timeout",
+			pod:     podWithCondition(corev1.ConditionFalse, corev1.PodReasonUnschedulable, 30*time.Second),
+			timeout: time.Minute,
+			fail:    false,
+		},
+		{
+			name:    "unschedulable-past-timeout",
+			pod:     podWithCondition(corev1.ConditionFalse, corev1.PodReasonUnschedulable, 2*time.Minute),
+			timeout: time.Minute,
+			fail:    true,
+		},
+		{
+			name:    "timeout-disabled",
+			pod:     podWithCondition(corev1.ConditionFalse, corev1.PodReasonUnschedulable, time.Hour),
+			timeout: 0,
+			fail:    false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			failure := targetUnschedulable(c.pod, c.timeout, now)
+			if !c.fail {
+				require.Nil(t, failure)
+				return
+			}
+			require.NotNil(t, failure)
+			require.Equal(t, vmv1.MigrationFailureTargetUnschedulable, failure.reason)
+			require.Contains(t, failure.message, "Not enough resources for pod")
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package controllers
+
+// Checks made on the target runner before starting a live migration, so that migrations that can't
+// succeed fail fast with a typed reason, rather than partway through copying the VM's memory.
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/neonvm/controllers/buildtag"
+)
+
+// migrationTargetReachableTimeout is the amount of time after the target runner pod has started
+// that we'll keep retrying to connect to it before failing the migration.
+const migrationTargetReachableTimeout = time.Minute
+
+// migrationPrecheckFailure is the reason that a migration can't be started, and a human-readable
+// message about why.
+type migrationPrecheckFailure struct {
+	reason  vmv1.MigrationFailureReason
+	message string
+}
+
+// targetUnschedulable returns a failure if the target runner pod has been unschedulable for at
+// least timeout. If timeout is zero, it never fails.
+//
+// The scheduler plugin's reason for rejecting each node (e.g., not enough CPU or memory) is part of
+// the PodScheduled condition's message, so we include it in the failure.
+func targetUnschedulable(pod *corev1.Pod, timeout time.Duration, now time.Time) *migrationPrecheckFailure {
+	if timeout == 0 {
+		return nil
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type != corev1.PodScheduled {
+			continue
+		}
+		if cond.Status != corev1.ConditionFalse || cond.Reason != corev1.PodReasonUnschedulable {
+			return nil
+		}
+		if now.Sub(cond.LastTransitionTime.Time) < timeout {
+			return nil
+		}
+		return &migrationPrecheckFailure{
+			reason:  vmv1.MigrationFailureTargetUnschedulable,
+			message: fmt.Sprintf("Target Pod (%s) could not be scheduled: %s", pod.Name, cond.Message),
+		}
+	}
+
+	return nil
+}
+
+// checkMigrationTarget checks that the running target runner can receive the VM from the source.
+//
+// If the target can't be reached yet but might be soon, checkMigrationTarget returns an error so
+// that the caller retries.
+func checkMigrationTarget(
+	vm *vmv1.VirtualMachine,
+	migration *vmv1.VirtualMachineMigration,
+	targetRunner *corev1.Pod,
+	now time.Time,
+) (*migrationPrecheckFailure, error) {
+	targetType, err := QmpGetMachineType(migration.Status.TargetPodIP, vm.Spec.QMP)
+	if err != nil {
+		startedAt := targetRunner.CreationTimestamp.Time
+		if targetRunner.Status.StartTime != nil {
+			startedAt = targetRunner.Status.StartTime.Time
+		}
+		if now.Sub(startedAt) < migrationTargetReachableTimeout {
+			return nil, fmt.Errorf("could not get machine type of target runner: %w", err)
+		}
+		return &migrationPrecheckFailure{
+			reason:  vmv1.MigrationFailureTargetUnreachable,
+			message: fmt.Sprintf("Target Pod (%s) is unreachable: %s", targetRunner.Name, err),
+		}, nil
+	}
+
+	sourceType, err := QmpGetMachineType(QmpAddr(vm))
+	if err != nil {
+		return nil, fmt.Errorf("could not get machine type of source runner: %w", err)
+	}
+
+	if sourceType != targetType {
+		return &migrationPrecheckFailure{
+			reason: vmv1.MigrationFailureIncompatibleTarget,
+			message: fmt.Sprintf(
+				"Target Pod (%s) has machine type %q, which is incompatible with source machine type %q",
+				targetRunner.Name, targetType, sourceType,
+			),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// failMigrationPrecheck marks the migration as failed due to the pre-check failure, removing the
+// target runner pod if it's not needed.
+func (r *VirtualMachineMigrationReconciler) failMigrationPrecheck(
+	ctx context.Context,
+	migration *vmv1.VirtualMachineMigration,
+	targetRunner *corev1.Pod,
+	failure *migrationPrecheckFailure,
+) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	log.Info(failure.message, "reason", failure.reason)
+	r.Recorder.Event(migration, "Warning", string(failure.reason), failure.message)
+	meta.SetStatusCondition(&migration.Status.Conditions,
+		metav1.Condition{
+			Type:    typeDegradedVirtualMachineMigration,
+			Status:  metav1.ConditionTrue,
+			Reason:  string(failure.reason),
+			Message: failure.message,
+		})
+
+	if buildtag.NeverDeleteRunnerPods {
+		log.Info(fmt.Sprintf("Target runner pod deletion was skipped due to '%s' build tag", buildtag.TagnameNeverDeleteRunnerPods),
+			"Pod.Namespace", targetRunner.Namespace, "Pod.Name", targetRunner.Name)
+	} else if err := r.Delete(ctx, targetRunner); err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to delete target runner Pod")
+		return ctrl.Result{}, err
+	}
+
+	migration.Status.FailureReason = failure.reason
+	migration.Status.Phase = vmv1.VmmFailed
+	return r.updateMigrationStatus(ctx, migration)
+}
@@ -0,0 +1,429 @@
+package controllers
+
+// Claiming a VirtualMachine from a VirtualMachineWarmPool.
+//
+// A new VirtualMachine with the vmv1.WarmPoolClaimAnnotation can skip booting entirely, by taking
+// over the runner pod of one of the pool's VMs that's already running. Pool VMs are booted without
+// starting their workload (see neonvm-runner's warmpool.go), so the claimant only needs to match
+// the pool's "shape" (resources, images, disks that are created by the runner, etc.), and the parts
+// of its spec that give its identity are sent to the guest when it's claimed.
+//
+// The claim happens in a few steps, each of which can be safely retried:
+//
+//  1. The pool VM is labeled with the name of the claimant, so that no other VM can claim it;
+//  2. The runner pod (and its SSH secret and overlay IP) are transferred to the claimant;
+//  3. The claimant's command, args, env, ConfigMap and Secret disks, and TLS certificate are sent
+//     to the runner, which passes them to the guest and starts the workload;
+//  4. The pool VM's status is copied to the claimant, which is now running; and finally
+//  5. The pool VM is deleted.
+//
+// If any step fails, the next reconcile of the claimant will find the VM it labeled, and pick up
+// from where it left off.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/samber/lo"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// warmPoolTLSVersionAnnotation is set on the runner pod of a VM with TLS that was claimed from a
+// warm pool, with the resourceVersion of the TLS Secret that was last sent to the guest.
+const warmPoolTLSVersionAnnotation = "vm.neon.tech/warm-pool-tls-version"
+
+// claimWarmPoolVM attempts to start the VM by claiming a running VM from the warm pool given by
+// its vmv1.WarmPoolClaimAnnotation, returning true if it did.
+//
+// If the VM doesn't ask to claim from a pool, or there's no compatible VM available in the pool,
+// claimWarmPoolVM returns false so that the VM is booted as usual.
+func (r *VMReconciler) claimWarmPoolVM(ctx context.Context, vm *vmv1.VirtualMachine) (bool, error) {
+	log := log.FromContext(ctx)
+
+	poolName, ok := vm.Annotations[vmv1.WarmPoolClaimAnnotation]
+	if !ok {
+		return false, nil
+	}
+
+	var poolVMs vmv1.VirtualMachineList
+	if err := r.List(ctx, &poolVMs,
+		client.InNamespace(vm.Namespace),
+		client.MatchingLabels{vmv1.WarmPoolNameLabel: poolName},
+	); err != nil {
+		return false, fmt.Errorf("failed to list VirtualMachines in warm pool: %w", err)
+	}
+
+	// If we already claimed a VM but didn't finish taking it over, continue where we left off.
+	for i := range poolVMs.Items {
+		if poolVMs.Items[i].Labels[vmv1.WarmPoolClaimedByLabel] == vm.Name {
+			return true, r.takeOverWarmPoolVM(ctx, vm, &poolVMs.Items[i])
+		}
+	}
+
+	var incompatibility string
+	for i := range poolVMs.Items {
+		candidate := &poolVMs.Items[i]
+		if !warmPoolVMAvailable(candidate) {
+			continue
+		}
+		if reason := warmPoolIncompatibility(candidate, vm); reason != "" {
+			incompatibility = reason
+			continue
+		}
+
+		candidate.Labels[vmv1.WarmPoolClaimedByLabel] = vm.Name
+		if err := r.Update(ctx, candidate); err != nil {
+			if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+				// Something else changed the VM first (possibly another claim). Try the next one.
+				continue
+			}
+			return false, fmt.Errorf("failed to claim warm pool VirtualMachine: %w", err)
+		}
+		return true, r.takeOverWarmPoolVM(ctx, vm, candidate)
+	}
+
+	msg := fmt.Sprintf("No VirtualMachine available in warm pool %s, booting as usual", poolName)
+	if incompatibility != "" {
+		msg = fmt.Sprintf("VirtualMachine is incompatible with warm pool %s (%s), booting as usual",
+			poolName, incompatibility)
+	}
+	log.Info(msg)
+	r.Recorder.Event(vm, "Normal", "WarmPool", msg)
+	return false, nil
+}
+
+// warmPoolVMAvailable returns whether the VM from a warm pool can be claimed
+func warmPoolVMAvailable(vm *vmv1.VirtualMachine) bool {
+	_, claimed := vm.Labels[vmv1.WarmPoolClaimedByLabel]
+	return !claimed &&
+		vm.DeletionTimestamp.IsZero() &&
+		vm.Status.Phase == vmv1.VmRunning &&
+		vm.Status.PodName != ""
+}
+
+// warmPoolShapeFields are the fields that must be the same between a VM and the warm pool VM it
+// claims, because they're fixed for the lifetime of the runner pod or the guest.
+//
+// Everything else that's immutable is either sent to the guest when the VM is claimed (see
+// warmPoolRebind), or checked separately by warmPoolIncompatibility.
+var warmPoolShapeFields = []struct {
+	fieldName string
+	getter    func(*vmv1.VirtualMachine) any
+}{
+	{".spec.guest.cpus.min", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.CPUs.Min }},
+	{".spec.guest.cpus.max", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.CPUs.Max }},
+	{".spec.guest.memorySlots.min", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.MemorySlots.Min }},
+	{".spec.guest.memorySlots.max", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.MemorySlots.Max }},
+	{".spec.guest.memorySlotSize", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.MemorySlotSize.Value() }},
+	{".spec.guest.ports", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.Ports }},
+	{".spec.guest.rootDisk", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.RootDisk }},
+	{".spec.guest.settings", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.Settings }},
+	{".spec.guest.kernelImage", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.KernelImage }},
+	{".spec.guest.appendKernelCmdline", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.AppendKernelCmdline }},
+	{".spec.guest.memhpAutoMovableRatio", func(v *vmv1.VirtualMachine) any { return v.Spec.Guest.MemhpAutoMovableRatio }},
+	// ConfigMap and Secret disks are sent to the guest when it's claimed. Other disks are created
+	// by the runner, so must be the same.
+	{".spec.disks", func(v *vmv1.VirtualMachine) any {
+		return lo.Filter(v.Spec.Disks, func(d vmv1.Disk, _ int) bool { return !isRebindableDisk(d) })
+	}},
+	{".spec.podResources", func(v *vmv1.VirtualMachine) any { return v.Spec.PodResources }},
+	{".spec.enableAcceleration", func(v *vmv1.VirtualMachine) any { return v.Spec.EnableAcceleration }},
+	{".spec.enableSSH", func(v *vmv1.VirtualMachine) any { return v.Spec.EnableSSH }},
+	{".spec.initScript", func(v *vmv1.VirtualMachine) any { return v.Spec.InitScript }},
+	{".spec.enableNetworkMonitoring", func(v *vmv1.VirtualMachine) any { return v.Spec.EnableNetworkMonitoring }},
+	{".spec.hibernation.stateVolumeClaimName", func(v *vmv1.VirtualMachine) any {
+		if v.Spec.Hibernation == nil {
+			return ""
+		}
+		return v.Spec.Hibernation.StateVolumeClaimName
+	}},
+	// The overlay IP is transferred to the claimant, but the interface must be the same.
+	{".spec.extraNetwork", func(v *vmv1.VirtualMachine) any { return v.Spec.ExtraNetwork }},
+	{".spec.runnerImage", func(v *vmv1.VirtualMachine) any { return v.Spec.RunnerImage }},
+	{".spec.targetArchitecture", func(v *vmv1.VirtualMachine) any { return v.Spec.TargetArchitecture }},
+	{".spec.cpuScalingMode", func(v *vmv1.VirtualMachine) any { return v.Spec.CpuScalingMode }},
+	{".spec.qmp", func(v *vmv1.VirtualMachine) any { return v.Spec.QMP }},
+	{".spec.qmpManual", func(v *vmv1.VirtualMachine) any { return v.Spec.QMPManual }},
+	{".spec.runnerPort", func(v *vmv1.VirtualMachine) any { return v.Spec.RunnerPort }},
+	{".spec.serviceAccountName", func(v *vmv1.VirtualMachine) any { return v.Spec.ServiceAccountName }},
+	{".spec.extraInitContainers", func(v *vmv1.VirtualMachine) any { return v.Spec.ExtraInitContainers }},
+}
+
+// warmPoolIncompatibility returns why the VM from a warm pool can't be claimed by vm, or the empty
+// string if it can.
+func warmPoolIncompatibility(poolVM, vm *vmv1.VirtualMachine) string {
+	for _, info := range warmPoolShapeFields {
+		if !DeepEqual(info.getter(poolVM), info.getter(vm)) {
+			return fmt.Sprintf("%s differs", info.fieldName)
+		}
+	}
+
+	// Anything the pool VM has that's sent to the guest on claim would be left over from the pool
+	// VM, and the runner pod of the pool VM would keep syncing it.
+	if lo.SomeBy(poolVM.Spec.Disks, isRebindableDisk) {
+		return "pool VMs can't have ConfigMap or Secret disks"
+	}
+	if poolVM.Spec.TLS != nil {
+		return "pool VMs can't have .spec.tls"
+	}
+
+	// Watched disks are kept in sync by the runner, from the pod's volumes. The runner pod of the
+	// pool VM doesn't have the claimant's volumes.
+	if lo.SomeBy(vm.Spec.Disks, func(d vmv1.Disk) bool { return isRebindableDisk(d) && lo.FromPtr(d.Watch) }) {
+		return "disks with .watch are not supported"
+	}
+
+	return ""
+}
+
+// isRebindableDisk returns whether the disk's contents are sent to the guest when a VM is claimed
+// from a warm pool, rather than being set up by the runner when it starts.
+func isRebindableDisk(disk vmv1.Disk) bool {
+	return disk.ConfigMap != nil || disk.Secret != nil
+}
+
+// takeOverWarmPoolVM transfers the running VM claimed from a warm pool to vm, and removes the
+// claimed VM.
+//
+// On success, vm's status is updated to match the claimed VM, so that it's now running.
+func (r *VMReconciler) takeOverWarmPoolVM(ctx context.Context, vm, poolVM *vmv1.VirtualMachine) error {
+	log := log.FromContext(ctx)
+
+	runner := new(corev1.Pod)
+	err := r.Get(ctx, types.NamespacedName{Name: poolVM.Status.PodName, Namespace: poolVM.Namespace}, runner)
+	if err != nil {
+		return fmt.Errorf("failed to get runner Pod of claimed warm pool VirtualMachine: %w", err)
+	}
+	if !metav1.IsControlledBy(runner, vm) {
+		if err := r.transferController(runner, poolVM, vm); err != nil {
+			return err
+		}
+		// Update the labels now, so that the scheduler plugin stops counting the pod as part of
+		// the warm pool. The rest of the metadata is synced once the VM is running.
+		delete(runner.Labels, vmv1.WarmPoolNameLabel)
+		runner.Labels[vmv1.VirtualMachineNameLabel] = vm.Name
+		if vm.Spec.TLS != nil {
+			// The certificate is sent with the rest of the rebind below. Leaving the version
+			// empty means it's sent once more by the next reconcile; that's harmless.
+			if runner.Annotations == nil {
+				runner.Annotations = make(map[string]string)
+			}
+			runner.Annotations[warmPoolTLSVersionAnnotation] = ""
+		}
+		if err := r.Update(ctx, runner); err != nil {
+			return fmt.Errorf("failed to update runner Pod of claimed warm pool VirtualMachine: %w", err)
+		}
+	}
+
+	if poolVM.Status.SSHSecretName != "" {
+		secret := new(corev1.Secret)
+		err := r.Get(ctx, types.NamespacedName{Name: poolVM.Status.SSHSecretName, Namespace: poolVM.Namespace}, secret)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get SSH Secret of claimed warm pool VirtualMachine: %w", err)
+		} else if err == nil && !metav1.IsControlledBy(secret, vm) {
+			if err := r.transferController(secret, poolVM, vm); err != nil {
+				return err
+			}
+			if err := r.Update(ctx, secret); err != nil {
+				return fmt.Errorf("failed to update SSH Secret of claimed warm pool VirtualMachine: %w", err)
+			}
+		}
+	}
+
+	if len(poolVM.Status.ExtraNetIP) != 0 {
+		ip, err := r.IPAM.TransferIP(ctx,
+			types.NamespacedName{Name: poolVM.Name, Namespace: poolVM.Namespace},
+			types.NamespacedName{Name: vm.Name, Namespace: vm.Namespace},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to transfer overlay IP of claimed warm pool VirtualMachine: %w", err)
+		}
+		log.Info(fmt.Sprintf("Transferred IP %s for overlay network interface", ip.String()))
+	}
+
+	if vm.Status.PodName != poolVM.Status.PodName {
+		rebind, err := r.warmPoolRebind(ctx, vm)
+		if err != nil {
+			return err
+		}
+		if err := r.sendWarmPoolRebind(ctx, poolVM.Status.PodIP, vm.Spec.RunnerPort, rebind); err != nil {
+			return fmt.Errorf("failed to send VirtualMachine spec to runner of claimed warm pool VirtualMachine: %w", err)
+		}
+
+		status := poolVM.Status.DeepCopy()
+		status.Conditions = vm.Status.Conditions
+		status.RestartCount = vm.Status.RestartCount
+		status.TLSSecretName = vm.Status.TLSSecretName
+		vm.Status = *status
+
+		// Save the status now, so that we don't claim another VM if the rest of the reconcile
+		// fails.
+		if err := r.Status().Update(ctx, vm); err != nil {
+			return fmt.Errorf("Failed to update VirtualMachine status: %w", err)
+		}
+
+		msg := fmt.Sprintf("Claimed VirtualMachine %s from warm pool %s, Pod %s",
+			poolVM.Name, poolVM.Labels[vmv1.WarmPoolNameLabel], vm.Status.PodName)
+		log.Info(msg)
+		r.Recorder.Event(vm, "Normal", "WarmPool", msg)
+	}
+
+	if err := r.Delete(ctx, poolVM); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete claimed warm pool VirtualMachine: %w", err)
+	}
+
+	return nil
+}
+
+// transferController makes newOwner the controller of obj, in place of oldOwner
+func (r *VMReconciler) transferController(obj client.Object, oldOwner, newOwner *vmv1.VirtualMachine) error {
+	obj.SetOwnerReferences(lo.Filter(obj.GetOwnerReferences(), func(ref metav1.OwnerReference, _ int) bool {
+		return ref.UID != oldOwner.UID
+	}))
+	if err := ctrl.SetControllerReference(newOwner, obj, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set owner of %s to claiming VirtualMachine: %w", obj.GetName(), err)
+	}
+	return nil
+}
+
+// warmPoolRebind returns the parts of vm's spec that warm pool VMs are booted without, to send to
+// the runner of the pool VM that vm claims
+func (r *VMReconciler) warmPoolRebind(ctx context.Context, vm *vmv1.VirtualMachine) (*api.WarmPoolRebind, error) {
+	files := make(map[string]map[string][]byte)
+
+	for _, disk := range vm.Spec.Disks {
+		switch {
+		case disk.ConfigMap != nil:
+			configMap := new(corev1.ConfigMap)
+			err := r.Get(ctx, types.NamespacedName{Name: disk.ConfigMap.Name, Namespace: vm.Namespace}, configMap)
+			if apierrors.IsNotFound(err) && lo.FromPtr(disk.ConfigMap.Optional) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to get ConfigMap for disk %s: %w", disk.Name, err)
+			}
+			data := make(map[string][]byte)
+			for k, v := range configMap.Data {
+				data[k] = []byte(v)
+			}
+			maps.Copy(data, configMap.BinaryData)
+			files[disk.MountPath] = projectVolumeItems(data, disk.ConfigMap.Items)
+		case disk.Secret != nil:
+			secret := new(corev1.Secret)
+			err := r.Get(ctx, types.NamespacedName{Name: disk.Secret.SecretName, Namespace: vm.Namespace}, secret)
+			if apierrors.IsNotFound(err) && lo.FromPtr(disk.Secret.Optional) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to get Secret for disk %s: %w", disk.Name, err)
+			}
+			files[disk.MountPath] = projectVolumeItems(secret.Data, disk.Secret.Items)
+		}
+	}
+
+	if vm.Spec.TLS != nil {
+		secret := new(corev1.Secret)
+		err := r.Get(ctx, types.NamespacedName{Name: vm.Status.TLSSecretName, Namespace: vm.Namespace}, secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get TLS Secret: %w", err)
+		}
+		files[vm.Spec.TLS.MountPath] = secret.Data
+	}
+
+	return &api.WarmPoolRebind{
+		Command: vm.Spec.Guest.Command,
+		Args:    vm.Spec.Guest.Args,
+		Env:     vm.Spec.Guest.Env,
+		Files:   files,
+	}, nil
+}
+
+// projectVolumeItems returns the files that would be in a ConfigMap or Secret volume with the
+// given items, from the object's data
+func projectVolumeItems(data map[string][]byte, items []corev1.KeyToPath) map[string][]byte {
+	if len(items) == 0 {
+		return data
+	}
+
+	files := make(map[string][]byte)
+	for _, item := range items {
+		if contents, ok := data[item.Key]; ok {
+			files[item.Path] = contents
+		}
+	}
+	return files
+}
+
+func (r *VMReconciler) sendWarmPoolRebind(ctx context.Context, podIP string, port int32, rebind *api.WarmPoolRebind) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:%d/rebind", podIP, port)
+
+	data, err := json.Marshal(rebind)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.doRunnerRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("sendWarmPoolRebind: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// reconcileWarmPoolTLS sends the VM's TLS certificate to the guest if the VM was claimed from a
+// warm pool and the certificate has changed since it was last sent.
+//
+// The runner pod of a claimed VM doesn't mount the VM's TLS Secret, so it can't keep it in sync
+// like it does for other VMs.
+func (r *VMReconciler) reconcileWarmPoolTLS(
+	ctx context.Context,
+	vm *vmv1.VirtualMachine,
+	runner *corev1.Pod,
+	certSecret *corev1.Secret,
+) error {
+	version, ok := runner.Annotations[warmPoolTLSVersionAnnotation]
+	if !ok || certSecret == nil || version == certSecret.ResourceVersion {
+		return nil
+	}
+
+	rebind, err := r.warmPoolRebind(ctx, vm)
+	if err != nil {
+		return err
+	}
+	if err := r.sendWarmPoolRebind(ctx, vm.Status.PodIP, vm.Spec.RunnerPort, rebind); err != nil {
+		return fmt.Errorf("failed to send TLS certificate to runner: %w", err)
+	}
+
+	original := runner.DeepCopy()
+	runner.Annotations[warmPoolTLSVersionAnnotation] = certSecret.ResourceVersion
+	if err := r.Patch(ctx, runner, client.MergeFrom(original)); err != nil {
+		return fmt.Errorf("failed to update runner Pod annotation: %w", err)
+	}
+	return nil
+}
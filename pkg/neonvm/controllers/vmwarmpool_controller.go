@@ -0,0 +1,228 @@
+package controllers
+
+// Keeping a warm pool of booted VirtualMachines, as described by a VirtualMachineWarmPool.
+//
+// The pool controller only maintains the number of unclaimed VMs in the pool. Claiming a VM is
+// done by the VM controller, when reconciling the VM that's claiming it (see vmwarmpool_claim.go).
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+)
+
+// VirtualMachineWarmPoolReconciler reconciles a VirtualMachineWarmPool object
+type VirtualMachineWarmPoolReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Config   *ReconcilerConfig
+
+	Metrics ReconcilerMetrics
+}
+
+//+kubebuilder:rbac:groups=vm.neon.tech,resources=virtualmachinewarmpools,verbs=get;list;watch
+//+kubebuilder:rbac:groups=vm.neon.tech,resources=virtualmachinewarmpools/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=vm.neon.tech,resources=virtualmachinewarmpools/finalizers,verbs=update
+
+func (r *VirtualMachineWarmPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	pool := new(vmv1.VirtualMachineWarmPool)
+	if err := r.Get(ctx, req.NamespacedName, pool); err != nil {
+		// ignore error and stop reconcile loop if object not found (already deleted?)
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Unable to fetch VirtualMachineWarmPool")
+		return ctrl.Result{}, err
+	}
+	if !pool.DeletionTimestamp.IsZero() {
+		// Nothing to do: the unclaimed VMs are owned by the pool, so they'll be removed with it.
+		return ctrl.Result{}, nil
+	}
+
+	var vms vmv1.VirtualMachineList
+	if err := r.List(ctx, &vms,
+		client.InNamespace(pool.Namespace),
+		client.MatchingLabels{vmv1.WarmPoolNameLabel: pool.Name},
+	); err != nil {
+		log.Error(err, "Failed to list VirtualMachines")
+		return ctrl.Result{}, err
+	}
+
+	var status vmv1.VirtualMachineWarmPoolStatus
+	var unclaimed []*vmv1.VirtualMachine
+	for i := range vms.Items {
+		vm := &vms.Items[i]
+		if !vm.DeletionTimestamp.IsZero() || !metav1.IsControlledBy(vm, pool) {
+			continue
+		}
+
+		if claimedBy, ok := vm.Labels[vmv1.WarmPoolClaimedByLabel]; ok {
+			if err := r.cleanUpClaimedVM(ctx, vm, claimedBy); err != nil {
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+
+		switch vm.Status.Phase {
+		case vmv1.VmFailed, vmv1.VmSucceeded:
+			// Unclaimed VMs should never stop; replace it.
+			log.Info("Deleting stopped VirtualMachine from warm pool", "VirtualMachine", vm.Name, "Phase", vm.Status.Phase)
+			if err := r.Delete(ctx, vm); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "Failed to delete VirtualMachine", "VirtualMachine", vm.Name)
+				return ctrl.Result{}, err
+			}
+			continue
+		case vmv1.VmRunning:
+			status.Ready += 1
+		default:
+			status.Booting += 1
+		}
+		unclaimed = append(unclaimed, vm)
+	}
+
+	for i := int32(len(unclaimed)); i < pool.Spec.Replicas; i++ {
+		vm, err := r.vmForWarmPool(pool)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, vm); err != nil {
+			log.Error(err, "Failed to create VirtualMachine for warm pool")
+			r.Recorder.Eventf(pool, "Warning", "Failed", "Failed to create VirtualMachine: %s", err)
+			return ctrl.Result{}, err
+		}
+		log.Info("Created VirtualMachine for warm pool", "VirtualMachine", vm.Name)
+		status.Booting += 1
+	}
+
+	if excess := len(unclaimed) - int(pool.Spec.Replicas); excess > 0 {
+		// Remove VMs that are still booting first, so that the ones that are ready can be claimed.
+		slices.SortStableFunc(unclaimed, func(a, b *vmv1.VirtualMachine) int {
+			aReady, bReady := a.Status.Phase == vmv1.VmRunning, b.Status.Phase == vmv1.VmRunning
+			switch {
+			case aReady == bReady:
+				return 0
+			case bReady:
+				return -1
+			default:
+				return 1
+			}
+		})
+		for _, vm := range unclaimed[:excess] {
+			if err := r.Delete(ctx, vm); err != nil && !apierrors.IsNotFound(err) {
+				log.Error(err, "Failed to delete VirtualMachine", "VirtualMachine", vm.Name)
+				return ctrl.Result{}, err
+			}
+			log.Info("Deleted excess VirtualMachine from warm pool", "VirtualMachine", vm.Name)
+			if vm.Status.Phase == vmv1.VmRunning {
+				status.Ready -= 1
+			} else {
+				status.Booting -= 1
+			}
+		}
+	}
+
+	if status != pool.Status {
+		pool.Status = status
+		if err := r.Status().Update(ctx, pool); err != nil {
+			log.Error(err, "Failed to update VirtualMachineWarmPool status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// cleanUpClaimedVM deletes the VM claimed from the pool if the claim is complete, or if the VM that
+// claimed it no longer exists.
+//
+// Normally, the claimed VM is deleted by the VM controller once the claim is complete; this only
+// handles the cases where that didn't happen.
+func (r *VirtualMachineWarmPoolReconciler) cleanUpClaimedVM(
+	ctx context.Context,
+	vm *vmv1.VirtualMachine,
+	claimedBy string,
+) error {
+	log := log.FromContext(ctx)
+
+	var claimant vmv1.VirtualMachine
+	err := r.Get(ctx, types.NamespacedName{Namespace: vm.Namespace, Name: claimedBy}, &claimant)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get VirtualMachine that claimed from warm pool", "VirtualMachine", claimedBy)
+		return err
+	} else if err == nil && claimant.Status.Phase == "" {
+		// The claim is still in progress
+		return nil
+	}
+
+	log.Info("Deleting VirtualMachine claimed from warm pool", "VirtualMachine", vm.Name, "ClaimedBy", claimedBy)
+	if err := r.Delete(ctx, vm); err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to delete VirtualMachine", "VirtualMachine", vm.Name)
+		return err
+	}
+	return nil
+}
+
+// vmForWarmPool returns a new VirtualMachine for the pool, created from the pool's template
+func (r *VirtualMachineWarmPoolReconciler) vmForWarmPool(
+	pool *vmv1.VirtualMachineWarmPool,
+) (*vmv1.VirtualMachine, error) {
+	tmpl := pool.Spec.Template.DeepCopy()
+
+	labels := tmpl.Metadata.Labels
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[vmv1.WarmPoolNameLabel] = pool.Name
+
+	//nolint:exhaustruct // status is set by the VM controller
+	vm := &vmv1.VirtualMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s-", pool.Name),
+			Namespace:    pool.Namespace,
+			Labels:       labels,
+			Annotations:  tmpl.Metadata.Annotations,
+		},
+		Spec: tmpl.Spec,
+	}
+	if err := ctrl.SetControllerReference(pool, vm, r.Scheme); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+// Note that the VirtualMachines will be also watched, so that claimed VMs are replaced.
+func (r *VirtualMachineWarmPoolReconciler) SetupWithManager(mgr ctrl.Manager) (ReconcilerWithMetrics, error) {
+	cntrlName := "virtualmachinewarmpool"
+	reconciler := WithMetrics(
+		withCatchPanic(r),
+		r.Metrics,
+		cntrlName,
+		r.Config.FailurePendingPeriod,
+		r.Config.FailingRefreshInterval,
+	)
+	err := ctrl.NewControllerManagedBy(mgr).
+		For(&vmv1.VirtualMachineWarmPool{}, builder.WithPredicates(reconciler.ForPredicate())).
+		Owns(&vmv1.VirtualMachine{}, builder.WithPredicates(reconciler.OwnsPredicate(vmv1.SchemeGroupVersion.WithKind("VirtualMachineWarmPool").GroupKind()))).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Config.MaxConcurrentReconciles}).
+		Named(cntrlName).
+		Complete(reconciler)
+	return reconciler, err
+}
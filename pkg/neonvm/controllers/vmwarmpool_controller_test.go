@@ -0,0 +1,354 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func TestVirtualMachineWarmPoolReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachine{}, &vmv1.VirtualMachineList{})
+	scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachineWarmPool{})
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithStatusSubresource(&vmv1.VirtualMachine{}).
+		WithStatusSubresource(&vmv1.VirtualMachineWarmPool{}).
+		Build()
+
+	//nolint:exhaustruct // only the fields used by the warm pool controller
+	r := &VirtualMachineWarmPoolReconciler{
+		Client:   c,
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(10),
+	}
+
+	//nolint:exhaustruct // this is a test
+	pool := &vmv1.VirtualMachineWarmPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "small",
+			Namespace: "default",
+		},
+		Spec: vmv1.VirtualMachineWarmPoolSpec{
+			Replicas: 2,
+			Template: vmv1.VirtualMachineTemplate{
+				Metadata: vmv1.VirtualMachineTemplateMeta{
+					Labels:      map[string]string{"size": "small"},
+					Annotations: nil,
+				},
+				Spec: defaultVm().Spec,
+			},
+		},
+	}
+	require.NoError(t, c.Create(ctx, pool))
+
+	reconcilePool := func() []vmv1.VirtualMachine {
+		res, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pool)})
+		require.NoError(t, err)
+		require.Equal(t, reconcile.Result{}, res)
+		require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(pool), pool))
+
+		var vms vmv1.VirtualMachineList
+		require.NoError(t, c.List(ctx, &vms))
+		return vms.Items
+	}
+
+	vms := reconcilePool()
+	require.Len(t, vms, 2)
+	assert.Equal(t, vmv1.VirtualMachineWarmPoolStatus{Ready: 0, Booting: 2}, pool.Status)
+	for _, vm := range vms {
+		assert.Equal(t, "small", vm.Labels[vmv1.WarmPoolNameLabel])
+		assert.Equal(t, "small", vm.Labels["size"])
+		assert.True(t, metav1.IsControlledBy(&vm, pool))
+	}
+
+	vm := vms[0]
+	vm.Status.Phase = vmv1.VmRunning
+	require.NoError(t, c.Status().Update(ctx, &vm))
+	vms = reconcilePool()
+	require.Len(t, vms, 2)
+	assert.Equal(t, vmv1.VirtualMachineWarmPoolStatus{Ready: 1, Booting: 1}, pool.Status)
+
+	// Scaling down removes the VM that isn't ready yet
+	pool.Spec.Replicas = 1
+	require.NoError(t, c.Update(ctx, pool))
+	vms = reconcilePool()
+	require.Len(t, vms, 1)
+	assert.Equal(t, vm.Name, vms[0].Name)
+	assert.Equal(t, vmv1.VirtualMachineWarmPoolStatus{Ready: 1, Booting: 0}, pool.Status)
+
+	// Once claimed, the VM is replaced
+	vm = vms[0]
+	vm.Labels[vmv1.WarmPoolClaimedByLabel] = "claimant"
+	require.NoError(t, c.Update(ctx, &vm))
+	vms = reconcilePool()
+	require.Len(t, vms, 1)
+	assert.NotEqual(t, vm.Name, vms[0].Name)
+	assert.Equal(t, vmv1.VirtualMachineWarmPoolStatus{Ready: 0, Booting: 1}, pool.Status)
+}
+
+func TestClaimWarmPoolVM(t *testing.T) {
+	// setup returns the test params, the VM to claim with, the pool VM's runner pod, and the
+	// requests received by the pool VM's runner
+	setup := func(t *testing.T) (*testParams, *vmv1.VirtualMachine, *corev1.Pod, *[]api.WarmPoolRebind) {
+		params := newTestParams(t)
+		params.r.Scheme.AddKnownTypes(vmv1.SchemeGroupVersion, &vmv1.VirtualMachineList{})
+		params.r.Scheme.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.ConfigMap{})
+
+		var rebinds []api.WarmPoolRebind
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/rebind", r.URL.Path)
+			var rebind api.WarmPoolRebind
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&rebind))
+			rebinds = append(rebinds, rebind)
+		}))
+		t.Cleanup(server.Close)
+		serverURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		port, err := strconv.Atoi(serverURL.Port())
+		require.NoError(t, err)
+
+		poolVM := defaultVm()
+		poolVM.Name = "small-abcde"
+		poolVM.UID = types.UID("pool-vm-uid")
+		poolVM.Labels = map[string]string{vmv1.WarmPoolNameLabel: "small"}
+		poolVM.Spec.CpuScalingMode = lo.ToPtr(vmv1.CpuScalingModeQMP)
+		poolVM.Spec.TargetArchitecture = lo.ToPtr(vmv1.CPUArchitectureAMD64)
+		poolVM.Spec.RunnerPort = int32(port)
+		poolVM.Status.Phase = vmv1.VmRunning
+		poolVM.Status.PodName = "small-abcde-fghij"
+		poolVM.Status.PodIP = serverURL.Hostname()
+		poolVM.Status.Node = "node-1"
+		require.NoError(t, params.client.Create(params.ctx, poolVM))
+
+		//nolint:exhaustruct // this is a test
+		runner := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      poolVM.Status.PodName,
+				Namespace: poolVM.Namespace,
+				Labels: map[string]string{
+					vmv1.VirtualMachineNameLabel: poolVM.Name,
+					vmv1.WarmPoolNameLabel:       "small",
+				},
+			},
+		}
+		require.NoError(t, ctrl.SetControllerReference(poolVM, runner, params.r.Scheme))
+		require.NoError(t, params.client.Create(params.ctx, runner))
+
+		//nolint:exhaustruct // this is a test
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "compute-config", Namespace: poolVM.Namespace},
+			Data:       map[string]string{"config.json": "{}", "other": ""},
+		}
+		require.NoError(t, params.client.Create(params.ctx, configMap))
+
+		// The VM has its own identity, which isn't part of the pool VM's shape
+		vm := defaultVm()
+		vm.UID = types.UID("vm-uid")
+		vm.Annotations = map[string]string{vmv1.WarmPoolClaimAnnotation: "small"}
+		vm.Spec.CpuScalingMode = lo.ToPtr(vmv1.CpuScalingModeQMP)
+		vm.Spec.TargetArchitecture = lo.ToPtr(vmv1.CPUArchitectureAMD64)
+		vm.Spec.RunnerPort = int32(port)
+		vm.Spec.Guest.Args = []string{"--compute-id", "compute-1"}
+		vm.Spec.Guest.Env = []vmv1.EnvVar{{Name: "COMPUTE_ID", Value: "compute-1"}}
+		vm.Spec.Disks = append(vm.Spec.Disks, vmv1.Disk{
+			Name:      "config",
+			MountPath: "/var/config",
+			Watch:     nil,
+			ReadOnly:  nil,
+			DiskSource: vmv1.DiskSource{
+				EmptyDisk: nil,
+				//nolint:exhaustruct // this is a test
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: configMap.Name},
+					Items:                []corev1.KeyToPath{{Key: "config.json", Path: "config.json", Mode: nil}},
+				},
+				Secret: nil,
+				Tmpfs:  nil,
+			},
+		})
+		vm = params.initVM(vm)
+
+		return params, vm, runner, &rebinds
+	}
+
+	t.Run("claimed", func(t *testing.T) {
+		params, vm, runner, rebinds := setup(t)
+		params.mockRecorder.On("Event", mock.Anything, "Normal", "WarmPool", mock.Anything)
+
+		claimed, err := params.r.claimWarmPoolVM(params.ctx, vm)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+
+		// The VM's identity was sent to the runner
+		assert.Equal(t, []api.WarmPoolRebind{{
+			Command: nil,
+			Args:    vm.Spec.Guest.Args,
+			Env:     vm.Spec.Guest.Env,
+			Files: map[string]map[string][]byte{
+				"/var/config": {"config.json": []byte("{}")},
+			},
+		}}, *rebinds)
+
+		// The VM is now running, in the pool VM's runner pod
+		vm = params.getVM()
+		assert.Equal(t, vmv1.VmRunning, vm.Status.Phase)
+		assert.Equal(t, runner.Name, vm.Status.PodName)
+		assert.Equal(t, "127.0.0.1", vm.Status.PodIP)
+
+		require.NoError(t, params.client.Get(params.ctx, client.ObjectKeyFromObject(runner), runner))
+		assert.True(t, metav1.IsControlledBy(runner, vm))
+		assert.Len(t, runner.OwnerReferences, 1)
+		assert.Equal(t, vm.Name, runner.Labels[vmv1.VirtualMachineNameLabel])
+		assert.NotContains(t, runner.Labels, vmv1.WarmPoolNameLabel)
+
+		var poolVM vmv1.VirtualMachine
+		err = params.client.Get(params.ctx, client.ObjectKey{Namespace: vm.Namespace, Name: "small-abcde"}, &poolVM)
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("incompatible", func(t *testing.T) {
+		params, vm, runner, rebinds := setup(t)
+		params.mockRecorder.On("Event", mock.Anything, "Normal", "WarmPool", mock.Anything)
+
+		vm.Spec.Guest.CPUs.Max *= 2
+		claimed, err := params.r.claimWarmPoolVM(params.ctx, vm)
+		require.NoError(t, err)
+		assert.False(t, claimed)
+		params.mockRecorder.AssertCalled(t, "Event", mock.Anything, "Normal", "WarmPool",
+			"VirtualMachine is incompatible with warm pool small (.spec.guest.cpus.max differs), booting as usual")
+		assert.Empty(t, *rebinds)
+
+		var poolVM vmv1.VirtualMachine
+		require.NoError(t, params.client.Get(params.ctx, client.ObjectKey{Namespace: vm.Namespace, Name: "small-abcde"}, &poolVM))
+		assert.NotContains(t, poolVM.Labels, vmv1.WarmPoolClaimedByLabel)
+
+		require.NoError(t, params.client.Get(params.ctx, client.ObjectKeyFromObject(runner), runner))
+		assert.True(t, metav1.IsControlledBy(runner, &poolVM))
+	})
+}
+
+func TestWarmPoolIncompatibility(t *testing.T) {
+	configMapDisk := vmv1.Disk{
+		Name:      "config",
+		MountPath: "/var/config",
+		Watch:     nil,
+		ReadOnly:  nil,
+		DiskSource: vmv1.DiskSource{
+			EmptyDisk: nil,
+			//nolint:exhaustruct // this is a test
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "config"},
+			},
+			Secret: nil,
+			Tmpfs:  nil,
+		},
+	}
+
+	cases := []struct {
+		name     string
+		poolVM   func(*vmv1.VirtualMachine)
+		vm       func(*vmv1.VirtualMachine)
+		expected string
+	}{
+		{
+			name:     "same",
+			poolVM:   func(*vmv1.VirtualMachine) {},
+			vm:       func(*vmv1.VirtualMachine) {},
+			expected: "",
+		},
+		{
+			name:   "different identity",
+			poolVM: func(*vmv1.VirtualMachine) {},
+			vm: func(vm *vmv1.VirtualMachine) {
+				vm.Spec.Guest.Command = []string{"/bin/compute"}
+				vm.Spec.Guest.Env = []vmv1.EnvVar{{Name: "COMPUTE_ID", Value: "compute-1"}}
+				vm.Spec.Disks = append(vm.Spec.Disks, configMapDisk)
+				vm.Spec.TLS = &vmv1.TLSProvisioning{} //nolint:exhaustruct // this is a test
+			},
+			expected: "",
+		},
+		{
+			name:   "different shape",
+			poolVM: func(*vmv1.VirtualMachine) {},
+			vm: func(vm *vmv1.VirtualMachine) {
+				vm.Spec.Guest.MemorySlots.Max += 1
+			},
+			expected: ".spec.guest.memorySlots.max differs",
+		},
+		{
+			name:   "different runner disks",
+			poolVM: func(*vmv1.VirtualMachine) {},
+			vm: func(vm *vmv1.VirtualMachine) {
+				vm.Spec.Disks = append(vm.Spec.Disks, vmv1.Disk{
+					Name:      "tmp",
+					MountPath: "/tmp",
+					Watch:     nil,
+					ReadOnly:  nil,
+					DiskSource: vmv1.DiskSource{
+						EmptyDisk: nil,
+						ConfigMap: nil,
+						Secret:    nil,
+						Tmpfs:     &vmv1.TmpfsDiskSource{Size: resource.MustParse("1Gi")},
+					},
+				})
+			},
+			expected: ".spec.disks differs",
+		},
+		{
+			name: "pool VM with identity",
+			poolVM: func(vm *vmv1.VirtualMachine) {
+				vm.Spec.Disks = append(vm.Spec.Disks, configMapDisk)
+			},
+			vm:       func(*vmv1.VirtualMachine) {},
+			expected: "pool VMs can't have ConfigMap or Secret disks",
+		},
+		{
+			name:   "watched disk",
+			poolVM: func(*vmv1.VirtualMachine) {},
+			vm: func(vm *vmv1.VirtualMachine) {
+				disk := configMapDisk
+				disk.Watch = lo.ToPtr(true)
+				vm.Spec.Disks = append(vm.Spec.Disks, disk)
+			},
+			expected: "disks with .watch are not supported",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			poolVM := defaultVm()
+			c.poolVM(poolVM)
+			vm := defaultVm()
+			c.vm(vm)
+			assert.Equal(t, c.expected, warmPoolIncompatibility(poolVM, vm))
+		})
+	}
+}
@@ -2,7 +2,11 @@ package ipam
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"slices"
+	"strings"
+	"time"
 
 	whereaboutsallocate "github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
 	whereaboutslogging "github.com/k8snetworkplumbingwg/whereabouts/pkg/logging"
@@ -12,6 +16,14 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 )
 
+// Released IPs are kept in the pool for a quarantine period before they can be reused, so that
+// stale ARP entries pointing at the previous owner have a chance to expire.
+//
+// In the whereabouts reservation list, a quarantined IP is represented as a reservation whose ID is
+// the previous owner's ID prefixed by quarantinedIDPrefix, with the time it was released in PodRef.
+// Because it's still reserved, whereabouts won't hand it out again.
+const quarantinedIDPrefix = "quarantined/"
+
 type ipamAction = func(
 	ipRange RangeConfiguration,
 	reservation []whereaboutstypes.IPReservation,
@@ -24,10 +36,63 @@ func makeAcquireAction(ctx context.Context, vmName types.NamespacedName) ipamAct
 	}
 }
 
+// makeAcquireBatchAction creates a callback which changes IPPool state to include new IP
+// reservations for all of the VMs, storing the acquired IPs in ips, in the same order as vmNames.
+func makeAcquireBatchAction(ctx context.Context, vmNames []types.NamespacedName, ips *[]net.IPNet) ipamAction {
+	return func(ipRange RangeConfiguration, reservation []whereaboutstypes.IPReservation) (net.IPNet, []whereaboutstypes.IPReservation, error) {
+		// The action may be retried, so start from scratch each time.
+		*ips = make([]net.IPNet, 0, len(vmNames))
+		for _, vmName := range vmNames {
+			ip, newReservation, err := doAcquire(ctx, ipRange, reservation, vmName)
+			if err != nil {
+				return net.IPNet{}, nil, err
+			}
+			*ips = append(*ips, ip)
+			reservation = newReservation
+		}
+		return net.IPNet{}, reservation, nil
+	}
+}
+
 // makeReleaseAction creates a callback which changes IPPool state to deallocate an IP reservation.
-func makeReleaseAction(ctx context.Context, vmName types.NamespacedName) ipamAction {
+//
+// If quarantine is true, the IP is kept in the pool as quarantined, rather than being removed.
+func makeReleaseAction(ctx context.Context, vmName types.NamespacedName, quarantine bool) ipamAction {
 	return func(ipRange RangeConfiguration, reservation []whereaboutstypes.IPReservation) (net.IPNet, []whereaboutstypes.IPReservation, error) {
-		return doRelease(ctx, ipRange, reservation, vmName)
+		ip, newReservation, err := doRelease(ctx, ipRange, reservation, vmName)
+		if err != nil || !quarantine || ip.IP == nil {
+			return ip, newReservation, err
+		}
+		newReservation = append(newReservation, quarantinedReservation(ip.IP, vmName.String(), time.Now()))
+		return ip, newReservation, nil
+	}
+}
+
+// makeReleaseBatchAction creates a callback which changes IPPool state to deallocate the IP
+// reservations for all of the VMs. VMs without a reservation are ignored.
+//
+// If quarantine is true, the IPs are kept in the pool as quarantined, rather than being removed.
+func makeReleaseBatchAction(ctx context.Context, vmNames []types.NamespacedName, quarantine bool) ipamAction {
+	return func(ipRange RangeConfiguration, reservation []whereaboutstypes.IPReservation) (net.IPNet, []whereaboutstypes.IPReservation, error) {
+		for _, vmName := range vmNames {
+			var err error
+			_, reservation, err = makeReleaseAction(ctx, vmName, quarantine)(ipRange, reservation)
+			if err != nil {
+				return net.IPNet{}, nil, err
+			}
+		}
+		return net.IPNet{}, reservation, nil
+	}
+}
+
+// makeTransferAction creates a callback which changes IPPool state so that the IP reserved for one
+// VM is reserved for another instead.
+//
+// If the new owner already has an IP, that IP is returned without changes, so that the transfer
+// can be retried.
+func makeTransferAction(ctx context.Context, from, to types.NamespacedName) ipamAction {
+	return func(ipRange RangeConfiguration, reservation []whereaboutstypes.IPReservation) (net.IPNet, []whereaboutstypes.IPReservation, error) {
+		return doTransfer(ctx, ipRange, reservation, from, to)
 	}
 }
 
@@ -86,6 +151,74 @@ func doRelease(
 	return net.IPNet{IP: ip, Mask: ipnet.Mask}, newReservation, nil
 }
 
+func doTransfer(
+	_ context.Context,
+	ipRange RangeConfiguration,
+	reservation []whereaboutstypes.IPReservation,
+	from types.NamespacedName,
+	to types.NamespacedName,
+) (net.IPNet, []whereaboutstypes.IPReservation, error) {
+	_, ipnet, _ := net.ParseCIDR(ipRange.Range)
+
+	// check if the transfer already happened
+	if idx := getMatchingIPReservationIndex(reservation, to.String()); idx >= 0 {
+		return net.IPNet{IP: reservation[idx].IP, Mask: ipnet.Mask}, reservation, nil
+	}
+
+	idx := getMatchingIPReservationIndex(reservation, from.String())
+	if idx < 0 {
+		return net.IPNet{}, nil, fmt.Errorf("no IP reserved for %v", from)
+	}
+
+	newReservation := slices.Clone(reservation)
+	newReservation[idx].ContainerID = to.String()
+	return net.IPNet{IP: newReservation[idx].IP, Mask: ipnet.Mask}, newReservation, nil
+}
+
+func quarantinedReservation(ip net.IP, id string, releasedAt time.Time) whereaboutstypes.IPReservation {
+	return whereaboutstypes.IPReservation{
+		IP:          ip,
+		ContainerID: quarantinedIDPrefix + id,
+		PodRef:      releasedAt.UTC().Format(time.RFC3339),
+		IsAllocated: false,
+	}
+}
+
+// quarantinedSince returns the time the reservation was released, if it's quarantined
+func quarantinedSince(r whereaboutstypes.IPReservation) (_ time.Time, ok bool) {
+	if !strings.HasPrefix(r.ContainerID, quarantinedIDPrefix) {
+		return time.Time{}, false
+	}
+	releasedAt, err := time.Parse(time.RFC3339, r.PodRef)
+	if err != nil {
+		// Treat it as released long ago, so that it's not stuck in quarantine forever.
+		return time.Time{}, true
+	}
+	return releasedAt, true
+}
+
+// expireQuarantined removes the quarantined IPs from the reservations that were released at
+// least period before now, making them available for reuse. It returns the new reservations, and
+// the number of IPs still quarantined.
+func expireQuarantined(
+	reservation []whereaboutstypes.IPReservation,
+	period time.Duration,
+	now time.Time,
+) ([]whereaboutstypes.IPReservation, int) {
+	result := make([]whereaboutstypes.IPReservation, 0, len(reservation))
+	quarantined := 0
+	for _, r := range reservation {
+		if releasedAt, ok := quarantinedSince(r); ok {
+			if !now.Before(releasedAt.Add(period)) {
+				continue
+			}
+			quarantined += 1
+		}
+		result = append(result, r)
+	}
+	return result, quarantined
+}
+
 func getMatchingIPReservationIndex(reservation []whereaboutstypes.IPReservation, id string) int {
 	foundidx := -1
 	for idx, v := range reservation {
@@ -14,6 +14,7 @@ import (
 	whereaboutsallocate "github.com/k8snetworkplumbingwg/whereabouts/pkg/allocate"
 	whereaboutstypes "github.com/k8snetworkplumbingwg/whereabouts/pkg/types"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
 	"golang.org/x/sync/semaphore"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -53,6 +54,8 @@ type IPAM struct {
 	Config  IPAMConfig
 	metrics *IPAMMetrics
 
+	quarantinePeriod time.Duration
+
 	mu                 sync.Mutex
 	concurrencyLimiter *semaphore.Weighted
 }
@@ -62,6 +65,10 @@ type IPAMParams struct {
 	NadNamespace     string
 	ConcurrencyLimit int
 	MetricsReg       prometheus.Registerer
+
+	// QuarantinePeriod is how long released IPs are kept out of use before they can be given to
+	// another VM. Zero means that they're reused immediately.
+	QuarantinePeriod time.Duration
 }
 
 func (i *IPAM) AcquireIP(ctx context.Context, vmName types.NamespacedName) (net.IPNet, error) {
@@ -72,14 +79,60 @@ func (i *IPAM) AcquireIP(ctx context.Context, vmName types.NamespacedName) (net.
 	return ip, nil
 }
 
+// AcquireIPs acquires IPs for all of the VMs with a single update to the IP pool, returning them in
+// the same order as vmNames.
+//
+// Because updates to the IP pool are serialized, this is much cheaper than calling AcquireIP for
+// each VM when creating many VMs at once.
+func (i *IPAM) AcquireIPs(ctx context.Context, vmNames []types.NamespacedName) ([]net.IPNet, error) {
+	if len(vmNames) == 0 {
+		return nil, nil
+	}
+
+	var ips []net.IPNet
+	_, err := i.runIPAMWithMetrics(ctx, makeAcquireBatchAction(ctx, vmNames, &ips), IPAMAcquireBatch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire IPs: %w", err)
+	}
+	return ips, nil
+}
+
 func (i *IPAM) ReleaseIP(ctx context.Context, vmName types.NamespacedName) (net.IPNet, error) {
-	ip, err := i.runIPAMWithMetrics(ctx, makeReleaseAction(ctx, vmName), IPAMRelease)
+	ip, err := i.runIPAMWithMetrics(ctx, makeReleaseAction(ctx, vmName, i.quarantinePeriod > 0), IPAMRelease)
 	if err != nil {
 		return net.IPNet{}, fmt.Errorf("failed to release IP: %w", err)
 	}
 	return ip, nil
 }
 
+// ReleaseIPs releases the IPs for all of the VMs with a single update to the IP pool. VMs without an
+// IP are ignored.
+//
+// Like AcquireIPs, this is much cheaper than calling ReleaseIP for each VM.
+func (i *IPAM) ReleaseIPs(ctx context.Context, vmNames []types.NamespacedName) error {
+	if len(vmNames) == 0 {
+		return nil
+	}
+
+	_, err := i.runIPAMWithMetrics(ctx, makeReleaseBatchAction(ctx, vmNames, i.quarantinePeriod > 0), IPAMReleaseBatch)
+	if err != nil {
+		return fmt.Errorf("failed to release IPs: %w", err)
+	}
+	return nil
+}
+
+// TransferIP moves the IP reserved for one VM to another, returning the IP.
+//
+// This is used when a VM takes over the running VM of another, e.g. when claiming from a warm
+// pool. It's safe to retry; if the IP has already been transferred, the same IP is returned.
+func (i *IPAM) TransferIP(ctx context.Context, from, to types.NamespacedName) (net.IPNet, error) {
+	ip, err := i.runIPAMWithMetrics(ctx, makeTransferAction(ctx, from, to), IPAMTransfer)
+	if err != nil {
+		return net.IPNet{}, fmt.Errorf("failed to transfer IP: %w", err)
+	}
+	return ip, nil
+}
+
 // New returns a new IPAM object with ipam config and k8s/crd clients
 func New(params IPAMParams) (*IPAM, error) {
 	// get Kubernetes client config
@@ -124,6 +177,7 @@ func NewWithClient(kClient *Client, params IPAMParams) (*IPAM, error) {
 		Config:             *ipamConfig,
 		Client:             *kClient,
 		metrics:            NewIPAMMetrics(params.MetricsReg),
+		quarantinePeriod:   params.QuarantinePeriod,
 		mu:                 sync.Mutex{},
 		concurrencyLimiter: semaphore.NewWeighted(int64(params.ConcurrencyLimit)),
 	}, nil
@@ -267,7 +321,9 @@ func (i *IPAM) runIPAMRange(ctx context.Context, ipRange RangeConfiguration, act
 			return net.IPNet{}, fmt.Errorf("error reading IP pool: %w", err)
 		}
 
-		currentReservation := pool.Allocations(ctx)
+		// Quarantined IPs are freed as part of whatever the next action is, so that we don't
+		// need to separately update the pool when they expire.
+		currentReservation, _ := expireQuarantined(pool.Allocations(ctx), i.quarantinePeriod, time.Now())
 		var newReservation []whereaboutstypes.IPReservation
 		ip, newReservation, err = action(ipRange, currentReservation)
 		if err != nil {
@@ -284,6 +340,11 @@ func (i *IPAM) runIPAMRange(ctx context.Context, ipRange RangeConfiguration, act
 			}
 			return net.IPNet{}, fmt.Errorf("error updating IP pool: %w", err)
 		}
+
+		if i.quarantinePeriod > 0 {
+			_, quarantined := expireQuarantined(newReservation, i.quarantinePeriod, time.Now())
+			i.metrics.quarantined.WithLabelValues(ipRange.Range).Set(float64(quarantined))
+		}
 		return ip, nil
 	}
 	return ip, errors.New("IPAMretries limit reached")
@@ -389,6 +450,10 @@ func toIPReservation(ctx context.Context, allocations map[string]vmv1.IPAllocati
 			continue
 		}
 		ip := whereaboutsallocate.IPAddOffset(firstip, uint64(numOffset))
+		if a.ReleasedAt != nil {
+			reservelist = append(reservelist, quarantinedReservation(ip, a.ContainerID, a.ReleasedAt.Time))
+			continue
+		}
 		reservelist = append(reservelist, whereaboutstypes.IPReservation{
 			IP:          ip,
 			ContainerID: a.ContainerID,
@@ -404,7 +469,15 @@ func toAllocations(reservelist []whereaboutstypes.IPReservation, firstip net.IP)
 	allocations := make(map[string]vmv1.IPAllocation)
 	for _, r := range reservelist {
 		index := whereaboutsallocate.IPGetOffset(r.IP, firstip)
-		allocations[fmt.Sprintf("%d", index)] = vmv1.IPAllocation{ContainerID: r.ContainerID, PodRef: r.PodRef}
+		if releasedAt, ok := quarantinedSince(r); ok {
+			allocations[fmt.Sprintf("%d", index)] = vmv1.IPAllocation{
+				ContainerID: strings.TrimPrefix(r.ContainerID, quarantinedIDPrefix),
+				PodRef:      "",
+				ReleasedAt:  lo.ToPtr(metav1.NewTime(releasedAt)),
+			}
+			continue
+		}
+		allocations[fmt.Sprintf("%d", index)] = vmv1.IPAllocation{ContainerID: r.ContainerID, PodRef: r.PodRef, ReleasedAt: nil}
 	}
 	return allocations
 }
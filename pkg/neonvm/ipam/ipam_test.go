@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	nadv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	nadfake "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
@@ -16,16 +17,22 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	kfake "k8s.io/client-go/kubernetes/fake"
 
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	nfake "github.com/neondatabase/autoscaling/neonvm/client/clientset/versioned/fake"
 	"github.com/neondatabase/autoscaling/pkg/neonvm/ipam"
 )
 
 type testParams struct {
-	prom *prometheus.Registry
-	ipam *ipam.IPAM
+	prom   *prometheus.Registry
+	ipam   *ipam.IPAM
+	client ipam.Client
 }
 
 func makeIPAM(t *testing.T, cfg string) *testParams {
+	return makeIPAMWithQuarantine(t, cfg, 0)
+}
+
+func makeIPAMWithQuarantine(t *testing.T, cfg string, quarantinePeriod time.Duration) *testParams {
 	client := ipam.Client{
 		KubeClient: kfake.NewSimpleClientset(),
 		VMClient:   nfake.NewSimpleClientset(),
@@ -51,13 +58,15 @@ func makeIPAM(t *testing.T, cfg string) *testParams {
 		NadNamespace:     "default",
 		ConcurrencyLimit: 1,
 		MetricsReg:       prom,
+		QuarantinePeriod: quarantinePeriod,
 	})
 
 	require.NoError(t, err)
 
 	return &testParams{
-		prom: prom,
-		ipam: ipam,
+		prom:   prom,
+		ipam:   ipam,
+		client: client,
 	}
 }
 
@@ -241,3 +250,170 @@ func TestIPAMReleaseTwice(t *testing.T) {
 		Mask: ip.Mask,
 	}, ipResult)
 }
+
+func TestIPAMAcquireBatch(t *testing.T) {
+	params := makeIPAM(t,
+		`{
+			"ipRanges": [
+				{
+					"range":"10.100.123.0/24",
+					"range_start":"10.100.123.1",
+					"range_end":"10.100.123.254"
+				}
+			]
+		}`,
+	)
+	ipam := params.ipam
+	defer ipam.Close()
+
+	// Acquire one IP first, to check that the batch returns the same IP for the same VM
+	ip1, err := ipam.AcquireIP(context.Background(), types.NamespacedName{Namespace: "default", Name: "vm1"})
+	require.NoError(t, err)
+
+	names := []types.NamespacedName{
+		{Namespace: "default", Name: "vm2"},
+		{Namespace: "default", Name: "vm1"},
+		{Namespace: "default", Name: "vm3"},
+	}
+	ips, err := ipam.AcquireIPs(context.Background(), names)
+	require.NoError(t, err)
+	require.Len(t, ips, 3)
+	assert.Equal(t, "10.100.123.2/24", ips[0].String())
+	assert.Equal(t, ip1, ips[1])
+	assert.Equal(t, "10.100.123.3/24", ips[2].String())
+
+	// The batch reservations are visible to individual acquires
+	ip3, err := ipam.AcquireIP(context.Background(), names[2])
+	require.NoError(t, err)
+	assert.Equal(t, ips[2], ip3)
+
+	// Releasing in a batch ignores VMs without an IP, and frees the rest for reuse
+	err = ipam.ReleaseIPs(context.Background(), []types.NamespacedName{
+		names[0],
+		{Namespace: "default", Name: "vm4"},
+		names[2],
+	})
+	require.NoError(t, err)
+	ip4, err := ipam.AcquireIP(context.Background(), types.NamespacedName{Namespace: "default", Name: "vm4"})
+	require.NoError(t, err)
+	assert.Equal(t, ips[0], ip4)
+
+	metrics := collectMetrics(t, params.prom)
+	assert.ElementsMatch(t, []metricValue{
+		{Name: "ipam_request_duration_seconds", Action: "acquire", Outcome: "success", Value: 3},
+		{Name: "ipam_request_duration_seconds", Action: "acquire_batch", Outcome: "success", Value: 1},
+		{Name: "ipam_request_duration_seconds", Action: "release_batch", Outcome: "success", Value: 1},
+		{Name: "ipam_ongoing_requests", Action: "acquire", Outcome: "", Value: 0},
+		{Name: "ipam_ongoing_requests", Action: "acquire_batch", Outcome: "", Value: 0},
+		{Name: "ipam_ongoing_requests", Action: "release_batch", Outcome: "", Value: 0},
+	}, metrics)
+}
+
+func TestIPAMQuarantine(t *testing.T) {
+	params := makeIPAMWithQuarantine(t,
+		`{
+			"ipRanges": [
+				{
+					"range":"10.100.123.0/24",
+					"range_start":"10.100.123.1",
+					"range_end":"10.100.123.254"
+				}
+			]
+		}`,
+		time.Hour,
+	)
+	ipam := params.ipam
+	defer ipam.Close()
+
+	ctx := context.Background()
+	vm := func(name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: "default", Name: name}
+	}
+
+	_, err := ipam.AcquireIP(ctx, vm("vm1"))
+	require.NoError(t, err)
+	ip2, err := ipam.AcquireIP(ctx, vm("vm2"))
+	require.NoError(t, err)
+	assert.Equal(t, "10.100.123.2/24", ip2.String())
+
+	ipResult, err := ipam.ReleaseIP(ctx, vm("vm2"))
+	require.NoError(t, err)
+	require.Equal(t, ip2, ipResult)
+
+	// The released IP is quarantined, so it's not reused
+	ip3, err := ipam.AcquireIP(ctx, vm("vm3"))
+	require.NoError(t, err)
+	assert.Equal(t, "10.100.123.3/24", ip3.String())
+
+	pools := params.client.VMClient.NeonvmV1().IPPools("default")
+	pool, err := pools.Get(ctx, "10.100.123.0-24", metav1.GetOptions{})
+	require.NoError(t, err)
+	quarantined := pool.Spec.Allocations["2"]
+	assert.Equal(t, "default/vm2", quarantined.ContainerID)
+	require.NotNil(t, quarantined.ReleasedAt)
+
+	// Once the quarantine period has passed, the IP can be reused
+	pool.Spec.Allocations["2"] = vmv1.IPAllocation{
+		ContainerID: quarantined.ContainerID,
+		PodRef:      "",
+		ReleasedAt:  &metav1.Time{Time: quarantined.ReleasedAt.Add(-2 * time.Hour)},
+	}
+	_, err = pools.Update(ctx, pool, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	ip4, err := ipam.AcquireIP(ctx, vm("vm4"))
+	require.NoError(t, err)
+	assert.Equal(t, ip2, ip4)
+}
+
+func TestIPAMTransfer(t *testing.T) {
+	params := makeIPAMWithQuarantine(t,
+		`{
+			"ipRanges": [
+				{
+					"range":"10.100.123.0/24",
+					"range_start":"10.100.123.1",
+					"range_end":"10.100.123.254"
+				}
+			]
+		}`,
+		time.Hour,
+	)
+	ipam := params.ipam
+	defer ipam.Close()
+
+	ctx := context.Background()
+	vm := func(name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: "default", Name: name}
+	}
+
+	ip, err := ipam.AcquireIP(ctx, vm("pool-vm"))
+	require.NoError(t, err)
+
+	ipResult, err := ipam.TransferIP(ctx, vm("pool-vm"), vm("claimant"))
+	require.NoError(t, err)
+	assert.Equal(t, ip, ipResult)
+
+	// Transferring again is a no-op
+	ipResult, err = ipam.TransferIP(ctx, vm("pool-vm"), vm("claimant"))
+	require.NoError(t, err)
+	assert.Equal(t, ip, ipResult)
+
+	// The new owner has the IP
+	ipResult, err = ipam.AcquireIP(ctx, vm("claimant"))
+	require.NoError(t, err)
+	assert.Equal(t, ip, ipResult)
+
+	// ... and releasing the previous owner doesn't release or quarantine it
+	ipResult, err = ipam.ReleaseIP(ctx, vm("pool-vm"))
+	require.NoError(t, err)
+	assert.Nil(t, ipResult.IP)
+
+	pool, err := params.client.VMClient.NeonvmV1().IPPools("default").Get(ctx, "10.100.123.0-24", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, pool.Spec.Allocations, 1)
+
+	// VMs without an IP can't transfer one
+	_, err = ipam.TransferIP(ctx, vm("other"), vm("other-claimant"))
+	assert.Error(t, err)
+}
@@ -9,14 +9,18 @@ import (
 )
 
 type IPAMMetrics struct {
-	ongoing  *prometheus.GaugeVec
-	duration *prometheus.HistogramVec
+	ongoing     *prometheus.GaugeVec
+	duration    *prometheus.HistogramVec
+	quarantined *prometheus.GaugeVec
 }
 
 const (
-	IPAMAcquire = "acquire"
-	IPAMRelease = "release"
-	IPAMCleanup = "cleanup"
+	IPAMAcquire      = "acquire"
+	IPAMAcquireBatch = "acquire_batch"
+	IPAMRelease      = "release"
+	IPAMReleaseBatch = "release_batch"
+	IPAMTransfer     = "transfer"
+	IPAMCleanup      = "cleanup"
 )
 
 const (
@@ -41,6 +45,10 @@ func NewIPAMMetrics(reg prometheus.Registerer) *IPAMMetrics {
 			Help:    "Duration of IPAM requests",
 			Buckets: buckets,
 		}, []string{"action", "outcome"})),
+		quarantined: util.RegisterMetric(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ipam_quarantined_ips",
+			Help: "Number of released IPs that are quarantined before they can be reused",
+		}, []string{"range"})),
 	}
 }
 
@@ -0,0 +1,345 @@
+package plugin
+
+// Benchmark harness for the scheduler plugin's Filter, Score, and reconcile paths, against
+// synthetic clusters of configurable size.
+//
+// This is used by the benchmarks in benchmark_test.go, both with 'go test -bench' and by
+// TestBenchmarkResults, which emits machine-readable results that can be compared against the
+// baseline in testdata/benchmark_baseline.json. Many other tests also use it to set up state.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+// benchmarkConfigJSON matches the plugin config from autoscale-scheduler/config_map.yaml.
+const benchmarkConfigJSON = `{
+	"watermark": 0.9,
+	"scoring": {
+		"minUsageScore": 0.5,
+		"maxUsageScore": 0,
+		"scorePeak": 0.8,
+		"randomize": true
+	},
+	"schedulerName": "autoscale-scheduler",
+	"reconcileWorkers": 16,
+	"logSuccessiveFailuresThreshold": 10,
+	"startupEventHandlingTimeoutSeconds": 15,
+	"patchRetryWaitSeconds": 1,
+	"k8sCRUDTimeoutSeconds": 1,
+	"nodeMetricLabels": {},
+	"ignoredNamespaces": []
+}`
+
+// defaultBenchmarkConfig returns the plugin Config used for benchmarks when no other is provided.
+func defaultBenchmarkConfig() *Config {
+	var config Config
+	decoder := json.NewDecoder(strings.NewReader(benchmarkConfigJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		panic(fmt.Errorf("could not decode default benchmark config: %w", err))
+	}
+	if path, err := config.validate(); err != nil {
+		panic(fmt.Errorf("invalid default benchmark config at %s: %w", path, err))
+	}
+	return &config
+}
+
+// benchmarkClusterConfig describes the synthetic cluster to benchmark against.
+type benchmarkClusterConfig struct {
+	// Nodes is the number of nodes in the cluster.
+	Nodes int `json:"nodes"`
+	// VMsPerNode is the number of VMs already running on each node.
+	VMsPerNode int `json:"vmsPerNode"`
+
+	// NodeCPU and NodeMem are the allocatable resources of each node.
+	NodeCPU vmv1.MilliCPU `json:"nodeCPU"`
+	NodeMem api.Bytes     `json:"nodeMem"`
+
+	// VMCPU and VMMem are the resources of each VM, including the one being scheduled.
+	VMCPU vmv1.MilliCPU `json:"vmCPU"`
+	VMMem api.Bytes     `json:"vmMem"`
+}
+
+// defaultBenchmarkClusterConfig returns a cluster of the given size, with node and VM sizes
+// loosely based on production: each node is about half full.
+func defaultBenchmarkClusterConfig(nodes int, vmsPerNode int) benchmarkClusterConfig {
+	return benchmarkClusterConfig{
+		Nodes:      nodes,
+		VMsPerNode: vmsPerNode,
+		NodeCPU:    vmv1.MilliCPU(2000 * vmsPerNode),
+		NodeMem:    api.Bytes(8 * vmsPerNode * (1 << 30)),
+		VMCPU:      1000,
+		VMMem:      4 * (1 << 30),
+	}
+}
+
+// benchmarkCluster is a PluginState populated with a synthetic cluster, for benchmarking.
+type benchmarkCluster struct {
+	enforcer *AutoscaleEnforcer
+
+	nodeInfos []*framework.NodeInfo
+	// vmPods are the existing VM pods, with alternate versions of each that request upscaling, so
+	// that reconciling them alternates between upscaling and downscaling.
+	vmPods [][2]*corev1.Pod
+	// newPod is the VM pod that's being scheduled, for Filter and Score.
+	newPod *corev1.Pod
+}
+
+// newBenchmarkCluster creates a new benchmarkCluster from the plugin config and cluster shape.
+//
+// The returned cluster does not interact with the Kubernetes API, and any operations that would do
+// so (e.g., patching VMs or creating migrations) are no-ops.
+func newBenchmarkCluster(config *Config, cluster benchmarkClusterConfig) (*benchmarkCluster, error) {
+	logger := zap.NewNop()
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	s.requeuePod = func(types.UID) error { return nil }
+	s.requeueNode = func(string) error { return nil }
+	s.createMigration = func(*zap.Logger, *vmv1.VirtualMachineMigration) error { return nil }
+	s.deleteMigration = func(*zap.Logger, *vmv1.VirtualMachineMigration) error { return nil }
+	s.patchVM = func(util.NamespacedName, []patch.Operation) error { return nil }
+	s.evictPod = func(*zap.Logger, *corev1.Pod) error { return nil }
+	s.patchNode = func(*zap.Logger, string, []byte) error { return nil }
+
+	c := &benchmarkCluster{
+		enforcer: &AutoscaleEnforcer{
+			logger:      logger,
+			state:       s,
+			metrics:     &pluginMetrics.Framework,
+			getNodeInfo: nil,
+			decisions:   nil,
+		},
+		nodeInfos: nil,
+		vmPods:    nil,
+		newPod:    nil,
+	}
+
+	for i := range cluster.Nodes {
+		node := benchmarkNode(fmt.Sprintf("node-%d", i), cluster)
+		if err := s.HandleNodeEvent(logger, reconcile.EventKindAdded, node); err != nil {
+			return nil, fmt.Errorf("could not add node %q: %w", node.Name, err)
+		}
+
+		var pods []*corev1.Pod
+		for j := range cluster.VMsPerNode {
+			pod, err := benchmarkVMPod(config, fmt.Sprintf("vm-%d-%d", i, j), node.Name, cluster, false)
+			if err != nil {
+				return nil, err
+			}
+			upscaled, err := benchmarkVMPod(config, pod.Name, node.Name, cluster, true)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := s.HandlePodEvent(logger, reconcile.EventKindAdded, pod); err != nil {
+				return nil, fmt.Errorf("could not add pod %q: %w", pod.Name, err)
+			}
+
+			pods = append(pods, pod)
+			c.vmPods = append(c.vmPods, [2]*corev1.Pod{pod, upscaled})
+		}
+
+		nodeInfo := framework.NewNodeInfo(pods...)
+		nodeInfo.SetNode(node)
+		c.nodeInfos = append(c.nodeInfos, nodeInfo)
+	}
+
+	var err error
+	c.newPod, err = benchmarkVMPod(config, "new-vm", "", cluster, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Same as at the end of NewAutoscaleEnforcerPlugin, once initial events have been handled.
+	s.startupDone = true
+
+	return c, nil
+}
+
+func benchmarkNode(name string, cluster benchmarkClusterConfig) *corev1.Node {
+	resources := corev1.ResourceList{
+		corev1.ResourceCPU:    *cluster.NodeCPU.ToResourceQuantity(),
+		corev1.ResourceMemory: *resource.NewQuantity(int64(cluster.NodeMem), resource.BinarySI),
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // synthetic object
+			Name:   name,
+			UID:    types.UID(name),
+			Labels: map[string]string{},
+		},
+		Spec: corev1.NodeSpec{}, //nolint:exhaustruct // synthetic object
+		Status: corev1.NodeStatus{ //nolint:exhaustruct // synthetic object
+			Capacity:    resources,
+			Allocatable: resources,
+		},
+	}
+}
+
+// benchmarkVMPod returns a runner pod for an autoscaling-enabled VM with the cluster's VM size.
+//
+// If upscaled is true, the pod also has an outstanding request from the autoscaler-agent to
+// increase by one scaling unit.
+func benchmarkVMPod(
+	config *Config,
+	name string,
+	nodeName string,
+	cluster benchmarkClusterConfig,
+	upscaled bool,
+) (*corev1.Pod, error) {
+	const memSlotSize = api.Bytes(1 << 30)
+
+	unit := api.Resources{VCPU: 250, Mem: memSlotSize}
+	current := api.Resources{VCPU: cluster.VMCPU, Mem: cluster.VMMem}
+	maxResources := current.Add(unit)
+
+	vmResources := vmv1.VirtualMachineResources{
+		CPUs: vmv1.CPUs{Min: current.VCPU, Use: current.VCPU, Max: maxResources.VCPU},
+		MemorySlots: vmv1.MemorySlots{
+			Min: int32(current.Mem / memSlotSize),
+			Use: int32(current.Mem / memSlotSize),
+			Max: int32(maxResources.Mem / memSlotSize),
+		},
+		MemorySlotSize: *memSlotSize.ToResourceQuantity(),
+	}
+
+	annotations := make(map[string]string)
+	for key, value := range map[string]any{
+		vmv1.VirtualMachineResourcesAnnotation: vmResources,
+		api.AnnotationAutoscalingUnit:          unit,
+	} {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode %s annotation: %w", key, err)
+		}
+		annotations[key] = string(encoded)
+	}
+	if upscaled {
+		encoded, err := json.Marshal(maxResources)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode requested resources: %w", err)
+		}
+		annotations[api.InternalAnnotationResourcesRequested] = string(encoded)
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // synthetic object
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID(name),
+			Labels:      map[string]string{api.LabelEnableAutoscaling: "true"},
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{{ //nolint:exhaustruct // synthetic object
+				APIVersion: vmv1.SchemeGroupVersion.String(),
+				Kind:       "VirtualMachine",
+				Name:       name,
+			}},
+		},
+		Spec: corev1.PodSpec{ //nolint:exhaustruct // synthetic object
+			NodeName:      nodeName,
+			SchedulerName: config.SchedulerName,
+		},
+		Status: corev1.PodStatus{}, //nolint:exhaustruct // synthetic object
+	}, nil
+}
+
+// Filter runs the Filter method for the pod being scheduled, on the i'th node (modulo the number
+// of nodes).
+func (c *benchmarkCluster) Filter(i int) error {
+	nodeInfo := c.nodeInfos[i%len(c.nodeInfos)]
+	status := c.enforcer.Filter(context.Background(), nil, c.newPod, nodeInfo)
+	// Unschedulable is an expected outcome if the cluster is full; anything else is not.
+	if !status.IsSuccess() && status.Code() != framework.Unschedulable {
+		return status.AsError()
+	}
+	return nil
+}
+
+// Score runs the Score method for the pod being scheduled, on the i'th node (modulo the number of
+// nodes).
+func (c *benchmarkCluster) Score(i int) error {
+	nodeName := c.nodeInfos[i%len(c.nodeInfos)].Node().Name
+	_, status := c.enforcer.Score(context.Background(), nil, c.newPod, nodeName)
+	return status.AsError()
+}
+
+// Reconcile handles a modification to the i'th VM pod (modulo the number of pods), alternating
+// between requesting upscaling and returning to its original size.
+func (c *benchmarkCluster) Reconcile(i int) error {
+	pods := c.vmPods[i%len(c.vmPods)]
+	pod := pods[(i/len(c.vmPods))%2]
+	_, err := c.enforcer.state.HandlePodEvent(c.enforcer.logger, reconcile.EventKindModified, pod)
+	return err
+}
+
+// benchmarkResult is the machine-readable result of a single benchmark, as returned by
+// runBenchmark.
+type benchmarkResult struct {
+	Name       string  `json:"name"`
+	Nodes      int     `json:"nodes"`
+	VMsPerNode int     `json:"vmsPerNode"`
+	Ops        int     `json:"ops"`
+	NsPerOp    float64 `json:"nsPerOp"`
+	OpsPerSec  float64 `json:"opsPerSec"`
+	P50Ns      int64   `json:"p50Ns"`
+	P99Ns      int64   `json:"p99Ns"`
+}
+
+// runBenchmark runs op the given number of times, and returns statistics on its latency.
+func runBenchmark(
+	name string,
+	cluster benchmarkClusterConfig,
+	ops int,
+	op func(i int) error,
+) (benchmarkResult, error) {
+	if ops <= 0 {
+		return benchmarkResult{}, fmt.Errorf("number of ops must be > 0, got %d", ops)
+	}
+
+	durations := make([]time.Duration, ops)
+	start := time.Now()
+	for i := range ops {
+		opStart := time.Now()
+		if err := op(i); err != nil {
+			return benchmarkResult{}, fmt.Errorf("%s op #%d failed: %w", name, i, err)
+		}
+		durations[i] = time.Since(opStart)
+	}
+	total := time.Since(start)
+
+	slices.Sort(durations)
+	percentile := func(p float64) int64 {
+		return durations[min(len(durations)-1, int(p*float64(len(durations))))].Nanoseconds()
+	}
+
+	return benchmarkResult{
+		Name:       name,
+		Nodes:      cluster.Nodes,
+		VMsPerNode: cluster.VMsPerNode,
+		Ops:        ops,
+		NsPerOp:    float64(total.Nanoseconds()) / float64(ops),
+		OpsPerSec:  float64(ops) / total.Seconds(),
+		P50Ns:      percentile(0.5),
+		P99Ns:      percentile(0.99),
+	}, nil
+}
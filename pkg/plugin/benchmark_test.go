@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+)
+
+var benchmarkClusterSizes = []struct {
+	nodes      int
+	vmsPerNode int
+}{
+	{nodes: 10, vmsPerNode: 20},
+	{nodes: 100, vmsPerNode: 50},
+	{nodes: 500, vmsPerNode: 100},
+}
+
+func runClusterBenchmarks(b *testing.B, op func(c *benchmarkCluster, i int) error) {
+	for _, size := range benchmarkClusterSizes {
+		b.Run(fmt.Sprintf("nodes=%d/vms=%d", size.nodes, size.vmsPerNode), func(b *testing.B) {
+			cluster, err := newBenchmarkCluster(
+				defaultBenchmarkConfig(),
+				defaultBenchmarkClusterConfig(size.nodes, size.vmsPerNode),
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				if err := op(cluster, i); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFilter(b *testing.B) {
+	runClusterBenchmarks(b, (*benchmarkCluster).Filter)
+}
+
+func BenchmarkScore(b *testing.B) {
+	runClusterBenchmarks(b, (*benchmarkCluster).Score)
+}
+
+func BenchmarkReconcile(b *testing.B) {
+	runClusterBenchmarks(b, (*benchmarkCluster).Reconcile)
+}
+
+// Flags for TestBenchmarkResults. For example, to compare against the committed baseline:
+//
+//	go test ./pkg/plugin -run '^TestBenchmarkResults$' -count=1 \
+//		-benchmark-baseline testdata/benchmark_baseline.json
+//
+// Results depend on the machine they were recorded on, so the baseline should be re-recorded (with
+// -benchmark-results) when comparing on different hardware.
+var (
+	benchmarkResultsPath = flag.String("benchmark-results", "",
+		"File to write JSON results of TestBenchmarkResults to")
+	benchmarkBaselinePath = flag.String("benchmark-baseline", "",
+		"JSON results from a previous run of TestBenchmarkResults to compare against")
+	benchmarkMaxRegression = flag.Float64("benchmark-max-regression", 0.2,
+		"Maximum allowed fractional increase in ns/op over the baseline in TestBenchmarkResults")
+	benchmarkNodes      = flag.Int("benchmark-nodes", 100, "Number of nodes in the cluster for TestBenchmarkResults")
+	benchmarkVMsPerNode = flag.Int("benchmark-vms-per-node", 50, "Number of VMs on each node for TestBenchmarkResults")
+	benchmarkOps        = flag.Int("benchmark-ops", 100000, "Number of operations for each benchmark in TestBenchmarkResults")
+)
+
+// benchmarkResults is the JSON output of TestBenchmarkResults, and the format of the baseline it's
+// compared to.
+type benchmarkResults struct {
+	Results []benchmarkResult `json:"results"`
+}
+
+// TestBenchmarkResults runs the benchmarks with a fixed number of operations, emitting
+// machine-readable results and checking them against a baseline, so that regressions can be caught
+// in review. It's skipped unless -benchmark-results or -benchmark-baseline is set.
+func TestBenchmarkResults(t *testing.T) {
+	if *benchmarkResultsPath == "" && *benchmarkBaselinePath == "" {
+		t.Skip("neither -benchmark-results nor -benchmark-baseline is set")
+	}
+
+	config := defaultBenchmarkConfig()
+	clusterConfig := defaultBenchmarkClusterConfig(*benchmarkNodes, *benchmarkVMsPerNode)
+
+	benchmarks := []struct {
+		name string
+		op   func(c *benchmarkCluster, i int) error
+	}{
+		{"Filter", (*benchmarkCluster).Filter},
+		{"Score", (*benchmarkCluster).Score},
+		{"Reconcile", (*benchmarkCluster).Reconcile},
+	}
+
+	var results benchmarkResults
+	for _, b := range benchmarks {
+		// Use a fresh cluster for each benchmark, so that they don't affect each other.
+		cluster, err := newBenchmarkCluster(config, clusterConfig)
+		if err != nil {
+			t.Fatalf("could not create synthetic cluster: %s", err)
+		}
+
+		result, err := runBenchmark(b.name, clusterConfig, *benchmarkOps, func(i int) error {
+			return b.op(cluster, i)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results.Results = append(results.Results, result)
+	}
+
+	if *benchmarkResultsPath != "" {
+		output, err := json.MarshalIndent(&results, "", "  ")
+		if err != nil {
+			t.Fatalf("could not encode results: %s", err)
+		}
+		if err := os.WriteFile(*benchmarkResultsPath, append(output, '\n'), 0o644); err != nil {
+			t.Fatalf("could not write results: %s", err)
+		}
+	}
+
+	if *benchmarkBaselinePath != "" {
+		compareToBaseline(t, results, *benchmarkBaselinePath, *benchmarkMaxRegression)
+	}
+}
+
+// compareToBaseline logs the change in ns/op for each benchmark that's also in the baseline, and
+// fails the test if any regressed by more than maxRegression.
+func compareToBaseline(t *testing.T, results benchmarkResults, baselinePath string, maxRegression float64) {
+	contents, err := os.ReadFile(baselinePath)
+	if err != nil {
+		t.Fatalf("could not read baseline: %s", err)
+	}
+	var baseline benchmarkResults
+	if err := json.Unmarshal(contents, &baseline); err != nil {
+		t.Fatalf("could not decode baseline: %s", err)
+	}
+
+	type key struct {
+		name       string
+		nodes      int
+		vmsPerNode int
+	}
+	baselineByKey := make(map[key]benchmarkResult)
+	for _, r := range baseline.Results {
+		baselineByKey[key{r.Name, r.Nodes, r.VMsPerNode}] = r
+	}
+
+	for _, r := range results.Results {
+		base, ok := baselineByKey[key{r.Name, r.Nodes, r.VMsPerNode}]
+		if !ok || base.NsPerOp == 0 {
+			t.Logf("%s: no baseline for nodes=%d vms-per-node=%d", r.Name, r.Nodes, r.VMsPerNode)
+			continue
+		}
+
+		change := r.NsPerOp/base.NsPerOp - 1
+		t.Logf("%s: %.0f ns/op vs baseline %.0f ns/op (%+.1f%%)", r.Name, r.NsPerOp, base.NsPerOp, change*100)
+		if change > maxRegression {
+			t.Errorf("%s regressed by more than %.0f%%", r.Name, maxRegression*100)
+		}
+	}
+}
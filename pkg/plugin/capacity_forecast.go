@@ -0,0 +1,178 @@
+package plugin
+
+// Forecasting the cluster's remaining capacity, as configured by (Config).CapacityForecast.
+//
+// Each node's usage is already exported, but it's hard to alert on the cluster filling up from
+// those alone: whether a VM still fits depends on how the free space is split between nodes, and a
+// fixed threshold fires too late when usage is growing quickly. So we periodically export two
+// derived metrics instead:
+//
+//   - For each configured shape of VM, how many more would fit in the cluster, counting each node
+//     separately, the same way as Filter.
+//   - For CPU and memory, how long until the resources reserved in the cluster reach its watermark,
+//     extrapolated from the growth over the configured window.
+//
+// Only nodes that accept new pods are counted -- nodes that are being scaled down, drained, or
+// defragmented are left out.
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// capacitySample is the total of the resources reserved in the cluster, and of its watermark, at a
+// point in time.
+type capacitySample struct {
+	time      time.Time
+	reserved  api.Resources
+	watermark api.Resources
+}
+
+// runCapacityForecast periodically updates the capacity forecast metrics, until the context is
+// canceled.
+func (s *PluginState) runCapacityForecast(ctx context.Context, logger *zap.Logger, config CapacityForecastConfig) {
+	ticker := time.NewTicker(time.Second * time.Duration(config.IntervalSeconds))
+	defer ticker.Stop()
+
+	window := time.Second * time.Duration(config.GrowthWindowSeconds)
+	var samples []capacitySample
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		sample, fits, ok := s.capacityForecast(config.Shapes, now)
+		if !ok {
+			logger.Info("Skipping capacity forecast because startup is not done yet")
+			continue
+		}
+
+		for name, count := range fits {
+			s.metrics.CapacityForecastVMsFit.WithLabelValues(name).Set(float64(count))
+		}
+
+		samples = trimCapacitySamples(append(samples, sample), now.Add(-window))
+		cpuSeconds, memSeconds := secondsUntilWatermark(samples)
+		s.metrics.CapacityForecastWatermarkSeconds.WithLabelValues("cpu").Set(cpuSeconds)
+		s.metrics.CapacityForecastWatermarkSeconds.WithLabelValues("mem").Set(memSeconds)
+	}
+}
+
+// capacityForecast returns the current totals for the cluster and the number of additional VMs of
+// each shape that fit, or false if the plugin hasn't finished startup (and so the state may be
+// incomplete).
+func (s *PluginState) capacityForecast(
+	shapes []CapacityForecastShape,
+	now time.Time,
+) (_ capacitySample, fits map[string]int, ok bool) {
+	cfg := s.config()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.startupDone {
+		return lo.Empty[capacitySample](), nil, false
+	}
+
+	sample := capacitySample{
+		time:      now,
+		reserved:  api.Resources{VCPU: 0, Mem: 0},
+		watermark: api.Resources{VCPU: 0, Mem: 0},
+	}
+	fits = make(map[string]int)
+	for _, shape := range shapes {
+		fits[shape.Name] = 0
+	}
+
+	for _, ns := range s.nodes {
+		if nodeFilterReason(cfg, ns, now) != "" {
+			continue
+		}
+
+		sample.reserved.VCPU += ns.node.CPU.Reserved
+		sample.reserved.Mem += ns.node.Mem.Reserved
+		sample.watermark.VCPU += ns.node.CPU.Watermark
+		sample.watermark.Mem += ns.node.Mem.Watermark
+
+		maxVMs, limitVMs := cfg.maxVMsOnNode(ns.labels)
+		for _, shape := range shapes {
+			fits[shape.Name] += vmsFitOnNode(ns.node, shape.Resources, maxVMs, limitVMs)
+		}
+	}
+
+	return sample, fits, true
+}
+
+// vmsFitOnNode returns the number of additional VMs with the given resources that would fit on the
+// node.
+func vmsFitOnNode(n *state.Node, r api.Resources, maxVMs int, limitVMs bool) int {
+	if n.OverBudget() {
+		return 0
+	}
+
+	count := min(int((n.CPU.Total-n.CPU.Reserved)/r.VCPU), int((n.Mem.Total-n.Mem.Reserved)/r.Mem))
+	if limitVMs {
+		count = min(count, max(maxVMs-n.VMs(), 0))
+	}
+	return count
+}
+
+// trimCapacitySamples removes the samples that aren't needed to measure growth since the cutoff,
+// keeping the newest sample at or before it.
+func trimCapacitySamples(samples []capacitySample, cutoff time.Time) []capacitySample {
+	drop := 0
+	for drop+1 < len(samples) && !samples[drop+1].time.After(cutoff) {
+		drop += 1
+	}
+	return samples[drop:]
+}
+
+// secondsUntilWatermark returns the number of seconds until the resources reserved in the cluster
+// reach its watermark, extrapolating linearly from the oldest to the newest sample.
+func secondsUntilWatermark(samples []capacitySample) (cpu float64, mem float64) {
+	if len(samples) == 0 {
+		return math.Inf(1), math.Inf(1)
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.time.Sub(first.time).Seconds()
+
+	cpu = extrapolateSeconds(
+		first.reserved.VCPU.AsFloat64(),
+		last.reserved.VCPU.AsFloat64(),
+		last.watermark.VCPU.AsFloat64(),
+		elapsed,
+	)
+	mem = extrapolateSeconds(
+		first.reserved.Mem.AsFloat64(),
+		last.reserved.Mem.AsFloat64(),
+		last.watermark.Mem.AsFloat64(),
+		elapsed,
+	)
+	return cpu, mem
+}
+
+// extrapolateSeconds returns the number of seconds until current reaches limit, if it keeps growing
+// at the rate it grew from previous over elapsed seconds -- zero if it already has, or +Inf if it
+// isn't growing.
+func extrapolateSeconds(previous, current, limit, elapsed float64) float64 {
+	if current >= limit {
+		return 0
+	} else if elapsed <= 0 || current <= previous {
+		return math.Inf(1)
+	}
+
+	rate := (current - previous) / elapsed
+	return (limit - current) / rate
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestVMsFitOnNode(t *testing.T) {
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, 0.9, nil)
+	node.AddPod(preemptionTestPod("vm-a", 3000, true))
+
+	small := api.Resources{VCPU: 1000, Mem: 4 * 1024 * 1024 * 1024}
+	large := api.Resources{VCPU: 4000, Mem: 16 * 1024 * 1024 * 1024}
+
+	// 7 CPUs and 40 GiB remaining
+	assert.Equal(t, 7, vmsFitOnNode(node, small, 0, false))
+	assert.Equal(t, 1, vmsFitOnNode(node, large, 0, false))
+	// ... but only one more VM is allowed on the node
+	assert.Equal(t, 1, vmsFitOnNode(node, small, 2, true))
+	assert.Equal(t, 0, vmsFitOnNode(node, small, 1, true))
+
+	node.AddPod(preemptionTestPod("vm-b", 8000, true))
+	assert.Equal(t, 0, vmsFitOnNode(node, small, 0, false), "over-budget nodes have no room")
+}
+
+func TestSecondsUntilWatermark(t *testing.T) {
+	start := time.Now()
+	sample := func(seconds int, reservedCPU vmv1.MilliCPU) capacitySample {
+		return capacitySample{
+			time:      start.Add(time.Duration(seconds) * time.Second),
+			reserved:  api.Resources{VCPU: reservedCPU, Mem: 1024},
+			watermark: api.Resources{VCPU: 9000, Mem: 2048},
+		}
+	}
+
+	samples := []capacitySample{sample(0, 1000), sample(30, 1500), sample(60, 2000), sample(90, 3000)}
+
+	// Only the newest sample at or before the cutoff is kept, along with the ones after it
+	trimmed := trimCapacitySamples(samples, start.Add(45*time.Second))
+	assert.Equal(t, samples[1:], trimmed)
+
+	// 1500m over 60s is 25m/s, so 6000m remaining takes 240s. Memory isn't growing.
+	cpu, mem := secondsUntilWatermark(trimmed)
+	assert.InDelta(t, 240.0, cpu, 0.001)
+	assert.True(t, math.IsInf(mem, 1))
+
+	// Already at the watermark
+	cpu, _ = secondsUntilWatermark([]capacitySample{sample(0, 9000)})
+	assert.Equal(t, 0.0, cpu)
+
+	// A single sample can't tell us anything about growth
+	cpu, _ = secondsUntilWatermark([]capacitySample{sample(0, 1000)})
+	assert.True(t, math.IsInf(cpu, 1))
+}
@@ -1,11 +1,28 @@
 package plugin
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"slices"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/samber/lo"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+	"sigs.k8s.io/yaml"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
 //////////////////
@@ -17,25 +34,84 @@ import (
 // It is parsed from a JSON file in a separate ConfigMap.
 type Config struct {
 	// Scoring defines our policies around how to weight where Pods should be scheduled.
+	//
+	// If the scoring curve is not set, it defaults to the one in DefaultScoringConfig.
 	Scoring ScoringConfig `json:"scoring"`
 
 	// Watermark is the fraction of total resources allocated above which we should be migrating VMs
 	// away to reduce usage.
-	Watermark float64 `json:"watermark"`
+	//
+	// If zero, defaults to DefaultWatermark.
+	Watermark float64 `json:"watermark" schema:"minimum=0,maximum=1"`
+
+	// CPUWatermark and MemoryWatermark, if not zero, are the watermarks for each resource
+	// individually. Migrations are triggered when either resource is above its watermark.
+	//
+	// If zero, they default to Watermark.
+	CPUWatermark    float64 `json:"cpuWatermark,omitempty" schema:"minimum=0,maximum=1"`
+	MemoryWatermark float64 `json:"memoryWatermark,omitempty" schema:"minimum=0,maximum=1"`
+
+	// WatermarkHysteresis, if not zero, is the fraction of a node's resources below the watermark
+	// that its usage must drop to before we stop migrating VMs away, once it's gone above the
+	// watermark. This prevents repeated migrations when usage oscillates around the watermark.
+	//
+	// It must be less than the watermarks for both resources.
+	WatermarkHysteresis float64 `json:"watermarkHysteresis,omitempty" schema:"minimum=0,maximum=1"`
+
+	// CordonWatermark, if not nil, enables cordoning nodes whose reserved CPU or memory goes above
+	// a second, higher threshold, so that the default scheduler also stops placing non-VM pods
+	// there.
+	CordonWatermark *CordonWatermarkConfig `json:"cordonWatermark,omitempty"`
+
+	// Defragmentation, if not nil, enables periodically emptying lightly-used nodes by migrating
+	// their VMs elsewhere, so that cluster-autoscaler can remove them.
+	Defragmentation *DefragmentationConfig `json:"defragmentation,omitempty"`
+
+	// WatermarkOverrides gives alternate watermarks for VMs in namespaces matching a label
+	// selector, so that e.g. latency-sensitive namespaces can be migrated away from busy nodes
+	// earlier than batch workloads. The first override that matches a namespace is used.
+	//
+	// Because migrations are decided per node, each node uses the lowest watermark of the VMs on
+	// it. Namespaces can be selected by name with the "kubernetes.io/metadata.name" label.
+	WatermarkOverrides []WatermarkOverride `json:"watermarkOverrides,omitempty"`
 
 	// SchedulerName informs the scheduler of its name, so that it can identify pods that a previous
 	// version handled.
+	//
+	// If empty, defaults to DefaultSchedulerName.
 	SchedulerName string `json:"schedulerName"`
 
 	// ReconcileWorkers sets the number of parallel workers to use for the global reconcile queue.
-	ReconcileWorkers int `json:"reconcileWorkers"`
+	// Kinds of objects with their own workers in Reconcile are not handled by these workers.
+	//
+	// If ReconcileWorkerAutoscaling is not nil, this is the minimum number of workers.
+	//
+	// If zero, defaults to DefaultReconcileWorkers.
+	ReconcileWorkers int `json:"reconcileWorkers" schema:"minimum=0"`
+
+	// ReconcileWorkerAutoscaling, if not nil, enables automatically adjusting the number of
+	// reconcile workers based on how long items are waiting in the queue.
+	ReconcileWorkerAutoscaling *ReconcileWorkerAutoscalingConfig `json:"reconcileWorkerAutoscaling,omitempty"`
+
+	// ReconcilePriorities, if not nil, divides the reconcile queue into priority classes and shares
+	// the reconcile workers between them by weight, so that e.g. a flood of Node updates can't
+	// starve pod deletions or retries of VM patches.
+	//
+	// If nil, all reconcile operations are handled in the order that they're due.
+	ReconcilePriorities *ReconcilePrioritiesConfig `json:"reconcilePriorities,omitempty"`
+
+	// Reconcile, if not nil, sets options for reconciling each kind of object separately: whether
+	// it has its own pool of workers, and how failures are retried.
+	Reconcile *ReconcileConfig `json:"reconcile,omitempty"`
 
 	// LogSuccessiveFailuresThreshold is the threshold for number of failures in a row at which
 	// we'll start logging that an object is failing to be reconciled.
 	//
 	// This is to help make it easier to go from metrics saying "N objects are failing" to actually
 	// finding the relevant objects.
-	LogSuccessiveFailuresThreshold int `json:"logSuccessiveFailuresThreshold"`
+	//
+	// If zero, defaults to DefaultLogSuccessiveFailuresThreshold.
+	LogSuccessiveFailuresThreshold int `json:"logSuccessiveFailuresThreshold" schema:"minimum=0"`
 
 	// StartupEventHandlingTimeoutSeconds gives the maximum duration, in seconds, that we are
 	// allowed to wait to finish handling all of the initial events generated by reading the cluster
@@ -43,15 +119,49 @@ type Config struct {
 	//
 	// If event processing takes longer than this time, then plugin creation will fail, and the
 	// scheduler pod will retry.
-	StartupEventHandlingTimeoutSeconds int `json:"startupEventHandlingTimeoutSeconds"`
+	//
+	// If zero, defaults to DefaultStartupEventHandlingTimeoutSeconds.
+	StartupEventHandlingTimeoutSeconds int `json:"startupEventHandlingTimeoutSeconds" schema:"minimum=0"`
 
 	// K8sCRUDTimeoutSeconds sets the timeout to use for creating, updating, or deleting singular
 	// kubernetes objects.
-	K8sCRUDTimeoutSeconds int `json:"k8sCRUDTimeoutSeconds"`
+	//
+	// If zero, defaults to DefaultK8sCRUDTimeoutSeconds.
+	K8sCRUDTimeoutSeconds int `json:"k8sCRUDTimeoutSeconds" schema:"minimum=0"`
 
 	// PatchRetryWaitSeconds sets the minimum duration, in seconds, that we must wait between
-	// successive patch operations on a VirtualMachine object.
-	PatchRetryWaitSeconds int `json:"patchRetryWaitSeconds"`
+	// successive patch operations on a VirtualMachine object, and before retrying a failed patch.
+	//
+	// If zero, defaults to DefaultPatchRetryWaitSeconds.
+	PatchRetryWaitSeconds int `json:"patchRetryWaitSeconds" schema:"minimum=0"`
+
+	// PatchMaxAttempts sets the maximum number of times in a row that patching a VirtualMachine
+	// object can fail due to a conflict or failed precondition before we stop retrying it after
+	// PatchRetryWaitSeconds, and fall back to the usual error backoff.
+	//
+	// If zero, conflicts and failed preconditions are not retried any sooner than other errors.
+	PatchMaxAttempts int `json:"patchMaxAttempts,omitempty" schema:"minimum=0"`
+
+	// WatchStaleTimeoutSeconds, if not zero, gives the maximum duration, in seconds, without any
+	// events from one of our watch streams before we assume it has silently stalled, and restart
+	// it with a fresh re-list.
+	WatchStaleTimeoutSeconds int `json:"watchStaleTimeoutSeconds,omitempty" schema:"minimum=0"`
+
+	// ReservationTTLSeconds, if not zero, gives the duration, in seconds, that upscaling approved
+	// for a VM is reserved for before it's released, unless the autoscaler-agent confirms that it
+	// patched the VM to use it.
+	//
+	// This only applies to VMs whose autoscaler-agent supports confirming reservations.
+	ReservationTTLSeconds int `json:"reservationTTLSeconds,omitempty" schema:"minimum=0"`
+
+	// UnboundReservationTTLSeconds, if not zero, gives the duration, in seconds, that resources
+	// reserved for a pod by the Reserve method are kept before they're released, unless we've seen
+	// the pod bound to the node.
+	//
+	// This protects against pods that never reach Bind, without a matching Unreserve (e.g., if the
+	// API server times out). It should be comfortably longer than the time pods normally take to
+	// be bound.
+	UnboundReservationTTLSeconds int `json:"unboundReservationTTLSeconds,omitempty" schema:"minimum=0"`
 
 	// NodeMetricLabels gives additional labels to annotate node metrics with.
 	// The map is keyed by the metric name, and gives the kubernetes label that should be used to
@@ -66,15 +176,692 @@ type Config struct {
 	NodeMetricLabels map[string]string `json:"nodeMetricLabels"`
 
 	// IgnoredNamespaces, if provided, gives a list of namespaces that the plugin should completely
-	// ignore, as if pods from those namespaces do not exist.
+	// ignore, as if pods from those namespaces do not exist. Each entry may also be a glob pattern
+	// (e.g. "overprovisioning-*"), using the syntax of path.Match.
 	//
-	// This is specifically designed for our "overprovisioning" namespace, which creates paused pods
+	// This is specifically designed for our "overprovisioning" namespaces, which create paused pods
 	// to trigger cluster-autoscaler.
 	//
 	// The only exception to this rule is during Filter method calls, where we do still count the
 	// resources from such pods. The reason to do that is so that these overprovisioning pods can be
 	// evicted, which will allow cluster-autoscaler to trigger scale-up.
 	IgnoredNamespaces []string `json:"ignoredNamespaces"`
+
+	// IgnoredNamespaceSelector, if not empty, gives the labels of namespaces that the plugin should
+	// ignore, in addition to IgnoredNamespaces.
+	//
+	// Namespaces' labels are watched, so that changes to them apply without a restart -- but
+	// changes to the selector itself require one.
+	IgnoredNamespaceSelector map[string]string `json:"ignoredNamespaceSelector,omitempty"`
+
+	// ComputeUnitConfigPath, if not empty, gives the path to the JSON-encoded api.ComputeUnitConfig
+	// shared with the autoscaler-agent and neonvm-controller.
+	//
+	// If set, the plugin checks that the Compute Unit sent in each agent request matches the one
+	// configured for the VM's family, and logs a warning if they differ.
+	ComputeUnitConfigPath string `json:"computeUnitConfigPath,omitempty"`
+
+	// UpscaleRateLimit, if not nil, caps the total amount of new capacity that the plugin will
+	// grant for upscaling across the whole cluster, per second.
+	//
+	// This smooths out thundering-herd upscaling (e.g. after a region-wide traffic spike), so that
+	// nodes don't all go over the watermark at the same time and trigger a wave of migrations.
+	UpscaleRateLimit *UpscaleRateLimitConfig `json:"upscaleRateLimit,omitempty"`
+
+	// Federation, if not nil, enables periodically pushing a summary of this cluster's capacity
+	// and pressure to a central federation service, for cross-cluster placement of new endpoints.
+	Federation *FederationConfig `json:"federation,omitempty"`
+
+	// HealthReport, if not nil, enables periodically writing a summary of the health of the fleet
+	// to a ConfigMap, so that operators and external monitors have a single object to watch.
+	HealthReport *HealthReportConfig `json:"healthReport,omitempty"`
+
+	// CapacityForecast, if not nil, enables metrics that forecast the cluster's remaining capacity
+	// -- how many more VMs of each shape fit, and how long until the watermark is reached at the
+	// current rate of growth -- so that alerts can fire before pods go Pending. Refer to
+	// capacity_forecast.go for more.
+	CapacityForecast *CapacityForecastConfig `json:"capacityForecast,omitempty"`
+
+	// DecisionLog, if not nil, enables recording the candidate nodes, filter results, and scores
+	// for each pod's scheduling decision, so that it's possible to tell why a VM was placed where it
+	// was after the fact.
+	DecisionLog *DecisionLogConfig `json:"decisionLog,omitempty"`
+
+	// DebugServer, if not nil, enables a separate read-only HTTP server that returns the plugin's
+	// full in-memory state, for debugging.
+	DebugServer *DebugServerConfig `json:"debugServer,omitempty"`
+
+	// StateHandoff, if not nil, enables saving a snapshot of the resources reserved for each VM to
+	// a ConfigMap, so that the next instance of the scheduler (e.g. during a rollout) starts with
+	// them, instead of only what was recorded on the VMs -- which may be missing recent approvals.
+	// Refer to state_handoff.go for more.
+	StateHandoff *StateHandoffConfig `json:"stateHandoff,omitempty"`
+
+	// LeaderElection, if not nil, allows running the scheduler with multiple replicas, by following
+	// the scheduler's own leader election: standby replicas keep their state up-to-date, but leave
+	// approving resources, migrations, and other changes to the leader. Refer to
+	// leader_election.go for more.
+	//
+	// The scheduler's leader election must also be enabled, using the same Lease.
+	LeaderElection *LeaderElectionConfig `json:"leaderElection,omitempty"`
+
+	// Preemption, if not nil, enables making room for VM pods that don't fit on any node, by
+	// evicting pods in IgnoredNamespaces or migrating other VMs off a node in PostFilter.
+	Preemption *PreemptionConfig `json:"preemption,omitempty"`
+
+	// PriorityHandling, if not nil, makes placement and migrations take into account the priority
+	// of VM pods, from their PriorityClass.
+	//
+	// Low-priority VMs are only placed onto nodes where they'd stay below the watermark, leaving
+	// the room above it for high-priority VMs. When a node is above the watermark, lower-priority
+	// VMs are migrated away first, and preemption only ever moves VMs with lower priority than the
+	// pod it's making room for.
+	PriorityHandling *PriorityHandlingConfig `json:"priorityHandling,omitempty"`
+
+	// SystemPodAccounting, if not nil, sets how DaemonSet and static pods are counted toward each
+	// node's usage (and therefore the watermark and scoring) -- or whether they're counted at all.
+	//
+	// If nil, their resource requests are used, like any other pod.
+	SystemPodAccounting *SystemPodAccountingConfig `json:"systemPodAccounting,omitempty"`
+
+	// ExtraSystemReserve, if not nil, gives additional resources on each node that are not usable
+	// for VMs, beyond what's already excluded from the node's allocatable resources by
+	// kube-reserved and system-reserved.
+	ExtraSystemReserve *api.Resources `json:"extraSystemReserve,omitempty"`
+
+	// VMsPerNode, if not nil, limits the number of VMs that can be placed on each node, regardless
+	// of the resources available.
+	//
+	// This exists because per-VM overheads (tap devices, IRQs, etc.) make packing many small VMs
+	// onto a single node pathological, even if there's nominally enough capacity.
+	VMsPerNode *VMsPerNodeConfig `json:"vmsPerNode,omitempty"`
+
+	// NetworkBandwidth, if not nil, enables accounting for the network bandwidth that VMs are
+	// expected to use (from api.AnnotationNetworkBandwidth) against each node's bandwidth.
+	//
+	// Without this, network-heavy VMs can be packed onto the same node and saturate its NIC.
+	NetworkBandwidth *NetworkBandwidthConfig `json:"networkBandwidth,omitempty"`
+
+	// ExtendedResources gives the extended resources (e.g., "nvidia.com/gpu") or hugepages (e.g.,
+	// "hugepages-2Mi") to track on each node, in addition to CPU and memory.
+	//
+	// Pods that request a tracked resource are only allowed onto nodes with enough of it left,
+	// counting the requests of all pods on the node -- including non-VM pods.
+	ExtendedResources []ExtendedResourceConfig `json:"extendedResources,omitempty"`
+
+	// VMAnnotationResources maps annotations on VMs to requirements for the tracked
+	// ExtendedResources, for VMs that only express their need for them by annotation (e.g.,
+	// hugepages or local NVMe).
+	//
+	// VMs with a mapped annotation are treated as if they requested the resource: they're only
+	// allowed onto nodes with enough of it left, and scored the same way.
+	VMAnnotationResources []VMAnnotationResourceConfig `json:"vmAnnotationResources,omitempty"`
+
+	// UsageBlending, if not nil, incorporates the actual usage reported by the autoscaler-agent
+	// into deciding whether a node is above the watermark, rather than just the resources reserved
+	// for each VM.
+	//
+	// This helps avoid draining nodes full of idle-but-allocated VMs, while nodes with genuinely
+	// busy VMs are more likely to have VMs migrated away.
+	UsageBlending *UsageBlendingConfig `json:"usageBlending,omitempty"`
+
+	// MigrationDeferral, if not nil, allows delaying the live migration of VMs that are currently
+	// busy, in the hope of migrating them while they're idle instead.
+	//
+	// Migrating a VM in the middle of heavy work can significantly slow that work down.
+	MigrationDeferral *MigrationDeferralConfig `json:"migrationDeferral,omitempty"`
+
+	// MigrationLimits, if not nil, limits how many live migrations the plugin starts at once, and
+	// how quickly, so that migrating many VMs away from busy nodes doesn't saturate the network.
+	//
+	// Migrations that are held back are retried until they can be started.
+	MigrationLimits *MigrationLimitsConfig `json:"migrationLimits,omitempty"`
+
+	// MigrationVictimPolicy selects which VMs are migrated first when a node is above the
+	// watermark. If empty, defaults to MigrationVictimLeastRecentlyMigrated.
+	//
+	// Whatever the policy, VMs with api.AnnotationNeverMigrate are never migrated, and VMs with a
+	// lower api.AnnotationMigrationCost are migrated before those with a higher one.
+	MigrationVictimPolicy MigrationVictimPolicy `json:"migrationVictimPolicy,omitempty" schema:"enum=smallestFirst|largestFirst|leastRecentlyMigrated|lowestPriority"`
+
+	// MigrationBlackoutWindows gives the recurring windows of time during which the plugin must not
+	// trigger live migrations (e.g., known peak-traffic hours).
+	//
+	// During a window, nodes above the watermark only have the migrations that would be triggered
+	// logged, and counted in a metric for alerting. Migrations that were requested before the
+	// window started, but not yet created, are canceled.
+	MigrationBlackoutWindows []MigrationBlackoutWindowConfig `json:"migrationBlackoutWindows,omitempty"`
+
+	// HonorPodTopology, if true, makes Filter and Score take into account the topology spread
+	// constraints and required pod affinity / anti-affinity of incoming pods, evaluated against
+	// the plugin's local state.
+	//
+	// "DoNotSchedule" constraints and required (anti-)affinity reject nodes in Filter, while
+	// "ScheduleAnyway" constraints reduce nodes' scores.
+	HonorPodTopology bool `json:"honorPodTopology,omitempty"`
+
+	// HonorScaleDownTaints, if true, makes the plugin avoid nodes that cluster-autoscaler is
+	// removing, or may remove soon.
+	//
+	// Nodes with the "ToBeDeletedByClusterAutoscaler" taint are rejected in Filter, and their VMs
+	// are all migrated away before the node is drained. Nodes with the
+	// "DeletionCandidateOfClusterAutoscaler" taint are given the lowest score, so they're only used
+	// if there's nowhere else.
+	HonorScaleDownTaints bool `json:"honorScaleDownTaints,omitempty"`
+
+	// NodePressureDownscale, if not nil, asks the autoscaler-agents of the least active VMs on
+	// nodes with MemoryPressure to downscale their VMs a little, in the hope of avoiding kubelet
+	// evicting whole VMs.
+	NodePressureDownscale *NodePressureDownscaleConfig `json:"nodePressureDownscale,omitempty"`
+
+	// TenantFairness, if not nil, shares each node's capacity between tenants by weighted max-min
+	// fairness when there isn't enough for all of the upscaling that's been requested, instead of
+	// approving upscaling first-come-first-served.
+	//
+	// This prevents a single noisy tenant from taking all of the headroom on a node.
+	TenantFairness *TenantFairnessConfig `json:"tenantFairness,omitempty"`
+
+	// APIHealth, if not nil, enables detecting when the API server is degraded, during which we
+	// defer non-critical writes (like adding missing annotations to VMs) so that the API server's
+	// capacity is left for approving resources and creating migrations.
+	APIHealth *util.APIHealthConfig `json:"apiHealth,omitempty"`
+
+	// ShadowMode, if true, makes this replica a "shadow" that evaluates this config against the
+	// decisions made by the scheduler that's actually running, without acting on anything. Diverging
+	// decisions are logged and counted, so that changes to scoring or the watermark can be tried
+	// out on real traffic before rolling them out.
+	//
+	// Shadow replicas must use a different kube-scheduler profile name from the real scheduler, and
+	// not take part in its leader election. SchedulerName must be the same as the real scheduler's.
+	ShadowMode bool `json:"shadowMode,omitempty"`
+
+	// DryRun, if true, makes the plugin compute all of its decisions as usual, but only log and
+	// count the ones that would change something: pods are never rejected by Filter, and
+	// VirtualMachines and migrations are never created, patched, or deleted.
+	//
+	// Unlike ShadowMode, the replica is the scheduler that's actually running, so this can be used
+	// to evaluate new settings in a cluster where VMs aren't yet managed by the plugin.
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// computeUnits is read from ComputeUnitConfigPath by ReadConfig. It is nil if
+	// ComputeUnitConfigPath is empty.
+	computeUnits *api.ComputeUnitConfig
+
+	// path is the file the config was read from by ReadConfig, which is watched for changes so
+	// that the config can be reloaded. It is empty if the config wasn't read from a file.
+	path string
+
+	// envOverrides are the names of the environment variables that were applied to the config by
+	// ReadConfig. Refer to config_env.go for more.
+	envOverrides []string
+}
+
+// UpscaleRateLimitConfig defines the maximum rate at which new capacity is granted for upscaling.
+//
+// When the budget doesn't cover all of a VM's upscaling, only part of it is granted -- in multiples
+// of the VM's scaling unit -- and the rest is granted once the budget has refilled.
+type UpscaleRateLimitConfig struct {
+	// CPUPerSecond is the amount of CPU that can be granted per second, cluster-wide.
+	CPUPerSecond vmv1.MilliCPU `json:"cpuPerSecond" schema:"required"`
+	// MemoryPerSecond is the amount of memory that can be granted per second, cluster-wide.
+	MemoryPerSecond api.Bytes `json:"memoryPerSecond" schema:"required"`
+	// BurstSeconds gives the number of seconds' worth of budget that can be accumulated while no
+	// upscaling is happening.
+	BurstSeconds int `json:"burstSeconds" schema:"minimum=1,required"`
+}
+
+// FederationConfig defines how the plugin reports to the central federation service.
+type FederationConfig struct {
+	// Endpoint is the URL that each api.FederationClusterSummary is POSTed to -- e.g. the
+	// /summaries endpoint of a neonvm-controller with -federation-bind-address set.
+	Endpoint string `json:"endpoint" schema:"minLength=1,required"`
+	// ClusterName is the name of this cluster, as reported to the federation service.
+	ClusterName string `json:"clusterName" schema:"minLength=1,required"`
+	// PushIntervalSeconds sets the number of seconds to wait between pushing each summary.
+	PushIntervalSeconds int `json:"pushIntervalSeconds" schema:"minimum=1,required"`
+	// RequestTimeoutSeconds gives the timeout duration, in seconds, for each push.
+	RequestTimeoutSeconds int `json:"requestTimeoutSeconds" schema:"minimum=1,required"`
+}
+
+// HealthReportConfig defines where and how often the plugin writes its api.FleetHealth.
+type HealthReportConfig struct {
+	// Namespace and Name give the ConfigMap that the report is written to. It's created if it
+	// doesn't already exist.
+	Namespace string `json:"namespace" schema:"minLength=1,required"`
+	Name      string `json:"name" schema:"minLength=1,required"`
+	// IntervalSeconds sets the number of seconds to wait between writing each report.
+	IntervalSeconds int `json:"intervalSeconds" schema:"minimum=1,required"`
+	// StuckMigrationSeconds is the number of seconds after which a migration created by the plugin
+	// that hasn't finished is reported as stuck.
+	StuckMigrationSeconds int `json:"stuckMigrationSeconds" schema:"minimum=1,required"`
+}
+
+// CapacityForecastConfig defines how the plugin forecasts the cluster's remaining capacity.
+type CapacityForecastConfig struct {
+	// IntervalSeconds is the number of seconds between each update of the forecast.
+	IntervalSeconds int `json:"intervalSeconds" schema:"minimum=1,required"`
+	// GrowthWindowSeconds is the number of seconds over which the growth rate of the resources
+	// reserved in the cluster is measured. It must be greater than IntervalSeconds.
+	GrowthWindowSeconds int `json:"growthWindowSeconds" schema:"minimum=1,required"`
+	// Shapes gives the sizes of VM to report the number that still fit for.
+	Shapes []CapacityForecastShape `json:"shapes,omitempty"`
+}
+
+// CapacityForecastShape is a size of VM that the number that still fit in the cluster is reported
+// for.
+type CapacityForecastShape struct {
+	// Name is the value of the "shape" label on the metric. Each shape's name must be unique.
+	Name      string        `json:"name" schema:"minLength=1,required"`
+	Resources api.Resources `json:"resources" schema:"required"`
+}
+
+// CordonWatermarkConfig defines when nodes are cordoned for being too full, and when they're
+// uncordoned again.
+type CordonWatermarkConfig struct {
+	// Cordon is the fraction of a node's CPU or memory at or above which the node is cordoned. It
+	// must be greater than the watermarks for both resources.
+	Cordon float64 `json:"cordon" schema:"exclusiveMinimum=0,maximum=1,required"`
+	// Uncordon is the fraction that both CPU and memory must be below before a node that we
+	// cordoned is uncordoned. It must be less than Cordon, so that nodes near the threshold aren't
+	// repeatedly cordoned and uncordoned.
+	Uncordon float64 `json:"uncordon" schema:"exclusiveMinimum=0,maximum=1,required"`
+	// AnnotateOnly, if true, makes the plugin only annotate nodes instead of cordoning them, for
+	// other tools to act on.
+	AnnotateOnly bool `json:"annotateOnly,omitempty"`
+	// IntervalSeconds is the number of seconds between checking nodes' usage.
+	IntervalSeconds int `json:"intervalSeconds" schema:"minimum=1,required"`
+}
+
+// DefragmentationConfig defines which nodes are emptied for defragmentation, and how quickly.
+type DefragmentationConfig struct {
+	// IntervalSeconds is the number of seconds between each round of choosing nodes to empty.
+	IntervalSeconds int `json:"intervalSeconds" schema:"minimum=1,required"`
+	// TargetUtilization is the fraction of a node's CPU and memory that both must be below for the
+	// node to be emptied. It must be less than the watermarks for both resources.
+	TargetUtilization float64 `json:"targetUtilization" schema:"exclusiveMinimum=0,maximum=1,required"`
+	// MaxMigrationsPerCycle is the maximum number of migrations to start in each round. Nodes with
+	// more VMs than this are never emptied.
+	MaxMigrationsPerCycle int `json:"maxMigrationsPerCycle" schema:"minimum=1,required"`
+	// HoldSeconds is how long pods are kept off a node after we start emptying it, so that
+	// cluster-autoscaler has time to remove it.
+	HoldSeconds int `json:"holdSeconds" schema:"minimum=1,required"`
+}
+
+// DecisionLogConfig defines where the plugin records its scheduling decisions.
+//
+// At least one destination must be set.
+type DecisionLogConfig struct {
+	// File, if not nil, enables writing each decision as a line of JSON to a file that's rotated
+	// once it gets too big.
+	File *DecisionLogFileConfig `json:"file,omitempty"`
+	// Events, if true, enables emitting a Kubernetes Event on each pod with a summary of the
+	// decision.
+	Events bool `json:"events,omitempty"`
+}
+
+// DecisionLogFileConfig defines the file that scheduling decisions are written to.
+type DecisionLogFileConfig struct {
+	// Path is the path of the file. Rotated files are kept in the same directory.
+	Path string `json:"path" schema:"minLength=1,required"`
+	// MaxSizeMB is the size, in megabytes, at which the file is rotated.
+	MaxSizeMB int `json:"maxSizeMB" schema:"minimum=1,required"`
+	// MaxBackups is the number of rotated files to keep. If zero, all are kept.
+	MaxBackups int `json:"maxBackups" schema:"minimum=0"`
+	// Compress, if true, gzips rotated files.
+	Compress bool `json:"compress,omitempty"`
+}
+
+// DebugServerConfig defines how the plugin serves its internal state for debugging.
+type DebugServerConfig struct {
+	// Port is the port to serve on.
+	Port uint16 `json:"port" schema:"minimum=1,required"`
+	// TimeoutSeconds gives the maximum duration, in seconds, that we allow for a request to dump
+	// the internal state.
+	TimeoutSeconds int `json:"timeoutSeconds" schema:"minimum=1,required"`
+	// BearerTokenPath, if not empty, is the path to a file containing a token that requests must
+	// provide in their "Authorization: Bearer <token>" header -- typically from a mounted Secret.
+	//
+	// The file is read on each request, so that the token can be rotated without restarting.
+	//
+	// The /drain, /reevaluate, and /simulate endpoints are only served when this is set.
+	BearerTokenPath string `json:"bearerTokenPath,omitempty"`
+}
+
+// StateHandoffConfig defines where and how often the plugin saves a snapshot of its state, for the
+// next instance to restore on startup.
+type StateHandoffConfig struct {
+	// Namespace and Name give the ConfigMap that the snapshot is written to. It's created if it
+	// doesn't already exist.
+	Namespace string `json:"namespace" schema:"minLength=1,required"`
+	Name      string `json:"name" schema:"minLength=1,required"`
+	// IntervalSeconds sets the number of seconds between writing each snapshot, in addition to the
+	// final snapshot written on shutdown.
+	//
+	// During a rolling update, the new instance may start before the old one has shut down, so the
+	// periodic snapshots are what bound how much state can be lost.
+	IntervalSeconds int `json:"intervalSeconds" schema:"minimum=1,required"`
+	// MaxAgeSeconds is the maximum age of a snapshot, in seconds, for it to be restored on startup.
+	// Older snapshots are ignored.
+	MaxAgeSeconds int `json:"maxAgeSeconds" schema:"minimum=1,required"`
+}
+
+// LeaderElectionConfig defines how the plugin follows the scheduler's leader election.
+type LeaderElectionConfig struct {
+	// LeaseNamespace and LeaseName give the Lease used for the scheduler's leader election, i.e.
+	// the leaderElection.resourceNamespace and leaderElection.resourceName from its
+	// KubeSchedulerConfiguration.
+	LeaseNamespace string `json:"leaseNamespace" schema:"minLength=1,required"`
+	LeaseName      string `json:"leaseName" schema:"minLength=1,required"`
+	// CheckIntervalSeconds sets the number of seconds between checking which replica is the
+	// leader.
+	CheckIntervalSeconds int `json:"checkIntervalSeconds" schema:"minimum=1,required"`
+}
+
+// PreemptionConfig defines how the plugin may make room for VM pods that don't fit on any node.
+type PreemptionConfig struct {
+	// MaxVictims is the maximum number of pods that may be evicted or migrated from a node to make
+	// room for a single VM pod.
+	MaxVictims int `json:"maxVictims" schema:"minimum=1,required"`
+
+	// PrePullImages, if true, sets the VM's "vm.neon.tech/prepull-nodes" annotation to the
+	// nominated node, so that neonvm-controller starts pulling the VM's images onto the node while
+	// the victims are being moved off it.
+	PrePullImages bool `json:"prePullImages,omitempty"`
+}
+
+// PriorityHandlingConfig defines which VM pods are high-priority.
+type PriorityHandlingConfig struct {
+	// HighPriorityThreshold is the priority at or above which VM pods are high-priority, and so
+	// may be placed onto nodes above their watermark. VM pods without a PriorityClass have a
+	// priority of zero.
+	HighPriorityThreshold int32 `json:"highPriorityThreshold"`
+}
+
+// SystemPodAccountingMode is a policy for counting DaemonSet and static pods toward node usage.
+type SystemPodAccountingMode string
+
+const (
+	// SystemPodAccountingRequests counts the pods' resource requests, like any other pod.
+	SystemPodAccountingRequests SystemPodAccountingMode = "requests"
+	// SystemPodAccountingMeasured counts the pods' actual usage, as reported by metrics-server.
+	// Until the usage has been measured, requests are used instead.
+	SystemPodAccountingMeasured SystemPodAccountingMode = "measured"
+	// SystemPodAccountingFixedReserve ignores the pods entirely, and instead reduces each node's
+	// allocatable resources by a fixed amount.
+	SystemPodAccountingFixedReserve SystemPodAccountingMode = "fixedReserve"
+	// SystemPodAccountingExclude ignores the pods entirely, for clusters where they're already
+	// accounted for by the kubelet's system-reserved (and so, the node's allocatable resources).
+	SystemPodAccountingExclude SystemPodAccountingMode = "exclude"
+	// SystemPodAccountingCapped counts the pods' resource requests, but only up to a fixed amount
+	// for each pod.
+	SystemPodAccountingCapped SystemPodAccountingMode = "capped"
+)
+
+// SystemPodAccountingConfig defines how DaemonSet and static pods are counted toward node usage.
+type SystemPodAccountingConfig struct {
+	// Mode selects the accounting policy.
+	Mode SystemPodAccountingMode `json:"mode" schema:"enum=requests|measured|fixedReserve|exclude|capped,required"`
+	// FixedReserve gives the resources subtracted from each node's allocatable resources.
+	//
+	// Required if Mode is "fixedReserve", and must be empty otherwise.
+	FixedReserve *api.Resources `json:"fixedReserve,omitempty"`
+	// Cap gives the most resources counted for each pod.
+	//
+	// Required if Mode is "capped", and must be empty otherwise.
+	Cap *api.Resources `json:"cap,omitempty"`
+	// MeasureIntervalSeconds sets the number of seconds between fetching pod usage from
+	// metrics-server.
+	//
+	// Required if Mode is "measured", and must be zero otherwise.
+	MeasureIntervalSeconds int `json:"measureIntervalSeconds,omitempty" schema:"minimum=0"`
+}
+
+// VMsPerNodeConfig defines the maximum number of VMs on each node.
+//
+// The limit is enforced in the Filter stage, which also applies to the target pods of live
+// migrations.
+type VMsPerNodeConfig struct {
+	// Max is the maximum number of VMs on nodes that don't match any of the Overrides. If zero,
+	// there is no limit for those nodes.
+	Max int `json:"max" schema:"minimum=0"`
+	// Overrides gives alternate limits for nodes matching a label selector. The first override
+	// that matches a node is used.
+	Overrides []VMsPerNodeOverride `json:"overrides,omitempty"`
+}
+
+// VMsPerNodeOverride is a limit on the number of VMs that applies to a subset of nodes.
+type VMsPerNodeOverride struct {
+	// NodeSelector gives the labels that a node must have for the override to apply.
+	NodeSelector map[string]string `json:"nodeSelector" schema:"minProperties=1,required"`
+	// Max is the maximum number of VMs on matching nodes. If zero, there is no limit.
+	Max int `json:"max" schema:"minimum=0"`
+}
+
+// WatermarkOverride is an alternate watermark that applies to VMs in a subset of namespaces.
+type WatermarkOverride struct {
+	// NamespaceSelector gives the labels that a namespace must have for the override to apply.
+	NamespaceSelector map[string]string `json:"namespaceSelector" schema:"minProperties=1,required"`
+	// Watermark is the watermark for nodes with VMs in matching namespaces. Refer to
+	// Config.Watermark for more.
+	Watermark float64 `json:"watermark" schema:"exclusiveMinimum=0,maximum=1,required"`
+}
+
+// NetworkBandwidthConfig defines how each node's network bandwidth is determined, and how it's
+// used for placement.
+//
+// Bandwidths are resource quantities in bits per second (e.g. "25G"). Nodes where the bandwidth
+// can't be determined are not limited.
+type NetworkBandwidthConfig struct {
+	// NodeLabel, if not empty, is the node label that gives the node's bandwidth. It takes
+	// precedence over InstanceTypes.
+	NodeLabel string `json:"nodeLabel,omitempty"`
+	// InstanceTypes gives the bandwidth of nodes by the value of their
+	// "node.kubernetes.io/instance-type" label.
+	InstanceTypes map[string]resource.Quantity `json:"instanceTypes,omitempty"`
+	// ScoreWeight is a fraction from 0 to 1 giving how much a node's score is reduced by the
+	// fraction of its bandwidth that would be in use after adding the pod.
+	//
+	// If zero, bandwidth is only used to reject nodes that don't have enough of it.
+	ScoreWeight float64 `json:"scoreWeight" schema:"minimum=0,maximum=1"`
+}
+
+// ExtendedResourceConfig defines how an extended resource is tracked.
+type ExtendedResourceConfig struct {
+	// Name is the name of the resource, as used in pods' requests and nodes' allocatable
+	// resources.
+	Name string `json:"name" schema:"minLength=1,required"`
+	// Watermark is the fraction of the node's allocatable amount of the resource, above which
+	// nodes are scored lower for pods requesting it -- down to the minimum score when it's fully
+	// used.
+	Watermark float64 `json:"watermark" schema:"exclusiveMinimum=0,maximum=1,required"`
+}
+
+// VMAnnotationResourceConfig defines the resource required by VMs with a particular annotation.
+type VMAnnotationResourceConfig struct {
+	// Annotation is the annotation on the VM, as propagated to its runner pod.
+	Annotation string `json:"annotation" schema:"minLength=1,required"`
+	// Resource is the name of the resource required. It must be one of the ExtendedResources.
+	Resource string `json:"resource" schema:"minLength=1,required"`
+	// Amount, if not nil, is the amount of the resource required by VMs with the annotation,
+	// regardless of its value. Otherwise, the annotation's value is parsed as a resource quantity
+	// (e.g. "4Gi").
+	Amount *resource.Quantity `json:"amount,omitempty"`
+}
+
+// UsageBlendingConfig defines how much of each VM's contribution towards the watermark comes from
+// its reported usage, rather than its reserved resources.
+//
+// Each weight is a fraction from 0 to 1, where 0 means only reserved resources are used (i.e., the
+// same as without blending), and 1 means only reported usage is used. VMs that haven't reported
+// usage are counted with their reserved resources.
+type UsageBlendingConfig struct {
+	// CPUUsageWeight is the weight given to the VM's reported CPU usage (from its load average).
+	CPUUsageWeight float64 `json:"cpuUsageWeight" schema:"minimum=0,maximum=1"`
+	// MemoryUsageWeight is the weight given to the VM's reported memory usage.
+	MemoryUsageWeight float64 `json:"memoryUsageWeight" schema:"minimum=0,maximum=1"`
+	// RequeueChangeFraction is the fraction of a VM's reserved CPU or memory by which the blended
+	// amount must change, since its node was last requeued because of the VM's usage, for the node
+	// to be requeued again -- so that its watermark status is re-evaluated without waiting for some
+	// other change on the node.
+	//
+	// If zero, defaults to DefaultUsageBlendingRequeueChangeFraction.
+	RequeueChangeFraction float64 `json:"requeueChangeFraction,omitempty" schema:"minimum=0,maximum=1"`
+}
+
+// MigrationDeferralConfig defines when the migration of a busy VM is deferred.
+//
+// A VM is busy if its pod has the api.AnnotationBusy annotation, or if the load average most
+// recently reported by the autoscaler-agent is at least BusyCPUFraction of the VM's CPU.
+type MigrationDeferralConfig struct {
+	// BusyCPUFraction is the fraction of the VM's reserved CPU that its load average must be at or
+	// above for the VM to be considered busy. If zero, only the annotation is used.
+	BusyCPUFraction float64 `json:"busyCPUFraction" schema:"minimum=0"`
+	// MaxDeferralSeconds is the maximum number of seconds that migration may be deferred for,
+	// after which the VM is migrated regardless of whether it's busy.
+	MaxDeferralSeconds int `json:"maxDeferralSeconds" schema:"minimum=1,required"`
+	// RecheckIntervalSeconds sets how often to check whether a VM is still busy, while its
+	// migration is deferred.
+	RecheckIntervalSeconds int `json:"recheckIntervalSeconds" schema:"minimum=1,required"`
+}
+
+// MigrationVictimPolicy is a policy for choosing which VMs to migrate away from a node. Refer to
+// migration_victims.go for the implementations.
+type MigrationVictimPolicy string
+
+const (
+	// MigrationVictimSmallestFirst migrates the VMs with the least reserved resources first, which
+	// makes each migration quicker, at the cost of needing more of them.
+	MigrationVictimSmallestFirst MigrationVictimPolicy = "smallestFirst"
+	// MigrationVictimLargestFirst migrates the VMs with the most reserved resources first, so that
+	// fewer migrations are needed.
+	MigrationVictimLargestFirst MigrationVictimPolicy = "largestFirst"
+	// MigrationVictimLeastRecentlyMigrated migrates the VMs that were started or last migrated the
+	// longest ago first, so that the same VMs aren't repeatedly migrated.
+	MigrationVictimLeastRecentlyMigrated MigrationVictimPolicy = "leastRecentlyMigrated"
+	// MigrationVictimLowestPriority migrates the VMs whose pods have the lowest priority first.
+	MigrationVictimLowestPriority MigrationVictimPolicy = "lowestPriority"
+)
+
+// MigrationBlackoutWindowConfig defines a recurring window of time during which live migrations
+// must not be triggered.
+type MigrationBlackoutWindowConfig struct {
+	// Name identifies the window in logs and metrics.
+	Name string `json:"name" schema:"minLength=1,required"`
+	// Schedule gives the times that the window starts, as a standard 5-field cron schedule
+	// ("minute hour day-of-month month day-of-week"), e.g. "0 9 * * 1-5" for 9am on weekdays.
+	Schedule string `json:"schedule" schema:"minLength=1,required"`
+	// DurationMinutes is how long the window lasts each time it starts. It must be at most 7 days.
+	DurationMinutes int `json:"durationMinutes" schema:"minimum=1,maximum=10080,required"`
+	// TimeZone is the IANA time zone that Schedule is evaluated in, e.g. "America/New_York". If
+	// empty, defaults to UTC.
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// MigrationLimitsConfig defines the limits on starting live migrations.
+//
+// At least one limit must be set.
+type MigrationLimitsConfig struct {
+	// MaxConcurrent is the maximum number of migrations that may be ongoing at once, cluster-wide.
+	// If zero, there's no limit.
+	MaxConcurrent int `json:"maxConcurrent" schema:"minimum=0"`
+	// MaxConcurrentPerNode is the maximum number of migrations away from each node that may be
+	// ongoing at once. If zero, there's no limit.
+	MaxConcurrentPerNode int `json:"maxConcurrentPerNode" schema:"minimum=0"`
+	// PerMinute is the average number of migrations that may be started per minute, cluster-wide.
+	// If zero, there's no limit.
+	PerMinute float64 `json:"perMinute" schema:"minimum=0"`
+	// Burst is the number of migrations that may be started at once, when none have been started
+	// recently. It must be at least 1 if PerMinute is set.
+	Burst int `json:"burst" schema:"minimum=0"`
+}
+
+// ReconcileWorkerAutoscalingConfig defines how the number of reconcile workers is adjusted.
+//
+// Every AdjustIntervalSeconds, if any item waited in the queue for longer than
+// QueueWaitThresholdMillis, the number of workers is doubled (up to MaxWorkers). Otherwise, if all
+// items waited for less than a quarter of that, the number of workers is reduced by one (down to
+// ReconcileWorkers).
+type ReconcileWorkerAutoscalingConfig struct {
+	// MaxWorkers is the maximum number of reconcile workers.
+	MaxWorkers int `json:"maxWorkers" schema:"minimum=1,required"`
+	// QueueWaitThresholdMillis is the queue wait duration, in milliseconds, above which we should
+	// add more workers.
+	QueueWaitThresholdMillis int `json:"queueWaitThresholdMillis" schema:"minimum=1,required"`
+	// AdjustIntervalSeconds sets how often the number of workers may be changed.
+	AdjustIntervalSeconds int `json:"adjustIntervalSeconds" schema:"minimum=1,required"`
+}
+
+// ReconcilePrioritiesConfig defines the relative weights of each priority class in the reconcile
+// queue.
+//
+// When there are operations waiting in more than one class, each class gets a share of the workers
+// proportional to its weight. A class with nothing waiting doesn't take any share.
+type ReconcilePrioritiesConfig struct {
+	// Deletions is the weight for deletions of any object.
+	Deletions int `json:"deletions" schema:"minimum=1,required"`
+	// Pods is the weight for other changes to Pods and VirtualMachineMigrations -- including
+	// approving changes in VM resources, and retrying patches to VMs.
+	Pods int `json:"pods" schema:"minimum=1,required"`
+	// Nodes is the weight for other changes to Nodes, which are frequently updated by the kubelet.
+	Nodes int `json:"nodes" schema:"minimum=1,required"`
+}
+
+// ReconcileConfig defines the options for reconciling each kind of object.
+//
+// Kinds that aren't set here share the ReconcileWorkers, and use the default backoff.
+type ReconcileConfig struct {
+	Nodes      *ReconcileKindConfig `json:"nodes,omitempty"`
+	Pods       *ReconcileKindConfig `json:"pods,omitempty"`
+	Migrations *ReconcileKindConfig `json:"migrations,omitempty"`
+}
+
+// ReconcileKindConfig defines the options for reconciling a single kind of object.
+type ReconcileKindConfig struct {
+	// Workers, if not zero, gives this kind of object its own reconcile queue with this many
+	// workers, separate from the ReconcileWorkers shared by other kinds.
+	Workers int `json:"workers,omitempty" schema:"minimum=0"`
+	// Backoff, if not nil, sets how long to wait before retrying failed reconcile operations.
+	Backoff *ReconcileBackoffConfig `json:"backoff,omitempty"`
+}
+
+// ReconcileBackoffConfig defines how long to wait before retrying failed reconcile operations.
+//
+// The wait starts at InitialMillis after the first failure, and roughly doubles with each
+// successive failure, up to MaxSeconds.
+type ReconcileBackoffConfig struct {
+	InitialMillis int `json:"initialMillis" schema:"minimum=1,required"`
+	MaxSeconds    int `json:"maxSeconds" schema:"minimum=1,required"`
+	// Jitter is the maximum fraction by which each wait is randomly lengthened or shortened, so
+	// that objects that failed at the same time aren't all retried together.
+	Jitter float64 `json:"jitter,omitempty" schema:"minimum=0,maximum=1"`
+}
+
+// NodePressureDownscaleConfig defines which VMs are asked to downscale when their node is under
+// memory pressure, and by how much.
+//
+// VMs are ranked by the load average most recently reported by their autoscaler-agent, relative to
+// their CPU. VMs that haven't reported usage are never selected.
+type NodePressureDownscaleConfig struct {
+	// MaxVMsPerNode is the maximum number of VMs on each node that are asked to downscale at a
+	// time.
+	MaxVMsPerNode int `json:"maxVMsPerNode" schema:"minimum=1,required"`
+	// DownscaleCUs is the number of Compute Units that each selected VM is asked to downscale by.
+	DownscaleCUs uint16 `json:"downscaleCUs" schema:"minimum=1,required"`
+}
+
+// TenantFairnessConfig defines how VMs are grouped into tenants, and the relative share of each
+// node's capacity that each tenant is entitled to when upscaling is contended.
+//
+// Each tenant is guaranteed its weighted share of the node, and any capacity left unused by
+// tenants asking for less than their share is divided between the rest, again by weight.
+type TenantFairnessConfig struct {
+	// TenantLabel is the label on VM pods whose value gives the VM's tenant. All VMs without the
+	// label are treated as a single tenant.
+	TenantLabel string `json:"tenantLabel" schema:"minLength=1,required"`
+	// DefaultWeight is the weight of tenants that aren't listed in Weights.
+	DefaultWeight int `json:"defaultWeight" schema:"minimum=1,required"`
+	// Weights gives the weight of specific tenants, overriding DefaultWeight.
+	Weights map[string]int `json:"weights,omitempty"`
 }
 
 type ScoringConfig struct {
@@ -88,21 +875,229 @@ type ScoringConfig struct {
 	// score at the midpoint, which will have the maximum.
 	//
 	// This corresponds to y₀ in the desmos link above.
-	MinUsageScore float64 `json:"minUsageScore"`
+	MinUsageScore float64 `json:"minUsageScore" schema:"minimum=0,maximum=1"`
 	// MaxUsageScore gives the ratio of the score at the maximum usage (i.e. full) relative to the
 	// score at the midpoint, which will have the maximum.
 	//
 	// This corresponds to y₁ in the desmos link above.
-	MaxUsageScore float64 `json:"maxUsageScore"`
+	MaxUsageScore float64 `json:"maxUsageScore" schema:"minimum=0,maximum=1"`
 	// ScorePeak gives the fraction at which the "target" or highest score should be, with the score
 	// sloping down on either side towards MinUsageScore at 0 and MaxUsageScore at 1.
 	//
 	// This corresponds to xₚ in the desmos link.
-	ScorePeak float64 `json:"scorePeak"`
+	ScorePeak float64 `json:"scorePeak" schema:"minimum=0,maximum=1"`
+
+	// Strategy selects how nodes are scored, based on how full they'd be with the pod. If empty,
+	// defaults to ScoringStrategyPeak.
+	//
+	// MinUsageScore, MaxUsageScore, ScorePeak, and ScoringOverrides are only used by the "peak"
+	// strategy. ResourceWeights is only used by "leastAllocatedWeighted".
+	Strategy ScoringStrategyName `json:"strategy,omitempty" schema:"enum=peak|binpack|spread|leastAllocatedWeighted"`
+
+	// ResourceWeights gives the relative importance of CPU and memory for the
+	// "leastAllocatedWeighted" strategy. If nil, they're weighted equally.
+	ResourceWeights *ScoringResourceWeights `json:"resourceWeights,omitempty"`
+
+	// ProjectedUsageFactor, if not zero, scores nodes by their projected usage instead of what's
+	// currently reserved, assuming that each autoscaling VM on the node (including the one being
+	// scheduled) scales up to this fraction of its maximum size. VMs already larger than that are
+	// counted at their current size.
+	//
+	// This accounts for future growth when placing VMs that start small but can scale up a lot.
+	// Refer to projected_usage.go for more.
+	ProjectedUsageFactor float64 `json:"projectedUsageFactor,omitempty" schema:"minimum=0,maximum=1"`
+
+	// TopologySpread, if not nil, lowers the score of nodes in topology domains (e.g. zones) that
+	// already have more of the tenant's VMs than others, so that each tenant's VMs are spread out
+	// instead of concentrated in a single domain.
+	//
+	// This applies regardless of the Strategy.
+	//
+	// Unlike the rest of the scoring config, this can't be changed by reloading the config, because
+	// it determines whether pod labels are stored.
+	TopologySpread *TopologySpreadScoringConfig `json:"topologySpread,omitempty"`
+
+	// Normalization, if not nil, rescales the scores of the candidate nodes for each pod to cover
+	// the full range of scores. Otherwise, the scores are often clustered in a narrow band, so that
+	// the differences between nodes are lost once they're combined with other plugins' scores.
+	//
+	// Normalization is applied before Randomize.
+	Normalization *ScoreNormalizationConfig `json:"normalization,omitempty"`
 
 	// Randomize, if true, will cause the scheduler to score a node with a random number in the
 	// range [minScore + 1, trueScore], instead of the trueScore.
-	Randomize bool
+	Randomize bool `json:"randomize"`
+
+	// RandomSeed, if not nil, seeds the random numbers used by Randomize, so that the sequence of
+	// scores can be reproduced when analyzing scheduling decisions. Otherwise, the seed is based on
+	// the time that the scheduler started.
+	//
+	// The sequence is restarted whenever the seed is changed by reloading the config.
+	RandomSeed *int64 `json:"randomSeed,omitempty"`
+
+	// RandomJitterFraction, if not zero, limits how much Randomize can lower a node's score, as a
+	// fraction of the trueScore: the random score is picked from the range
+	// [trueScore × (1 - RandomJitterFraction), trueScore] instead.
+	RandomJitterFraction float64 `json:"randomJitterFraction,omitempty" schema:"minimum=0,maximum=1"`
+
+	// ScoringOverrides gives alternate values of MinUsageScore, MaxUsageScore, and ScorePeak for
+	// nodes matching a label selector (e.g. on "eks.amazonaws.com/nodegroup"), so that packing can
+	// be biased differently in each pool of nodes.
+	//
+	// The selectors must not overlap: each node may match at most one override.
+	ScoringOverrides []ScoringOverride `json:"scoringOverrides,omitempty"`
+}
+
+// ScoringStrategyName is the name of a way of scoring nodes. Refer to scoring.go for the
+// implementations.
+type ScoringStrategyName string
+
+const (
+	// ScoringStrategyPeak gives the highest score to nodes at ScorePeak, sloping down towards
+	// MinUsageScore when empty and MaxUsageScore when full.
+	ScoringStrategyPeak ScoringStrategyName = "peak"
+	// ScoringStrategyBinpack gives higher scores to fuller nodes.
+	ScoringStrategyBinpack ScoringStrategyName = "binpack"
+	// ScoringStrategySpread gives higher scores to emptier nodes.
+	ScoringStrategySpread ScoringStrategyName = "spread"
+	// ScoringStrategyLeastAllocatedWeighted gives higher scores to emptier nodes, like
+	// ScoringStrategySpread, but with CPU and memory averaged according to ResourceWeights rather
+	// than taking the fuller of the two.
+	ScoringStrategyLeastAllocatedWeighted ScoringStrategyName = "leastAllocatedWeighted"
+)
+
+// ScoreNormalizationFunction is the name of a way of spreading out the scores of the candidate nodes
+// for a pod. Refer to normalizeScores for the implementations.
+type ScoreNormalizationFunction string
+
+const (
+	// ScoreNormalizationLinear maps the range of the nodes' scores evenly onto the full range.
+	ScoreNormalizationLinear ScoreNormalizationFunction = "linear"
+	// ScoreNormalizationExponential maps the range of the nodes' scores onto the full range along an
+	// exponential curve, so that the differences between the best nodes are widened the most.
+	ScoreNormalizationExponential ScoreNormalizationFunction = "exponential"
+)
+
+// ScoreNormalizationConfig defines how the scores of the candidate nodes for a pod are spread out.
+type ScoreNormalizationConfig struct {
+	Function ScoreNormalizationFunction `json:"function" schema:"enum=linear|exponential,required"`
+	// Steepness sets the curve of the "exponential" function: higher values favor the best nodes
+	// more strongly.
+	//
+	// Required if Function is "exponential", and must be zero otherwise.
+	Steepness float64 `json:"steepness,omitempty" schema:"minimum=0"`
+}
+
+// ScoringResourceWeights gives the relative importance of each resource when scoring.
+type ScoringResourceWeights struct {
+	CPU    float64 `json:"cpu" schema:"minimum=0"`
+	Memory float64 `json:"memory" schema:"minimum=0"`
+}
+
+// TopologySpreadScoringConfig defines how VMs are grouped into tenants, and how strongly each
+// tenant's VMs are spread across the values of each node topology label.
+//
+// For each label, the node's score is reduced according to how many more of the tenant's VMs are
+// in the node's domain than in the domain with the fewest -- i.e. the skew that placing the VM there
+// would create. The score is divided by (1 + Σ weight × excess).
+type TopologySpreadScoringConfig struct {
+	// TenantLabel is the label on VM pods whose value gives the VM's tenant. VMs without the label
+	// are not spread.
+	TenantLabel string `json:"tenantLabel" schema:"minLength=1,required"`
+	// Weights maps each node label to spread across (e.g. "topology.kubernetes.io/zone") to how
+	// strongly VMs should be spread across its values. Nodes without the label are not penalized.
+	Weights map[string]float64 `json:"weights" schema:"minProperties=1,required"`
+}
+
+// ScoringOverride is an alternate scoring curve that applies to a subset of nodes.
+//
+// Refer to ScoringConfig for the meaning of each field.
+type ScoringOverride struct {
+	// NodeSelector gives the labels that a node must have for the override to apply.
+	NodeSelector map[string]string `json:"nodeSelector" schema:"minProperties=1,required"`
+
+	MinUsageScore float64 `json:"minUsageScore" schema:"minimum=0,maximum=1"`
+	MaxUsageScore float64 `json:"maxUsageScore" schema:"minimum=0,maximum=1"`
+	ScorePeak     float64 `json:"scorePeak" schema:"minimum=0,maximum=1"`
+}
+
+/////////////////////
+// CONFIG DEFAULTS //
+/////////////////////
+
+// Default values for fields in Config that are used when they're not set.
+//
+// These match the values we've been running with in production.
+const (
+	DefaultWatermark                          = 0.9
+	DefaultSchedulerName                      = "autoscale-scheduler"
+	DefaultReconcileWorkers                   = 16
+	DefaultLogSuccessiveFailuresThreshold     = 10
+	DefaultStartupEventHandlingTimeoutSeconds = 15
+	DefaultK8sCRUDTimeoutSeconds              = 1
+	DefaultPatchRetryWaitSeconds              = 1
+
+	DefaultUsageBlendingRequeueChangeFraction = 0.1
+)
+
+// DefaultScoringConfig returns the scoring curve that's used if the config doesn't set one.
+func DefaultScoringConfig() ScoringConfig {
+	return ScoringConfig{
+		MinUsageScore:        0.5,
+		MaxUsageScore:        0,
+		ScorePeak:            0.8,
+		Strategy:             "", // i.e. ScoringStrategyPeak
+		ResourceWeights:      nil,
+		ProjectedUsageFactor: 0,
+		TopologySpread:       nil,
+		Randomize:            false,
+		RandomSeed:           nil,
+		RandomJitterFraction: 0,
+		ScoringOverrides:     nil,
+	}
+}
+
+// Default fills the fields in the config that aren't set with their default values.
+//
+// Fields are only defaulted if their zero value would be rejected by validation, so that explicitly
+// setting a field to zero (where that's allowed) keeps its meaning.
+func (c *Config) Default() {
+	c.Scoring.Default()
+
+	if c.Watermark == 0 {
+		c.Watermark = DefaultWatermark
+	}
+	if c.SchedulerName == "" {
+		c.SchedulerName = DefaultSchedulerName
+	}
+	if c.ReconcileWorkers == 0 {
+		c.ReconcileWorkers = DefaultReconcileWorkers
+	}
+	if c.LogSuccessiveFailuresThreshold == 0 {
+		c.LogSuccessiveFailuresThreshold = DefaultLogSuccessiveFailuresThreshold
+	}
+	if c.StartupEventHandlingTimeoutSeconds == 0 {
+		c.StartupEventHandlingTimeoutSeconds = DefaultStartupEventHandlingTimeoutSeconds
+	}
+	if c.K8sCRUDTimeoutSeconds == 0 {
+		c.K8sCRUDTimeoutSeconds = DefaultK8sCRUDTimeoutSeconds
+	}
+	if c.PatchRetryWaitSeconds == 0 {
+		c.PatchRetryWaitSeconds = DefaultPatchRetryWaitSeconds
+	}
+}
+
+// Default sets the scoring curve to the one from DefaultScoringConfig, if it's not set.
+//
+// Zero is a valid value for each field on its own, so the curve is only considered unset if all of
+// MinUsageScore, MaxUsageScore, and ScorePeak are zero.
+func (c *ScoringConfig) Default() {
+	if c.MinUsageScore == 0 && c.MaxUsageScore == 0 && c.ScorePeak == 0 {
+		defaults := DefaultScoringConfig()
+		c.MinUsageScore = defaults.MinUsageScore
+		c.MaxUsageScore = defaults.MaxUsageScore
+		c.ScorePeak = defaults.ScorePeak
+	}
 }
 
 ///////////////////////
@@ -119,10 +1114,34 @@ func (c *Config) validate() (string, error) {
 		return "schedulerName", errors.New("string cannot be empty")
 	}
 
+	for i, pattern := range c.IgnoredNamespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Sprintf("ignoredNamespaces[%d]", i), fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+
 	if c.ReconcileWorkers <= 0 {
 		return "reconcileWorkers", errors.New("value must be > 0")
 	}
 
+	if c.ReconcileWorkerAutoscaling != nil {
+		if path, err := c.ReconcileWorkerAutoscaling.validate(c.ReconcileWorkers); err != nil {
+			return fmt.Sprintf("reconcileWorkerAutoscaling.%s", path), err
+		}
+	}
+
+	if c.ReconcilePriorities != nil {
+		if path, err := c.ReconcilePriorities.validate(); err != nil {
+			return fmt.Sprintf("reconcilePriorities.%s", path), err
+		}
+	}
+
+	if c.Reconcile != nil {
+		if path, err := c.Reconcile.validate(); err != nil {
+			return fmt.Sprintf("reconcile.%s", path), err
+		}
+	}
+
 	if c.LogSuccessiveFailuresThreshold <= 0 {
 		return "logSuccessiveFailuresThreshold", errors.New("value must be > 0")
 	}
@@ -139,58 +1158,1095 @@ func (c *Config) validate() (string, error) {
 		return "patchRetryWaitSeconds", errors.New("value must be > 0")
 	}
 
+	if c.DryRun && c.ShadowMode {
+		return "dryRun", errors.New("cannot be enabled at the same time as shadowMode")
+	}
+
+	if c.PatchMaxAttempts < 0 {
+		return "patchMaxAttempts", errors.New("value must be >= 0")
+	}
+
+	if c.WatchStaleTimeoutSeconds < 0 {
+		return "watchStaleTimeoutSeconds", errors.New("value must be >= 0")
+	}
+
+	if c.ReservationTTLSeconds < 0 {
+		return "reservationTTLSeconds", errors.New("value must be >= 0")
+	}
+
+	if c.UnboundReservationTTLSeconds < 0 {
+		return "unboundReservationTTLSeconds", errors.New("value must be >= 0")
+	}
+
 	if c.Watermark <= 0.0 {
 		return "watermark", errors.New("value must be > 0")
 	} else if c.Watermark > 1.0 {
 		return "watermark", errors.New("value must be <= 1")
 	}
 
-	return "", nil
-}
+	if c.CPUWatermark < 0.0 || c.CPUWatermark > 1.0 {
+		return "cpuWatermark", errors.New("value must be between 0 and 1, inclusive")
+	} else if c.MemoryWatermark < 0.0 || c.MemoryWatermark > 1.0 {
+		return "memoryWatermark", errors.New("value must be between 0 and 1, inclusive")
+	}
 
-func (c *ScoringConfig) validate() (string, error) {
-	if c.MinUsageScore < 0 || c.MinUsageScore > 1 {
-		return "minUsageScore", errors.New("value must be between 0 and 1, inclusive")
-	} else if c.MaxUsageScore < 0 || c.MaxUsageScore > 1 {
+	if c.WatermarkHysteresis < 0.0 {
+		return "watermarkHysteresis", errors.New("value must be >= 0")
+	} else if lowest := min(c.cpuWatermark(), c.memWatermark()); c.WatermarkHysteresis >= lowest {
+		return "watermarkHysteresis", fmt.Errorf("value must be < watermark (%g)", lowest)
+	}
+
+	for i, o := range c.WatermarkOverrides {
+		if len(o.NamespaceSelector) == 0 {
+			return fmt.Sprintf("watermarkOverrides[%d].namespaceSelector", i), errors.New("map cannot be empty")
+		} else if o.Watermark <= 0.0 {
+			return fmt.Sprintf("watermarkOverrides[%d].watermark", i), errors.New("value must be > 0")
+		} else if o.Watermark > 1.0 {
+			return fmt.Sprintf("watermarkOverrides[%d].watermark", i), errors.New("value must be <= 1")
+		}
+	}
+
+	if c.Defragmentation != nil {
+		if path, err := c.Defragmentation.validate(min(c.cpuWatermark(), c.memWatermark())); err != nil {
+			return fmt.Sprintf("defragmentation.%s", path), err
+		}
+	}
+
+	if c.CordonWatermark != nil {
+		if path, err := c.CordonWatermark.validate(max(c.cpuWatermark(), c.memWatermark())); err != nil {
+			return fmt.Sprintf("cordonWatermark.%s", path), err
+		}
+	}
+
+	if c.UpscaleRateLimit != nil {
+		if path, err := c.UpscaleRateLimit.validate(); err != nil {
+			return fmt.Sprintf("upscaleRateLimit.%s", path), err
+		}
+	}
+
+	if c.Federation != nil {
+		if path, err := c.Federation.validate(); err != nil {
+			return fmt.Sprintf("federation.%s", path), err
+		}
+	}
+
+	if c.HealthReport != nil {
+		if path, err := c.HealthReport.validate(); err != nil {
+			return fmt.Sprintf("healthReport.%s", path), err
+		}
+	}
+
+	if c.CapacityForecast != nil {
+		if path, err := c.CapacityForecast.validate(); err != nil {
+			return fmt.Sprintf("capacityForecast.%s", path), err
+		}
+	}
+
+	if c.DecisionLog != nil {
+		if path, err := c.DecisionLog.validate(); err != nil {
+			return fmt.Sprintf("decisionLog.%s", path), err
+		}
+	}
+
+	if c.DebugServer != nil {
+		if path, err := c.DebugServer.validate(); err != nil {
+			return fmt.Sprintf("debugServer.%s", path), err
+		}
+	}
+
+	if c.StateHandoff != nil {
+		if path, err := c.StateHandoff.validate(); err != nil {
+			return fmt.Sprintf("stateHandoff.%s", path), err
+		}
+	}
+
+	if c.LeaderElection != nil {
+		if path, err := c.LeaderElection.validate(); err != nil {
+			return fmt.Sprintf("leaderElection.%s", path), err
+		}
+	}
+
+	if c.Preemption != nil {
+		if path, err := c.Preemption.validate(); err != nil {
+			return fmt.Sprintf("preemption.%s", path), err
+		}
+	}
+
+	if c.SystemPodAccounting != nil {
+		if path, err := c.SystemPodAccounting.validate(); err != nil {
+			return fmt.Sprintf("systemPodAccounting.%s", path), err
+		}
+	}
+
+	if c.VMsPerNode != nil {
+		if path, err := c.VMsPerNode.validate(); err != nil {
+			return fmt.Sprintf("vmsPerNode.%s", path), err
+		}
+	}
+
+	if c.NetworkBandwidth != nil {
+		if path, err := c.NetworkBandwidth.validate(); err != nil {
+			return fmt.Sprintf("networkBandwidth.%s", path), err
+		}
+	}
+
+	seenExtendedResources := make(map[string]struct{})
+	for i, r := range c.ExtendedResources {
+		if r.Name == "" {
+			return fmt.Sprintf("extendedResources[%d].name", i), errors.New("string cannot be empty")
+		} else if name := corev1.ResourceName(r.Name); !v1helper.IsExtendedResourceName(name) && !v1helper.IsHugePageResourceName(name) {
+			return fmt.Sprintf("extendedResources[%d].name", i), errors.New("must be an extended resource or hugepages name")
+		} else if _, ok := seenExtendedResources[r.Name]; ok {
+			return fmt.Sprintf("extendedResources[%d].name", i), fmt.Errorf("duplicate resource %q", r.Name)
+		} else if r.Watermark <= 0.0 {
+			return fmt.Sprintf("extendedResources[%d].watermark", i), errors.New("value must be > 0")
+		} else if r.Watermark > 1.0 {
+			return fmt.Sprintf("extendedResources[%d].watermark", i), errors.New("value must be <= 1")
+		}
+		seenExtendedResources[r.Name] = struct{}{}
+	}
+
+	seenAnnotations := make(map[string]struct{})
+	for i, r := range c.VMAnnotationResources {
+		if r.Annotation == "" {
+			return fmt.Sprintf("vmAnnotationResources[%d].annotation", i), errors.New("string cannot be empty")
+		} else if _, ok := seenAnnotations[r.Annotation]; ok {
+			return fmt.Sprintf("vmAnnotationResources[%d].annotation", i), fmt.Errorf("duplicate annotation %q", r.Annotation)
+		} else if r.Resource == "" {
+			return fmt.Sprintf("vmAnnotationResources[%d].resource", i), errors.New("string cannot be empty")
+		} else if _, ok := seenExtendedResources[r.Resource]; !ok {
+			return fmt.Sprintf("vmAnnotationResources[%d].resource", i), errors.New("must be one of extendedResources")
+		} else if r.Amount != nil && r.Amount.Sign() <= 0 {
+			return fmt.Sprintf("vmAnnotationResources[%d].amount", i), errors.New("value must be > 0")
+		}
+		seenAnnotations[r.Annotation] = struct{}{}
+	}
+
+	if c.UsageBlending != nil {
+		if path, err := c.UsageBlending.validate(); err != nil {
+			return fmt.Sprintf("usageBlending.%s", path), err
+		}
+	}
+
+	seenBlackoutWindows := make(map[string]struct{})
+	for i, w := range c.MigrationBlackoutWindows {
+		if _, ok := seenBlackoutWindows[w.Name]; ok {
+			return fmt.Sprintf("migrationBlackoutWindows[%d].name", i), fmt.Errorf("duplicate window %q", w.Name)
+		}
+		seenBlackoutWindows[w.Name] = struct{}{}
+		if path, err := w.validate(); err != nil {
+			return fmt.Sprintf("migrationBlackoutWindows[%d].%s", i, path), err
+		}
+	}
+
+	if _, ok := migrationVictimPolicies[c.migrationVictimPolicy()]; !ok {
+		return "migrationVictimPolicy", fmt.Errorf("unknown migration victim policy %q", c.MigrationVictimPolicy)
+	}
+
+	if c.MigrationLimits != nil {
+		if path, err := c.MigrationLimits.validate(); err != nil {
+			return fmt.Sprintf("migrationLimits.%s", path), err
+		}
+	}
+
+	if c.MigrationDeferral != nil {
+		if path, err := c.MigrationDeferral.validate(); err != nil {
+			return fmt.Sprintf("migrationDeferral.%s", path), err
+		}
+	}
+
+	if c.NodePressureDownscale != nil {
+		if path, err := c.NodePressureDownscale.validate(); err != nil {
+			return fmt.Sprintf("nodePressureDownscale.%s", path), err
+		}
+	}
+
+	if c.TenantFairness != nil {
+		if path, err := c.TenantFairness.validate(); err != nil {
+			return fmt.Sprintf("tenantFairness.%s", path), err
+		}
+	}
+
+	if c.APIHealth != nil {
+		if path, err := validateAPIHealthConfig(c.APIHealth); err != nil {
+			return fmt.Sprintf("apiHealth.%s", path), err
+		}
+	}
+
+	return "", nil
+}
+
+func (c *UpscaleRateLimitConfig) validate() (string, error) {
+	if c.CPUPerSecond == 0 {
+		return "cpuPerSecond", errors.New("value must be > 0")
+	} else if c.MemoryPerSecond == 0 {
+		return "memoryPerSecond", errors.New("value must be > 0")
+	} else if c.BurstSeconds <= 0 {
+		return "burstSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *FederationConfig) validate() (string, error) {
+	if c.Endpoint == "" {
+		return "endpoint", errors.New("string cannot be empty")
+	} else if c.ClusterName == "" {
+		return "clusterName", errors.New("string cannot be empty")
+	} else if c.PushIntervalSeconds <= 0 {
+		return "pushIntervalSeconds", errors.New("value must be > 0")
+	} else if c.RequestTimeoutSeconds <= 0 {
+		return "requestTimeoutSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *HealthReportConfig) validate() (string, error) {
+	if c.Namespace == "" {
+		return "namespace", errors.New("string cannot be empty")
+	} else if c.Name == "" {
+		return "name", errors.New("string cannot be empty")
+	} else if c.IntervalSeconds <= 0 {
+		return "intervalSeconds", errors.New("value must be > 0")
+	} else if c.StuckMigrationSeconds <= 0 {
+		return "stuckMigrationSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *CapacityForecastConfig) validate() (string, error) {
+	if c.IntervalSeconds <= 0 {
+		return "intervalSeconds", errors.New("value must be > 0")
+	} else if c.GrowthWindowSeconds <= c.IntervalSeconds {
+		return "growthWindowSeconds", errors.New("value must be > intervalSeconds")
+	}
+
+	names := make(map[string]struct{})
+	for i, shape := range c.Shapes {
+		if shape.Name == "" {
+			return fmt.Sprintf("shapes[%d].name", i), errors.New("string cannot be empty")
+		} else if _, ok := names[shape.Name]; ok {
+			return fmt.Sprintf("shapes[%d].name", i), fmt.Errorf("duplicate shape %q", shape.Name)
+		} else if shape.Resources.VCPU == 0 {
+			return fmt.Sprintf("shapes[%d].resources.vCPUs", i), errors.New("value must be > 0")
+		} else if shape.Resources.Mem == 0 {
+			return fmt.Sprintf("shapes[%d].resources.mem", i), errors.New("value must be > 0")
+		}
+		names[shape.Name] = struct{}{}
+	}
+
+	return "", nil
+}
+
+func (c *DebugServerConfig) validate() (string, error) {
+	if c.Port == 0 {
+		return "port", errors.New("value must be > 0")
+	} else if c.TimeoutSeconds <= 0 {
+		return "timeoutSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *StateHandoffConfig) validate() (string, error) {
+	if c.Namespace == "" {
+		return "namespace", errors.New("string cannot be empty")
+	} else if c.Name == "" {
+		return "name", errors.New("string cannot be empty")
+	} else if c.IntervalSeconds <= 0 {
+		return "intervalSeconds", errors.New("value must be > 0")
+	} else if c.MaxAgeSeconds <= 0 {
+		return "maxAgeSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *LeaderElectionConfig) validate() (string, error) {
+	if c.LeaseNamespace == "" {
+		return "leaseNamespace", errors.New("string cannot be empty")
+	} else if c.LeaseName == "" {
+		return "leaseName", errors.New("string cannot be empty")
+	} else if c.CheckIntervalSeconds <= 0 {
+		return "checkIntervalSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *CordonWatermarkConfig) validate(watermark float64) (string, error) {
+	if c.Cordon <= watermark {
+		return "cordon", fmt.Errorf("value must be > watermark (%g)", watermark)
+	} else if c.Cordon > 1.0 {
+		return "cordon", errors.New("value must be <= 1")
+	} else if c.Uncordon <= 0.0 {
+		return "uncordon", errors.New("value must be > 0")
+	} else if c.Uncordon >= c.Cordon {
+		return "uncordon", errors.New("value must be < cordon")
+	} else if c.IntervalSeconds <= 0 {
+		return "intervalSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *DefragmentationConfig) validate(watermark float64) (string, error) {
+	if c.IntervalSeconds <= 0 {
+		return "intervalSeconds", errors.New("value must be > 0")
+	} else if c.TargetUtilization <= 0.0 {
+		return "targetUtilization", errors.New("value must be > 0")
+	} else if c.TargetUtilization >= watermark {
+		return "targetUtilization", fmt.Errorf("value must be < watermark (%g)", watermark)
+	} else if c.MaxMigrationsPerCycle <= 0 {
+		return "maxMigrationsPerCycle", errors.New("value must be > 0")
+	} else if c.HoldSeconds <= 0 {
+		return "holdSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *DecisionLogConfig) validate() (string, error) {
+	if c.File == nil && !c.Events {
+		return "", errors.New("at least one of file or events must be set")
+	}
+
+	if c.File != nil {
+		if c.File.Path == "" {
+			return "file.path", errors.New("string cannot be empty")
+		} else if c.File.MaxSizeMB <= 0 {
+			return "file.maxSizeMB", errors.New("value must be > 0")
+		} else if c.File.MaxBackups < 0 {
+			return "file.maxBackups", errors.New("value must be >= 0")
+		}
+	}
+
+	return "", nil
+}
+
+func (c *PreemptionConfig) validate() (string, error) {
+	if c.MaxVictims <= 0 {
+		return "maxVictims", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *SystemPodAccountingConfig) validate() (string, error) {
+	switch c.Mode {
+	case SystemPodAccountingRequests:
+	case SystemPodAccountingMeasured:
+		if c.MeasureIntervalSeconds <= 0 {
+			return "measureIntervalSeconds", errors.New("value must be > 0 for \"measured\" mode")
+		}
+	case SystemPodAccountingFixedReserve:
+		if c.FixedReserve == nil {
+			return "fixedReserve", errors.New("value must be set for \"fixedReserve\" mode")
+		}
+	case SystemPodAccountingExclude:
+	case SystemPodAccountingCapped:
+		if c.Cap == nil {
+			return "cap", errors.New("value must be set for \"capped\" mode")
+		}
+	default:
+		return "mode", fmt.Errorf("unknown mode %q", c.Mode)
+	}
+
+	if c.Mode != SystemPodAccountingFixedReserve && c.FixedReserve != nil {
+		return "fixedReserve", fmt.Errorf("value must not be set for %q mode", c.Mode)
+	} else if c.Mode != SystemPodAccountingCapped && c.Cap != nil {
+		return "cap", fmt.Errorf("value must not be set for %q mode", c.Mode)
+	} else if c.Mode != SystemPodAccountingMeasured && c.MeasureIntervalSeconds != 0 {
+		return "measureIntervalSeconds", fmt.Errorf("value must be zero for %q mode", c.Mode)
+	}
+
+	return "", nil
+}
+
+func (c *VMsPerNodeConfig) validate() (string, error) {
+	if c.Max < 0 {
+		return "max", errors.New("value must be >= 0")
+	}
+
+	for i, o := range c.Overrides {
+		if len(o.NodeSelector) == 0 {
+			return fmt.Sprintf("overrides[%d].nodeSelector", i), errors.New("map cannot be empty")
+		} else if o.Max < 0 {
+			return fmt.Sprintf("overrides[%d].max", i), errors.New("value must be >= 0")
+		}
+	}
+
+	return "", nil
+}
+
+func (c *NetworkBandwidthConfig) validate() (string, error) {
+	if c.NodeLabel == "" && len(c.InstanceTypes) == 0 {
+		return "", errors.New("at least one of nodeLabel or instanceTypes must be set")
+	}
+
+	for instanceType, q := range c.InstanceTypes {
+		if q.Sign() <= 0 {
+			return fmt.Sprintf("instanceTypes[%q]", instanceType), errors.New("value must be > 0")
+		}
+	}
+
+	if c.ScoreWeight < 0 || c.ScoreWeight > 1 {
+		return "scoreWeight", errors.New("value must be between 0 and 1, inclusive")
+	}
+
+	return "", nil
+}
+
+func (c *UsageBlendingConfig) validate() (string, error) {
+	if c.CPUUsageWeight < 0 || c.CPUUsageWeight > 1 {
+		return "cpuUsageWeight", errors.New("value must be between 0 and 1, inclusive")
+	} else if c.MemoryUsageWeight < 0 || c.MemoryUsageWeight > 1 {
+		return "memoryUsageWeight", errors.New("value must be between 0 and 1, inclusive")
+	} else if c.RequeueChangeFraction < 0 || c.RequeueChangeFraction > 1 {
+		return "requeueChangeFraction", errors.New("value must be between 0 and 1, inclusive")
+	}
+
+	return "", nil
+}
+
+func (c *MigrationBlackoutWindowConfig) validate() (string, error) {
+	if c.Name == "" {
+		return "name", errors.New("string cannot be empty")
+	} else if _, err := parseCronSchedule(c.Schedule); err != nil {
+		return "schedule", err
+	} else if c.DurationMinutes <= 0 {
+		return "durationMinutes", errors.New("value must be > 0")
+	} else if time.Minute*time.Duration(c.DurationMinutes) > maxBlackoutWindowDuration {
+		return "durationMinutes", fmt.Errorf("value must be <= %d", int(maxBlackoutWindowDuration/time.Minute))
+	}
+
+	if c.TimeZone != "" {
+		if _, err := time.LoadLocation(c.TimeZone); err != nil {
+			return "timeZone", err
+		}
+	}
+
+	return "", nil
+}
+
+func (c *MigrationLimitsConfig) validate() (string, error) {
+	if c.MaxConcurrent < 0 {
+		return "maxConcurrent", errors.New("value must be >= 0")
+	} else if c.MaxConcurrentPerNode < 0 {
+		return "maxConcurrentPerNode", errors.New("value must be >= 0")
+	} else if c.PerMinute < 0 {
+		return "perMinute", errors.New("value must be >= 0")
+	} else if c.Burst < 0 {
+		return "burst", errors.New("value must be >= 0")
+	}
+
+	if c.PerMinute != 0 && c.Burst == 0 {
+		return "burst", errors.New("value must be > 0 if perMinute is set")
+	} else if c.MaxConcurrent == 0 && c.MaxConcurrentPerNode == 0 && c.PerMinute == 0 {
+		return "", errors.New("at least one of maxConcurrent, maxConcurrentPerNode, or perMinute must be set")
+	}
+
+	return "", nil
+}
+
+func (c *MigrationDeferralConfig) validate() (string, error) {
+	if c.BusyCPUFraction < 0 {
+		return "busyCPUFraction", errors.New("value must be >= 0")
+	} else if c.MaxDeferralSeconds <= 0 {
+		return "maxDeferralSeconds", errors.New("value must be > 0")
+	} else if c.RecheckIntervalSeconds <= 0 {
+		return "recheckIntervalSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *ReconcileWorkerAutoscalingConfig) validate(minWorkers int) (string, error) {
+	if c.MaxWorkers < minWorkers {
+		return "maxWorkers", errors.New("value must be >= reconcileWorkers")
+	} else if c.QueueWaitThresholdMillis <= 0 {
+		return "queueWaitThresholdMillis", errors.New("value must be > 0")
+	} else if c.AdjustIntervalSeconds <= 0 {
+		return "adjustIntervalSeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *ReconcilePrioritiesConfig) validate() (string, error) {
+	if c.Deletions <= 0 {
+		return "deletions", errors.New("value must be > 0")
+	} else if c.Pods <= 0 {
+		return "pods", errors.New("value must be > 0")
+	} else if c.Nodes <= 0 {
+		return "nodes", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *ReconcileConfig) validate() (string, error) {
+	kinds := []struct {
+		name   string
+		config *ReconcileKindConfig
+	}{
+		{"nodes", c.Nodes},
+		{"pods", c.Pods},
+		{"migrations", c.Migrations},
+	}
+	for _, kind := range kinds {
+		if kind.config == nil {
+			continue
+		}
+		if path, err := kind.config.validate(); err != nil {
+			return fmt.Sprintf("%s.%s", kind.name, path), err
+		}
+	}
+
+	return "", nil
+}
+
+func (c *ReconcileKindConfig) validate() (string, error) {
+	if c.Workers < 0 {
+		return "workers", errors.New("value must be >= 0")
+	}
+
+	if c.Backoff != nil {
+		if path, err := c.Backoff.validate(); err != nil {
+			return fmt.Sprintf("backoff.%s", path), err
+		}
+	}
+
+	return "", nil
+}
+
+func (c *ReconcileBackoffConfig) validate() (string, error) {
+	if c.InitialMillis <= 0 {
+		return "initialMillis", errors.New("value must be > 0")
+	} else if c.MaxSeconds <= 0 {
+		return "maxSeconds", errors.New("value must be > 0")
+	} else if c.MaxSeconds*1000 < c.InitialMillis {
+		return "maxSeconds", errors.New("value must be >= initialMillis")
+	} else if c.Jitter < 0 || c.Jitter >= 1 {
+		return "jitter", errors.New("value must be between 0 and 1")
+	}
+
+	return "", nil
+}
+
+func (c *NodePressureDownscaleConfig) validate() (string, error) {
+	if c.MaxVMsPerNode <= 0 {
+		return "maxVMsPerNode", errors.New("value must be > 0")
+	} else if c.DownscaleCUs == 0 {
+		return "downscaleCUs", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *TenantFairnessConfig) validate() (string, error) {
+	if c.TenantLabel == "" {
+		return "tenantLabel", errors.New("string cannot be empty")
+	} else if c.DefaultWeight <= 0 {
+		return "defaultWeight", errors.New("value must be > 0")
+	}
+
+	for tenant, weight := range c.Weights {
+		if weight <= 0 {
+			return fmt.Sprintf("weights[%q]", tenant), errors.New("value must be > 0")
+		}
+	}
+
+	return "", nil
+}
+
+func validateAPIHealthConfig(c *util.APIHealthConfig) (string, error) {
+	if c.WindowSeconds == 0 {
+		return "windowSeconds", errors.New("value must be > 0")
+	} else if c.BadRequestFraction <= 0 || c.BadRequestFraction > 1 {
+		return "badRequestFraction", errors.New("value must be > 0 and <= 1")
+	} else if c.SlowRequestMillis == 0 {
+		return "slowRequestMillis", errors.New("value must be > 0")
+	} else if c.RecoverySeconds == 0 {
+		return "recoverySeconds", errors.New("value must be > 0")
+	}
+
+	return "", nil
+}
+
+func (c *ScoreNormalizationConfig) validate() (string, error) {
+	switch c.Function {
+	case ScoreNormalizationLinear:
+		if c.Steepness != 0 {
+			return "steepness", fmt.Errorf("value must be zero for %q function", c.Function)
+		}
+	case ScoreNormalizationExponential:
+		if c.Steepness <= 0 {
+			return "steepness", fmt.Errorf("value must be > 0 for %q function", c.Function)
+		}
+	default:
+		return "function", fmt.Errorf("unknown function %q", c.Function)
+	}
+
+	return "", nil
+}
+
+func (c *ScoringConfig) validate() (string, error) {
+	if _, ok := scoringStrategies[c.strategyName()]; !ok {
+		return "strategy", fmt.Errorf("unknown scoring strategy %q", c.Strategy)
+	}
+
+	if c.ResourceWeights != nil {
+		w := c.ResourceWeights
+		if w.CPU < 0 {
+			return "resourceWeights.cpu", errors.New("value must be >= 0")
+		} else if w.Memory < 0 {
+			return "resourceWeights.memory", errors.New("value must be >= 0")
+		} else if w.CPU == 0 && w.Memory == 0 {
+			return "resourceWeights", errors.New("at least one weight must be > 0")
+		}
+	}
+
+	if c.ProjectedUsageFactor < 0 || c.ProjectedUsageFactor > 1 {
+		return "projectedUsageFactor", errors.New("value must be between 0 and 1, inclusive")
+	}
+
+	if c.RandomJitterFraction < 0 || c.RandomJitterFraction > 1 {
+		return "randomJitterFraction", errors.New("value must be between 0 and 1, inclusive")
+	}
+
+	if c.Normalization != nil {
+		if path, err := c.Normalization.validate(); err != nil {
+			return fmt.Sprintf("normalization.%s", path), err
+		}
+	}
+
+	if c.TopologySpread != nil {
+		if c.TopologySpread.TenantLabel == "" {
+			return "topologySpread.tenantLabel", errors.New("string cannot be empty")
+		} else if len(c.TopologySpread.Weights) == 0 {
+			return "topologySpread.weights", errors.New("map cannot be empty")
+		}
+		for label, weight := range c.TopologySpread.Weights {
+			if weight <= 0 {
+				return fmt.Sprintf("topologySpread.weights[%q]", label), errors.New("value must be > 0")
+			}
+		}
+	}
+
+	if path, err := validateScoringCurve(c.MinUsageScore, c.MaxUsageScore, c.ScorePeak); err != nil {
+		return path, err
+	}
+
+	for i, o := range c.ScoringOverrides {
+		if len(o.NodeSelector) == 0 {
+			return fmt.Sprintf("scoringOverrides[%d].nodeSelector", i), errors.New("map cannot be empty")
+		}
+		if path, err := validateScoringCurve(o.MinUsageScore, o.MaxUsageScore, o.ScorePeak); err != nil {
+			return fmt.Sprintf("scoringOverrides[%d].%s", i, path), err
+		}
+
+		// Two selectors overlap if they agree on every label they have in common, because then a
+		// node with the labels from both would match both.
+		for j, other := range c.ScoringOverrides[:i] {
+			if selectorsOverlap(o.NodeSelector, other.NodeSelector) {
+				return fmt.Sprintf("scoringOverrides[%d].nodeSelector", i), fmt.Errorf(
+					"selector overlaps with scoringOverrides[%d], so a node could match both", j,
+				)
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func validateScoringCurve(minUsageScore, maxUsageScore, scorePeak float64) (string, error) {
+	if minUsageScore < 0 || minUsageScore > 1 {
+		return "minUsageScore", errors.New("value must be between 0 and 1, inclusive")
+	} else if maxUsageScore < 0 || maxUsageScore > 1 {
 		return "maxUsageScore", errors.New("value must be between 0 and 1, inclusive")
-	} else if c.ScorePeak < 0 || c.ScorePeak > 1 {
+	} else if scorePeak < 0 || scorePeak > 1 {
 		return "scorePeak", errors.New("value must be between 0 and 1, inclusive")
 	}
 
 	return "", nil
 }
 
+// selectorsOverlap returns whether there could be a node matching both label selectors
+func selectorsOverlap(a, b map[string]string) bool {
+	for label, value := range a {
+		if v, ok := b[label]; ok && v != value {
+			return false
+		}
+	}
+	return true
+}
+
 ////////////////////
 // CONFIG READING //
 ////////////////////
 
 const DefaultConfigPath = "/etc/scheduler-plugin-config/autoscale-enforcer-config.json"
 
+// ConfigMapKey is the key in the plugin's ConfigMap that holds the config, which is mounted at
+// DefaultConfigPath.
+const ConfigMapKey = "autoscale-enforcer-config.json"
+
+// ReadConfig reads and validates the config from the file at path.
+//
+// The config may be either JSON or YAML. YAML configs use the same field names as JSON.
+//
+// After decoding, any overrides from AUTOSCALE_ENFORCER_* environment variables are applied, and
+// then unset fields are filled with their defaults (see Config.Default) before the config is
+// validated.
 func ReadConfig(path string) (*Config, error) {
-	file, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("Error opening config file %q: %w", path, err)
+		return nil, fmt.Errorf("Error reading config file %q: %w", path, err)
 	}
 
-	defer file.Close()
-	var config Config
-	jsonDecoder := json.NewDecoder(file)
-	jsonDecoder.DisallowUnknownFields()
-	if err = jsonDecoder.Decode(&config); err != nil {
-		return nil, fmt.Errorf("Error decoding JSON config in %q: %w", path, err)
+	config, err := decodeConfig(path, data)
+	if err != nil {
+		return nil, err
 	}
+	config.path = path
+
+	config.envOverrides, err = config.applyEnvOverrides(os.Environ())
+	if err != nil {
+		return nil, fmt.Errorf("Error applying config overrides from environment: %w", err)
+	}
+
+	config.Default()
 
 	if path, err = config.validate(); err != nil {
 		return nil, fmt.Errorf("Invalid config at %s: %w", path, err)
 	}
 
+	if config.ComputeUnitConfigPath != "" {
+		config.computeUnits, err = api.ReadComputeUnitConfig(config.ComputeUnitConfigPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// decodeConfig decodes the config from the contents of the file at path, without defaulting or
+// validating it.
+func decodeConfig(path string, data []byte) (*Config, error) {
+	var config Config
+	if isYAMLConfig(path, data) {
+		// sigs.k8s.io/yaml converts to JSON before decoding, so all of our JSON handling (e.g. for
+		// resource quantities) still applies.
+		if err := yaml.UnmarshalStrict(data, &config); err != nil {
+			return nil, fmt.Errorf("Error decoding YAML config in %q: %w", path, err)
+		}
+	} else {
+		jsonDecoder := json.NewDecoder(bytes.NewReader(data))
+		jsonDecoder.DisallowUnknownFields()
+		if err := jsonDecoder.Decode(&config); err != nil {
+			return nil, fmt.Errorf("Error decoding JSON config in %q: %w", path, err)
+		}
+	}
 	return &config, nil
 }
 
+// ValidateConfigFile checks the config in the file at path, for use before it's applied.
+//
+// The file may either be the config itself, or Kubernetes manifests (e.g. the output of 'kustomize
+// build') containing the plugin's ConfigMap -- i.e. any ConfigMap with a ConfigMapKey entry, all of
+// which are checked.
+//
+// Unlike ReadConfig, no overrides from the environment are applied, and ComputeUnitConfigPath is not
+// read, because neither is available until the config is mounted into the scheduler.
+func ValidateConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading file %q: %w", path, err)
+	}
+
+	configMaps, err := pluginConfigMaps(data)
+	if err != nil {
+		return fmt.Errorf("Error decoding manifests in %q: %w", path, err)
+	}
+
+	if len(configMaps) == 0 {
+		return validateConfigData(path, data)
+	}
+	for _, cm := range configMaps {
+		name := fmt.Sprintf("ConfigMap %s/%s", cm.Namespace, cm.Name)
+		if err := validateConfigData(name, []byte(cm.Data[ConfigMapKey])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pluginConfigMaps returns the ConfigMaps with a ConfigMapKey entry among the (possibly multiple)
+// YAML documents in data.
+//
+// Documents that aren't ConfigMaps are ignored, so a plain config file returns no ConfigMaps.
+func pluginConfigMaps(data []byte) ([]corev1.ConfigMap, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+
+	var configMaps []corev1.ConfigMap
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return configMaps, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		var cm corev1.ConfigMap
+		if err := yaml.Unmarshal(doc, &cm); err != nil {
+			// Not a Kubernetes object, e.g. a config file with fields of unexpected types. Leave it
+			// to validateConfigData to report the error.
+			continue
+		}
+		if cm.Kind != "ConfigMap" {
+			continue
+		}
+		if _, ok := cm.Data[ConfigMapKey]; ok {
+			configMaps = append(configMaps, cm)
+		}
+	}
+}
+
+// validateConfigData decodes, defaults, and validates the config, using name to identify it in
+// errors.
+func validateConfigData(name string, data []byte) error {
+	config, err := decodeConfig(name, data)
+	if err != nil {
+		return err
+	}
+
+	config.Default()
+
+	if path, err := config.validate(); err != nil {
+		return fmt.Errorf("Invalid config in %s at %s: %w", name, path, err)
+	}
+	return nil
+}
+
+// isYAMLConfig returns whether the config file is YAML, based on its extension or otherwise whether
+// its contents look like a JSON object.
+//
+// We can't rely on a ".json" extension, because DefaultConfigPath has one regardless of the format
+// of the config.
+func isYAMLConfig(path string, data []byte) bool {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return true
+	default:
+		return !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+	}
+}
+
+// withReloadableFields returns a copy of the config with the fields that can be changed without
+// restarting the scheduler taken from other.
+//
+// The reloadable fields are the ones that are read every time they're used, rather than just at
+// startup, and don't determine what state we keep. The rest are deliberately excluded:
+//
+//   - Fields that start background work, set up watches, or build other objects at startup:
+//     CordonWatermark, Defragmentation, WatermarkOverrides, IgnoredNamespaceSelector,
+//     ReconcileWorkers, ReconcileWorkerAutoscaling, ReconcilePriorities, Reconcile,
+//     StartupEventHandlingTimeoutSeconds, K8sCRUDTimeoutSeconds, PatchRetryWaitSeconds,
+//     PatchMaxAttempts, WatchStaleTimeoutSeconds, NodeMetricLabels, ComputeUnitConfigPath,
+//     UpscaleRateLimit, Federation, HealthReport, CapacityForecast, DecisionLog, DebugServer,
+//     StateHandoff, LeaderElection, MigrationLimits, MigrationBlackoutWindows, APIHealth, and
+//     DryRun.
+//   - Fields that determine what we store for each node or pod: SystemPodAccounting,
+//     NetworkBandwidth, ExtendedResources, VMAnnotationResources, UsageBlending,
+//     MigrationDeferral, NodePressureDownscale, HonorPodTopology, TenantFairness, and
+//     Scoring.TopologySpread (the rest of Scoring is reloadable).
+//   - SchedulerName and ShadowMode, which determine which pods we're responsible for.
+func (c Config) withReloadableFields(other Config) Config {
+	topologySpread := c.Scoring.TopologySpread
+	c.Scoring = other.Scoring
+	c.Scoring.TopologySpread = topologySpread
+	c.Watermark = other.Watermark
+	c.CPUWatermark = other.CPUWatermark
+	c.MemoryWatermark = other.MemoryWatermark
+	c.WatermarkHysteresis = other.WatermarkHysteresis
+	c.MigrationVictimPolicy = other.MigrationVictimPolicy
+	c.LogSuccessiveFailuresThreshold = other.LogSuccessiveFailuresThreshold
+	c.ReservationTTLSeconds = other.ReservationTTLSeconds
+	c.UnboundReservationTTLSeconds = other.UnboundReservationTTLSeconds
+	c.IgnoredNamespaces = other.IgnoredNamespaces
+	c.ExtraSystemReserve = other.ExtraSystemReserve
+	c.VMsPerNode = other.VMsPerNode
+	c.Preemption = other.Preemption
+	c.PriorityHandling = other.PriorityHandling
+	c.HonorScaleDownTaints = other.HonorScaleDownTaints
+	return c
+}
+
 //////////////////////////////////////
 // HELPER METHODS FOR USING CONFIGS //
 //////////////////////////////////////
 
-func (c Config) ignoredNamespace(namespace string) bool {
-	return slices.Contains(c.IgnoredNamespaces, namespace)
+// EnvOverrides returns the names of the environment variables that were applied to the config when
+// it was read.
+func (c Config) EnvOverrides() []string {
+	return c.envOverrides
+}
+
+// ignoredNamespaceName returns whether the namespace is in IgnoredNamespaces, or matches one of
+// its patterns.
+//
+// Namespaces may also be ignored by their labels -- see (*PluginState).ignoredNamespace.
+func (c Config) ignoredNamespaceName(namespace string) bool {
+	for _, pattern := range c.IgnoredNamespaces {
+		// Patterns are checked by validation, so the only possible error here is ErrBadPattern.
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// storePodLabels returns whether the labels of each pod should be kept in the local state.
+func (c Config) storePodLabels() bool {
+	return c.HonorPodTopology || c.TenantFairness != nil || c.Scoring.TopologySpread != nil
+}
+
+func (c Config) migrationVictimPolicy() MigrationVictimPolicy {
+	if c.MigrationVictimPolicy == "" {
+		return MigrationVictimLeastRecentlyMigrated
+	}
+	return c.MigrationVictimPolicy
+}
+
+func (c Config) systemPodAccountingMode() SystemPodAccountingMode {
+	if c.SystemPodAccounting == nil {
+		return SystemPodAccountingRequests
+	}
+	return c.SystemPodAccounting.Mode
+}
+
+// nodeReserve returns the resources that should be subtracted from each node's allocatable
+// resources, due to the ExtraSystemReserve and SystemPodAccounting.
+func (c Config) nodeReserve() api.Resources {
+	reserve := lo.FromPtr(c.ExtraSystemReserve)
+	if c.systemPodAccountingMode() == SystemPodAccountingFixedReserve {
+		reserve = reserve.Add(*c.SystemPodAccounting.FixedReserve)
+	}
+	return reserve
+}
+
+// maxVMsOnNode returns the maximum number of VMs allowed on a node with the given labels, or false
+// if there is no limit.
+func (c Config) maxVMsOnNode(nodeLabels map[string]string) (_ int, ok bool) {
+	if c.VMsPerNode == nil {
+		return 0, false
+	}
+
+	limit := c.VMsPerNode.Max
+	for _, o := range c.VMsPerNode.Overrides {
+		if selectorMatches(o.NodeSelector, nodeLabels) {
+			limit = o.Max
+			break
+		}
+	}
+
+	return limit, limit != 0
+}
+
+// cpuWatermark returns the CPUWatermark, or Watermark if it's not set.
+func (c Config) cpuWatermark() float64 {
+	if c.CPUWatermark != 0 {
+		return c.CPUWatermark
+	}
+	return c.Watermark
+}
+
+// memWatermark returns the MemoryWatermark, or Watermark if it's not set.
+func (c Config) memWatermark() float64 {
+	if c.MemoryWatermark != 0 {
+		return c.MemoryWatermark
+	}
+	return c.Watermark
+}
+
+// watermarkForNamespace returns the watermark for VMs in a namespace with the given labels, or
+// false if no override matches and the global Watermark applies.
+func (c Config) watermarkForNamespace(namespaceLabels map[string]string) (_ float64, ok bool) {
+	for _, o := range c.WatermarkOverrides {
+		if selectorMatches(o.NamespaceSelector, namespaceLabels) {
+			return o.Watermark, true
+		}
+	}
+	return 0, false
+}
+
+// selectorMatches returns whether the labels include all of the labels in the selector
+func selectorMatches(selector map[string]string, labels map[string]string) bool {
+	for label, value := range selector {
+		if v, ok := labels[label]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// forNode returns the scoring config that applies to a node with the given labels, taking the
+// curve from the matching override, if there is one.
+func (c ScoringConfig) strategyName() ScoringStrategyName {
+	if c.Strategy == "" {
+		return ScoringStrategyPeak
+	}
+	return c.Strategy
+}
+
+func (c ScoringConfig) forNode(nodeLabels map[string]string) ScoringConfig {
+	for _, o := range c.ScoringOverrides {
+		if selectorMatches(o.NodeSelector, nodeLabels) {
+			c.MinUsageScore = o.MinUsageScore
+			c.MaxUsageScore = o.MaxUsageScore
+			c.ScorePeak = o.ScorePeak
+			break
+		}
+	}
+	return c
+}
+
+// extendedResourceWatermarks returns the watermark fraction for each of the tracked extended
+// resources.
+func (c Config) extendedResourceWatermarks() map[corev1.ResourceName]float64 {
+	watermarks := make(map[corev1.ResourceName]float64)
+	for _, r := range c.ExtendedResources {
+		watermarks[corev1.ResourceName(r.Name)] = r.Watermark
+	}
+	return watermarks
+}
+
+// nodeNetworkBandwidth returns the network bandwidth, in bits per second, of a node with the given
+// labels, or zero if it's unknown.
+func (c Config) nodeNetworkBandwidth(nodeLabels map[string]string) (uint64, error) {
+	if c.NetworkBandwidth == nil {
+		return 0, nil
+	}
+
+	if c.NetworkBandwidth.NodeLabel != "" {
+		if value, ok := nodeLabels[c.NetworkBandwidth.NodeLabel]; ok {
+			q, err := resource.ParseQuantity(value)
+			if err != nil {
+				return 0, fmt.Errorf("could not parse label %q: %w", c.NetworkBandwidth.NodeLabel, err)
+			} else if q.Sign() < 0 {
+				return 0, fmt.Errorf("label %q must not be negative", c.NetworkBandwidth.NodeLabel)
+			}
+			return uint64(q.Value()), nil
+		}
+	}
+
+	if q, ok := c.NetworkBandwidth.InstanceTypes[nodeLabels[corev1.LabelInstanceTypeStable]]; ok {
+		return uint64(q.Value()), nil
+	}
+
+	return 0, nil
 }
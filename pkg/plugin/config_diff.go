@@ -0,0 +1,277 @@
+package plugin
+
+// Semantic diffs between configs, to help review config changes.
+//
+// DiffConfigs compares the effective configs (i.e., after defaulting), so that e.g. removing a
+// field that was set to its default isn't reported. Given a StateDump from a running scheduler
+// (served at /dump-state), EstimateConfigEffects also estimates how the change would affect the
+// cluster as it currently is.
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/constraints"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// StateDump is a summary of the plugin's view of the cluster, in the format served at /dump-state.
+type StateDump struct {
+	Nodes []NodeDump `json:"nodes"`
+}
+
+// NodeDump is the summary of a single node in a StateDump.
+type NodeDump struct {
+	Name   string                           `json:"name"`
+	Labels map[string]string                `json:"labels"`
+	CPU    NodeResourcesDump[vmv1.MilliCPU] `json:"cpu"`
+	Mem    NodeResourcesDump[api.Bytes]     `json:"mem"`
+	// VMs is the number of VMs on the node, as counted for VMsPerNode.
+	VMs int `json:"vms"`
+}
+
+// NodeResourcesDump is the summary of one resource on a node in a StateDump.
+//
+// Refer to state.NodeResources for the meaning of each field.
+type NodeResourcesDump[T constraints.Unsigned] struct {
+	Allocatable T `json:"allocatable"`
+	Reserved    T `json:"reserved"`
+	Migrating   T `json:"migrating"`
+}
+
+// dumpState returns the StateDump for the current state.
+func (s *PluginState) dumpState() StateDump {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes := make([]NodeDump, 0, len(s.nodes))
+	for _, name := range slices.Sorted(maps.Keys(s.nodes)) {
+		ns := s.nodes[name]
+		nodes = append(nodes, NodeDump{
+			Name:   name,
+			Labels: maps.Clone(ns.labels),
+			CPU: NodeResourcesDump[vmv1.MilliCPU]{
+				Allocatable: ns.node.CPU.Allocatable,
+				Reserved:    ns.node.CPU.Reserved,
+				Migrating:   ns.node.CPU.Migrating,
+			},
+			Mem: NodeResourcesDump[api.Bytes]{
+				Allocatable: ns.node.Mem.Allocatable,
+				Reserved:    ns.node.Mem.Reserved,
+				Migrating:   ns.node.Mem.Migrating,
+			},
+			VMs: ns.node.VMs(),
+		})
+	}
+
+	return StateDump{Nodes: nodes}
+}
+
+// ConfigChange is a single difference between two configs.
+type ConfigChange struct {
+	// Path is the JSON path to the changed value, e.g. "scoring.scorePeak".
+	Path string
+	// Old and New are the JSON values before and after the change, or nil if the value is unset.
+	Old any
+	New any
+}
+
+func (c ConfigChange) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("%s set to %s", c.Path, formatConfigValue(c.New))
+	case c.New == nil:
+		return fmt.Sprintf("%s unset (was %s)", c.Path, formatConfigValue(c.Old))
+	}
+
+	verb := "changed"
+	if oldNum, ok := c.Old.(float64); ok {
+		if newNum, ok := c.New.(float64); ok {
+			verb = "raised"
+			if newNum < oldNum {
+				verb = "lowered"
+			}
+		}
+	}
+	return fmt.Sprintf("%s %s from %s → %s", c.Path, verb, formatConfigValue(c.Old), formatConfigValue(c.New))
+}
+
+func formatConfigValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// DiffConfigs returns the differences between the two configs, sorted by path.
+//
+// Nested objects are compared field by field. Lists are compared as a whole.
+func DiffConfigs(oldConfig, newConfig *Config) ([]ConfigChange, error) {
+	oldValue, err := configAsJSONValue(oldConfig)
+	if err != nil {
+		return nil, err
+	}
+	newValue, err := configAsJSONValue(newConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []ConfigChange
+	diffJSONValues("", oldValue, newValue, &changes)
+	return changes, nil
+}
+
+func configAsJSONValue(c *Config) (any, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode config: %w", err)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("could not decode config: %w", err)
+	}
+	return value, nil
+}
+
+func diffJSONValues(path string, oldValue, newValue any, changes *[]ConfigChange) {
+	oldObj, oldIsObj := oldValue.(map[string]any)
+	newObj, newIsObj := newValue.(map[string]any)
+	if !oldIsObj || !newIsObj {
+		if !reflect.DeepEqual(oldValue, newValue) {
+			*changes = append(*changes, ConfigChange{Path: path, Old: oldValue, New: newValue})
+		}
+		return
+	}
+
+	keys := slices.Sorted(maps.Keys(oldObj))
+	for key := range newObj {
+		if _, ok := oldObj[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		subpath := key
+		if path != "" {
+			subpath = path + "." + key
+		}
+		diffJSONValues(subpath, oldObj[key], newObj[key], changes)
+	}
+}
+
+// ConfigEffect is an estimate of how a config change would affect the current state.
+type ConfigEffect struct {
+	// Description describes what's being counted, e.g. "nodes above watermark".
+	Description string
+	// Old and New are the counts with the old and new configs.
+	Old int
+	New int
+}
+
+func (e ConfigEffect) String() string {
+	diff := e.New - e.Old
+	direction := "additional"
+	if diff < 0 {
+		direction = "fewer"
+		diff = -diff
+	}
+	return fmt.Sprintf("~%d %s %s (%d → %d)", diff, direction, e.Description, e.Old, e.New)
+}
+
+// EstimateConfigEffects returns the estimated effects of changing from the old config to the new
+// one, for the nodes in the dump. Only effects that change the counts are returned.
+//
+// These are estimates: the resources reserved by each pod are taken from the dump, so changes
+// that affect them (like SystemPodAccounting) aren't accounted for.
+func EstimateConfigEffects(oldConfig, newConfig *Config, dump *StateDump) []ConfigEffect {
+	counters := []struct {
+		description string
+		count       func(*Config, NodeDump) bool
+	}{
+		{"nodes would currently be above watermark", nodeDumpAboveWatermark},
+		{"nodes would currently be over their VM limit", nodeDumpOverVMLimit},
+	}
+
+	var effects []ConfigEffect
+	for _, c := range counters {
+		effect := ConfigEffect{Description: c.description, Old: 0, New: 0}
+		for _, n := range dump.Nodes {
+			if c.count(oldConfig, n) {
+				effect.Old += 1
+			}
+			if c.count(newConfig, n) {
+				effect.New += 1
+			}
+		}
+		if effect.Old != effect.New {
+			effects = append(effects, effect)
+		}
+	}
+	return effects
+}
+
+// nodeDumpAboveWatermark returns whether the node would be above the watermark with the config,
+// using the same calculation as when choosing VMs to migrate.
+func nodeDumpAboveWatermark(c *Config, n NodeDump) bool {
+	reserve := c.nodeReserve()
+	cpu := nodeResourcesFromDump(n.CPU, reserve.VCPU, c.cpuWatermark())
+	mem := nodeResourcesFromDump(n.Mem, reserve.Mem, c.memWatermark())
+	return cpu.UnmigratedAboveWatermark() > 0 || mem.UnmigratedAboveWatermark() > 0
+}
+
+func nodeResourcesFromDump[T constraints.Unsigned](
+	dump NodeResourcesDump[T],
+	reserve T,
+	watermarkFraction float64,
+) state.NodeResources[T] {
+	total := util.SaturatingSub(dump.Allocatable, reserve)
+	return state.NodeResources[T]{
+		Capacity:    dump.Allocatable,
+		Allocatable: dump.Allocatable,
+		Total:       total,
+		Reserved:    dump.Reserved,
+		Migrating:   min(dump.Migrating, dump.Reserved),
+		WarmPool:    0,
+		Watermark:   T(float64(total) * watermarkFraction),
+	}
+}
+
+func nodeDumpOverVMLimit(c *Config, n NodeDump) bool {
+	maxVMs, limited := c.maxVMsOnNode(n.Labels)
+	return limited && n.VMs > maxVMs
+}
+
+// FormatConfigDiff returns a human-readable description of the changes and their effects, or a
+// note that there are no changes.
+func FormatConfigDiff(changes []ConfigChange, effects []ConfigEffect, dump *StateDump) string {
+	if len(changes) == 0 {
+		return "No changes to the effective config\n"
+	}
+
+	var b strings.Builder
+	b.WriteString("Changes to the effective config:\n")
+	for _, c := range changes {
+		fmt.Fprintf(&b, "  %s\n", c)
+	}
+
+	if dump != nil {
+		fmt.Fprintf(&b, "\nEstimated effects on the current state (%d nodes):\n", len(dump.Nodes))
+		if len(effects) == 0 {
+			b.WriteString("  none\n")
+		}
+		for _, e := range effects {
+			fmt.Fprintf(&b, "  %s\n", e)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	oldConfig := defaultBenchmarkConfig()
+	newConfig := defaultBenchmarkConfig()
+
+	changes, err := DiffConfigs(oldConfig, newConfig)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	newConfig.Watermark = 0.8
+	newConfig.Scoring.ScorePeak = 0.9
+	newConfig.VMsPerNode = &VMsPerNodeConfig{Max: 10, Overrides: nil}
+
+	changes, err = DiffConfigs(oldConfig, newConfig)
+	require.NoError(t, err)
+
+	var descriptions []string
+	for _, c := range changes {
+		descriptions = append(descriptions, c.String())
+	}
+	assert.Equal(t, []string{
+		"scoring.scorePeak raised from 0.8 → 0.9",
+		"vmsPerNode set to {\"max\":10}",
+		"watermark lowered from 0.9 → 0.8",
+	}, descriptions)
+}
+
+func TestEstimateConfigEffects(t *testing.T) {
+	const gib = api.Bytes(1 << 30)
+	node := func(name string, cpuReserved vmv1.MilliCPU, vms int) NodeDump {
+		return NodeDump{
+			Name:   name,
+			Labels: map[string]string{},
+			CPU: NodeResourcesDump[vmv1.MilliCPU]{
+				Allocatable: 10000,
+				Reserved:    cpuReserved,
+				Migrating:   0,
+			},
+			Mem: NodeResourcesDump[api.Bytes]{
+				Allocatable: 40 * gib,
+				Reserved:    gib,
+				Migrating:   0,
+			},
+			VMs: vms,
+		}
+	}
+	dump := &StateDump{
+		Nodes: []NodeDump{
+			node("node-0", 9500, 5),  // above 0.9 and 0.75
+			node("node-1", 8000, 20), // above 0.75 only
+			node("node-2", 5000, 10), // below both
+		},
+	}
+
+	oldConfig := defaultBenchmarkConfig()
+	oldConfig.Watermark = 0.9
+	newConfig := defaultBenchmarkConfig()
+	newConfig.Watermark = 0.75
+	newConfig.VMsPerNode = &VMsPerNodeConfig{Max: 8, Overrides: nil}
+
+	effects := EstimateConfigEffects(oldConfig, newConfig, dump)
+	assert.Equal(t, []ConfigEffect{
+		{Description: "nodes would currently be above watermark", Old: 1, New: 2},
+		{Description: "nodes would currently be over their VM limit", Old: 0, New: 2},
+	}, effects)
+	assert.Equal(t, "~1 additional nodes would currently be above watermark (1 → 2)", effects[0].String())
+
+	// Unchanged counts aren't reported
+	assert.Empty(t, EstimateConfigEffects(oldConfig, oldConfig, dump))
+}
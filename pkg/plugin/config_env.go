@@ -0,0 +1,155 @@
+package plugin
+
+// Overriding individual config fields with environment variables.
+//
+// Each field in the config can be set by an environment variable named EnvOverridePrefix followed
+// by the JSON path to the field, with each part in upper snake case and separated by underscores.
+// For example, "watermark" is set by AUTOSCALE_ENFORCER_WATERMARK, and "scoring.scorePeak" by
+// AUTOSCALE_ENFORCER_SCORING_SCORE_PEAK.
+//
+// String values are used as-is. All other values are parsed as JSON, so that e.g. lists can be
+// given with AUTOSCALE_ENFORCER_IGNORED_NAMESPACES='["kube-system"]'.
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// EnvOverridePrefix is the prefix of environment variables that override fields in the config.
+const EnvOverridePrefix = "AUTOSCALE_ENFORCER_"
+
+// applyEnvOverrides sets the fields in the config that have overrides in environ, which has the
+// same "KEY=value" format as os.Environ().
+//
+// It returns the names of the environment variables that were applied, in the order they were
+// applied. Environment variables with EnvOverridePrefix that don't correspond to a field in the
+// config are an error.
+func (c *Config) applyEnvOverrides(environ []string) ([]string, error) {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, EnvOverridePrefix) {
+			env[key] = value
+		}
+	}
+	if len(env) == 0 {
+		return nil, nil
+	}
+
+	var applied []string
+	if err := applyEnvOverridesToStruct(EnvOverridePrefix, reflect.ValueOf(c).Elem(), env, &applied); err != nil {
+		return nil, err
+	}
+
+	if len(applied) != len(env) {
+		var unknown []string
+		for key := range env {
+			if !slices.Contains(applied, key) {
+				unknown = append(unknown, key)
+			}
+		}
+		slices.Sort(unknown)
+		return nil, fmt.Errorf("Unknown config overrides from environment: %s", strings.Join(unknown, ", "))
+	}
+
+	return applied, nil
+}
+
+var jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+
+func applyEnvOverridesToStruct(prefix string, v reflect.Value, env map[string]string, applied *[]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		jsonName := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if name, _, _ := strings.Cut(tag, ","); name == "-" {
+				continue
+			} else if name != "" {
+				jsonName = name
+			}
+		}
+
+		key := prefix + envName(jsonName)
+		fieldValue := v.Field(i)
+
+		// Overrides for the field itself are applied before overrides for any of its subfields,
+		// so that e.g. a whole struct can be set and then have a single field changed.
+		if value, ok := env[key]; ok {
+			if err := setFromEnv(fieldValue, value); err != nil {
+				return fmt.Errorf("Invalid value for %s: %w", key, err)
+			}
+			*applied = append(*applied, key)
+		}
+
+		switch {
+		case isNestedConfig(fieldValue.Type()):
+			if err := applyEnvOverridesToStruct(key+"_", fieldValue, env, applied); err != nil {
+				return err
+			}
+		case fieldValue.Kind() == reflect.Pointer && isNestedConfig(fieldValue.Type().Elem()):
+			if !fieldValue.IsNil() {
+				if err := applyEnvOverridesToStruct(key+"_", fieldValue.Elem(), env, applied); err != nil {
+					return err
+				}
+				continue
+			}
+			for other := range env {
+				if strings.HasPrefix(other, key+"_") {
+					return fmt.Errorf("Cannot apply %s because %s is not set", other, jsonName)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// isNestedConfig returns whether fields of type t should have overrides for each of their own
+// fields, rather than only for the value as a whole.
+func isNestedConfig(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(jsonUnmarshalerType)
+}
+
+func setFromEnv(v reflect.Value, value string) error {
+	if v.Kind() == reflect.String && !reflect.PointerTo(v.Type()).Implements(jsonUnmarshalerType) {
+		v.SetString(value)
+		return nil
+	}
+
+	ptr := reflect.New(v.Type())
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(ptr.Interface()); err != nil {
+		return err
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+// envName converts a camelCase JSON field name into upper snake case, e.g. "k8sCRUDTimeoutSeconds"
+// becomes "K8S_CRUD_TIMEOUT_SECONDS".
+func envName(jsonName string) string {
+	runes := []rune(jsonName)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if i != 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package plugin
+
+// Reloading the config when the file it was read from changes, so that tuning changes don't
+// require restarting the scheduler.
+//
+// Only some fields can be changed this way -- see (Config).withReloadableFields. Changes to other
+// fields are logged and ignored until the next restart.
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// configReloadDelay is how long we wait after the last change to the config file before reloading
+// it, because a single update generates a burst of events.
+const configReloadDelay = time.Second
+
+// runConfigWatcher watches the config file at path, reloading the config whenever it changes,
+// until the context is canceled.
+func (s *PluginState) runConfigWatcher(ctx context.Context, logger *zap.Logger, path string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error("Failed to create config watcher, config will not be reloaded", zap.Error(err))
+		return
+	}
+	defer watcher.Close()
+
+	// Watch the directory rather than the file itself: ConfigMap volumes are updated by atomically
+	// swapping a symlink to a new directory, which doesn't generate any events for the file.
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		logger.Error("Failed to watch config directory, config will not be reloaded", zap.Error(err))
+		return
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Warn("Error watching config file", zap.Error(err))
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			reload = time.After(configReloadDelay)
+		case <-reload:
+			reload = nil
+			s.reloadConfig(logger, path)
+		}
+	}
+}
+
+// reloadConfig reads the config at path and, if it's valid, swaps in its reloadable fields.
+//
+// This must not be called concurrently with itself.
+func (s *PluginState) reloadConfig(logger *zap.Logger, path string) {
+	newConfig, err := ReadConfig(path)
+	if err != nil {
+		s.metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		logger.Error("Failed to reload config, keeping the current config", zap.Error(err))
+		return
+	}
+
+	oldConfig := s.config()
+	if !reflect.DeepEqual(newConfig.withReloadableFields(*oldConfig), *oldConfig) {
+		logger.Warn("Config has changes that require restarting the scheduler, ignoring them until then")
+	}
+
+	updated := oldConfig.withReloadableFields(*newConfig)
+	if reflect.DeepEqual(updated, *oldConfig) {
+		return
+	}
+
+	s.currentConfig.Store(&updated)
+	s.metrics.ConfigReloads.WithLabelValues("success").Inc()
+	logger.Info("Reloaded config", zap.Any("config", updated))
+
+	// Restart the random sequence for scores whenever the seed changes, so that it's reproducible
+	// from the point that the new seed was applied.
+	if !reflect.DeepEqual(updated.Scoring.RandomSeed, oldConfig.Scoring.RandomSeed) {
+		s.mu.Lock()
+		s.scoreRand = newScoreRand(updated.Scoring)
+		s.mu.Unlock()
+	}
+
+	// The watermarks and reserved resources are only applied to nodes when they're reconciled, so
+	// make sure that happens for all of them now.
+	watermarksChanged := updated.cpuWatermark() != oldConfig.cpuWatermark() ||
+		updated.memWatermark() != oldConfig.memWatermark()
+	if watermarksChanged || !reflect.DeepEqual(updated.ExtraSystemReserve, oldConfig.ExtraSystemReserve) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for name := range s.nodes {
+			if err := s.requeueNode(name); err != nil {
+				logger.Warn("Failed to requeue Node after reloading config", zap.String("node", name), zap.Error(err))
+			}
+		}
+	}
+}
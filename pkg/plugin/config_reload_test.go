@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+)
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(config *Config) {
+		data, err := json.Marshal(config)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+	}
+
+	writeConfig(defaultBenchmarkConfig())
+	config, err := ReadConfig(path)
+	require.NoError(t, err)
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	var requeuedNodes []string
+	s.requeueNode = func(name string) error {
+		requeuedNodes = append(requeuedNodes, name)
+		return nil
+	}
+	s.nodes["node-1"] = nil
+	logger := zap.NewNop()
+
+	// Reloadable fields are swapped in, but others are kept as they were
+	changed := defaultBenchmarkConfig()
+	changed.Watermark = config.Watermark / 2
+	changed.IgnoredNamespaces = []string{"overprovisioning"}
+	changed.SchedulerName = "other-scheduler"
+	writeConfig(changed)
+
+	s.reloadConfig(logger, path)
+	assert.Equal(t, changed.Watermark, s.config().Watermark)
+	assert.True(t, s.ignoredNamespace("overprovisioning"))
+	assert.Equal(t, config.SchedulerName, s.config().SchedulerName)
+	assert.Equal(t, []string{"node-1"}, requeuedNodes)
+	assert.Equal(t, 1.0, testutil.ToFloat64(pluginMetrics.ConfigReloads.WithLabelValues("success")))
+
+	// Invalid configs are rejected
+	invalid := defaultBenchmarkConfig()
+	invalid.Watermark = 2.0
+	writeConfig(invalid)
+
+	s.reloadConfig(logger, path)
+	assert.Equal(t, changed.Watermark, s.config().Watermark)
+	assert.Equal(t, 1.0, testutil.ToFloat64(pluginMetrics.ConfigReloads.WithLabelValues("failure")))
+}
+
+func TestReloadableFields(t *testing.T) {
+	// Every field of Config must be in exactly one of these lists, matching the documentation of
+	// withReloadableFields.
+	reloadable := []string{
+		"Scoring", "Watermark", "CPUWatermark", "MemoryWatermark", "WatermarkHysteresis",
+		"MigrationVictimPolicy", "LogSuccessiveFailuresThreshold", "ReservationTTLSeconds",
+		"UnboundReservationTTLSeconds", "IgnoredNamespaces", "ExtraSystemReserve", "VMsPerNode",
+		"Preemption", "PriorityHandling", "HonorScaleDownTaints",
+	}
+	excluded := []string{
+		"CordonWatermark", "Defragmentation", "WatermarkOverrides", "IgnoredNamespaceSelector",
+		"ReconcileWorkers", "ReconcileWorkerAutoscaling", "ReconcilePriorities", "Reconcile",
+		"StartupEventHandlingTimeoutSeconds", "K8sCRUDTimeoutSeconds", "PatchRetryWaitSeconds",
+		"PatchMaxAttempts", "WatchStaleTimeoutSeconds", "NodeMetricLabels", "ComputeUnitConfigPath",
+		"UpscaleRateLimit", "Federation", "HealthReport", "CapacityForecast", "DecisionLog",
+		"DebugServer", "StateHandoff", "LeaderElection", "MigrationLimits",
+		"MigrationBlackoutWindows", "APIHealth", "DryRun",
+		"SystemPodAccounting", "NetworkBandwidth", "ExtendedResources", "VMAnnotationResources",
+		"UsageBlending", "MigrationDeferral", "NodePressureDownscale", "HonorPodTopology",
+		"TenantFairness",
+		"SchedulerName", "ShadowMode",
+	}
+
+	base := *defaultBenchmarkConfig()
+	typ := reflect.TypeOf(base)
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		t.Run(field.Name, func(t *testing.T) {
+			other := base
+			require.True(
+				t,
+				changeValue(reflect.ValueOf(&other).Elem().Field(i)),
+				"don't know how to change field of type %s", field.Type,
+			)
+			copied := !reflect.DeepEqual(base.withReloadableFields(other), base)
+
+			switch {
+			case slices.Contains(reloadable, field.Name):
+				assert.True(t, copied, "reloadable field is not copied")
+			case slices.Contains(excluded, field.Name):
+				assert.False(t, copied, "excluded field is copied")
+			default:
+				t.Error("field must be listed as reloadable or excluded in withReloadableFields")
+			}
+		})
+	}
+}
+
+// Scoring is reloadable, except for TopologySpread, which determines whether pod labels are stored.
+func TestReloadableScoringFields(t *testing.T) {
+	base := *defaultBenchmarkConfig()
+
+	other := base
+	other.Scoring.ProjectedUsageFactor += 0.5
+	other.Scoring.TopologySpread = &TopologySpreadScoringConfig{
+		TenantLabel: "tenant",
+		Weights:     map[string]float64{"topology.kubernetes.io/zone": 1},
+	}
+
+	updated := base.withReloadableFields(other)
+	assert.Equal(t, other.Scoring.ProjectedUsageFactor, updated.Scoring.ProjectedUsageFactor)
+	assert.Nil(t, updated.Scoring.TopologySpread)
+	assert.Equal(t, base.storePodLabels(), updated.storePodLabels())
+}
+
+// changeValue sets v to a different value of the same type, returning false if it doesn't know how.
+func changeValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(!v.Bool())
+	case reflect.Int, reflect.Int64:
+		v.SetInt(v.Int() + 1)
+	case reflect.Uint16, reflect.Uint64:
+		v.SetUint(v.Uint() + 1)
+	case reflect.Float64:
+		v.SetFloat(v.Float() + 0.5)
+	case reflect.String:
+		v.SetString(v.String() + "x")
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		} else {
+			v.SetZero()
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		} else {
+			v.SetZero()
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		} else {
+			v.SetZero()
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() && changeValue(v.Field(i)) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+	return true
+}
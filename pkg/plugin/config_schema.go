@@ -0,0 +1,197 @@
+package plugin
+
+// Generating a JSON Schema for the config, so that configs can be checked in CI and editors can
+// offer completion.
+//
+// The schema is derived from the Go types by reflection, so that it can't drift from the fields
+// that ReadConfig actually accepts. Constraints on individual values come from each field's
+// "schema" struct tag, which has comma-separated entries that are either a keyword with a value
+// (e.g. "minimum=0", or "enum=a|b" for a list of strings) or "required". Defaults are taken from
+// Config.Default.
+//
+// Constraints that relate multiple fields (e.g. ReconcileWorkerAutoscaling.MaxWorkers must be at
+// least ReconcileWorkers) can't be expressed this way, so the checks in validate() are still the
+// source of truth. When adding a check there for a single field, add the matching tag as well.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// ConfigSchemaPath is the path, relative to the root of the repo, of the generated schema for the
+// config.
+const ConfigSchemaPath = "autoscale-scheduler/config.schema.json"
+
+// quantityTypes are the types in the config that are decoded from resource quantities, which may
+// be either strings (e.g. "2Gi") or numbers.
+var quantityTypes = []reflect.Type{
+	reflect.TypeFor[resource.Quantity](),
+	reflect.TypeFor[vmv1.MilliCPU](),
+	reflect.TypeFor[api.Bytes](),
+}
+
+// ConfigSchema returns the JSON Schema for the config, as indented JSON.
+func ConfigSchema() ([]byte, error) {
+	var defaults Config
+	defaults.Default()
+
+	schema, err := schemaForType(reflect.TypeFor[Config](), reflect.ValueOf(defaults))
+	if err != nil {
+		return nil, err
+	}
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "autoscale-scheduler plugin config"
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// schemaForType returns the schema for values of type t. If defaults is valid, it's used to fill
+// the defaults of the fields of structs.
+func schemaForType(t reflect.Type, defaults reflect.Value) (map[string]any, error) {
+	if slices.Contains(quantityTypes, t) {
+		return map[string]any{"type": []string{"string", "number"}}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		// Defaults only apply to fields that are always present.
+		return schemaForType(t.Elem(), reflect.Value{})
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema := map[string]any{"type": "integer", "minimum": 0}
+		if t.Bits() < 64 {
+			schema["maximum"] = uint64(1)<<t.Bits() - 1
+		}
+		return schema, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Slice:
+		items, err := schemaForType(t.Elem(), reflect.Value{})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := schemaForType(t.Elem(), reflect.Value{})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		return schemaForStruct(t, defaults)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+func schemaForStruct(t reflect.Type, defaults reflect.Value) (map[string]any, error) {
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return nil, fmt.Errorf("unsupported type %s with custom JSON decoding", t)
+	}
+
+	properties := make(map[string]any)
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		} else if field.Anonymous {
+			return nil, fmt.Errorf("unsupported embedded field %s.%s", t, field.Name)
+		}
+
+		jsonName := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if name, _, _ := strings.Cut(tag, ","); name == "-" {
+				continue
+			} else if name != "" {
+				jsonName = name
+			}
+		}
+
+		var fieldDefault reflect.Value
+		if defaults.IsValid() {
+			fieldDefault = defaults.Field(i)
+		}
+
+		schema, err := schemaForType(field.Type, fieldDefault)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", jsonName, err)
+		}
+
+		if fieldDefault.IsValid() && !fieldDefault.IsZero() && field.Type.Kind() != reflect.Struct {
+			schema["default"] = fieldDefault.Interface()
+		}
+
+		isRequired, err := applySchemaTag(schema, field.Tag.Get("schema"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid schema tag: %w", jsonName, err)
+		}
+		if isRequired {
+			required = append(required, jsonName)
+		}
+
+		properties[jsonName] = schema
+	}
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+		// ReadConfig disallows unknown fields.
+		"additionalProperties": false,
+	}
+	if len(required) != 0 {
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+// applySchemaTag adds the constraints from the value of a field's "schema" struct tag to its
+// schema, returning whether the field is required.
+func applySchemaTag(schema map[string]any, tag string) (required bool, _ error) {
+	if tag == "" {
+		return false, nil
+	}
+
+	for _, entry := range strings.Split(tag, ",") {
+		keyword, value, hasValue := strings.Cut(entry, "=")
+		switch {
+		case keyword == "required" && !hasValue:
+			required = true
+		case keyword == "enum" && hasValue:
+			schema["enum"] = strings.Split(value, "|")
+		case hasValue:
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				return false, fmt.Errorf("invalid number %q for %q", value, keyword)
+			}
+			schema[keyword] = n
+		default:
+			return false, fmt.Errorf("unknown entry %q", entry)
+		}
+	}
+
+	return required, nil
+}
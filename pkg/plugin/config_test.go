@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfigYAML(t *testing.T) {
+	const configYAML = `
+watermark: 0.9
+scoring:
+  minUsageScore: 0.5
+  maxUsageScore: 0
+  scorePeak: 0.8
+  randomize: true
+schedulerName: autoscale-scheduler
+reconcileWorkers: 16
+logSuccessiveFailuresThreshold: 10
+startupEventHandlingTimeoutSeconds: 15
+patchRetryWaitSeconds: 1
+k8sCRUDTimeoutSeconds: 1
+nodeMetricLabels: {}
+ignoredNamespaces: []
+`
+	expected := defaultBenchmarkConfig()
+
+	// YAML is detected by the extension, or otherwise by the contents
+	for _, name := range []string{"config.yaml", "config.json"} {
+		path := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(path, []byte(configYAML), 0o644))
+
+		config, err := ReadConfig(path)
+		require.NoError(t, err)
+		expected.path = path
+		assert.Equal(t, expected, config)
+	}
+
+	// Unknown fields are rejected, same as with JSON
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(configYAML+"unknownField: true\n"), 0o644))
+	_, err := ReadConfig(path)
+	assert.ErrorContains(t, err, "unknownField")
+
+	// Validation errors are reported in the same way
+	path = filepath.Join(t.TempDir(), "config.yml")
+	invalid := strings.Replace(configYAML, "reconcileWorkers: 16", "reconcileWorkers: -1", 1)
+	require.NoError(t, os.WriteFile(path, []byte(invalid), 0o644))
+	_, err = ReadConfig(path)
+	assert.ErrorContains(t, err, "Invalid config at reconcileWorkers")
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	manifests, err := os.ReadFile("../../autoscale-scheduler/config_map.yaml")
+	require.NoError(t, err)
+
+	write := func(name string, contents string) string {
+		path := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+		return path
+	}
+
+	// The ConfigMap in the manifests is found and checked
+	assert.NoError(t, ValidateConfigFile(write("manifests.yaml", string(manifests))))
+	invalid := strings.Replace(string(manifests), `"watermark": 0.9`, `"watermark": 2.0`, 1)
+	require.NotEqual(t, string(manifests), invalid)
+	err = ValidateConfigFile(write("manifests.yaml", invalid))
+	assert.ErrorContains(t, err, "Invalid config in ConfigMap kube-system/scheduler-plugin-config at watermark")
+
+	// Plain config files are checked directly
+	config, err := json.Marshal(defaultBenchmarkConfig())
+	require.NoError(t, err)
+	assert.NoError(t, ValidateConfigFile(write("config.json", string(config))))
+	err = ValidateConfigFile(write("config.json", `{"unknownField": true}`))
+	assert.ErrorContains(t, err, "unknownField")
+}
+
+func TestScoringOverrides(t *testing.T) {
+	override := func(selector map[string]string, peak float64) ScoringOverride {
+		return ScoringOverride{
+			NodeSelector:  selector,
+			MinUsageScore: 0.5,
+			MaxUsageScore: 0.5,
+			ScorePeak:     peak,
+		}
+	}
+
+	cfg := defaultBenchmarkConfig().Scoring
+	cfg.ScoringOverrides = []ScoringOverride{
+		override(map[string]string{"nodegroup": "small"}, 0.5),
+		override(map[string]string{"nodegroup": "large", "zone": "a"}, 0.9),
+	}
+	_, err := cfg.validate()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0.5, cfg.forNode(map[string]string{"nodegroup": "small", "zone": "b"}).ScorePeak)
+	assert.Equal(t, 0.9, cfg.forNode(map[string]string{"nodegroup": "large", "zone": "a"}).ScorePeak)
+	// Nodes without a matching override use the default
+	assert.Equal(t, cfg.ScorePeak, cfg.forNode(map[string]string{"nodegroup": "large", "zone": "b"}).ScorePeak)
+
+	// A node could have both nodegroup=small and zone=a, so this overlaps with the first override
+	cfg.ScoringOverrides = append(cfg.ScoringOverrides, override(map[string]string{"zone": "a"}, 0.7))
+	path, err := cfg.validate()
+	assert.Error(t, err)
+	assert.Equal(t, "scoringOverrides[2].nodeSelector", path)
+}
+
+func TestConfigResourceWatermarks(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.Watermark = 0.9
+
+	// Both fall back to the watermark
+	assert.Equal(t, 0.9, config.cpuWatermark())
+	assert.Equal(t, 0.9, config.memWatermark())
+
+	config.MemoryWatermark = 0.75
+	assert.Equal(t, 0.9, config.cpuWatermark())
+	assert.Equal(t, 0.75, config.memWatermark())
+
+	config.CPUWatermark = 1.5
+	path, err := config.validate()
+	assert.Error(t, err)
+	assert.Equal(t, "cpuWatermark", path)
+}
+
+func TestConfigEnvOverrides(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	applied, err := config.applyEnvOverrides([]string{
+		"HOME=/root",
+		"AUTOSCALE_ENFORCER_WATERMARK=0.85",
+		"AUTOSCALE_ENFORCER_SCORING_SCORE_PEAK=0.7",
+		"AUTOSCALE_ENFORCER_SCHEDULER_NAME=other-scheduler",
+		"AUTOSCALE_ENFORCER_K8S_CRUD_TIMEOUT_SECONDS=5",
+		"AUTOSCALE_ENFORCER_IGNORED_NAMESPACES=[\"kube-system\"]",
+		// Parent fields are applied before their children
+		"AUTOSCALE_ENFORCER_VMS_PER_NODE_MAX=20",
+		"AUTOSCALE_ENFORCER_VMS_PER_NODE={\"max\":10}",
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"AUTOSCALE_ENFORCER_WATERMARK",
+		"AUTOSCALE_ENFORCER_SCORING_SCORE_PEAK",
+		"AUTOSCALE_ENFORCER_SCHEDULER_NAME",
+		"AUTOSCALE_ENFORCER_K8S_CRUD_TIMEOUT_SECONDS",
+		"AUTOSCALE_ENFORCER_IGNORED_NAMESPACES",
+		"AUTOSCALE_ENFORCER_VMS_PER_NODE",
+		"AUTOSCALE_ENFORCER_VMS_PER_NODE_MAX",
+	}, applied)
+
+	assert.Equal(t, 0.85, config.Watermark)
+	assert.Equal(t, 0.7, config.Scoring.ScorePeak)
+	assert.Equal(t, "other-scheduler", config.SchedulerName)
+	assert.Equal(t, 5, config.K8sCRUDTimeoutSeconds)
+	assert.Equal(t, []string{"kube-system"}, config.IgnoredNamespaces)
+	require.NotNil(t, config.VMsPerNode)
+	assert.Equal(t, 20, config.VMsPerNode.Max)
+
+	// Unknown variables and unparseable values are errors
+	_, err = defaultBenchmarkConfig().applyEnvOverrides([]string{"AUTOSCALE_ENFORCER_WATERMARKK=0.85"})
+	assert.ErrorContains(t, err, "AUTOSCALE_ENFORCER_WATERMARKK")
+	_, err = defaultBenchmarkConfig().applyEnvOverrides([]string{"AUTOSCALE_ENFORCER_WATERMARK=high"})
+	assert.ErrorContains(t, err, "Invalid value for AUTOSCALE_ENFORCER_WATERMARK")
+	_, err = defaultBenchmarkConfig().applyEnvOverrides([]string{"AUTOSCALE_ENFORCER_VMS_PER_NODE_MAX=10"})
+	assert.ErrorContains(t, err, "vmsPerNode is not set")
+
+	// Overrides go through the same validation as the rest of the config
+	path := filepath.Join(t.TempDir(), "config.json")
+	data, err := json.Marshal(defaultBenchmarkConfig())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	t.Setenv("AUTOSCALE_ENFORCER_WATERMARK", "0.85")
+	config, err = ReadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 0.85, config.Watermark)
+	assert.Equal(t, []string{"AUTOSCALE_ENFORCER_WATERMARK"}, config.EnvOverrides())
+
+	t.Setenv("AUTOSCALE_ENFORCER_WATERMARK", "1.5")
+	_, err = ReadConfig(path)
+	assert.ErrorContains(t, err, "Invalid config at watermark")
+}
+
+func TestConfigDefaults(t *testing.T) {
+	// An empty config is valid, with everything set to the defaults
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte("{}"), 0o644))
+	config, err := ReadConfig(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultScoringConfig(), config.Scoring)
+	assert.Equal(t, DefaultWatermark, config.Watermark)
+	assert.Equal(t, DefaultSchedulerName, config.SchedulerName)
+	assert.Equal(t, DefaultReconcileWorkers, config.ReconcileWorkers)
+	assert.Equal(t, DefaultLogSuccessiveFailuresThreshold, config.LogSuccessiveFailuresThreshold)
+	assert.Equal(t, DefaultStartupEventHandlingTimeoutSeconds, config.StartupEventHandlingTimeoutSeconds)
+	assert.Equal(t, DefaultK8sCRUDTimeoutSeconds, config.K8sCRUDTimeoutSeconds)
+	assert.Equal(t, DefaultPatchRetryWaitSeconds, config.PatchRetryWaitSeconds)
+
+	// Fields that are set are kept, including a scoring curve with some fields set to zero
+	config = &Config{} //nolint:exhaustruct // only setting the fields under test
+	config.Watermark = 0.5
+	config.Scoring.ScorePeak = 0.6
+	config.Default()
+	assert.Equal(t, 0.5, config.Watermark)
+	assert.Equal(t, 0.0, config.Scoring.MinUsageScore)
+	assert.Equal(t, 0.0, config.Scoring.MaxUsageScore)
+	assert.Equal(t, 0.6, config.Scoring.ScorePeak)
+
+	// The benchmark config, which sets everything, is unchanged by defaulting
+	expected := defaultBenchmarkConfig()
+	config = defaultBenchmarkConfig()
+	config.Default()
+	assert.Equal(t, expected, config)
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema, err := ConfigSchema()
+	require.NoError(t, err)
+
+	// The checked-in schema is used by CI and editors, so it must match the config types
+	checkedIn, err := os.ReadFile(filepath.Join("..", "..", ConfigSchemaPath))
+	require.NoError(t, err)
+	assert.Equal(t, string(checkedIn), string(schema),
+		"%s is out of date. Run 'make config-schema' to regenerate it", ConfigSchemaPath)
+
+	var parsed struct {
+		Properties map[string]struct {
+			Default any `json:"default"`
+		} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(schema, &parsed))
+	assert.Equal(t, DefaultWatermark, parsed.Properties["watermark"].Default)
+	assert.Equal(t, DefaultSchedulerName, parsed.Properties["schedulerName"].Default)
+	assert.Nil(t, parsed.Properties["patchMaxAttempts"].Default)
+}
@@ -0,0 +1,186 @@
+package plugin
+
+// Cordoning nodes that are far above the watermark, as configured by (Config).CordonWatermark.
+//
+// Migrating VMs away from busy nodes only helps with VMs -- the default scheduler may still place
+// other pods there. Above a second, higher threshold, we cordon the node (or just annotate it, for
+// other tools to act on), and remove the cordon once usage drops back below a lower threshold.
+//
+// We only ever uncordon nodes that we cordoned, which we track with annotationCordoned:
+//
+//   - Nodes that are already unschedulable (e.g., cordoned by an operator for maintenance) are
+//     only annotated, never cordoned, so that we don't later undo someone else's cordon.
+//   - If a node we cordoned is made schedulable by someone else, the cordon is no longer ours, and
+//     we downgrade the annotation to cordonModeAnnotate.
+//
+// Cordoning a node that we've already cordoned doesn't change it, so we can't tell that it
+// happened. To keep such a node cordoned, remove annotationCordoned from it as well.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"golang.org/x/exp/constraints"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// annotationCordoned is set on nodes that the plugin cordoned or annotated for being above the
+// cordon watermark. Its value is cordonModeCordon or cordonModeAnnotate.
+const annotationCordoned = "autoscaling.neon.tech/cordoned-above-watermark"
+
+const (
+	// cordonModeCordon marks that the node was also made unschedulable.
+	cordonModeCordon = "cordon"
+	// cordonModeAnnotate marks that the node was only annotated.
+	cordonModeAnnotate = "annotate"
+)
+
+// cordonChange is a change to make to a node's cordon.
+type cordonChange struct {
+	nodeName string
+	// mode is the value for annotationCordoned, or empty if the annotation should be removed.
+	mode string
+	// unschedulable is whether the node should be made unschedulable (if true) or schedulable (if
+	// false). If nil, it's left alone.
+	unschedulable *bool
+}
+
+// runCordonController periodically cordons and uncordons nodes as their usage crosses the
+// thresholds in the config, until the context is canceled.
+func (s *PluginState) runCordonController(ctx context.Context, logger *zap.Logger, config CordonWatermarkConfig) {
+	ticker := time.NewTicker(time.Second * time.Duration(config.IntervalSeconds))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.leading.Load() {
+			continue // standby replicas leave cordoning to the leader
+		}
+
+		for _, change := range s.cordonChanges(config) {
+			if err := s.applyCordonChange(logger, change); err != nil {
+				logger.Error("Failed to update Node cordon", zap.String("Node", change.nodeName), zap.Error(err))
+			}
+		}
+	}
+}
+
+// cordonChanges returns the changes to make to nodes' cordons, given their current usage.
+//
+// Nodes are cordoned when either resource is at or above config.Cordon, and uncordoned once both
+// are below config.Uncordon.
+func (s *PluginState) cordonChanges(config CordonWatermarkConfig) []cordonChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes []cordonChange
+	for name, ns := range s.nodes {
+		usage := max(reservedFraction(ns.node.CPU), reservedFraction(ns.node.Mem))
+
+		// The cordon is only ours if we set it, and it's still there.
+		ownsCordon := ns.cordoned == cordonModeCordon && ns.unschedulable
+
+		if ns.cordoned == "" && usage >= config.Cordon {
+			change := cordonChange{nodeName: name, mode: cordonModeAnnotate, unschedulable: nil}
+			// Don't cordon nodes that are already unschedulable, otherwise we'd take over the
+			// existing cordon, and later remove it.
+			if !config.AnnotateOnly && !ns.unschedulable {
+				change.mode = cordonModeCordon
+				change.unschedulable = lo.ToPtr(true)
+			}
+			changes = append(changes, change)
+		} else if ns.cordoned != "" && usage < config.Uncordon {
+			change := cordonChange{nodeName: name, mode: "", unschedulable: nil}
+			// Only make the node schedulable if it was us that made it unschedulable.
+			if ownsCordon {
+				change.unschedulable = lo.ToPtr(false)
+			}
+			changes = append(changes, change)
+		} else if ns.cordoned == cordonModeCordon && !ownsCordon {
+			// Someone else made the node schedulable again. Leave it to them from now on.
+			changes = append(changes, cordonChange{nodeName: name, mode: cordonModeAnnotate, unschedulable: nil})
+		}
+	}
+	return changes
+}
+
+// reservedFraction returns the fraction of the node's resource that's reserved.
+func reservedFraction[T constraints.Unsigned](r state.NodeResources[T]) float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Reserved) / float64(r.Total)
+}
+
+// applyCordonChange patches the node to make the change.
+func (s *PluginState) applyCordonChange(logger *zap.Logger, change cordonChange) error {
+	var annotationValue any // nil removes the annotation
+	if change.mode != "" {
+		annotationValue = change.mode
+	}
+	patch := map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{annotationCordoned: annotationValue},
+		},
+	}
+	if change.unschedulable != nil {
+		patch["spec"] = map[string]any{"unschedulable": *change.unschedulable}
+	}
+
+	payload, err := json.Marshal(patch)
+	if err != nil {
+		panic(fmt.Errorf("could not marshal JSON patch: %w", err))
+	}
+
+	action := "uncordon"
+	if change.mode != "" {
+		action = "cordon"
+	}
+	logger.Info(
+		"Updating Node cordon for cordon watermark",
+		zap.String("Node", change.nodeName),
+		zap.String("Action", action),
+		zap.String("Mode", change.mode),
+	)
+
+	if err := s.patchNode(logger, change.nodeName, payload); err != nil {
+		return err
+	}
+	s.metrics.NodeCordons.WithLabelValues(action).Inc()
+	return nil
+}
+
+// nodePatcher returns the function for PluginState.patchNode, which applies a JSON merge patch to
+// the node.
+func (s *PluginState) nodePatcher(client corev1client.NodesGetter) func(*zap.Logger, string, []byte) error {
+	return func(logger *zap.Logger, nodeName string, patch []byte) error {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Second*time.Duration(s.config().K8sCRUDTimeoutSeconds))
+		defer cancel()
+
+		start := time.Now()
+		_, err := client.Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+		s.apiHealth.Observe(time.Since(start), err)
+		s.metrics.RecordK8sOp("Patch", "Node", nodeName, err)
+		return err
+	}
+}
+
+// cordonAnnotationOf returns the value of annotationCordoned on the node, or empty if it's not set.
+func cordonAnnotationOf(node *corev1.Node) string {
+	return node.Annotations[annotationCordoned]
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestCordonWatermark(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.Watermark = 0.8
+	cordonConfig := CordonWatermarkConfig{
+		Cordon:          0.95,
+		Uncordon:        0.85,
+		AnnotateOnly:    false,
+		IntervalSeconds: 10,
+	}
+	config.CordonWatermark = &cordonConfig
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	patches := make(map[string]map[string]any)
+	s.patchNode = func(_ *zap.Logger, nodeName string, patch []byte) error {
+		var decoded map[string]any
+		assert.NoError(t, json.Unmarshal(patch, &decoded))
+		patches[nodeName] = decoded
+		return nil
+	}
+
+	cases := []struct {
+		name     string
+		cpu      vmv1.MilliCPU
+		cordoned string
+		// unschedulable is the node's spec.unschedulable
+		unschedulable bool
+		// expected is the expected patch, or nil if there should be no change.
+		expected map[string]any
+	}{
+		{"below", 8000, "", false, nil},
+		{"above-cordon", 9500, "", false, map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{annotationCordoned: cordonModeCordon}},
+			"spec":     map[string]any{"unschedulable": true},
+		}},
+		{"already-cordoned", 9900, cordonModeCordon, true, nil},
+		// Between the thresholds, nothing changes either way
+		{"between-not-cordoned", 9000, "", false, nil},
+		{"between-cordoned", 9000, cordonModeCordon, true, nil},
+		{"below-uncordon", 8000, cordonModeCordon, true, map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{annotationCordoned: nil}},
+			"spec":     map[string]any{"unschedulable": false},
+		}},
+		// Nodes we only annotated aren't made schedulable
+		{"below-uncordon-annotated", 8000, cordonModeAnnotate, true, map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{annotationCordoned: nil}},
+		}},
+		// Nodes that someone else cordoned are only annotated, so that we don't uncordon them later
+		{"above-cordoned-by-someone-else", 9500, "", true, map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{annotationCordoned: cordonModeAnnotate}},
+		}},
+		// If someone else uncordoned a node that we cordoned, it's no longer ours
+		{"above-uncordoned-by-someone-else", 9900, cordonModeCordon, false, map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{annotationCordoned: cordonModeAnnotate}},
+		}},
+		{"below-uncordoned-by-someone-else", 8000, cordonModeCordon, false, map[string]any{
+			"metadata": map[string]any{"annotations": map[string]any{annotationCordoned: nil}},
+		}},
+	}
+
+	for i, c := range cases {
+		name := fmt.Sprintf("node-%d", i)
+		node := state.NodeStateFromParams(name, 10000, 40*1024*1024*1024, config.Watermark, nil)
+		node.AddPod(preemptionTestPod(fmt.Sprintf("vm-%d", i), c.cpu, true))
+		s.nodes[name] = &nodeState{node: node, cordoned: c.cordoned, unschedulable: c.unschedulable} //nolint:exhaustruct // only need the node and cordon
+	}
+
+	for _, change := range s.cordonChanges(cordonConfig) {
+		assert.NoError(t, s.applyCordonChange(zap.NewNop(), change))
+	}
+
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			patch, ok := patches[fmt.Sprintf("node-%d", i)]
+			assert.Equal(t, c.expected != nil, ok)
+			if c.expected != nil {
+				assert.Equal(t, c.expected, patch)
+			}
+		})
+	}
+
+	// With AnnotateOnly, nodes are only annotated
+	clear(patches)
+	cordonConfig.AnnotateOnly = true
+	for _, change := range s.cordonChanges(cordonConfig) {
+		if change.nodeName == "node-1" {
+			assert.NoError(t, s.applyCordonChange(zap.NewNop(), change))
+		}
+	}
+	assert.Equal(t, map[string]any{
+		"metadata": map[string]any{"annotations": map[string]any{annotationCordoned: cordonModeAnnotate}},
+	}, patches["node-1"])
+
+	// The cordon threshold must be above the watermark
+	config.CordonWatermark = lo.ToPtr(cordonConfig)
+	config.CordonWatermark.Cordon = 0.8
+	path, err := config.validate()
+	assert.Error(t, err)
+	assert.Equal(t, "cordonWatermark.cordon", path)
+}
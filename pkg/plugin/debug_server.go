@@ -0,0 +1,384 @@
+package plugin
+
+// Debug server, as configured by (Config).DebugServer.
+//
+// Unlike /dump-state on the main HTTP server -- which only returns the summary that configdiff
+// needs -- the debug server returns the plugin's full in-memory state for each node and pod, along
+// with the reservations and reconcile operations that are still pending. It's served on a separate
+// port so that it can be exposed (or not) independently of the autoscaler-agent endpoints.
+//
+// The debug server also serves the /drain endpoint (see drain.go), the /reevaluate endpoint, and the
+// /simulate endpoint (see simulate.go), but only when a bearer token is configured: unlike the rest
+// of the server, they change the cluster or do work across every node, so must never be open to
+// anything that can reach the port.
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"golang.org/x/exp/constraints"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// DebugState is the full internal state of the plugin, as returned by the debug server.
+type DebugState struct {
+	StartupDone bool `json:"startupDone"`
+	// Nodes is the state of each node, ordered by name.
+	Nodes []DebugNodeState `json:"nodes"`
+	// TentativelyScheduled maps the UIDs of pods that have been reserved on a node but not yet
+	// bound to it, to the name of that node.
+	TentativelyScheduled map[types.UID]string `json:"tentativelyScheduled"`
+	// RequeueAfterStartup is the set of pods whose reconciling is waiting for startup to finish.
+	RequeueAfterStartup []types.UID `json:"requeueAfterStartup"`
+	// Reconciles is the number of pending and ongoing reconcile operations.
+	Reconciles reconcile.QueueStats `json:"reconciles"`
+}
+
+// DebugNodeState is the state of a single node in a DebugState.
+type DebugNodeState struct {
+	Name   string                            `json:"name"`
+	Labels map[string]string                 `json:"labels"`
+	CPU    DebugNodeResources[vmv1.MilliCPU] `json:"cpu"`
+	Mem    DebugNodeResources[api.Bytes]     `json:"mem"`
+	// NetworkBandwidth is only set if the node's bandwidth is known.
+	NetworkBandwidth *DebugNetworkBandwidth `json:"networkBandwidth,omitempty"`
+
+	MemoryPressure bool   `json:"memoryPressure"`
+	OverWatermark  bool   `json:"overWatermark"`
+	ScaleDown      string `json:"scaleDown,omitempty"`
+	Cordoned       string `json:"cordoned,omitempty"`
+
+	DrainRequestedAt   *time.Time `json:"drainRequestedAt,omitempty"`
+	DefragmentingUntil *time.Time `json:"defragmentingUntil,omitempty"`
+
+	// RequestedMigrations maps the UIDs of pods that we've decided to migrate to the time that we
+	// decided to do so.
+	RequestedMigrations map[types.UID]time.Time `json:"requestedMigrations"`
+	// StartingMigrations lists the pods in RequestedMigrations that we've created a migration for,
+	// but haven't yet seen start.
+	StartingMigrations []types.UID `json:"startingMigrations"`
+
+	// Pods is the state of each pod on the node, ordered by namespace and name.
+	Pods []DebugPodState `json:"pods"`
+}
+
+// DebugNodeResources is the state of one resource on a node in a DebugState.
+//
+// Refer to state.NodeResources for the meaning of each field.
+type DebugNodeResources[T constraints.Unsigned] struct {
+	Capacity    T `json:"capacity"`
+	Allocatable T `json:"allocatable"`
+	Total       T `json:"total"`
+	Reserved    T `json:"reserved"`
+	Migrating   T `json:"migrating"`
+	WarmPool    T `json:"warmPool"`
+	Watermark   T `json:"watermark"`
+}
+
+// DebugNetworkBandwidth is the network bandwidth of a node in a DebugState, in bits per second.
+type DebugNetworkBandwidth struct {
+	Total    uint64 `json:"total"`
+	Reserved uint64 `json:"reserved"`
+}
+
+// DebugPodState is the state of a single pod in a DebugState.
+//
+// Refer to state.Pod for the meaning of each field.
+type DebugPodState struct {
+	util.NamespacedName
+	UID       types.UID `json:"uid"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	VirtualMachine    *util.NamespacedName `json:"virtualMachine,omitempty"`
+	Migratable        bool                 `json:"migratable"`
+	AlwaysMigrate     bool                 `json:"alwaysMigrate,omitempty"`
+	DisruptionGroup   string               `json:"disruptionGroup,omitempty"`
+	Migrating         bool                 `json:"migrating"`
+	NetworkBandwidth  uint64               `json:"networkBandwidth,omitempty"`
+	ExtendedResources string               `json:"extendedResources,omitempty"`
+	WarmPool          bool                 `json:"warmPool,omitempty"`
+	Priority          int32                `json:"priority"`
+	MigrationCost     uint64               `json:"migrationCost,omitempty"`
+
+	CPU DebugPodResources[vmv1.MilliCPU] `json:"cpu"`
+	Mem DebugPodResources[api.Bytes]     `json:"mem"`
+}
+
+// DebugPodResources is the state of one resource for a pod in a DebugState.
+//
+// Refer to state.PodResources for the meaning of each field.
+type DebugPodResources[T constraints.Unsigned] struct {
+	Reserved             T `json:"reserved"`
+	Requested            T `json:"requested"`
+	Preapproved          T `json:"preapproved,omitempty"`
+	PreapprovalRequested T `json:"preapprovalRequested,omitempty"`
+	Factor               T `json:"factor"`
+}
+
+// StartDebugServer starts the debug server in the background, returning the current state of the
+// plugin at "/", and serving "/drain", "/reevaluate", and "/simulate" if a bearer token is
+// configured.
+//
+// queueStats is called on each request to fetch the state of the reconcile queue.
+func (s *PluginState) StartDebugServer(
+	logger *zap.Logger,
+	config DebugServerConfig,
+	queueStats func() reconcile.QueueStats,
+) error {
+	// Manually start the TCP listener so we can minimize errors in the background thread.
+	addr := net.TCPAddr{IP: net.IPv4zero, Port: int(config.Port)}
+	listener, err := net.ListenTCP("tcp", &addr)
+	if err != nil {
+		return fmt.Errorf("Error binding to %v: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", requireDebugBearerToken(logger, config, s.serveDebugState(logger, config, queueStats)))
+	if config.BearerTokenPath != "" {
+		mux.HandleFunc("/drain", requireDebugBearerToken(logger, config, s.serveDrain(logger.Named("drain"))))
+		mux.HandleFunc("/reevaluate", requireDebugBearerToken(logger, config, s.serveReevaluate(logger.Named("reevaluate"))))
+		mux.HandleFunc("/simulate", requireDebugBearerToken(logger, config, s.serveSimulate(logger.Named("simulate"))))
+	} else {
+		logger.Info("Not serving /drain, /reevaluate, or /simulate on debug server because no bearer token is configured")
+	}
+
+	go func() {
+		// note: like the autoscaler-agent's dump-state server, we don't shut this down. It should
+		// be possible to continue fetching the internal state after shutdown has started.
+		server := &http.Server{Handler: mux}
+		if err := server.Serve(listener); err != nil {
+			logger.Error("Debug server exited", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// serveDebugState handles requests for the current state of the plugin.
+func (s *PluginState) serveDebugState(
+	logger *zap.Logger,
+	config DebugServerConfig,
+	queueStats func() reconcile.QueueStats,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("must be GET"))
+			return
+		}
+
+		timeout := time.Second * time.Duration(config.TimeoutSeconds)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		// Fetch the state in a separate goroutine, so that we still respond if the lock is held
+		// for too long -- which is itself useful to know when debugging.
+		result := make(chan DebugState, 1)
+		go func() {
+			result <- s.debugState(queueStats())
+		}()
+
+		var debugState DebugState
+		select {
+		case <-ctx.Done():
+			w.Header().Add("Content-Type", ContentTypeError)
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte(fmt.Sprintf("timed out after %s while getting state", timeout)))
+			return
+		case debugState = <-result:
+		}
+
+		body, err := json.Marshal(debugState)
+		if err != nil {
+			logger.Panic("Failed to encode response JSON", zap.Error(err))
+		}
+		w.Header().Add("Content-Type", ContentTypeJSON)
+		w.WriteHeader(200)
+		_, _ = w.Write(body)
+	}
+}
+
+// requireDebugBearerToken wraps the handler so that requests are only passed through if they have
+// the bearer token, if one is configured.
+func requireDebugBearerToken(
+	logger *zap.Logger,
+	config DebugServerConfig,
+	handler http.HandlerFunc,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.BearerTokenPath != "" {
+			if status, err := checkDebugBearerToken(config.BearerTokenPath, r); err != nil {
+				if status == 500 {
+					logger.Error("Failed to check debug server bearer token", zap.Error(err))
+				}
+				w.Header().Add("Content-Type", ContentTypeError)
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+		}
+
+		handler(w, r)
+	}
+}
+
+// checkDebugBearerToken checks that the request has the bearer token stored in the file at
+// tokenPath, returning the HTTP status to respond with if it doesn't.
+func checkDebugBearerToken(tokenPath string, r *http.Request) (status int, _ error) {
+	contents, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return 500, fmt.Errorf("could not read bearer token: %w", err)
+	}
+	expected := strings.TrimSpace(string(contents))
+	if expected == "" {
+		// Refuse all requests, rather than letting everything through.
+		return 500, errors.New("bearer token is empty")
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return 401, errors.New("missing or invalid bearer token")
+	}
+	return 200, nil
+}
+
+// debugState returns the DebugState for the current state.
+func (s *PluginState) debugState(reconciles reconcile.QueueStats) DebugState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes := make([]DebugNodeState, 0, len(s.nodes))
+	for _, name := range slices.Sorted(maps.Keys(s.nodes)) {
+		nodes = append(nodes, debugNodeState(name, s.nodes[name]))
+	}
+
+	requeueAfterStartup := slices.Sorted(maps.Keys(s.requeueAfterStartup))
+	if requeueAfterStartup == nil {
+		requeueAfterStartup = []types.UID{}
+	}
+
+	return DebugState{
+		StartupDone:          s.startupDone,
+		Nodes:                nodes,
+		TentativelyScheduled: maps.Clone(s.tentativelyScheduled),
+		RequeueAfterStartup:  requeueAfterStartup,
+		Reconciles:           reconciles,
+	}
+}
+
+// debugNodeState returns the DebugNodeState for the node.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func debugNodeState(name string, ns *nodeState) DebugNodeState {
+	var bandwidth *DebugNetworkBandwidth
+	if ns.node.NetworkBandwidth.Total != 0 {
+		bandwidth = &DebugNetworkBandwidth{
+			Total:    ns.node.NetworkBandwidth.Total,
+			Reserved: ns.node.NetworkBandwidth.Reserved,
+		}
+	}
+
+	optionalTime := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+
+	startingMigrations := slices.Sorted(maps.Keys(ns.startingMigrations))
+	if startingMigrations == nil {
+		startingMigrations = []types.UID{}
+	}
+
+	pods := []DebugPodState{}
+	for _, pod := range ns.node.Pods() {
+		pods = append(pods, debugPodState(pod))
+	}
+	slices.SortFunc(pods, func(x, y DebugPodState) int {
+		return cmp.Or(cmp.Compare(x.Namespace, y.Namespace), cmp.Compare(x.Name, y.Name))
+	})
+
+	return DebugNodeState{
+		Name:                name,
+		Labels:              maps.Clone(ns.labels),
+		CPU:                 debugNodeResources(ns.node.CPU),
+		Mem:                 debugNodeResources(ns.node.Mem),
+		NetworkBandwidth:    bandwidth,
+		MemoryPressure:      ns.memoryPressure,
+		OverWatermark:       ns.overWatermark,
+		ScaleDown:           string(ns.scaleDown),
+		Cordoned:            ns.cordoned,
+		DrainRequestedAt:    optionalTime(ns.drainRequestedAt),
+		DefragmentingUntil:  optionalTime(ns.defragmentingUntil),
+		RequestedMigrations: lo.Assign(ns.requestedMigrations),
+		StartingMigrations:  startingMigrations,
+		Pods:                pods,
+	}
+}
+
+func debugNodeResources[T constraints.Unsigned](r state.NodeResources[T]) DebugNodeResources[T] {
+	return DebugNodeResources[T]{
+		Capacity:    r.Capacity,
+		Allocatable: r.Allocatable,
+		Total:       r.Total,
+		Reserved:    r.Reserved,
+		Migrating:   r.Migrating,
+		WarmPool:    r.WarmPool,
+		Watermark:   r.Watermark,
+	}
+}
+
+func debugPodState(pod state.Pod) DebugPodState {
+	var vm *util.NamespacedName
+	if !lo.IsEmpty(pod.VirtualMachine) {
+		vm = &pod.VirtualMachine
+	}
+
+	return DebugPodState{
+		NamespacedName:    pod.NamespacedName,
+		UID:               pod.UID,
+		CreatedAt:         pod.CreatedAt,
+		VirtualMachine:    vm,
+		Migratable:        pod.Migratable,
+		AlwaysMigrate:     pod.AlwaysMigrate,
+		DisruptionGroup:   pod.DisruptionGroup,
+		Migrating:         pod.Migrating,
+		NetworkBandwidth:  pod.NetworkBandwidth,
+		ExtendedResources: pod.ExtendedResources.String(),
+		WarmPool:          pod.WarmPool,
+		Priority:          pod.Priority,
+		MigrationCost:     pod.MigrationCost,
+		CPU:               debugPodResources(pod.CPU),
+		Mem:               debugPodResources(pod.Mem),
+	}
+}
+
+func debugPodResources[T constraints.Unsigned](r state.PodResources[T]) DebugPodResources[T] {
+	return DebugPodResources[T]{
+		Reserved:             r.Reserved,
+		Requested:            r.Requested,
+		Preapproved:          r.Preapproved,
+		PreapprovalRequested: r.PreapprovalRequested,
+		Factor:               r.Factor,
+	}
+}
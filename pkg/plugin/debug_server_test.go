@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestDebugServer(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+	node.AddPod(preemptionTestPod("vm-b", 1000, true))
+	node.AddPod(preemptionTestPod("vm-a", 2000, true))
+	s.nodes["node-1"] = &nodeState{ //nolint:exhaustruct // only need the node and migrations
+		node:                node,
+		overWatermark:       true,
+		requestedMigrations: map[types.UID]time.Time{"vm-a": time.Now()},
+		startingMigrations:  map[types.UID]struct{}{"vm-a": {}},
+	}
+	s.tentativelyScheduled["vm-c"] = "node-1"
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenPath, []byte("secret\n"), 0o600))
+
+	debugConfig := DebugServerConfig{
+		Port:            1,
+		TimeoutSeconds:  5,
+		BearerTokenPath: tokenPath,
+	}
+	handler := requireDebugBearerToken(zap.NewNop(), debugConfig, s.serveDebugState(zap.NewNop(), debugConfig, func() reconcile.QueueStats {
+		return reconcile.QueueStats{Queued: 1, Pending: 2, Ongoing: 3}
+	}))
+
+	get := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, 401, get("").Code)
+	assert.Equal(t, 401, get("Bearer wrong").Code)
+
+	recorder := get("Bearer secret")
+	assert.Equal(t, 200, recorder.Code)
+
+	var dump DebugState
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &dump))
+	assert.Equal(t, reconcile.QueueStats{Queued: 1, Pending: 2, Ongoing: 3}, dump.Reconciles)
+	assert.Equal(t, map[types.UID]string{"vm-c": "node-1"}, dump.TentativelyScheduled)
+	assert.Len(t, dump.Nodes, 1)
+
+	nodeDump := dump.Nodes[0]
+	assert.Equal(t, "node-1", nodeDump.Name)
+	assert.True(t, nodeDump.OverWatermark)
+	assert.Equal(t, node.CPU.Reserved, nodeDump.CPU.Reserved)
+	assert.Equal(t, []types.UID{"vm-a"}, nodeDump.StartingMigrations)
+	assert.Contains(t, nodeDump.RequestedMigrations, types.UID("vm-a"))
+	// Pods are ordered by name
+	assert.Len(t, nodeDump.Pods, 2)
+	assert.Equal(t, "vm-a", nodeDump.Pods[0].Name)
+	assert.Equal(t, "vm-b", nodeDump.Pods[1].Name)
+}
@@ -0,0 +1,339 @@
+package plugin
+
+// Recording why each pod was placed where it was, as configured by (Config).DecisionLog.
+//
+// For each scheduling cycle of a pod, PreFilter stores a schedulingDecision in the CycleState,
+// which Filter, Score, and NormalizeScore then fill in as they're called for each node. Once the
+// outcome is known -- in Reserve if a node was chosen, or PostFilter if none were feasible -- the
+// decision is written out by the decisionLogger.
+//
+// Only the decisions made by this plugin are recorded: nodes rejected by other plugins before ours
+// only appear if the pod was unschedulable, and scores from other plugins aren't included.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// decisionStateKey is the key of the schedulingDecision in the framework.CycleState.
+const decisionStateKey framework.StateKey = PluginName + "/decision"
+
+const (
+	decisionOutcomeReserved      = "Reserved"
+	decisionOutcomeReserveFailed = "ReserveFailed"
+	decisionOutcomeUnschedulable = "Unschedulable"
+
+	// decisionFilterPassed is the filter result for nodes that our plugin allowed the pod onto.
+	decisionFilterPassed = "Passed"
+)
+
+// maxDecisionEventNoteLength is the maximum length of the note of an Event, as enforced by the API
+// server.
+const maxDecisionEventNoteLength = 1024
+
+// schedulingDecision accumulates the information about a single scheduling cycle of a pod.
+//
+// Filter is called concurrently for different nodes, so access is guarded by mu. All methods are
+// no-ops on a nil schedulingDecision, which is used when the decision log is disabled.
+type schedulingDecision struct {
+	mu    sync.Mutex
+	nodes map[string]*decisionCandidate
+}
+
+// decisionCandidate is the recorded information about a single node in a schedulingDecision.
+type decisionCandidate struct {
+	Node string `json:"node"`
+	// Filter is decisionFilterPassed, the reason the node was rejected, or empty if the node wasn't
+	// filtered by our plugin.
+	Filter          string `json:"filter,omitempty"`
+	Score           *int64 `json:"score,omitempty"`
+	NormalizedScore *int64 `json:"normalizedScore,omitempty"`
+}
+
+// decisionRecord is a single entry in the decision log.
+type decisionRecord struct {
+	Timestamp  time.Time           `json:"timestamp"`
+	Pod        util.NamespacedName `json:"pod"`
+	PodUID     types.UID           `json:"podUID"`
+	Outcome    string              `json:"outcome"`
+	Node       string              `json:"node,omitempty"`
+	Message    string              `json:"message,omitempty"`
+	Candidates []decisionCandidate `json:"candidates"`
+}
+
+func newSchedulingDecision() *schedulingDecision {
+	return &schedulingDecision{
+		mu:    sync.Mutex{},
+		nodes: make(map[string]*decisionCandidate),
+	}
+}
+
+// Clone implements framework.StateData.
+func (d *schedulingDecision) Clone() framework.StateData {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	nodes := make(map[string]*decisionCandidate, len(d.nodes))
+	for name, c := range d.nodes {
+		candidate := *c
+		nodes[name] = &candidate
+	}
+	return &schedulingDecision{mu: sync.Mutex{}, nodes: nodes}
+}
+
+// decisionFromCycleState returns the schedulingDecision stored by PreFilter, or nil if there isn't
+// one.
+func decisionFromCycleState(cycleState *framework.CycleState) *schedulingDecision {
+	if cycleState == nil {
+		return nil
+	}
+	data, err := cycleState.Read(decisionStateKey)
+	if err != nil {
+		return nil
+	}
+	return data.(*schedulingDecision)
+}
+
+// NOTE: this method expects that the caller has acquired d.mu.
+func (d *schedulingDecision) candidate(nodeName string) *decisionCandidate {
+	c, ok := d.nodes[nodeName]
+	if !ok {
+		c = &decisionCandidate{Node: nodeName, Filter: "", Score: nil, NormalizedScore: nil}
+		d.nodes[nodeName] = c
+	}
+	return c
+}
+
+// recordFilter records the result of our Filter for the node. status is nil if the node passed.
+func (d *schedulingDecision) recordFilter(nodeName string, status *framework.Status) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if status.IsSuccess() {
+		d.candidate(nodeName).Filter = decisionFilterPassed
+	} else {
+		d.candidate(nodeName).Filter = status.Message()
+	}
+}
+
+// recordStatuses records the reasons the nodes were rejected by any plugin, for nodes that our
+// Filter didn't reject.
+func (d *schedulingDecision) recordStatuses(statuses framework.NodeToStatusMap) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for nodeName, status := range statuses {
+		c := d.candidate(nodeName)
+		if c.Filter == "" || c.Filter == decisionFilterPassed {
+			c.Filter = status.Message()
+		}
+	}
+}
+
+// recordScore records the score that our Score gave the node.
+func (d *schedulingDecision) recordScore(nodeName string, score int64) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.candidate(nodeName).Score = &score
+}
+
+// recordNormalizedScores records the scores after NormalizeScore.
+func (d *schedulingDecision) recordNormalizedScores(scores framework.NodeScoreList) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range scores {
+		score := s.Score
+		d.candidate(s.Name).NormalizedScore = &score
+	}
+}
+
+// record returns the decisionRecord for the decision, with the candidates sorted by name.
+func (d *schedulingDecision) record(pod *corev1.Pod, outcome, nodeName, message string) decisionRecord {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	candidates := make([]decisionCandidate, 0, len(d.nodes))
+	for _, c := range d.nodes {
+		candidates = append(candidates, *c)
+	}
+	slices.SortFunc(candidates, func(x, y decisionCandidate) int {
+		return strings.Compare(x.Node, y.Node)
+	})
+
+	return decisionRecord{
+		Timestamp:  time.Now(),
+		Pod:        util.GetNamespacedName(pod),
+		PodUID:     pod.UID,
+		Outcome:    outcome,
+		Node:       nodeName,
+		Message:    message,
+		Candidates: candidates,
+	}
+}
+
+// eventNote returns a short human-readable summary of the record, for the note of an Event.
+func (r decisionRecord) eventNote() string {
+	var b strings.Builder
+	switch r.Outcome {
+	case decisionOutcomeReserved:
+		fmt.Fprintf(&b, "Chose node %s", r.Node)
+		for _, c := range r.Candidates {
+			if c.Node == r.Node && c.NormalizedScore != nil {
+				fmt.Fprintf(&b, " (score %d)", *c.NormalizedScore)
+			}
+		}
+	case decisionOutcomeReserveFailed:
+		fmt.Fprintf(&b, "Failed to reserve node %s: %s", r.Node, r.Message)
+	default:
+		b.WriteString("No node was feasible")
+		if r.Node != "" {
+			fmt.Fprintf(&b, ", nominated node %s for preemption", r.Node)
+		}
+	}
+
+	passed := 0
+	rejected := make(map[string]int)
+	for _, c := range r.Candidates {
+		if c.Filter == decisionFilterPassed {
+			passed += 1
+		} else if c.Filter != "" {
+			rejected[c.Filter] += 1
+		}
+	}
+	fmt.Fprintf(&b, "; %d of %d nodes passed", passed, len(r.Candidates))
+	for _, reason := range slices.Sorted(maps.Keys(rejected)) {
+		fmt.Fprintf(&b, "; %d: %s", rejected[reason], reason)
+	}
+
+	note := b.String()
+	if len(note) > maxDecisionEventNoteLength {
+		note = note[:maxDecisionEventNoteLength-3] + "..."
+	}
+	return note
+}
+
+// decisionLogger writes out scheduling decisions to the destinations given by the config.
+type decisionLogger struct {
+	logger *zap.Logger
+
+	// mu guards writing to file, so that records aren't interleaved.
+	mu   sync.Mutex
+	file io.WriteCloser // may be nil
+
+	recorder events.EventRecorder // may be nil
+}
+
+// newDecisionLogger returns the decisionLogger for the config, or nil if the config is nil.
+//
+// The file, if any, is closed when ctx is canceled.
+func newDecisionLogger(
+	ctx context.Context,
+	logger *zap.Logger,
+	config *DecisionLogConfig,
+	recorder events.EventRecorder,
+) *decisionLogger {
+	if config == nil {
+		return nil
+	}
+
+	l := &decisionLogger{
+		logger:   logger,
+		mu:       sync.Mutex{},
+		file:     nil,
+		recorder: nil,
+	}
+	if config.File != nil {
+		file := &lumberjack.Logger{
+			Filename:   config.File.Path,
+			MaxSize:    config.File.MaxSizeMB,
+			MaxAge:     0, // don't remove old files based on age; only MaxBackups.
+			MaxBackups: config.File.MaxBackups,
+			LocalTime:  false,
+			Compress:   config.File.Compress,
+		}
+		l.file = file
+		go func() {
+			<-ctx.Done()
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if err := file.Close(); err != nil {
+				logger.Error("Failed to close decision log file", zap.Error(err))
+			}
+		}()
+	}
+	if config.Events {
+		l.recorder = recorder
+	}
+	return l
+}
+
+// write records the outcome of the decision, if there is one.
+//
+// This method may be called without holding any locks.
+func (l *decisionLogger) write(
+	decision *schedulingDecision,
+	pod *corev1.Pod,
+	outcome string,
+	nodeName string,
+	message string,
+) {
+	if l == nil || decision == nil {
+		return
+	}
+
+	record := decision.record(pod, outcome, nodeName, message)
+
+	if l.file != nil {
+		line, err := json.Marshal(record)
+		if err != nil {
+			panic(fmt.Errorf("could not marshal decision record: %w", err))
+		}
+		line = append(line, '\n')
+
+		l.mu.Lock()
+		_, err = l.file.Write(line)
+		l.mu.Unlock()
+		if err != nil {
+			l.logger.Error("Failed to write to decision log file", zap.Error(err))
+		}
+	}
+
+	if l.recorder != nil {
+		eventType := corev1.EventTypeNormal
+		if outcome != decisionOutcomeReserved {
+			eventType = corev1.EventTypeWarning
+		}
+		l.recorder.Eventf(pod, nil, eventType, "SchedulingDecision", "Scheduling", "%s", record.eventNote())
+	}
+}
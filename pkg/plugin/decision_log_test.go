@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (*bufferCloser) Close() error { return nil }
+
+func TestDecisionLog(t *testing.T) {
+	pod := &corev1.Pod{ //nolint:exhaustruct // only need the metadata
+		ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // only need the name and UID
+			Namespace: "default",
+			Name:      "vm-1",
+			UID:       "pod-uid-1",
+		},
+	}
+
+	cycleState := framework.NewCycleState()
+	cycleState.Write(decisionStateKey, newSchedulingDecision())
+
+	decision := decisionFromCycleState(cycleState)
+	decision.recordFilter("node-1", nil)
+	decision.recordFilter("node-2", framework.NewStatus(framework.Unschedulable, filterReasonNotEnoughResources))
+	decision.recordFilter("node-3", nil)
+	decision.recordScore("node-1", 60)
+	decision.recordScore("node-3", 80)
+	decision.recordNormalizedScores(framework.NodeScoreList{
+		{Name: "node-1", Score: 55},
+		{Name: "node-3", Score: 72},
+	})
+
+	// Changes to clones don't affect the original
+	clone := decision.Clone().(*schedulingDecision)
+	clone.recordFilter("node-3", framework.NewStatus(framework.Unschedulable, "other"))
+
+	file := &bufferCloser{Buffer: bytes.Buffer{}}
+	l := &decisionLogger{logger: zap.NewNop(), file: file, recorder: nil} //nolint:exhaustruct // mu zero value is fine
+	l.write(decision, pod, decisionOutcomeReserved, "node-3", "")
+
+	var record decisionRecord
+	assert.NoError(t, json.Unmarshal(file.Bytes(), &record))
+	assert.Equal(t, util.NamespacedName{Namespace: "default", Name: "vm-1"}, record.Pod)
+	assert.Equal(t, decisionOutcomeReserved, record.Outcome)
+	assert.Equal(t, "node-3", record.Node)
+	assert.Equal(t, []decisionCandidate{
+		{Node: "node-1", Filter: decisionFilterPassed, Score: lo.ToPtr[int64](60), NormalizedScore: lo.ToPtr[int64](55)},
+		{Node: "node-2", Filter: filterReasonNotEnoughResources, Score: nil, NormalizedScore: nil},
+		{Node: "node-3", Filter: decisionFilterPassed, Score: lo.ToPtr[int64](80), NormalizedScore: lo.ToPtr[int64](72)},
+	}, record.Candidates)
+
+	assert.Equal(
+		t,
+		"Chose node node-3 (score 72); 2 of 3 nodes passed; 1: "+filterReasonNotEnoughResources,
+		record.eventNote(),
+	)
+
+	// Long notes are truncated
+	record.Message = strings.Repeat("x", 2*maxDecisionEventNoteLength)
+	record.Outcome = decisionOutcomeReserveFailed
+	assert.Len(t, record.eventNote(), maxDecisionEventNoteLength)
+
+	// Without a decision in the cycle state, nothing is recorded
+	file.Reset()
+	missing := decisionFromCycleState(framework.NewCycleState())
+	assert.Nil(t, missing)
+	missing.recordFilter("node-1", nil)
+	l.write(missing, pod, decisionOutcomeUnschedulable, "", "")
+	assert.Zero(t, file.Len())
+}
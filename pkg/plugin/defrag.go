@@ -0,0 +1,244 @@
+package plugin
+
+// Background defragmentation, as configured by (Config).Defragmentation.
+//
+// Watermark-based migrations only ever move VMs away from nodes that are too full, so over time the
+// cluster can end up with many nodes that are only lightly used. Periodically, we look for nodes
+// below the configured target utilization whose VMs could all be moved elsewhere in a few
+// migrations, and migrate them away -- leaving the node empty for cluster-autoscaler to remove.
+//
+// While a node is being emptied, pods are kept off it for the configured hold time, so that its
+// VMs don't just get scheduled back onto it.
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// filterReasonDefragmenting is the reason that Filter rejects a pod from a node that's being
+// emptied for defragmentation.
+const filterReasonDefragmenting = "Node is being emptied for defragmentation"
+
+// defragCandidate is a node that could be emptied by migrating all of its VMs.
+type defragCandidate struct {
+	name  string
+	ns    *nodeState
+	vms   []state.Pod
+	usage float64
+}
+
+// runDefragmentation periodically empties lightly-used nodes, until the context is canceled.
+func (s *PluginState) runDefragmentation(ctx context.Context, logger *zap.Logger, config DefragmentationConfig) {
+	ticker := time.NewTicker(time.Second * time.Duration(config.IntervalSeconds))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.leading.Load() {
+			continue // standby replicas leave migrations to the leader
+		}
+
+		s.defragment(logger, config, time.Now())
+	}
+}
+
+// defragment runs a single cycle of defragmentation, choosing nodes to empty and requesting the
+// migrations for their VMs.
+func (s *PluginState) defragment(logger *zap.Logger, config DefragmentationConfig, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if window := s.activeBlackoutWindow(now); window != "" {
+		logger.Info("Skipping defragmentation during migration blackout window", zap.String("Window", window))
+		return
+	}
+
+	candidates := s.defragCandidates(config, now)
+	if len(candidates) == 0 {
+		return
+	}
+
+	// Nodes that VMs may be moved to, with the amount of room they have left below the watermark.
+	// As we choose nodes to empty, this is updated to reflect where their VMs could go.
+	room := s.defragTargetRoom(now)
+	budget := config.MaxMigrationsPerCycle
+
+	for _, c := range candidates {
+		if len(c.vms) > budget {
+			continue
+		}
+
+		// The node is no longer a possible target, whether or not we empty it.
+		delete(room, c.name)
+		placement, ok := placeVMsForDefrag(c.vms, room)
+		if !ok {
+			continue
+		}
+		room = placement
+
+		nodeLogger := logger.With(zap.String("Node", c.name))
+		nodeLogger.Info(
+			"Emptying Node for defragmentation",
+			zap.Float64("Usage", c.usage),
+			zap.Int("VMs", len(c.vms)),
+		)
+		c.ns.defragmentingUntil = now.Add(time.Second * time.Duration(config.HoldSeconds))
+		for _, vm := range c.vms {
+			if err := s.requeuePod(vm.UID); err != nil {
+				nodeLogger.Error("Failed to requeue Pod for defragmentation", zap.Object("Pod", vm.NamespacedName), zap.Error(err))
+				continue
+			}
+			c.ns.requestedMigrations[vm.UID] = now
+		}
+		s.metrics.NodesDefragmented.Inc()
+
+		budget -= len(c.vms)
+		if budget == 0 {
+			break
+		}
+	}
+}
+
+// defragCandidates returns the nodes that could be emptied by migrating their VMs, least used
+// first.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) defragCandidates(config DefragmentationConfig, now time.Time) []defragCandidate {
+	disrupted := s.disruptedGroups()
+
+	var candidates []defragCandidate
+	for name, ns := range s.nodes {
+		if ns.defragmenting(now) || ns.scaleDown != scaleDownNone || ns.cordoned != "" || ns.overWatermark {
+			continue
+		}
+
+		usage := max(reservedFraction(ns.node.CPU), reservedFraction(ns.node.Mem))
+		if usage >= config.TargetUtilization {
+			continue
+		}
+
+		vms, ok := defragVMsOf(ns, disrupted)
+		if !ok || len(vms) == 0 {
+			continue
+		}
+
+		candidates = append(candidates, defragCandidate{name: name, ns: ns, vms: vms, usage: usage})
+	}
+
+	slices.SortFunc(candidates, func(x, y defragCandidate) int {
+		return cmp.Or(
+			cmp.Compare(len(x.vms), len(y.vms)),
+			cmp.Compare(x.usage, y.usage),
+			cmp.Compare(x.name, y.name),
+		)
+	})
+	return candidates
+}
+
+// defragVMsOf returns the VMs that would need to be migrated to empty the node, or false if the
+// node can't be emptied -- because it has VMs that can't be migrated, or that are already being
+// migrated, or because more than one of its VMs would be disrupted from the same disruption group.
+func defragVMsOf(ns *nodeState, disrupted map[util.NamespacedName]struct{}) (_ []state.Pod, ok bool) {
+	groups := make(map[util.NamespacedName]struct{})
+
+	var vms []state.Pod
+	for uid, pod := range ns.node.Pods() {
+		if lo.IsEmpty(pod.VirtualMachine) {
+			continue
+		}
+		if _, requested := ns.requestedMigrations[uid]; !pod.Migratable || pod.Migrating || requested {
+			return nil, false
+		}
+		if group, hasGroup := disruptionGroupOf(pod); hasGroup {
+			_, alreadyDisrupted := disrupted[group]
+			_, sameNode := groups[group]
+			if alreadyDisrupted || sameNode {
+				return nil, false
+			}
+			groups[group] = struct{}{}
+		}
+		vms = append(vms, pod)
+	}
+	return vms, true
+}
+
+// defragRoom is the amount of room a node has for more VMs, before it reaches the watermark.
+type defragRoom struct {
+	cpu vmv1.MilliCPU
+	mem api.Bytes
+}
+
+// defragTargetRoom returns the room on each node that VMs could be migrated to.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) defragTargetRoom(now time.Time) map[string]defragRoom {
+	room := make(map[string]defragRoom)
+	for name, ns := range s.nodes {
+		if ns.defragmenting(now) || ns.scaleDown != scaleDownNone || ns.cordoned != "" {
+			continue
+		}
+		room[name] = defragRoom{
+			cpu: util.SaturatingSub(ns.node.CPU.Watermark, ns.node.CPU.Reserved),
+			mem: util.SaturatingSub(ns.node.Mem.Watermark, ns.node.Mem.Reserved),
+		}
+	}
+	return room
+}
+
+// placeVMsForDefrag checks that all of the VMs could be placed onto other nodes without going above
+// the watermark, and returns the room that would be left on each node afterwards.
+//
+// VMs are placed largest first, onto the node with the least room that fits -- the scheduler itself
+// will place them however it's configured to, so this is only an estimate.
+func placeVMsForDefrag(vms []state.Pod, room map[string]defragRoom) (_ map[string]defragRoom, ok bool) {
+	vms = slices.Clone(vms)
+	slices.SortFunc(vms, func(x, y state.Pod) int {
+		return comparePodSize(y, x)
+	})
+
+	remaining := make(map[string]defragRoom, len(room))
+	for name, r := range room {
+		remaining[name] = r
+	}
+	names := lo.Keys(remaining)
+	slices.Sort(names) // deterministic choice between equal nodes
+
+	for _, vm := range vms {
+		best := ""
+		for _, name := range names {
+			r := remaining[name]
+			if r.cpu < vm.CPU.Reserved || r.mem < vm.Mem.Reserved {
+				continue
+			}
+			if best == "" || r.cpu < remaining[best].cpu {
+				best = name
+			}
+		}
+		if best == "" {
+			return nil, false
+		}
+		r := remaining[best]
+		remaining[best] = defragRoom{cpu: r.cpu - vm.CPU.Reserved, mem: r.mem - vm.Mem.Reserved}
+	}
+	return remaining, true
+}
+
+// defragmenting returns whether the node is currently being emptied for defragmentation.
+func (ns *nodeState) defragmenting(now time.Time) bool {
+	return now.Before(ns.defragmentingUntil)
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestDefragmentation(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.Watermark = 0.8
+	defragConfig := DefragmentationConfig{
+		IntervalSeconds:       60,
+		TargetUtilization:     0.3,
+		MaxMigrationsPerCycle: 2,
+		HoldSeconds:           600,
+	}
+	config.Defragmentation = &defragConfig
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	var requeued []types.UID
+	s.requeuePod = func(uid types.UID) error {
+		requeued = append(requeued, uid)
+		return nil
+	}
+
+	addNode := func(name string, vms ...state.Pod) *nodeState {
+		node := state.NodeStateFromParams(name, 10000, 40*1024*1024*1024, config.Watermark, nil)
+		for _, vm := range vms {
+			node.AddPod(vm)
+		}
+		ns := &nodeState{ //nolint:exhaustruct // only need the node and requested migrations
+			node:                node,
+			requestedMigrations: make(map[types.UID]time.Time),
+		}
+		s.nodes[name] = ns
+		return ns
+	}
+
+	unmigratable := preemptionTestPod("vm-e", 500, true)
+	unmigratable.Migratable = false
+
+	small := addNode("small", preemptionTestPod("vm-a", 1000, true), preemptionTestPod("vm-b", 1000, true))
+	busy := addNode("busy", preemptionTestPod("vm-c", 5000, true))
+	// Above the target utilization
+	large := addNode("large", preemptionTestPod("vm-d", 3500, true))
+	// Has a VM that can't be migrated, so can't be emptied
+	pinned := addNode("pinned", unmigratable)
+
+	now := time.Now()
+	s.defragment(zap.NewNop(), defragConfig, now)
+
+	assert.ElementsMatch(t, []types.UID{"vm-a", "vm-b"}, requeued)
+	assert.True(t, small.defragmenting(now))
+	assert.Len(t, small.requestedMigrations, 2)
+	for _, ns := range []*nodeState{busy, large, pinned} {
+		assert.False(t, ns.defragmenting(now))
+		assert.Empty(t, ns.requestedMigrations)
+	}
+
+	// Once the hold expires, the node can be used again
+	assert.False(t, small.defragmenting(now.Add(time.Second*time.Duration(defragConfig.HoldSeconds))))
+
+	// Running again doesn't choose the same node again, and there's no other node to empty.
+	requeued = nil
+	s.defragment(zap.NewNop(), defragConfig, now.Add(time.Minute))
+	assert.Empty(t, requeued)
+}
@@ -0,0 +1,346 @@
+package plugin
+
+// Draining nodes of VMs, on request.
+//
+// A drain is requested with the /drain endpoint on the plugin's debug server -- which is only served
+// when the debug server requires a bearer token -- and recorded on the Node with annotationDrain.
+// So the drain survives restarts of the scheduler, and may also be requested by setting the
+// annotation directly.
+//
+// While a node is draining, no pods are scheduled onto it, and each time it's balanced we request
+// migrations for all of its VMs. Those migrations are subject to the same limits as any other
+// (see migration_limits.go), and only one VM from each disruption group is migrated at a time.
+//
+// Drains are never finished automatically: once the node has no VMs left, the drain is reported as
+// complete, and it's up to the operator to remove the node or cancel the drain.
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// annotationDrain is set on nodes that are being drained, with the time that the drain was
+// requested, in RFC 3339 format.
+const annotationDrain = "autoscaling.neon.tech/drain-requested-at"
+
+// filterReasonDraining is the reason that Filter rejects a pod from a node that's being drained.
+const filterReasonDraining = "Node is being drained"
+
+// DrainPhase is the progress of draining a node.
+type DrainPhase string
+
+const (
+	// DrainPhaseInProgress means that there are VMs left on the node that can be migrated away.
+	DrainPhaseInProgress DrainPhase = "InProgress"
+	// DrainPhaseBlocked means that the only VMs left on the node can't be migrated away.
+	DrainPhaseBlocked DrainPhase = "Blocked"
+	// DrainPhaseComplete means that there are no VMs left on the node.
+	DrainPhaseComplete DrainPhase = "Complete"
+)
+
+// DrainStatus is the status of draining a node, as returned by the /drain endpoint.
+type DrainStatus struct {
+	Node        string     `json:"node"`
+	RequestedAt time.Time  `json:"requestedAt"`
+	Phase       DrainPhase `json:"phase"`
+	// RemainingVMs is the number of VMs still on the node, including ones that are migrating.
+	RemainingVMs int `json:"remainingVMs"`
+	// MigratingVMs is the number of VMs on the node that are currently being migrated away.
+	MigratingVMs int `json:"migratingVMs"`
+	// UnmigratableVMs lists the VMs on the node that can't be migrated, so must be handled
+	// manually for the drain to complete.
+	UnmigratableVMs []util.NamespacedName `json:"unmigratableVMs,omitempty"`
+}
+
+// drainRequestedAtOf returns the time that a drain of the node was requested, from its
+// annotationDrain. It returns zero if the node isn't being drained.
+func drainRequestedAtOf(logger *zap.Logger, node *corev1.Node) time.Time {
+	value, ok := node.Annotations[annotationDrain]
+	if !ok {
+		return time.Time{}
+	}
+	requestedAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		// Still drain the node: the annotation's presence is what matters.
+		logger.Warn("Could not parse Node drain annotation", zap.String("Value", value), zap.Error(err))
+		return time.Unix(0, 0)
+	}
+	return requestedAt
+}
+
+// draining returns whether the node is being drained.
+func (ns *nodeState) draining() bool {
+	return !ns.drainRequestedAt.IsZero()
+}
+
+// drainStatus returns the current status of draining the node.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func drainStatus(name string, ns *nodeState) DrainStatus {
+	status := DrainStatus{
+		Node:            name,
+		RequestedAt:     ns.drainRequestedAt,
+		Phase:           DrainPhaseComplete,
+		RemainingVMs:    0,
+		MigratingVMs:    0,
+		UnmigratableVMs: nil,
+	}
+	for _, pod := range ns.node.Pods() {
+		if lo.IsEmpty(pod.VirtualMachine) {
+			continue
+		}
+		status.RemainingVMs += 1
+		if pod.Migrating {
+			status.MigratingVMs += 1
+		} else if !pod.Migratable {
+			status.UnmigratableVMs = append(status.UnmigratableVMs, pod.VirtualMachine)
+		}
+	}
+	slices.SortFunc(status.UnmigratableVMs, func(x, y util.NamespacedName) int {
+		return cmp.Or(cmp.Compare(x.Namespace, y.Namespace), cmp.Compare(x.Name, y.Name))
+	})
+
+	if status.RemainingVMs > len(status.UnmigratableVMs) {
+		status.Phase = DrainPhaseInProgress
+	} else if status.RemainingVMs != 0 {
+		status.Phase = DrainPhaseBlocked
+	}
+	return status
+}
+
+// triggerDrainMigrations requests migrations for all of the VMs on the node that aren't already
+// migrating, so that the node ends up empty.
+//
+// Only one VM from each disruption group is migrated at a time. The rest are requested when the
+// node is next balanced.
+func triggerDrainMigrations(
+	logger *zap.Logger,
+	node *state.Node,
+	reason string,
+	requestedMigrations map[types.UID]time.Time,
+	disruptedGroups map[util.NamespacedName]struct{},
+	victimOrder func(x, y state.Pod) int,
+	requestMigrationAndRequeue func(podUID types.UID) error,
+) error {
+	var candidates []state.Pod
+	for _, pod := range node.MigratablePods() {
+		if _, requested := requestedMigrations[pod.UID]; pod.Migrating || requested {
+			continue
+		}
+		candidates = append(candidates, pod)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	logger.Info(
+		"Migrating remaining VMs away from Node",
+		zap.String("Reason", reason),
+		zap.Object("Node", node),
+		zap.Int("Candidates", len(candidates)),
+	)
+
+	slices.SortFunc(candidates, victimOrder)
+	for _, pod := range candidates {
+		podLogger := logger.With(zap.Any("CandidatePod", pod))
+
+		group, hasGroup := disruptionGroupOf(pod)
+		if _, disrupted := disruptedGroups[group]; hasGroup && disrupted {
+			podLogger.Info("Deferring migration of candidate Pod because its disruption group is already migrating")
+			continue
+		}
+
+		podLogger.Info("Internally triggering migration for candidate Pod")
+		if err := requestMigrationAndRequeue(pod.UID); err != nil {
+			podLogger.Error("Failed to requeue reconciling of candidate Pod")
+			return fmt.Errorf("could not requeue pod %v with UID %s: %w", pod.NamespacedName, pod.UID, err)
+		}
+		if hasGroup {
+			disruptedGroups[group] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// startDrain starts draining the node, if it isn't already being drained.
+func (s *PluginState) startDrain(logger *zap.Logger, nodeName string) (*DrainStatus, error) {
+	s.mu.Lock()
+	ns, ok := s.nodes[nodeName]
+	alreadyDraining := ok && ns.draining()
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, errNodeNotFound
+	}
+
+	if !alreadyDraining {
+		now := time.Now().UTC().Truncate(time.Second)
+		if err := s.patchDrainAnnotation(logger, nodeName, now.Format(time.RFC3339)); err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		if ns, ok := s.nodes[nodeName]; ok && !ns.draining() {
+			ns.drainRequestedAt = now
+		}
+		s.mu.Unlock()
+
+		s.metrics.NodeDrains.WithLabelValues("start").Inc()
+		if err := s.requeueNode(nodeName); err != nil {
+			logger.Error("Failed to requeue Node after starting drain", zap.String("Node", nodeName), zap.Error(err))
+		}
+	}
+
+	return s.getDrainStatus(nodeName)
+}
+
+// cancelDrain stops draining the node. Migrations that have already started are not canceled.
+func (s *PluginState) cancelDrain(logger *zap.Logger, nodeName string) error {
+	s.mu.Lock()
+	_, ok := s.nodes[nodeName]
+	s.mu.Unlock()
+
+	if !ok {
+		return errNodeNotFound
+	}
+
+	if err := s.patchDrainAnnotation(logger, nodeName, nil); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if ns, ok := s.nodes[nodeName]; ok {
+		ns.drainRequestedAt = time.Time{}
+	}
+	s.mu.Unlock()
+
+	s.metrics.NodeDrains.WithLabelValues("cancel").Inc()
+	return nil
+}
+
+// getDrainStatus returns the status of draining the node, or nil if it's not being drained.
+func (s *PluginState) getDrainStatus(nodeName string) (*DrainStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns, ok := s.nodes[nodeName]
+	if !ok {
+		return nil, errNodeNotFound
+	} else if !ns.draining() {
+		return nil, nil
+	}
+	return lo.ToPtr(drainStatus(nodeName, ns)), nil
+}
+
+// listDrainStatuses returns the status of every node that's being drained, ordered by name.
+func (s *PluginState) listDrainStatuses() []DrainStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := []DrainStatus{}
+	for name, ns := range s.nodes {
+		if ns.draining() {
+			statuses = append(statuses, drainStatus(name, ns))
+		}
+	}
+	slices.SortFunc(statuses, func(x, y DrainStatus) int {
+		return cmp.Compare(x.Node, y.Node)
+	})
+	return statuses
+}
+
+// patchDrainAnnotation sets annotationDrain on the node to the value, or removes it if the value
+// is nil.
+func (s *PluginState) patchDrainAnnotation(logger *zap.Logger, nodeName string, value any) error {
+	patch := map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{annotationDrain: value},
+		},
+	}
+	payload, err := json.Marshal(patch)
+	if err != nil {
+		panic(fmt.Errorf("could not marshal JSON patch: %w", err))
+	}
+
+	logger.Info("Updating Node drain annotation", zap.String("Node", nodeName), zap.Any("Value", value))
+	return s.patchNode(logger, nodeName, payload)
+}
+
+// errNodeNotFound is returned when a drain is requested for a node that's not in the local state.
+var errNodeNotFound = errors.New("node not found in local state")
+
+// serveDrain handles requests to the /drain endpoint:
+//
+//   - GET /drain returns the status of all nodes being drained;
+//   - GET /drain?node=<name> returns the status of draining the node, or null if it's not draining;
+//   - POST /drain?node=<name> starts draining the node, and returns its status; and
+//   - DELETE /drain?node=<name> stops draining the node.
+func (s *PluginState) serveDrain(logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nodeName := r.URL.Query().Get("node")
+
+		var result any
+		var err error
+		switch {
+		case r.Method == "GET" && nodeName == "":
+			result = s.listDrainStatuses()
+		case r.Method == "GET":
+			result, err = s.getDrainStatus(nodeName)
+		case (r.Method == "POST" || r.Method == "DELETE") && nodeName != "":
+			if s.config().ShadowMode {
+				w.WriteHeader(400)
+				_, _ = w.Write([]byte("draining nodes is not supported in shadow mode"))
+				return
+			} else if !s.leading.Load() {
+				w.WriteHeader(503)
+				_, _ = w.Write([]byte("draining nodes must be requested from the leader"))
+				return
+			}
+
+			if r.Method == "POST" {
+				result, err = s.startDrain(logger, nodeName)
+			} else {
+				result = struct{}{}
+				err = s.cancelDrain(logger, nodeName)
+			}
+		default:
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("must be GET, or POST or DELETE with a 'node' query parameter"))
+			return
+		}
+
+		if err != nil {
+			status := 500
+			if errors.Is(err, errNodeNotFound) {
+				status = 404
+			}
+			w.Header().Add("Content-Type", ContentTypeError)
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		body, err := json.Marshal(result)
+		if err != nil {
+			logger.Panic("Failed to encode response JSON", zap.Error(err))
+		}
+		w.Header().Add("Content-Type", ContentTypeJSON)
+		w.WriteHeader(200)
+		_, _ = w.Write(body)
+	}
+}
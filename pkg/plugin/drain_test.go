@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+func TestDrainNode(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+
+	var patches []map[string]any
+	s.patchNode = func(_ *zap.Logger, nodeName string, patch []byte) error {
+		assert.Equal(t, "node-1", nodeName)
+		var decoded map[string]any
+		assert.NoError(t, json.Unmarshal(patch, &decoded))
+		patches = append(patches, decoded)
+		return nil
+	}
+	s.requeueNode = func(string) error { return nil }
+	var requeued []types.UID
+	s.requeuePod = func(uid types.UID) error {
+		requeued = append(requeued, uid)
+		return nil
+	}
+
+	unmigratable := preemptionTestPod("vm-c", 1000, true)
+	unmigratable.Migratable = false
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+	node.AddPod(preemptionTestPod("vm-a", 1000, true))
+	node.AddPod(preemptionTestPod("vm-b", 1000, true))
+	node.AddPod(unmigratable)
+	ns := &nodeState{ //nolint:exhaustruct // only need the node, drain, and requested migrations
+		node:                node,
+		requestedMigrations: make(map[types.UID]time.Time),
+	}
+	s.nodes["node-1"] = ns
+
+	_, err := s.startDrain(zap.NewNop(), "missing")
+	assert.ErrorIs(t, err, errNodeNotFound)
+
+	status, err := s.startDrain(zap.NewNop(), "node-1")
+	assert.NoError(t, err)
+	assert.Equal(t, DrainPhaseInProgress, status.Phase)
+	assert.Equal(t, 3, status.RemainingVMs)
+	assert.Equal(t, []util.NamespacedName{{Namespace: "default", Name: "vm-c"}}, status.UnmigratableVMs)
+	assert.Len(t, patches, 1)
+	assert.True(t, ns.draining())
+
+	// Starting the drain again doesn't patch the node again
+	_, err = s.startDrain(zap.NewNop(), "node-1")
+	assert.NoError(t, err)
+	assert.Len(t, patches, 1)
+
+	assert.NoError(t, s.balanceNode(zap.NewNop(), ns))
+	assert.ElementsMatch(t, []types.UID{"vm-a", "vm-b"}, requeued)
+
+	// Once only the unmigratable VM is left, the drain is blocked
+	ns.node.RemovePod("vm-a")
+	ns.node.RemovePod("vm-b")
+	status, err = s.getDrainStatus("node-1")
+	assert.NoError(t, err)
+	assert.Equal(t, DrainPhaseBlocked, status.Phase)
+
+	// The status is also available over HTTP
+	recorder := httptest.NewRecorder()
+	s.serveDrain(zap.NewNop())(recorder, httptest.NewRequest(http.MethodGet, "/drain", nil))
+	assert.Equal(t, 200, recorder.Code)
+	var statuses []DrainStatus
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &statuses))
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, "node-1", statuses[0].Node)
+
+	// Canceling the drain is only allowed from the leader
+	s.leading.Store(false)
+	recorder = httptest.NewRecorder()
+	s.serveDrain(zap.NewNop())(recorder, httptest.NewRequest(http.MethodDelete, "/drain?node=node-1", nil))
+	assert.Equal(t, 503, recorder.Code)
+	assert.True(t, ns.draining())
+	s.leading.Store(true)
+
+	// Canceling the drain removes the annotation
+	recorder = httptest.NewRecorder()
+	s.serveDrain(zap.NewNop())(recorder, httptest.NewRequest(http.MethodDelete, "/drain?node=node-1", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.False(t, ns.draining())
+	assert.Equal(t, map[string]any{
+		"metadata": map[string]any{"annotations": map[string]any{annotationDrain: nil}},
+	}, patches[1])
+}
@@ -0,0 +1,82 @@
+package plugin
+
+// Dry-run mode: making all of the usual decisions, but only logging and counting the ones that
+// would change something, instead of acting on them.
+//
+// All writes to the API server that act on VMs, pods, or nodes go through PluginState's
+// createMigration, deleteMigration, patchVM, evictPod, and patchNode, so those are replaced at
+// startup. The only other ways we affect scheduling are by rejecting pods in Filter and nominating
+// nodes in PostFilter, which are handled separately.
+//
+// Writes that only describe the plugin itself are still made in dry-run mode: the ConfigMaps for
+// the fleet health report and state handoff (see writeConfigMapData), and the leader label on the
+// plugin's own pod. Those don't change anything about the workloads, and autoscaler-agents still
+// need the leader label to find the replica that's running.
+
+import (
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+const (
+	dryRunActionRejectPod       = "reject_pod"
+	dryRunActionCreateMigration = "create_migration"
+	dryRunActionDeleteMigration = "delete_migration"
+	dryRunActionPatchVM         = "patch_vm"
+	dryRunActionEvictPod        = "evict_pod"
+	dryRunActionNominateNode    = "nominate_node"
+	dryRunActionPatchNode       = "patch_node"
+)
+
+// enableDryRun replaces the functions that write to the API server with ones that only log and
+// count what would have been written.
+func (s *PluginState) enableDryRun(logger *zap.Logger) {
+	s.createMigration = func(logger *zap.Logger, vmm *vmv1.VirtualMachineMigration) error {
+		logger.Info("Would create migration (dry run)", zap.String("Migration", vmm.Name))
+		s.metrics.DryRunActions.WithLabelValues(dryRunActionCreateMigration).Inc()
+		return nil
+	}
+	s.deleteMigration = func(logger *zap.Logger, vmm *vmv1.VirtualMachineMigration) error {
+		logger.Info("Would delete migration (dry run)", zap.String("Migration", vmm.Name))
+		s.metrics.DryRunActions.WithLabelValues(dryRunActionDeleteMigration).Inc()
+		return nil
+	}
+	s.patchVM = func(vm util.NamespacedName, patches []patch.Operation) error {
+		logger.Info(
+			"Would patch VirtualMachine (dry run)",
+			zap.Object("VirtualMachine", vm),
+			zap.Any("patches", patches),
+		)
+		s.metrics.DryRunActions.WithLabelValues(dryRunActionPatchVM).Inc()
+		return nil
+	}
+	s.evictPod = func(logger *zap.Logger, pod *corev1.Pod) error {
+		logger.Info("Would evict Pod (dry run)", zap.Object("Pod", util.GetNamespacedName(pod)))
+		s.metrics.DryRunActions.WithLabelValues(dryRunActionEvictPod).Inc()
+		return nil
+	}
+	s.patchNode = func(logger *zap.Logger, nodeName string, patch []byte) error {
+		logger.Info("Would patch Node (dry run)", zap.String("Node", nodeName), zap.ByteString("patch", patch))
+		s.metrics.DryRunActions.WithLabelValues(dryRunActionPatchNode).Inc()
+		return nil
+	}
+}
+
+// dryRunFilterStatus returns the status from Filter, unless we're in dry-run mode and it would
+// reject the pod -- in which case the rejection is logged and counted, and success is returned
+// instead.
+func (s *PluginState) dryRunFilterStatus(logger *zap.Logger, status *framework.Status) *framework.Status {
+	if !s.config().DryRun || status.IsSuccess() {
+		return status
+	}
+
+	logger.Info("Would reject Pod placement onto this Node (dry run)", zap.String("Reason", status.Message()))
+	s.metrics.DryRunActions.WithLabelValues(dryRunActionRejectPod).Inc()
+	return nil
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+func TestDryRunFilter(t *testing.T) {
+	// A single node with no room for another VM
+	cluster := defaultBenchmarkClusterConfig(1, 4)
+	cluster.NodeCPU = 4 * cluster.VMCPU
+	cluster.NodeMem = 4 * cluster.VMMem
+
+	filter := func(c *benchmarkCluster) *framework.Status {
+		return c.enforcer.Filter(context.Background(), nil, c.newPod, c.nodeInfos[0])
+	}
+
+	config := defaultBenchmarkConfig()
+	c, err := newBenchmarkCluster(config, cluster)
+	require.NoError(t, err)
+	assert.Equal(t, framework.Unschedulable, filter(c).Code())
+
+	config.DryRun = true
+	c, err = newBenchmarkCluster(config, cluster)
+	require.NoError(t, err)
+	assert.True(t, filter(c).IsSuccess())
+
+	actions := c.enforcer.state.metrics.DryRunActions
+	assert.Equal(t, 1.0, testutil.ToFloat64(actions.WithLabelValues(dryRunActionRejectPod)))
+}
+
+func TestDryRunWrites(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.DryRun = true
+	c, err := newBenchmarkCluster(config, defaultBenchmarkClusterConfig(1, 1))
+	require.NoError(t, err)
+
+	s := c.enforcer.state
+	s.patchVM = func(util.NamespacedName, []patch.Operation) error {
+		t.Fatal("VM should not be patched in dry-run mode")
+		return nil
+	}
+	s.enableDryRun(zap.NewNop())
+
+	vm := util.NamespacedName{Namespace: "default", Name: "vm-0-0"}
+	assert.NoError(t, s.patchVM(vm, nil))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.DryRunActions.WithLabelValues(dryRunActionPatchVM)))
+}
+
+// In dry-run mode, migrations are only logged where they'd actually be created -- not while they'd
+// be suppressed by a blackout window, deferred, or held back by migration limits.
+func TestDryRunMigrationBlackout(t *testing.T) {
+	for _, blackout := range []bool{false, true} {
+		config := defaultBenchmarkConfig()
+		config.DryRun = true
+		if blackout {
+			config.MigrationBlackoutWindows = []MigrationBlackoutWindowConfig{
+				{Name: "always", Schedule: "* * * * *", DurationMinutes: 1, TimeZone: ""},
+			}
+		}
+		_, err := config.validate()
+		require.NoError(t, err)
+
+		c, err := newBenchmarkCluster(config, defaultBenchmarkClusterConfig(1, 1))
+		require.NoError(t, err)
+		s := c.enforcer.state
+		s.enableDryRun(zap.NewNop())
+
+		pod := c.vmPods[0][0].DeepCopy()
+		pod.Labels[api.LabelEnableAutoMigration] = "true"
+		ns := s.nodes[pod.Spec.NodeName]
+		ns.requestedMigrations[pod.UID] = time.Now()
+
+		_, err = s.HandlePodEvent(zap.NewNop(), reconcile.EventKindModified, pod)
+		require.NoError(t, err)
+
+		created := testutil.ToFloat64(s.metrics.DryRunActions.WithLabelValues(dryRunActionCreateMigration))
+		if blackout {
+			assert.Equal(t, 0.0, created)
+			assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.MigrationsSuppressedByBlackout.WithLabelValues("always")))
+		} else {
+			assert.Equal(t, 1.0, created)
+		}
+		assert.NotContains(t, ns.requestedMigrations, pod.UID)
+	}
+}
@@ -3,13 +3,16 @@ package plugin
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/samber/lo"
+	"github.com/tychoish/fun/srv"
 	"go.uber.org/zap"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/kubernetes/pkg/scheduler/framework"
@@ -60,108 +63,239 @@ func NewAutoscaleEnforcerPlugin(
 
 	initEvents := initevents.NewInitEventsMiddleware()
 
-	reconcileQueue, err := reconcile.NewQueue(
-		map[reconcile.Object]reconcile.HandlerFunc{
-			&corev1.Node{}: func(logger *zap.Logger, k reconcile.EventKind, obj reconcile.Object) (reconcile.Result, error) {
-				return lo.Empty[reconcile.Result](), pluginState.HandleNodeEvent(logger, k, obj.(*corev1.Node))
-			},
+	reconcileConfig := lo.FromPtr(config.Reconcile)
 
-			&corev1.Pod{}: func(logger *zap.Logger, k reconcile.EventKind, obj reconcile.Object) (reconcile.Result, error) {
-				result, err := pluginState.HandlePodEvent(logger, k, obj.(*corev1.Pod))
-				return lo.FromPtr(result), err
+	reconcileQueues, err := newReconcilePools(
+		[]reconcileKind{
+			{
+				obj:    &corev1.Node{},
+				config: reconcileConfig.Nodes,
+				handler: func(logger *zap.Logger, k reconcile.EventKind, obj reconcile.Object) (reconcile.Result, error) {
+					return lo.Empty[reconcile.Result](), pluginState.HandleNodeEvent(logger, k, obj.(*corev1.Node))
+				},
 			},
-
-			&vmv1.VirtualMachineMigration{}: func(logger *zap.Logger, k reconcile.EventKind, obj reconcile.Object) (reconcile.Result, error) {
-				vmm := obj.(*vmv1.VirtualMachineMigration)
-				return lo.Empty[reconcile.Result](), pluginState.HandleMigrationEvent(logger, k, vmm)
+			{
+				obj:    &corev1.Pod{},
+				config: reconcileConfig.Pods,
+				handler: func(logger *zap.Logger, k reconcile.EventKind, obj reconcile.Object) (reconcile.Result, error) {
+					result, err := pluginState.HandlePodEvent(logger, k, obj.(*corev1.Pod))
+					return lo.FromPtr(result), err
+				},
 			},
+			{
+				obj:    &vmv1.VirtualMachineMigration{},
+				config: reconcileConfig.Migrations,
+				handler: func(logger *zap.Logger, k reconcile.EventKind, obj reconcile.Object) (reconcile.Result, error) {
+					vmm := obj.(*vmv1.VirtualMachineMigration)
+					return lo.Empty[reconcile.Result](), pluginState.HandleMigrationEvent(logger, k, vmm)
+				},
+			},
+		},
+		func(pool *reconcilePool) []reconcile.QueueOption {
+			return []reconcile.QueueOption{
+				reconcile.WithBaseContext(ctx),
+				reconcile.WithMiddleware(initEvents),
+				reconcile.WithPriorityClasses(reconcilePriorityClasses(config.ReconcilePriorities)),
+				// Note: we need one layer of indirection for callbacks referencing pluginState,
+				// because it's initialized later, so directly referencing the methods at this point
+				// will use the nil pluginState and panic on use.
+				reconcile.WithQueueWaitDurationCallback(func(gvk schema.GroupVersionKind, duration time.Duration) {
+					pluginState.reconcileQueueWaitCallback(gvk, duration)
+					pool.workers.observeWait(duration)
+				}),
+				reconcile.WithRetryCallback(func(gvk schema.GroupVersionKind, retryAfter time.Duration) {
+					pluginState.reconcileRetryCallback(gvk, retryAfter)
+				}),
+				reconcile.WithResultCallback(func(params reconcile.ObjectParams, duration time.Duration, err error) {
+					pluginState.reconcileResultCallback(params, duration, err)
+				}),
+				reconcile.WithErrorStatsCallback(func(params reconcile.ObjectParams, stats reconcile.ErrorStats) {
+					pluginState.reconcileErrorStatsCallback(logger, params, stats)
+				}),
+				reconcile.WithPanicCallback(func(params reconcile.ObjectParams) {
+					pluginState.reconcilePanicCallback(params)
+				}),
+			}
 		},
-		reconcile.WithBaseContext(ctx),
-		reconcile.WithMiddleware(initEvents),
-		// Note: we need one layer of indirection for callbacks referencing pluginState, because
-		// it's initialized later, so directly referencing the methods at this point will use the
-		// nil pluginState and panic on use.
-		reconcile.WithQueueWaitDurationCallback(func(duration time.Duration) {
-			pluginState.reconcileQueueWaitCallback(duration)
-		}),
-		reconcile.WithResultCallback(func(params reconcile.ObjectParams, duration time.Duration, err error) {
-			pluginState.reconcileResultCallback(params, duration, err)
-		}),
-		reconcile.WithErrorStatsCallback(func(params reconcile.ObjectParams, stats reconcile.ErrorStats) {
-			pluginState.reconcileErrorStatsCallback(logger, params, stats)
-		}),
-		reconcile.WithPanicCallback(func(params reconcile.ObjectParams) {
-			pluginState.reconcilePanicCallback(params)
-		}),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("could not setup reconcile queue: %w", err)
+		return nil, fmt.Errorf("could not setup reconcile queues: %w", err)
 	}
 
 	watchMetrics := watch.NewMetrics("autoscaling_plugin_watchers", promReg)
+	watchSettings := makeWatchSettings(logger, config, handle.EventRecorder(), watchMetrics)
 
 	// Fetch the nodes first, so that they'll *tend* to be added to the state before we try to
 	// handle the pods that are on them.
 	// It's not guaranteed, because parallel workers acquiring the same lock ends up with *some*
 	// reordered handling, but it helps dramatically reduce the number of warnings in practice.
-	nodeHandlers := watchHandlers[*corev1.Node](reconcileQueue, initEvents)
-	nodeStore, err := watchNodeEvents(ctx, logger, handle.ClientSet(), watchMetrics, nodeHandlers)
+	nodeHandlers := watchHandlers[*corev1.Node](reconcileQueues.queueFor(&corev1.Node{}), initEvents)
+	nodeStore, err := watchNodeEvents(ctx, logger, handle.ClientSet(), watchSettings, nodeHandlers)
 	if err != nil {
 		return nil, fmt.Errorf("could not start watch on Node events: %w", err)
 	}
 
-	podHandlers := watchHandlers[*corev1.Pod](reconcileQueue, initEvents)
-	podStore, err := watchPodEvents(ctx, logger, handle.ClientSet(), watchMetrics, podHandlers)
+	podHandlers := watchHandlers[*corev1.Pod](reconcileQueues.queueFor(&corev1.Pod{}), initEvents)
+	podStore, err := watchPodEvents(ctx, logger, handle.ClientSet(), watchSettings, podHandlers)
 	if err != nil {
 		return nil, fmt.Errorf("could not start watch on Pod events: %w", err)
 	}
 
 	// we make these handlers with nil instead of initEvents so that we're not blocking plugin setup
 	// on the migration objects being handled.
-	vmmHandlers := watchHandlers[*vmv1.VirtualMachineMigration](reconcileQueue, nil)
-	if err := watchMigrationEvents(ctx, logger, vmClient, watchMetrics, vmmHandlers); err != nil {
+	vmmHandlers := watchHandlers[*vmv1.VirtualMachineMigration](
+		reconcileQueues.queueFor(&vmv1.VirtualMachineMigration{}), nil,
+	)
+	migrationStore, err := watchMigrationEvents(ctx, logger, vmClient, watchSettings, vmmHandlers)
+	if err != nil {
 		return nil, fmt.Errorf("could not start watch on VirtualMachineMigration events: %w", err)
 	}
 
-	pluginState = NewPluginState(*config, vmClient, promReg, podStore, nodeStore)
+	// Namespaces are only needed to find which watermark overrides apply, and which namespaces are
+	// ignored by their labels. We mostly just need their labels to be available -- the only events
+	// we handle are when namespaces start or stop being ignored.
+	var namespaceStore *watch.Store[corev1.Namespace]
+	if len(config.WatermarkOverrides) != 0 || len(config.IgnoredNamespaceSelector) != 0 {
+		namespaceStore, err = watchNamespaceEvents(
+			ctx,
+			logger,
+			handle.ClientSet(),
+			watchSettings,
+			ignoredNamespaceHandlers(logger.Named("ignored-namespaces"), config, podStore),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not start watch on Namespace events: %w", err)
+		}
+	}
+
+	pluginState = NewPluginState(*config, vmClient, promReg, podStore, nodeStore, namespaceStore)
+	pluginState.listMigrations = migrationStore.Items
+	pluginState.evictPod = pluginState.podEvicter(handle.ClientSet().PolicyV1())
+	pluginState.patchNode = pluginState.nodePatcher(handle.ClientSet().CoreV1())
+	if config.DryRun {
+		logger.Warn("Running in dry-run mode, logging changes without making them")
+		pluginState.enableDryRun(logger.Named("dry-run"))
+	}
 
-	// Start the workers for the queue. We can't do these earlier because our handlers depend on the
-	// PluginState that only exists now.
-	reconcileLogger := logger.Named("reconcile")
-	for i := 0; i < config.ReconcileWorkers; i++ {
-		go reconcileWorker(ctx, reconcileLogger, reconcileQueue)
+	// The snapshot from the previous instance must be restored before any events are handled, so
+	// that it's taken into account as pods are added.
+	if config.StateHandoff != nil && !config.ShadowMode {
+		pluginState.restoreStateSnapshot(
+			ctx,
+			logger.Named("state-handoff"),
+			*config.StateHandoff,
+			handle.ClientSet().CoreV1(),
+			time.Now(),
+		)
 	}
 
+	// Start the workers for the queues. We can't do these earlier because our handlers depend on
+	// the PluginState that only exists now.
+	reconcileQueues.startWorkers(ctx, logger.Named("reconcile"), config, pluginState.metrics.Reconcile.Workers)
+	go pluginState.runReconcileQueueMetrics(ctx, reconcileQueues)
+
 	err = util.StartPrometheusMetricsServer(ctx, logger.Named("prometheus"), 9100, promReg)
 	if err != nil {
 		return nil, fmt.Errorf("could not start prometheus server: %w", err)
 	}
 
+	// The debug server's state dump is read-only, so it's also available in shadow mode. (/drain
+	// refuses changes in shadow mode itself.)
+	if config.DebugServer != nil {
+		err = pluginState.StartDebugServer(logger.Named("debug-server"), *config.DebugServer, reconcileQueues.Stats)
+		if err != nil {
+			return nil, fmt.Errorf("could not start debug server: %w", err)
+		}
+	}
+
 	indexedPodStore := watch.NewIndexedStore(podStore, watch.NewNameIndex[corev1.Pod]())
 	getPod := func(p util.NamespacedName) (*corev1.Pod, bool) {
 		return indexedPodStore.GetIndexed(func(index *watch.NameIndex[corev1.Pod]) (*corev1.Pod, bool) {
 			return index.Get(p.Namespace, p.Name)
 		})
 	}
-	err = pluginState.startPermitHandler(ctx, logger.Named("agent-handler"), getPod, podStore.Listen)
-	if err != nil {
-		return nil, fmt.Errorf("could not start agent request handler: %w", err)
+	// In shadow mode, requests from the autoscaler-agents are handled by the real scheduler.
+	if config.ShadowMode {
+		logger.Warn("Running in shadow mode, comparing decisions without acting on them")
+	} else {
+		err = pluginState.startPermitHandler(ctx, logger.Named("agent-handler"), getPod, podStore.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("could not start agent request handler: %w", err)
+		}
+	}
+
+	if config.systemPodAccountingMode() == SystemPodAccountingMeasured {
+		interval := time.Second * time.Duration(config.SystemPodAccounting.MeasureIntervalSeconds)
+		go pluginState.runSystemPodUsageWatcher(
+			ctx,
+			logger.Named("system-pod-usage"),
+			handle.ClientSet().CoreV1().RESTClient(),
+			interval,
+		)
+	}
+
+	if config.Federation != nil && !config.ShadowMode {
+		go pluginState.runFederationReporter(ctx, logger.Named("federation"), *config.Federation)
+	}
+
+	if config.HealthReport != nil && !config.ShadowMode {
+		go pluginState.runHealthReporter(
+			ctx,
+			logger.Named("health-report"),
+			*config.HealthReport,
+			handle.ClientSet().CoreV1(),
+		)
+	}
+
+	// The forecast is read-only, so it's also available in shadow mode.
+	if config.CapacityForecast != nil {
+		go pluginState.runCapacityForecast(ctx, logger.Named("capacity-forecast"), *config.CapacityForecast)
+	}
+
+	// The final snapshot is written on shutdown, so this is added to the orchestrator, which waits
+	// for it before exiting.
+	if config.StateHandoff != nil && !config.ShadowMode {
+		handoff := &srv.Service{ //nolint:exhaustruct // only setting the fields we need
+			Name: "state-handoff",
+			Run: func(ctx context.Context) error {
+				pluginState.runStateHandoff(
+					ctx,
+					logger.Named("state-handoff"),
+					*config.StateHandoff,
+					handle.ClientSet().CoreV1(),
+				)
+				return nil
+			},
+		}
+		if err := handoff.Start(ctx); err != nil {
+			return nil, fmt.Errorf("could not start state handoff: %w", err)
+		}
+		if err := srv.GetOrchestrator(ctx).Add(handoff); err != nil {
+			return nil, fmt.Errorf("could not add state handoff to orchestrator: %w", err)
+		}
+	}
+
+	if config.CordonWatermark != nil && !config.ShadowMode {
+		go pluginState.runCordonController(ctx, logger.Named("cordon-watermark"), *config.CordonWatermark)
+	}
+
+	if config.Defragmentation != nil && !config.ShadowMode {
+		go pluginState.runDefragmentation(ctx, logger.Named("defragmentation"), *config.Defragmentation)
+	}
+
+	// The TTL may be set by reloading the config, so this always runs -- except in shadow mode,
+	// where pods are never reserved.
+	if !config.ShadowMode {
+		go pluginState.runUnboundReservationExpiry(ctx, logger.Named("unbound-reservations"))
+	}
+
+	if config.path != "" {
+		go pluginState.runConfigWatcher(ctx, logger.Named("config-watcher"), config.path)
 	}
 
 	// The reconciles are ongoing -- we need to wait until they're finished.
-	timeout := time.Second * time.Duration(config.StartupEventHandlingTimeoutSeconds)
-	start := time.Now()
-	select {
-	case <-ctx.Done():
-		logger.Warn("Context unexpectedly canceled while waiting for initial events to be handled")
-		return nil, ctx.Err()
-	case <-time.After(timeout):
-		logger.Error("Timed out handling initial events")
-		// intentionally use separate log lines, to emit *something* if it deadlocks.
-		logger.Warn("Objects remaining to be reconciled", zap.Any("Remaining", initEvents.Remaining()))
-		return nil, fmt.Errorf("timed out after %s while handling initial events", time.Since(start))
-	case <-initEvents.Done():
-		logger.Info("Handled all initial events", zap.Duration("duration", time.Since(start)))
+	if err := pluginState.waitForInitEvents(ctx, logger, config, initEvents); err != nil {
+		return nil, err
 	}
 
 	// Reconciles are finished -- for now. Some of them may be waiting on startup to complete, in
@@ -180,10 +314,110 @@ func NewAutoscaleEnforcerPlugin(
 		}
 	}
 	clear(pluginState.requeueAfterStartup)
+	pluginState.finishHandoff()
+
+	// Only start following the leader election now that startup is done, so that everything that
+	// was deferred until then has already been requeued.
+	if config.LeaderElection != nil {
+		if err := pluginState.startLeaderElectionWatcher(
+			ctx,
+			logger.Named("leader-election"),
+			*config.LeaderElection,
+			handle.ClientSet(),
+			func() {
+				// Whatever the previous leader didn't write to the VMs may be more recent than
+				// what we restored at startup, if we restored anything.
+				if config.StateHandoff != nil {
+					pluginState.restoreStateSnapshot(
+						ctx,
+						logger.Named("state-handoff"),
+						*config.StateHandoff,
+						handle.ClientSet().CoreV1(),
+						time.Now(),
+					)
+				}
+				pluginState.requeueDeferredForLeader(logger.Named("leader-election"))
+				for _, vmm := range migrationStore.Items() {
+					_ = migrationStore.NopUpdate(vmm.UID)
+				}
+			},
+		); err != nil {
+			return nil, fmt.Errorf("could not start leader election watcher: %w", err)
+		}
+	}
 
 	return &AutoscaleEnforcer{
 		logger:  logger.Named("plugin"),
 		state:   pluginState,
 		metrics: &pluginState.metrics.Framework,
+		getNodeInfo: func(nodeName string) (*framework.NodeInfo, error) {
+			return handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+		},
+		decisions: newDecisionLogger(ctx, logger.Named("decision-log"), config.DecisionLog, handle.EventRecorder()),
 	}, nil
 }
+
+// startupProgressInterval is how often progress is logged while waiting for the initial events to
+// be handled.
+const startupProgressInterval = 2 * time.Second
+
+// waitForInitEvents waits until all the initial events have been handled, periodically logging and
+// exporting the progress -- or returns an error if that takes longer than the config's
+// StartupEventHandlingTimeoutSeconds.
+func (s *PluginState) waitForInitEvents(
+	ctx context.Context,
+	logger *zap.Logger,
+	config *Config,
+	initEvents *initevents.InitEventsMiddleware,
+) error {
+	timeout := time.After(time.Second * time.Duration(config.StartupEventHandlingTimeoutSeconds))
+	ticker := time.NewTicker(startupProgressInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	done := initEvents.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Warn("Context unexpectedly canceled while waiting for initial events to be handled")
+			return ctx.Err()
+		case <-timeout:
+			progress := s.recordStartupProgress(initEvents)
+			logger.Error("Timed out handling initial events")
+			// intentionally use separate log lines, to emit *something* if it deadlocks.
+			logger.Warn("Objects remaining to be reconciled", zap.Any("Remaining", initEvents.Remaining()))
+
+			// Only include the slowest few kinds, so the error stays readable.
+			slowest := make([]string, 0, 3)
+			for _, p := range progress[:min(len(progress), cap(slowest))] {
+				slowest = append(slowest, p.String())
+			}
+			return fmt.Errorf(
+				"timed out after %s while handling initial events (slowest: %s)",
+				time.Since(start), strings.Join(slowest, "; "),
+			)
+		case <-ticker.C:
+			progress := s.recordStartupProgress(initEvents)
+			logger.Info(
+				"Still handling initial events",
+				zap.Duration("duration", time.Since(start)),
+				zap.Stringers("progress", progress),
+			)
+		case <-done:
+			s.recordStartupProgress(initEvents)
+			logger.Info("Handled all initial events", zap.Duration("duration", time.Since(start)))
+			return nil
+		}
+	}
+}
+
+// recordStartupProgress updates the metrics for the progress of handling the initial events, and
+// returns it.
+func (s *PluginState) recordStartupProgress(initEvents *initevents.InitEventsMiddleware) []initevents.KindProgress {
+	progress := initEvents.Progress()
+	for _, p := range progress {
+		s.metrics.StartupEvents.WithLabelValues(p.Kind.Kind, "handled").Set(float64(p.Total - p.Remaining))
+		s.metrics.StartupEvents.WithLabelValues(p.Kind.Kind, "remaining").Set(float64(p.Remaining))
+	}
+	return progress
+}
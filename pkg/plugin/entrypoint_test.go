@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/initevents"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+)
+
+func TestRecordStartupProgress(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ //nolint:exhaustruct // only need the type and object metadata
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(name)}, //nolint:exhaustruct // only need the name and UID
+		}
+	}
+
+	initEvents := initevents.NewInitEventsMiddleware()
+	pods := []*corev1.Pod{pod("pod-a"), pod("pod-b"), pod("pod-c")}
+	for _, p := range pods {
+		initEvents.AddRequired(p)
+	}
+
+	handled := pods[0]
+	params := reconcile.ObjectParams{
+		GVK:       corev1.SchemeGroupVersion.WithKind("Pod"),
+		UID:       handled.UID,
+		Name:      handled.Name,
+		Namespace: handled.Namespace,
+		EventKind: reconcile.EventKindAdded,
+		Obj:       handled,
+	}
+	_, err := initEvents.Call(zap.NewNop(), params, func(*zap.Logger, reconcile.ObjectParams) (reconcile.Result, error) {
+		return reconcile.Result{RetryAfter: 0}, nil
+	})
+	assert.NoError(t, err)
+
+	progress := s.recordStartupProgress(initEvents)
+	assert.Equal(t, []initevents.KindProgress{
+		{Kind: corev1.SchemeGroupVersion.WithKind("Pod"), Total: 3, Remaining: 2, Failures: 0},
+	}, progress)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.StartupEvents.WithLabelValues("Pod", "handled")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(s.metrics.StartupEvents.WithLabelValues("Pod", "remaining")))
+}
@@ -0,0 +1,190 @@
+package plugin
+
+// Weighted max-min fairness between tenants when approving upscaling, as configured by
+// (Config).TenantFairness.
+
+import (
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"golang.org/x/exp/constraints"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+func (c *TenantFairnessConfig) weight(tenant string) int {
+	if w, ok := c.Weights[tenant]; ok {
+		return w
+	}
+	return c.DefaultWeight
+}
+
+// applyTenantFairness limits any increase in desiredPod's reserved or preapproved resources to
+// its tenant's fair share of the node, if there isn't enough capacity on the node for all of the
+// upscaling that's been requested.
+//
+// Returns true iff desiredPod was changed.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) applyTenantFairness(
+	logger *zap.Logger,
+	ns *nodeState,
+	oldPod state.Pod,
+	desiredPod *state.Pod,
+) (throttled bool) {
+	config := s.config().TenantFairness
+	if config == nil {
+		return false
+	}
+
+	tenantOf := func(uid types.UID) string {
+		return s.podLabels[uid][config.TenantLabel]
+	}
+	tenant := tenantOf(oldPod.UID)
+
+	cpuThrottled := limitToFairShare(
+		ns.node,
+		ns.node.CPU.Total,
+		func(p state.Pod) state.PodResources[vmv1.MilliCPU] { return p.CPU },
+		oldPod, &desiredPod.CPU, tenant, tenantOf, config.weight,
+	)
+	memThrottled := limitToFairShare(
+		ns.node,
+		ns.node.Mem.Total,
+		func(p state.Pod) state.PodResources[api.Bytes] { return p.Mem },
+		oldPod, &desiredPod.Mem, tenant, tenantOf, config.weight,
+	)
+
+	if !cpuThrottled && !memThrottled {
+		return false
+	}
+
+	s.metrics.TenantFairnessThrottled.WithLabelValues(tenant).Inc()
+	logger.Info(
+		"Limiting upscaling for Pod to its tenant's fair share of the node",
+		zap.String("tenant", tenant),
+		zap.Object("Pod", oldPod),
+		zap.Object("DesiredPod", *desiredPod),
+	)
+	return true
+}
+
+// limitToFairShare implements applyTenantFairness for a single resource.
+//
+// Overcommit is not taken into account: each tenant's share is calculated from the amounts
+// reserved and requested by its VMs.
+func limitToFairShare[T constraints.Unsigned](
+	node *state.Node,
+	total T,
+	get func(state.Pod) state.PodResources[T],
+	oldPod state.Pod,
+	desired *state.PodResources[T],
+	tenant string,
+	tenantOf func(types.UID) string,
+	weight func(tenant string) int,
+) (throttled bool) {
+	old := get(oldPod)
+	if desired.Reserved <= old.Reserved && desired.Preapproved <= old.Preapproved {
+		return false // no increase, nothing to limit.
+	}
+
+	// Capacity used by pods that aren't VMs can't be reallocated, so it's excluded from what we
+	// share between tenants.
+	available := total
+	demands := make(map[string]T)
+	var othersReserved T // reserved by other VMs from the same tenant
+	for uid, pod := range node.Pods() {
+		r := get(pod)
+		if lo.IsEmpty(pod.VirtualMachine) {
+			available = util.SaturatingSub(available, r.Reserved)
+			continue
+		}
+
+		t := tenantOf(uid)
+		demands[t] += max(r.Requested, r.Reserved)
+		if t == tenant && uid != oldPod.UID {
+			othersReserved += r.Reserved
+		}
+	}
+
+	var totalDemand T
+	for _, d := range demands {
+		totalDemand += d
+	}
+	if totalDemand <= available {
+		return false // enough for everyone; first-come-first-served is fair.
+	}
+
+	shares := weightedMaxMinShares(available, demands, weight)
+	limit := util.SaturatingSub(shares[tenant], othersReserved)
+
+	reservedThrottled := limitIncrease(old.Reserved, &desired.Reserved, limit, desired.Factor)
+	preapprovedThrottled := limitIncrease(old.Preapproved, &desired.Preapproved, limit, desired.Factor)
+	return reservedThrottled || preapprovedThrottled
+}
+
+// limitIncrease reduces *desired to at most limit (but not below old), in multiples of factor
+// above old. Returns true iff *desired was reduced.
+func limitIncrease[T constraints.Unsigned](old T, desired *T, limit T, factor T) bool {
+	if *desired <= old || *desired <= limit {
+		return false
+	}
+
+	increase := util.SaturatingSub(limit, old)
+	if factor != 0 {
+		increase = (increase / factor) * factor
+	}
+	*desired = old + increase
+	return true
+}
+
+// weightedMaxMinShares divides capacity between tenants by weighted max-min fairness ("water
+// filling"): tenants demanding no more than their weighted share get all of their demand, and
+// what's left is repeatedly divided between the remaining tenants, by weight.
+func weightedMaxMinShares[T constraints.Unsigned](
+	capacity T,
+	demands map[string]T,
+	weight func(tenant string) int,
+) map[string]T {
+	shares := make(map[string]T, len(demands))
+	remaining := capacity
+	unsatisfied := lo.Keys(demands)
+
+	for len(unsatisfied) != 0 {
+		totalWeight := 0
+		for _, t := range unsatisfied {
+			totalWeight += weight(t)
+		}
+		shareOf := func(t string) T {
+			return T(float64(remaining) * float64(weight(t)) / float64(totalWeight))
+		}
+
+		var stillUnsatisfied []string
+		var satisfied T
+		for _, t := range unsatisfied {
+			if demands[t] <= shareOf(t) {
+				shares[t] = demands[t]
+				satisfied += demands[t]
+			} else {
+				stillUnsatisfied = append(stillUnsatisfied, t)
+			}
+		}
+
+		if len(stillUnsatisfied) == len(unsatisfied) {
+			// Nobody's demand fits within their share, so everyone just gets their share.
+			for _, t := range unsatisfied {
+				shares[t] = shareOf(t)
+			}
+			break
+		}
+
+		remaining -= satisfied
+		unsatisfied = stillUnsatisfied
+	}
+
+	return shares
+}
@@ -0,0 +1,185 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestWeightedMaxMinShares(t *testing.T) {
+	weights := map[string]int{"heavy": 3}
+	weight := func(tenant string) int {
+		if w, ok := weights[tenant]; ok {
+			return w
+		}
+		return 1
+	}
+
+	cases := []struct {
+		name     string
+		capacity uint64
+		demands  map[string]uint64
+		expected map[string]uint64
+	}{
+		{
+			name:     "no demand",
+			capacity: 100,
+			demands:  map[string]uint64{},
+			expected: map[string]uint64{},
+		},
+		{
+			name:     "enough for everyone",
+			capacity: 100,
+			demands:  map[string]uint64{"a": 30, "b": 30},
+			expected: map[string]uint64{"a": 30, "b": 30},
+		},
+		{
+			name:     "equal split",
+			capacity: 100,
+			demands:  map[string]uint64{"a": 80, "b": 80},
+			expected: map[string]uint64{"a": 50, "b": 50},
+		},
+		{
+			name:     "leftover from small demand is shared",
+			capacity: 100,
+			demands:  map[string]uint64{"a": 20, "b": 80, "c": 80},
+			expected: map[string]uint64{"a": 20, "b": 40, "c": 40},
+		},
+		{
+			name:     "weighted split",
+			capacity: 100,
+			demands:  map[string]uint64{"heavy": 100, "b": 100},
+			expected: map[string]uint64{"heavy": 75, "b": 25},
+		},
+		{
+			name:     "weighted tenant satisfied first",
+			capacity: 100,
+			demands:  map[string]uint64{"heavy": 50, "b": 100},
+			expected: map[string]uint64{"heavy": 50, "b": 50},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, weightedMaxMinShares(c.capacity, c.demands, weight))
+		})
+	}
+}
+
+func TestLimitIncrease(t *testing.T) {
+	cases := []struct {
+		name            string
+		old             uint64
+		desired         uint64
+		limit           uint64
+		factor          uint64
+		expected        uint64
+		expectedLimited bool
+	}{
+		{name: "decrease", old: 4, desired: 3, limit: 2, factor: 1, expected: 3, expectedLimited: false},
+		{name: "within limit", old: 2, desired: 4, limit: 5, factor: 1, expected: 4, expectedLimited: false},
+		{name: "above limit", old: 2, desired: 8, limit: 5, factor: 1, expected: 5, expectedLimited: true},
+		{name: "above limit, no factor", old: 2, desired: 8, limit: 5, factor: 0, expected: 5, expectedLimited: true},
+		{name: "rounded down to factor", old: 2, desired: 8, limit: 5, factor: 2, expected: 4, expectedLimited: true},
+		{name: "limit below old", old: 4, desired: 8, limit: 2, factor: 1, expected: 4, expectedLimited: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			desired := c.desired
+			limited := limitIncrease(c.old, &desired, c.limit, c.factor)
+			assert.Equal(t, c.expectedLimited, limited)
+			assert.Equal(t, c.expected, desired)
+		})
+	}
+}
+
+func TestApplyTenantFairness(t *testing.T) {
+	const tenantLabel = "neon/tenant"
+
+	cases := []struct {
+		name     string
+		fairness *TenantFairnessConfig
+		// CPU requested by vm-a, which currently has 4 CPUs reserved, like vm-b
+		requested vmv1.MilliCPU
+
+		expectedThrottled bool
+		expectedReserved  vmv1.MilliCPU
+	}{
+		{
+			name:              "disabled",
+			fairness:          nil,
+			requested:         8000,
+			expectedThrottled: false,
+			expectedReserved:  8000,
+		},
+		{
+			name:              "enough capacity",
+			fairness:          &TenantFairnessConfig{TenantLabel: tenantLabel, DefaultWeight: 1, Weights: nil},
+			requested:         6000,
+			expectedThrottled: false,
+			expectedReserved:  6000,
+		},
+		{
+			name:              "limited to fair share",
+			fairness:          &TenantFairnessConfig{TenantLabel: tenantLabel, DefaultWeight: 1, Weights: nil},
+			requested:         8000,
+			expectedThrottled: true,
+			expectedReserved:  6000,
+		},
+		{
+			name: "other tenant has a larger weight",
+			fairness: &TenantFairnessConfig{
+				TenantLabel:   tenantLabel,
+				DefaultWeight: 1,
+				Weights:       map[string]int{"tenant-b": 4},
+			},
+			requested: 8000,
+			// the 10 CPUs are split 2:8, but tenant-b only wants 4, so the remaining 6 still go to
+			// tenant-a.
+			expectedThrottled: true,
+			expectedReserved:  6000,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := defaultBenchmarkConfig()
+			config.TenantFairness = c.fairness
+			s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+			vmA := preemptionTestPod("vm-a", 4000, true)
+			vmA.CPU.Requested = c.requested
+			vmB := preemptionTestPod("vm-b", 4000, true)
+
+			node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+			node.AddPod(vmA)
+			node.AddPod(vmB)
+			ns := &nodeState{node: node} //nolint:exhaustruct // only need the node for this test
+			s.podLabels[types.UID("vm-a")] = map[string]string{tenantLabel: "tenant-a"}
+			s.podLabels[types.UID("vm-b")] = map[string]string{tenantLabel: "tenant-b"}
+
+			desired := vmA
+			desired.CPU.Reserved = c.requested
+
+			throttled := s.applyTenantFairness(zap.NewNop(), ns, vmA, &desired)
+			assert.Equal(t, c.expectedThrottled, throttled)
+			assert.Equal(t, c.expectedReserved, desired.CPU.Reserved)
+
+			expectedCount := 0.0
+			if c.expectedThrottled {
+				expectedCount = 1
+			}
+			assert.Equal(t, expectedCount, testutil.ToFloat64(s.metrics.TenantFairnessThrottled.WithLabelValues("tenant-a")))
+		})
+	}
+}
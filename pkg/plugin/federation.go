@@ -0,0 +1,122 @@
+package plugin
+
+// Reporting of cluster summaries to the central federation service, if enabled.
+//
+// The summaries are aggregated by the federation service -- e.g. neonvm-controller, when its
+// -federation-bind-address flag is set -- which uses them to recommend clusters for new endpoints.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// runFederationReporter periodically pushes a summary of the cluster to the federation endpoint,
+// until the context is canceled.
+func (s *PluginState) runFederationReporter(ctx context.Context, logger *zap.Logger, config FederationConfig) {
+	interval := time.Second * time.Duration(config.PushIntervalSeconds)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.leading.Load() {
+			continue // only the leader reports for the cluster
+		}
+
+		summary, ok := s.federationSummary(config.ClusterName)
+		if !ok {
+			logger.Info("Skipping push to federation service because startup is not done yet")
+			continue
+		}
+
+		if err := pushFederationSummary(ctx, config, summary); err != nil {
+			logger.Error("Failed to push cluster summary to federation service", zap.Error(err))
+			s.metrics.FederationPushes.WithLabelValues(fmt.Sprintf("error: %s", util.RootError(err))).Inc()
+		} else {
+			s.metrics.FederationPushes.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+// federationSummary returns the summary of the cluster to push to the federation service, or false
+// if the plugin hasn't finished startup (and so the summary may be incomplete).
+func (s *PluginState) federationSummary(clusterName string) (_ api.FederationClusterSummary, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := api.FederationClusterSummary{
+		Cluster:             clusterName,
+		Timestamp:           time.Now(),
+		Nodes:               len(s.nodes),
+		NodesAboveWatermark: 0,
+		Total:               api.Resources{VCPU: 0, Mem: 0},
+		Reserved:            api.Resources{VCPU: 0, Mem: 0},
+		Watermark:           api.Resources{VCPU: 0, Mem: 0},
+		Migrating:           api.Resources{VCPU: 0, Mem: 0},
+	}
+
+	if !s.startupDone {
+		return summary, false
+	}
+
+	for _, ns := range s.nodes {
+		cpu, mem := ns.node.CPU, ns.node.Mem
+
+		if cpu.Reserved > cpu.Watermark || mem.Reserved > mem.Watermark {
+			summary.NodesAboveWatermark += 1
+		}
+
+		summary.Total = summary.Total.Add(api.Resources{VCPU: cpu.Total, Mem: mem.Total})
+		summary.Reserved = summary.Reserved.Add(api.Resources{VCPU: cpu.Reserved, Mem: mem.Reserved})
+		summary.Watermark = summary.Watermark.Add(api.Resources{VCPU: cpu.Watermark, Mem: mem.Watermark})
+		summary.Migrating = summary.Migrating.Add(api.Resources{VCPU: cpu.Migrating, Mem: mem.Migrating})
+	}
+
+	return summary, true
+}
+
+// pushFederationSummary sends the summary to the federation endpoint.
+func pushFederationSummary(ctx context.Context, config FederationConfig, summary api.FederationClusterSummary) error {
+	reqBody, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("Error encoding request JSON: %w", err)
+	}
+
+	timeout := time.Second * time.Duration(config.RequestTimeoutSeconds)
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(reqCtx, http.MethodPost, config.Endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("Error building request to %q: %w", config.Endpoint, err)
+	}
+	request.Header.Set("content-type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Error doing request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("Received response status %d body %q", response.StatusCode, string(respBody))
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestFederationSummary(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+	// node-1 is above its CPU watermark. node-2 is empty.
+	node1 := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, 0.5, nil)
+	node1.AddPod(preemptionTestPod("vm-a", 6000, true))
+	node2 := state.NodeStateFromParams("node-2", 10000, 40*1024*1024*1024, 0.5, nil)
+	s.nodes["node-1"] = &nodeState{node: node1} //nolint:exhaustruct // only need the node for this test
+	s.nodes["node-2"] = &nodeState{node: node2} //nolint:exhaustruct // only need the node for this test
+
+	// Before startup is done, the summary may be incomplete
+	_, ok := s.federationSummary("cluster-a")
+	assert.False(t, ok)
+
+	s.startupDone = true
+	summary, ok := s.federationSummary("cluster-a")
+	require.True(t, ok)
+
+	assert.Equal(t, "cluster-a", summary.Cluster)
+	assert.Equal(t, 2, summary.Nodes)
+	assert.Equal(t, 1, summary.NodesAboveWatermark)
+	assert.Equal(t, api.Resources{VCPU: 20000, Mem: 80 * 1024 * 1024 * 1024}, summary.Total)
+	assert.Equal(t, node1.CPU.Reserved, summary.Reserved.VCPU)
+	assert.Equal(t, node1.Mem.Reserved, summary.Reserved.Mem)
+	assert.Equal(t, node1.CPU.Watermark+node2.CPU.Watermark, summary.Watermark.VCPU)
+	assert.Equal(t, node1.Mem.Watermark+node2.Mem.Watermark, summary.Watermark.Mem)
+	assert.Equal(t, api.Resources{VCPU: 0, Mem: 0}, summary.Migrating)
+}
+
+func TestPushFederationSummary(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK, wantErr: false},
+		{name: "accepted", status: http.StatusAccepted, wantErr: false},
+		{name: "bad request", status: http.StatusBadRequest, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var received api.FederationClusterSummary
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("content-type"))
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+				w.WriteHeader(c.status)
+			}))
+			defer server.Close()
+
+			config := FederationConfig{
+				Endpoint:              server.URL,
+				ClusterName:           "cluster-a",
+				PushIntervalSeconds:   10,
+				RequestTimeoutSeconds: 5,
+			}
+			summary := api.FederationClusterSummary{ //nolint:exhaustruct // only need a few fields to check the request
+				Cluster: "cluster-a",
+				Nodes:   3,
+			}
+
+			err := pushFederationSummary(context.Background(), config, summary)
+			if c.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, "cluster-a", received.Cluster)
+			assert.Equal(t, 3, received.Nodes)
+		})
+	}
+}
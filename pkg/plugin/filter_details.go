@@ -0,0 +1,100 @@
+package plugin
+
+// Explaining why Filter rejected a pod from a node.
+//
+// The reasons from podFilterReason are kept short and fixed, because they're matched elsewhere
+// (e.g. for preemption) and kube-scheduler counts nodes by reason in the pod's FailedScheduling
+// event. So Filter only adds a hint after the reason about what would allow the pod onto the node
+// -- the hints are fixed as well, so every node rejected for the same reason is still grouped
+// together in the event.
+//
+// On their own though, the reasons don't say by how much the node was over, which is usually the
+// first question when a VM is stuck Pending. Those details differ for every node, so they're only
+// included in the logs and in the output of the /simulate endpoint.
+
+import (
+	"fmt"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// Hints that Filter adds after the reason, about what would allow the pod onto the node.
+const (
+	filterHintCapacity    = "Pod may fit once VMs on the Node scale down or are migrated away"
+	filterHintWarmPool    = "Unclaimed warm pool Pods only use spare capacity below the Node's watermark"
+	filterHintLowPriority = "Only high-priority VMs may use capacity above the Node's watermark"
+)
+
+// filterStatusReasons returns the reasons for Filter's status when rejecting a pod for the reason
+// from podFilterReason: the reason itself, followed by its hint if it has one.
+func filterStatusReasons(reason string) []string {
+	if hint := filterReasonHint(reason); hint != "" {
+		return []string{reason, hint}
+	}
+	return []string{reason}
+}
+
+// filterReasonHint returns the hint about what would allow the pod onto the node, for the reason
+// from podFilterReason, or "" if there isn't one.
+func filterReasonHint(reason string) string {
+	switch reason {
+	case filterReasonNotEnoughResources, filterReasonNotEnoughBandwidth:
+		return filterHintCapacity
+	case filterReasonNotEnoughSpare:
+		return filterHintWarmPool
+	case filterReasonLowPriorityAboveWatermark:
+		return filterHintLowPriority
+	default:
+		return ""
+	}
+}
+
+// filterReasonDetails returns the details of the node's usage explaining the reason from
+// podFilterReason, or nil if there's nothing to add to the reason.
+func filterReasonDetails(reason string, nodeWithPod *state.Node) []string {
+	n := nodeWithPod
+
+	var details []string
+	switch reason {
+	case filterReasonNotEnoughResources:
+		if n.CPU.Reserved > n.CPU.Total {
+			details = append(details, fmt.Sprintf(
+				"Node would exceed CPU capacity by %v cores", n.CPU.Reserved-n.CPU.Total,
+			))
+		}
+		if n.Mem.Reserved > n.Mem.Total {
+			details = append(details, fmt.Sprintf(
+				"Node would exceed memory capacity by %v", n.Mem.Reserved-n.Mem.Total,
+			))
+		}
+	case filterReasonNotEnoughBandwidth:
+		details = append(details, fmt.Sprintf(
+			"Node would exceed network bandwidth by %d bits/s",
+			n.NetworkBandwidth.Reserved-n.NetworkBandwidth.Total,
+		))
+	case filterReasonNotEnoughSpare, filterReasonLowPriorityAboveWatermark:
+		details = watermarkDetails(n)
+	}
+	return details
+}
+
+// watermarkDetails returns a message for each resource that's above the node's watermark, giving
+// the usage and watermark as fractions of the node's total.
+func watermarkDetails(n *state.Node) []string {
+	var details []string
+	if n.CPU.Reserved > n.CPU.Watermark {
+		details = append(details, fmt.Sprintf(
+			"Node would be over CPU watermark: %.2f > %.2f",
+			usageFraction(n.CPU.Reserved, n.CPU.Total),
+			usageFraction(n.CPU.Watermark, n.CPU.Total),
+		))
+	}
+	if n.Mem.Reserved > n.Mem.Watermark {
+		details = append(details, fmt.Sprintf(
+			"Node would be over memory watermark: %.2f > %.2f",
+			usageFraction(n.Mem.Reserved, n.Mem.Total),
+			usageFraction(n.Mem.Watermark, n.Mem.Total),
+		))
+	}
+	return details
+}
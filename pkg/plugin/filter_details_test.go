@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestFilterReasonDetails(t *testing.T) {
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, 0.9, nil)
+	node.AddPod(preemptionTestPod("vm-a", 9500, true))
+
+	assert.Equal(t, []string{
+		"Node would be over CPU watermark: 0.95 > 0.90",
+	}, filterReasonDetails(filterReasonLowPriorityAboveWatermark, node))
+
+	node.AddPod(preemptionTestPod("vm-b", 1000, true))
+	assert.Equal(t, []string{
+		"Node would exceed CPU capacity by 0.5 cores",
+	}, filterReasonDetails(filterReasonNotEnoughResources, node))
+
+	// Reasons that already say everything there is to say don't get any details
+	assert.Nil(t, filterReasonDetails(filterReasonDraining, node))
+}
+
+func TestFilterStatusReasons(t *testing.T) {
+	cases := []struct {
+		reason   string
+		expected []string
+	}{
+		{
+			reason:   filterReasonNotEnoughResources,
+			expected: []string{filterReasonNotEnoughResources, filterHintCapacity},
+		},
+		{
+			reason:   filterReasonNotEnoughSpare,
+			expected: []string{filterReasonNotEnoughSpare, filterHintWarmPool},
+		},
+		{
+			reason:   filterReasonLowPriorityAboveWatermark,
+			expected: []string{filterReasonLowPriorityAboveWatermark, filterHintLowPriority},
+		},
+		{
+			reason:   filterReasonDraining,
+			expected: []string{filterReasonDraining},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.reason, func(t *testing.T) {
+			// The reasons must be the same for every node, so that kube-scheduler groups them
+			// together in the pod's events.
+			assert.Equal(t, c.expected, filterStatusReasons(c.reason))
+		})
+	}
+}
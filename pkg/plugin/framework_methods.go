@@ -3,8 +3,10 @@ package plugin
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"strings"
+	"time"
 
+	"github.com/samber/lo"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
@@ -28,11 +30,19 @@ type AutoscaleEnforcer struct {
 	logger  *zap.Logger
 	state   *PluginState
 	metrics *metrics.Framework
+
+	// getNodeInfo returns the scheduler's current snapshot of the node, for preemption. If nil,
+	// preemption is disabled.
+	getNodeInfo func(nodeName string) (*framework.NodeInfo, error)
+
+	// decisions writes out the decision log. It's nil if the decision log is disabled.
+	decisions *decisionLogger
 }
 
 // Compile-time checks that AutoscaleEnforcer actually implements the interfaces we want it to
 var (
 	_ framework.Plugin           = (*AutoscaleEnforcer)(nil)
+	_ framework.PreFilterPlugin  = (*AutoscaleEnforcer)(nil)
 	_ framework.PostFilterPlugin = (*AutoscaleEnforcer)(nil)
 	_ framework.FilterPlugin     = (*AutoscaleEnforcer)(nil)
 	_ framework.ScorePlugin      = (*AutoscaleEnforcer)(nil)
@@ -55,11 +65,18 @@ func logFieldForNodeName(nodeName string) zap.Field {
 	))
 }
 
-func (e *AutoscaleEnforcer) checkSchedulerName(logger *zap.Logger, pod *corev1.Pod) *framework.Status {
-	if e.state.config.SchedulerName != pod.Spec.SchedulerName {
+func (e *AutoscaleEnforcer) checkSchedulerName(logger *zap.Logger, cfg *Config, pod *corev1.Pod) *framework.Status {
+	if cfg.ShadowMode {
+		// Shadow replicas should never be asked to schedule pods, but just in case they are (e.g.
+		// because they were deployed with the same profile as the real scheduler), don't.
+		msg := "Scheduler is running in shadow mode"
+		logger.Error(msg)
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, msg)
+	}
+	if cfg.SchedulerName != pod.Spec.SchedulerName {
 		err := fmt.Errorf(
 			"mismatched SchedulerName for pod: our config has %q, but the pod has %q",
-			e.state.config.SchedulerName, pod.Spec.SchedulerName,
+			cfg.SchedulerName, pod.Spec.SchedulerName,
 		)
 		logger.Error("Pod has unexpected SchedulerName", zap.Error(err))
 		return framework.NewStatus(framework.Error, err.Error())
@@ -82,7 +99,7 @@ func (e *AutoscaleEnforcer) PostFilter(
 	pod *corev1.Pod,
 	filteredNodeStatusMap framework.NodeToStatusMap,
 ) (_ *framework.PostFilterResult, status *framework.Status) {
-	ignored := e.state.config.ignoredNamespace(pod.Namespace)
+	ignored := e.state.ignoredNamespace(pod.Namespace)
 
 	e.metrics.IncMethodCall("PostFilter", pod, ignored)
 	defer func() {
@@ -95,19 +112,54 @@ func (e *AutoscaleEnforcer) PostFilter(
 	)
 	logger.Error("Pod rejected by all Filter method calls")
 
+	if !ignored {
+		e.state.recordSchedulingFailure(pod, filteredNodeStatusMap)
+
+		decision := decisionFromCycleState(state)
+		decision.recordStatuses(filteredNodeStatusMap)
+
+		if result := e.preempt(logger, pod, filteredNodeStatusMap); result != nil {
+			e.decisions.write(decision, pod, decisionOutcomeUnschedulable, result.NominatedNodeName, "")
+			return result, framework.NewStatus(framework.Success)
+		}
+		e.decisions.write(decision, pod, decisionOutcomeUnschedulable, "", "")
+	}
+
 	return nil, nil // PostFilterResult is optional, nil Status is success.
 }
 
+// PreFilter is used by us only to start recording the decision for the pod, if the decision log is
+// enabled.
+//
+// PreFilter implements framework.PreFilterPlugin.
+func (e *AutoscaleEnforcer) PreFilter(
+	ctx context.Context,
+	state *framework.CycleState,
+	pod *corev1.Pod,
+) (*framework.PreFilterResult, *framework.Status) {
+	if e.decisions != nil && !e.state.ignoredNamespace(pod.Namespace) {
+		state.Write(decisionStateKey, newSchedulingDecision())
+	}
+
+	return nil, nil // PreFilterResult is optional, nil Status is success.
+}
+
+// PreFilterExtensions is required for framework.PreFilterPlugin, and can return nil if it's not
+// used.
+func (e *AutoscaleEnforcer) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
 // Filter gives our plugin a chance to signal that a pod shouldn't be put onto a particular node
 //
 // Filter implements framework.FilterPlugin.
 func (e *AutoscaleEnforcer) Filter(
 	ctx context.Context,
-	_state *framework.CycleState,
+	cycleState *framework.CycleState,
 	pod *corev1.Pod,
 	nodeInfo *framework.NodeInfo,
 ) (status *framework.Status) {
-	ignored := e.state.config.ignoredNamespace(pod.Namespace)
+	ignored := e.state.ignoredNamespace(pod.Namespace)
 
 	e.metrics.IncMethodCall("Filter", pod, ignored)
 	defer func() {
@@ -122,13 +174,23 @@ func (e *AutoscaleEnforcer) Filter(
 		reconcile.ObjectMetaLogField("Node", nodeInfo.Node()),
 	)
 
+	// note: this runs before the metrics are updated, so that pods we don't actually reject in
+	// dry-run mode aren't counted as failures.
+	defer func() {
+		status = e.state.dryRunFilterStatus(logger, status)
+		decisionFromCycleState(cycleState).recordFilter(nodeName, status)
+	}()
+
 	logger.Info("Handling Filter request")
 
-	if status := e.checkSchedulerName(logger, pod); status != nil {
+	// Load the config once, so that it's consistent for the whole request.
+	cfg := e.state.config()
+
+	if status := e.checkSchedulerName(logger, cfg, pod); status != nil {
 		return status
 	}
 
-	podState, err := state.PodStateFromK8sObj(pod)
+	podState, err := cfg.podStateFromK8sObj(pod)
 	if err != nil {
 		msg := "Error extracting local information for Pod"
 		logger.Error(msg, zap.Error(err))
@@ -155,26 +217,62 @@ func (e *AutoscaleEnforcer) Filter(
 		return framework.NewStatus(framework.Error, msg)
 	}
 
+	if cfg.HonorPodTopology {
+		reason, err := e.state.topologyCheck(pod, nodeName)
+		if err != nil {
+			msg := "Error checking Pod topology"
+			logger.Error(msg, zap.Error(err))
+			return framework.NewStatus(
+				framework.UnschedulableAndUnresolvable,
+				fmt.Sprintf("%s: %s", msg, err.Error()),
+			)
+		} else if reason != "" {
+			logger.Info("Rejecting Pod placement onto this Node", zap.String("Reason", reason))
+			return framework.NewStatus(framework.Unschedulable, reason)
+		}
+	}
+
+	if reason := nodeFilterReason(cfg, ns, time.Now()); reason != "" {
+		logger.Info("Rejecting Pod placement onto this Node", zap.String("Reason", reason))
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, reason)
+	}
+
+	maxVMs, limitVMs := cfg.maxVMsOnNode(nodeInfo.Node().Labels)
+
 	var approve bool
+	var reason string
 	ns.node.Speculatively(func(n *state.Node) (commit bool) {
-		approve = e.filterCheck(logger, ns.node, n, podState, proposedPods)
+		approve, reason = e.filterCheck(logger, cfg, ns.node, n, podState, proposedPods, maxVMs, limitVMs)
 		return false // never commit these changes; we're just using this for a temp node.
 	})
 
 	if !approve {
-		return framework.NewStatus(framework.Unschedulable, "Not enough resources for Pod")
+		return framework.NewStatus(framework.Unschedulable, filterStatusReasons(reason)...)
 	} else {
 		return nil
 	}
 }
 
+// Reasons that filterCheck may reject a pod from a node because the node doesn't have room for it.
+const (
+	filterReasonNotEnoughResources = "Not enough resources for Pod"
+	filterReasonVMLimitPrefix      = "Node has reached its limit of"
+	filterReasonNotEnoughBandwidth = "Not enough network bandwidth for Pod"
+	filterReasonNotEnoughSpare     = "Not enough spare resources for warm pool Pod"
+	// followed by the names of the resources
+	filterReasonNotEnoughExtendedPrefix = "Not enough extended resources for Pod:"
+)
+
 func (e *AutoscaleEnforcer) filterCheck(
 	logger *zap.Logger,
+	cfg *Config,
 	oldNode *state.Node,
 	tmpNode *state.Node,
 	filterPod state.Pod,
 	otherPods map[types.UID]*framework.PodInfo,
-) (ok bool) {
+	maxVMs int,
+	limitVMs bool,
+) (ok bool, reason string) {
 	type podInfo struct {
 		Namespace string
 		Name      string
@@ -217,7 +315,7 @@ func (e *AutoscaleEnforcer) filterCheck(
 			UID:       p.Pod.UID,
 		})
 
-		pod, err := state.PodStateFromK8sObj(p.Pod)
+		pod, err := cfg.podStateFromK8sObj(p.Pod)
 		if err != nil {
 			logger.Error(
 				"Ignoring extra Pod in Filter stage because extracting custom state failed",
@@ -226,6 +324,7 @@ func (e *AutoscaleEnforcer) filterCheck(
 			)
 			continue
 		}
+		e.state.applySystemPodAccounting(cfg, p.Pod, &pod)
 
 		tmpNode.AddPod(pod)
 	}
@@ -241,7 +340,11 @@ func (e *AutoscaleEnforcer) filterCheck(
 	var canAddToNode bool
 	tmpNode.Speculatively(func(n *state.Node) (commit bool) {
 		n.AddPod(filterPod)
-		canAddToNode = !n.OverBudget()
+		reason = podFilterReason(cfg, n, filterPod, maxVMs, limitVMs)
+		canAddToNode = reason == ""
+		// The details are only logged, because they'd make the reason unique to each node in the
+		// pod's events. Refer to filter_details.go for more.
+		details := filterReasonDetails(reason, n)
 
 		var msg string
 		if canAddToNode {
@@ -257,11 +360,54 @@ func (e *AutoscaleEnforcer) filterCheck(
 			zap.Object("Pod", filterPod),
 			zap.Any("LocalPodsNotInFilterState", localNotInProposed),
 			zap.Any("FilterPodsNotInLocalState", proposedNotInLocalState),
+			zap.String("Reason", reason),
+			zap.Strings("Details", details),
 		)
 
 		return false // don't commit. Doesn't really matter because we're operating on the temp node.
 	})
-	return canAddToNode
+	return canAddToNode, reason
+}
+
+// nodeFilterReason returns a non-empty reason if no pods may be placed on the node, regardless of
+// its resources.
+func nodeFilterReason(cfg *Config, ns *nodeState, now time.Time) string {
+	switch {
+	case cfg.HonorScaleDownTaints && ns.scaleDown == scaleDownPending:
+		return filterReasonScaleDown
+	case ns.draining():
+		return filterReasonDraining
+	case ns.defragmenting(now):
+		return filterReasonDefragmenting
+	default:
+		return ""
+	}
+}
+
+// podFilterReason returns a non-empty reason if the pod must not be placed on the node, given the
+// node with the pod already added.
+func podFilterReason(cfg *Config, nodeWithPod *state.Node, pod state.Pod, maxVMs int, limitVMs bool) string {
+	n := nodeWithPod
+	if n.OverBudget() {
+		return filterReasonNotEnoughResources
+	} else if limitVMs && !lo.IsEmpty(pod.VirtualMachine) && n.VMs() > maxVMs {
+		// Only check the VM limit if the pod is a VM -- other pods shouldn't be blocked by it.
+		return fmt.Sprintf("%s %d VMs", filterReasonVMLimitPrefix, maxVMs)
+	} else if pod.NetworkBandwidth != 0 && n.NetworkBandwidth.OverBudget() {
+		// Similarly, only pods that declare their bandwidth are blocked by it.
+		return filterReasonNotEnoughBandwidth
+	} else if overBudget := n.ExtendedResourcesOverBudget(pod); len(overBudget) != 0 {
+		// Like bandwidth, only pods that request the resources are blocked by them.
+		return fmt.Sprintf("%s %s", filterReasonNotEnoughExtendedPrefix, joinResourceNames(overBudget))
+	} else if pod.WarmPool && n.WarmPoolOverWatermark() {
+		// Unclaimed warm pool VMs only use capacity that's spare, below the watermark, so that
+		// they never cause migrations of VMs that are in use.
+		return filterReasonNotEnoughSpare
+	} else if lowPriorityAboveWatermark(cfg, pod, n) {
+		// Only high-priority VMs may use the room above the watermark.
+		return filterReasonLowPriorityAboveWatermark
+	}
+	return ""
 }
 
 // Score allows our plugin to express which nodes should be preferred for scheduling new pods onto
@@ -278,11 +424,11 @@ func (e *AutoscaleEnforcer) filterCheck(
 // Score implements framework.ScorePlugin.
 func (e *AutoscaleEnforcer) Score(
 	ctx context.Context,
-	_state *framework.CycleState,
+	cycleState *framework.CycleState,
 	pod *corev1.Pod,
 	nodeName string,
 ) (_ int64, status *framework.Status) {
-	ignored := e.state.config.ignoredNamespace(pod.Namespace)
+	ignored := e.state.ignoredNamespace(pod.Namespace)
 
 	e.metrics.IncMethodCall("Score", pod, ignored)
 	defer func() {
@@ -296,11 +442,14 @@ func (e *AutoscaleEnforcer) Score(
 
 	logger.Info("Handling Score request", logFieldForNodeName(nodeName))
 
-	if status := e.checkSchedulerName(logger, pod); status != nil {
+	// Load the config once, so that it's consistent for the whole request.
+	cfg := e.state.config()
+
+	if status := e.checkSchedulerName(logger, cfg, pod); status != nil {
 		return framework.MinNodeScore, status
 	}
 
-	podState, err := state.PodStateFromK8sObj(pod)
+	podState, err := cfg.podStateFromK8sObj(pod)
 	if err != nil {
 		msg := "Error extracting local information for Pod"
 		logger.Error(msg, zap.Error(err))
@@ -321,6 +470,17 @@ func (e *AutoscaleEnforcer) Score(
 		return framework.MinNodeScore, status
 	}
 
+	var spreadExcess int
+	if cfg.HonorPodTopology {
+		spreadExcess, err = e.state.softTopologySpreadExcess(pod, nodeName)
+		if err != nil {
+			logger.Warn("Ignoring topology spread constraints for scoring", zap.Error(err))
+		}
+	}
+
+	tenantSpreadPenalty := e.state.tenantSpreadPenalty(cfg, pod, nodeName)
+	podMax := podMaxResources(pod)
+
 	var score int64
 
 	ns.node.Speculatively(func(tmp *state.Node) (commit bool) {
@@ -335,10 +495,21 @@ func (e *AutoscaleEnforcer) Score(
 				zap.Object("NodeWithPod", tmp),
 			)
 		} else {
-			cfg := e.state.config.Scoring
-			cpuScore := calculateScore(cfg, tmp.CPU.Reserved, tmp.CPU.Total, e.state.maxNodeCPU)
-			memScore := calculateScore(cfg, tmp.Mem.Reserved, tmp.Mem.Total, e.state.maxNodeMem)
-			scoreFraction := min(cpuScore, memScore)
+			scoring := cfg.Scoring.forNode(ns.labels)
+			// note: modifies tmp, which is never committed
+			growth := e.state.applyProjectedGrowth(scoring, tmp, pod.UID, podMax)
+			usageScore := scoring.strategy().Score(tmp, e.state.maxNodeCPU, e.state.maxNodeMem)
+			// Each unit of skew beyond "ScheduleAnyway" constraints' maxSkew further reduces the score.
+			scoreFraction := usageScore / float64(1+spreadExcess)
+			// Likewise for concentrating the tenant's VMs in one zone (or other domain).
+			scoreFraction /= 1 + tenantSpreadPenalty
+
+			bandwidthFraction := networkBandwidthScoreFraction(cfg, tmp)
+			scoreFraction *= bandwidthFraction
+			extendedFraction := extendedResourcesScoreFraction(tmp, podState)
+			scoreFraction *= extendedFraction
+			scaleDownFraction := scaleDownScoreFraction(cfg, ns)
+			scoreFraction *= scaleDownFraction
 
 			scoreLen := framework.MaxNodeScore - framework.MinNodeScore
 			score = framework.MinNodeScore + int64(float64(scoreLen)*scoreFraction)
@@ -346,8 +517,14 @@ func (e *AutoscaleEnforcer) Score(
 			logger.Info(
 				"Scored Pod placement for Node",
 				zap.Int64("Score", score),
-				zap.Float64("CPUFraction", cpuScore),
-				zap.Float64("MemFraction", memScore),
+				zap.String("Strategy", string(scoring.strategyName())),
+				zap.Float64("UsageFraction", usageScore),
+				zap.Object("ProjectedGrowth", growth),
+				zap.Int("TopologySpreadExcess", spreadExcess),
+				zap.Float64("TenantSpreadPenalty", tenantSpreadPenalty),
+				zap.Float64("NetworkBandwidthFraction", bandwidthFraction),
+				zap.Float64("ExtendedResourcesFraction", extendedFraction),
+				zap.Float64("ScaleDownFraction", scaleDownFraction),
 				zap.Object("NodeWithPod", tmp),
 			)
 		}
@@ -355,39 +532,54 @@ func (e *AutoscaleEnforcer) Score(
 		return false // never commit, we're doing this just to check.
 	})
 
+	decisionFromCycleState(cycleState).recordScore(nodeName, score)
 	return score, nil
 }
 
-type floatable interface {
-	AsFloat64() float64
-}
-
-// Refer to the comments in ScoringConfig for more. Also, see: https://www.desmos.com/calculator/wg8s0yn63s
-func calculateScore[T floatable](
-	cfg ScoringConfig,
-	reserved T,
-	total T,
-	maxTotalSeen T,
-) float64 {
-	y0 := cfg.MinUsageScore
-	y1 := cfg.MaxUsageScore
-	xp := cfg.ScorePeak
+// networkBandwidthScoreFraction returns the factor that the node's score is multiplied by, based
+// on how much of its network bandwidth would be in use.
+//
+// If bandwidth accounting is disabled or the node's bandwidth is unknown, this returns 1.
+func networkBandwidthScoreFraction(cfg *Config, node *state.Node) float64 {
+	if cfg.NetworkBandwidth == nil || node.NetworkBandwidth.Total == 0 {
+		return 1
+	}
 
-	fraction := reserved.AsFloat64() / total.AsFloat64()
-	scale := total.AsFloat64() / maxTotalSeen.AsFloat64()
+	used := float64(node.NetworkBandwidth.Reserved) / float64(node.NetworkBandwidth.Total)
+	return max(0, 1-cfg.NetworkBandwidth.ScoreWeight*min(used, 1))
+}
 
-	score := float64(1) // if fraction == nodeConf.ScorePeak
-	if fraction < cfg.ScorePeak {
-		score = y0 + (1-y0)/xp*fraction
-	} else if fraction > cfg.ScorePeak {
-		score = y1 + (1-y1)/(1-xp)*(1-fraction)
+// extendedResourcesScoreFraction returns the factor that the node's score is multiplied by, based
+// on how much of the extended resources requested by the pod would be in use.
+//
+// For each resource, the factor is 1 up to the resource's watermark, and then decreases linearly
+// to 0 when the node's entire allocatable amount is used.
+func extendedResourcesScoreFraction(node *state.Node, pod state.Pod) float64 {
+	fraction := 1.0
+	for name := range pod.ExtendedResources.All() {
+		r, ok := node.ExtendedResources[name]
+		if !ok || r.Reserved <= r.Watermark || r.Total == 0 {
+			continue
+		}
+		above := float64(r.Reserved-r.Watermark) / float64(max(1, r.Total-r.Watermark))
+		fraction *= max(0, 1-above)
 	}
+	return fraction
+}
 
-	return score * scale
+// joinResourceNames returns the names, separated by commas.
+func joinResourceNames(names []corev1.ResourceName) string {
+	strs := make([]string, len(names))
+	for i, name := range names {
+		strs[i] = string(name)
+	}
+	return strings.Join(strs, ", ")
 }
 
-// NormalizeScore weights scores uniformly in the range [minScore, trueScore], where
-// minScore is framework.MinNodeScore + 1.
+// NormalizeScore spreads out the scores according to the scoring config's Normalization, if it's
+// set, and then -- if Randomize is enabled -- weights scores uniformly in the range
+// [minScore, trueScore], where minScore is framework.MinNodeScore + 1 -- or, if the scoring config's
+// RandomJitterFraction is set, in the narrower range allowed by it.
 //
 // NormalizeScore implements framework.ScoreExtensions.
 func (e *AutoscaleEnforcer) NormalizeScore(
@@ -396,7 +588,7 @@ func (e *AutoscaleEnforcer) NormalizeScore(
 	pod *corev1.Pod,
 	scores framework.NodeScoreList,
 ) (status *framework.Status) {
-	ignored := e.state.config.ignoredNamespace(pod.Namespace)
+	ignored := e.state.ignoredNamespace(pod.Namespace)
 
 	e.metrics.IncMethodCall("NormalizeScore", pod, ignored)
 	defer func() {
@@ -411,48 +603,76 @@ func (e *AutoscaleEnforcer) NormalizeScore(
 	type scoring struct {
 		Node     string
 		OldScore int64
-		NewScore int64
+		// Normalized is the score after applying the config's Normalization, or OldScore if it's
+		// not set.
+		Normalized int64
+		NewScore   int64
+		// Draw is the random number that was added to the lowest score in the range, or -1 if the
+		// score wasn't randomized.
+		Draw int64
 	}
 
 	var scoreInfos []scoring
 
-	for _, node := range scores {
-		oldScore := node.Score
+	cfg := e.state.config().Scoring
 
-		// rand.Intn will panic if we pass in 0
-		if oldScore == 0 {
+	oldScores := make([]int64, len(scores))
+	for i := range scores {
+		oldScores[i] = scores[i].Score
+	}
+	if cfg.Normalization != nil {
+		normalizeScores(*cfg.Normalization, scores)
+	}
+
+	e.state.mu.Lock()
+	defer e.state.mu.Unlock()
+
+	for i := range scores {
+		node := &scores[i]
+		normalized := node.Score
+
+		var newScore, draw int64
+		var ok bool
+		if cfg.Randomize {
+			newScore, draw, ok = randomizeScore(cfg, e.state.scoreRand, normalized)
+		}
+		if !ok {
 			scoreInfos = append(scoreInfos, scoring{
-				Node:     node.Name,
-				OldScore: oldScore,
-				NewScore: node.Score,
+				Node:       node.Name,
+				OldScore:   oldScores[i],
+				Normalized: normalized,
+				NewScore:   normalized,
+				Draw:       -1,
 			})
 			continue
 		}
 
-		// This is different from framework.MinNodeScore. We use framework.MinNodeScore
-		// to indicate that a pod should not be placed on a node. The lowest
-		// actual score we assign a node is thus framework.MinNodeScore + 1
-		minScore := framework.MinNodeScore + 1
-
-		// We want to pick a score in the range [minScore, score], so use score + 1 - minScore, as
-		// rand.Intn picks a number in the *half open* range [0, n).
-		newScore := minScore + int64(rand.Intn(int(oldScore+1-minScore)))
 		node.Score = newScore
 		scoreInfos = append(scoreInfos, scoring{
-			Node:     node.Name,
-			OldScore: oldScore,
-			NewScore: newScore,
+			Node:       node.Name,
+			OldScore:   oldScores[i],
+			Normalized: normalized,
+			NewScore:   newScore,
+			Draw:       draw,
 		})
 	}
 
-	logger.Info("Randomized Node scores for Pod", zap.Any("scores", scoreInfos))
+	decisionFromCycleState(state).recordNormalizedScores(scores)
+
+	logger.Info(
+		"Normalized Node scores for Pod",
+		zap.Any("scores", scoreInfos),
+		zap.Any("normalization", cfg.Normalization),
+		zap.Int64p("randomSeed", cfg.RandomSeed),
+		zap.Float64("randomJitterFraction", cfg.RandomJitterFraction),
+	)
 	return nil
 }
 
 // ScoreExtensions is required for framework.ScorePlugin, and can return nil if it's not used.
-// However, we do use it, to randomize scores (when enabled).
+// However, we do use it, to normalize and randomize scores (when enabled).
 func (e *AutoscaleEnforcer) ScoreExtensions() framework.ScoreExtensions {
-	if e.state.config.Scoring.Randomize {
+	if cfg := e.state.config().Scoring; cfg.Randomize || cfg.Normalization != nil {
 		return e
 	} else {
 		return nil
@@ -466,11 +686,11 @@ func (e *AutoscaleEnforcer) ScoreExtensions() framework.ScoreExtensions {
 // Reserve implements framework.ReservePlugin.
 func (e *AutoscaleEnforcer) Reserve(
 	ctx context.Context,
-	_state *framework.CycleState,
+	cycleState *framework.CycleState,
 	pod *corev1.Pod,
 	nodeName string,
 ) (status *framework.Status) {
-	ignored := e.state.config.ignoredNamespace(pod.Namespace)
+	ignored := e.state.ignoredNamespace(pod.Namespace)
 
 	e.metrics.IncMethodCall("Reserve", pod, ignored)
 	defer func() {
@@ -489,11 +709,23 @@ func (e *AutoscaleEnforcer) Reserve(
 
 	logger.Info("Handling Reserve request", logFieldForNodeName(nodeName))
 
-	if status := e.checkSchedulerName(logger, pod); status != nil {
+	// note: deferred before acquiring the lock, so that it runs after the lock is released.
+	defer func() {
+		outcome, message := decisionOutcomeReserved, ""
+		if !status.IsSuccess() {
+			outcome, message = decisionOutcomeReserveFailed, status.Message()
+		}
+		e.decisions.write(decisionFromCycleState(cycleState), pod, outcome, nodeName, message)
+	}()
+
+	// Load the config once, so that it's consistent for the whole request.
+	cfg := e.state.config()
+
+	if status := e.checkSchedulerName(logger, cfg, pod); status != nil {
 		return status
 	}
 
-	podState, err := state.PodStateFromK8sObj(pod)
+	podState, err := cfg.podStateFromK8sObj(pod)
 	if err != nil {
 		msg := "Error extracting local information for Pod"
 		logger.Error(msg, zap.Error(err))
@@ -527,6 +759,13 @@ func (e *AutoscaleEnforcer) Reserve(
 	ns.node.Speculatively(func(n *state.Node) (commit bool) {
 		n.AddPod(podState)
 		e.state.tentativelyScheduled[pod.UID] = nodeName
+		e.state.tentativelyScheduledAt[pod.UID] = time.Now()
+		if cfg.storePodLabels() {
+			e.state.podLabels[pod.UID] = pod.Labels
+		}
+		if maxResources := podMaxResources(pod); maxResources != nil {
+			e.state.podMaxResources[pod.UID] = *maxResources
+		}
 
 		logger.Info(
 			"Reserved tentatively scheduled Pod on Node",
@@ -558,7 +797,7 @@ func (e *AutoscaleEnforcer) Unreserve(
 	pod *corev1.Pod,
 	nodeName string,
 ) {
-	ignored := e.state.config.ignoredNamespace(pod.Namespace)
+	ignored := e.state.ignoredNamespace(pod.Namespace)
 
 	e.metrics.IncMethodCall("Unreserve", pod, ignored)
 
@@ -599,6 +838,9 @@ func (e *AutoscaleEnforcer) Unreserve(
 		}
 		n.RemovePod(pod.UID)
 		delete(e.state.tentativelyScheduled, pod.UID)
+		delete(e.state.tentativelyScheduledAt, pod.UID)
+		delete(e.state.podLabels, pod.UID)
+		delete(e.state.podMaxResources, pod.UID)
 
 		logger.Info(
 			"Unreserved tentatively scheduled Pod",
@@ -7,7 +7,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,6 +24,7 @@ import (
 	vmclient "github.com/neondatabase/autoscaling/neonvm/client/clientset/versioned"
 	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/patcher"
 	"github.com/neondatabase/autoscaling/pkg/plugin/state"
 	"github.com/neondatabase/autoscaling/pkg/util"
 	"github.com/neondatabase/autoscaling/pkg/util/patch"
@@ -32,7 +35,9 @@ import (
 type PluginState struct {
 	mu sync.Mutex
 
-	config Config
+	// currentConfig stores the config, which may be swapped out at any time when it's reloaded.
+	// Use config() to access it.
+	currentConfig atomic.Pointer[Config]
 
 	nodes map[string]*nodeState
 
@@ -42,10 +47,24 @@ type PluginState struct {
 	//
 	// the string associated with each pod is the name of the node.
 	tentativelyScheduled map[types.UID]string
+	// tentativelyScheduledAt stores the time that each pod in tentativelyScheduled was reserved,
+	// so that reservations for pods that are never bound can be expired.
+	tentativelyScheduledAt map[types.UID]time.Time
 
-	startupDone         bool
+	startupDone bool
+	// requeueAfterStartup stores the pods whose changes were deferred until startup is done -- or,
+	// if the config's LeaderElection is enabled, until we become the leader.
 	requeueAfterStartup map[types.UID]struct{}
 
+	// leading is whether this replica is the leader. It's always true if the config's
+	// LeaderElection is not enabled. Refer to leader_election.go for more.
+	leading atomic.Bool
+
+	// handoff stores the state restored from the previous instance of the scheduler, if the
+	// config's StateHandoff is enabled and there was a recent enough snapshot. Otherwise, it's nil.
+	// Refer to state_handoff.go for more.
+	handoff *stateSnapshot
+
 	// maxNodeCPU is the maximum amount of CPU we've seen available for a node.
 	// We use this when scoring pod placements.
 	maxNodeCPU vmv1.MilliCPU
@@ -53,6 +72,66 @@ type PluginState struct {
 	// We use this when scoring pod placements.
 	maxNodeMem api.Bytes
 
+	// upscaleLimiter enforces the config's UpscaleRateLimit, if there is one. Otherwise, it's nil.
+	upscaleLimiter *upscaleLimiter
+	// migrationLimiter enforces the config's MigrationLimits, if there are any. Otherwise, it's nil.
+	migrationLimiter *migrationLimiter
+	// blackout tracks the config's MigrationBlackoutWindows, during which migrations are not
+	// triggered.
+	blackout *blackoutState
+
+	// apiHealth tracks whether the API server is degraded, if the config's APIHealth is set.
+	// Otherwise, it's nil.
+	apiHealth *util.APIHealth
+
+	// systemPods stores the UIDs of the DaemonSet and static pods we've seen, if the config's
+	// SystemPodAccounting mode is "measured". Otherwise, it's empty.
+	systemPods map[util.NamespacedName]types.UID
+	// systemPodUsage stores the most recent measured usage for each of the pods in systemPods.
+	systemPodUsage map[util.NamespacedName]api.Resources
+
+	// podUsage stores the most recent usage reported by the autoscaler-agent for each VM pod, if
+	// the config's UsageBlending, MigrationDeferral, or NodePressureDownscale is enabled. Otherwise,
+	// it's empty.
+	podUsage map[types.UID]api.Metrics
+	// podUsageAtRequeue stores the usage of each VM pod as of the last time its node was requeued
+	// because of it, if the config's UsageBlending is enabled. Otherwise, it's empty.
+	podUsageAtRequeue map[types.UID]api.Metrics
+
+	// podLabels stores the labels of each pod in the local state, if the config's HonorPodTopology,
+	// TenantFairness, or Scoring.TopologySpread is enabled. Otherwise, it's empty.
+	podLabels map[types.UID]map[string]string
+
+	// podMaxResources stores the resources that each autoscaling VM pod in the local state can scale
+	// up to, for the config's Scoring.ProjectedUsageFactor.
+	podMaxResources map[types.UID]api.Resources
+
+	// preapprovalCharged stores, for pods with requests that were approved immediately because they
+	// were within the pod's preapproved resources, the resources that were approved -- so that the
+	// increase isn't taken from upscaleLimiter again once it's reserved for the pod. Refer to
+	// (*PluginState).approveWithinPreapproval.
+	preapprovalCharged map[types.UID]api.Resources
+
+	// shadowReportedMigrations stores the UIDs of pods that we would have migrated, if the config's
+	// ShadowMode is enabled, where we've already reported that the real scheduler didn't.
+	// Otherwise, it's empty.
+	shadowReportedMigrations map[types.UID]struct{}
+
+	// schedulingFailures stores the reasons that each VM pod has failed to be scheduled, for pods
+	// that haven't yet been bound to a node. Refer to scheduling_wait.go for more.
+	schedulingFailures map[types.UID]schedulingFailures
+
+	// scoreRand is the source of randomness for randomizing scores, if the config's
+	// Scoring.Randomize is enabled. It's seeded from Scoring.RandomSeed, if set.
+	scoreRand *rand.Rand
+
+	// failingReconciles stores the number of objects of each kind that are currently failing to be
+	// reconciled, for the fleet health report.
+	failingReconciles map[string]int
+	// reconcilesAboveFailureThreshold stores, for each kind of object, the UIDs of the objects that
+	// have failed to be reconciled at least LogSuccessiveFailuresThreshold times in a row.
+	reconcilesAboveFailureThreshold map[string]map[types.UID]struct{}
+
 	metrics metrics.Plugin
 
 	requeuePod      func(uid types.UID) error
@@ -60,16 +139,71 @@ type PluginState struct {
 	createMigration func(*zap.Logger, *vmv1.VirtualMachineMigration) error
 	deleteMigration func(*zap.Logger, *vmv1.VirtualMachineMigration) error
 	patchVM         func(util.NamespacedName, []patch.Operation) error
+	// evictPod evicts a pod in IgnoredNamespaces that was chosen as a preemption victim. It's set
+	// by the caller of NewPluginState, because it needs the Kubernetes clientset.
+	evictPod func(*zap.Logger, *corev1.Pod) error
+	// patchNode applies a JSON merge patch to a Node, for the config's CordonWatermark and for
+	// draining nodes. Like evictPod, it's set by the caller of NewPluginState.
+	patchNode func(logger *zap.Logger, nodeName string, patch []byte) error
+	// namespaceLabels returns the labels of the namespace, if the config's WatermarkOverrides or
+	// IgnoredNamespaceSelector were set at startup. Otherwise, it's nil.
+	namespaceLabels func(namespace string) (map[string]string, bool)
+	// listMigrations returns the migrations created by the plugin. It's only used for the fleet
+	// health report.
+	listMigrations func() []*vmv1.VirtualMachineMigration
 }
 
 type nodeState struct {
 	node *state.Node
 
-	// requestedMigrations stores the set of pods that we've decided we should migrate.
+	// labels stores the full set of labels on the Node object.
+	labels map[string]string
+
+	// memoryPressure is true if the Node has the MemoryPressure condition.
+	memoryPressure bool
+
+	// pressureCaps stores, for each pod that was asked to downscale during the node's current
+	// episode of memory pressure, the upper bound it was given. The bound is fixed for the rest of
+	// the episode so that repeated requests don't keep lowering it.
+	//
+	// It's cleared when the node's memory pressure ends.
+	pressureCaps map[types.UID]api.Resources
+
+	// scaleDown is whether cluster-autoscaler has marked the Node for removal, as given by its
+	// taints.
+	scaleDown scaleDownState
+
+	// overWatermark is whether the node's reserved resources went above its watermark, and haven't
+	// since dropped below the watermark minus the config's WatermarkHysteresis. It's updated each
+	// time the node is balanced.
+	overWatermark bool
+
+	// cordoned is the value of annotationCordoned on the Node, i.e. whether we've cordoned it for
+	// being above the config's CordonWatermark. It's empty if we haven't.
+	cordoned string
+
+	// unschedulable is the value of the Node's spec.unschedulable, i.e. whether it's cordoned, by us
+	// or anyone else.
+	unschedulable bool
+
+	// drainRequestedAt is the time that draining the node was requested, from annotationDrain. It's
+	// zero if the node isn't being drained.
+	drainRequestedAt time.Time
+
+	// defragmentingUntil, if in the future, is the time until which the node is being emptied for
+	// defragmentation, during which no pods may be scheduled onto it.
+	defragmentingUntil time.Time
+
+	// requestedMigrations stores the set of pods that we've decided we should migrate, with the
+	// time that we decided to do so.
 	//
 	// When they are reconciled, we will (a) double-check that we should still migrate them, and (b)
 	// if so, create a VirtualMachineMigration object to handle it.
-	requestedMigrations map[types.UID]struct{}
+	requestedMigrations map[types.UID]time.Time
+
+	// startingMigrations stores the set of pods in requestedMigrations that we've created a
+	// migration for, but haven't yet seen start. They count towards the config's MigrationLimits.
+	startingMigrations map[types.UID]struct{}
 
 	// podsVMPatchedAt stores the last time that the VirtualMachine object for a Pod was patched, so
 	// that we can avoid spamming patch requests if the Pod is just slightly out of date.
@@ -84,6 +218,7 @@ func NewPluginState(
 	reg prometheus.Registerer,
 	podWatchStore *watch.Store[corev1.Pod],
 	nodeWatchStore *watch.Store[corev1.Node],
+	namespaceWatchStore *watch.Store[corev1.Namespace], // may be nil
 ) *PluginState {
 	crudTimeout := time.Second * time.Duration(config.K8sCRUDTimeoutSeconds)
 
@@ -91,86 +226,185 @@ func NewPluginState(
 
 	metrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, reg)
 
-	return &PluginState{
+	apiHealth := util.NewAPIHealth(config.APIHealth, func(degraded bool) {
+		if degraded {
+			metrics.APIDegraded.Set(1)
+		} else {
+			metrics.APIDegraded.Set(0)
+		}
+	})
+
+	// All patches to VirtualMachine objects go through vmPatcher, so that patches from different
+	// code paths to the same VM don't conflict with each other.
+	vmPatcher := patcher.NewManager(
+		patcher.Config{
+			MinInterval: time.Second * time.Duration(config.PatchRetryWaitSeconds),
+			MaxAttempts: config.PatchMaxAttempts,
+		},
+		func(vm util.NamespacedName, patches []patch.Operation) error {
+			patchPayload, err := json.Marshal(patches)
+			if err != nil {
+				panic(fmt.Errorf("could not marshal JSON patch: %w", err))
+			}
+
+			ctx, cancel := context.WithTimeout(context.TODO(), crudTimeout)
+			defer cancel()
+
+			start := time.Now()
+			_, err = vmClient.NeonvmV1().VirtualMachines(vm.Namespace).
+				Patch(ctx, vm.Name, types.JSONPatchType, patchPayload, metav1.PatchOptions{})
+			apiHealth.Observe(time.Since(start), err)
+			metrics.RecordK8sOp("Patch", "VirtualMachine", vm.Name, err)
+			return err
+		},
+		patcher.NewMetrics("autoscaling_plugin_vm_patches", reg),
+	)
+
+	s := newPluginState(config, metrics, apiHealth)
+	s.requeuePod = func(uid types.UID) error {
+		ok := podWatchStore.NopUpdate(uid)
+		if !ok {
+			return errors.New("pod not found in watch store")
+		}
+		return nil
+	}
+	s.requeueNode = func(nodeName string) error {
+		node, ok := indexedNodeStore.GetIndexed(
+			func(i *watch.FlatNameIndex[corev1.Node]) (*corev1.Node, bool) {
+				return i.Get(nodeName)
+			},
+		)
+		if !ok {
+			return errors.New("node not found in watch store")
+		}
+
+		_ = nodeWatchStore.NopUpdate(node.UID)
+		return nil
+	}
+	s.createMigration = func(logger *zap.Logger, vmm *vmv1.VirtualMachineMigration) error {
+		ctx, cancel := context.WithTimeout(context.TODO(), crudTimeout)
+		defer cancel()
+
+		start := time.Now()
+		_, err := vmClient.NeonvmV1().VirtualMachineMigrations(vmm.Namespace).
+			Create(ctx, vmm, metav1.CreateOptions{})
+		apiHealth.Observe(time.Since(start), err)
+		metrics.RecordK8sOp("Create", "VirtualMachineMigration", vmm.Name, err)
+		if err != nil && apierrors.IsAlreadyExists(err) {
+			logger.Warn("Migration already exists for this pod")
+			return nil
+		}
+		return err
+	}
+	s.deleteMigration = func(logger *zap.Logger, vmm *vmv1.VirtualMachineMigration) error {
+		ctx, cancel := context.WithTimeout(context.TODO(), crudTimeout)
+		defer cancel()
+
+		opts := metav1.DeleteOptions{
+			// Include the extra pre-condition that we're deleting exactly the migration object
+			// that was specified.
+			Preconditions: &metav1.Preconditions{
+				UID:             &vmm.UID,
+				ResourceVersion: nil,
+			},
+		}
+
+		start := time.Now()
+		err := vmClient.NeonvmV1().VirtualMachineMigrations(vmm.Namespace).
+			Delete(ctx, vmm.Name, opts)
+		apiHealth.Observe(time.Since(start), err)
+		metrics.RecordK8sOp("Delete", "VirtualMachineMigration", vmm.Name, err)
+		return err
+	}
+	s.patchVM = vmPatcher.Patch
+	if namespaceWatchStore != nil {
+		indexedNamespaceStore := watch.NewIndexedStore(namespaceWatchStore, watch.NewFlatNameIndex[corev1.Namespace]())
+		s.namespaceLabels = func(namespace string) (map[string]string, bool) {
+			ns, ok := indexedNamespaceStore.GetIndexed(
+				func(i *watch.FlatNameIndex[corev1.Namespace]) (*corev1.Namespace, bool) {
+					return i.Get(namespace)
+				},
+			)
+			if !ok {
+				return nil, false
+			}
+			return ns.Labels, true
+		}
+	}
+	return s
+}
+
+// newPluginState creates a PluginState without any of the callbacks that interact with the
+// cluster, which must be set by the caller.
+func newPluginState(config Config, metrics metrics.Plugin, apiHealth *util.APIHealth) *PluginState {
+	s := &PluginState{
 		mu: sync.Mutex{},
 
-		config: config,
+		currentConfig: atomic.Pointer[Config]{},
 
-		nodes:                make(map[string]*nodeState),
-		tentativelyScheduled: make(map[types.UID]string),
+		nodes:                  make(map[string]*nodeState),
+		tentativelyScheduled:   make(map[types.UID]string),
+		tentativelyScheduledAt: make(map[types.UID]time.Time),
 
 		startupDone:         false,
 		requeueAfterStartup: make(map[types.UID]struct{}),
 
+		leading: atomic.Bool{},
+		handoff: nil,
+
 		// these values will be set as we handle node events:
 		maxNodeCPU: 0,
 		maxNodeMem: 0,
 
-		metrics: metrics,
-		requeuePod: func(uid types.UID) error {
-			ok := podWatchStore.NopUpdate(uid)
-			if !ok {
-				return errors.New("pod not found in watch store")
-			}
-			return nil
-		},
-		requeueNode: func(nodeName string) error {
-			node, ok := indexedNodeStore.GetIndexed(
-				func(i *watch.FlatNameIndex[corev1.Node]) (*corev1.Node, bool) {
-					return i.Get(nodeName)
-				},
-			)
-			if !ok {
-				return errors.New("node not found in watch store")
-			}
+		upscaleLimiter:   newUpscaleLimiter(config.UpscaleRateLimit, time.Now()),
+		migrationLimiter: newMigrationLimiter(config.MigrationLimits, time.Now()),
+		blackout:         newBlackoutState(config.MigrationBlackoutWindows),
+		apiHealth:        apiHealth,
 
-			_ = nodeWatchStore.NopUpdate(node.UID)
-			return nil
-		},
-		createMigration: func(logger *zap.Logger, vmm *vmv1.VirtualMachineMigration) error {
-			ctx, cancel := context.WithTimeout(context.TODO(), crudTimeout)
-			defer cancel()
+		systemPods:     make(map[util.NamespacedName]types.UID),
+		systemPodUsage: make(map[util.NamespacedName]api.Resources),
 
-			_, err := vmClient.NeonvmV1().VirtualMachineMigrations(vmm.Namespace).
-				Create(ctx, vmm, metav1.CreateOptions{})
-			metrics.RecordK8sOp("Create", "VirtualMachineMigration", vmm.Name, err)
-			if err != nil && apierrors.IsAlreadyExists(err) {
-				logger.Warn("Migration already exists for this pod")
-				return nil
-			}
-			return err
-		},
-		deleteMigration: func(logger *zap.Logger, vmm *vmv1.VirtualMachineMigration) error {
-			ctx, cancel := context.WithTimeout(context.TODO(), crudTimeout)
-			defer cancel()
+		podUsage:          make(map[types.UID]api.Metrics),
+		podUsageAtRequeue: make(map[types.UID]api.Metrics),
+		podLabels:         make(map[types.UID]map[string]string),
 
-			opts := metav1.DeleteOptions{
-				// Include the extra pre-condition that we're deleting exactly the migration object
-				// that was specified.
-				Preconditions: &metav1.Preconditions{
-					UID:             &vmm.UID,
-					ResourceVersion: nil,
-				},
-			}
+		podMaxResources:    make(map[types.UID]api.Resources),
+		preapprovalCharged: make(map[types.UID]api.Resources),
 
-			err := vmClient.NeonvmV1().VirtualMachineMigrations(vmm.Namespace).
-				Delete(ctx, vmm.Name, opts)
-			metrics.RecordK8sOp("Delete", "VirtualMachineMigration", vmm.Name, err)
-			return err
-		},
-		patchVM: func(vm util.NamespacedName, patches []patch.Operation) error {
-			patchPayload, err := json.Marshal(patches)
-			if err != nil {
-				panic(fmt.Errorf("could not marshal JSON patch: %w", err))
-			}
+		shadowReportedMigrations: make(map[types.UID]struct{}),
 
-			ctx, cancel := context.WithTimeout(context.TODO(), crudTimeout)
-			defer cancel()
+		schedulingFailures: make(map[types.UID]schedulingFailures),
 
-			_, err = vmClient.NeonvmV1().VirtualMachines(vm.Namespace).
-				Patch(ctx, vm.Name, types.JSONPatchType, patchPayload, metav1.PatchOptions{})
-			metrics.RecordK8sOp("Patch", "VirtualMachine", vm.Name, err)
-			return err
-		},
+		scoreRand: newScoreRand(config.Scoring),
+
+		failingReconciles: make(map[string]int),
+
+		reconcilesAboveFailureThreshold: make(map[string]map[types.UID]struct{}),
+
+		metrics: metrics,
+
+		requeuePod:      nil,
+		requeueNode:     nil,
+		createMigration: nil,
+		deleteMigration: nil,
+		patchVM:         nil,
+		evictPod:        nil,
+		patchNode:       nil,
+		namespaceLabels: nil,
+		listMigrations:  nil,
 	}
+	s.currentConfig.Store(&config)
+	if config.LeaderElection == nil {
+		s.leading.Store(true)
+		metrics.Leader.Set(1)
+	}
+	return s
+}
+
+// config returns the current config.
+//
+// The returned config must not be modified. Because the config may be reloaded at any time, callers
+// that need multiple fields to be consistent with each other should only call this once.
+func (s *PluginState) config() *Config {
+	return s.currentConfig.Load()
 }
@@ -11,6 +11,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
+	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
 	"github.com/neondatabase/autoscaling/pkg/plugin/state"
 )
@@ -29,10 +30,21 @@ func (s *PluginState) HandleNodeEvent(logger *zap.Logger, kind reconcile.EventKi
 }
 
 func (s *PluginState) updateNode(logger *zap.Logger, node *corev1.Node, expectExists bool) error {
-	newNode, err := state.NodeStateFromK8sObj(node, s.config.Watermark, s.metrics.Nodes.InheritedLabels)
+	newNode, err := state.NodeStateFromK8sObj(
+		node,
+		state.WatermarkFractions{CPU: s.config().cpuWatermark(), Mem: s.config().memWatermark()},
+		s.metrics.Nodes.InheritedLabels,
+		s.config().nodeReserve(),
+	)
 	if err != nil {
 		return fmt.Errorf("could not get state from Node object: %w", err)
 	}
+	newNode.NetworkBandwidth.Total, err = s.config().nodeNetworkBandwidth(node.Labels)
+	if err != nil {
+		// Not worth failing over -- treat the node's bandwidth as unknown, i.e. unlimited.
+		logger.Warn("Could not determine Node network bandwidth", zap.Error(err))
+	}
+	newNode.ExtendedResources = state.NodeExtendedResourcesFromK8sObj(node, s.config().extendedResourceWatermarks())
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -50,10 +62,21 @@ func (s *PluginState) updateNode(logger *zap.Logger, node *corev1.Node, expectEx
 
 		entry := &nodeState{
 			node:                newNode,
-			requestedMigrations: make(map[types.UID]struct{}),
+			labels:              node.Labels,
+			memoryPressure:      hasMemoryPressure(node),
+			pressureCaps:        make(map[types.UID]api.Resources),
+			scaleDown:           scaleDownStateOf(node),
+			cordoned:            cordonAnnotationOf(node),
+			unschedulable:       node.Spec.Unschedulable,
+			drainRequestedAt:    drainRequestedAtOf(logger, node),
+			defragmentingUntil:  time.Time{},
+			requestedMigrations: make(map[types.UID]time.Time),
+			startingMigrations:  make(map[types.UID]struct{}),
 			podsVMPatchedAt:     make(map[types.UID]time.Time),
 		}
 
+		s.applyHandoffToNode(node.Name, entry)
+
 		logger.Info("Adding base node state", zap.Object("Node", entry.node))
 		s.nodes[node.Name] = entry
 		updated = entry
@@ -71,6 +94,32 @@ func (s *PluginState) updateNode(logger *zap.Logger, node *corev1.Node, expectEx
 			}
 			return true // yes, apply the change
 		})
+		oldNS.labels = node.Labels
+		if pressure := hasMemoryPressure(node); pressure != oldNS.memoryPressure {
+			logger.Info("Node memory pressure changed", zap.Bool("MemoryPressure", pressure))
+			oldNS.memoryPressure = pressure
+			if !pressure {
+				clear(oldNS.pressureCaps)
+			}
+		}
+		if scaleDown := scaleDownStateOf(node); scaleDown != oldNS.scaleDown {
+			logger.Info(
+				"Node cluster-autoscaler scale-down state changed",
+				zap.String("Old", string(oldNS.scaleDown)),
+				zap.String("New", string(scaleDown)),
+			)
+			oldNS.scaleDown = scaleDown
+		}
+		oldNS.cordoned = cordonAnnotationOf(node)
+		oldNS.unschedulable = node.Spec.Unschedulable
+		if drainRequestedAt := drainRequestedAtOf(logger, node); !drainRequestedAt.Equal(oldNS.drainRequestedAt) {
+			logger.Info(
+				"Node drain changed",
+				zap.Time("OldRequestedAt", oldNS.drainRequestedAt),
+				zap.Time("RequestedAt", drainRequestedAt),
+			)
+			oldNS.drainRequestedAt = drainRequestedAt
+		}
 		updated = oldNS
 	}
 
@@ -103,7 +152,10 @@ func (s *PluginState) deleteNode(logger *zap.Logger, node *corev1.Node, expectEx
 //
 // NOTE: this function expects that the caller has acquired s.mu.
 func (s *PluginState) reconcileNode(logger *zap.Logger, ns *nodeState) error {
-	defer s.metrics.Nodes.Update(ns.node)
+	// note: wrapped in a closure so that it uses the over-watermark state after balancing.
+	defer func() {
+		s.metrics.Nodes.Update(ns.node, ns.overWatermark)
+	}()
 
 	err := s.balanceNode(logger, ns)
 	if err != nil {
@@ -121,10 +173,27 @@ func (s *PluginState) updateNodeMetricsAndRequeue(logger *zap.Logger, ns *nodeSt
 	if err := s.requeueNode(ns.node.Name); err != nil {
 		logger.Error("Failed to requeue Node", zap.Error(err))
 	}
-	s.metrics.Nodes.Update(ns.node)
+	s.metrics.Nodes.Update(ns.node, ns.overWatermark)
 }
 
 func (s *PluginState) balanceNode(logger *zap.Logger, ns *nodeState) error {
+	// Standby replicas leave migrations to the leader. All nodes are requeued when we become the
+	// leader, so they'll be balanced then.
+	if !s.leading.Load() {
+		return nil
+	}
+
+	requestMigration := func(podUID types.UID) error {
+		if err := s.requeuePod(podUID); err != nil {
+			return err
+		}
+		ns.requestedMigrations[podUID] = time.Now()
+		return nil
+	}
+	if window := s.activeBlackoutWindow(time.Now()); window != "" {
+		requestMigration = s.suppressMigrationsForBlackout(logger, window)
+	}
+
 	var err error
 	// use Speculatively() to produce a temporary node that triggerMigrationsIfNecessary can use to
 	// evaluate what the state *will* look like after the migrations are running.
@@ -134,22 +203,42 @@ func (s *PluginState) balanceNode(logger *zap.Logger, ns *nodeState) error {
 		for uid := range ns.requestedMigrations {
 			requestedMigrations = append(requestedMigrations, uid)
 		}
+		s.applyUsageBlending(tmpNode)
+		s.applyWatermarkOverrides(tmpNode)
+		s.applyWatermarkHysteresis(ns, tmpNode)
 		err = triggerMigrationsIfNecessary(
 			logger,
 			originalNode,
 			tmpNode,
 			requestedMigrations,
-			func(podUID types.UID) error {
-				if err := s.requeuePod(podUID); err != nil {
-					return err
-				}
-				ns.requestedMigrations[podUID] = struct{}{}
-				return nil
-			},
+			s.disruptedGroups(),
+			migrationVictimOrder(s.config()),
+			requestMigration,
 		)
 
 		return false // Never actually commit; we're just using Speculatively() for a cheap copy.
 	})
+	if err != nil {
+		return err
+	}
+
+	var evacuateReason string
+	if ns.draining() {
+		evacuateReason = filterReasonDraining
+	} else if s.config().HonorScaleDownTaints && ns.scaleDown == scaleDownPending {
+		evacuateReason = filterReasonScaleDown
+	}
+	if evacuateReason != "" {
+		err = triggerDrainMigrations(
+			logger,
+			ns.node,
+			evacuateReason,
+			ns.requestedMigrations,
+			s.disruptedGroups(),
+			migrationVictimOrder(s.config()),
+			requestMigration,
+		)
+	}
 	return err
 }
 
@@ -159,6 +248,7 @@ func (s *PluginState) cleanupNode(logger *zap.Logger, ns *nodeState) {
 	for uid, nodeName := range s.tentativelyScheduled {
 		if nodeName == ns.node.Name {
 			delete(s.tentativelyScheduled, uid)
+			delete(s.tentativelyScheduledAt, uid)
 		}
 	}
 
@@ -167,3 +257,13 @@ func (s *PluginState) cleanupNode(logger *zap.Logger, ns *nodeState) {
 
 	logger.Info("Removed node", zap.Object("Node", ns.node))
 }
+
+// hasMemoryPressure returns whether the node's MemoryPressure condition is true.
+func hasMemoryPressure(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeMemoryPressure {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -17,8 +17,10 @@ import (
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/patcher"
 	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
 	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
 	"github.com/neondatabase/autoscaling/pkg/util/patch"
 )
 
@@ -27,8 +29,13 @@ func (s *PluginState) HandlePodEvent(
 	kind reconcile.EventKind,
 	pod *corev1.Pod,
 ) (*reconcile.Result, error) {
-	if s.config.ignoredNamespace(pod.Namespace) {
-		// We intentionally don't include ignored pods in the namespace.
+	if s.ignoredNamespace(pod.Namespace) {
+		// We intentionally don't include ignored pods in the namespace. But the namespace may have
+		// only become ignored after the pod was added, so make sure it's not left behind.
+		if s.podInLocalState(pod.UID) {
+			logger.Info("Removing Pod from local state because its namespace is now ignored")
+			return nil, s.deletePod(logger, pod, true)
+		}
 		return nil, nil
 	}
 
@@ -51,6 +58,12 @@ func (s *PluginState) HandlePodEvent(
 		if updateResult != nil {
 			if updateResult.afterUnlock != nil {
 				if err := updateResult.afterUnlock(); err != nil {
+					// If the patch can be retried, make sure we don't back off for longer than
+					// necessary.
+					var retryErr *patcher.RetryAfterError
+					if errors.As(err, &retryErr) {
+						reconcileResult = &reconcile.Result{RetryAfter: retryErr.RetryAfter}
+					}
 					return reconcileResult, err
 				}
 			}
@@ -83,7 +96,10 @@ func (s *PluginState) updatePod(
 	pod *corev1.Pod,
 	expectExists bool,
 ) (*podUpdateResult, error) {
-	newPod, err := state.PodStateFromK8sObj(pod)
+	// Load the config once, so that it's consistent for the whole update.
+	cfg := s.config()
+
+	newPod, err := cfg.podStateFromK8sObj(pod)
 	if err != nil {
 		return nil, fmt.Errorf("could not get state from Pod object: %w", err)
 	}
@@ -91,6 +107,9 @@ func (s *PluginState) updatePod(
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.applySystemPodAccounting(cfg, pod, &newPod)
+	fromHandoff := s.applyHandoffToPod(logger, pod, &newPod)
+
 	var ns *nodeState // pre-declare this so we can update metrics in a defer
 	defer func() {
 		if ns != nil {
@@ -104,7 +123,12 @@ func (s *PluginState) updatePod(
 			// oh hey, this pod has been properly scheduled now! Let's remove it from the
 			// "tentatively scheduled" set.
 			delete(s.tentativelyScheduled, pod.UID)
+			delete(s.tentativelyScheduledAt, pod.UID)
 			logger.Info("Pod was scheduled as expected")
+			if !lo.IsEmpty(newPod.VirtualMachine) {
+				s.observeSchedulingWait(pod, newPod)
+				s.recordStorageLocalityPlacement(logger, pod)
+			}
 		} else if pod.Spec.NodeName != "" {
 			logger.Panic(
 				"Pod was scheduled onto a different Node than tentatively recorded",
@@ -133,6 +157,11 @@ func (s *PluginState) updatePod(
 		return nil, fmt.Errorf("pod's node %q is not present in local state", nodeName)
 	}
 
+	if _, exists := ns.node.GetPod(newPod.UID); !exists && cfg.ShadowMode && s.startupDone &&
+		!lo.IsEmpty(newPod.VirtualMachine) && pod.Spec.SchedulerName == cfg.SchedulerName {
+		s.compareShadowPlacement(logger, pod, newPod, nodeName)
+	}
+
 	// make the changes in Speculatively() so that we can log both states before committing, and
 	// provide protection from panics.
 	ns.node.Speculatively(func(n *state.Node) (commit bool) {
@@ -163,27 +192,90 @@ func (s *PluginState) updatePod(
 				zap.Object("OldNode", ns.node),
 				zap.Object("Node", n),
 			)
+			if placedAt, ok := pod.Annotations[api.AnnotationFallbackScheduled]; ok {
+				logger.Warn(
+					"Pod was placed by the autoscaler-agent while the scheduler was unavailable",
+					zap.String("PlacedAt", placedAt),
+				)
+			}
 		}
 
 		// Commit the changes so far, then keep going.
 		return true
 	})
 
+	if cfg.storePodLabels() {
+		s.podLabels[pod.UID] = pod.Labels
+	}
+	if maxResources := podMaxResources(pod); maxResources != nil {
+		s.podMaxResources[pod.UID] = *maxResources
+	} else {
+		delete(s.podMaxResources, pod.UID)
+	}
+
 	// At this point, our local state has been updated according to the Pod object from k8s.
 	//
 	// All that's left is to handle VMs that are the responsibility of *this* scheduler.
-	if lo.IsEmpty(newPod.VirtualMachine) || pod.Spec.SchedulerName != s.config.SchedulerName {
+	if lo.IsEmpty(newPod.VirtualMachine) || pod.Spec.SchedulerName != cfg.SchedulerName {
 		return nil, nil
 	}
 
-	if _, ok := ns.requestedMigrations[newPod.UID]; ok {
+	if requestedAt, ok := ns.requestedMigrations[newPod.UID]; ok {
 		// If the pod is already migrating, remove it from requestedMigrations.
 		if newPod.Migrating {
 			delete(ns.requestedMigrations, newPod.UID)
+			delete(ns.startingMigrations, newPod.UID)
+			delete(s.shadowReportedMigrations, newPod.UID)
 		} else if !newPod.Migratable {
 			logger.Warn("Canceling previously wanted migration because Pod is not migratable")
 			delete(ns.requestedMigrations, newPod.UID)
+			delete(ns.startingMigrations, newPod.UID)
+			delete(s.shadowReportedMigrations, newPod.UID)
+		} else if cfg.ShadowMode {
+			// In shadow mode, the migration is left to the real scheduler -- we just check whether
+			// it happens.
+			s.checkShadowMigration(logger, newPod, requestedAt)
+			return &podUpdateResult{
+				needsMoreResources: false,
+				afterUnlock:        nil,
+				retryAfter:         lo.ToPtr(5 * time.Second),
+			}, nil
+		} else if window := s.blackoutWindowForMigration(ns, newPod.UID); window != "" {
+			// During a blackout window, requested migrations that haven't been created yet are
+			// canceled. If the node is still above the watermark afterwards, they'll be requested
+			// again once the node is next balanced.
+			logger.Warn("Canceling requested migration for Pod during migration blackout window", zap.String("Window", window))
+			s.metrics.MigrationsSuppressedByBlackout.WithLabelValues(window).Inc()
+			delete(ns.requestedMigrations, newPod.UID)
+		} else if recheck, deferring := s.shouldDeferMigration(pod, newPod, requestedAt, time.Now()); deferring {
+			logger.Info("Deferring migration for Pod because its VM is busy", zap.Time("RequestedAt", requestedAt))
+			return &podUpdateResult{
+				needsMoreResources: false,
+				afterUnlock:        nil,
+				retryAfter:         &recheck,
+			}, nil
+		} else if reason, recheck := s.checkMigrationLimitsIfNotStarted(ns, newPod.UID); reason != "" {
+			logger.Info(
+				"Deferring migration for Pod because of migration limits",
+				zap.String("Reason", reason),
+				zap.Duration("retryAfter", recheck),
+			)
+			s.metrics.MigrationsDeferred.WithLabelValues(reason).Inc()
+			return &podUpdateResult{
+				needsMoreResources: false,
+				afterUnlock:        nil,
+				retryAfter:         &recheck,
+			}, nil
+		} else if cfg.DryRun {
+			// In dry-run mode, the migration is only logged. Forget about it afterwards, so that we
+			// don't log it again every time the pod is reconciled -- if the node is still above the
+			// watermark, it'll be requested again next time the node is balanced.
+			if err := s.createMigrationForPod(logger, newPod); err != nil {
+				return nil, fmt.Errorf("could not create migration for Pod: %w", err)
+			}
+			delete(ns.requestedMigrations, newPod.UID)
 		} else {
+			ns.startingMigrations[newPod.UID] = struct{}{}
 			// Otherwise: the pod is not migrating, but *is* migratable. Let's trigger migration.
 			logger.Info("Creating migration for Pod")
 			return &podUpdateResult{
@@ -202,8 +294,9 @@ func (s *PluginState) updatePod(
 		}
 	}
 
-	if !newPod.Migrating {
-		return s.reconcilePodResources(logger, ns, pod, newPod), nil
+	// In shadow mode, the resources approved for each pod are set by the real scheduler.
+	if !newPod.Migrating && !cfg.ShadowMode {
+		return s.reconcilePodResources(logger, cfg, ns, pod, newPod, fromHandoff), nil
 	}
 
 	return nil, nil
@@ -231,10 +324,12 @@ func (s *PluginState) createMigrationForPod(logger *zap.Logger, pod state.Pod) e
 
 func (s *PluginState) reconcilePodResources(
 	logger *zap.Logger,
+	cfg *Config,
 	ns *nodeState,
 	oldPodObj *corev1.Pod,
 	oldPod state.Pod,
-) *podUpdateResult {
+	fromHandoff bool,
+) (result *podUpdateResult) {
 	// Quick check: Does this pod have autoscaling enabled? if no, then we shouldn't set our
 	// annotations on it -- particularly because we may end up with stale approved resources when
 	// the VM scales, and that can cause issues if autoscaling is enabled later.
@@ -254,7 +349,16 @@ func (s *PluginState) reconcilePodResources(
 		return false
 	})
 
+	// If there isn't enough room on the node for all of the upscaling that's been asked for, only
+	// give the pod's tenant its fair share.
+	if s.applyTenantFairness(logger, ns, oldPod, &desiredPod) {
+		needsMoreResources = true
+	}
+
+	// If the reservation was raised from the previous scheduler instance's state, the VM's approved
+	// resources annotation is out of date, even if it's present.
 	_, hasApprovedAnnotation := oldPodObj.Annotations[api.InternalAnnotationResourcesApproved]
+	approvedUpToDate := hasApprovedAnnotation && !fromHandoff
 
 	// At this point, desiredPod has the updated state of the pod that *would* be the case if we
 	// fully reconcile it.
@@ -285,7 +389,30 @@ func (s *PluginState) reconcilePodResources(
 		// don't report anything, even if needsMoreResources. We're waiting for startup to finish!
 		return nil
 	}
-	if oldPod == desiredPod && hasApprovedAnnotation {
+
+	// Standby replicas leave approving resources to the leader. The pod is requeued when we become
+	// the leader, same as after startup.
+	if !s.leading.Load() {
+		s.requeueAfterStartup[oldPod.UID] = struct{}{}
+		return nil
+	}
+
+	// If there's upscaling reserved for the pod that the autoscaler-agent hasn't confirmed in time,
+	// release it. Otherwise, make sure we check again once it expires.
+	if pending, err := s.pendingReservationForPod(oldPodObj, oldPod); err != nil {
+		logger.Error("Failed to check for unconfirmed upscaling reserved for Pod", zap.Error(err))
+	} else if pending != nil {
+		if !time.Now().Before(pending.expiresAt) {
+			return s.releaseReservation(logger, ns, oldPodObj, oldPod, *pending)
+		}
+		defer func() {
+			wait := time.Until(pending.expiresAt)
+			if result != nil && (result.retryAfter == nil || *result.retryAfter > wait) {
+				result.retryAfter = &wait
+			}
+		}()
+	}
+	if oldPod == desiredPod && approvedUpToDate {
 		// no changes, nothing to do. Although, if we *do* need more resources, log something about
 		// it so we're not failing silently.
 		if needsMoreResources {
@@ -302,6 +429,26 @@ func (s *PluginState) reconcilePodResources(
 		}
 	}
 
+	// If the pod's approved resources annotation is only out of date (e.g. because it was set by
+	// the previous scheduler instance), updating it doesn't change anything about the resources
+	// reserved for the pod, so it can wait while the API server is degraded.
+	//
+	// Pods *without* the annotation can't wait: the autoscaler-agent's first request for the VM
+	// waits for it to be set, so deferring it would stall scaling exactly when it's most needed.
+	if oldPod == desiredPod && hasApprovedAnnotation && s.apiHealth.Degraded() {
+		s.metrics.DeferredWrites.WithLabelValues("approved-annotation").Inc()
+		retryAfter := time.Second * time.Duration(cfg.APIHealth.RecoverySeconds)
+		logger.Info(
+			"Deferring adding approved resources annotation to VirtualMachine because API server is degraded",
+			zap.Duration("retryAfter", retryAfter),
+		)
+		return &podUpdateResult{
+			needsMoreResources: needsMoreResources,
+			afterUnlock:        nil,
+			retryAfter:         &retryAfter,
+		}
+	}
+
 	// Startup done. Either we have changes or the pod is missing the approved resources annotation.
 	//
 	// If it hasn't been too soon since the last patch:
@@ -313,7 +460,7 @@ func (s *PluginState) reconcilePodResources(
 
 	canRetryAt := now
 	if previouslyPatched {
-		canRetryAt = lastPatch.Add(time.Second * time.Duration(s.config.PatchRetryWaitSeconds))
+		canRetryAt = lastPatch.Add(time.Second * time.Duration(cfg.PatchRetryWaitSeconds))
 	}
 
 	if now.Before(canRetryAt) {
@@ -329,9 +476,59 @@ func (s *PluginState) reconcilePodResources(
 		}
 	}
 
+	// If we're granting more resources, only grant as much as the cluster-wide upscale rate limit
+	// allows (if there is one).
+	//
+	// Any part of the increase that was already approved within the pod's preapproval has already
+	// been taken from the limit -- see approveWithinPreapproval.
+	cpuIncrease := util.SaturatingSub(desiredPod.CPU.Reserved, oldPod.CPU.Reserved)
+	memIncrease := util.SaturatingSub(desiredPod.Mem.Reserved, oldPod.Mem.Reserved)
+	charged := s.preapprovalCharged[oldPod.UID]
+	cpuCharged := util.SaturatingSub(min(desiredPod.CPU.Reserved, charged.VCPU), oldPod.CPU.Reserved)
+	memCharged := util.SaturatingSub(min(desiredPod.Mem.Reserved, charged.Mem), oldPod.Mem.Reserved)
+	cpuGranted, memGranted, limitWait := s.upscaleLimiter.take(
+		now,
+		cpuIncrease-cpuCharged, desiredPod.CPU.Factor,
+		memIncrease-memCharged, desiredPod.Mem.Factor,
+	)
+	cpuGranted += cpuCharged
+	memGranted += memCharged
+	if cpuGranted != cpuIncrease || memGranted != memIncrease {
+		s.metrics.UpscaleRateLimited.Inc()
+		if cpuGranted == 0 && memGranted == 0 {
+			logger.Warn(
+				"Want to grant upscaling for Pod, but cluster-wide upscale rate limit reached. Waiting.",
+				zap.Object("Pod", oldPod),
+				zap.Object("DesiredPod", desiredPod),
+				zap.Duration("retryAfter", limitWait),
+			)
+			return &podUpdateResult{
+				needsMoreResources: needsMoreResources,
+				afterUnlock:        nil,
+				retryAfter:         &limitWait,
+			}
+		}
+
+		logger.Warn(
+			"Only granting part of upscaling for Pod because of cluster-wide upscale rate limit",
+			zap.Object("Pod", oldPod),
+			zap.Object("DesiredPod", desiredPod),
+			zap.Duration("retryAfter", limitWait),
+		)
+		desiredPod.CPU.Reserved = oldPod.CPU.Reserved + cpuGranted
+		desiredPod.Mem.Reserved = oldPod.Mem.Reserved + memGranted
+		cpuIncrease, memIncrease = cpuGranted, memGranted
+		needsMoreResources = true
+	}
+	if desiredPod.CPU.Reserved >= charged.VCPU && desiredPod.Mem.Reserved >= charged.Mem {
+		delete(s.preapprovalCharged, oldPod.UID)
+	}
+
 	newPod := desiredPod
 	newPod.CPU.Reserved = max(desiredPod.CPU.Reserved, oldPod.CPU.Reserved)
 	newPod.Mem.Reserved = max(desiredPod.Mem.Reserved, oldPod.Mem.Reserved)
+	newPod.CPU.Preapproved = max(desiredPod.CPU.Preapproved, oldPod.CPU.Preapproved)
+	newPod.Mem.Preapproved = max(desiredPod.Mem.Preapproved, oldPod.Mem.Preapproved)
 
 	if newPod == oldPod {
 		if oldPod != desiredPod {
@@ -341,6 +538,11 @@ func (s *PluginState) reconcilePodResources(
 				zap.Object("DesiredPod", desiredPod),
 				zap.Object("Node", ns.node),
 			)
+		} else if fromHandoff {
+			logger.Info(
+				"Pod has reserved resources from previous scheduler instance, patching VirtualMachine",
+				zap.Object("Pod", oldPod),
+			)
 		} else /* implies !hasApprovedAnnotation */ {
 			logger.Info(
 				"Pod is missing approved resources annotation, patching VirtualMachine",
@@ -364,13 +566,28 @@ func (s *PluginState) reconcilePodResources(
 	}
 
 	ns.podsVMPatchedAt[oldPod.UID] = now
+	s.forgetHandoffPod(oldPod.UID)
+
+	// If we're granting more resources to a VM whose autoscaler-agent can confirm them, they're
+	// only reserved until it does. Check again once the reservation expires.
+	var reservationExpiresAt *time.Time
+	var retryAfter *time.Duration
+	_, canConfirm := oldPodObj.Annotations[api.InternalAnnotationResourcesConfirmed]
+	if ttl := s.reservationTTL(); ttl != nil && canConfirm && (cpuIncrease != 0 || memIncrease != 0) {
+		reservationExpiresAt = lo.ToPtr(now.Add(*ttl))
+		retryAfter = ttl
+	}
+	// If only part of the upscaling was granted, come back for the rest once the limit allows.
+	if limitWait != 0 && (retryAfter == nil || *retryAfter > limitWait) {
+		retryAfter = &limitWait
+	}
 
 	return &podUpdateResult{
 		needsMoreResources: needsMoreResources,
 		afterUnlock: func() error {
-			return s.patchReservedResourcesForPod(logger, oldPodObj, desiredPod)
+			return s.patchReservedResourcesForPod(logger, oldPodObj, desiredPod, reservationExpiresAt)
 		},
-		retryAfter: nil,
+		retryAfter: retryAfter,
 	}
 }
 
@@ -378,6 +595,7 @@ func (s *PluginState) patchReservedResourcesForPod(
 	logger *zap.Logger,
 	oldPodObj *corev1.Pod,
 	newPod state.Pod,
+	reservationExpiresAt *time.Time,
 ) error {
 	// Broadly, the idea with the patch is that we only want to update the reserved resources if the
 	// resources that were requested are still current.
@@ -416,6 +634,17 @@ func (s *PluginState) patchReservedResourcesForPod(
 			Value: requestedJSON,
 		})
 	}
+	preapprovalJSON, hasPreapproval := oldPodObj.Annotations[api.InternalAnnotationPreapprovalRequested]
+	if hasPreapproval {
+		patches = append(patches, patch.Operation{
+			Op: patch.OpTest,
+			Path: fmt.Sprintf(
+				"/metadata/annotations/%s",
+				patch.PathEscape(api.InternalAnnotationPreapprovalRequested),
+			),
+			Value: preapprovalJSON,
+		})
+	}
 
 	// ... and then if so, set the approved resources appropriately:
 	reservedJSON := marshalJSON(api.Resources{
@@ -435,6 +664,36 @@ func (s *PluginState) patchReservedResourcesForPod(
 
 	hasKnownAnnotations := len(patches) > 1 || hasApprovedAnnotation
 
+	// Only set the preapproved resources if they were asked for. This must happen after we've
+	// checked for known annotations, because it doesn't exist on its own.
+	if hasPreapproval {
+		patches = append(patches, patch.Operation{
+			Op: patch.OpReplace,
+			Path: fmt.Sprintf(
+				"/metadata/annotations/%s",
+				patch.PathEscape(api.InternalAnnotationResourcesPreapproved),
+			),
+			Value: marshalJSON(api.Resources{
+				VCPU: newPod.CPU.Preapproved,
+				Mem:  newPod.Mem.Preapproved,
+			}),
+		})
+	}
+
+	// Only set when the reservation expires if we're reserving new upscaling that can be confirmed.
+	// This also requires known annotations to exist, because it's only possible once the
+	// autoscaler-agent has sent us the resources it's confirmed.
+	if reservationExpiresAt != nil {
+		patches = append(patches, patch.Operation{
+			Op: patch.OpAdd,
+			Path: fmt.Sprintf(
+				"/metadata/annotations/%s",
+				patch.PathEscape(api.InternalAnnotationReservationExpiresAt),
+			),
+			Value: marshalJSON(*reservationExpiresAt),
+		})
+	}
+
 	// If there's no other known annotations at this point, it's possible that the VM's annotations
 	// are completely empty. If so, any operations to add an annotation will fail because the
 	// 'annotations' field doesn't exist!
@@ -482,7 +741,7 @@ func (s *PluginState) patchReservedResourcesForPod(
 	if err != nil {
 		if apierrors.IsInvalid(err) {
 			logger.Warn("Failed to patch VirtualMachine because preconditions failed", zap.Any("patches", patches), zap.Error(err))
-			return errors.New("local pod state doesn't match most recent VM state")
+			return fmt.Errorf("local pod state doesn't match most recent VM state: %w", err)
 		} else {
 			logger.Error("Failed to patch VirtualMachine", zap.Any("patches", patches), zap.Error(err))
 			return err
@@ -496,6 +755,9 @@ func (s *PluginState) deletePod(logger *zap.Logger, pod *corev1.Pod, expectExist
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	delete(s.schedulingFailures, pod.UID)
+	s.forgetHandoffPod(pod.UID)
+
 	nodeName := pod.Spec.NodeName
 	if nodeName == "" {
 		var ok bool
@@ -525,8 +787,18 @@ func (s *PluginState) deletePod(logger *zap.Logger, pod *corev1.Pod, expectExist
 	}
 
 	// Clear any extra state for this pod
+	delete(s.systemPods, util.GetNamespacedName(pod))
+	delete(s.systemPodUsage, util.GetNamespacedName(pod))
+	delete(s.podUsage, pod.UID)
+	delete(s.podUsageAtRequeue, pod.UID)
+	delete(s.podLabels, pod.UID)
+	delete(s.podMaxResources, pod.UID)
+	delete(s.preapprovalCharged, pod.UID)
 	delete(ns.requestedMigrations, pod.UID)
+	delete(ns.startingMigrations, pod.UID)
 	delete(ns.podsVMPatchedAt, pod.UID)
+	delete(ns.pressureCaps, pod.UID)
+	delete(s.shadowReportedMigrations, pod.UID)
 	if exists {
 		// ... and run the actual removal in Speculatively() so we can log the before/after in a single
 		// line, and for panic safety.
@@ -562,6 +834,7 @@ func (s *PluginState) deletePod(logger *zap.Logger, pod *corev1.Pod, expectExist
 			)
 		}
 		delete(s.tentativelyScheduled, pod.UID)
+		delete(s.tentativelyScheduledAt, pod.UID)
 	}
 
 	return nil
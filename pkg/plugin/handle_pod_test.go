@@ -0,0 +1,49 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+// The approved resources annotation is required for the autoscaler-agent's first request for a
+// VM, so it must not be deferred while the API server is degraded.
+func TestApprovedAnnotationWhileAPIDegraded(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	cluster := defaultBenchmarkClusterConfig(1, 2)
+	c, err := newBenchmarkCluster(config, cluster)
+	require.NoError(t, err)
+	s := c.enforcer.state
+
+	s.apiHealth = util.NewAPIHealth(&util.APIHealthConfig{
+		WindowSeconds:      60,
+		MinRequests:        1,
+		BadRequestFraction: 0.5,
+		SlowRequestMillis:  1000,
+		RecoverySeconds:    60,
+	}, nil)
+	s.apiHealth.Observe(2*time.Second, nil)
+	require.True(t, s.apiHealth.Degraded())
+
+	var patched []util.NamespacedName
+	s.patchVM = func(vm util.NamespacedName, _ []patch.Operation) error {
+		patched = append(patched, vm)
+		return nil
+	}
+
+	pod, err := benchmarkVMPod(config, "vm-new", "node-0", cluster, false)
+	require.NoError(t, err)
+	_, err = s.HandlePodEvent(zap.NewNop(), reconcile.EventKindAdded, pod)
+	require.NoError(t, err)
+
+	assert.Equal(t, []util.NamespacedName{{Namespace: pod.Namespace, Name: pod.Name}}, patched)
+	assert.Equal(t, 0.0, testutil.ToFloat64(s.metrics.DeferredWrites.WithLabelValues("approved-annotation")))
+}
@@ -34,6 +34,18 @@ func (s *PluginState) HandleMigrationEvent(
 		// Migration was deleted. Nothing to do.
 		return nil
 	case reconcile.EventKindAdded, reconcile.EventKindModified:
+		if s.config().ShadowMode {
+			// Cleaning up migrations is left to the real scheduler.
+			if _, ok := vmm.Labels[LabelPluginCreatedMigration]; ok && kind == reconcile.EventKindAdded {
+				s.compareShadowMigration(logger, vmm)
+			}
+			return nil
+		}
+		if !s.leading.Load() {
+			// Cleaning up migrations is left to the leader. All migrations are requeued when we
+			// become the leader.
+			return nil
+		}
 		return s.deleteMigrationIfNeeded(logger, vmm)
 	default:
 		panic("unreachable")
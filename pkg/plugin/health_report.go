@@ -0,0 +1,193 @@
+package plugin
+
+// Periodically writing a summary of the fleet's health to a ConfigMap, if enabled.
+//
+// The same information is available from the plugin's metrics (and others'), but a single object
+// is much easier for operators and external monitors to watch.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// runHealthReporter periodically writes the fleet health report to the configured ConfigMap, until
+// the context is canceled.
+func (s *PluginState) runHealthReporter(
+	ctx context.Context,
+	logger *zap.Logger,
+	config HealthReportConfig,
+	client corev1client.ConfigMapsGetter,
+) {
+	interval := time.Second * time.Duration(config.IntervalSeconds)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.leading.Load() {
+			continue // only the leader writes the report
+		}
+
+		report, ok := s.fleetHealth(time.Now(), config)
+		if !ok {
+			logger.Info("Skipping health report because startup is not done yet")
+			continue
+		}
+
+		if err := s.writeHealthReport(ctx, config, client, report); err != nil {
+			logger.Error("Failed to write health report", zap.Error(err))
+			s.metrics.HealthReportWrites.WithLabelValues(fmt.Sprintf("error: %s", util.RootError(err))).Inc()
+		} else {
+			s.metrics.HealthReportWrites.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+// fleetHealth returns the current health report, or false if the plugin hasn't finished startup
+// (and so the report may be incomplete).
+func (s *PluginState) fleetHealth(now time.Time, config HealthReportConfig) (_ api.FleetHealth, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := api.FleetHealth{
+		Timestamp:           now,
+		Nodes:               len(s.nodes),
+		NodesAboveWatermark: 0,
+		VMs:                 0,
+		VMsFailingToScale:   0,
+		StuckMigrations:     0,
+		FailingReconciles:   make(map[string]int),
+	}
+	for kind, count := range s.failingReconciles {
+		if count != 0 {
+			report.FailingReconciles[kind] = count
+		}
+	}
+
+	if !s.startupDone {
+		return report, false
+	}
+
+	for _, ns := range s.nodes {
+		cpu, mem := ns.node.CPU, ns.node.Mem
+		if cpu.Reserved > cpu.Watermark || mem.Reserved > mem.Watermark {
+			report.NodesAboveWatermark += 1
+		}
+
+		for _, pod := range ns.node.Pods() {
+			if lo.IsEmpty(pod.VirtualMachine) {
+				continue
+			}
+			report.VMs += 1
+			if pod.CPU.Requested > pod.CPU.Reserved || pod.Mem.Requested > pod.Mem.Reserved {
+				report.VMsFailingToScale += 1
+			}
+		}
+	}
+
+	if s.listMigrations != nil {
+		stuckAfter := time.Second * time.Duration(config.StuckMigrationSeconds)
+		for _, vmm := range s.listMigrations() {
+			if migrationStuck(vmm, now, stuckAfter) {
+				report.StuckMigrations += 1
+			}
+		}
+	}
+
+	return report, true
+}
+
+// migrationStuck returns whether the migration is unfinished, more than stuckAfter after it was
+// created.
+func migrationStuck(vmm *vmv1.VirtualMachineMigration, now time.Time, stuckAfter time.Duration) bool {
+	switch vmm.Status.Phase {
+	case vmv1.VmmSucceeded, vmv1.VmmFailed:
+		return false
+	default:
+		return now.Sub(vmm.CreationTimestamp.Time) > stuckAfter
+	}
+}
+
+// writeHealthReport updates the ConfigMap with the report, creating it if it doesn't exist.
+func (s *PluginState) writeHealthReport(
+	ctx context.Context,
+	config HealthReportConfig,
+	client corev1client.ConfigMapsGetter,
+	report api.FleetHealth,
+) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("Error encoding report JSON: %w", err)
+	}
+
+	return s.writeConfigMapData(ctx, config.Namespace, config.Name, client, api.FleetHealthConfigMapKey, data)
+}
+
+// writeConfigMapData sets the key in the ConfigMap's data, creating the ConfigMap if it doesn't
+// exist. Other keys are left as-is.
+func (s *PluginState) writeConfigMapData(
+	ctx context.Context,
+	namespace string,
+	name string,
+	client corev1client.ConfigMapsGetter,
+	key string,
+	data []byte,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(s.config().K8sCRUDTimeoutSeconds))
+	defer cancel()
+
+	configMaps := client.ConfigMaps(namespace)
+
+	start := time.Now()
+	cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	s.apiHealth.Observe(time.Since(start), err)
+	s.metrics.RecordK8sOp("Get", "ConfigMap", name, err)
+
+	if err != nil && apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{ //nolint:exhaustruct // only setting the fields we need
+			ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // only setting the fields we need
+				Name:      name,
+				Namespace: namespace,
+			},
+			Data: map[string]string{key: string(data)},
+		}
+
+		start = time.Now()
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		s.apiHealth.Observe(time.Since(start), err)
+		s.metrics.RecordK8sOp("Create", "ConfigMap", name, err)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[key] = string(data)
+
+	start = time.Now()
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	s.apiHealth.Observe(time.Since(start), err)
+	s.metrics.RecordK8sOp("Update", "ConfigMap", name, err)
+	return err
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+func TestFleetHealth(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	healthConfig := HealthReportConfig{
+		Namespace:             "kube-system",
+		Name:                  "autoscaling-health",
+		IntervalSeconds:       30,
+		StuckMigrationSeconds: 600,
+	}
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+
+	nextID := 0
+	vmPod := func(cpuRequested, cpuReserved vmv1.MilliCPU) state.Pod {
+		nextID += 1
+		name := util.NamespacedName{Namespace: "default", Name: fmt.Sprintf("vm-%d", nextID)}
+		return state.Pod{
+			NamespacedName:    name,
+			UID:               types.UID(fmt.Sprintf("pod-uid-%d", nextID)),
+			CreatedAt:         lo.Empty[time.Time](),
+			VirtualMachine:    name,
+			Migratable:        true,
+			AlwaysMigrate:     false,
+			DisruptionGroup:   "",
+			Migrating:         false,
+			NetworkBandwidth:  0,
+			ExtendedResources: state.ExtendedResources{},
+			WarmPool:          false,
+			Priority:          0,
+			MigrationCost:     0,
+			CPU: state.PodResources[vmv1.MilliCPU]{
+				Reserved:             cpuReserved,
+				Requested:            cpuRequested,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               0,
+				Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			},
+			Mem: state.PodResources[api.Bytes]{
+				Reserved:             0,
+				Requested:            0,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               0,
+				Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			},
+		}
+	}
+
+	// node-1 is above its watermark, with one VM that can't scale up. node-2 is fine.
+	node1 := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, 0.5, nil)
+	node1.AddPod(vmPod(4000, 4000))
+	node1.AddPod(vmPod(3000, 2000))
+	node2 := state.NodeStateFromParams("node-2", 10000, 40*1024*1024*1024, 0.5, nil)
+	node2.AddPod(vmPod(1000, 1000))
+	s.nodes["node-1"] = &nodeState{node: node1} //nolint:exhaustruct // only need the node for this test
+	s.nodes["node-2"] = &nodeState{node: node2} //nolint:exhaustruct // only need the node for this test
+
+	now := time.Now()
+	migration := func(phase vmv1.VmmPhase, age time.Duration) *vmv1.VirtualMachineMigration {
+		return &vmv1.VirtualMachineMigration{ //nolint:exhaustruct // only need phase and creation time
+			ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // only need creation time
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Status: vmv1.VirtualMachineMigrationStatus{ //nolint:exhaustruct // only need phase
+				Phase: phase,
+			},
+		}
+	}
+	s.listMigrations = func() []*vmv1.VirtualMachineMigration {
+		return []*vmv1.VirtualMachineMigration{
+			migration(vmv1.VmmRunning, time.Hour),     // stuck
+			migration(vmv1.VmmPending, time.Minute),   // not stuck yet
+			migration(vmv1.VmmSucceeded, time.Hour),   // finished
+			migration(vmv1.VmmFailed, time.Hour),      // finished
+			migration(vmv1.VmmPhase(""), 2*time.Hour), // stuck before it started
+		}
+	}
+	s.failingReconciles["Pod"] = 2
+	s.failingReconciles["Node"] = 0
+
+	// Before startup is done, the report may be incomplete
+	_, ok := s.fleetHealth(now, healthConfig)
+	assert.False(t, ok)
+
+	s.startupDone = true
+	report, ok := s.fleetHealth(now, healthConfig)
+	assert.True(t, ok)
+	assert.Equal(t, api.FleetHealth{
+		Timestamp:           now,
+		Nodes:               2,
+		NodesAboveWatermark: 1,
+		VMs:                 3,
+		VMsFailingToScale:   1,
+		StuckMigrations:     2,
+		FailingReconciles:   map[string]int{"Pod": 2},
+	}, report)
+}
@@ -0,0 +1,80 @@
+package plugin
+
+// Ignoring pods by their namespace, as configured by (Config).IgnoredNamespaces and
+// (Config).IgnoredNamespaceSelector.
+//
+// Namespaces can start or stop being ignored while the scheduler is running -- either from
+// reloading the config, or because a namespace's labels changed. When that happens because of the
+// labels, the pods in the namespace are requeued so that they're added to or removed from the local
+// state. Otherwise, pods are only added or removed on their next event.
+
+import (
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/util/watch"
+)
+
+// ignoredNamespace returns whether pods in the namespace should be ignored, either because of its
+// name or its labels.
+func (s *PluginState) ignoredNamespace(namespace string) bool {
+	config := s.config()
+	if config.ignoredNamespaceName(namespace) {
+		return true
+	}
+
+	if len(config.IgnoredNamespaceSelector) == 0 || s.namespaceLabels == nil {
+		return false
+	}
+	labels, ok := s.namespaceLabels(namespace)
+	return ok && selectorMatches(config.IgnoredNamespaceSelector, labels)
+}
+
+// podInLocalState returns whether the pod is in the local state, either on a node or tentatively
+// scheduled.
+func (s *PluginState) podInLocalState(uid types.UID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, tentative := s.tentativelyScheduled[uid]
+	return tentative || s.podExists(uid)
+}
+
+// ignoredNamespaceHandlers returns the handlers for Namespace events that requeue the pods in a
+// namespace when its labels change whether it matches the config's IgnoredNamespaceSelector.
+//
+// The selector can't be reloaded, so it's taken from the config at startup.
+func ignoredNamespaceHandlers(
+	logger *zap.Logger,
+	config *Config,
+	podStore *watch.Store[corev1.Pod],
+) watch.HandlerFuncs[*corev1.Namespace] {
+	if len(config.IgnoredNamespaceSelector) == 0 {
+		return watch.HandlerFuncs[*corev1.Namespace]{}
+	}
+
+	return watch.HandlerFuncs[*corev1.Namespace]{
+		UpdateFunc: func(oldNamespace, newNamespace *corev1.Namespace) {
+			wasIgnored := selectorMatches(config.IgnoredNamespaceSelector, oldNamespace.Labels)
+			isIgnored := selectorMatches(config.IgnoredNamespaceSelector, newNamespace.Labels)
+			if wasIgnored == isIgnored {
+				return
+			}
+
+			var count int
+			for _, pod := range podStore.Items() {
+				if pod.Namespace == newNamespace.Name && podStore.NopUpdate(pod.UID) {
+					count += 1
+				}
+			}
+			logger.Info(
+				"Requeued Pods in Namespace after it changed whether it's ignored",
+				zap.String("Namespace", newNamespace.Name),
+				zap.Bool("Ignored", isIgnored),
+				zap.Int("Pods", count),
+			)
+		},
+	}
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+)
+
+func TestIgnoredNamespaces(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.IgnoredNamespaces = []string{"overprovisioning", "overprovisioning-*"}
+	config.IgnoredNamespaceSelector = map[string]string{"example.com/placeholder": "true"}
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	namespaces := map[string]map[string]string{
+		"labeled":   {"example.com/placeholder": "true"},
+		"unlabeled": {"example.com/placeholder": "false"},
+	}
+	s.namespaceLabels = func(namespace string) (map[string]string, bool) {
+		labels, ok := namespaces[namespace]
+		return labels, ok
+	}
+
+	assert.True(t, s.ignoredNamespace("overprovisioning"))
+	assert.True(t, s.ignoredNamespace("overprovisioning-us-east-1a"))
+	assert.False(t, s.ignoredNamespace("overprovisioningx"))
+	assert.True(t, s.ignoredNamespace("labeled"))
+	assert.False(t, s.ignoredNamespace("unlabeled"))
+	assert.False(t, s.ignoredNamespace("unknown"))
+
+	// Label changes apply immediately
+	namespaces["unlabeled"] = map[string]string{"example.com/placeholder": "true"}
+	assert.True(t, s.ignoredNamespace("unlabeled"))
+
+	config.IgnoredNamespaces = []string{"overprovisioning-["}
+	path, err := config.validate()
+	assert.Error(t, err)
+	assert.Equal(t, "ignoredNamespaces[0]", path)
+}
@@ -3,11 +3,16 @@ package initevents
 // Reconcile middleware to allow us to know when all of a set of events have been handled.
 
 import (
+	"cmp"
+	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 
 	"go.uber.org/zap"
 
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
 	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
 )
 
@@ -26,6 +31,10 @@ type InitEventsMiddleware struct {
 	mu         sync.Mutex
 	doneAdding bool
 	remaining  map[reconcile.Key]struct{}
+	// total is the number of required objects of each kind
+	total map[schema.GroupVersionKind]int
+	// failures is the number of failed attempts to reconcile each of the remaining objects
+	failures map[reconcile.Key]int
 }
 
 func NewInitEventsMiddleware() *InitEventsMiddleware {
@@ -35,6 +44,8 @@ func NewInitEventsMiddleware() *InitEventsMiddleware {
 		mu:         sync.Mutex{},
 		doneAdding: false,
 		remaining:  make(map[reconcile.Key]struct{}),
+		total:      make(map[schema.GroupVersionKind]int),
+		failures:   make(map[reconcile.Key]int),
 	}
 }
 
@@ -48,6 +59,8 @@ func (m *InitEventsMiddleware) Call(
 
 	if err == nil {
 		m.success(params.Key())
+	} else {
+		m.failure(params.Key())
 	}
 
 	return result, err
@@ -69,7 +82,10 @@ func (m *InitEventsMiddleware) AddRequired(obj reconcile.Object) {
 		GVK: obj.GetObjectKind().GroupVersionKind(),
 		UID: obj.GetUID(),
 	}
-	m.remaining[k] = struct{}{}
+	if _, ok := m.remaining[k]; !ok {
+		m.remaining[k] = struct{}{}
+		m.total[k.GVK] += 1
+	}
 }
 
 // Done returns a channel that will be closed when all of the required objects have been
@@ -96,6 +112,49 @@ func (m *InitEventsMiddleware) Remaining() []reconcile.Key {
 	return keys
 }
 
+// KindProgress is the progress towards reconciling all the required objects of a particular kind.
+type KindProgress struct {
+	Kind      schema.GroupVersionKind
+	Total     int
+	Remaining int
+	// Failures is the number of failed attempts to reconcile the remaining objects.
+	Failures int
+}
+
+// String implements fmt.Stringer.
+func (p KindProgress) String() string {
+	return fmt.Sprintf("%s: %d of %d remaining, %d failed attempts", p.Kind.Kind, p.Remaining, p.Total, p.Failures)
+}
+
+// Progress returns the progress for each kind of required object, slowest first -- i.e., with
+// the smallest fraction of its objects reconciled.
+func (m *InitEventsMiddleware) Progress() []KindProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byKind := make(map[schema.GroupVersionKind]*KindProgress)
+	for gvk, total := range m.total {
+		byKind[gvk] = &KindProgress{Kind: gvk, Total: total, Remaining: 0, Failures: 0}
+	}
+	for k := range m.remaining {
+		byKind[k.GVK].Remaining += 1
+		byKind[k.GVK].Failures += m.failures[k]
+	}
+
+	var progress []KindProgress
+	for _, p := range byKind {
+		progress = append(progress, *p)
+	}
+	slices.SortFunc(progress, func(x, y KindProgress) int {
+		// Compare x.Remaining/x.Total to y.Remaining/y.Total, in reverse, without division.
+		if c := cmp.Compare(y.Remaining*x.Total, x.Remaining*y.Total); c != 0 {
+			return c
+		}
+		return cmp.Compare(x.Kind.String(), y.Kind.String())
+	})
+	return progress
+}
+
 // helper function for when reconciling is successful
 func (m *InitEventsMiddleware) success(k reconcile.Key) {
 	// fast path: don't do anything if we're already done, avoiding waiting on an extra lock.
@@ -107,9 +166,24 @@ func (m *InitEventsMiddleware) success(k reconcile.Key) {
 	defer m.mu.Unlock()
 
 	delete(m.remaining, k)
+	delete(m.failures, k)
 	m.checkDone()
 }
 
+// helper function for when reconciling fails
+func (m *InitEventsMiddleware) failure(k reconcile.Key) {
+	if m.done.Load() {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.remaining[k]; ok {
+		m.failures[k] += 1
+	}
+}
+
 // NOTE: this method expects that the caller has acquired m.mu.
 func (m *InitEventsMiddleware) checkDone() {
 	// we've already signaled that we're done. Avoid double-closing the channel.
@@ -0,0 +1,195 @@
+package initevents
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+)
+
+var (
+	podGVK  = corev1.SchemeGroupVersion.WithKind("Pod")
+	nodeGVK = corev1.SchemeGroupVersion.WithKind("Node")
+)
+
+func testObject(gvk string, uid string) reconcile.Object {
+	meta := metav1.ObjectMeta{Name: uid, UID: types.UID(uid)} //nolint:exhaustruct // only need the name and UID
+	switch gvk {
+	case "Pod":
+		return &corev1.Pod{ //nolint:exhaustruct // only need the type and object metadata
+			TypeMeta:   metav1.TypeMeta{APIVersion: podGVK.GroupVersion().String(), Kind: podGVK.Kind},
+			ObjectMeta: meta,
+		}
+	case "Node":
+		return &corev1.Node{ //nolint:exhaustruct // only need the type and object metadata
+			TypeMeta:   metav1.TypeMeta{APIVersion: nodeGVK.GroupVersion().String(), Kind: nodeGVK.Kind},
+			ObjectMeta: meta,
+		}
+	default:
+		panic("unknown kind " + gvk)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	type call struct {
+		kind string
+		uid  string
+		fail bool
+	}
+
+	cases := []struct {
+		name     string
+		required []call
+		calls    []call
+
+		expected     []KindProgress
+		expectedDone bool
+	}{
+		{
+			name:         "nothing required",
+			required:     nil,
+			calls:        nil,
+			expected:     nil,
+			expectedDone: true,
+		},
+		{
+			name: "nothing handled",
+			required: []call{
+				{kind: "Pod", uid: "pod-a", fail: false},
+				{kind: "Pod", uid: "pod-b", fail: false},
+				{kind: "Node", uid: "node-a", fail: false},
+			},
+			calls: nil,
+			expected: []KindProgress{
+				{Kind: nodeGVK, Total: 1, Remaining: 1, Failures: 0},
+				{Kind: podGVK, Total: 2, Remaining: 2, Failures: 0},
+			},
+			expectedDone: false,
+		},
+		{
+			name: "slowest kind first",
+			required: []call{
+				{kind: "Pod", uid: "pod-a", fail: false},
+				{kind: "Pod", uid: "pod-b", fail: false},
+				{kind: "Pod", uid: "pod-c", fail: false},
+				{kind: "Node", uid: "node-a", fail: false},
+				{kind: "Node", uid: "node-b", fail: false},
+			},
+			calls: []call{
+				{kind: "Node", uid: "node-a", fail: false},
+				{kind: "Pod", uid: "pod-a", fail: false},
+				{kind: "Pod", uid: "pod-b", fail: false},
+			},
+			expected: []KindProgress{
+				{Kind: nodeGVK, Total: 2, Remaining: 1, Failures: 0},
+				{Kind: podGVK, Total: 3, Remaining: 1, Failures: 0},
+			},
+			expectedDone: false,
+		},
+		{
+			name: "failures are counted until success",
+			required: []call{
+				{kind: "Pod", uid: "pod-a", fail: false},
+				{kind: "Pod", uid: "pod-b", fail: false},
+				{kind: "Node", uid: "node-a", fail: false},
+			},
+			calls: []call{
+				{kind: "Pod", uid: "pod-a", fail: true},
+				{kind: "Pod", uid: "pod-a", fail: true},
+				{kind: "Pod", uid: "pod-b", fail: true},
+				{kind: "Node", uid: "node-a", fail: true},
+				{kind: "Node", uid: "node-a", fail: false},
+			},
+			expected: []KindProgress{
+				{Kind: podGVK, Total: 2, Remaining: 2, Failures: 3},
+				{Kind: nodeGVK, Total: 1, Remaining: 0, Failures: 0},
+			},
+			expectedDone: false,
+		},
+		{
+			name: "objects that aren't required are ignored",
+			required: []call{
+				{kind: "Pod", uid: "pod-a", fail: false},
+				// duplicates are only counted once
+				{kind: "Pod", uid: "pod-a", fail: false},
+			},
+			calls: []call{
+				{kind: "Pod", uid: "pod-other", fail: true},
+				{kind: "Node", uid: "node-other", fail: false},
+			},
+			expected: []KindProgress{
+				{Kind: podGVK, Total: 1, Remaining: 1, Failures: 0},
+			},
+			expectedDone: false,
+		},
+		{
+			name: "all handled",
+			required: []call{
+				{kind: "Pod", uid: "pod-a", fail: false},
+				{kind: "Node", uid: "node-a", fail: false},
+			},
+			calls: []call{
+				{kind: "Pod", uid: "pod-a", fail: true},
+				{kind: "Pod", uid: "pod-a", fail: false},
+				{kind: "Node", uid: "node-a", fail: false},
+			},
+			expected: []KindProgress{
+				{Kind: nodeGVK, Total: 1, Remaining: 0, Failures: 0},
+				{Kind: podGVK, Total: 1, Remaining: 0, Failures: 0},
+			},
+			expectedDone: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := NewInitEventsMiddleware()
+			for _, r := range c.required {
+				m.AddRequired(testObject(r.kind, r.uid))
+			}
+			done := m.Done()
+
+			for _, call := range c.calls {
+				obj := testObject(call.kind, call.uid)
+				params := reconcile.ObjectParams{
+					GVK:       obj.GetObjectKind().GroupVersionKind(),
+					UID:       obj.GetUID(),
+					Name:      obj.GetName(),
+					Namespace: obj.GetNamespace(),
+					EventKind: reconcile.EventKindModified,
+					Obj:       obj,
+				}
+				handlerErr := error(nil)
+				if call.fail {
+					handlerErr = errors.New("failed")
+				}
+				_, err := m.Call(zap.NewNop(), params, func(*zap.Logger, reconcile.ObjectParams) (reconcile.Result, error) {
+					return reconcile.Result{RetryAfter: 0}, handlerErr
+				})
+				require.Equal(t, handlerErr, err)
+			}
+
+			assert.Equal(t, c.expected, m.Progress())
+
+			select {
+			case <-done:
+				assert.True(t, c.expectedDone, "should not be done")
+			default:
+				assert.False(t, c.expectedDone, "should be done")
+			}
+		})
+	}
+}
+
+func TestKindProgressString(t *testing.T) {
+	p := KindProgress{Kind: podGVK, Total: 5, Remaining: 2, Failures: 3}
+	assert.Equal(t, "Pod: 2 of 5 remaining, 3 failed attempts", p.String())
+}
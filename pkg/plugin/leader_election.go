@@ -0,0 +1,235 @@
+package plugin
+
+// Following the scheduler's leader election, as configured by (Config).LeaderElection.
+//
+// kube-scheduler's own leader election makes sure that only one replica makes scheduling decisions,
+// but every replica constructs the plugin -- so they all watch the cluster and keep their state
+// up-to-date, ready to take over. What kube-scheduler doesn't know about is everything else the
+// plugin does: approving resources for autoscaler-agents, patching VMs, triggering migrations, and
+// the various background tasks. Those must only be done by the leader.
+//
+// kube-scheduler doesn't expose whether it's the leader, so we read its Lease instead: the holder's
+// identity is the replica's hostname (i.e., its pod name), followed by a unique suffix. While we're
+// the leader, we set api.LabelSchedulerLeader on our pod, so that autoscaler-agents send their
+// requests to us rather than a standby.
+//
+// While standing by, changes to pods are deferred the same way as during startup, and nodes aren't
+// balanced. Once we become the leader, everything that was deferred is requeued. kube-scheduler
+// exits when it loses the Lease, so there's no need to hand anything back.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// startLeaderElectionWatcher starts following the scheduler's leader election in the background,
+// until the context is canceled. When this replica becomes the leader, onStartedLeading is called.
+func (s *PluginState) startLeaderElectionWatcher(
+	ctx context.Context,
+	logger *zap.Logger,
+	config LeaderElectionConfig,
+	client kubernetes.Interface,
+	onStartedLeading func(),
+) error {
+	identityPrefix, err := leaderIdentityPrefix()
+	if err != nil {
+		return err
+	}
+
+	var setLeaderLabel func(bool) error
+	podName, podNamespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if podName == "" || podNamespace == "" {
+		logger.Warn("POD_NAME or POD_NAMESPACE not set, will not label the scheduler Pod with leadership")
+	} else {
+		setLeaderLabel = s.leaderLabelSetter(client.CoreV1(), podNamespace, podName)
+	}
+
+	logger.Info("Following scheduler leader election", zap.String("IdentityPrefix", identityPrefix))
+	go s.runLeaderElectionWatcher(
+		ctx,
+		logger,
+		config,
+		client.CoordinationV1(),
+		identityPrefix,
+		setLeaderLabel,
+		onStartedLeading,
+	)
+	return nil
+}
+
+// leaderIdentityPrefix returns the prefix of kube-scheduler's leader election identity for this
+// replica.
+func leaderIdentityPrefix() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("could not get hostname: %w", err)
+	}
+	return hostname + "_", nil
+}
+
+// runLeaderElectionWatcher periodically checks which replica holds the scheduler's Lease, until the
+// context is canceled. When this replica becomes the leader, onStartedLeading is called.
+//
+// setLeaderLabel sets api.LabelSchedulerLeader on our pod. It may be nil, if we don't know which pod
+// we're in.
+func (s *PluginState) runLeaderElectionWatcher(
+	ctx context.Context,
+	logger *zap.Logger,
+	config LeaderElectionConfig,
+	client coordinationv1client.LeasesGetter,
+	identityPrefix string,
+	setLeaderLabel func(leader bool) error,
+	onStartedLeading func(),
+) {
+	ticker := time.NewTicker(time.Second * time.Duration(config.CheckIntervalSeconds))
+	defer ticker.Stop()
+
+	// labeled is the value of the leader label on our pod, if we've set it.
+	var labeled *bool
+
+	for {
+		holder, err := s.leaseHolder(ctx, config, client, time.Now())
+		if err != nil {
+			// Keep going as we were. If we lose the Lease, kube-scheduler will exit anyway.
+			logger.Error("Failed to get scheduler leader election Lease", zap.Error(err))
+		} else {
+			leading := strings.HasPrefix(holder, identityPrefix)
+
+			if leading && !s.leading.Load() {
+				logger.Info("Became the leader", zap.String("Identity", holder))
+				s.leading.Store(true)
+				s.metrics.Leader.Set(1)
+				onStartedLeading()
+			} else if !leading && s.leading.Load() {
+				logger.Warn("No longer the leader", zap.String("Leader", holder))
+				s.leading.Store(false)
+				s.metrics.Leader.Set(0)
+			}
+
+			if setLeaderLabel != nil && (labeled == nil || *labeled != leading) {
+				if err := setLeaderLabel(leading); err != nil {
+					logger.Error("Failed to update leader label on scheduler Pod", zap.Bool("Leader", leading), zap.Error(err))
+				} else {
+					labeled = &leading
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// leaseHolder returns the identity of the current holder of the scheduler's Lease, or empty if it
+// has no holder or the Lease has expired.
+func (s *PluginState) leaseHolder(
+	ctx context.Context,
+	config LeaderElectionConfig,
+	client coordinationv1client.LeasesGetter,
+	now time.Time,
+) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(s.config().K8sCRUDTimeoutSeconds))
+	defer cancel()
+
+	start := time.Now()
+	lease, err := client.Leases(config.LeaseNamespace).Get(ctx, config.LeaseName, metav1.GetOptions{})
+	s.apiHealth.Observe(time.Since(start), err)
+	s.metrics.RecordK8sOp("Get", "Lease", config.LeaseName, err)
+	if err != nil {
+		return "", err
+	}
+
+	holder := currentLeaseHolder(lease, now)
+	s.metrics.LeaderIdentity.Reset()
+	if holder != "" {
+		s.metrics.LeaderIdentity.WithLabelValues(holder).Set(1)
+	}
+	return holder, nil
+}
+
+// currentLeaseHolder returns the identity of the holder of the Lease, or empty if it has no holder
+// or it hasn't been renewed within its duration.
+func currentLeaseHolder(lease *coordinationv1.Lease, now time.Time) string {
+	holder := lo.FromPtr(lease.Spec.HolderIdentity)
+	if holder == "" || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return ""
+	}
+
+	expiresAt := lease.Spec.RenewTime.Add(time.Second * time.Duration(*lease.Spec.LeaseDurationSeconds))
+	if now.After(expiresAt) {
+		return ""
+	}
+	return holder
+}
+
+// leaderLabelSetter returns a function that sets api.LabelSchedulerLeader on the pod.
+func (s *PluginState) leaderLabelSetter(
+	client corev1client.PodsGetter,
+	namespace string,
+	name string,
+) func(leader bool) error {
+	return func(leader bool) error {
+		var value any // nil removes the label
+		if leader {
+			value = "true"
+		}
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"labels": map[string]any{api.LabelSchedulerLeader: value},
+			},
+		})
+		if err != nil {
+			panic(fmt.Errorf("could not marshal JSON patch: %w", err))
+		}
+
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Second*time.Duration(s.config().K8sCRUDTimeoutSeconds))
+		defer cancel()
+
+		start := time.Now()
+		_, err = client.Pods(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+		s.apiHealth.Observe(time.Since(start), err)
+		s.metrics.RecordK8sOp("Patch", "Pod", name, err)
+		return err
+	}
+}
+
+// requeueDeferredForLeader requeues everything that was deferred while this replica was a standby:
+// pods with changes that weren't made, and all nodes, so that they're balanced.
+func (s *PluginState) requeueDeferredForLeader(logger *zap.Logger) {
+	s.mu.Lock()
+	uids := lo.Keys(s.requeueAfterStartup)
+	clear(s.requeueAfterStartup)
+	s.mu.Unlock()
+
+	for _, uid := range uids {
+		if err := s.requeuePod(uid); err != nil {
+			logger.Warn(
+				"Could not requeue Pod after becoming the leader, maybe it was deleted?",
+				zap.String("UID", string(uid)),
+			)
+		}
+	}
+
+	if _, err := s.requeueAllNodes(logger); err != nil {
+		logger.Error("Failed to requeue Nodes after becoming the leader", zap.Error(err))
+	}
+}
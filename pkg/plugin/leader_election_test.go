@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCurrentLeaseHolder(t *testing.T) {
+	now := time.Now()
+	lease := func(holder *string, renewedAgo time.Duration) *coordinationv1.Lease {
+		return &coordinationv1.Lease{ //nolint:exhaustruct // only need the spec
+			Spec: coordinationv1.LeaseSpec{ //nolint:exhaustruct // only need the holder and timing
+				HolderIdentity:       holder,
+				LeaseDurationSeconds: lo.ToPtr[int32](15),
+				RenewTime:            &metav1.MicroTime{Time: now.Add(-renewedAgo)},
+			},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		lease    *coordinationv1.Lease
+		expected string
+	}{
+		{
+			name:     "no-holder",
+			lease:    lease(nil, time.Second),
+			expected: "",
+		},
+		{
+			name:     "renewed",
+			lease:    lease(lo.ToPtr("scheduler-0_abcd"), 10*time.Second),
+			expected: "scheduler-0_abcd",
+		},
+		{
+			name:     "expired",
+			lease:    lease(lo.ToPtr("scheduler-0_abcd"), 20*time.Second),
+			expected: "",
+		},
+		{
+			name:     "never-renewed",
+			lease:    &coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{HolderIdentity: lo.ToPtr("scheduler-0_abcd")}}, //nolint:exhaustruct // only need the holder
+			expected: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, currentLeaseHolder(c.lease, now))
+		})
+	}
+}
@@ -25,6 +25,91 @@ type Plugin struct {
 
 	ResourceRequests      *prometheus.CounterVec
 	ValidResourceRequests *prometheus.CounterVec
+	// PreapprovedResourceRequests counts the valid resource requests that were approved
+	// immediately, because they were within the VM's preapproved resources.
+	PreapprovedResourceRequests prometheus.Counter
+	// UpscaleRateLimited counts the number of times that granting upscaling for a VM was delayed
+	// by the cluster-wide upscale rate limit.
+	UpscaleRateLimited prometheus.Counter
+	// ReservationsReleased counts the number of times that upscaling approved for a VM was
+	// released because the autoscaler-agent didn't confirm it within the reservation TTL.
+	ReservationsReleased prometheus.Counter
+	// UnboundReservationsExpired counts the number of pods whose resources reserved by the Reserve
+	// method were released because they weren't bound within the configured TTL.
+	UnboundReservationsExpired prometheus.Counter
+	// FederationPushes counts the pushes of cluster summaries to the federation service, by
+	// outcome.
+	FederationPushes *prometheus.CounterVec
+	// HealthReportWrites counts the writes of the fleet health report to its ConfigMap, by
+	// outcome.
+	HealthReportWrites *prometheus.CounterVec
+	// CapacityForecastVMsFit gives the number of additional VMs that would fit in the cluster, by
+	// the configured shape of VM.
+	CapacityForecastVMsFit *prometheus.GaugeVec
+	// CapacityForecastWatermarkSeconds gives the estimated time until the resources reserved in the
+	// cluster reach its watermark at the current rate of growth, by resource. It's +Inf if usage
+	// isn't growing.
+	CapacityForecastWatermarkSeconds *prometheus.GaugeVec
+	// StartupEvents gives the number of objects reconciled during startup, and the number remaining,
+	// by kind and state ("handled" or "remaining").
+	StartupEvents *prometheus.GaugeVec
+	// StateHandoffs counts the snapshots of the plugin's state that were written for the next
+	// instance, or restored from the previous one, by operation and outcome.
+	StateHandoffs *prometheus.CounterVec
+	// Leader is 1 if this replica of the scheduler is the leader, and 0 if it's a standby. It's
+	// always 1 if leader election isn't enabled.
+	Leader prometheus.Gauge
+	// LeaderIdentity is 1 for the identity of the current leader, as seen by this replica, if
+	// leader election is enabled.
+	LeaderIdentity *prometheus.GaugeVec
+	// NodePressureDownscales counts the number of times a VM was first asked to downscale during an
+	// episode of memory pressure on its node.
+	NodePressureDownscales prometheus.Counter
+	// TenantFairnessThrottled counts the number of times that granting upscaling for a VM was
+	// limited to its tenant's fair share of the node, by tenant.
+	TenantFairnessThrottled *prometheus.CounterVec
+	// APIDegraded is 1 while the API server is considered degraded, and 0 otherwise.
+	APIDegraded prometheus.Gauge
+	// DeferredWrites counts the non-critical writes to the API server that were deferred because
+	// it was degraded, by kind of write.
+	DeferredWrites *prometheus.CounterVec
+	// ConfigReloads counts the attempts to reload the config after its file changed, by outcome.
+	ConfigReloads *prometheus.CounterVec
+	// ShadowDecisions counts the decisions made by the real scheduler that were compared against
+	// this replica's config, if it's in shadow mode, by kind of decision and whether they agreed.
+	ShadowDecisions *prometheus.CounterVec
+	// DryRunActions counts the changes that the plugin would have made if it weren't in dry-run
+	// mode, by kind of action.
+	DryRunActions *prometheus.CounterVec
+	// Preemptions counts the attempts to make room for VM pods that didn't fit on any node, by
+	// outcome.
+	Preemptions *prometheus.CounterVec
+	// PreemptionVictims counts the pods chosen to make room for VM pods, by whether they were
+	// evicted or migrated.
+	PreemptionVictims *prometheus.CounterVec
+	// NodeCordons counts the nodes cordoned or uncordoned for crossing the cordon watermark, by
+	// action.
+	NodeCordons *prometheus.CounterVec
+	// MigrationsDeferred counts the times that starting a migration was deferred because of the
+	// configured migration limits, by which limit was reached.
+	MigrationsDeferred *prometheus.CounterVec
+	// MigrationsSuppressedByBlackout counts the times that a migration would have been triggered,
+	// but wasn't because of an active migration blackout window, by the window.
+	MigrationsSuppressedByBlackout *prometheus.CounterVec
+	// NodesDefragmented counts the nodes that were chosen to be emptied for defragmentation.
+	NodesDefragmented prometheus.Counter
+	// NodeDrains counts the drains of nodes that were started or canceled, by action.
+	NodeDrains *prometheus.CounterVec
+	// VersionSkew counts the requests from autoscaler-agents with unsupported versions, by their
+	// version and whether the request was refused or just warned about.
+	VersionSkew *prometheus.CounterVec
+	// SchedulingWait tracks how long VM pods wait between creation and being bound to a node, by
+	// the reason for the delay and the VM's size in Compute Units.
+	SchedulingWait *prometheus.HistogramVec
+	// StorageLocalityPlacements counts VM pods with a storage locality preference set by
+	// neonvm-controller, by the preference's policy and whether the node they were bound to
+	// satisfies it.
+	StorageLocalityPlacements *prometheus.CounterVec
 
 	K8sOps *prometheus.CounterVec
 }
@@ -52,6 +137,202 @@ func BuildPluginMetrics(nodeMetricLabels map[string]string, reg prometheus.Regis
 			},
 			[]string{"code", "node"},
 		)),
+		PreapprovedResourceRequests: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_preapproved_resource_requests_total",
+				Help: "Number of resource requests to the scheduler plugin that were approved immediately due to preapproval",
+			},
+		)),
+		NodePressureDownscales: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_node_pressure_downscales_total",
+				Help: "Number of times VMs were asked to downscale due to node memory pressure, once per pressure episode",
+			},
+		)),
+		TenantFairnessThrottled: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_tenant_fairness_throttled_total",
+				Help: "Number of times granting upscaling was limited to the tenant's fair share of the node",
+			},
+			[]string{"tenant"},
+		)),
+		APIDegraded: util.RegisterMetric(reg, prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_api_degraded",
+				Help: "Whether the API server is considered degraded, so non-critical writes are deferred",
+			},
+		)),
+		DeferredWrites: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_deferred_writes_total",
+				Help: "Number of non-critical writes deferred because the API server was degraded",
+			},
+			[]string{"kind"},
+		)),
+		UpscaleRateLimited: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_upscale_rate_limited_total",
+				Help: "Number of times granting upscaling was delayed by the cluster-wide upscale rate limit",
+			},
+		)),
+		ReservationsReleased: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_reservations_released_total",
+				Help: "Number of times approved upscaling was released because it wasn't confirmed in time",
+			},
+		)),
+		UnboundReservationsExpired: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_unbound_reservations_expired_total",
+				Help: "Number of reserved pods that were released because they weren't bound in time",
+			},
+		)),
+		FederationPushes: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_federation_pushes_total",
+				Help: "Number of cluster summaries pushed to the federation service, by outcome",
+			},
+			[]string{"outcome"},
+		)),
+		HealthReportWrites: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_health_report_writes_total",
+				Help: "Number of writes of the fleet health report to its ConfigMap, by outcome",
+			},
+			[]string{"outcome"},
+		)),
+		CapacityForecastVMsFit: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_capacity_forecast_vms_fit",
+				Help: "Number of additional VMs that would fit in the cluster, by configured VM shape",
+			},
+			[]string{"shape"},
+		)),
+		CapacityForecastWatermarkSeconds: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_capacity_forecast_watermark_seconds",
+				Help: "Estimated time until reserved resources reach the cluster's watermark at the current growth rate, by resource",
+			},
+			[]string{"resource"},
+		)),
+		StartupEvents: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_startup_events",
+				Help: "Number of objects handled and remaining to be handled during startup, by kind and state",
+			},
+			[]string{"kind", "state"},
+		)),
+		StateHandoffs: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_state_handoffs_total",
+				Help: "Number of state snapshots written for or restored from another scheduler instance, by operation and outcome",
+			},
+			[]string{"operation", "outcome"},
+		)),
+		Leader: util.RegisterMetric(reg, prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_leader",
+				Help: "Whether this replica of the scheduler plugin is the leader (1) or a standby (0)",
+			},
+		)),
+		LeaderIdentity: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_leader_identity",
+				Help: "Identity of the current leader of the scheduler replicas, as seen by this replica",
+			},
+			[]string{"identity"},
+		)),
+		ConfigReloads: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_config_reloads_total",
+				Help: "Number of attempts to reload the scheduler plugin config after it changed, by outcome",
+			},
+			[]string{"outcome"},
+		)),
+		ShadowDecisions: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_shadow_decisions_total",
+				Help: "Number of scheduling decisions compared against the shadow config, by decision and outcome",
+			},
+			[]string{"decision", "outcome"},
+		)),
+		DryRunActions: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_dry_run_actions_total",
+				Help: "Number of changes that the scheduler plugin would have made if not in dry-run mode, by action",
+			},
+			[]string{"action"},
+		)),
+		Preemptions: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_preemptions_total",
+				Help: "Number of attempts to make room for VM pods that didn't fit on any node, by outcome",
+			},
+			[]string{"outcome"},
+		)),
+		PreemptionVictims: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_preemption_victims_total",
+				Help: "Number of pods evicted or migrated to make room for VM pods, by action",
+			},
+			[]string{"action"},
+		)),
+		NodeCordons: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_node_cordons_total",
+				Help: "Number of nodes cordoned or uncordoned for crossing the cordon watermark, by action",
+			},
+			[]string{"action"},
+		)),
+		MigrationsDeferred: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_migrations_deferred_total",
+				Help: "Number of times starting a migration was deferred due to migration limits, by limit",
+			},
+			[]string{"reason"},
+		)),
+		MigrationsSuppressedByBlackout: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_migrations_suppressed_by_blackout_total",
+				Help: "Number of times a migration was not triggered because of a migration blackout window, by window",
+			},
+			[]string{"window"},
+		)),
+		NodesDefragmented: util.RegisterMetric(reg, prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_defragmented_nodes_total",
+				Help: "Number of nodes chosen to be emptied by migrating their VMs, for defragmentation",
+			},
+		)),
+		NodeDrains: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_node_drains_total",
+				Help: "Number of node drains started or canceled through the plugin, by action",
+			},
+			[]string{"action"},
+		)),
+		VersionSkew: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_version_skew_total",
+				Help: "Number of requests from autoscaler-agents with unsupported versions, by peer version and action",
+			},
+			[]string{"peer_version", "action"},
+		)),
+		SchedulingWait: util.RegisterMetric(reg, prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "autoscaling_plugin_vm_pod_scheduling_wait_seconds",
+				Help:    "Time from VM pod creation until it was bound to a node, by reason for the delay and size class",
+				Buckets: prometheus.ExponentialBuckets(0.05, 2, 14), // 50ms to ~7 minutes
+			},
+			[]string{"reason", "size_class"},
+		)),
+		StorageLocalityPlacements: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_storage_locality_placements_total",
+				Help: "Number of VM pods with a storage locality preference bound to nodes, by policy and outcome",
+			},
+			[]string{"policy", "outcome"},
+		)),
 
 		K8sOps: util.RegisterMetric(reg, prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -21,11 +21,14 @@ type Node struct {
 
 	cpu *prometheus.GaugeVec
 	mem *prometheus.GaugeVec
+
+	overWatermark *prometheus.GaugeVec
 }
 
 func buildNodeMetrics(labels nodeLabeling, reg prometheus.Registerer) *Node {
-	finalMetricLabels := []string{"node"}
-	finalMetricLabels = append(finalMetricLabels, labels.metricLabelNames...)
+	commonMetricLabels := []string{"node"}
+	commonMetricLabels = append(commonMetricLabels, labels.metricLabelNames...)
+	finalMetricLabels := slices.Clone(commonMetricLabels)
 	finalMetricLabels = append(finalMetricLabels, "field")
 
 	return &Node{
@@ -48,10 +51,19 @@ func buildNodeMetrics(labels nodeLabeling, reg prometheus.Registerer) *Node {
 			},
 			finalMetricLabels,
 		)),
+		overWatermark: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_node_over_watermark",
+				Help: "Whether the node is over its watermark (1) or not (0), accounting for hysteresis",
+			},
+			commonMetricLabels,
+		)),
 	}
 }
 
-func (m *Node) Update(node *state.Node) {
+// Update sets the metrics for the node. overWatermark is whether we're migrating VMs away from it
+// to reduce its usage.
+func (m *Node) Update(node *state.Node, overWatermark bool) {
 	commonLabels := []string{node.Name}
 	for _, label := range m.InheritedLabels {
 		value, _ := node.Labels.Get(label)
@@ -77,6 +89,12 @@ func (m *Node) Update(node *state.Node) {
 		m.mem.WithLabelValues(labels...).Set(f.Value.AsFloat64())
 	}
 
+	if overWatermark {
+		m.overWatermark.WithLabelValues(commonLabels...).Set(1)
+	} else {
+		m.overWatermark.WithLabelValues(commonLabels...).Set(0)
+	}
+
 	m.lastLabels[node.Name] = commonLabels
 }
 
@@ -90,5 +108,6 @@ func (m *Node) removeLocked(node *state.Node) {
 	baseMatch := prometheus.Labels{"node": node.Name}
 	m.cpu.DeletePartialMatch(baseMatch)
 	m.mem.DeletePartialMatch(baseMatch)
+	m.overWatermark.DeletePartialMatch(baseMatch)
 	delete(m.lastLabels, node.Name)
 }
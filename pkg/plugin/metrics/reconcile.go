@@ -7,15 +7,25 @@ import (
 )
 
 type Reconcile struct {
-	WaitDurations    prometheus.Histogram
+	WaitDurations    *prometheus.HistogramVec
 	ProcessDurations *prometheus.HistogramVec
-	Failing          *prometheus.GaugeVec
-	Panics           *prometheus.CounterVec
+	// QueueDepth is the number of objects of each kind in each stage of the reconcile queue:
+	// "queued", "pending", or "ongoing".
+	QueueDepth *prometheus.GaugeVec
+	Retries    *prometheus.CounterVec
+	Failing    *prometheus.GaugeVec
+	// AboveFailureThreshold is the number of objects of each kind that have failed to reconcile at
+	// least LogSuccessiveFailuresThreshold times in a row.
+	AboveFailureThreshold *prometheus.GaugeVec
+	Panics                *prometheus.CounterVec
+	// Workers is the current number of workers in each pool: "shared", or the kind of object for
+	// kinds with their own pool.
+	Workers *prometheus.GaugeVec
 }
 
 func buildReconcileMetrics(reg prometheus.Registerer) Reconcile {
 	return Reconcile{
-		WaitDurations: util.RegisterMetric(reg, prometheus.NewHistogram(
+		WaitDurations: util.RegisterMetric(reg, prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name: "autoscaling_plugin_reconcile_queue_wait_durations",
 				Help: "Duration that items in the reconcile queue are waiting to be picked up",
@@ -28,6 +38,7 @@ func buildReconcileMetrics(reg prometheus.Registerer) Reconcile {
 					1.0, 2.5, 5, 10, 20, 45,
 				},
 			},
+			[]string{"kind"},
 		)),
 		ProcessDurations: util.RegisterMetric(reg, prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
@@ -44,6 +55,20 @@ func buildReconcileMetrics(reg prometheus.Registerer) Reconcile {
 			},
 			[]string{"kind", "outcome"},
 		)),
+		QueueDepth: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_reconcile_queue_depth",
+				Help: "Number of objects in the reconcile queue, by stage",
+			},
+			[]string{"kind", "stage"},
+		)),
+		Retries: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "autoscaling_plugin_reconcile_retries_count",
+				Help: "Number of times reconcile operations have been scheduled to be retried",
+			},
+			[]string{"kind"},
+		)),
 		Failing: util.RegisterMetric(reg, prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "autoscaling_plugin_reconcile_failing_objects",
@@ -51,6 +76,13 @@ func buildReconcileMetrics(reg prometheus.Registerer) Reconcile {
 			},
 			[]string{"kind"},
 		)),
+		AboveFailureThreshold: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_reconcile_objects_above_failure_threshold",
+				Help: "Number of objects that have failed to be reconciled at least the configured threshold times in a row",
+			},
+			[]string{"kind"},
+		)),
 		Panics: util.RegisterMetric(reg, prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "autoscaling_plugin_reconcile_panics_count",
@@ -58,5 +90,12 @@ func buildReconcileMetrics(reg prometheus.Registerer) Reconcile {
 			},
 			[]string{"kind"},
 		)),
+		Workers: util.RegisterMetric(reg, prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "autoscaling_plugin_reconcile_workers",
+				Help: "Current number of reconcile workers",
+			},
+			[]string{"pool"},
+		)),
 	}
 }
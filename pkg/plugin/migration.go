@@ -5,15 +5,52 @@ package plugin
 import (
 	"fmt"
 	"slices"
+	"time"
 
+	"github.com/samber/lo"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
 	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
+// disruptionGroupOf returns the disruption group for the pod, if it has one.
+//
+// Disruption groups are scoped to the namespace, so the group's "name" is the value of the pod's
+// label.
+func disruptionGroupOf(pod state.Pod) (_ util.NamespacedName, ok bool) {
+	if pod.DisruptionGroup == "" {
+		return lo.Empty[util.NamespacedName](), false
+	}
+	return util.NamespacedName{Namespace: pod.Namespace, Name: pod.DisruptionGroup}, true
+}
+
+// disruptedGroups returns the set of disruption groups that have a pod that's currently migrating,
+// or that we've requested to migrate.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) disruptedGroups() map[util.NamespacedName]struct{} {
+	groups := make(map[util.NamespacedName]struct{})
+	for _, ns := range s.nodes {
+		for uid, pod := range ns.node.Pods() {
+			_, requested := ns.requestedMigrations[uid]
+			if !pod.Migrating && !requested {
+				continue
+			}
+			if group, ok := disruptionGroupOf(pod); ok {
+				groups[group] = struct{}{}
+			}
+		}
+	}
+	return groups
+}
+
 // triggerMigrationsIfNecessary uses the state of the temporary node to request any migrations that
 // may be ncessary to reduce the reserved resources below the watermark.
 func triggerMigrationsIfNecessary(
@@ -21,6 +58,8 @@ func triggerMigrationsIfNecessary(
 	originalNode *state.Node,
 	tmpNode *state.Node,
 	requestedMigrations []types.UID,
+	disruptedGroups map[util.NamespacedName]struct{},
+	victimOrder func(x, y state.Pod) int,
 	requestMigrationAndRequeue func(podUID types.UID) error,
 ) error {
 	// To get an accurate count of the amount that's migrating, mark all the pods in
@@ -74,12 +113,17 @@ func triggerMigrationsIfNecessary(
 
 	// Ok, we have some migration candidates. Let's sort them and keep triggering migrations
 	// until it'll be enough to get below the watermark.
-	slices.SortFunc(candidates, func(cx, cy state.Pod) int {
-		return cx.BetterMigrationTargetThan(cy)
-	})
+	slices.SortFunc(candidates, victimOrder)
 	for _, pod := range candidates {
 		podLogger := logger.With(zap.Any("CandidatePod", pod))
 
+		// Each resource is evaluated separately (e.g., only memory may still be above its
+		// watermark), so there's no point migrating a pod that wouldn't help with the resources
+		// that are.
+		if !reducesAboveWatermark(pod, cpuAbove, memAbove) {
+			continue
+		}
+
 		// If we find a pod that is singularly above the watermark, don't migrate it! We'll
 		// likely just end up above the watermark on the new node.
 		// NOTE that this is NOT true in heterogeneous clusters (i.e., where the nodes are
@@ -94,6 +138,13 @@ func triggerMigrationsIfNecessary(
 			continue
 		}
 
+		// Only allow one migration at a time from each disruption group.
+		group, hasGroup := disruptionGroupOf(pod)
+		if _, disrupted := disruptedGroups[group]; hasGroup && disrupted {
+			podLogger.Info("Skipping potential migration of candidate Pod because its disruption group is already migrating")
+			continue
+		}
+
 		// Trigger migration of this pod!
 		podLogger.Info("Internally triggering migration for candidate Pod")
 		if err := requestMigrationAndRequeue(pod.UID); err != nil {
@@ -104,6 +155,9 @@ func triggerMigrationsIfNecessary(
 		newPod := pod
 		newPod.Migrating = true
 		tmpNode.UpdatePod(pod, newPod)
+		if hasGroup {
+			disruptedGroups[group] = struct{}{}
+		}
 
 		// ... and then check if we need to keep migrating more ...
 		cpuAbove = tmpNode.CPU.UnmigratedAboveWatermark()
@@ -125,3 +179,42 @@ func triggerMigrationsIfNecessary(
 
 	return nil
 }
+
+// reducesAboveWatermark returns whether migrating the pod would reduce the amount of CPU or memory
+// that's above the watermark, given the amounts that currently are.
+func reducesAboveWatermark(pod state.Pod, cpuAbove vmv1.MilliCPU, memAbove api.Bytes) bool {
+	return (cpuAbove > 0 && pod.CPU.Reserved > 0) || (memAbove > 0 && pod.Mem.Reserved > 0)
+}
+
+// shouldDeferMigration returns whether the migration of the pod, first requested at requestedAt,
+// should be deferred because its VM is busy -- and if so, how long to wait before checking again.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) shouldDeferMigration(
+	obj *corev1.Pod,
+	pod state.Pod,
+	requestedAt time.Time,
+	now time.Time,
+) (recheck time.Duration, _ bool) {
+	config := s.config().MigrationDeferral
+	if config == nil {
+		return 0, false
+	}
+
+	deadline := requestedAt.Add(time.Second * time.Duration(config.MaxDeferralSeconds))
+	if !now.Before(deadline) {
+		return 0, false
+	}
+
+	busy := api.IsMarkedBusy(obj)
+	if usage, ok := s.podUsage[pod.UID]; ok && config.BusyCPUFraction != 0 {
+		load := float64(usage.LoadAverage1Min) * 1000
+		busy = busy || load >= config.BusyCPUFraction*float64(pod.CPU.Reserved)
+	}
+	if !busy {
+		return 0, false
+	}
+
+	recheck = time.Second * time.Duration(config.RecheckIntervalSeconds)
+	return min(recheck, deadline.Sub(now)), true
+}
@@ -0,0 +1,273 @@
+package plugin
+
+// Blackout windows for live migrations, as configured by (Config).MigrationBlackoutWindows.
+//
+// During a blackout window, nodes above the watermark are still balanced as usual, but the
+// migrations that would be triggered are only logged and counted in the
+// MigrationsSuppressedByBlackout metric, so that the situation can be alerted on.
+//
+// Each window starts at the times given by a standard 5-field cron schedule ("minute hour
+// day-of-month month day-of-week"), evaluated in the window's time zone, and lasts for a fixed
+// duration.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// Embed the time zone database, so that windows can be configured in any time zone, regardless
+	// of what's available in the container image.
+	_ "time/tzdata"
+
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// maxBlackoutWindowDuration is the maximum duration of a single blackout window.
+const maxBlackoutWindowDuration = 7 * 24 * time.Hour
+
+// blackoutWindow is the parsed form of a MigrationBlackoutWindowConfig.
+type blackoutWindow struct {
+	name     string
+	schedule *cronSchedule
+	duration time.Duration
+	location *time.Location
+}
+
+// blackoutState caches the blackout window that's active, so that we don't have to re-evaluate the
+// cron schedules every time a node is balanced.
+type blackoutState struct {
+	windows []blackoutWindow
+
+	// minute is the start of the minute that active is valid for.
+	minute time.Time
+	// active is the name of the window that's active at minute, or empty if there is none.
+	active string
+}
+
+// newBlackoutState returns the blackoutState for the windows in the config.
+//
+// The config must already be validated.
+func newBlackoutState(configs []MigrationBlackoutWindowConfig) *blackoutState {
+	var windows []blackoutWindow
+	for _, c := range configs {
+		w, err := c.parse()
+		if err != nil {
+			panic(fmt.Errorf("invalid migration blackout window %q: %w", c.Name, err))
+		}
+		windows = append(windows, *w)
+	}
+	return &blackoutState{
+		windows: windows,
+		minute:  time.Time{},
+		active:  "",
+	}
+}
+
+// parse returns the parsed blackoutWindow for the config.
+func (c MigrationBlackoutWindowConfig) parse() (*blackoutWindow, error) {
+	schedule, err := parseCronSchedule(c.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	location := time.UTC
+	if c.TimeZone != "" {
+		location, err = time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone: %w", err)
+		}
+	}
+	return &blackoutWindow{
+		name:     c.Name,
+		schedule: schedule,
+		duration: time.Minute * time.Duration(c.DurationMinutes),
+		location: location,
+	}, nil
+}
+
+// activeBlackoutWindow returns the name of the migration blackout window that's active at the
+// given time, or empty if there is none.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) activeBlackoutWindow(now time.Time) string {
+	b := s.blackout
+	minute := now.Truncate(time.Minute)
+	if len(b.windows) == 0 || minute.Equal(b.minute) {
+		return b.active
+	}
+
+	b.minute = minute
+	b.active = ""
+	for _, w := range b.windows {
+		if w.contains(minute) {
+			b.active = w.name
+			break
+		}
+	}
+	return b.active
+}
+
+// blackoutWindowForMigration returns the name of the active migration blackout window, if the
+// requested migration for the pod hasn't already been created. Otherwise, it returns empty.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) blackoutWindowForMigration(ns *nodeState, podUID types.UID) string {
+	if _, started := ns.startingMigrations[podUID]; started {
+		return ""
+	}
+	return s.activeBlackoutWindow(time.Now())
+}
+
+// contains returns whether the window is active at the minute, i.e. whether the window started at
+// some point in the preceding duration.
+func (w blackoutWindow) contains(minute time.Time) bool {
+	minute = minute.In(w.location)
+	for offset := time.Duration(0); offset < w.duration; offset += time.Minute {
+		if w.schedule.matches(minute.Add(-offset)) {
+			return true
+		}
+	}
+	return false
+}
+
+// suppressMigrationsForBlackout returns a replacement for the function that requests a migration,
+// which instead logs that the migration was suppressed because of the active blackout window.
+func (s *PluginState) suppressMigrationsForBlackout(logger *zap.Logger, window string) func(types.UID) error {
+	return func(podUID types.UID) error {
+		logger.Warn(
+			"Not triggering migration for candidate Pod during migration blackout window",
+			zap.String("Window", window),
+			zap.String("PodUID", string(podUID)),
+		)
+		s.metrics.MigrationsSuppressedByBlackout.WithLabelValues(window).Inc()
+		return nil
+	}
+}
+
+// cronSchedule is a parsed 5-field cron schedule.
+//
+// Each field is stored as a bitset of the values that match.
+type cronSchedule struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+
+	// If both the day of month and day of week are restricted (i.e., not '*'), a time matches if
+	// either of them do, like in standard cron.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// parseCronSchedule parses a standard 5-field cron schedule.
+//
+// Each field may be '*', a single value, a range like "1-5", any of those with a step like "*/15",
+// or a comma-separated list of them. Days of the week are numbered 0-7, where both 0 and 7 are
+// Sunday. Names of months and days are not supported.
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if s.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if s.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month field: %w", err)
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+	if s.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week field: %w", err)
+	}
+	// 7 is also Sunday
+	if s.daysOfWeek&(1<<7) != 0 {
+		s.daysOfWeek |= 1 << 0
+	}
+	s.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	s.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+
+	return &s, nil
+}
+
+// parseCronField parses a single field of a cron schedule into a bitset of the matching values,
+// which must be between minValue and maxValue (inclusive).
+func parseCronField(field string, minValue, maxValue int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepStr, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		var start, end int
+		if rangePart == "*" {
+			start, end = minValue, maxValue
+		} else {
+			startStr, endStr, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(startStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q", startStr)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endStr); err != nil {
+					return 0, fmt.Errorf("invalid value %q", endStr)
+				}
+			} else if hasStep {
+				// "N/step" means from N to the maximum
+				end = maxValue
+			}
+		}
+
+		if start < minValue || end > maxValue || start > end {
+			return 0, fmt.Errorf("range %q must be within %d-%d", rangePart, minValue, maxValue)
+		}
+		for v := start; v <= end; v += step {
+			set |= 1 << v
+		}
+	}
+
+	if set == 0 {
+		return 0, errors.New("field matches no values")
+	}
+	return set, nil
+}
+
+// matches returns whether the schedule matches the minute containing t, in t's location.
+func (s *cronSchedule) matches(t time.Time) bool {
+	has := func(set uint64, v int) bool { return set&(1<<v) != 0 }
+
+	if !has(s.minutes, t.Minute()) || !has(s.hours, t.Hour()) || !has(s.months, int(t.Month())) {
+		return false
+	}
+
+	domMatches := has(s.daysOfMonth, t.Day())
+	dowMatches := has(s.daysOfWeek, int(t.Weekday()))
+	switch {
+	case s.anyDayOfMonth && s.anyDayOfWeek:
+		return true
+	case s.anyDayOfMonth:
+		return dowMatches
+	case s.anyDayOfWeek:
+		return domMatches
+	default:
+		return domMatches || dowMatches
+	}
+}
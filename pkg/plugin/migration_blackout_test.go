@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+)
+
+func TestCronSchedule(t *testing.T) {
+	cases := []struct {
+		spec     string
+		time     string
+		expected bool
+	}{
+		{"0 9 * * 1-5", "2024-06-03T09:00:00Z", true},  // Monday
+		{"0 9 * * 1-5", "2024-06-03T09:01:00Z", false}, // wrong minute
+		{"0 9 * * 1-5", "2024-06-02T09:00:00Z", false}, // Sunday
+		{"0 9 * * 7", "2024-06-02T09:00:00Z", true},    // 7 is also Sunday
+		{"*/15 * * * *", "2024-06-03T12:45:00Z", true},
+		{"*/15 * * * *", "2024-06-03T12:50:00Z", false},
+		{"30 22 1,15 * *", "2024-06-15T22:30:00Z", true},
+		// if both days are restricted, either may match
+		{"0 0 1 * 1", "2024-06-03T00:00:00Z", true},
+		{"0 0 1 * 1", "2024-06-01T00:00:00Z", true},
+		{"0 0 1 * 1", "2024-06-02T00:00:00Z", false},
+	}
+
+	for _, c := range cases {
+		schedule, err := parseCronSchedule(c.spec)
+		assert.NoError(t, err, c.spec)
+		tm, err := time.Parse(time.RFC3339, c.time)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, schedule.matches(tm), "%q at %s", c.spec, c.time)
+	}
+
+	for _, invalid := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := parseCronSchedule(invalid)
+		assert.Error(t, err, "%q", invalid)
+	}
+}
+
+func TestMigrationBlackoutWindows(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.MigrationBlackoutWindows = []MigrationBlackoutWindowConfig{
+		{
+			Name:            "weekday-peak",
+			Schedule:        "0 9 * * 1-5",
+			DurationMinutes: 8 * 60,
+			TimeZone:        "America/New_York",
+		},
+	}
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	monday := func(hour, minute int) time.Time {
+		return time.Date(2024, time.June, 3, hour, minute, 30, 0, newYork)
+	}
+
+	assert.Equal(t, "", s.activeBlackoutWindow(monday(8, 59)))
+	assert.Equal(t, "weekday-peak", s.activeBlackoutWindow(monday(9, 0)))
+	assert.Equal(t, "weekday-peak", s.activeBlackoutWindow(monday(16, 59)))
+	assert.Equal(t, "", s.activeBlackoutWindow(monday(17, 0)))
+	// 9am in New York is not 9am UTC
+	assert.Equal(t, "", s.activeBlackoutWindow(time.Date(2024, time.June, 3, 9, 30, 0, 0, time.UTC)))
+
+	// Invalid windows are rejected
+	config.MigrationBlackoutWindows[0].TimeZone = "Nowhere/Special"
+	path, err := config.validate()
+	assert.Error(t, err)
+	assert.Equal(t, "migrationBlackoutWindows[0].timeZone", path)
+
+	config.MigrationBlackoutWindows[0].TimeZone = ""
+	config.MigrationBlackoutWindows[0].DurationMinutes = 8 * 24 * 60
+	path, err = config.validate()
+	assert.Error(t, err)
+	assert.Equal(t, "migrationBlackoutWindows[0].durationMinutes", path)
+}
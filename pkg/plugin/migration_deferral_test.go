@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+)
+
+func TestShouldDeferMigration(t *testing.T) {
+	deferral := &MigrationDeferralConfig{
+		BusyCPUFraction:        0.8,
+		MaxDeferralSeconds:     600,
+		RecheckIntervalSeconds: 60,
+	}
+
+	requestedAt := time.Now()
+
+	cases := []struct {
+		name      string
+		deferral  *MigrationDeferralConfig
+		annotated bool
+		// load average reported by the autoscaler-agent, or nil if none was reported
+		load    *float32
+		elapsed time.Duration
+
+		expectedDefer   bool
+		expectedRecheck time.Duration
+	}{
+		{
+			name:            "disabled",
+			deferral:        nil,
+			annotated:       true,
+			load:            nil,
+			elapsed:         0,
+			expectedDefer:   false,
+			expectedRecheck: 0,
+		},
+		{
+			name:            "not busy",
+			deferral:        deferral,
+			annotated:       false,
+			load:            lo.ToPtr[float32](1.0),
+			elapsed:         0,
+			expectedDefer:   false,
+			expectedRecheck: 0,
+		},
+		{
+			name:            "no usage reported",
+			deferral:        deferral,
+			annotated:       false,
+			load:            nil,
+			elapsed:         0,
+			expectedDefer:   false,
+			expectedRecheck: 0,
+		},
+		{
+			name:            "annotated as busy",
+			deferral:        deferral,
+			annotated:       true,
+			load:            nil,
+			elapsed:         0,
+			expectedDefer:   true,
+			expectedRecheck: time.Minute,
+		},
+		{
+			name:            "busy from load average",
+			deferral:        deferral,
+			annotated:       false,
+			load:            lo.ToPtr[float32](3.2), // 80% of 4 CPUs
+			elapsed:         0,
+			expectedDefer:   true,
+			expectedRecheck: time.Minute,
+		},
+		{
+			name: "load average ignored",
+			deferral: &MigrationDeferralConfig{
+				BusyCPUFraction:        0,
+				MaxDeferralSeconds:     600,
+				RecheckIntervalSeconds: 60,
+			},
+			annotated:       false,
+			load:            lo.ToPtr[float32](4.0),
+			elapsed:         0,
+			expectedDefer:   false,
+			expectedRecheck: 0,
+		},
+		{
+			name:            "recheck at the deadline",
+			deferral:        deferral,
+			annotated:       true,
+			load:            nil,
+			elapsed:         590 * time.Second,
+			expectedDefer:   true,
+			expectedRecheck: 10 * time.Second,
+		},
+		{
+			name:            "past the deadline",
+			deferral:        deferral,
+			annotated:       true,
+			load:            nil,
+			elapsed:         600 * time.Second,
+			expectedDefer:   false,
+			expectedRecheck: 0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := defaultBenchmarkConfig()
+			config.MigrationDeferral = c.deferral
+			s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+			pod := preemptionTestPod("vm-a", 4000, true)
+			obj := &corev1.Pod{ //nolint:exhaustruct // only need the annotations
+				ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // only need the annotations
+					Name:        "vm-a",
+					Annotations: map[string]string{},
+				},
+			}
+			if c.annotated {
+				obj.Annotations[api.AnnotationBusy] = "true"
+			}
+			if c.load != nil {
+				s.podUsage[types.UID("vm-a")] = api.Metrics{
+					LoadAverage1Min:  *c.load,
+					LoadAverage5Min:  nil,
+					MemoryUsageBytes: nil,
+				}
+			}
+
+			recheck, deferred := s.shouldDeferMigration(obj, pod, requestedAt, requestedAt.Add(c.elapsed))
+			assert.Equal(t, c.expectedDefer, deferred)
+			assert.Equal(t, c.expectedRecheck, recheck)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package plugin
+
+// Limits on starting live migrations, as configured by (Config).MigrationLimits.
+//
+// Migrations that we've decided on are kept in each node's requestedMigrations until they're
+// created -- so when a limit is reached, the pod's reconcile is just retried later, and the
+// requested migrations effectively form a queue.
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// migrationLimitRecheckInterval is how long to wait before checking again whether a migration can
+// be started, when it was blocked by one of the concurrency limits.
+const migrationLimitRecheckInterval = 5 * time.Second
+
+// Reasons that a migration may be deferred by the migration limits, for the MigrationsDeferred
+// metric.
+const (
+	migrationLimitNodeConcurrency    = "node_concurrency"
+	migrationLimitClusterConcurrency = "cluster_concurrency"
+	migrationLimitRate               = "rate"
+)
+
+// migrationLimiter enforces the config's MigrationLimits, combining the concurrency limits with a
+// token bucket for the rate of new migrations.
+//
+// migrationLimiter is not safe for concurrent use. It is expected to only be used while holding the
+// PluginState's lock.
+type migrationLimiter struct {
+	config MigrationLimitsConfig
+
+	lastUpdate time.Time
+	tokens     float64
+}
+
+// newMigrationLimiter returns a new migrationLimiter for the config, or nil if config is nil.
+//
+// A nil *migrationLimiter permits all migrations.
+func newMigrationLimiter(config *MigrationLimitsConfig, now time.Time) *migrationLimiter {
+	if config == nil {
+		return nil
+	}
+
+	return &migrationLimiter{
+		config:     *config,
+		lastUpdate: now,
+		tokens:     float64(config.Burst),
+	}
+}
+
+func (l *migrationLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.lastUpdate).Minutes()
+	if elapsed <= 0 {
+		return
+	}
+	l.lastUpdate = now
+	l.tokens = min(float64(l.config.Burst), l.tokens+elapsed*l.config.PerMinute)
+}
+
+// tryTake attempts to take a token for a new migration.
+//
+// If a migration is permitted, tryTake returns zero. Otherwise, it returns the duration to wait
+// before retrying.
+func (l *migrationLimiter) tryTake(now time.Time) (wait time.Duration) {
+	if l.config.PerMinute == 0 {
+		return 0
+	}
+
+	l.refill(now)
+
+	if l.tokens < 1 {
+		minutes := (1 - l.tokens) / l.config.PerMinute
+		// round up to the nearest millisecond, so we don't retry slightly too early.
+		return time.Duration(minutes*60*1000+1) * time.Millisecond
+	}
+
+	l.tokens -= 1
+	return 0
+}
+
+// checkMigrationLimits returns whether a new migration away from the node can be started now. If
+// it can't, checkMigrationLimits returns the reason and how long to wait before checking again.
+//
+// If the migration can be started, it's counted against the rate limit.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) checkMigrationLimits(ns *nodeState, now time.Time) (reason string, wait time.Duration) {
+	l := s.migrationLimiter
+	if l == nil {
+		return "", 0
+	}
+
+	if l.config.MaxConcurrentPerNode != 0 && ongoingMigrations(ns) >= l.config.MaxConcurrentPerNode {
+		return migrationLimitNodeConcurrency, migrationLimitRecheckInterval
+	}
+
+	if l.config.MaxConcurrent != 0 {
+		total := 0
+		for _, other := range s.nodes {
+			total += ongoingMigrations(other)
+		}
+		if total >= l.config.MaxConcurrent {
+			return migrationLimitClusterConcurrency, migrationLimitRecheckInterval
+		}
+	}
+
+	if wait := l.tryTake(now); wait != 0 {
+		return migrationLimitRate, wait
+	}
+
+	return "", 0
+}
+
+// checkMigrationLimitsIfNotStarted is like checkMigrationLimits, but skips the check if we've
+// already created the migration for the pod -- we retry creating it until we see it start, and
+// those retries shouldn't count against the limits again.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) checkMigrationLimitsIfNotStarted(ns *nodeState, podUID types.UID) (reason string, wait time.Duration) {
+	if _, started := ns.startingMigrations[podUID]; started {
+		return "", 0
+	}
+	return s.checkMigrationLimits(ns, time.Now())
+}
+
+// ongoingMigrations returns the number of migrations away from the node that are currently
+// running, or that we've created but haven't seen start yet.
+//
+// Migrations that weren't created by the plugin are also counted, because they use the same
+// network.
+func ongoingMigrations(ns *nodeState) int {
+	// Pods are removed from startingMigrations once we see that they're migrating, so there's no
+	// double-counting here.
+	count := len(ns.startingMigrations)
+	for _, pod := range ns.node.Pods() {
+		if pod.Migrating {
+			count += 1
+		}
+	}
+	return count
+}
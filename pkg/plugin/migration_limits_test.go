@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestMigrationLimits(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.MigrationLimits = &MigrationLimitsConfig{
+		MaxConcurrent:        3,
+		MaxConcurrentPerNode: 2,
+		PerMinute:            0,
+		Burst:                0,
+	}
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	now := time.Now()
+
+	newNode := func(name string, migrating int) *nodeState {
+		node := state.NodeStateFromParams(name, 10000, 40*1024*1024*1024, config.Watermark, nil)
+		for i := range migrating {
+			pod := preemptionTestPod(fmt.Sprintf("%s-vm-%d", name, i), 1000, true)
+			pod.Migrating = true
+			node.AddPod(pod)
+		}
+		ns := &nodeState{ //nolint:exhaustruct // only need the node and starting migrations
+			node:               node,
+			startingMigrations: make(map[types.UID]struct{}),
+		}
+		s.nodes[name] = ns
+		return ns
+	}
+
+	node1 := newNode("node-1", 1)
+	node2 := newNode("node-2", 0)
+
+	reason, _ := s.checkMigrationLimits(node1, now)
+	assert.Equal(t, "", reason)
+
+	// Migrations that were created but haven't started yet also count
+	node1.startingMigrations["pending"] = struct{}{}
+	reason, wait := s.checkMigrationLimits(node1, now)
+	assert.Equal(t, migrationLimitNodeConcurrency, reason)
+	assert.Equal(t, migrationLimitRecheckInterval, wait)
+
+	// ... but not against themselves
+	reason, _ = s.checkMigrationLimitsIfNotStarted(node1, "pending")
+	assert.Equal(t, "", reason)
+
+	reason, _ = s.checkMigrationLimits(node2, now)
+	assert.Equal(t, "", reason)
+
+	node2.startingMigrations["other"] = struct{}{}
+	reason, _ = s.checkMigrationLimits(node2, now)
+	assert.Equal(t, migrationLimitClusterConcurrency, reason)
+}
+
+func TestMigrationRateLimit(t *testing.T) {
+	start := time.Now()
+	l := newMigrationLimiter(&MigrationLimitsConfig{
+		MaxConcurrent:        0,
+		MaxConcurrentPerNode: 0,
+		PerMinute:            6,
+		Burst:                2,
+	}, start)
+
+	// The burst is available immediately
+	assert.Zero(t, l.tryTake(start))
+	assert.Zero(t, l.tryTake(start))
+
+	// ... and then we have to wait 10s for the next token
+	wait := l.tryTake(start)
+	assert.InDelta(t, 10*time.Second, wait, float64(time.Millisecond))
+	assert.NotZero(t, l.tryTake(start.Add(5*time.Second)))
+	assert.Zero(t, l.tryTake(start.Add(wait)))
+
+	// Tokens don't accumulate past the burst
+	later := start.Add(time.Hour)
+	assert.Zero(t, l.tryTake(later))
+	assert.Zero(t, l.tryTake(later))
+	assert.NotZero(t, l.tryTake(later))
+}
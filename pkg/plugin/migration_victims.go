@@ -0,0 +1,61 @@
+package plugin
+
+// Policies for choosing which VMs to migrate away from a node, as configured by
+// (Config).MigrationVictimPolicy.
+//
+// To add a new policy, add a MigrationVictimPolicy value in config.go and its comparison function
+// to migrationVictimPolicies.
+
+import (
+	"cmp"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// migrationVictimPolicies maps each valid MigrationVictimPolicy to a function that compares two
+// migration candidates, returning <0 iff the first should be migrated before the second.
+//
+// These are only applied between candidates with the same api.AnnotationMigrationCost (and, with
+// PriorityHandling, the same priority); cheaper VMs are always migrated first. Ties are broken by (state.Pod).BetterMigrationTargetThan.
+var migrationVictimPolicies = map[MigrationVictimPolicy]func(x, y state.Pod) int{
+	MigrationVictimSmallestFirst: func(x, y state.Pod) int {
+		return comparePodSize(x, y)
+	},
+	MigrationVictimLargestFirst: func(x, y state.Pod) int {
+		return comparePodSize(y, x)
+	},
+	MigrationVictimLeastRecentlyMigrated: func(_, _ state.Pod) int {
+		return 0 // only the tie-break applies
+	},
+	MigrationVictimLowestPriority: func(x, y state.Pod) int {
+		return cmp.Compare(x.Priority, y.Priority)
+	},
+}
+
+// migrationVictimOrder returns the function to sort migration candidates with, so that the ones
+// that should be migrated first come first.
+//
+// If the config has PriorityHandling set, lower-priority VMs always come first, before taking into
+// account the cost or the config's MigrationVictimPolicy.
+func migrationVictimOrder(cfg *Config) func(x, y state.Pod) int {
+	compare := migrationVictimPolicies[cfg.migrationVictimPolicy()]
+	byPriority := cfg.PriorityHandling != nil
+	return func(x, y state.Pod) int {
+		if c := cmp.Compare(x.Priority, y.Priority); byPriority && c != 0 {
+			return c
+		} else if c := cmp.Compare(x.MigrationCost, y.MigrationCost); c != 0 {
+			return c
+		} else if c := compare(x, y); c != 0 {
+			return c
+		}
+		return x.BetterMigrationTargetThan(y)
+	}
+}
+
+// comparePodSize compares the pods by their reserved CPU, and then by their reserved memory.
+func comparePodSize(x, y state.Pod) int {
+	return cmp.Or(
+		cmp.Compare(x.CPU.Reserved, y.CPU.Reserved),
+		cmp.Compare(x.Mem.Reserved, y.Mem.Reserved),
+	)
+}
@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestMigrationVictimOrder(t *testing.T) {
+	start := time.Now()
+	victim := func(name string, cpu vmv1.MilliCPU, age time.Duration, priority int32, cost uint64) state.Pod {
+		pod := preemptionTestPod(name, cpu, true)
+		pod.CreatedAt = start.Add(-age)
+		pod.Priority = priority
+		pod.MigrationCost = cost
+		return pod
+	}
+
+	candidates := []state.Pod{
+		victim("small-new-high", 1000, time.Minute, 100, 0),
+		victim("large-old-low", 4000, time.Hour, 0, 0),
+		victim("medium-older-low", 2000, 2*time.Hour, 0, 0),
+		victim("small-oldest-expensive", 1000, 3*time.Hour, 0, 10),
+	}
+
+	cases := []struct {
+		policy   MigrationVictimPolicy
+		expected []string
+	}{
+		{
+			policy:   MigrationVictimSmallestFirst,
+			expected: []string{"small-new-high", "medium-older-low", "large-old-low", "small-oldest-expensive"},
+		},
+		{
+			policy:   MigrationVictimLargestFirst,
+			expected: []string{"large-old-low", "medium-older-low", "small-new-high", "small-oldest-expensive"},
+		},
+		{
+			policy:   MigrationVictimLeastRecentlyMigrated,
+			expected: []string{"medium-older-low", "large-old-low", "small-new-high", "small-oldest-expensive"},
+		},
+		{
+			policy:   MigrationVictimLowestPriority,
+			expected: []string{"medium-older-low", "large-old-low", "small-new-high", "small-oldest-expensive"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			sorted := slices.Clone(candidates)
+			slices.SortFunc(sorted, migrationVictimOrder(&Config{MigrationVictimPolicy: c.policy})) //nolint:exhaustruct // only need the policy
+			var names []string
+			for _, pod := range sorted {
+				names = append(names, pod.Name)
+			}
+			assert.Equal(t, c.expected, names)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package plugin
+
+// Asking VMs to downscale when their node is under memory pressure, as configured by
+// (Config).NodePressureDownscale.
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// nodePressureResponse returns the NodePressureResponse to send to the autoscaler-agent for the
+// pod, or nil if the pod's node isn't under memory pressure or its VM wasn't selected to downscale.
+//
+// The upper bound is calculated from the pod's reserved resources the first time it's selected
+// while the node is under memory pressure, and stays the same until the pressure ends.
+func (s *PluginState) nodePressureResponse(
+	logger *zap.Logger,
+	podObj *corev1.Pod,
+	computeUnit api.Resources,
+) *api.NodePressureResponse {
+	config := s.config().NodePressureDownscale
+	if config == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns, ok := s.nodes[podObj.Spec.NodeName]
+	if !ok || !ns.memoryPressure {
+		return nil
+	}
+	pod, ok := ns.node.GetPod(podObj.UID)
+	if !ok {
+		return nil
+	}
+
+	if !slices.Contains(s.leastActiveVMs(ns, config.MaxVMsPerNode), podObj.UID) {
+		return nil
+	}
+
+	current := api.Resources{VCPU: pod.CPU.Reserved, Mem: pod.Mem.Reserved}
+	maxResources, ok := ns.pressureCaps[podObj.UID]
+	if !ok {
+		maxResources = current.SaturatingSub(computeUnit.Mul(config.DownscaleCUs))
+		ns.pressureCaps[podObj.UID] = maxResources
+
+		logger.Info(
+			"Asking agent to downscale VM due to node memory pressure",
+			zap.Object("current", current),
+			zap.Object("maxResources", maxResources),
+		)
+		s.metrics.NodePressureDownscales.Inc()
+	}
+	return &api.NodePressureResponse{MaxResources: maxResources}
+}
+
+// leastActiveVMs returns the UIDs of up to n VM pods on the node with the lowest load average
+// relative to their CPU, as most recently reported by the autoscaler-agent. Pods that haven't
+// reported usage are excluded.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) leastActiveVMs(ns *nodeState, n int) []types.UID {
+	type candidate struct {
+		uid  types.UID
+		load float64
+	}
+
+	var candidates []candidate
+	for uid, pod := range ns.node.Pods() {
+		if lo.IsEmpty(pod.VirtualMachine) || pod.CPU.Reserved == 0 {
+			continue
+		}
+		usage, ok := s.podUsage[uid]
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			uid:  uid,
+			load: float64(usage.LoadAverage1Min) * 1000 / float64(pod.CPU.Reserved),
+		})
+	}
+
+	// Sort by load, with the UID as a tie-breaker so that the selection is stable.
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(a.load, b.load), cmp.Compare(a.uid, b.uid))
+	})
+
+	return lo.Map(candidates[:min(n, len(candidates))], func(c candidate, _ int) types.UID {
+		return c.uid
+	})
+}
@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestLeastActiveVMs(t *testing.T) {
+	type vm struct {
+		name string
+		cpu  vmv1.MilliCPU
+		// load is the reported LoadAverage1Min, or negative if no usage was reported
+		load float32
+		// notVM, if true, makes the pod not belong to a VM
+		notVM bool
+	}
+
+	cases := []struct {
+		name     string
+		vms      []vm
+		n        int
+		expected []types.UID
+	}{
+		{
+			name:     "no pods",
+			vms:      nil,
+			n:        2,
+			expected: []types.UID{},
+		},
+		{
+			name: "relative to CPU",
+			vms: []vm{
+				{name: "vm-a", cpu: 1000, load: 0.5, notVM: false}, // 0.5 per CPU
+				{name: "vm-b", cpu: 4000, load: 1, notVM: false},   // 0.25 per CPU
+				{name: "vm-c", cpu: 2000, load: 1.5, notVM: false}, // 0.75 per CPU
+			},
+			n:        2,
+			expected: []types.UID{"vm-b", "vm-a"},
+		},
+		{
+			name: "fewer than n",
+			vms: []vm{
+				{name: "vm-a", cpu: 1000, load: 0.5, notVM: false},
+			},
+			n:        3,
+			expected: []types.UID{"vm-a"},
+		},
+		{
+			name: "ties broken by UID",
+			vms: []vm{
+				{name: "vm-c", cpu: 1000, load: 0, notVM: false},
+				{name: "vm-a", cpu: 1000, load: 0, notVM: false},
+				{name: "vm-b", cpu: 1000, load: 0, notVM: false},
+			},
+			n:        2,
+			expected: []types.UID{"vm-a", "vm-b"},
+		},
+		{
+			name: "pods without usage or without VMs are excluded",
+			vms: []vm{
+				{name: "vm-a", cpu: 1000, load: -1, notVM: false},
+				{name: "pod-b", cpu: 1000, load: 0, notVM: true},
+				{name: "vm-c", cpu: 1000, load: 2, notVM: false},
+			},
+			n:        2,
+			expected: []types.UID{"vm-c"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := defaultBenchmarkConfig()
+			s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+			ns := &nodeState{ //nolint:exhaustruct // only need the node
+				node: state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil),
+			}
+			for _, vm := range c.vms {
+				ns.node.AddPod(preemptionTestPod(vm.name, vm.cpu, !vm.notVM))
+				if vm.load >= 0 {
+					s.podUsage[types.UID(vm.name)] = api.Metrics{
+						LoadAverage1Min:  vm.load,
+						LoadAverage5Min:  nil,
+						MemoryUsageBytes: nil,
+					}
+				}
+			}
+
+			assert.Equal(t, c.expected, s.leastActiveVMs(ns, c.n))
+		})
+	}
+}
+
+// pressureTestNode returns a Node object with 10 CPUs and 40 GiB of memory, with or without the
+// MemoryPressure condition.
+func pressureTestNode(pressure bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if pressure {
+		status = corev1.ConditionTrue
+	}
+	allocatable := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("10"),
+		corev1.ResourceMemory: resource.MustParse("40Gi"),
+	}
+	return &corev1.Node{ //nolint:exhaustruct // only need the name, resources, and conditions
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, //nolint:exhaustruct // only need the name
+		Status: corev1.NodeStatus{ //nolint:exhaustruct // only need the resources and conditions
+			Capacity:    allocatable,
+			Allocatable: allocatable,
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeMemoryPressure, Status: status}, //nolint:exhaustruct // only need the type and status
+			},
+		},
+	}
+}
+
+// pressureTestPodObj returns a Pod object on the node from pressureTestNode.
+func pressureTestPodObj(name string) *corev1.Pod {
+	return &corev1.Pod{ //nolint:exhaustruct // only need the UID and node
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)}, //nolint:exhaustruct // only need the name and UID
+		Spec:       corev1.PodSpec{NodeName: "node-1"},                                        //nolint:exhaustruct // only need the node
+	}
+}
+
+func TestNodePressureResponse(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	computeUnit := api.Resources{VCPU: 250, Mem: 1 * gib}
+
+	cases := []struct {
+		name     string
+		config   *NodePressureDownscaleConfig
+		pressure bool
+		pod      string
+		expected *api.NodePressureResponse
+	}{
+		{
+			name:     "disabled",
+			config:   nil,
+			pressure: true,
+			pod:      "vm-a",
+			expected: nil,
+		},
+		{
+			name:     "no pressure",
+			config:   &NodePressureDownscaleConfig{MaxVMsPerNode: 1, DownscaleCUs: 2},
+			pressure: false,
+			pod:      "vm-a",
+			expected: nil,
+		},
+		{
+			name:     "not selected",
+			config:   &NodePressureDownscaleConfig{MaxVMsPerNode: 1, DownscaleCUs: 2},
+			pressure: true,
+			pod:      "vm-b",
+			expected: nil,
+		},
+		{
+			name:     "selected",
+			config:   &NodePressureDownscaleConfig{MaxVMsPerNode: 1, DownscaleCUs: 2},
+			pressure: true,
+			pod:      "vm-a",
+			expected: &api.NodePressureResponse{MaxResources: api.Resources{VCPU: 1500, Mem: 2 * gib}},
+		},
+		{
+			name:     "downscale larger than VM",
+			config:   &NodePressureDownscaleConfig{MaxVMsPerNode: 1, DownscaleCUs: 16},
+			pressure: true,
+			pod:      "vm-a",
+			expected: &api.NodePressureResponse{MaxResources: api.Resources{VCPU: 0, Mem: 0}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := defaultBenchmarkConfig()
+			config.NodePressureDownscale = c.config
+			s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+			require.NoError(t, s.updateNode(zap.NewNop(), pressureTestNode(c.pressure), false))
+			ns := s.nodes["node-1"]
+
+			vmA := preemptionTestPod("vm-a", 2000, true)
+			vmA.Mem.Reserved = 4 * gib
+			vmB := preemptionTestPod("vm-b", 2000, true)
+			vmB.Mem.Reserved = 4 * gib
+			ns.node.AddPod(vmA)
+			ns.node.AddPod(vmB)
+			s.podUsage[vmA.UID] = api.Metrics{LoadAverage1Min: 0.1, LoadAverage5Min: nil, MemoryUsageBytes: nil}
+			s.podUsage[vmB.UID] = api.Metrics{LoadAverage1Min: 1.5, LoadAverage5Min: nil, MemoryUsageBytes: nil}
+
+			assert.Equal(t, c.expected, s.nodePressureResponse(zap.NewNop(), pressureTestPodObj(c.pod), computeUnit))
+		})
+	}
+}
+
+// The upper bound given to each VM is fixed for each episode of memory pressure, so that VMs aren't
+// asked to downscale by more each time their autoscaler-agent makes a request.
+func TestNodePressureResponseEpisodes(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	config := defaultBenchmarkConfig()
+	config.NodePressureDownscale = &NodePressureDownscaleConfig{MaxVMsPerNode: 1, DownscaleCUs: 2}
+	s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+	computeUnit := api.Resources{VCPU: 250, Mem: 1 * gib}
+
+	podObj := pressureTestPodObj("vm-a")
+
+	require.NoError(t, s.updateNode(zap.NewNop(), pressureTestNode(true), false))
+	ns := s.nodes["node-1"]
+
+	pod := preemptionTestPod("vm-a", 2000, true)
+	pod.Mem.Reserved = 4 * gib
+	ns.node.AddPod(pod)
+	s.podUsage[pod.UID] = api.Metrics{LoadAverage1Min: 0.1, LoadAverage5Min: nil, MemoryUsageBytes: nil}
+
+	// setReserved updates the pod's reserved resources, as if it had downscaled.
+	setReserved := func(cpu vmv1.MilliCPU, mem api.Bytes) {
+		oldPod, ok := ns.node.GetPod(pod.UID)
+		require.True(t, ok)
+		newPod := oldPod
+		newPod.CPU.Reserved = cpu
+		newPod.Mem.Reserved = mem
+		ns.node.UpdatePod(oldPod, newPod)
+	}
+
+	first := api.Resources{VCPU: 1500, Mem: 2 * gib}
+	resp := s.nodePressureResponse(zap.NewNop(), podObj, computeUnit)
+	assert.Equal(t, &api.NodePressureResponse{MaxResources: first}, resp)
+
+	// The VM downscales, but the node is still under pressure. It's not asked to go any lower.
+	setReserved(1500, 2*gib)
+	resp = s.nodePressureResponse(zap.NewNop(), podObj, computeUnit)
+	assert.Equal(t, &api.NodePressureResponse{MaxResources: first}, resp)
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.NodePressureDownscales))
+
+	// Once the pressure ends, the VM is free to scale again.
+	require.NoError(t, s.updateNode(zap.NewNop(), pressureTestNode(false), true))
+	assert.Nil(t, s.nodePressureResponse(zap.NewNop(), podObj, computeUnit))
+
+	// ... and a new episode of pressure starts from the VM's resources at that time.
+	require.NoError(t, s.updateNode(zap.NewNop(), pressureTestNode(true), true))
+	resp = s.nodePressureResponse(zap.NewNop(), podObj, computeUnit)
+	assert.Equal(t, &api.NodePressureResponse{MaxResources: api.Resources{VCPU: 1000, Mem: 0}}, resp)
+	assert.Equal(t, 2.0, testutil.ToFloat64(s.metrics.NodePressureDownscales))
+}
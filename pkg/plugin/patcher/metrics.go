@@ -0,0 +1,60 @@
+package patcher
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+type Metrics struct {
+	queued    prometheus.Gauge
+	queueWait prometheus.Histogram
+	attempts  *prometheus.CounterVec
+}
+
+// Values of the "outcome" label on the attempts metric
+const (
+	outcomeSuccess  = "success"
+	outcomeConflict = "conflict"
+	outcomeInvalid  = "invalid"
+	outcomeFailure  = "failure"
+	// outcomeTooSoon is used when the patch wasn't attempted, because the object was patched
+	// within MinInterval.
+	outcomeTooSoon = "too-soon"
+)
+
+// NewMetrics creates a new set of metrics for a Manager.
+//
+// The metrics will be registered with prometheus.Registerer, and all metric names will be prefixed
+// with the provided string.
+func NewMetrics(prefix string, reg prometheus.Registerer) Metrics {
+	return Metrics{
+		queued: util.RegisterMetric(reg, prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprint(prefix, "_queued_current"),
+				Help: "Number of patches currently waiting or in progress",
+			},
+		)),
+		queueWait: util.RegisterMetric(reg, prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name: fmt.Sprint(prefix, "_queue_wait_seconds"),
+				Help: "Duration that patches waited for earlier patches to the same object",
+				Buckets: []float64{
+					// 1ms, 5ms, 10ms, 50ms, 100ms, 250ms, 500ms, 750ms
+					0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 0.75,
+					// 1s, 2.5s, 5s, 10s, 20s, 45s
+					1.0, 2.5, 5, 10, 20, 45,
+				},
+			},
+		)),
+		attempts: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: fmt.Sprint(prefix, "_attempts_total"),
+				Help: "Number of patch attempts, labeled by outcome",
+			},
+			[]string{"outcome"},
+		)),
+	}
+}
@@ -0,0 +1,185 @@
+// Package patcher provides serialization of patches to individual objects, so that concurrent
+// patches to the same object from different code paths don't race with each other.
+package patcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+// PatchFunc applies the patches to the named object.
+type PatchFunc = func(util.NamespacedName, []patch.Operation) error
+
+type Config struct {
+	// MinInterval is the minimum duration between the end of one successful patch on an object and
+	// the start of the next, and the time after which a failed patch may be retried.
+	MinInterval time.Duration
+	// MaxAttempts is the maximum number of times in a row that patching an object may fail with a
+	// retryable error -- a conflict, or a failed "test" precondition -- before we stop asking the
+	// caller to retry.
+	//
+	// Values less than 1 are treated as 1.
+	MaxAttempts int
+}
+
+// RetryAfterError is returned by (*Manager).Patch when the patch was not applied, but is expected
+// to succeed if it's recomputed and tried again after RetryAfter.
+//
+// Patches are never retried by the Manager itself, because JSON patches with "test" preconditions
+// must be rebuilt from the latest state of the object before they can succeed.
+type RetryAfterError struct {
+	RetryAfter time.Duration
+	// Err is the error from patching the object, or nil if the object was patched too recently to
+	// try again.
+	Err error
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("object was patched too recently, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("%s (retry after %s)", e.Err, e.RetryAfter)
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// Manager serializes patches to each object, enforcing Config.MinInterval between them and
+// asking callers to retry on conflicts or failed preconditions, up to Config.MaxAttempts times in
+// a row.
+//
+// Patches to different objects may happen concurrently.
+type Manager struct {
+	config  Config
+	patch   PatchFunc
+	metrics Metrics
+
+	mu      sync.Mutex
+	objects map[util.NamespacedName]*object
+}
+
+type object struct {
+	// mu is held for the duration of each patch to the object, including retries.
+	mu sync.Mutex
+	// refs is the number of callers currently patching or waiting to patch the object.
+	//
+	// refs is guarded by (*Manager).mu.
+	refs int
+	// lastPatch is when the most recent successful patch finished.
+	//
+	// lastPatch is guarded by (*object).mu.
+	lastPatch time.Time
+	// failures is the number of patches in a row that failed with a retryable error.
+	//
+	// failures is guarded by (*object).mu.
+	failures int
+}
+
+func NewManager(config Config, patch PatchFunc, metrics Metrics) *Manager {
+	return &Manager{
+		config:  config,
+		patch:   patch,
+		metrics: metrics,
+		mu:      sync.Mutex{},
+		objects: make(map[util.NamespacedName]*object),
+	}
+}
+
+// Patch applies the patches to the object, after any other in-progress patches to the same object
+// have finished.
+//
+// Patch never waits for Config.MinInterval to pass. Instead, if the object was patched too
+// recently, or the patch failed in a way that may succeed if it's recomputed, Patch returns a
+// *RetryAfterError.
+func (m *Manager) Patch(name util.NamespacedName, patches []patch.Operation) error {
+	start := time.Now()
+
+	obj := m.acquire(name)
+	defer m.release(name, obj)
+
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+
+	m.metrics.queueWait.Observe(time.Since(start).Seconds())
+
+	if wait := time.Until(obj.lastPatch.Add(m.config.MinInterval)); wait > 0 {
+		m.metrics.attempts.WithLabelValues(outcomeTooSoon).Inc()
+		return &RetryAfterError{RetryAfter: wait, Err: nil}
+	}
+
+	err := m.patch(name, patches)
+	if err == nil {
+		m.metrics.attempts.WithLabelValues(outcomeSuccess).Inc()
+		obj.lastPatch = time.Now()
+		obj.failures = 0
+		return nil
+	}
+
+	switch {
+	case apierrors.IsConflict(err):
+		m.metrics.attempts.WithLabelValues(outcomeConflict).Inc()
+	case apierrors.IsInvalid(err):
+		// When a JSON patch "test" fails, the API server returns 422, which is represented as
+		// StatusReasonInvalid.
+		m.metrics.attempts.WithLabelValues(outcomeInvalid).Inc()
+	default:
+		m.metrics.attempts.WithLabelValues(outcomeFailure).Inc()
+		obj.failures = 0
+		return err
+	}
+
+	obj.failures += 1
+	if obj.failures >= max(m.config.MaxAttempts, 1) {
+		obj.failures = 0
+		return err
+	}
+	return &RetryAfterError{RetryAfter: m.config.MinInterval, Err: err}
+}
+
+func (m *Manager) acquire(name util.NamespacedName) *object {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[name]
+	if !ok {
+		obj = &object{
+			mu:        sync.Mutex{},
+			refs:      0,
+			lastPatch: time.Time{},
+			failures:  0,
+		}
+		m.objects[name] = obj
+	}
+	obj.refs += 1
+	m.metrics.queued.Inc()
+	return obj
+}
+
+func (m *Manager) release(name util.NamespacedName, obj *object) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj.refs -= 1
+	m.metrics.queued.Dec()
+
+	if obj.refs == 0 {
+		// Keep the object around until MinInterval has passed, so that the next patch still waits
+		// for it -- or, for a little longer, so that a retry after a failure is still counted
+		// towards MaxAttempts.
+		time.AfterFunc(2*m.config.MinInterval, func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			if obj.refs == 0 && m.objects[name] == obj {
+				delete(m.objects, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package patcher_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/patcher"
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+var vmName = util.NamespacedName{Namespace: "default", Name: "vm"}
+
+func conflictErr() error {
+	return apierrors.NewConflict(schema.GroupResource{Group: "vm.neon.tech", Resource: "virtualmachines"}, vmName.Name, errors.New("modified"))
+}
+
+func TestPatchesAreSerialized(t *testing.T) {
+	var inProgress, maxInProgress atomic.Int32
+	patchFn := func(util.NamespacedName, []patch.Operation) error {
+		current := inProgress.Add(1)
+		defer inProgress.Add(-1)
+		for {
+			prev := maxInProgress.Load()
+			if current <= prev || maxInProgress.CompareAndSwap(prev, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+
+	config := patcher.Config{MinInterval: 0, MaxAttempts: 1}
+	m := patcher.NewManager(config, patchFn, patcher.NewMetrics("test", prometheus.NewRegistry()))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, m.Patch(vmName, nil))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), maxInProgress.Load())
+}
+
+func invalidErr() error {
+	return apierrors.NewInvalid(schema.GroupKind{Group: "vm.neon.tech", Kind: "VirtualMachine"}, vmName.Name, nil)
+}
+
+func TestMinInterval(t *testing.T) {
+	var times []time.Time
+	patchFn := func(util.NamespacedName, []patch.Operation) error {
+		times = append(times, time.Now())
+		return nil
+	}
+
+	interval := 20 * time.Millisecond
+	config := patcher.Config{MinInterval: interval, MaxAttempts: 1}
+	m := patcher.NewManager(config, patchFn, patcher.NewMetrics("test", prometheus.NewRegistry()))
+
+	assert.NoError(t, m.Patch(vmName, nil))
+
+	// Patching again too soon doesn't wait, and doesn't patch:
+	start := time.Now()
+	err := m.Patch(vmName, nil)
+	assert.Less(t, time.Since(start), interval)
+	var retryErr *patcher.RetryAfterError
+	if assert.ErrorAs(t, err, &retryErr) {
+		assert.Nil(t, retryErr.Err)
+		assert.Positive(t, retryErr.RetryAfter)
+		assert.LessOrEqual(t, retryErr.RetryAfter, interval)
+		time.Sleep(retryErr.RetryAfter)
+	}
+	assert.Len(t, times, 1)
+
+	// ... but once the interval has passed, it's fine.
+	assert.NoError(t, m.Patch(vmName, nil))
+	assert.Len(t, times, 2)
+	assert.GreaterOrEqual(t, times[1].Sub(times[0]), interval)
+}
+
+func TestRetryableErrors(t *testing.T) {
+	cases := []struct {
+		name        string
+		err         func() error
+		failures    int
+		maxAttempts int
+		// wantRetries is the number of times that Patch should return a *RetryAfterError
+		wantRetries int
+		wantErr     bool
+	}{
+		{"no-conflicts", conflictErr, 0, 3, 0, false},
+		{"retry-succeeds", conflictErr, 2, 3, 2, false},
+		{"too-many-conflicts", conflictErr, 5, 3, 2, true},
+		{"no-retries", conflictErr, 1, 0, 0, true},
+		{"failed-precondition-retry-succeeds", invalidErr, 1, 3, 1, false},
+		{"too-many-failed-preconditions", invalidErr, 3, 2, 1, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			attempts := 0
+			patchFn := func(util.NamespacedName, []patch.Operation) error {
+				attempts += 1
+				if attempts <= c.failures {
+					return c.err()
+				}
+				return nil
+			}
+
+			config := patcher.Config{MinInterval: 0, MaxAttempts: c.maxAttempts}
+			m := patcher.NewManager(config, patchFn, patcher.NewMetrics("test", prometheus.NewRegistry()))
+
+			// Retry in the same way that callers would:
+			retries := 0
+			var err error
+			for {
+				err = m.Patch(vmName, nil)
+				var retryErr *patcher.RetryAfterError
+				if !errors.As(err, &retryErr) {
+					break
+				}
+				retries += 1
+				assert.Equal(t, c.err().Error(), retryErr.Err.Error())
+			}
+
+			assert.Equal(t, c.wantRetries, retries)
+			if c.wantErr {
+				assert.Equal(t, c.err().Error(), err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Other errors are not retried:
+	attempts := 0
+	patchFn := func(util.NamespacedName, []patch.Operation) error {
+		attempts += 1
+		return errors.New("something went wrong")
+	}
+	config := patcher.Config{MinInterval: 0, MaxAttempts: 3}
+	m := patcher.NewManager(config, patchFn, patcher.NewMetrics("test", prometheus.NewRegistry()))
+	err := m.Patch(vmName, nil)
+	assert.Error(t, err)
+	var retryErr *patcher.RetryAfterError
+	assert.False(t, errors.As(err, &retryErr))
+	assert.Equal(t, 1, attempts)
+}
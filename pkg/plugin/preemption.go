@@ -0,0 +1,450 @@
+package plugin
+
+// Preemption in PostFilter, if enabled: when a VM pod doesn't fit on any node, finding a node where
+// it would fit after moving some other pods off, nominating that node, and starting to move them.
+//
+// Pods in IgnoredNamespaces (e.g., overprovisioning placeholders) are preferred as victims, because
+// they exist to be evicted. Their resources aren't in our local state, but Filter counts them from
+// the scheduler's snapshot, so we do the same here. After those, the smallest migratable VMs are
+// chosen, because they're the quickest to migrate.
+//
+// The pod itself stays pending until the victims are gone. The scheduler keeps the nominated pod
+// in the node's set of pods when filtering others, so the space isn't immediately taken by
+// something else; and if it is, the pod just goes through PostFilter again.
+//
+// Like kube-scheduler's own PodEligibleToPreemptOthers, a pod that's already nominated for a node
+// doesn't preempt again while pods are still being moved off that node. Otherwise, each retry of
+// the pod would pick new victims -- because the previous ones are skipped once they're requested to
+// migrate -- and migrations would cascade across nodes.
+//
+// If PreemptionConfig.PrePullImages is enabled, the VM is also annotated to pre-pull its images
+// onto the nominated node, so that pulling them overlaps with moving the victims.
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	policyv1client "k8s.io/client-go/kubernetes/typed/policy/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+const (
+	preemptionActionEvict   = "evict"
+	preemptionActionMigrate = "migrate"
+)
+
+// preemptiblePod is a pod that could be moved off its node to make room for another.
+type preemptiblePod struct {
+	// obj is the Pod object, for pods in IgnoredNamespaces -- which are evicted. It's nil for VMs,
+	// which are migrated instead.
+	obj   *corev1.Pod
+	state state.Pod
+}
+
+// preemptionCandidate is a node where the pod would fit after moving the victims off it.
+type preemptionCandidate struct {
+	nodeName string
+	victims  []preemptiblePod
+}
+
+func (c preemptionCandidate) migrations() int {
+	count := 0
+	for _, v := range c.victims {
+		if v.obj == nil {
+			count += 1
+		}
+	}
+	return count
+}
+
+// betterThan returns whether c requires less disruption than other: fewer victims, or the same
+// number with fewer migrations.
+func (c preemptionCandidate) betterThan(other preemptionCandidate) bool {
+	if len(c.victims) != len(other.victims) {
+		return len(c.victims) < len(other.victims)
+	} else if c.migrations() != other.migrations() {
+		return c.migrations() < other.migrations()
+	}
+	return c.nodeName < other.nodeName // for determinism
+}
+
+// preempt tries to make room for the pod by evicting or migrating other pods, returning the
+// nominated node if successful.
+func (e *AutoscaleEnforcer) preempt(
+	logger *zap.Logger,
+	pod *corev1.Pod,
+	statuses framework.NodeToStatusMap,
+) *framework.PostFilterResult {
+	config := e.state.config()
+	if config.Preemption == nil || e.getNodeInfo == nil {
+		return nil
+	}
+	// Only VMs may preempt other pods.
+	if _, ok := vmv1.VirtualMachineOwnerForPod(pod); !ok {
+		return nil
+	}
+
+	if nodeName := pod.Status.NominatedNodeName; nodeName != "" && e.preemptionInFlight(nodeName) {
+		logger.Info(
+			"Not preempting again while pods are still being moved off the nominated Node",
+			logFieldForNodeName(nodeName),
+		)
+		e.state.metrics.Preemptions.WithLabelValues("in_flight").Inc()
+		return nil
+	}
+
+	podState, err := config.podStateFromK8sObj(pod)
+	if err != nil {
+		logger.Error("Error extracting local information for Pod", zap.Error(err))
+		return nil
+	}
+
+	// Collect the pods in IgnoredNamespaces before acquiring the lock -- they're only in the
+	// scheduler's snapshot.
+	ignoredPods := make(map[string][]preemptiblePod)
+	for nodeName, status := range statuses {
+		if !preemptionMayHelp(status) {
+			continue
+		}
+		nodeInfo, err := e.getNodeInfo(nodeName)
+		if err != nil {
+			logger.Warn("Could not get Node from scheduler snapshot", zap.String("NodeName", nodeName), zap.Error(err))
+			continue
+		}
+		ignoredPods[nodeName] = []preemptiblePod{}
+		for _, p := range nodeInfo.Pods {
+			if !e.state.ignoredNamespace(p.Pod.Namespace) {
+				continue
+			}
+			ps, err := config.podStateFromK8sObj(p.Pod)
+			if err != nil {
+				continue
+			}
+			ignoredPods[nodeName] = append(ignoredPods[nodeName], preemptiblePod{obj: p.Pod, state: ps})
+		}
+	}
+
+	candidate, ok := e.state.requestPreemption(logger, podState, ignoredPods, config.Preemption.MaxVictims)
+	if !ok {
+		logger.Info("No Node could make room for Pod by preemption")
+		e.state.metrics.Preemptions.WithLabelValues("no_candidate").Inc()
+		return nil
+	}
+
+	// Evictions happen outside the lock, so that we're not holding it during API calls. Migrations
+	// were requested by requestPreemption, and are created when the pods are reconciled.
+	for _, v := range candidate.victims {
+		if v.obj == nil {
+			continue
+		}
+		if err := e.state.evictPod(logger, v.obj); err != nil {
+			logger.Error("Failed to evict preemption victim", zap.Object("Victim", v.state.NamespacedName), zap.Error(err))
+		}
+	}
+
+	if config.DryRun {
+		logger.Info("Would nominate Node for Pod (dry run)", logFieldForNodeName(candidate.nodeName))
+		e.state.metrics.DryRunActions.WithLabelValues(dryRunActionNominateNode).Inc()
+		return nil
+	}
+
+	e.state.metrics.Preemptions.WithLabelValues("nominated").Inc()
+
+	if config.Preemption.PrePullImages {
+		if err := e.state.requestPrePull(podState.VirtualMachine, candidate.nodeName); err != nil {
+			// Pre-pulling is only an optimization, so don't let it get in the way of preemption.
+			logger.Warn("Failed to request pre-pull of VM images onto nominated Node", logFieldForNodeName(candidate.nodeName), zap.Error(err))
+		} else {
+			logger.Info("Requested pre-pull of VM images onto nominated Node", logFieldForNodeName(candidate.nodeName))
+		}
+	}
+
+	return framework.NewPostFilterResultWithNominatedNode(candidate.nodeName)
+}
+
+// requestPrePull sets the VM's vmv1.VirtualMachinePrePullNodesAnnotation to the node.
+func (s *PluginState) requestPrePull(vmName util.NamespacedName, nodeName string) error {
+	setAnnotation := patch.Operation{
+		Op:    patch.OpAdd,
+		Path:  fmt.Sprintf("/metadata/annotations/%s", patch.PathEscape(vmv1.VirtualMachinePrePullNodesAnnotation)),
+		Value: nodeName,
+	}
+
+	err := s.patchVM(vmName, []patch.Operation{setAnnotation})
+	if !apierrors.IsInvalid(err) {
+		return err
+	}
+
+	// Adding the annotation fails if the VM has no annotations at all. If so, create them.
+	return s.patchVM(vmName, []patch.Operation{
+		{
+			Op:    patch.OpTest,
+			Path:  "/metadata/annotations",
+			Value: (*struct{})(nil), // typed nil, so that it shows up as 'null'
+		},
+		{
+			Op:    patch.OpAdd,
+			Path:  "/metadata/annotations",
+			Value: struct{}{},
+		},
+		setAnnotation,
+	})
+}
+
+// preemptionInFlight returns whether pods are still being moved off the node: pods in
+// IgnoredNamespaces that are terminating, or VMs that have been requested to migrate or are
+// migrating.
+func (e *AutoscaleEnforcer) preemptionInFlight(nodeName string) bool {
+	if nodeInfo, err := e.getNodeInfo(nodeName); err == nil {
+		for _, p := range nodeInfo.Pods {
+			if p.Pod.DeletionTimestamp != nil && e.state.ignoredNamespace(p.Pod.Namespace) {
+				return true
+			}
+		}
+	}
+	return e.state.migrationsInFlight(nodeName)
+}
+
+// migrationsInFlight returns whether any VMs on the node have been requested to migrate, or are
+// migrating.
+func (s *PluginState) migrationsInFlight(nodeName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns, ok := s.nodes[nodeName]
+	if !ok {
+		return false
+	}
+	if len(ns.requestedMigrations) != 0 {
+		return true
+	}
+	for _, pod := range ns.node.Pods() {
+		if pod.Migrating {
+			return true
+		}
+	}
+	return false
+}
+
+// preemptionMayHelp returns whether moving pods off the node may allow the pod onto it, given the
+// node's status from Filter.
+//
+// We only consider nodes that we rejected for lack of resources. Other reasons -- including other
+// plugins' -- are left to those plugins (e.g., the default preemption plugin for pod priority).
+func preemptionMayHelp(status *framework.Status) bool {
+	return status.Code() == framework.Unschedulable &&
+		slices.Contains(status.Reasons(), filterReasonNotEnoughResources)
+}
+
+// requestPreemption finds the node that requires the least disruption to fit the pod, and requests
+// migration of the VMs that need to be moved off it.
+//
+// The pods in IgnoredNamespaces on each node to consider are given by ignoredPods.
+func (s *PluginState) requestPreemption(
+	logger *zap.Logger,
+	pod state.Pod,
+	ignoredPods map[string][]preemptiblePod,
+	maxVictims int,
+) (_ preemptionCandidate, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config := s.config()
+	disruptedGroups := s.disruptedGroups()
+
+	var best *preemptionCandidate
+	for nodeName, ignored := range ignoredPods {
+		ns, exists := s.nodes[nodeName]
+		if !exists {
+			continue
+		}
+
+		skipVM := func(p state.Pod) bool {
+			if _, requested := ns.requestedMigrations[p.UID]; requested {
+				return true
+			} else if !mayPreemptVM(config, pod, p) {
+				return true
+			}
+			group, hasGroup := disruptionGroupOf(p)
+			_, disrupted := disruptedGroups[group]
+			return hasGroup && disrupted
+		}
+
+		victims, fits := preemptionVictims(ns.node, pod, ignored, skipVM, maxVictims)
+		if !fits {
+			continue
+		}
+		c := preemptionCandidate{nodeName: nodeName, victims: victims}
+		if best == nil || c.betterThan(*best) {
+			best = &c
+		}
+	}
+
+	if best == nil {
+		return lo.Empty[preemptionCandidate](), false
+	}
+
+	ns := s.nodes[best.nodeName]
+	var names []string
+	for _, v := range best.victims {
+		names = append(names, fmt.Sprint(v.state.NamespacedName))
+		if v.obj != nil {
+			s.metrics.PreemptionVictims.WithLabelValues(preemptionActionEvict).Inc()
+			continue
+		}
+
+		if err := s.requeuePod(v.state.UID); err != nil {
+			logger.Error("Failed to requeue preemption victim", zap.Object("Victim", v.state.NamespacedName), zap.Error(err))
+			continue
+		}
+		ns.requestedMigrations[v.state.UID] = time.Now()
+		s.metrics.PreemptionVictims.WithLabelValues(preemptionActionMigrate).Inc()
+	}
+
+	logger.Info(
+		"Preempting pods to make room for Pod",
+		zap.Object("Node", ns.node),
+		zap.String("Victims", strings.Join(names, ", ")),
+		zap.Int("Migrations", best.migrations()),
+	)
+
+	return *best, true
+}
+
+// preemptionVictims returns the pods to move off the node so that the pod would fit on it, or false
+// if it wouldn't fit even after moving maxVictims pods.
+//
+// Pods in IgnoredNamespaces are taken first, largest first, so that we evict as few as possible.
+// Then migratable VMs are taken smallest first, skipping any for which skipVM returns true.
+func preemptionVictims(
+	node *state.Node,
+	pod state.Pod,
+	ignoredPods []preemptiblePod,
+	skipVM func(state.Pod) bool,
+	maxVictims int,
+) (victims []preemptiblePod, fits bool) {
+	node.Speculatively(func(tmpNode *state.Node) (commit bool) {
+		for _, p := range ignoredPods {
+			tmpNode.AddPod(p.state)
+		}
+		tmpNode.AddPod(pod)
+
+		// If the pod fits already, the node's state has changed since Filter. Preemption won't help
+		// -- the pod will just be retried.
+		if !tmpNode.OverBudget() {
+			return false
+		}
+
+		var ignored []preemptiblePod
+		for _, p := range ignoredPods {
+			if p.obj.DeletionTimestamp == nil {
+				ignored = append(ignored, p)
+			}
+		}
+		slices.SortFunc(ignored, func(x, y preemptiblePod) int {
+			return compareSizeOnNode(y.state, x.state, tmpNode)
+		})
+
+		var vms []preemptiblePod
+		for uid, p := range tmpNode.MigratablePods() {
+			if uid == pod.UID || p.Migrating || skipVM(p) {
+				continue
+			}
+			vms = append(vms, preemptiblePod{obj: nil, state: p})
+		}
+		slices.SortFunc(vms, func(x, y preemptiblePod) int {
+			return compareSizeOnNode(x.state, y.state, tmpNode)
+		})
+
+		for _, v := range slices.Concat(ignored, vms) {
+			if len(victims) == maxVictims {
+				break
+			}
+			if !reducesOverBudget(v.state, tmpNode) {
+				continue
+			}
+
+			tmpNode.RemovePod(v.state.UID)
+			victims = append(victims, v)
+			if !tmpNode.OverBudget() {
+				fits = true
+				break
+			}
+		}
+
+		return false // never commit; we're just using this for a temp node.
+	})
+
+	if !fits {
+		return nil, false
+	}
+	return victims, true
+}
+
+// reducesOverBudget returns whether removing the pod from the node would reduce the amount of CPU
+// or memory that's over its total.
+func reducesOverBudget(pod state.Pod, node *state.Node) bool {
+	return (node.CPU.Reserved > node.CPU.Total && pod.CPU.Reserved > 0) ||
+		(node.Mem.Reserved > node.Mem.Total && pod.Mem.Reserved > 0)
+}
+
+// compareSizeOnNode compares the pods by the sum of the fractions of the node's CPU and memory that
+// they have reserved.
+func compareSizeOnNode(x, y state.Pod, node *state.Node) int {
+	size := func(p state.Pod) float64 {
+		return float64(p.CPU.Reserved)/float64(node.CPU.Total) + float64(p.Mem.Reserved)/float64(node.Mem.Total)
+	}
+	sx, sy := size(x), size(y)
+	if sx < sy {
+		return -1
+	} else if sx > sy {
+		return 1
+	}
+	return strings.Compare(string(x.UID), string(y.UID))
+}
+
+// podEvicter returns the function used to evict pods in IgnoredNamespaces that were chosen as
+// preemption victims.
+func (s *PluginState) podEvicter(client policyv1client.EvictionsGetter) func(*zap.Logger, *corev1.Pod) error {
+	return func(logger *zap.Logger, pod *corev1.Pod) error {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Second*time.Duration(s.config().K8sCRUDTimeoutSeconds))
+		defer cancel()
+
+		eviction := &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // only setting the fields we need
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+			DeleteOptions: &metav1.DeleteOptions{ //nolint:exhaustruct // only setting the fields we need
+				// Only evict exactly the pod that we chose.
+				Preconditions: &metav1.Preconditions{
+					UID:             &pod.UID,
+					ResourceVersion: nil,
+				},
+			},
+		}
+
+		logger.Info("Evicting Pod to make room for preemption", zap.Object("Pod", util.GetNamespacedName(pod)))
+
+		start := time.Now()
+		err := client.Evictions(pod.Namespace).Evict(ctx, eviction)
+		s.apiHealth.Observe(time.Since(start), err)
+		s.metrics.RecordK8sOp("Evict", "Pod", pod.Name, err)
+		return err
+	}
+}
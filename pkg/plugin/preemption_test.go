@@ -0,0 +1,239 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+func preemptionTestPod(name string, cpu vmv1.MilliCPU, vm bool) state.Pod {
+	namespacedName := util.NamespacedName{Namespace: "default", Name: name}
+	var vmName util.NamespacedName
+	if vm {
+		vmName = namespacedName
+	}
+	return state.Pod{
+		NamespacedName:    namespacedName,
+		UID:               types.UID(name),
+		CreatedAt:         lo.Empty[time.Time](),
+		VirtualMachine:    vmName,
+		Migratable:        vm,
+		AlwaysMigrate:     false,
+		DisruptionGroup:   "",
+		Migrating:         false,
+		NetworkBandwidth:  0,
+		ExtendedResources: state.ExtendedResources{},
+		WarmPool:          false,
+		Priority:          0,
+		MigrationCost:     0,
+		CPU: state.PodResources[vmv1.MilliCPU]{
+			Reserved:             cpu,
+			Requested:            cpu,
+			Preapproved:          0,
+			PreapprovalRequested: 0,
+			Factor:               0,
+			Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+		},
+		Mem: state.PodResources[api.Bytes]{
+			Reserved:             0,
+			Requested:            0,
+			Preapproved:          0,
+			PreapprovalRequested: 0,
+			Factor:               0,
+			Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+		},
+	}
+}
+
+func TestPreemptionVictims(t *testing.T) {
+	ignoredPod := func(name string, cpu vmv1.MilliCPU) preemptiblePod {
+		return preemptiblePod{
+			obj:   &corev1.Pod{}, //nolint:exhaustruct // only checked for deletion
+			state: preemptionTestPod(name, cpu, false),
+		}
+	}
+
+	cases := []struct {
+		name        string
+		vms         []state.Pod
+		ignored     []preemptiblePod
+		skip        []types.UID
+		newPodCPU   vmv1.MilliCPU
+		maxVictims  int
+		expected    []types.UID
+		expectedFit bool
+	}{
+		{
+			name:        "ignored-pods-first",
+			vms:         []state.Pod{preemptionTestPod("vm-small", 1000, true), preemptionTestPod("vm-big", 2000, true)},
+			ignored:     []preemptiblePod{ignoredPod("overprovisioning", 1000)},
+			newPodCPU:   1000,
+			maxVictims:  3,
+			expected:    []types.UID{"overprovisioning"},
+			expectedFit: true,
+		},
+		{
+			name:        "smallest-vm",
+			vms:         []state.Pod{preemptionTestPod("vm-big", 2000, true), preemptionTestPod("vm-small", 1000, true)},
+			newPodCPU:   2000,
+			maxVictims:  3,
+			expected:    []types.UID{"vm-small"},
+			expectedFit: true,
+		},
+		{
+			name:        "multiple-vms",
+			vms:         []state.Pod{preemptionTestPod("vm-1", 1000, true), preemptionTestPod("vm-2", 1000, true), preemptionTestPod("vm-3", 1000, true)},
+			newPodCPU:   3000,
+			maxVictims:  3,
+			expected:    []types.UID{"vm-1", "vm-2"},
+			expectedFit: true,
+		},
+		{
+			name:        "too-many-victims",
+			vms:         []state.Pod{preemptionTestPod("vm-1", 1000, true), preemptionTestPod("vm-2", 1000, true), preemptionTestPod("vm-3", 1000, true)},
+			newPodCPU:   3000,
+			maxVictims:  1,
+			expected:    []types.UID{},
+			expectedFit: false,
+		},
+		{
+			name:        "skipped-vm",
+			vms:         []state.Pod{preemptionTestPod("vm-big", 2000, true), preemptionTestPod("vm-small", 1000, true)},
+			skip:        []types.UID{"vm-small"},
+			newPodCPU:   2000,
+			maxVictims:  3,
+			expected:    []types.UID{"vm-big"},
+			expectedFit: true,
+		},
+		{
+			name:        "not-migratable",
+			vms:         []state.Pod{preemptionTestPod("vm-1", 1000, true), preemptionTestPod("not-vm", 2000, false)},
+			newPodCPU:   3000,
+			maxVictims:  3,
+			expected:    []types.UID{},
+			expectedFit: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node := state.NodeStateFromParams("node-1", 4000, 16*1024*1024*1024, 0.9, nil)
+			for _, p := range c.vms {
+				node.AddPod(p)
+			}
+			skipVM := func(p state.Pod) bool {
+				return lo.Contains(c.skip, p.UID)
+			}
+
+			victims, fits := preemptionVictims(node, preemptionTestPod("new-vm", c.newPodCPU, true), c.ignored, skipVM, c.maxVictims)
+			assert.Equal(t, c.expectedFit, fits)
+			assert.Equal(t, c.expected, lo.Map(victims, func(v preemptiblePod, _ int) types.UID {
+				return v.state.UID
+			}))
+
+			// The node itself must not have been changed
+			assert.Equal(t, lo.SumBy(c.vms, func(p state.Pod) vmv1.MilliCPU { return p.CPU.Reserved }), node.CPU.Reserved)
+		})
+	}
+}
+
+func TestRequestPreemption(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.Preemption = &PreemptionConfig{MaxVictims: 2, PrePullImages: false}
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	var requeued []types.UID
+	s.requeuePod = func(uid types.UID) error {
+		requeued = append(requeued, uid)
+		return nil
+	}
+
+	// node-1 needs one migration; node-2 needs two. node-1 should be chosen.
+	for i, vmCPUs := range [][]vmv1.MilliCPU{{2000, 2000}, {1000, 1000, 2000}} {
+		name := fmt.Sprintf("node-%d", i+1)
+		node := state.NodeStateFromParams(name, 4000, 16*1024*1024*1024, 0.9, nil)
+		for j, cpu := range vmCPUs {
+			node.AddPod(preemptionTestPod(fmt.Sprintf("vm-%d-%d", i+1, j), cpu, true))
+		}
+		s.nodes[name] = &nodeState{ //nolint:exhaustruct // only need the node and requested migrations
+			node:                node,
+			requestedMigrations: make(map[types.UID]time.Time),
+		}
+	}
+
+	ignoredPods := map[string][]preemptiblePod{"node-1": nil, "node-2": nil}
+	candidate, ok := s.requestPreemption(zap.NewNop(), preemptionTestPod("new-vm", 2000, true), ignoredPods, 2)
+	assert.True(t, ok)
+	assert.Equal(t, "node-1", candidate.nodeName)
+	assert.Len(t, candidate.victims, 1)
+
+	victim := candidate.victims[0].state.UID
+	assert.Equal(t, []types.UID{victim}, requeued)
+	assert.Contains(t, s.nodes["node-1"].requestedMigrations, victim)
+	assert.Empty(t, s.nodes["node-2"].requestedMigrations)
+
+	// A pod nominated for node-1 must wait for the victim to be moved before preempting again.
+	assert.True(t, s.migrationsInFlight("node-1"))
+	assert.False(t, s.migrationsInFlight("node-2"))
+	assert.False(t, s.migrationsInFlight("unknown-node"))
+
+	// The VM that's now requested to migrate isn't chosen again.
+	candidate, ok = s.requestPreemption(zap.NewNop(), preemptionTestPod("new-vm-2", 2000, true), ignoredPods, 2)
+	assert.True(t, ok)
+	assert.Equal(t, "node-1", candidate.nodeName)
+	assert.Len(t, candidate.victims, 1)
+	assert.NotEqual(t, victim, candidate.victims[0].state.UID)
+}
+
+func TestRequestPrePull(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+	vmName := util.NamespacedName{Namespace: "default", Name: "vm-a"}
+	annotationPath := "/metadata/annotations/vm.neon.tech~1prepull-nodes"
+
+	// The VM has no annotations at first, so adding one fails and the annotations are created.
+	var patches [][]patch.Operation
+	s.patchVM = func(_ util.NamespacedName, p []patch.Operation) error {
+		patches = append(patches, p)
+		if len(patches) == 1 {
+			return apierrors.NewInvalid(schema.GroupKind{Group: "vm.neon.tech", Kind: "VirtualMachine"}, vmName.Name, nil)
+		}
+		return nil
+	}
+
+	assert.NoError(t, s.requestPrePull(vmName, "node-1"))
+	assert.Len(t, patches, 2)
+	assert.Equal(t, []patch.Operation{{Op: patch.OpAdd, Path: annotationPath, Value: "node-1"}}, patches[0])
+	assert.Len(t, patches[1], 3)
+	assert.Equal(t, patch.Operation{Op: patch.OpAdd, Path: annotationPath, Value: "node-1"}, patches[1][2])
+
+	// Other errors aren't retried.
+	patches = nil
+	s.patchVM = func(_ util.NamespacedName, p []patch.Operation) error {
+		patches = append(patches, p)
+		return errors.New("failed")
+	}
+	assert.Error(t, s.requestPrePull(vmName, "node-1"))
+	assert.Len(t, patches, 1)
+}
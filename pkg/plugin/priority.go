@@ -0,0 +1,38 @@
+package plugin
+
+// Handling of pod priority, as configured by (Config).PriorityHandling.
+//
+// Without it, every VM may use a node's resources up to its capacity, and priority is ignored. With
+// it, the room above each node's watermark is kept for high-priority VMs: low-priority VMs are only
+// placed onto nodes where they'd stay below the watermark, while high-priority VMs may go above it
+// -- which then causes lower-priority VMs to be migrated away first, and only lower-priority VMs to
+// be chosen as preemption victims.
+
+import (
+	"github.com/samber/lo"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// filterReasonLowPriorityAboveWatermark is the reason that Filter rejects a low-priority VM pod
+// from a node that it would take above the watermark.
+const filterReasonLowPriorityAboveWatermark = "Node would be above its watermark with low-priority Pod"
+
+// lowPriority returns whether the pod is a VM with priority below the HighPriorityThreshold of the
+// config's PriorityHandling. It always returns false if PriorityHandling isn't set.
+func (c Config) lowPriority(pod state.Pod) bool {
+	return c.PriorityHandling != nil && !lo.IsEmpty(pod.VirtualMachine) &&
+		pod.Priority < c.PriorityHandling.HighPriorityThreshold
+}
+
+// lowPriorityAboveWatermark returns whether the pod is low-priority, and the node (with the pod
+// added) is above its watermark -- in which case the pod must not be placed there.
+func lowPriorityAboveWatermark(cfg *Config, pod state.Pod, nodeWithPod *state.Node) bool {
+	return cfg.lowPriority(pod) && reservedAboveWatermark(nodeWithPod)
+}
+
+// mayPreemptVM returns whether the VM may be migrated to make room for the pod, based on their
+// priorities. If PriorityHandling is set, only VMs with lower priority than the pod may be.
+func mayPreemptVM(cfg *Config, pod state.Pod, victim state.Pod) bool {
+	return cfg.PriorityHandling == nil || victim.Priority < pod.Priority
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestPriorityHandling(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.Watermark = 0.8
+	config.PriorityHandling = &PriorityHandlingConfig{HighPriorityThreshold: 1000}
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	podWithPriority := func(name string, priority int32) state.Pod {
+		pod := preemptionTestPod(name, 2000, true)
+		pod.Priority = priority
+		return pod
+	}
+	low := podWithPriority("low", 0)
+	high := podWithPriority("high", 1000)
+
+	// 7000 reserved, with the pod: 9000 -- above the watermark of 8000
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+	node.AddPod(preemptionTestPod("existing", 7000, true))
+
+	for _, c := range []struct {
+		pod      state.Pod
+		expected bool
+	}{
+		{low, true},
+		{high, false},
+	} {
+		node.Speculatively(func(n *state.Node) (commit bool) {
+			n.AddPod(c.pod)
+			assert.Equal(t, c.expected, lowPriorityAboveWatermark(config, c.pod, n), c.pod.Name)
+			return false
+		})
+	}
+
+	// Non-VM pods are never rejected for being low priority
+	nonVM := preemptionTestPod("non-vm", 2000, false)
+	assert.False(t, config.lowPriority(nonVM))
+
+	// Lower-priority VMs are migrated first, even if they're more expensive
+	cheap := podWithPriority("cheap", 500)
+	expensive := podWithPriority("expensive", 0)
+	expensive.MigrationCost = 10
+	candidates := []state.Pod{high, cheap, expensive}
+	slices.SortFunc(candidates, migrationVictimOrder(config))
+	assert.Equal(t, []string{"expensive", "cheap", "high"}, []string{candidates[0].Name, candidates[1].Name, candidates[2].Name})
+
+	// Only lower-priority VMs may be preempted
+	assert.True(t, mayPreemptVM(config, high, low))
+	assert.False(t, mayPreemptVM(config, low, high))
+	assert.False(t, mayPreemptVM(config, low, low))
+
+	// Without PriorityHandling, priority is ignored
+	config.PriorityHandling = nil
+	assert.False(t, config.lowPriority(low))
+	assert.True(t, mayPreemptVM(config, low, high))
+}
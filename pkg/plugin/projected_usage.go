@@ -0,0 +1,88 @@
+package plugin
+
+// Scoring nodes by their usage after the VMs on them have scaled up, as configured by
+// (ScoringConfig).ProjectedUsageFactor.
+//
+// A VM that's placed at its minimum size may scale up to its maximum soon after, so scoring only by
+// what's currently reserved can fill a node that will be over the watermark minutes later. Instead,
+// each autoscaling VM on the node is counted as if it had already scaled to ProjectedUsageFactor of
+// its maximum (or its current size, if that's larger).
+//
+// This only affects scoring. Filter still checks whether there's room for the pod right now.
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/api/cuarith"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// podMaxResources returns the resources that the VM pod can scale up to, or nil if it's not a VM
+// with autoscaling enabled.
+func podMaxResources(pod *corev1.Pod) *api.Resources {
+	if _, ok := vmv1.VirtualMachineOwnerForPod(pod); !ok {
+		return nil
+	} else if !api.HasAutoscalingEnabled(pod) || vmv1.ScalingFrozenForPod(pod) {
+		return nil
+	}
+
+	res, err := vmv1.VirtualMachineResourcesFromPod(pod)
+	if err != nil {
+		return nil
+	}
+
+	return &api.Resources{
+		VCPU: res.CPUs.Max,
+		Mem:  cuarith.BytesFromSlots(uint64(res.MemorySlots.Max), api.BytesFromResourceQuantity(res.MemorySlotSize)),
+	}
+}
+
+// applyProjectedGrowth increases the reserved resources of the temporary node by how much its VMs
+// would grow if they each scaled to the config's ProjectedUsageFactor of their maximum, returning
+// the amount added.
+//
+// The pod being scored may not be in s.podMaxResources yet, so its maximum is given separately
+// (nil if it's not an autoscaling VM).
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) applyProjectedGrowth(
+	cfg ScoringConfig,
+	tmpNode *state.Node,
+	newPodUID types.UID,
+	newPodMax *api.Resources,
+) api.Resources {
+	var growth api.Resources
+	if cfg.ProjectedUsageFactor == 0 {
+		return growth
+	}
+
+	for uid, pod := range tmpNode.Pods() {
+		maxResources, ok := s.podMaxResources[uid]
+		if uid == newPodUID {
+			if newPodMax == nil {
+				continue
+			}
+			maxResources, ok = *newPodMax, true
+		}
+		if !ok {
+			continue
+		}
+
+		projectedCPU := vmv1.MilliCPU(cfg.ProjectedUsageFactor * float64(maxResources.VCPU))
+		projectedMem := api.Bytes(cfg.ProjectedUsageFactor * float64(maxResources.Mem))
+		growth.VCPU += util.SaturatingSub(projectedCPU, pod.CPU.Reserved)
+		growth.Mem += util.SaturatingSub(projectedMem, pod.Mem.Reserved)
+	}
+
+	// Cap at the node's total, so that the scoring strategies only see usage fractions up to 1.
+	growth.VCPU = min(growth.VCPU, util.SaturatingSub(tmpNode.CPU.Total, tmpNode.CPU.Reserved))
+	growth.Mem = min(growth.Mem, util.SaturatingSub(tmpNode.Mem.Total, tmpNode.Mem.Reserved))
+
+	tmpNode.CPU.Reserved += growth.VCPU
+	tmpNode.Mem.Reserved += growth.Mem
+	return growth
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+func TestApplyProjectedGrowth(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+
+	const gib = 1024 * 1024 * 1024
+	node := state.NodeStateFromParams("node-1", 10000, 40*gib, 0.9, nil)
+
+	nextID := 0
+	addVM := func(cpu vmv1.MilliCPU, mem api.Bytes) types.UID {
+		nextID += 1
+		name := util.NamespacedName{Namespace: "default", Name: fmt.Sprintf("vm-%d", nextID)}
+		uid := types.UID(fmt.Sprintf("pod-uid-%d", nextID))
+		node.AddPod(state.Pod{
+			NamespacedName:    name,
+			UID:               uid,
+			CreatedAt:         lo.Empty[time.Time](),
+			VirtualMachine:    name,
+			Migratable:        true,
+			AlwaysMigrate:     false,
+			DisruptionGroup:   "",
+			Migrating:         false,
+			NetworkBandwidth:  0,
+			ExtendedResources: state.ExtendedResources{},
+			WarmPool:          false,
+			Priority:          0,
+			MigrationCost:     0,
+			CPU: state.PodResources[vmv1.MilliCPU]{
+				Reserved:             cpu,
+				Requested:            cpu,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               0,
+				Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			},
+			Mem: state.PodResources[api.Bytes]{
+				Reserved:             mem,
+				Requested:            mem,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               0,
+				Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			},
+		})
+		return uid
+	}
+
+	// One small VM that can scale up a lot, one that's already at half of its max, and one that
+	// isn't autoscaling.
+	small := addVM(1000, 4*gib)
+	s.podMaxResources[small] = api.Resources{VCPU: 8000, Mem: 32 * gib}
+	half := addVM(2000, 8*gib)
+	s.podMaxResources[half] = api.Resources{VCPU: 4000, Mem: 16 * gib}
+	_ = addVM(1000, 4*gib)
+	// ... and the new VM, which isn't in podMaxResources yet.
+	newPod := addVM(500, 2*gib)
+	newPodMax := &api.Resources{VCPU: 2000, Mem: 8 * gib}
+
+	cfg := config.Scoring
+	node.Speculatively(func(tmp *state.Node) (commit bool) {
+		growth := s.applyProjectedGrowth(cfg, tmp, newPod, newPodMax)
+		assert.Equal(t, api.Resources{VCPU: 0, Mem: 0}, growth)
+		assert.Equal(t, node.CPU.Reserved, tmp.CPU.Reserved)
+		return false
+	})
+
+	// At half of max: small grows by 3000m / 12Gi, half doesn't grow, and the new VM grows by
+	// 500m / 2Gi.
+	cfg.ProjectedUsageFactor = 0.5
+	node.Speculatively(func(tmp *state.Node) (commit bool) {
+		growth := s.applyProjectedGrowth(cfg, tmp, newPod, newPodMax)
+		assert.Equal(t, api.Resources{VCPU: 3500, Mem: 14 * gib}, growth)
+		assert.Equal(t, node.CPU.Reserved+3500, tmp.CPU.Reserved)
+		assert.Equal(t, node.Mem.Reserved+14*gib, tmp.Mem.Reserved)
+		return false
+	})
+
+	// At full size, the growth is capped by the node's total
+	cfg.ProjectedUsageFactor = 1
+	node.Speculatively(func(tmp *state.Node) (commit bool) {
+		growth := s.applyProjectedGrowth(cfg, tmp, newPod, newPodMax)
+		assert.Equal(t, tmp.CPU.Total, tmp.CPU.Reserved)
+		assert.Equal(t, vmv1.MilliCPU(10000-4500), growth.VCPU)
+		return false
+	})
+}
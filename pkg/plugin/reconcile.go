@@ -3,15 +3,68 @@ package plugin
 import (
 	"context"
 	"fmt"
+	"maps"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
 	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// reconcileQueueMetricsInterval is how often the reconcile queue depth metrics are updated.
+const reconcileQueueMetricsInterval = 5 * time.Second
+
+// Priority classes for the reconcile queue, if the config's ReconcilePriorities is set.
+const (
+	reconcileClassDeletions = iota
+	reconcileClassPods
+	reconcileClassNodes
 )
 
-func (s *PluginState) reconcileQueueWaitCallback(duration time.Duration) {
-	s.metrics.Reconcile.WaitDurations.Observe(duration.Seconds())
+// reconcilePriorityClasses returns the reconcile.PriorityClasses for the config's
+// ReconcilePriorities, or a single class for everything if it's nil.
+func reconcilePriorityClasses(config *ReconcilePrioritiesConfig) reconcile.PriorityClasses {
+	if config == nil {
+		return reconcile.PriorityClasses{
+			Weights:  []int{1},
+			Classify: func(reconcile.EventKind, reconcile.Object) int { return 0 },
+		}
+	}
+
+	return reconcile.PriorityClasses{
+		Weights: []int{
+			reconcileClassDeletions: config.Deletions,
+			reconcileClassPods:      config.Pods,
+			reconcileClassNodes:     config.Nodes,
+		},
+		Classify: classifyReconcile,
+	}
+}
+
+// classifyReconcile returns the priority class for a change to the object.
+func classifyReconcile(k reconcile.EventKind, obj reconcile.Object) int {
+	if k == reconcile.EventKindDeleted || k == reconcile.EventKindEphemeral {
+		return reconcileClassDeletions
+	}
+	if _, ok := obj.(*corev1.Node); ok {
+		return reconcileClassNodes
+	}
+	return reconcileClassPods
+}
+
+func (s *PluginState) reconcileQueueWaitCallback(gvk schema.GroupVersionKind, duration time.Duration) {
+	s.metrics.Reconcile.WaitDurations.WithLabelValues(gvk.Kind).Observe(duration.Seconds())
+}
+
+func (s *PluginState) reconcileRetryCallback(gvk schema.GroupVersionKind, _ time.Duration) {
+	s.metrics.Reconcile.Retries.WithLabelValues(gvk.Kind).Inc()
 }
 
 func (s *PluginState) reconcileResultCallback(params reconcile.ObjectParams, duration time.Duration, err error) {
@@ -30,10 +83,28 @@ func (s *PluginState) reconcileErrorStatsCallback(logger *zap.Logger, params rec
 		WithLabelValues(params.GVK.Kind).
 		Set(float64(stats.TypedCount))
 
+	threshold := s.config().LogSuccessiveFailuresThreshold
+	aboveThreshold := stats.SuccessiveFailures >= threshold
+
+	s.mu.Lock()
+	s.failingReconciles[params.GVK.Kind] = stats.TypedCount
+	objects, ok := s.reconcilesAboveFailureThreshold[params.GVK.Kind]
+	if !ok {
+		objects = make(map[types.UID]struct{})
+		s.reconcilesAboveFailureThreshold[params.GVK.Kind] = objects
+	}
+	if aboveThreshold {
+		objects[params.UID] = struct{}{}
+	} else {
+		delete(objects, params.UID)
+	}
+	s.metrics.Reconcile.AboveFailureThreshold.WithLabelValues(params.GVK.Kind).Set(float64(len(objects)))
+	s.mu.Unlock()
+
 	// Make sure that repeatedly failing objects are sufficiently noisy
-	if stats.SuccessiveFailures >= s.config.LogSuccessiveFailuresThreshold {
+	if aboveThreshold {
 		logger.Warn(
-			fmt.Sprintf("%s has failed to reconcile >%d times in a row", params.GVK.Kind, s.config.LogSuccessiveFailuresThreshold),
+			fmt.Sprintf("%s has failed to reconcile >%d times in a row", params.GVK.Kind, threshold),
 			zap.Int("SuccessiveFailures", stats.SuccessiveFailures),
 			zap.String("EventKind", string(params.EventKind)),
 			reconcile.ObjectMetaLogField(params.GVK.Kind, params.Obj),
@@ -45,6 +116,162 @@ func (s *PluginState) reconcilePanicCallback(params reconcile.ObjectParams) {
 	s.metrics.Reconcile.Panics.WithLabelValues(params.GVK.Kind).Inc()
 }
 
+// runReconcileQueueMetrics periodically updates the metrics for the depth of the reconcile queue,
+// until the context is canceled.
+func (s *PluginState) runReconcileQueueMetrics(ctx context.Context, queues *reconcilePools) {
+	ticker := time.NewTicker(reconcileQueueMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		for gvk, stats := range queues.StatsByKind() {
+			s.metrics.Reconcile.QueueDepth.WithLabelValues(gvk.Kind, "queued").Set(float64(stats.Queued))
+			s.metrics.Reconcile.QueueDepth.WithLabelValues(gvk.Kind, "pending").Set(float64(stats.Pending))
+			s.metrics.Reconcile.QueueDepth.WithLabelValues(gvk.Kind, "ongoing").Set(float64(stats.Ongoing))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcileKind is a kind of object that the plugin reconciles, with its handler and options.
+type reconcileKind struct {
+	obj     reconcile.Object
+	handler reconcile.HandlerFunc
+	// config is the kind's options from the config's Reconcile section. It may be nil.
+	config *ReconcileKindConfig
+}
+
+// reconcilePool is a reconcile queue with its own pool of workers.
+type reconcilePool struct {
+	// name is the kind of object that the pool is for, or "shared".
+	name  string
+	size  int
+	queue *reconcile.Queue
+	// workers is nil until (*reconcilePools).startWorkers() is called.
+	workers *reconcileWorkerPool
+}
+
+// reconcilePools is the set of reconcile queues, each with its own pool of workers: one for each
+// kind of object with dedicated workers in the config's Reconcile section, and one shared by all
+// other kinds.
+type reconcilePools struct {
+	shared *reconcilePool
+	all    []*reconcilePool
+	byKind map[schema.GroupVersionKind]*reconcilePool
+}
+
+// newReconcilePools creates the reconcile queues for the kinds of objects, each with the options
+// returned by opts for its pool.
+//
+// The workers aren't started until startWorkers is called.
+func newReconcilePools(
+	kinds []reconcileKind,
+	opts func(*reconcilePool) []reconcile.QueueOption,
+) (*reconcilePools, error) {
+	shared := &reconcilePool{name: "shared", size: 0, queue: nil, workers: nil}
+	pools := &reconcilePools{
+		shared: shared,
+		all:    []*reconcilePool{shared},
+		byKind: make(map[schema.GroupVersionKind]*reconcilePool),
+	}
+
+	handlers := map[*reconcilePool]map[reconcile.Object]reconcile.HandlerFunc{
+		shared: make(map[reconcile.Object]reconcile.HandlerFunc),
+	}
+	backoffOpts := make(map[*reconcilePool][]reconcile.QueueOption)
+
+	for _, k := range kinds {
+		gvk, err := util.LookupGVKForType(k.obj)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := shared
+		if k.config != nil && k.config.Workers != 0 {
+			pool = &reconcilePool{name: gvk.Kind, size: k.config.Workers, queue: nil, workers: nil}
+			pools.all = append(pools.all, pool)
+			handlers[pool] = make(map[reconcile.Object]reconcile.HandlerFunc)
+		}
+		pools.byKind[gvk] = pool
+		handlers[pool][k.obj] = k.handler
+
+		if k.config != nil && k.config.Backoff != nil {
+			backoffOpts[pool] = append(backoffOpts[pool], reconcile.WithBackoffPolicy(k.obj, reconcile.BackoffPolicy{
+				Initial: time.Millisecond * time.Duration(k.config.Backoff.InitialMillis),
+				Max:     time.Second * time.Duration(k.config.Backoff.MaxSeconds),
+				Jitter:  k.config.Backoff.Jitter,
+			}))
+		}
+	}
+
+	for _, pool := range pools.all {
+		var err error
+		pool.queue, err = reconcile.NewQueue(handlers[pool], append(opts(pool), backoffOpts[pool]...)...)
+		if err != nil {
+			return nil, fmt.Errorf("could not setup %s reconcile queue: %w", pool.name, err)
+		}
+	}
+
+	return pools, nil
+}
+
+// queueFor returns the queue that objects with the same type as obj should be added to.
+func (p *reconcilePools) queueFor(obj reconcile.Object) *reconcile.Queue {
+	gvk, err := util.LookupGVKForType(obj)
+	if err != nil {
+		panic(fmt.Errorf("could not get GVK for %T: %w", obj, err))
+	}
+	pool, ok := p.byKind[gvk]
+	if !ok {
+		panic(fmt.Sprintf("no reconcile queue for object type %T", obj))
+	}
+	return pool.queue
+}
+
+// startWorkers starts the workers for each queue, and the autoscaling of the shared pool if the
+// config's ReconcileWorkerAutoscaling is set.
+func (p *reconcilePools) startWorkers(ctx context.Context, logger *zap.Logger, config *Config, gauge *prometheus.GaugeVec) {
+	p.shared.size = config.ReconcileWorkers
+
+	for _, pool := range p.all {
+		poolLogger := logger
+		if pool != p.shared {
+			poolLogger = logger.With(zap.String("pool", pool.name))
+		}
+		pool.workers = newReconcileWorkerPool(ctx, poolLogger, pool.queue, gauge.WithLabelValues(pool.name))
+		pool.workers.setWorkers(pool.size)
+	}
+
+	if config.ReconcileWorkerAutoscaling != nil {
+		go p.shared.workers.runAutoscaling(config.ReconcileWorkers, *config.ReconcileWorkerAutoscaling)
+	}
+}
+
+// Stats returns the total number of objects at each stage of all of the queues.
+func (p *reconcilePools) Stats() reconcile.QueueStats {
+	var total reconcile.QueueStats
+	for _, pool := range p.all {
+		stats := pool.queue.Stats()
+		total.Queued += stats.Queued
+		total.Pending += stats.Pending
+		total.Ongoing += stats.Ongoing
+	}
+	return total
+}
+
+// StatsByKind returns the number of objects at each stage of the queues, for each kind of object.
+func (p *reconcilePools) StatsByKind() map[schema.GroupVersionKind]reconcile.QueueStats {
+	byKind := make(map[schema.GroupVersionKind]reconcile.QueueStats)
+	for _, pool := range p.all {
+		maps.Copy(byKind, pool.queue.StatsByKind())
+	}
+	return byKind
+}
+
 func reconcileWorker(ctx context.Context, logger *zap.Logger, queue *reconcile.Queue) {
 	wait := queue.WaitChan()
 	for {
@@ -66,3 +293,108 @@ func reconcileWorker(ctx context.Context, logger *zap.Logger, queue *reconcile.Q
 		}
 	}
 }
+
+// reconcileWorkerPool runs a variable number of reconcileWorkers for the queue.
+type reconcileWorkerPool struct {
+	ctx    context.Context
+	logger *zap.Logger
+	queue  *reconcile.Queue
+	gauge  prometheus.Gauge
+
+	mu sync.Mutex
+	// cancels has the function to stop each currently running worker
+	cancels []context.CancelFunc
+	// maxWait is the longest queue wait duration observed since the last adjustment
+	maxWait time.Duration
+}
+
+func newReconcileWorkerPool(
+	ctx context.Context,
+	logger *zap.Logger,
+	queue *reconcile.Queue,
+	gauge prometheus.Gauge,
+) *reconcileWorkerPool {
+	return &reconcileWorkerPool{
+		ctx:     ctx,
+		logger:  logger,
+		queue:   queue,
+		gauge:   gauge,
+		mu:      sync.Mutex{},
+		cancels: nil,
+		maxWait: 0,
+	}
+}
+
+func (p *reconcileWorkerPool) observeWait(duration time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxWait = max(p.maxWait, duration)
+}
+
+// setWorkers starts or stops workers so that there are exactly count of them running.
+//
+// Stopped workers finish the item they're currently reconciling, if any.
+func (p *reconcileWorkerPool) setWorkers(count int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.cancels) < count {
+		ctx, cancel := context.WithCancel(p.ctx)
+		p.cancels = append(p.cancels, cancel)
+		go reconcileWorker(ctx, p.logger, p.queue)
+	}
+	for len(p.cancels) > count {
+		last := len(p.cancels) - 1
+		p.cancels[last]()
+		p.cancels = p.cancels[:last]
+	}
+
+	p.gauge.Set(float64(count))
+}
+
+// runAutoscaling periodically adjusts the number of workers between minWorkers and
+// config.MaxWorkers, based on the longest queue wait duration in each interval, until the pool's
+// context is canceled.
+func (p *reconcileWorkerPool) runAutoscaling(minWorkers int, config ReconcileWorkerAutoscalingConfig) {
+	threshold := time.Millisecond * time.Duration(config.QueueWaitThresholdMillis)
+	ticker := time.NewTicker(time.Second * time.Duration(config.AdjustIntervalSeconds))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		maxWait := p.maxWait
+		current := len(p.cancels)
+		p.maxWait = 0
+		p.mu.Unlock()
+
+		target := reconcileWorkersTarget(current, minWorkers, config.MaxWorkers, maxWait, threshold)
+		if target != current {
+			p.logger.Info(
+				"Adjusting number of reconcile workers",
+				zap.Int("current", current),
+				zap.Int("target", target),
+				zap.Duration("maxQueueWait", maxWait),
+			)
+			p.setWorkers(target)
+		}
+	}
+}
+
+// reconcileWorkersTarget returns the number of workers there should be, given the longest queue
+// wait duration since the last adjustment: doubling if it's above the threshold, and removing one
+// worker if it's well below.
+func reconcileWorkersTarget(current, minWorkers, maxWorkers int, maxWait, threshold time.Duration) int {
+	if maxWait > threshold {
+		return min(current*2, maxWorkers)
+	} else if maxWait < threshold/4 {
+		return max(current-1, minWorkers)
+	}
+	return current
+}
@@ -2,6 +2,7 @@ package reconcile
 
 import (
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -72,13 +73,14 @@ func applyMiddleware(middleware []Middleware, handler HandlerFunc) HandlerFunc {
 
 func defaultMiddleware(
 	types []schema.GroupVersionKind,
+	backoffPolicies map[schema.GroupVersionKind]BackoffPolicy,
 	resultCallback ResultCallback,
 	errorCallback ErrorStatsCallback,
 	panicCallback PanicCallback,
 ) []Middleware {
 	return []Middleware{
 		NewLogMiddleware(resultCallback),
-		NewErrorBackoffMiddleware(types, errorCallback),
+		NewErrorBackoffMiddleware(types, backoffPolicies, errorCallback),
 		NewCatchPanicMiddleware(panicCallback),
 	}
 }
@@ -228,6 +230,8 @@ type ErrorBackoffMiddleware struct {
 }
 
 type typedTimingSet struct {
+	policy BackoffPolicy
+
 	mu    sync.Mutex
 	byUID map[types.UID]backoff
 }
@@ -243,17 +247,58 @@ const (
 	maxErrorWait     = time.Minute
 )
 
+// BackoffPolicy sets how long the ErrorBackoffMiddleware waits before retrying failed reconcile
+// operations for a type of object.
+//
+// After the first failure, the wait is Initial. For each successive failure, it's roughly doubled,
+// up to Max.
+type BackoffPolicy struct {
+	Initial time.Duration
+	Max     time.Duration
+	// Jitter, if not zero, is the maximum fraction by which each wait is randomly lengthened or
+	// shortened, so that objects that failed together aren't all retried at the same time.
+	Jitter float64
+}
+
+// DefaultBackoffPolicy returns the BackoffPolicy that's used for types of objects that aren't
+// given one with the WithBackoffPolicy QueueOption.
+func DefaultBackoffPolicy() BackoffPolicy {
+	return BackoffPolicy{
+		Initial: initialErrorWait,
+		Max:     maxErrorWait,
+		Jitter:  0,
+	}
+}
+
+// withJitter returns the duration, randomly adjusted by up to the policy's Jitter
+func (p BackoffPolicy) withJitter(d time.Duration) time.Duration {
+	if p.Jitter == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
 // NewErrorBackoffMiddleware creates a new ErrorBackoffMiddleware, using the set of known types
-// provided and optionally a callback for observability.
+// provided, their backoff policies (if not the default), and optionally a callback for
+// observability.
 //
 // The callback is NOT assumed to be thread-safe.
-func NewErrorBackoffMiddleware(typs []schema.GroupVersionKind, callback ErrorStatsCallback) *ErrorBackoffMiddleware {
+func NewErrorBackoffMiddleware(
+	typs []schema.GroupVersionKind,
+	policies map[schema.GroupVersionKind]BackoffPolicy,
+	callback ErrorStatsCallback,
+) *ErrorBackoffMiddleware {
 	byType := make(map[schema.GroupVersionKind]*typedTimingSet)
 
 	for _, gvk := range typs {
+		policy, ok := policies[gvk]
+		if !ok {
+			policy = DefaultBackoffPolicy()
+		}
 		byType[gvk] = &typedTimingSet{
-			mu:    sync.Mutex{},
-			byUID: make(map[types.UID]backoff),
+			policy: policy,
+			mu:     sync.Mutex{},
+			byUID:  make(map[types.UID]backoff),
 		}
 	}
 
@@ -288,9 +333,9 @@ func (m *ErrorBackoffMiddleware) Call(
 	if failed {
 		b.successiveFailures += 1
 		if wasFailing {
-			b.waitDuration = min(maxErrorWait, time.Duration(float64(b.waitDuration)*backoffFactor))
+			b.waitDuration = min(typed.policy.Max, time.Duration(float64(b.waitDuration)*backoffFactor))
 		} else {
-			b.waitDuration = initialErrorWait
+			b.waitDuration = typed.policy.Initial
 		}
 
 		if result.RetryAfter != 0 {
@@ -300,7 +345,7 @@ func (m *ErrorBackoffMiddleware) Call(
 			b.waitDuration = min(result.RetryAfter, b.waitDuration)
 		}
 		// use max(..) so that the backoff MUST be respected, but waits longer than it are allowed.
-		result.RetryAfter = max(result.RetryAfter, b.waitDuration)
+		result.RetryAfter = max(result.RetryAfter, typed.policy.withJitter(b.waitDuration))
 
 		typed.byUID[params.UID] = b
 		if !wasFailing {
@@ -317,7 +362,8 @@ func (m *ErrorBackoffMiddleware) Call(
 		}
 	}
 
-	if change != 0 {
+	// Every failure is reported, not just the first, so that SuccessiveFailures is kept up to date.
+	if change != 0 || failed {
 		m.globalCounterMu.Lock()
 		defer m.globalCounterMu.Unlock()
 
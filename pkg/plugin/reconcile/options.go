@@ -3,6 +3,8 @@ package reconcile
 import (
 	"context"
 	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // QueueOption customizes the behavior of NewQueue.
@@ -15,7 +17,10 @@ type QueueOption struct {
 type queueSettings struct {
 	baseContext    context.Context
 	middleware     []Middleware
+	priorities     PriorityClasses
+	backoff        map[Object]BackoffPolicy
 	waitCallback   QueueWaitDurationCallback
+	retryCallback  RetryCallback
 	resultCallback ResultCallback
 	errorCallback  ErrorStatsCallback
 	panicCallback  PanicCallback
@@ -23,9 +28,15 @@ type queueSettings struct {
 
 func defaultQueueSettings() *queueSettings {
 	return &queueSettings{
-		baseContext:    context.Background(),
-		middleware:     []Middleware{},
+		baseContext: context.Background(),
+		middleware:  []Middleware{},
+		priorities: PriorityClasses{
+			Weights:  []int{1},
+			Classify: func(EventKind, Object) int { return 0 },
+		},
+		backoff:        make(map[Object]BackoffPolicy),
 		waitCallback:   nil,
+		retryCallback:  nil,
 		resultCallback: nil,
 		errorCallback:  nil,
 		panicCallback:  nil,
@@ -54,9 +65,46 @@ func WithMiddleware(mw Middleware) QueueOption {
 	}
 }
 
+// PriorityClasses defines how the reconcile operations in a Queue are divided into classes, and how
+// the workers' time is shared between those classes.
+type PriorityClasses struct {
+	// Weights gives the relative share of reconcile operations that are taken from each class,
+	// when there are operations waiting in more than one of them. Each weight must be > 0.
+	Weights []int
+	// Classify returns the index in Weights of the class that a change to an object belongs to.
+	//
+	// It's called with the combined change each time an object is added to the queue, so the class
+	// of an object may change while it's waiting -- e.g. if it's deleted.
+	Classify func(EventKind, Object) int
+}
+
+// WithPriorityClasses sets the PriorityClasses for the Queue.
+//
+// By default, there is a single class, so all reconcile operations are handled in the order that
+// they're due.
+func WithPriorityClasses(classes PriorityClasses) QueueOption {
+	return QueueOption{
+		apply: func(s *queueSettings) {
+			s.priorities = classes
+		},
+	}
+}
+
+// WithBackoffPolicy sets the BackoffPolicy for failed reconcile operations on objects with the same
+// type as obj, which must be one of the types that the Queue has a handler for.
+//
+// By default, DefaultBackoffPolicy is used.
+func WithBackoffPolicy(obj Object, policy BackoffPolicy) QueueOption {
+	return QueueOption{
+		apply: func(s *queueSettings) {
+			s.backoff[obj] = policy
+		},
+	}
+}
+
 // QueueWaitDurationCallback represents the signature of the callback that may be provided to add
 // observability for how long items are waiting in the queue before being reconciled.
-type QueueWaitDurationCallback = func(time.Duration)
+type QueueWaitDurationCallback = func(gvk schema.GroupVersionKind, wait time.Duration)
 
 // WithQueueWaitDurationCallback sets the QueueWaitDurationCallback that will be called with the
 // wait time from the desired reconcile time whenever a reconcile operation starts.
@@ -68,6 +116,21 @@ func WithQueueWaitDurationCallback(cb QueueWaitDurationCallback) QueueOption {
 	}
 }
 
+// RetryCallback represents the signature of the callback that may be provided to add observability
+// for how often reconcile operations are retried.
+type RetryCallback = func(gvk schema.GroupVersionKind, retryAfter time.Duration)
+
+// WithRetryCallback sets the RetryCallback that will be called whenever a reconcile operation
+// finishes and the object is scheduled to be reconciled again -- either because the handler
+// requested it, or because the operation failed.
+func WithRetryCallback(cb RetryCallback) QueueOption {
+	return QueueOption{
+		apply: func(s *queueSettings) {
+			s.retryCallback = cb
+		},
+	}
+}
+
 // WithResultCallback sets the ResultCallback to provide to the LogMiddleware.
 //
 // It will be called after every reconcile operation completes with the relevant information about
@@ -2,6 +2,7 @@ package reconcile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -22,10 +23,10 @@ import (
 type Queue struct {
 	mu sync.Mutex
 
-	// queue is the changes that are due to be processed but have not yet been picked up by any
-	// workers.
-	queue queue.PriorityQueue[kv]
-	// queued stores the handles for objects in the queue. This is needed so that we can update the
+	// queues stores the changes that are due to be processed but have not yet been picked up by any
+	// workers, with one queue for each priority class.
+	queues []queue.PriorityQueue[kv]
+	// queued stores the handles for objects in the queues. This is needed so that we can update the
 	// objects while they're in the queue, rather than requeueing on each change we receive from the
 	// kubernetes API server.
 	queued map[Key]queue.ItemHandle[kv]
@@ -53,13 +54,23 @@ type Queue struct {
 	// NOTE: This field is immutable.
 	handlers map[schema.GroupVersionKind]HandlerFunc
 
+	// NOTE: This field is immutable.
+	priorities PriorityClasses
+	// credits stores the current credit of each priority class, for the weighted round-robin
+	// between classes in (*Queue).Next().
+	credits []int
+
 	// if not nil, a callback that records how long each item was waiting to be reconciled
 	queueWaitCallback QueueWaitDurationCallback
+	// if not nil, a callback that records each time an item is scheduled to be retried
+	retryCallback RetryCallback
 }
 
 type kv struct {
 	k Key
 	v value
+	// class is the index of the priority class that the item is queued in.
+	class int
 }
 
 // Key uniquely identifies a kubernetes object
@@ -100,6 +111,15 @@ func NewQueue(handlers map[Object]HandlerFunc, opts ...QueueOption) (*Queue, err
 		o.apply(settings)
 	}
 
+	if len(settings.priorities.Weights) == 0 {
+		return nil, errors.New("at least one priority class is required")
+	}
+	for i, weight := range settings.priorities.Weights {
+		if weight <= 0 {
+			return nil, fmt.Errorf("weight of priority class %d must be > 0", i)
+		}
+	}
+
 	types := []schema.GroupVersionKind{}
 	handlersByType := make(map[schema.GroupVersionKind]HandlerFunc)
 	for obj, handler := range handlers {
@@ -118,7 +138,25 @@ func NewQueue(handlers map[Object]HandlerFunc, opts ...QueueOption) (*Queue, err
 		types = append(types, gvk)
 	}
 
-	middleware := defaultMiddleware(types, settings.resultCallback, settings.errorCallback, settings.panicCallback)
+	backoffPolicies := make(map[schema.GroupVersionKind]BackoffPolicy)
+	for obj, policy := range settings.backoff {
+		gvk, err := util.LookupGVKForType(obj)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := handlersByType[gvk]; !ok {
+			return nil, fmt.Errorf("backoff policy for object type %T with GVK %q that has no handler", obj, fmtGVK(gvk))
+		}
+		backoffPolicies[gvk] = policy
+	}
+
+	middleware := defaultMiddleware(
+		types,
+		backoffPolicies,
+		settings.resultCallback,
+		settings.errorCallback,
+		settings.panicCallback,
+	)
 	middleware = append(middleware, settings.middleware...)
 
 	// Apply middleware to all handlers
@@ -133,11 +171,16 @@ func NewQueue(handlers map[Object]HandlerFunc, opts ...QueueOption) (*Queue, err
 	enqueuedSndr := util.NewBroadcaster()
 	enqueuedRcvr := enqueuedSndr.NewReceiver()
 
-	q := &Queue{
-		mu: sync.Mutex{},
-		queue: queue.New(func(x, y kv) bool {
+	queues := make([]queue.PriorityQueue[kv], len(settings.priorities.Weights))
+	for i := range queues {
+		queues[i] = queue.New(func(x, y kv) bool {
 			return x.v.isHigherPriority(y.v)
-		}),
+		})
+	}
+
+	q := &Queue{
+		mu:      sync.Mutex{},
+		queues:  queues,
 		queued:  make(map[Key]queue.ItemHandle[kv]),
 		pending: make(map[Key]value),
 		ongoing: make(map[Key]struct{}),
@@ -150,7 +193,11 @@ func NewQueue(handlers map[Object]HandlerFunc, opts ...QueueOption) (*Queue, err
 
 		handlers: enrichedHandlers,
 
+		priorities: settings.priorities,
+		credits:    make([]int, len(queues)),
+
 		queueWaitCallback: settings.waitCallback,
+		retryCallback:     settings.retryCallback,
 	}
 
 	go q.handleNotifications(ctx, next, enqueuedRcvr)
@@ -179,7 +226,7 @@ func (q *Queue) handleNotifications(ctx context.Context, next chan<- struct{}, e
 			q.mu.Lock()
 			defer q.mu.Unlock()
 
-			nextKV, ok := q.queue.Peek()
+			nextKV, ok := q.peekEarliest()
 			if !ok {
 				return
 			}
@@ -249,6 +296,57 @@ func (q *Queue) WaitChan() <-chan struct{} {
 	return q.next
 }
 
+// QueueStats gives the number of objects at each stage of a Queue, as returned by (*Queue).Stats()
+type QueueStats struct {
+	// Queued is the number of objects waiting to be reconciled, including ones that aren't due yet.
+	Queued int `json:"queued"`
+	// Pending is the number of objects with changes that can't be queued until their ongoing
+	// reconcile operation finishes.
+	Pending int `json:"pending"`
+	// Ongoing is the number of reconcile operations currently in progress.
+	Ongoing int `json:"ongoing"`
+}
+
+// Stats returns the current number of objects at each stage of the Queue
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return QueueStats{
+		Queued:  len(q.queued),
+		Pending: len(q.pending),
+		Ongoing: len(q.ongoing),
+	}
+}
+
+// StatsByKind returns the current number of objects at each stage of the Queue, separately for
+// each type of object. Every type that the Queue has a handler for is included.
+func (q *Queue) StatsByKind() map[schema.GroupVersionKind]QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := make(map[schema.GroupVersionKind]QueueStats, len(q.handlers))
+	for gvk := range q.handlers {
+		stats[gvk] = QueueStats{Queued: 0, Pending: 0, Ongoing: 0}
+	}
+	for k := range q.queued {
+		s := stats[k.GVK]
+		s.Queued += 1
+		stats[k.GVK] = s
+	}
+	for k := range q.pending {
+		s := stats[k.GVK]
+		s.Pending += 1
+		stats[k.GVK] = s
+	}
+	for k := range q.ongoing {
+		s := stats[k.GVK]
+		s.Ongoing += 1
+		stats[k.GVK] = s
+	}
+	return stats
+}
+
 func (v value) isHigherPriority(other value) bool {
 	return v.reconcileAt.Before(other.reconcileAt)
 }
@@ -308,15 +406,18 @@ func (q *Queue) Enqueue(eventKind EventKind, obj Object) {
 
 // Next returns a callback to execute the next waiting reconcile operation in the queue, or false if
 // there are none.
+//
+// If there are operations waiting in more than one priority class, the class is chosen by weighted
+// round-robin, so that each class gets a share of the workers proportional to its weight.
 func (q *Queue) Next() (_ ReconcileCallback, ok bool) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	kv, ok := q.queue.Peek()
-	if !ok || kv.v.reconcileAt.After(time.Now()) {
+	class, ok := q.chooseClass(time.Now())
+	if !ok {
 		return nil, false
 	}
-	q.queue.Pop()
+	kv, _ := q.queues[class].Pop()
 	delete(q.queued, kv.k)
 
 	// mark the item as ongoing, and then return it:
@@ -329,6 +430,61 @@ func (q *Queue) Next() (_ ReconcileCallback, ok bool) {
 	return callback, true
 }
 
+// peekEarliest returns the item across all priority classes that is due to be reconciled soonest.
+//
+// NOTE: this method assumes that the caller has acquired q.mu.
+func (q *Queue) peekEarliest() (_ kv, ok bool) {
+	var earliest kv
+	for _, pq := range q.queues {
+		item, itemOk := pq.Peek()
+		if itemOk && (!ok || item.v.isHigherPriority(earliest.v)) {
+			earliest = item
+			ok = true
+		}
+	}
+	return earliest, ok
+}
+
+// chooseClass returns the priority class that the next item should be taken from, out of those
+// with an item that's due to be reconciled, or false if there are none.
+//
+// Classes are chosen by smooth weighted round-robin: each time, every class with a waiting item
+// gains credit equal to its weight, and the class with the most credit is chosen and has its
+// credit reduced by the total weight of the waiting classes.
+//
+// NOTE: this method assumes that the caller has acquired q.mu.
+func (q *Queue) chooseClass(now time.Time) (class int, ok bool) {
+	totalWeight := 0
+	for i, pq := range q.queues {
+		item, itemOk := pq.Peek()
+		if !itemOk || item.v.reconcileAt.After(now) {
+			continue
+		}
+		weight := q.priorities.Weights[i]
+		totalWeight += weight
+		q.credits[i] += weight
+		if !ok || q.credits[i] > q.credits[class] {
+			class = i
+			ok = true
+		}
+	}
+	if ok {
+		q.credits[class] -= totalWeight
+	}
+	return class, ok
+}
+
+// push adds the item to the queue for its priority class.
+//
+// NOTE: this method assumes that the caller has acquired q.mu.
+func (q *Queue) push(k Key, v value) {
+	class := q.priorities.Classify(v.eventKind, v.object)
+	if class < 0 || class >= len(q.queues) {
+		panic(fmt.Sprintf("priority class %d out of range for %d classes", class, len(q.queues)))
+	}
+	q.queued[k] = q.queues[class].Push(kv{k: k, v: v, class: class})
+}
+
 // reconcile is the outermost function that is called in order to reconcile an object.
 //
 // It calls the outermost middleware, which in turn calls the next, and so forth, until the original
@@ -336,7 +492,7 @@ func (q *Queue) Next() (_ ReconcileCallback, ok bool) {
 func (q *Queue) reconcile(logger *zap.Logger, k Key, v value) {
 	if q.queueWaitCallback != nil {
 		wait := time.Since(v.reconcileAt)
-		q.queueWaitCallback(wait)
+		q.queueWaitCallback(k.GVK, wait)
 	}
 
 	// Noteworthy functionality that we don't need to worry about here:
@@ -350,6 +506,10 @@ func (q *Queue) reconcile(logger *zap.Logger, k Key, v value) {
 
 	requeue := result.RetryAfter != 0
 	if requeue {
+		if q.retryCallback != nil {
+			q.retryCallback(k.GVK, result.RetryAfter)
+		}
+
 		retryAt := time.Now().Add(result.RetryAfter)
 
 		// Now that we know when we're retrying, let's schedule that!
@@ -400,19 +560,16 @@ func (q *Queue) enqueuePendingChange(k Key, v value) {
 func (q *Queue) enqueueInactive(k Key, v value) {
 	// if there's already something in the queue, just merge with that:
 	if queuedHandle, ok := q.queued[k]; ok {
-		queuedHandle.Update(func(queuedValue *kv) {
-			queuedValue.v = queuedValue.v.mergeWithNewer(v)
-		})
-		// the value of reconcileAt for the item may have changed; we should notify just in case, so
-		// it's not waiting.
-		q.notifyEnqueued()
-		return
+		// The merged change may belong in a different priority class (e.g., if the object was
+		// deleted), so we always re-add it.
+		v = queuedHandle.Value().v.mergeWithNewer(v)
+		queuedHandle.Remove()
 	}
 
-	// ... otherwise, add it to the queue!
-	handle := q.queue.Push(kv{k, v})
-	q.queued[k] = handle
-	// and make sure that someone picks it up:
+	// add it to the queue!
+	q.push(k, v)
+	// and make sure that someone picks it up -- the value of reconcileAt for the item may have
+	// changed, so we should notify even if it was already queued.
 	q.notifyEnqueued()
 }
 
@@ -441,8 +598,7 @@ func (q *Queue) finishAndMaybeRequeue(k Key, v value, requeue bool) {
 
 	// now that everything has been cleared, we can actually add it to the queue, if desired
 	if requeue {
-		handle := q.queue.Push(kv{k, v})
-		q.queued[k] = handle
+		q.push(k, v)
 		// ... and make sure someone picks it up:
 		q.notifyEnqueued()
 	}
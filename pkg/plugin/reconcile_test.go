@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+)
+
+func TestReconcileFailureThresholdMetric(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.LogSuccessiveFailuresThreshold = 3
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+
+	params := func(uid string) reconcile.ObjectParams {
+		return reconcile.ObjectParams{ //nolint:exhaustruct // only need the object, kind, and UID
+			Obj: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: uid, Namespace: "default", UID: types.UID(uid)}},
+			GVK: schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			UID: types.UID(uid),
+		}
+	}
+	fail := func(uid string, successive int) {
+		s.reconcileErrorStatsCallback(zap.NewNop(), params(uid), reconcile.ErrorStats{
+			GlobalCount:        1,
+			TypedCount:         1,
+			SuccessiveFailures: successive,
+		})
+	}
+	aboveThreshold := func() float64 {
+		return testutil.ToFloat64(s.metrics.Reconcile.AboveFailureThreshold.WithLabelValues("Pod"))
+	}
+
+	fail("pod-a", 1)
+	fail("pod-a", 2)
+	assert.Equal(t, 0.0, aboveThreshold())
+	fail("pod-a", 3)
+	assert.Equal(t, 1.0, aboveThreshold())
+	fail("pod-b", 5)
+	fail("pod-a", 4)
+	assert.Equal(t, 2.0, aboveThreshold())
+
+	// Success resets the count for the object
+	fail("pod-a", 0)
+	assert.Equal(t, 1.0, aboveThreshold())
+}
+
+func TestReconcilePriorityClasses(t *testing.T) {
+	var handled []string
+	handler := func(_ *zap.Logger, k reconcile.EventKind, obj reconcile.Object) (reconcile.Result, error) {
+		switch {
+		case k == reconcile.EventKindDeleted:
+			handled = append(handled, "deletion")
+		case obj.GetObjectKind().GroupVersionKind().Kind == "Node":
+			handled = append(handled, "node")
+		default:
+			handled = append(handled, "pod")
+		}
+		return reconcile.Result{RetryAfter: 0}, nil
+	}
+	queue, err := reconcile.NewQueue(
+		map[reconcile.Object]reconcile.HandlerFunc{
+			&corev1.Node{}: handler,
+			&corev1.Pod{}:  handler,
+		},
+		reconcile.WithPriorityClasses(reconcilePriorityClasses(&ReconcilePrioritiesConfig{
+			Deletions: 1,
+			Pods:      2,
+			Nodes:     1,
+		})),
+	)
+	assert.NoError(t, err)
+	defer queue.Stop()
+
+	newNode := func(uid string) *corev1.Node {
+		return &corev1.Node{
+			TypeMeta:   metav1.TypeMeta{Kind: "Node", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid)},
+		}
+	}
+	newPod := func(uid string) *corev1.Pod {
+		return &corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid)},
+		}
+	}
+
+	// Enqueue the nodes first, so that they'd be handled first without priorities.
+	for _, uid := range []string{"node-1", "node-2", "node-3", "node-4"} {
+		queue.Enqueue(reconcile.EventKindModified, newNode(uid))
+	}
+	for _, uid := range []string{"pod-1", "pod-2", "pod-3", "pod-4"} {
+		queue.Enqueue(reconcile.EventKindModified, newPod(uid))
+	}
+	queue.Enqueue(reconcile.EventKindDeleted, newPod("deleted"))
+
+	for {
+		callback, ok := queue.Next()
+		if !ok {
+			break
+		}
+		callback(zap.NewNop())
+	}
+
+	// Pods get twice the share of nodes, and the deletion goes near the front.
+	assert.Equal(t, []string{
+		"pod", "deletion", "node", "pod", "pod", "node", "pod", "node", "node",
+	}, handled)
+}
+
+func TestReconcilePools(t *testing.T) {
+	handler := func(*zap.Logger, reconcile.EventKind, reconcile.Object) (reconcile.Result, error) {
+		return reconcile.Result{RetryAfter: 0}, nil
+	}
+	pools, err := newReconcilePools(
+		[]reconcileKind{
+			{obj: &corev1.Node{}, handler: handler, config: nil},
+			{obj: &corev1.Pod{}, handler: handler, config: &ReconcileKindConfig{
+				Workers: 2,
+				Backoff: &ReconcileBackoffConfig{InitialMillis: 50, MaxSeconds: 10, Jitter: 0.1},
+			}},
+		},
+		func(*reconcilePool) []reconcile.QueueOption { return nil },
+	)
+	assert.NoError(t, err)
+	for _, pool := range pools.all {
+		defer pool.queue.Stop()
+	}
+
+	// Pods get their own queue, and nodes use the shared one
+	assert.Len(t, pools.all, 2)
+	assert.Same(t, pools.shared.queue, pools.queueFor(&corev1.Node{}))
+	assert.NotSame(t, pools.shared.queue, pools.queueFor(&corev1.Pod{}))
+
+	pools.queueFor(&corev1.Pod{}).Enqueue(reconcile.EventKindAdded, &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{UID: "pod-1"},
+	})
+	assert.Equal(t, reconcile.QueueStats{Queued: 1, Pending: 0, Ongoing: 0}, pools.Stats())
+	byKind := pools.StatsByKind()
+	assert.Equal(t, 1, byKind[schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"}].Queued)
+	assert.Equal(t, 0, byKind[schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Node"}].Queued)
+}
+
+func TestReconcileWorkersTarget(t *testing.T) {
+	const threshold = 100 * time.Millisecond
+
+	cases := []struct {
+		name     string
+		current  int
+		maxWait  time.Duration
+		expected int
+	}{
+		{name: "above threshold", current: 4, maxWait: 150 * time.Millisecond, expected: 8},
+		{name: "above threshold, capped", current: 12, maxWait: 150 * time.Millisecond, expected: 16},
+		{name: "at max", current: 16, maxWait: time.Second, expected: 16},
+		{name: "at threshold", current: 4, maxWait: threshold, expected: 4},
+		{name: "between", current: 4, maxWait: 50 * time.Millisecond, expected: 4},
+		{name: "at quarter of threshold", current: 4, maxWait: 25 * time.Millisecond, expected: 4},
+		{name: "well below threshold", current: 4, maxWait: 10 * time.Millisecond, expected: 3},
+		{name: "idle", current: 4, maxWait: 0, expected: 3},
+		{name: "at min", current: 2, maxWait: 0, expected: 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, reconcileWorkersTarget(c.current, 2, 16, c.maxWait, threshold))
+		})
+	}
+}
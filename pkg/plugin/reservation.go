@@ -0,0 +1,191 @@
+package plugin
+
+// Releasing upscaling that was approved for a VM but never confirmed by its autoscaler-agent, as
+// configured by (Config).ReservationTTLSeconds.
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/api/cuarith"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util/patch"
+)
+
+// pendingReservation describes upscaling that's been reserved for a VM's pod, but not yet
+// confirmed by its autoscaler-agent.
+type pendingReservation struct {
+	// confirmed is the resources that are known to be in use by the VM.
+	confirmed api.Resources
+	// expiresAt is the time after which the unconfirmed upscaling should be released.
+	expiresAt time.Time
+}
+
+// reservationTTL returns the configured duration that unconfirmed upscaling is reserved for, or
+// nil if it's never released.
+func (s *PluginState) reservationTTL() *time.Duration {
+	ttlSeconds := s.config().ReservationTTLSeconds
+	if ttlSeconds == 0 {
+		return nil
+	}
+	ttl := time.Second * time.Duration(ttlSeconds)
+	return &ttl
+}
+
+// pendingReservationForPod returns the upscaling reserved for the pod that hasn't yet been
+// confirmed, or nil if there is none.
+func (s *PluginState) pendingReservationForPod(podObj *corev1.Pod, pod state.Pod) (*pendingReservation, error) {
+	if s.reservationTTL() == nil {
+		return nil, nil
+	}
+
+	expiresAt, err := api.ExtractReservationExpiry(podObj)
+	if err != nil || expiresAt == nil {
+		return nil, err
+	}
+	confirmed, err := api.ExtractConfirmedScaling(podObj)
+	if err != nil || confirmed == nil {
+		return nil, err
+	}
+
+	// The VM may have been patched without the autoscaler-agent confirming it (e.g., if it crashed
+	// just afterwards), so whatever the VM is currently using counts as confirmed as well.
+	res, err := vmv1.VirtualMachineResourcesFromPod(podObj)
+	if err != nil {
+		return nil, err
+	} else if res != nil {
+		*confirmed = confirmed.Max(api.Resources{
+			VCPU: res.CPUs.Use,
+			Mem:  cuarith.BytesFromSlots(uint64(res.MemorySlots.Use), api.BytesFromResourceQuantity(res.MemorySlotSize)),
+		})
+	}
+
+	reserved := api.Resources{
+		VCPU: pod.CPU.Reserved,
+		Mem:  pod.Mem.Reserved,
+	}
+	if !reserved.HasFieldGreaterThan(*confirmed) {
+		return nil, nil
+	}
+
+	return &pendingReservation{
+		confirmed: *confirmed,
+		expiresAt: *expiresAt,
+	}, nil
+}
+
+// releaseReservation returns the podUpdateResult to release the pod's expired pending
+// reservation, back down to the resources that are confirmed.
+//
+// Like the rest of reconcilePodResources, we don't reduce the resources reserved in the local
+// state until the patch to the VM has been observed.
+func (s *PluginState) releaseReservation(
+	logger *zap.Logger,
+	ns *nodeState,
+	podObj *corev1.Pod,
+	pod state.Pod,
+	pending pendingReservation,
+) *podUpdateResult {
+	now := time.Now()
+	if lastPatch, ok := ns.podsVMPatchedAt[pod.UID]; ok {
+		canRetryAt := lastPatch.Add(time.Second * time.Duration(s.config().PatchRetryWaitSeconds))
+		if now.Before(canRetryAt) {
+			retryAfter := canRetryAt.Sub(now)
+			return &podUpdateResult{
+				needsMoreResources: false,
+				afterUnlock:        nil,
+				retryAfter:         &retryAfter,
+			}
+		}
+	}
+
+	reserved := api.Resources{VCPU: pod.CPU.Reserved, Mem: pod.Mem.Reserved}
+	requested := api.Resources{VCPU: pod.CPU.Requested, Mem: pod.Mem.Requested}
+	released := reserved.Min(pending.confirmed)
+
+	s.metrics.ReservationsReleased.Inc()
+	logger.Warn(
+		"Releasing upscaling for Pod that wasn't confirmed in time",
+		zap.Object("Pod", pod),
+		zap.Object("confirmed", pending.confirmed),
+		zap.Time("expiresAt", pending.expiresAt),
+	)
+
+	ns.podsVMPatchedAt[pod.UID] = now
+
+	return &podUpdateResult{
+		needsMoreResources: false,
+		afterUnlock: func() error {
+			return s.patchReleasedReservation(logger, podObj, pod, released, requested.Min(released))
+		},
+		retryAfter: nil,
+	}
+}
+
+func (s *PluginState) patchReleasedReservation(
+	logger *zap.Logger,
+	oldPodObj *corev1.Pod,
+	pod state.Pod,
+	approved api.Resources,
+	requested api.Resources,
+) error {
+	marshalJSON := func(value any) string {
+		bs, err := json.Marshal(value)
+		if err != nil {
+			panic(fmt.Sprintf("failed to marshal value: %s", err))
+		}
+		return string(bs)
+	}
+
+	annotationPath := func(annotation string) string {
+		return fmt.Sprintf("/metadata/annotations/%s", patch.PathEscape(annotation))
+	}
+
+	// Only release the reservation if the autoscaler-agent hasn't asked for something new in the
+	// meantime, and we haven't made a new reservation.
+	patches := []patch.Operation{
+		{
+			Op:    patch.OpTest,
+			Path:  annotationPath(api.InternalAnnotationResourcesRequested),
+			Value: oldPodObj.Annotations[api.InternalAnnotationResourcesRequested],
+		},
+		{
+			Op:    patch.OpTest,
+			Path:  annotationPath(api.InternalAnnotationReservationExpiresAt),
+			Value: oldPodObj.Annotations[api.InternalAnnotationReservationExpiresAt],
+		},
+		{
+			Op:    patch.OpReplace,
+			Path:  annotationPath(api.InternalAnnotationResourcesRequested),
+			Value: marshalJSON(requested),
+		},
+		{
+			Op:    patch.OpReplace,
+			Path:  annotationPath(api.InternalAnnotationResourcesApproved),
+			Value: marshalJSON(approved),
+		},
+		{
+			Op:   patch.OpRemove,
+			Path: annotationPath(api.InternalAnnotationReservationExpiresAt),
+		},
+	}
+
+	if err := s.patchVM(pod.VirtualMachine, patches); err != nil {
+		if apierrors.IsInvalid(err) {
+			logger.Warn("Failed to patch VirtualMachine because preconditions failed", zap.Any("patches", patches), zap.Error(err))
+			return fmt.Errorf("local pod state doesn't match most recent VM state: %w", err)
+		}
+		logger.Error("Failed to patch VirtualMachine", zap.Any("patches", patches), zap.Error(err))
+		return err
+	}
+	logger.Info("Patched VirtualMachine to release unconfirmed upscaling", zap.Any("patches", patches))
+	return nil
+}
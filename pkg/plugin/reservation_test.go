@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestPendingReservationForPod(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	expiresAt := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+
+	marshal := func(value any) string {
+		bs, err := json.Marshal(value)
+		require.NoError(t, err)
+		return string(bs)
+	}
+	// vmUsing returns the annotation value for the VM's resources, using cpu and memory slots of
+	// 1 GiB.
+	vmUsing := func(cpu vmv1.MilliCPU, slots int32) string {
+		return marshal(vmv1.VirtualMachineResources{
+			CPUs:           vmv1.CPUs{Min: 1000, Max: 8000, Use: cpu},
+			MemorySlots:    vmv1.MemorySlots{Min: 1, Max: 16, Use: slots},
+			MemorySlotSize: resource.MustParse("1Gi"),
+		})
+	}
+
+	cases := []struct {
+		name        string
+		ttlSeconds  int
+		annotations map[string]string
+
+		expectErr bool
+		expected  *pendingReservation
+	}{
+		{
+			name:       "disabled",
+			ttlSeconds: 0,
+			annotations: map[string]string{
+				api.InternalAnnotationReservationExpiresAt: marshal(expiresAt),
+				api.InternalAnnotationResourcesConfirmed:   marshal(api.Resources{VCPU: 2000, Mem: 4 * gib}),
+			},
+			expectErr: false,
+			expected:  nil,
+		},
+		{
+			name:       "no expiry",
+			ttlSeconds: 30,
+			annotations: map[string]string{
+				api.InternalAnnotationResourcesConfirmed: marshal(api.Resources{VCPU: 2000, Mem: 4 * gib}),
+			},
+			expectErr: false,
+			expected:  nil,
+		},
+		{
+			name:       "nothing confirmed",
+			ttlSeconds: 30,
+			annotations: map[string]string{
+				api.InternalAnnotationReservationExpiresAt: marshal(expiresAt),
+			},
+			expectErr: false,
+			expected:  nil,
+		},
+		{
+			name:       "all confirmed",
+			ttlSeconds: 30,
+			annotations: map[string]string{
+				api.InternalAnnotationReservationExpiresAt: marshal(expiresAt),
+				api.InternalAnnotationResourcesConfirmed:   marshal(api.Resources{VCPU: 4000, Mem: 8 * gib}),
+			},
+			expectErr: false,
+			expected:  nil,
+		},
+		{
+			name:       "unconfirmed upscaling",
+			ttlSeconds: 30,
+			annotations: map[string]string{
+				api.InternalAnnotationReservationExpiresAt: marshal(expiresAt),
+				api.InternalAnnotationResourcesConfirmed:   marshal(api.Resources{VCPU: 2000, Mem: 4 * gib}),
+				vmv1.VirtualMachineResourcesAnnotation:     vmUsing(2000, 4),
+			},
+			expectErr: false,
+			expected: &pendingReservation{
+				confirmed: api.Resources{VCPU: 2000, Mem: 4 * gib},
+				expiresAt: expiresAt,
+			},
+		},
+		{
+			name:       "VM resources unknown",
+			ttlSeconds: 30,
+			annotations: map[string]string{
+				api.InternalAnnotationReservationExpiresAt: marshal(expiresAt),
+				api.InternalAnnotationResourcesConfirmed:   marshal(api.Resources{VCPU: 2000, Mem: 4 * gib}),
+			},
+			expectErr: false,
+			expected: &pendingReservation{
+				confirmed: api.Resources{VCPU: 2000, Mem: 4 * gib},
+				expiresAt: expiresAt,
+			},
+		},
+		{
+			name:       "VM already using the upscaling",
+			ttlSeconds: 30,
+			annotations: map[string]string{
+				api.InternalAnnotationReservationExpiresAt: marshal(expiresAt),
+				api.InternalAnnotationResourcesConfirmed:   marshal(api.Resources{VCPU: 2000, Mem: 4 * gib}),
+				vmv1.VirtualMachineResourcesAnnotation:     vmUsing(4000, 8),
+			},
+			expectErr: false,
+			expected:  nil,
+		},
+		{
+			name:       "VM using some of the upscaling",
+			ttlSeconds: 30,
+			annotations: map[string]string{
+				api.InternalAnnotationReservationExpiresAt: marshal(expiresAt),
+				api.InternalAnnotationResourcesConfirmed:   marshal(api.Resources{VCPU: 2000, Mem: 4 * gib}),
+				vmv1.VirtualMachineResourcesAnnotation:     vmUsing(3000, 4),
+			},
+			expectErr: false,
+			expected: &pendingReservation{
+				confirmed: api.Resources{VCPU: 3000, Mem: 4 * gib},
+				expiresAt: expiresAt,
+			},
+		},
+		{
+			name:       "invalid expiry",
+			ttlSeconds: 30,
+			annotations: map[string]string{
+				api.InternalAnnotationReservationExpiresAt: "soon",
+				api.InternalAnnotationResourcesConfirmed:   marshal(api.Resources{VCPU: 2000, Mem: 4 * gib}),
+			},
+			expectErr: true,
+			expected:  nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := defaultBenchmarkConfig()
+			config.ReservationTTLSeconds = c.ttlSeconds
+			s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+			pod := preemptionTestPod("vm-a", 4000, true)
+			pod.Mem.Reserved = 8 * gib
+			podObj := &corev1.Pod{ //nolint:exhaustruct // only need the annotations
+				ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // only need the annotations
+					Name:        "vm-a",
+					Namespace:   "default",
+					Annotations: c.annotations,
+				},
+			}
+
+			pending, err := s.pendingReservationForPod(podObj, pod)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, pending)
+		})
+	}
+}
+
+func TestReleaseReservation(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.ReservationTTLSeconds = 30
+	config.PatchRetryWaitSeconds = 5
+
+	cases := []struct {
+		name string
+		// patchedAgo is how long ago the VM was last patched, or nil if it hasn't been
+		patchedAgo *time.Duration
+
+		expectRetry   bool
+		expectRelease bool
+	}{
+		{name: "never patched", patchedAgo: nil, expectRetry: false, expectRelease: true},
+		{name: "patched long ago", patchedAgo: lo.ToPtr(time.Minute), expectRetry: false, expectRelease: true},
+		{name: "patched recently", patchedAgo: lo.ToPtr(time.Second), expectRetry: true, expectRelease: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+			pod := preemptionTestPod("vm-a", 4000, true)
+			ns := &nodeState{ //nolint:exhaustruct // only need the node and patch times
+				node:            state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil),
+				podsVMPatchedAt: make(map[types.UID]time.Time),
+			}
+			if c.patchedAgo != nil {
+				ns.podsVMPatchedAt[pod.UID] = time.Now().Add(-*c.patchedAgo)
+			}
+			podObj := &corev1.Pod{} //nolint:exhaustruct // not used before the patch
+			pending := pendingReservation{
+				confirmed: api.Resources{VCPU: 2000, Mem: 0},
+				expiresAt: time.Now().Add(-time.Second),
+			}
+
+			result := s.releaseReservation(zap.NewNop(), ns, podObj, pod, pending)
+			require.NotNil(t, result)
+			assert.False(t, result.needsMoreResources)
+
+			if c.expectRetry {
+				require.NotNil(t, result.retryAfter)
+				assert.Positive(t, *result.retryAfter)
+				assert.LessOrEqual(t, *result.retryAfter, 5*time.Second)
+			} else {
+				assert.Nil(t, result.retryAfter)
+			}
+
+			if c.expectRelease {
+				assert.NotNil(t, result.afterUnlock)
+				assert.Equal(t, 1.0, testutil.ToFloat64(s.metrics.ReservationsReleased))
+				assert.WithinDuration(t, time.Now(), ns.podsVMPatchedAt[pod.UID], time.Second)
+			} else {
+				assert.Nil(t, result.afterUnlock)
+				assert.Equal(t, 0.0, testutil.ToFloat64(s.metrics.ReservationsReleased))
+			}
+		})
+	}
+}
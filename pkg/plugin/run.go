@@ -18,6 +18,7 @@ import (
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/patcher"
 	"github.com/neondatabase/autoscaling/pkg/plugin/state"
 	"github.com/neondatabase/autoscaling/pkg/util"
 	"github.com/neondatabase/autoscaling/pkg/util/patch"
@@ -31,7 +32,7 @@ const (
 
 const (
 	MinPluginProtocolVersion api.PluginProtoVersion = api.PluginProtoV5_0
-	MaxPluginProtocolVersion api.PluginProtoVersion = api.PluginProtoV5_0
+	MaxPluginProtocolVersion api.PluginProtoVersion = api.PluginProtoV5_2
 )
 
 // startPermitHandler runs the server for handling each resourceRequest from a pod
@@ -69,6 +70,22 @@ func (s *PluginState) startPermitHandler(
 			return
 		}
 
+		api.SetComponentVersionHeader(w.Header(), api.ComponentSchedulerPlugin)
+		if skew := api.CheckPeerVersionHeader(
+			api.ComponentSchedulerPlugin, api.ComponentAutoscalerAgent, r.Header,
+		); skew != nil {
+			s.metrics.VersionSkew.WithLabelValues(skew.PeerVersion.String(), skew.Action()).Inc()
+			if skew.Refuse {
+				logger.Error("Refusing request from autoscaler-agent with incompatible version", zap.Error(skew))
+				w.Header().Add("Content-Type", ContentTypeError)
+				finalStatus = 400
+				w.WriteHeader(400)
+				_, _ = w.Write([]byte(skew.Error()))
+				return
+			}
+			logger.Warn("Received request from autoscaler-agent with unsupported version skew", zap.Error(skew))
+		}
+
 		defer r.Body.Close()
 		var req api.AgentRequest
 		jsonDecoder := json.NewDecoder(io.LimitReader(r.Body, MaxHTTPBodySize))
@@ -113,6 +130,48 @@ func (s *PluginState) startPermitHandler(
 		w.WriteHeader(statusCode)
 		_, _ = w.Write(responseBody)
 	})
+	// Debug endpoint, returning the config currently in use, after defaulting.
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("must be GET"))
+			return
+		}
+
+		body, err := json.Marshal(s.config())
+		if err != nil {
+			w.Header().Add("Content-Type", ContentTypeError)
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Add("Content-Type", ContentTypeJSON)
+		w.WriteHeader(200)
+		_, _ = w.Write(body)
+	})
+
+	// Debug endpoint, returning a summary of the current state of the cluster, for estimating the
+	// effects of config changes with configdiff.
+	mux.HandleFunc("/dump-state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("must be GET"))
+			return
+		}
+
+		body, err := json.Marshal(s.dumpState())
+		if err != nil {
+			w.Header().Add("Content-Type", ContentTypeError)
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Add("Content-Type", ContentTypeJSON)
+		w.WriteHeader(200)
+		_, _ = w.Write(body)
+	})
 
 	orca := srv.GetOrchestrator(ctx)
 
@@ -128,6 +187,106 @@ func (s *PluginState) startPermitHandler(
 	return nil
 }
 
+// serveReevaluate handles requests to re-evaluate all nodes. It's served by the debug server, behind
+// its bearer token.
+func (s *PluginState) serveReevaluate(logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("must be POST"))
+			return
+		}
+
+		count, err := s.requeueAllNodes(logger)
+		if err != nil {
+			w.Header().Add("Content-Type", ContentTypeError)
+			w.WriteHeader(500)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Add("Content-Type", ContentTypeJSON)
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"nodes":%d}`, count)))
+	}
+}
+
+// requeueAllNodes puts every node back into the reconcile queue, so that their watermark status
+// and any necessary migrations are re-evaluated immediately -- e.g., after many nodes were added.
+//
+// The usual limits still apply, because nodes are reconciled by the same bounded set of workers.
+func (s *PluginState) requeueAllNodes(logger *zap.Logger) (count int, _ error) {
+	s.mu.Lock()
+	nodeNames := make([]string, 0, len(s.nodes))
+	for name := range s.nodes {
+		nodeNames = append(nodeNames, name)
+	}
+	s.mu.Unlock()
+
+	logger.Info("Requeuing all nodes for re-evaluation", zap.Int("count", len(nodeNames)))
+
+	var errs []error
+	for _, name := range nodeNames {
+		if err := s.requeueNode(name); err != nil {
+			errs = append(errs, fmt.Errorf("could not requeue node %q: %w", name, err))
+			continue
+		}
+		count += 1
+	}
+
+	return count, errors.Join(errs...)
+}
+
+// approveWithinPreapproval returns whether the requested resources for the pod, which are within its
+// preapproved resources, can be approved immediately, rather than waiting for them to be reserved.
+//
+// The same limits apply as when reserving resources: the increase must be within the tenant's fair
+// share of the node, if the config's TenantFairness is enabled, and the full increase is taken from
+// the cluster-wide upscale rate limit, if there is one. If either would limit the increase, the
+// request is left to the usual path, which grants as much as they allow.
+func (s *PluginState) approveWithinPreapproval(
+	logger *zap.Logger,
+	podObj *corev1.Pod,
+	requested api.Resources,
+) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns, ok := s.nodes[podObj.Spec.NodeName]
+	if !ok {
+		return false
+	}
+	pod, ok := ns.node.GetPod(podObj.UID)
+	if !ok {
+		return false
+	}
+
+	desiredPod := pod
+	desiredPod.CPU.Reserved = max(pod.CPU.Reserved, requested.VCPU)
+	desiredPod.Mem.Reserved = max(pod.Mem.Reserved, requested.Mem)
+	if s.applyTenantFairness(logger, ns, pod, &desiredPod) {
+		return false
+	}
+
+	// Only take the part of the increase that wasn't already taken for an earlier request.
+	charged := s.preapprovalCharged[pod.UID]
+	cpuIncrease := util.SaturatingSub(desiredPod.CPU.Reserved, max(pod.CPU.Reserved, charged.VCPU))
+	memIncrease := util.SaturatingSub(desiredPod.Mem.Reserved, max(pod.Mem.Reserved, charged.Mem))
+	if !s.upscaleLimiter.takeAll(time.Now(), cpuIncrease, memIncrease) {
+		s.metrics.UpscaleRateLimited.Inc()
+		logger.Warn("Not approving request within preapproval immediately because of cluster-wide upscale rate limit")
+		return false
+	}
+
+	if cpuIncrease != 0 || memIncrease != 0 {
+		s.preapprovalCharged[pod.UID] = api.Resources{
+			VCPU: max(charged.VCPU, desiredPod.CPU.Reserved),
+			Mem:  max(charged.Mem, desiredPod.Mem.Reserved),
+		}
+	}
+	return true
+}
+
 // Returns body (if successful), status code, error (if unsuccessful)
 func (s *PluginState) handleAgentRequest(
 	logger *zap.Logger,
@@ -143,6 +302,12 @@ func (s *PluginState) handleAgentRequest(
 			Inc()
 	}()
 
+	// Only the leader may approve resources. autoscaler-agents prefer the leader, but may not have
+	// seen a change in leadership yet.
+	if !s.leading.Load() {
+		return nil, 503, errors.New("This scheduler replica is not the leader")
+	}
+
 	// Before doing anything, check that the version is within the range we're expecting.
 	expectedProtoRange := api.VersionRange[api.PluginProtoVersion]{
 		Min: MinPluginProtocolVersion,
@@ -175,6 +340,22 @@ func (s *PluginState) handleAgentRequest(
 
 	nodeName = podObj.Spec.NodeName // set nodeName for deferred metrics
 
+	if req.Metrics != nil {
+		s.recordPodUsage(logger, podObj.UID, nodeName, *req.Metrics)
+	}
+
+	if s.config().computeUnits != nil {
+		expected := s.config().computeUnits.ForObject(podObj)
+		if req.ComputeUnit != expected {
+			logger.Warn(
+				"Agent request's computeUnit does not match configured value for VM family",
+				zap.String("family", api.ComputeUnitFamily(podObj)),
+				zap.Object("computeUnit", req.ComputeUnit),
+				zap.Object("expected", expected),
+			)
+		}
+	}
+
 	vmRef, ok := vmv1.VirtualMachineOwnerForPod(podObj)
 	if !ok {
 		logger.Error("Received request for non-VM Pod")
@@ -185,6 +366,11 @@ func (s *PluginState) handleAgentRequest(
 		Name:      vmRef.Name,
 	}
 
+	var nodePressure *api.NodePressureResponse
+	if req.ProtoVersion.SupportsNodePressure() {
+		nodePressure = s.nodePressureResponse(logger, podObj, req.ComputeUnit)
+	}
+
 	// From this point, we'll:
 	//
 	// 1. Update the annotations on the VirtualMachine object, if this request should change them;
@@ -202,6 +388,13 @@ func (s *PluginState) handleAgentRequest(
 	// Only patch the VM object if it changed:
 	if changed {
 		if err := s.patchVM(vmName, patches); err != nil {
+			// Don't wait here for the patch to be retried -- the autoscaler-agent will send
+			// another request soon enough.
+			var retryErr *patcher.RetryAfterError
+			if errors.As(err, &retryErr) {
+				logger.Warn("Failed to patch VM object, can retry", zap.Error(err))
+				return nil, 429, fmt.Errorf("failed to patch VM object, retry after %s", retryErr.RetryAfter)
+			}
 			logger.Error("Failed to patch VM object", zap.Error(err))
 			return nil, 500, errors.New("failed to patch VM object")
 		}
@@ -211,14 +404,42 @@ func (s *PluginState) handleAgentRequest(
 	// If we should be able to instantly approve the request, don't bother waiting to observe it.
 	if req.LastPermit != nil && !req.Resources.HasFieldGreaterThan(*req.LastPermit) {
 		resp := api.PluginResponse{
-			Permit:  req.Resources,
-			Migrate: nil,
+			Permit:       req.Resources,
+			Migrate:      nil,
+			NodePressure: nodePressure,
 		}
 		status = 200
 		logger.Info("Handled agent request", zap.Int("status", status), zap.Any("response", resp))
 		return &resp, status, nil
 	}
 
+	// Similarly, if the request is within what was already preapproved (and is still being asked
+	// for), then the resources are already set aside for the pod, so we can approve immediately --
+	// as long as the same limits that apply to reserving resources allow it.
+	if req.Preapproval != nil && !req.Resources.HasFieldGreaterThan(*req.Preapproval) {
+		podState, err := state.PodStateFromK8sObj(podObj)
+		if err != nil {
+			logger.Error("Failed to extract Pod state from Pod object for agent request")
+			return nil, 500, errors.New("failed to extract state from pod")
+		}
+
+		preapproved := api.Resources{
+			VCPU: podState.CPU.Preapproved,
+			Mem:  podState.Mem.Preapproved,
+		}
+		if !req.Resources.HasFieldGreaterThan(preapproved) && s.approveWithinPreapproval(logger, podObj, req.Resources) {
+			resp := api.PluginResponse{
+				Permit:       req.Resources,
+				Migrate:      nil,
+				NodePressure: nodePressure,
+			}
+			status = 200
+			s.metrics.PreapprovedResourceRequests.Inc()
+			logger.Info("Handled agent request within preapproval", zap.Int("status", status), zap.Any("response", resp))
+			return &resp, status, nil
+		}
+	}
+
 	// We want to wait for updates on the pod, but if it no longer exists, we should just return.
 	if !podExists {
 		logger.Warn("Pod for request no longer exists")
@@ -285,8 +506,9 @@ func (s *PluginState) handleAgentRequest(
 				logger.Warn("Timed out while waiting for updates to respond to agent request")
 			}
 			resp := api.PluginResponse{
-				Permit:  approved,
-				Migrate: nil,
+				Permit:       approved,
+				Migrate:      nil,
+				NodePressure: nodePressure,
 			}
 			status = 200
 			logger.Info("Handled agent request", zap.Int("status", status), zap.Any("response", resp))
@@ -344,6 +566,36 @@ func vmPatchForAgentRequest(pod *corev1.Pod, req api.AgentRequest) (_ []patch.Op
 		Value: requestedJSON,
 	})
 
+	if req.Preapproval != nil {
+		preapprovalJSON := marshalJSON(*req.Preapproval)
+		if preapprovalJSON != pod.Annotations[api.InternalAnnotationPreapprovalRequested] {
+			changed = true
+		}
+		patches = append(patches, patch.Operation{
+			Op: patch.OpReplace,
+			Path: fmt.Sprintf(
+				"/metadata/annotations/%s",
+				patch.PathEscape(api.InternalAnnotationPreapprovalRequested),
+			),
+			Value: preapprovalJSON,
+		})
+	}
+
+	if req.Confirmed != nil {
+		confirmedJSON := marshalJSON(*req.Confirmed)
+		if confirmedJSON != pod.Annotations[api.InternalAnnotationResourcesConfirmed] {
+			changed = true
+		}
+		patches = append(patches, patch.Operation{
+			Op: patch.OpReplace,
+			Path: fmt.Sprintf(
+				"/metadata/annotations/%s",
+				patch.PathEscape(api.InternalAnnotationResourcesConfirmed),
+			),
+			Value: confirmedJSON,
+		})
+	}
+
 	if req.LastPermit != nil {
 		approvedJSON := marshalJSON(*req.LastPermit)
 		if approvedJSON != pod.Annotations[api.InternalAnnotationResourcesApproved] {
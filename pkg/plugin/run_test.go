@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+)
+
+func TestRequeueAllNodes(t *testing.T) {
+	cases := []struct {
+		name    string
+		nodes   []string
+		failing []string
+
+		expectedCount int
+		expectedErr   bool
+	}{
+		{
+			name:          "no nodes",
+			nodes:         nil,
+			failing:       nil,
+			expectedCount: 0,
+			expectedErr:   false,
+		},
+		{
+			name:          "all nodes requeued",
+			nodes:         []string{"node-1", "node-2", "node-3"},
+			failing:       nil,
+			expectedCount: 3,
+			expectedErr:   false,
+		},
+		{
+			name:          "some nodes failed",
+			nodes:         []string{"node-1", "node-2", "node-3"},
+			failing:       []string{"node-2"},
+			expectedCount: 2,
+			expectedErr:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := defaultBenchmarkConfig()
+			s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+			for _, name := range c.nodes {
+				s.nodes[name] = &nodeState{} //nolint:exhaustruct // only the node's name is used
+			}
+
+			var requeued []string
+			s.requeueNode = func(name string) error {
+				if slices.Contains(c.failing, name) {
+					return errors.New("queue is closed")
+				}
+				requeued = append(requeued, name)
+				return nil
+			}
+
+			count, err := s.requeueAllNodes(zap.NewNop())
+			assert.Equal(t, c.expectedCount, count)
+			if c.expectedErr {
+				assert.Error(t, err)
+				for _, name := range c.failing {
+					assert.ErrorContains(t, err, name)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+
+			expected := slices.DeleteFunc(slices.Clone(c.nodes), func(name string) bool {
+				return slices.Contains(c.failing, name)
+			})
+			assert.ElementsMatch(t, expected, requeued)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package plugin
+
+// Handling of nodes that cluster-autoscaler wants to remove, as configured by
+// (Config).HonorScaleDownTaints.
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// taintToBeDeleted is added by cluster-autoscaler to a node right before it's drained and
+	// removed.
+	taintToBeDeleted = "ToBeDeletedByClusterAutoscaler"
+	// taintDeletionCandidate is added by cluster-autoscaler to a node that it may remove soon, if
+	// it stays unneeded.
+	taintDeletionCandidate = "DeletionCandidateOfClusterAutoscaler"
+)
+
+// filterReasonScaleDown is the reason that Filter rejects a pod from a node that cluster-autoscaler
+// is removing.
+const filterReasonScaleDown = "Node is being removed by cluster-autoscaler"
+
+// scaleDownState describes whether cluster-autoscaler intends to remove a node.
+type scaleDownState string
+
+const (
+	scaleDownNone      scaleDownState = ""
+	scaleDownCandidate scaleDownState = "Candidate"
+	scaleDownPending   scaleDownState = "Pending"
+)
+
+// scaleDownStateOf returns the scale-down state of the node, based on the taints added by
+// cluster-autoscaler.
+func scaleDownStateOf(node *corev1.Node) scaleDownState {
+	result := scaleDownNone
+	for _, taint := range node.Spec.Taints {
+		switch taint.Key {
+		case taintToBeDeleted:
+			return scaleDownPending
+		case taintDeletionCandidate:
+			result = scaleDownCandidate
+		}
+	}
+	return result
+}
+
+// scaleDownScoreFraction returns the factor that the node's score is multiplied by, based on
+// whether cluster-autoscaler wants to remove it: nodes that it might remove are only used if there's
+// nowhere else.
+func scaleDownScoreFraction(cfg *Config, ns *nodeState) float64 {
+	if cfg.HonorScaleDownTaints && ns.scaleDown != scaleDownNone {
+		return 0
+	}
+	return 1
+}
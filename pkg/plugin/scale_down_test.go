@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestScaleDownStateOf(t *testing.T) {
+	nodeWithTaints := func(keys ...string) *corev1.Node {
+		node := &corev1.Node{} //nolint:exhaustruct // only need the taints
+		for _, key := range keys {
+			node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{ //nolint:exhaustruct // only need the key
+				Key:    key,
+				Effect: corev1.TaintEffectNoSchedule,
+			})
+		}
+		return node
+	}
+
+	assert.Equal(t, scaleDownNone, scaleDownStateOf(nodeWithTaints()))
+	assert.Equal(t, scaleDownNone, scaleDownStateOf(nodeWithTaints("example.com/other")))
+	assert.Equal(t, scaleDownCandidate, scaleDownStateOf(nodeWithTaints(taintDeletionCandidate)))
+	assert.Equal(t, scaleDownPending, scaleDownStateOf(nodeWithTaints(taintToBeDeleted)))
+	assert.Equal(t, scaleDownPending, scaleDownStateOf(nodeWithTaints(taintDeletionCandidate, taintToBeDeleted)))
+}
+
+func TestScaleDownMigrations(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.HonorScaleDownTaints = true
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	var requeued []types.UID
+	s.requeuePod = func(uid types.UID) error {
+		requeued = append(requeued, uid)
+		return nil
+	}
+
+	// Well below the watermark, so only the scale-down should cause migrations.
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, 0.9, nil)
+	grouped1 := preemptionTestPod("grouped-1", 1000, true)
+	grouped1.DisruptionGroup = "group"
+	grouped2 := preemptionTestPod("grouped-2", 1000, true)
+	grouped2.DisruptionGroup = "group"
+	for _, p := range []state.Pod{
+		preemptionTestPod("vm", 1000, true),
+		preemptionTestPod("not-vm", 1000, false),
+		grouped1,
+		grouped2,
+	} {
+		node.AddPod(p)
+	}
+	ns := &nodeState{ //nolint:exhaustruct // only need the node, its state, and requested migrations
+		node:                node,
+		scaleDown:           scaleDownCandidate,
+		requestedMigrations: make(map[types.UID]time.Time),
+	}
+	s.nodes["node-1"] = ns
+
+	// Candidates for removal aren't drained
+	assert.NoError(t, s.balanceNode(zap.NewNop(), ns))
+	assert.Empty(t, requeued)
+
+	// ... but nodes that are being removed are, one at a time from each disruption group.
+	ns.scaleDown = scaleDownPending
+	assert.NoError(t, s.balanceNode(zap.NewNop(), ns))
+	assert.Len(t, requeued, 2)
+	assert.Contains(t, requeued, types.UID("vm"))
+	assert.Len(t, ns.requestedMigrations, 2)
+
+	// Balancing again doesn't request the same migrations, or another from the same group.
+	requeued = nil
+	assert.NoError(t, s.balanceNode(zap.NewNop(), ns))
+	assert.Empty(t, requeued)
+}
@@ -0,0 +1,112 @@
+package plugin
+
+// Tracking how long VM pods wait to be scheduled, and why.
+//
+// Each time a VM pod can't be placed onto any node, we record whether that was because the nodes
+// didn't have room for it, or for some other reason (e.g. node selectors or taints). Once the pod is
+// bound, the time since it was created is observed in the SchedulingWait metric, labeled by the
+// "worst" reason it was delayed:
+//
+//   - "capacity_shortage" if it was ever rejected because there was no room for it,
+//   - "filter_rejected" if it was ever rejected for any other reason, or
+//   - "approval" if it was never rejected, i.e. the wait was only in the scheduling queue,
+//     approving the reservation, and binding.
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+const (
+	schedulingWaitCapacityShortage = "capacity_shortage"
+	schedulingWaitFilterRejected   = "filter_rejected"
+	schedulingWaitApproval         = "approval"
+)
+
+// schedulingFailures records the kinds of failed scheduling attempts for a pod.
+type schedulingFailures struct {
+	capacityShortage bool
+	filterRejected   bool
+}
+
+// recordSchedulingFailure records that no node was feasible for the pod, with the Filter statuses
+// for each node.
+func (s *PluginState) recordSchedulingFailure(pod *corev1.Pod, statuses framework.NodeToStatusMap) {
+	if _, ok := vmv1.VirtualMachineOwnerForPod(pod); !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures := s.schedulingFailures[pod.UID]
+	if anyCapacityRejection(statuses) {
+		failures.capacityShortage = true
+	} else {
+		failures.filterRejected = true
+	}
+	s.schedulingFailures[pod.UID] = failures
+}
+
+// anyCapacityRejection returns whether any of the nodes rejected the pod because they didn't have
+// room for it.
+func anyCapacityRejection(statuses framework.NodeToStatusMap) bool {
+	for _, status := range statuses {
+		for _, reason := range status.Reasons() {
+			switch {
+			case reason == filterReasonNotEnoughResources,
+				reason == filterReasonNotEnoughBandwidth,
+				reason == filterReasonNotEnoughSpare,
+				strings.HasPrefix(reason, filterReasonVMLimitPrefix),
+				strings.HasPrefix(reason, filterReasonNotEnoughExtendedPrefix):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// observeSchedulingWait updates the SchedulingWait metric for a VM pod that's just been bound to a
+// node.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) observeSchedulingWait(pod *corev1.Pod, podState state.Pod) {
+	failures := s.schedulingFailures[pod.UID]
+	delete(s.schedulingFailures, pod.UID)
+
+	reason := schedulingWaitApproval
+	if failures.capacityShortage {
+		reason = schedulingWaitCapacityShortage
+	} else if failures.filterRejected {
+		reason = schedulingWaitFilterRejected
+	}
+
+	wait := time.Since(pod.CreationTimestamp.Time)
+	s.metrics.SchedulingWait.WithLabelValues(reason, s.sizeClass(pod, podState)).Observe(wait.Seconds())
+}
+
+// sizeClass returns the VM pod's size as a number of Compute Units, for use as a metric label.
+//
+// If there's no Compute Unit config, sizeClass returns "unknown". If the VM's size is not an exact
+// number of Compute Units, it returns "other".
+func (s *PluginState) sizeClass(pod *corev1.Pod, podState state.Pod) string {
+	cu := s.config().computeUnits
+	if cu == nil {
+		return "unknown"
+	}
+
+	size := api.Resources{VCPU: podState.CPU.Reserved, Mem: podState.Mem.Reserved}
+	cus, ok := size.DivResources(cu.ForObject(pod))
+	if !ok {
+		return "other"
+	}
+	return strconv.Itoa(int(cus))
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+)
+
+func TestSchedulingWait(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	cluster := defaultBenchmarkClusterConfig(2, 4)
+	c, err := newBenchmarkCluster(config, cluster)
+	require.NoError(t, err)
+
+	e := c.enforcer
+	logger := zap.NewNop()
+	ctx := context.Background()
+
+	// schedule runs the pod through a failed scheduling attempt with the reason (if not empty),
+	// and then reserves and binds it onto node-0.
+	schedule := func(name string, failureReason string) {
+		pod, err := benchmarkVMPod(config, name, "", cluster, false)
+		require.NoError(t, err)
+
+		if failureReason != "" {
+			statuses := framework.NodeToStatusMap{
+				"node-0": framework.NewStatus(framework.Unschedulable, failureReason),
+				"node-1": framework.NewStatus(framework.UnschedulableAndUnresolvable, "node(s) had untolerated taint"),
+			}
+			_, status := e.PostFilter(ctx, nil, pod, statuses)
+			require.True(t, status.IsSuccess())
+		}
+
+		require.True(t, e.Reserve(ctx, nil, pod, "node-0").IsSuccess())
+		pod.Spec.NodeName = "node-0"
+		_, err = e.state.HandlePodEvent(logger, reconcile.EventKindModified, pod)
+		require.NoError(t, err)
+	}
+	// observed returns whether there were observations with the reason, deleting them so that
+	// later checks start fresh.
+	observed := func(reason string) bool {
+		return e.state.metrics.SchedulingWait.DeleteLabelValues(reason, "unknown")
+	}
+
+	schedule("vm-capacity", filterReasonNotEnoughResources)
+	assert.True(t, observed(schedulingWaitCapacityShortage))
+	assert.False(t, observed(schedulingWaitFilterRejected))
+
+	schedule("vm-filter", "node(s) didn't match Pod's node affinity/selector")
+	assert.True(t, observed(schedulingWaitFilterRejected))
+	assert.False(t, observed(schedulingWaitCapacityShortage))
+
+	schedule("vm-approval", "")
+	assert.True(t, observed(schedulingWaitApproval))
+
+	// Nothing is left behind once the pods are bound
+	assert.Empty(t, e.state.schedulingFailures)
+}
@@ -0,0 +1,205 @@
+package plugin
+
+// Implementations of the strategies for scoring nodes, selected by ScoringConfig.Strategy.
+//
+// Each strategy only looks at how full the node would be with the pod added. Everything else that
+// affects the score (topology spread, network bandwidth) is applied on top of it by Score, regardless
+// of the strategy. Normalization and randomization are then applied by NormalizeScore, across all the
+// candidate nodes for the pod.
+//
+// To add a new strategy, implement ScoringStrategy and add it to scoringStrategies, along with a
+// ScoringStrategyName for it.
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// ScoringStrategy decides how good a node would be for a pod, based on its usage.
+type ScoringStrategy interface {
+	// Score returns the fraction of the maximum score, from 0 to 1, that the node should get, given
+	// its state with the pod already added.
+	//
+	// maxNodeCPU and maxNodeMem are the largest CPU and memory of any node in the cluster, so that
+	// strategies can take the node's relative size into account.
+	Score(node *state.Node, maxNodeCPU vmv1.MilliCPU, maxNodeMem api.Bytes) float64
+}
+
+// scoringStrategies maps each valid ScoringStrategyName to a function that creates the strategy
+// from the (already validated) scoring config.
+var scoringStrategies = map[ScoringStrategyName]func(ScoringConfig) ScoringStrategy{
+	ScoringStrategyPeak:    func(c ScoringConfig) ScoringStrategy { return peakScoring{config: c} },
+	ScoringStrategyBinpack: func(ScoringConfig) ScoringStrategy { return binpackScoring{} },
+	ScoringStrategySpread:  func(ScoringConfig) ScoringStrategy { return spreadScoring{} },
+	ScoringStrategyLeastAllocatedWeighted: func(c ScoringConfig) ScoringStrategy {
+		weights := ScoringResourceWeights{CPU: 1, Memory: 1}
+		if c.ResourceWeights != nil {
+			weights = *c.ResourceWeights
+		}
+		return leastAllocatedWeightedScoring{weights: weights}
+	},
+}
+
+// strategy returns the ScoringStrategy selected by the config.
+//
+// The config must have been validated, so that the strategy is known.
+func (c ScoringConfig) strategy() ScoringStrategy {
+	return scoringStrategies[c.strategyName()](c)
+}
+
+func usageFraction[T floatable](reserved T, total T) float64 {
+	return reserved.AsFloat64() / total.AsFloat64()
+}
+
+// peakScoring is the original scoring curve, refer to ScoringConfig for more.
+type peakScoring struct {
+	config ScoringConfig
+}
+
+func (s peakScoring) Score(node *state.Node, maxNodeCPU vmv1.MilliCPU, maxNodeMem api.Bytes) float64 {
+	cpuScore := calculateScore(s.config, node.CPU.Reserved, node.CPU.Total, maxNodeCPU)
+	memScore := calculateScore(s.config, node.Mem.Reserved, node.Mem.Total, maxNodeMem)
+	return min(cpuScore, memScore)
+}
+
+// binpackScoring prefers fuller nodes, so that VMs are packed onto as few nodes as possible.
+//
+// The score is the usage of whichever resource is *less* full, so that nodes are only preferred if
+// both resources are well-used.
+type binpackScoring struct{}
+
+func (binpackScoring) Score(node *state.Node, _ vmv1.MilliCPU, _ api.Bytes) float64 {
+	cpu := usageFraction(node.CPU.Reserved, node.CPU.Total)
+	mem := usageFraction(node.Mem.Reserved, node.Mem.Total)
+	return min(cpu, mem, 1)
+}
+
+// spreadScoring prefers emptier nodes, so that VMs are spread evenly across the cluster.
+//
+// The score is based on whichever resource is *more* full, so that nodes close to running out of
+// either resource are avoided.
+type spreadScoring struct{}
+
+func (spreadScoring) Score(node *state.Node, _ vmv1.MilliCPU, _ api.Bytes) float64 {
+	cpu := usageFraction(node.CPU.Reserved, node.CPU.Total)
+	mem := usageFraction(node.Mem.Reserved, node.Mem.Total)
+	return max(0, 1-max(cpu, mem))
+}
+
+// leastAllocatedWeightedScoring prefers emptier nodes, like kube-scheduler's LeastAllocated
+// strategy: the score is the weighted average of the unused fraction of each resource.
+type leastAllocatedWeightedScoring struct {
+	weights ScoringResourceWeights
+}
+
+func (s leastAllocatedWeightedScoring) Score(node *state.Node, _ vmv1.MilliCPU, _ api.Bytes) float64 {
+	cpuFree := max(0, 1-usageFraction(node.CPU.Reserved, node.CPU.Total))
+	memFree := max(0, 1-usageFraction(node.Mem.Reserved, node.Mem.Total))
+	return (s.weights.CPU*cpuFree + s.weights.Memory*memFree) / (s.weights.CPU + s.weights.Memory)
+}
+
+type floatable interface {
+	AsFloat64() float64
+}
+
+// Refer to the comments in ScoringConfig for more. Also, see: https://www.desmos.com/calculator/wg8s0yn63s
+func calculateScore[T floatable](
+	cfg ScoringConfig,
+	reserved T,
+	total T,
+	maxTotalSeen T,
+) float64 {
+	y0 := cfg.MinUsageScore
+	y1 := cfg.MaxUsageScore
+	xp := cfg.ScorePeak
+
+	fraction := reserved.AsFloat64() / total.AsFloat64()
+	scale := total.AsFloat64() / maxTotalSeen.AsFloat64()
+
+	score := float64(1) // if fraction == nodeConf.ScorePeak
+	if fraction < cfg.ScorePeak {
+		score = y0 + (1-y0)/xp*fraction
+	} else if fraction > cfg.ScorePeak {
+		score = y1 + (1-y1)/(1-xp)*(1-fraction)
+	}
+
+	return score * scale
+}
+
+// normalizeScores spreads out the scores of the candidate nodes for a pod, so that the lowest covers
+// the lowest actual score and the highest covers framework.MaxNodeScore.
+//
+// Scores of framework.MinNodeScore, which indicate that the pod should not be placed on the node,
+// are left as-is and don't count towards the range.
+func normalizeScores(c ScoreNormalizationConfig, scores framework.NodeScoreList) {
+	// Refer to randomizeScore for why this is different from framework.MinNodeScore.
+	minScore := framework.MinNodeScore + 1
+
+	lowest, highest := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, s := range scores {
+		if s.Score >= minScore {
+			lowest = min(lowest, s.Score)
+			highest = max(highest, s.Score)
+		}
+	}
+
+	for i := range scores {
+		s := &scores[i]
+		if s.Score < minScore {
+			continue
+		} else if lowest == highest {
+			s.Score = framework.MaxNodeScore // nothing to spread out; all nodes are equally good.
+			continue
+		}
+
+		x := float64(s.Score-lowest) / float64(highest-lowest)
+		var fraction float64
+		switch c.Function {
+		case ScoreNormalizationLinear:
+			fraction = x
+		case ScoreNormalizationExponential:
+			fraction = math.Expm1(c.Steepness*x) / math.Expm1(c.Steepness)
+		}
+
+		s.Score = minScore + int64(math.Round(fraction*float64(framework.MaxNodeScore-minScore)))
+	}
+}
+
+// newScoreRand returns the source of randomness for the config's Randomize, seeded from RandomSeed
+// if it's set.
+func newScoreRand(c ScoringConfig) *rand.Rand {
+	seed := time.Now().UnixNano()
+	if c.RandomSeed != nil {
+		seed = *c.RandomSeed
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// randomizeScore picks a random score in the range allowed by the config, returning the score and
+// the random number it was derived from, or false if the score can't be randomized.
+func randomizeScore(c ScoringConfig, rng *rand.Rand, trueScore int64) (score int64, draw int64, ok bool) {
+	// This is different from framework.MinNodeScore. We use framework.MinNodeScore to indicate that
+	// a pod should not be placed on a node. The lowest actual score we assign a node is thus
+	// framework.MinNodeScore + 1
+	minScore := framework.MinNodeScore + 1
+	if trueScore < minScore {
+		return trueScore, 0, false
+	}
+
+	lowest := minScore
+	if c.RandomJitterFraction != 0 {
+		lowest = max(minScore, trueScore-int64(c.RandomJitterFraction*float64(trueScore)))
+	}
+
+	// We want to pick a score in the range [lowest, trueScore], so use trueScore + 1 - lowest, as
+	// rand.Int63n picks a number in the *half open* range [0, n).
+	draw = rng.Int63n(trueScore + 1 - lowest)
+	return lowest + draw, draw, true
+}
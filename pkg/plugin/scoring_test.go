@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+func TestScoringStrategies(t *testing.T) {
+	const totalCPU vmv1.MilliCPU = 10000
+	const totalMem api.Bytes = 10 * 1024 * 1024 * 1024
+
+	node := func(cpuFraction, memFraction float64) *state.Node {
+		n := state.NodeStateFromParams("node", totalCPU, totalMem, 0.9, nil)
+		n.CPU.Reserved = vmv1.MilliCPU(cpuFraction * float64(totalCPU))
+		n.Mem.Reserved = api.Bytes(memFraction * float64(totalMem))
+		return n
+	}
+	score := func(cfg ScoringConfig, n *state.Node) float64 {
+		_, err := cfg.validate()
+		assert.NoError(t, err)
+		return cfg.strategy().Score(n, totalCPU, totalMem)
+	}
+
+	withStrategy := func(name ScoringStrategyName) ScoringConfig {
+		cfg := DefaultScoringConfig()
+		cfg.Strategy = name
+		return cfg
+	}
+
+	// Unset is the same as peak
+	assert.Equal(t, score(withStrategy(ScoringStrategyPeak), node(0.3, 0.5)), score(withStrategy(""), node(0.3, 0.5)))
+	// Peak prefers nodes close to ScorePeak
+	peak := withStrategy(ScoringStrategyPeak)
+	assert.Equal(t, 1.0, score(peak, node(0.8, 0.8)))
+	assert.Less(t, score(peak, node(0.5, 0.5)), score(peak, node(0.7, 0.7)))
+	assert.Less(t, score(peak, node(0.95, 0.95)), score(peak, node(0.85, 0.85)))
+
+	// Binpack prefers fuller nodes, based on the less-used resource
+	binpack := withStrategy(ScoringStrategyBinpack)
+	assert.InDelta(t, 0.3, score(binpack, node(0.3, 0.5)), 1e-9)
+	assert.Less(t, score(binpack, node(0.5, 0.5)), score(binpack, node(0.95, 0.95)))
+
+	// Spread prefers emptier nodes, based on the more-used resource
+	spread := withStrategy(ScoringStrategySpread)
+	assert.InDelta(t, 0.5, score(spread, node(0.3, 0.5)), 1e-9)
+	assert.Greater(t, score(spread, node(0.5, 0.5)), score(spread, node(0.95, 0.95)))
+
+	// Least-allocated averages the resources, by weight
+	leastAllocated := withStrategy(ScoringStrategyLeastAllocatedWeighted)
+	assert.InDelta(t, 0.6, score(leastAllocated, node(0.3, 0.5)), 1e-9)
+	leastAllocated.ResourceWeights = &ScoringResourceWeights{CPU: 3, Memory: 1}
+	assert.InDelta(t, 0.65, score(leastAllocated, node(0.3, 0.5)), 1e-9)
+
+	// Invalid configs are rejected
+	unknown := withStrategy("random")
+	path, err := unknown.validate()
+	assert.Equal(t, "strategy", path)
+	assert.Error(t, err)
+
+	noWeights := withStrategy(ScoringStrategyLeastAllocatedWeighted)
+	noWeights.ResourceWeights = &ScoringResourceWeights{CPU: 0, Memory: 0}
+	path, err = noWeights.validate()
+	assert.Equal(t, "resourceWeights", path)
+	assert.Error(t, err)
+}
+
+func TestTenantSpreadPenalty(t *testing.T) {
+	const zoneLabel = "topology.kubernetes.io/zone"
+	const tenantLabel = "tenant"
+
+	config := defaultBenchmarkConfig()
+	config.Scoring.TopologySpread = &TopologySpreadScoringConfig{
+		TenantLabel: tenantLabel,
+		Weights:     map[string]float64{zoneLabel: 0.5},
+	}
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+
+	for _, name := range []string{"node-a", "node-b", "node-c"} {
+		s.nodes[name] = &nodeState{ //nolint:exhaustruct // only need the node and labels for this test
+			node:   state.NodeStateFromParams(name, 10000, 40*1024*1024*1024, 0.9, nil),
+			labels: map[string]string{zoneLabel: "zone-" + name[len(name)-1:]},
+		}
+	}
+	// A node without the label is never penalized
+	s.nodes["node-unlabeled"] = &nodeState{ //nolint:exhaustruct // only need the node for this test
+		node: state.NodeStateFromParams("node-unlabeled", 10000, 40*1024*1024*1024, 0.9, nil),
+	}
+
+	nextID := 0
+	addVM := func(nodeName string, tenant string) {
+		nextID += 1
+		name := util.NamespacedName{Namespace: "default", Name: fmt.Sprintf("vm-%d", nextID)}
+		uid := types.UID(fmt.Sprintf("pod-uid-%d", nextID))
+		s.nodes[nodeName].node.AddPod(state.Pod{
+			NamespacedName:    name,
+			UID:               uid,
+			CreatedAt:         lo.Empty[time.Time](),
+			VirtualMachine:    name,
+			Migratable:        true,
+			AlwaysMigrate:     false,
+			DisruptionGroup:   "",
+			Migrating:         false,
+			NetworkBandwidth:  0,
+			ExtendedResources: state.ExtendedResources{},
+			WarmPool:          false,
+			Priority:          0,
+			MigrationCost:     0,
+			CPU: state.PodResources[vmv1.MilliCPU]{
+				Reserved:             0,
+				Requested:            0,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               0,
+				Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			},
+			Mem: state.PodResources[api.Bytes]{
+				Reserved:             0,
+				Requested:            0,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               0,
+				Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			},
+		})
+		s.podLabels[uid] = map[string]string{tenantLabel: tenant}
+	}
+
+	// tenant-1 has two VMs in zone a, one in zone b, and none in zone c. tenant-2 is all in zone c.
+	addVM("node-a", "tenant-1")
+	addVM("node-a", "tenant-1")
+	addVM("node-b", "tenant-1")
+	addVM("node-c", "tenant-2")
+	addVM("node-c", "tenant-2")
+
+	newPod := func(tenant string) *corev1.Pod {
+		return &corev1.Pod{ //nolint:exhaustruct // only need the labels for this test
+			ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // only need the labels for this test
+				UID:    "new-pod",
+				Labels: map[string]string{tenantLabel: tenant},
+			},
+		}
+	}
+
+	assert.Equal(t, 1.0, s.tenantSpreadPenalty(s.config(), newPod("tenant-1"), "node-a"))
+	assert.Equal(t, 0.5, s.tenantSpreadPenalty(s.config(), newPod("tenant-1"), "node-b"))
+	assert.Equal(t, 0.0, s.tenantSpreadPenalty(s.config(), newPod("tenant-1"), "node-c"))
+	assert.Equal(t, 0.0, s.tenantSpreadPenalty(s.config(), newPod("tenant-1"), "node-unlabeled"))
+	assert.Equal(t, 1.0, s.tenantSpreadPenalty(s.config(), newPod("tenant-2"), "node-c"))
+	// New tenants aren't penalized anywhere
+	assert.Equal(t, 0.0, s.tenantSpreadPenalty(s.config(), newPod("tenant-3"), "node-a"))
+}
+
+func TestRandomizeScore(t *testing.T) {
+	cfg := DefaultScoringConfig()
+	cfg.Randomize = true
+	cfg.RandomSeed = lo.ToPtr[int64](42)
+
+	draws := func(cfg ScoringConfig) []int64 {
+		rng := newScoreRand(cfg)
+		var scores []int64
+		for range 20 {
+			score, _, ok := randomizeScore(cfg, rng, 80)
+			assert.True(t, ok)
+			scores = append(scores, score)
+		}
+		return scores
+	}
+
+	// The same seed gives the same sequence
+	scores := draws(cfg)
+	assert.Equal(t, scores, draws(cfg))
+	for _, s := range scores {
+		assert.GreaterOrEqual(t, s, int64(1))
+		assert.LessOrEqual(t, s, int64(80))
+	}
+
+	// The jitter fraction limits how far scores can be lowered
+	cfg.RandomJitterFraction = 0.25
+	for _, s := range draws(cfg) {
+		assert.GreaterOrEqual(t, s, int64(60))
+		assert.LessOrEqual(t, s, int64(80))
+	}
+
+	// Scores that reject the node are left as-is
+	score, _, ok := randomizeScore(cfg, newScoreRand(cfg), 0)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), score)
+}
+
+func TestNormalizeScores(t *testing.T) {
+	normalize := func(c ScoreNormalizationConfig, raw ...int64) []int64 {
+		scores := make(framework.NodeScoreList, len(raw))
+		for i, score := range raw {
+			scores[i] = framework.NodeScore{Name: fmt.Sprintf("node-%d", i), Score: score}
+		}
+		normalizeScores(c, scores)
+		return lo.Map(scores, func(s framework.NodeScore, _ int) int64 { return s.Score })
+	}
+
+	linear := ScoreNormalizationConfig{Function: ScoreNormalizationLinear, Steepness: 0}
+	exponential := ScoreNormalizationConfig{Function: ScoreNormalizationExponential, Steepness: 3}
+
+	// Scores clustered between 60 and 64 are spread across the full range, except for rejected
+	// nodes, which keep the minimum score.
+	assert.Equal(t, []int64{1, 26, 51, 75, 100, 0}, normalize(linear, 60, 61, 62, 63, 64, 0))
+
+	// The exponential function keeps the ends of the range, but widens the gaps between the best
+	// nodes more than between the worst.
+	scores := normalize(exponential, 60, 61, 62, 63, 64)
+	assert.Equal(t, int64(1), scores[0])
+	assert.Equal(t, int64(100), scores[4])
+	assert.Less(t, scores[1]-scores[0], scores[4]-scores[3])
+
+	// If all nodes are equally good, they all get the maximum score.
+	assert.Equal(t, []int64{100, 100, 0}, normalize(linear, 70, 70, 0))
+}
@@ -0,0 +1,190 @@
+package plugin
+
+// Shadow mode: evaluating a candidate config against the decisions made by the scheduler that's
+// actually running, without acting on anything.
+//
+// A shadow replica runs with the candidate config and watches the same objects as the real
+// scheduler, so its local state mirrors the real scheduler's. Whenever the real scheduler makes a
+// decision that we can observe -- placing a VM pod onto a node, or migrating a VM away from one --
+// we check what we would have decided with our config, and log & count the decisions that differ.
+//
+// Because it doesn't act, the shadow replica never approves resources or creates migrations
+// itself. Approved resources are instead taken from the objects, as updated by the real scheduler.
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+const (
+	shadowDecisionPlacement = "placement"
+	shadowDecisionMigration = "migration"
+)
+
+// shadowMigrationGracePeriod is how long we wait for the real scheduler to migrate a pod that we
+// would have migrated, before counting it as a diverging decision.
+const shadowMigrationGracePeriod = 30 * time.Second
+
+func (s *PluginState) recordShadowDecision(decision string, agree bool) {
+	outcome := "diverge"
+	if agree {
+		outcome = "agree"
+	}
+	s.metrics.ShadowDecisions.WithLabelValues(decision, outcome).Inc()
+}
+
+// compareShadowPlacement compares the real scheduler's placement of a new VM pod onto the node with
+// the placement we would have chosen.
+//
+// Topology constraints aren't evaluated here, so pods with them are ignored if the config's
+// HonorPodTopology is enabled.
+//
+// NOTE: this function expects that the caller has acquired s.mu, and that the pod has not yet been
+// added to the node.
+func (s *PluginState) compareShadowPlacement(
+	logger *zap.Logger,
+	obj *corev1.Pod,
+	pod state.Pod,
+	nodeName string,
+) {
+	cfg := s.config()
+	if cfg.HonorPodTopology && (obj.Spec.Affinity != nil || len(obj.Spec.TopologySpreadConstraints) != 0) {
+		return
+	}
+
+	var bestNode string
+	var bestScore float64
+	var leaderScore float64
+	leaderNodeAllowed := false
+
+	podMax := podMaxResources(obj)
+
+	for name, ns := range s.nodes {
+		score, ok := s.shadowScore(cfg, ns, pod, podMax)
+		if !ok {
+			continue
+		}
+		if name == nodeName {
+			leaderScore = score
+			leaderNodeAllowed = true
+		}
+		if bestNode == "" || score > bestScore {
+			bestNode = name
+			bestScore = score
+		}
+	}
+
+	// Ties count as agreeing, because either node could have been picked.
+	agree := leaderNodeAllowed && leaderScore >= bestScore
+	s.recordShadowDecision(shadowDecisionPlacement, agree)
+	if agree {
+		return
+	}
+
+	logger.Warn(
+		"Shadow config would have placed Pod differently",
+		zap.Object("Pod", pod),
+		zap.Bool("LeaderNodeAllowed", leaderNodeAllowed),
+		zap.Float64("LeaderNodeScore", leaderScore),
+		zap.String("ShadowNodeName", bestNode),
+		zap.Float64("ShadowNodeScore", bestScore),
+	)
+}
+
+// shadowScore returns the score (from 0 to 1) that the node would have for the pod under the
+// config, or false if the pod would be rejected from the node.
+//
+// This mirrors the checks in Filter and the calculation in Score.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) shadowScore(
+	cfg *Config,
+	ns *nodeState,
+	pod state.Pod,
+	podMax *api.Resources,
+) (score float64, ok bool) {
+	maxVMs, limitVMs := cfg.maxVMsOnNode(ns.labels)
+
+	if nodeFilterReason(cfg, ns, time.Now()) != "" {
+		return 0, false
+	}
+
+	ns.node.Speculatively(func(n *state.Node) (commit bool) {
+		n.AddPod(pod)
+
+		if podFilterReason(cfg, n, pod, maxVMs, limitVMs) == "" {
+			scoring := cfg.Scoring.forNode(ns.labels)
+			s.applyProjectedGrowth(scoring, n, pod.UID, podMax)
+			score = scoring.strategy().Score(n, s.maxNodeCPU, s.maxNodeMem) *
+				networkBandwidthScoreFraction(cfg, n) * extendedResourcesScoreFraction(n, pod) *
+				scaleDownScoreFraction(cfg, ns)
+			ok = true
+		}
+
+		return false // never commit, we're doing this just to check.
+	})
+
+	return score, ok
+}
+
+// checkShadowMigration handles a pod that we would have migrated, reporting it as a diverging
+// decision if the real scheduler hasn't migrated it within shadowMigrationGracePeriod.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) checkShadowMigration(logger *zap.Logger, pod state.Pod, requestedAt time.Time) {
+	if time.Since(requestedAt) < shadowMigrationGracePeriod {
+		return
+	}
+	if _, reported := s.shadowReportedMigrations[pod.UID]; reported {
+		return
+	}
+
+	s.shadowReportedMigrations[pod.UID] = struct{}{}
+	s.recordShadowDecision(shadowDecisionMigration, false)
+	logger.Warn(
+		"Shadow config would have migrated Pod, but the leader hasn't",
+		zap.Object("Pod", pod),
+		zap.Time("RequestedAt", requestedAt),
+	)
+}
+
+// compareShadowMigration compares a new migration created by the real scheduler with the
+// migrations we would have made.
+func (s *PluginState) compareShadowMigration(logger *zap.Logger, vmm *vmv1.VirtualMachineMigration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Existing migrations seen during startup weren't decided with our state.
+	if !s.startupDone {
+		return
+	}
+
+	for _, ns := range s.nodes {
+		for uid, pod := range ns.node.Pods() {
+			if pod.VirtualMachine.Namespace != vmm.Namespace || pod.VirtualMachine.Name != vmm.Spec.VmName {
+				continue
+			} else if metadataForNewMigration(pod).Name != vmm.Name {
+				continue
+			}
+
+			_, requested := ns.requestedMigrations[uid]
+			_, reported := s.shadowReportedMigrations[uid]
+			// If we already reported that the leader didn't make this migration, don't count it
+			// twice.
+			if !reported {
+				s.recordShadowDecision(shadowDecisionMigration, requested)
+			}
+			if !requested {
+				logger.Warn("Leader migrated Pod, but shadow config would not have", zap.Object("Pod", pod))
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/reconcile"
+)
+
+func TestShadowPlacement(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.ShadowMode = true
+	cluster := defaultBenchmarkClusterConfig(2, 4)
+	c, err := newBenchmarkCluster(config, cluster)
+	require.NoError(t, err)
+
+	s := c.enforcer.state
+	logger := zap.NewNop()
+
+	place := func(name string, nodeName string) {
+		pod, err := benchmarkVMPod(config, name, nodeName, cluster, false)
+		require.NoError(t, err)
+		_, err = s.HandlePodEvent(logger, reconcile.EventKindAdded, pod)
+		require.NoError(t, err)
+	}
+	decisions := func(outcome string) float64 {
+		return testutil.ToFloat64(s.metrics.ShadowDecisions.WithLabelValues(shadowDecisionPlacement, outcome))
+	}
+
+	// Both nodes are the same, so either placement agrees
+	place("new-vm-1", "node-0")
+	assert.Equal(t, 1.0, decisions("agree"))
+	assert.Equal(t, 0.0, decisions("diverge"))
+
+	// node-0 is now fuller but still below the scorePeak, so it's preferred
+	place("new-vm-2", "node-1")
+	assert.Equal(t, 1.0, decisions("agree"))
+	assert.Equal(t, 1.0, decisions("diverge"))
+
+	// Updates to pods that are already placed aren't compared again
+	place("new-vm-2", "node-1")
+	assert.Equal(t, 1.0, decisions("diverge"))
+}
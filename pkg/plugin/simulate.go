@@ -0,0 +1,257 @@
+package plugin
+
+// The /simulate endpoint: evaluating a hypothetical pod against every node, without changing
+// anything.
+//
+// An operator can POST either a pod spec, or just the resources of a VM, and get back what Filter
+// and Score would return for each node right now. This is useful for capacity planning ("would a
+// 16 CPU VM fit anywhere?") and for checking the effects of scoring changes.
+//
+// Unlike the real Filter and Score, the simulation only uses the plugin's local state -- not the
+// scheduler's snapshot of the pods on each node, which may include pods we haven't seen yet.
+//
+// Each simulation holds the plugin's lock while it evaluates every node, so the endpoint is only
+// served by the debug server, behind its bearer token.
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// simulatedPodUID is the UID given to every simulated pod, so that it can't conflict with a pod
+// that's already in the local state.
+const simulatedPodUID types.UID = "autoscale-scheduler-simulated-pod"
+
+// SimulationRequest is the body of a request to the /simulate endpoint. Exactly one of the fields
+// must be set.
+type SimulationRequest struct {
+	// Pod is the hypothetical pod. It's evaluated the same way as in Filter and Score -- so, for
+	// example, VM runner pods must have the same annotations that neonvm-controller sets.
+	Pod *corev1.Pod `json:"pod,omitempty"`
+	// VM gives the resources of a hypothetical VM, for when the pod spec doesn't matter.
+	VM *api.Resources `json:"vm,omitempty"`
+}
+
+// SimulationResult is the response from the /simulate endpoint.
+type SimulationResult struct {
+	// Nodes gives the outcome for each node, highest score first. Nodes that would reject the pod
+	// are last, ordered by name.
+	Nodes []SimulatedNode `json:"nodes"`
+}
+
+// SimulatedNode is the outcome of a simulation for a single node.
+type SimulatedNode struct {
+	Name string `json:"name"`
+	// Reason is why Filter would reject the pod from the node, or empty if it'd be allowed.
+	Reason string `json:"reason,omitempty"`
+	// Details explains the Reason with the node's usage, if there's anything to add to it.
+	Details []string `json:"details,omitempty"`
+	// Hint is the hint about what would allow the pod onto the node, that Filter adds after the
+	// Reason, if there is one.
+	Hint string `json:"hint,omitempty"`
+	// Score is the score that Score would return for the node, before normalizing. It's only set
+	// if the pod would be allowed.
+	Score int64 `json:"score"`
+}
+
+// maxSimulationBodySize is the maximum size of a request to the /simulate endpoint. It's larger
+// than MaxHTTPBodySize because the request may contain a full pod spec.
+const maxSimulationBodySize int64 = 1 << 20 // 1 MiB
+
+// serveSimulate handles POST requests to the /simulate endpoint.
+func (s *PluginState) serveSimulate(logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte("must be POST"))
+			return
+		}
+
+		var req SimulationRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSimulationBodySize))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			w.Header().Add("Content-Type", ContentTypeError)
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte(fmt.Sprintf("bad request body: %s", err)))
+			return
+		}
+
+		pod, isVM, err := req.pod()
+		if err != nil {
+			w.Header().Add("Content-Type", ContentTypeError)
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		result, err := s.simulate(pod, isVM, time.Now())
+		if err != nil {
+			w.Header().Add("Content-Type", ContentTypeError)
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		body, err := json.Marshal(result)
+		if err != nil {
+			logger.Panic("Failed to encode response JSON", zap.Error(err))
+		}
+		w.Header().Add("Content-Type", ContentTypeJSON)
+		w.WriteHeader(200)
+		_, _ = w.Write(body)
+	}
+}
+
+// pod returns the pod to simulate for the request, and whether it should be treated as a VM even if
+// it doesn't look like one.
+func (r SimulationRequest) pod() (_ *corev1.Pod, isVM bool, _ error) {
+	switch {
+	case r.Pod != nil && r.VM != nil:
+		return nil, false, errors.New("only one of 'pod' or 'vm' may be set")
+	case r.Pod != nil:
+		pod := r.Pod.DeepCopy()
+		pod.UID = simulatedPodUID
+		return pod, false, nil
+	case r.VM != nil:
+		// Without the annotations from neonvm-controller, the pod's resources are taken from its
+		// requests -- so we can just set those, and mark the pod as a VM afterwards.
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "simulated-vm", Namespace: "default", UID: simulatedPodUID},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name: "neonvm-runner",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    *r.VM.VCPU.ToResourceQuantity(),
+							corev1.ResourceMemory: *resource.NewQuantity(int64(r.VM.Mem), resource.BinarySI),
+						},
+					},
+				}},
+			},
+		}, true, nil
+	default:
+		return nil, false, errors.New("one of 'pod' or 'vm' must be set")
+	}
+}
+
+// simulate returns what Filter and Score would return for the pod on each node, without changing
+// any state.
+func (s *PluginState) simulate(obj *corev1.Pod, isVM bool, now time.Time) (*SimulationResult, error) {
+	cfg := s.config()
+
+	pod, err := cfg.podStateFromK8sObj(obj)
+	if err != nil {
+		return nil, fmt.Errorf("could not get state from Pod: %w", err)
+	}
+	if isVM {
+		pod.VirtualMachine = util.GetNamespacedName(obj)
+	}
+	podMax := podMaxResources(obj)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &SimulationResult{Nodes: make([]SimulatedNode, 0, len(s.nodes))}
+	for name, ns := range s.nodes {
+		outcome, err := s.simulateOnNode(cfg, obj, pod, podMax, name, ns, now)
+		if err != nil {
+			return nil, err
+		}
+		result.Nodes = append(result.Nodes, outcome)
+	}
+
+	slices.SortFunc(result.Nodes, func(x, y SimulatedNode) int {
+		if xRejected, yRejected := x.Reason != "", y.Reason != ""; xRejected != yRejected {
+			return lo.Ternary(xRejected, 1, -1) // allowed nodes first
+		} else if c := cmp.Compare(y.Score, x.Score); c != 0 {
+			return c // highest score first
+		}
+		return cmp.Compare(x.Name, y.Name)
+	})
+	return result, nil
+}
+
+// simulateOnNode returns what Filter and Score would return for the pod on the node.
+//
+// This mirrors the checks in Filter and the calculation in Score.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) simulateOnNode(
+	cfg *Config,
+	obj *corev1.Pod,
+	pod state.Pod,
+	podMax *api.Resources,
+	nodeName string,
+	ns *nodeState,
+	now time.Time,
+) (SimulatedNode, error) {
+	outcome := SimulatedNode{Name: nodeName, Reason: "", Details: nil, Hint: "", Score: 0}
+
+	if cfg.HonorPodTopology {
+		reason, err := s.topologyCheck(obj, nodeName)
+		if err != nil {
+			return outcome, fmt.Errorf("could not check Pod topology: %w", err)
+		} else if reason != "" {
+			outcome.Reason = reason
+			return outcome, nil
+		}
+	}
+	if reason := nodeFilterReason(cfg, ns, now); reason != "" {
+		outcome.Reason = reason
+		return outcome, nil
+	}
+
+	var spreadExcess int
+	if cfg.HonorPodTopology {
+		// Score ignores errors here, so we do too.
+		spreadExcess, _ = s.softTopologySpreadExcess(obj, nodeName)
+	}
+	tenantSpreadPenalty := s.tenantSpreadPenalty(cfg, obj, nodeName)
+	maxVMs, limitVMs := cfg.maxVMsOnNode(ns.labels)
+
+	ns.node.Speculatively(func(n *state.Node) (commit bool) {
+		n.AddPod(pod)
+
+		outcome.Reason = podFilterReason(cfg, n, pod, maxVMs, limitVMs)
+		if outcome.Reason != "" {
+			outcome.Details = filterReasonDetails(outcome.Reason, n)
+			outcome.Hint = filterReasonHint(outcome.Reason)
+			return false
+		}
+
+		scoring := cfg.Scoring.forNode(ns.labels)
+		s.applyProjectedGrowth(scoring, n, pod.UID, podMax)
+		scoreFraction := scoring.strategy().Score(n, s.maxNodeCPU, s.maxNodeMem)
+		scoreFraction /= float64(1 + spreadExcess)
+		scoreFraction /= 1 + tenantSpreadPenalty
+		scoreFraction *= networkBandwidthScoreFraction(cfg, n) *
+			extendedResourcesScoreFraction(n, pod) *
+			scaleDownScoreFraction(cfg, ns)
+
+		scoreLen := framework.MaxNodeScore - framework.MinNodeScore
+		outcome.Score = framework.MinNodeScore + int64(float64(scoreLen)*scoreFraction)
+
+		return false // never commit, we're doing this just to check.
+	})
+
+	return outcome, nil
+}
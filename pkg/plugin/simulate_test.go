@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestSimulate(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	s.maxNodeCPU = 10000
+	s.maxNodeMem = 40 * 1024 * 1024 * 1024
+
+	for name, usedCPU := range map[string]vmv1.MilliCPU{"node-empty": 0, "node-busy": 6000, "node-full": 9500} {
+		node := state.NodeStateFromParams(name, 10000, 40*1024*1024*1024, config.Watermark, nil)
+		if usedCPU != 0 {
+			node.AddPod(preemptionTestPod(name+"-vm", usedCPU, true))
+		}
+		s.nodes[name] = &nodeState{node: node} //nolint:exhaustruct // only need the node
+	}
+
+	handler := s.serveSimulate(zap.NewNop())
+	post := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/simulate", strings.NewReader(body)))
+		return recorder
+	}
+
+	recorder := post(`{"vm": {"vCPUs": 1, "mem": "4Gi"}}`)
+	assert.Equal(t, 200, recorder.Code, recorder.Body.String())
+	var result SimulationResult
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+
+	assert.Len(t, result.Nodes, 3)
+	// The full node is rejected, and sorted last
+	assert.Equal(t, "node-full", result.Nodes[2].Name)
+	assert.Equal(t, filterReasonNotEnoughResources, result.Nodes[2].Reason)
+	for _, n := range result.Nodes[:2] {
+		assert.Empty(t, n.Reason)
+	}
+	assert.GreaterOrEqual(t, result.Nodes[0].Score, result.Nodes[1].Score)
+
+	// Nothing was changed
+	for _, ns := range s.nodes {
+		_, ok := ns.node.GetPod(simulatedPodUID)
+		assert.False(t, ok)
+	}
+
+	assert.Equal(t, 400, post(`{}`).Code)
+	assert.Equal(t, 400, post(`{"vm": {"vCPUs": 1, "mem": "4Gi"}, "pod": {}}`).Code)
+	// Oversized bodies are rejected without being read in full
+	assert.Equal(t, 400, post(`{"pod": {"metadata": {"name": "`+strings.Repeat("a", int(maxSimulationBodySize))+`"}}}`).Code)
+}
@@ -0,0 +1,115 @@
+package state
+
+// Tracking of extended resources (e.g., "nvidia.com/gpu") and hugepages, in addition to CPU and
+// memory.
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+)
+
+// ExtendedResources gives the amounts of extended resources (or hugepages) requested by a pod.
+//
+// It's stored in an encoded form, rather than as a map, so that Pod stays comparable. The zero
+// value has no extended resources.
+type ExtendedResources struct {
+	// encoded is the list of "name=amount", sorted by name and separated by commas. Resource names
+	// can't contain either character.
+	encoded string
+}
+
+// NewExtendedResources returns the ExtendedResources with the amounts given, ignoring any that are
+// zero.
+func NewExtendedResources(amounts map[corev1.ResourceName]int64) ExtendedResources {
+	var parts []string
+	for _, name := range slices.Sorted(maps.Keys(amounts)) {
+		if amounts[name] != 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", name, amounts[name]))
+		}
+	}
+	return ExtendedResources{encoded: strings.Join(parts, ",")}
+}
+
+// All returns an iterator over the resources and their amounts.
+func (r ExtendedResources) All() iter.Seq2[corev1.ResourceName, int64] {
+	return func(yield func(corev1.ResourceName, int64) bool) {
+		if r.encoded == "" {
+			return
+		}
+		for _, part := range strings.Split(r.encoded, ",") {
+			name, amount, _ := strings.Cut(part, "=")
+			value, err := strconv.ParseInt(amount, 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("invalid encoded extended resource %q: %s", part, err))
+			}
+			if !yield(corev1.ResourceName(name), value) {
+				return
+			}
+		}
+	}
+}
+
+// Get returns the amount of the resource, or zero if it's not present.
+func (r ExtendedResources) Get(name corev1.ResourceName) int64 {
+	for n, amount := range r.All() {
+		if n == name {
+			return amount
+		}
+	}
+	return 0
+}
+
+// Plus returns the ExtendedResources with the amounts added.
+func (r ExtendedResources) Plus(amounts map[corev1.ResourceName]int64) ExtendedResources {
+	if len(amounts) == 0 {
+		return r
+	}
+	sum := maps.Clone(amounts)
+	for name, amount := range r.All() {
+		sum[name] += amount
+	}
+	return NewExtendedResources(sum)
+}
+
+// String implements fmt.Stringer.
+func (r ExtendedResources) String() string {
+	return r.encoded
+}
+
+// extendedResourcesFromContainers returns the sum of the containers' requests for extended
+// resources and hugepages.
+func extendedResourcesFromContainers(containers []corev1.Container) ExtendedResources {
+	amounts := make(map[corev1.ResourceName]int64)
+	for _, container := range containers {
+		for name, q := range container.Resources.Requests {
+			if v1helper.IsExtendedResourceName(name) || v1helper.IsHugePageResourceName(name) {
+				amounts[name] += q.Value()
+			}
+		}
+	}
+	return NewExtendedResources(amounts)
+}
+
+// NodeExtendedResource tracks the amount of an extended resource on a node, and how much of it the
+// pods on the node have requested.
+type NodeExtendedResource struct {
+	// Total is the amount of the resource allocatable on the node.
+	Total int64
+	// Reserved is the sum of all Pods' requests for the resource.
+	Reserved int64
+	// Watermark is the amount of the resource above which the node is considered busy.
+	Watermark int64
+}
+
+// OverBudget returns whether the pods on the node have requested more of the resource than the
+// node has.
+func (r NodeExtendedResource) OverBudget() bool {
+	return r.Reserved > r.Total
+}
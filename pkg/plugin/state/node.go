@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"iter"
+	"maps"
 
+	"github.com/samber/lo"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/exp/constraints"
 
@@ -35,6 +37,28 @@ type Node struct {
 
 	CPU NodeResources[vmv1.MilliCPU]
 	Mem NodeResources[api.Bytes]
+
+	NetworkBandwidth NodeNetworkBandwidth
+
+	// ExtendedResources tracks the extended resources that the plugin is configured to track, for
+	// those that the node has.
+	ExtendedResources map[corev1.ResourceName]NodeExtendedResource
+}
+
+// NodeNetworkBandwidth tracks the network bandwidth of a node, in bits per second, and how much of
+// it the pods on the node are expected to use.
+type NodeNetworkBandwidth struct {
+	// Total is the node's network bandwidth. If zero, the node's bandwidth is unknown, and it is
+	// not limited.
+	Total uint64
+	// Reserved is the sum of all Pods' NetworkBandwidth.
+	Reserved uint64
+}
+
+// OverBudget returns whether the pods on the node are expected to use more network bandwidth than
+// the node has.
+func (b NodeNetworkBandwidth) OverBudget() bool {
+	return b.Total != 0 && b.Reserved > b.Total
 }
 
 // MarshalLogObject implements zapcore.ObjectMarshaler so that Node can be used with zap.Object
@@ -56,11 +80,33 @@ func (n Node) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	if err := enc.AddReflected("Mem", n.Mem); err != nil {
 		return err
 	}
+	if n.NetworkBandwidth.Total != 0 {
+		if err := enc.AddReflected("NetworkBandwidth", n.NetworkBandwidth); err != nil {
+			return err
+		}
+	}
+	if len(n.ExtendedResources) != 0 {
+		if err := enc.AddReflected("ExtendedResources", n.ExtendedResources); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type NodeResources[T constraints.Unsigned] struct {
-	// Total is the total amount of T available on the node.
+	// Capacity is the total amount of T on the node, from the Node's status.
+	//
+	// This value does not change.
+	Capacity T
+
+	// Allocatable is the amount of T on the node available for scheduling, after kube-reserved,
+	// system-reserved, and eviction thresholds are subtracted from Capacity.
+	//
+	// This value does not change.
+	Allocatable T
+
+	// Total is the total amount of T usable for VMs on the node. It is equal to Allocatable, minus
+	// any extra reserve from the plugin's config.
 	//
 	// This value does not change.
 	Total T
@@ -80,6 +126,14 @@ type NodeResources[T constraints.Unsigned] struct {
 	// Migrating is the amount of T that we expect will be removed by ongoing live migration.
 	Migrating T
 
+	// WarmPool is the amount of T reserved by unclaimed warm pool VMs, after applying overcommit
+	// factors. It is included in Reserved.
+	//
+	// Warm pool VMs are kept booted in advance so that they can be claimed by new VMs; until that
+	// happens, their capacity is speculative, so we don't allow them to push a node above
+	// Watermark.
+	WarmPool T
+
 	// Watermark is the amount of T reserved to pods above which we attempt to reduce usage via
 	// migration.
 	//
@@ -94,17 +148,32 @@ type NodeResourceField[T any] struct {
 
 func (r NodeResources[T]) Fields() []NodeResourceField[T] {
 	return []NodeResourceField[T]{
+		{"Capacity", r.Capacity},
+		{"Allocatable", r.Allocatable},
 		{"Total", r.Total},
 		{"Reserved", r.Reserved},
 		{"Migrating", r.Migrating},
+		{"WarmPool", r.WarmPool},
 		{"Watermark", r.Watermark},
 	}
 }
 
+// WatermarkFractions gives the fraction of each of the node's total resources above which VMs
+// should be migrated away.
+type WatermarkFractions struct {
+	CPU float64
+	Mem float64
+}
+
+// NodeStateFromK8sObj creates a new *Node from the k8s object.
+//
+// The node's total resources are its allocatable resources, minus any amount in reserve (e.g., for
+// system pods that aren't otherwise counted).
 func NodeStateFromK8sObj(
 	node *corev1.Node,
-	watermarkFraction float64,
+	watermarks WatermarkFractions,
 	keepLabels []string,
+	reserve api.Resources,
 ) (*Node, error) {
 	// Note that node.Status.Allocatable has the following docs:
 	//
@@ -118,24 +187,68 @@ func NodeStateFromK8sObj(
 	if cpuQ == nil {
 		return nil, errors.New("Node hsa no Allocatable CPU limit")
 	}
-	totalCPU := vmv1.MilliCPUFromResourceQuantity(*cpuQ)
+	allocatableCPU := vmv1.MilliCPUFromResourceQuantity(*cpuQ)
+	totalCPU := util.SaturatingSub(allocatableCPU, reserve.VCPU)
 
 	memQ := node.Status.Allocatable.Memory()
 	if memQ == nil {
 		return nil, errors.New("Node has no Allocatable Memory limit")
 	}
-	totalMem := api.BytesFromResourceQuantity(*memQ)
+	allocatableMem := api.BytesFromResourceQuantity(*memQ)
+	totalMem := util.SaturatingSub(allocatableMem, reserve.Mem)
 
 	labels := make(map[string]string)
 	for _, lbl := range keepLabels {
 		labels[lbl] = node.Labels[lbl]
 	}
 
-	return NodeStateFromParams(node.Name, totalCPU, totalMem, watermarkFraction, labels), nil
+	n := NodeStateFromParams(node.Name, totalCPU, totalMem, watermarks.CPU, labels)
+	n.Mem.Watermark = api.Bytes(float64(totalMem) * watermarks.Mem)
+
+	// Capacity defaults to Allocatable if it's not present (which shouldn't happen in practice).
+	n.CPU.Allocatable = allocatableCPU
+	n.CPU.Capacity = allocatableCPU
+	if q, ok := node.Status.Capacity[corev1.ResourceCPU]; ok {
+		n.CPU.Capacity = vmv1.MilliCPUFromResourceQuantity(q)
+	}
+	n.Mem.Allocatable = allocatableMem
+	n.Mem.Capacity = allocatableMem
+	if q, ok := node.Status.Capacity[corev1.ResourceMemory]; ok {
+		n.Mem.Capacity = api.BytesFromResourceQuantity(q)
+	}
+
+	return n, nil
+}
+
+// NodeExtendedResourcesFromK8sObj returns the state of the node's extended resources, for each
+// resource in watermarks that the node has allocatable.
+//
+// The returned resources have nothing reserved. When used to update an existing node, the reserved
+// amounts are recalculated from its pods.
+func NodeExtendedResourcesFromK8sObj(
+	node *corev1.Node,
+	watermarks map[corev1.ResourceName]float64,
+) map[corev1.ResourceName]NodeExtendedResource {
+	resources := make(map[corev1.ResourceName]NodeExtendedResource)
+	for name, fraction := range watermarks {
+		q, ok := node.Status.Allocatable[name]
+		if !ok {
+			continue
+		}
+		total := q.Value()
+		resources[name] = NodeExtendedResource{
+			Total:     total,
+			Reserved:  0,
+			Watermark: int64(float64(total) * fraction),
+		}
+	}
+	return resources
 }
 
 // NodeStateFromParams is a helper to construct a *Node, primarily for use in tests.
 //
+// The node's Capacity and Allocatable are both set to the total.
+//
 // For practical usage, see NodeStateFromK8sObj.
 func NodeStateFromParams(
 	name string,
@@ -156,17 +269,28 @@ func NodeStateFromParams(
 		pods:           NewXactMap[types.UID, Pod](),
 		migratablePods: NewXactMap[types.UID, struct{}](),
 		CPU: NodeResources[vmv1.MilliCPU]{
-			Total:     totalCPU,
-			Reserved:  0,
-			Migrating: 0,
-			Watermark: vmv1.MilliCPU(float64(totalCPU) * watermarkFraction),
+			Capacity:    totalCPU,
+			Allocatable: totalCPU,
+			Total:       totalCPU,
+			Reserved:    0,
+			Migrating:   0,
+			WarmPool:    0,
+			Watermark:   vmv1.MilliCPU(float64(totalCPU) * watermarkFraction),
 		},
 		Mem: NodeResources[api.Bytes]{
-			Total:     totalMem,
-			Reserved:  0,
-			Migrating: 0,
-			Watermark: api.Bytes(float64(totalMem) * watermarkFraction),
+			Capacity:    totalMem,
+			Allocatable: totalMem,
+			Total:       totalMem,
+			Reserved:    0,
+			Migrating:   0,
+			WarmPool:    0,
+			Watermark:   api.Bytes(float64(totalMem) * watermarkFraction),
+		},
+		NetworkBandwidth: NodeNetworkBandwidth{
+			Total:    0,
+			Reserved: 0,
 		},
+		ExtendedResources: make(map[corev1.ResourceName]NodeExtendedResource),
 	}
 }
 
@@ -175,18 +299,30 @@ func (n *Node) OverBudget() bool {
 	return n.CPU.Reserved > n.CPU.Total || n.Mem.Reserved > n.Mem.Total
 }
 
+// WarmPoolOverWatermark returns whether this node's unclaimed warm pool VMs have taken it above
+// Watermark.
+//
+// Warm pool VMs are placed only where there's room to spare: they shouldn't cause migrations of
+// VMs that are actually in use.
+func (n *Node) WarmPoolOverWatermark() bool {
+	return (n.CPU.WarmPool != 0 && n.CPU.Reserved > n.CPU.Watermark) ||
+		(n.Mem.WarmPool != 0 && n.Mem.Reserved > n.Mem.Watermark)
+}
+
 // Speculatively allows attempting a modification to the node before deciding whether to actually
 // commit that change.
 //
 // Any of the fields of the node can be updated, including its pods.
 func (n *Node) Speculatively(modify func(n *Node) (commit bool)) (committed bool) {
 	tmp := &Node{
-		Name:           n.Name,
-		Labels:         n.Labels.NewTransaction(),
-		pods:           n.pods.NewTransaction(),
-		migratablePods: n.migratablePods.NewTransaction(),
-		CPU:            n.CPU,
-		Mem:            n.Mem,
+		Name:              n.Name,
+		Labels:            n.Labels.NewTransaction(),
+		pods:              n.pods.NewTransaction(),
+		migratablePods:    n.migratablePods.NewTransaction(),
+		CPU:               n.CPU,
+		Mem:               n.Mem,
+		NetworkBandwidth:  n.NetworkBandwidth,
+		ExtendedResources: maps.Clone(n.ExtendedResources),
 	}
 	commit := modify(tmp)
 	if commit {
@@ -195,6 +331,8 @@ func (n *Node) Speculatively(modify func(n *Node) (commit bool)) (committed bool
 		tmp.migratablePods.Commit()
 		n.CPU = tmp.CPU
 		n.Mem = tmp.Mem
+		n.NetworkBandwidth = tmp.NetworkBandwidth
+		n.ExtendedResources = tmp.ExtendedResources
 	}
 	return commit
 }
@@ -210,7 +348,11 @@ func (n *Node) Update(newState *Node) (changed bool) {
 	}
 
 	changed = newState.CPU.Total != n.CPU.Total || newState.Mem.Total != n.Mem.Total ||
-		newState.CPU.Watermark != n.CPU.Watermark || newState.Mem.Watermark != n.Mem.Watermark
+		newState.CPU.Watermark != n.CPU.Watermark || newState.Mem.Watermark != n.Mem.Watermark ||
+		newState.CPU.Capacity != n.CPU.Capacity || newState.Mem.Capacity != n.Mem.Capacity ||
+		newState.CPU.Allocatable != n.CPU.Allocatable || newState.Mem.Allocatable != n.Mem.Allocatable ||
+		newState.NetworkBandwidth.Total != n.NetworkBandwidth.Total ||
+		!extendedResourceTotalsEqual(newState.ExtendedResources, n.ExtendedResources)
 
 	// Propagate changes to labels:
 	for label, value := range newState.Labels.Entries() {
@@ -238,22 +380,50 @@ func (n *Node) Update(newState *Node) (changed bool) {
 		pods:           n.pods,
 		migratablePods: n.migratablePods,
 		CPU: NodeResources[vmv1.MilliCPU]{
-			Total:     newState.CPU.Total,
-			Reserved:  n.CPU.Reserved,
-			Migrating: n.CPU.Migrating,
-			Watermark: newState.CPU.Watermark,
+			Capacity:    newState.CPU.Capacity,
+			Allocatable: newState.CPU.Allocatable,
+			Total:       newState.CPU.Total,
+			Reserved:    n.CPU.Reserved,
+			Migrating:   n.CPU.Migrating,
+			WarmPool:    n.CPU.WarmPool,
+			Watermark:   newState.CPU.Watermark,
 		},
 		Mem: NodeResources[api.Bytes]{
-			Total:     newState.Mem.Total,
-			Reserved:  n.Mem.Reserved,
-			Migrating: n.Mem.Migrating,
-			Watermark: newState.Mem.Watermark,
+			Capacity:    newState.Mem.Capacity,
+			Allocatable: newState.Mem.Allocatable,
+			Total:       newState.Mem.Total,
+			Reserved:    n.Mem.Reserved,
+			Migrating:   n.Mem.Migrating,
+			WarmPool:    n.Mem.WarmPool,
+			Watermark:   newState.Mem.Watermark,
+		},
+		NetworkBandwidth: NodeNetworkBandwidth{
+			Total:    newState.NetworkBandwidth.Total,
+			Reserved: n.NetworkBandwidth.Reserved,
 		},
+		ExtendedResources: make(map[corev1.ResourceName]NodeExtendedResource),
+	}
+
+	// The set of tracked resources may have changed, so recalculate what's reserved.
+	for name, r := range newState.ExtendedResources {
+		r.Reserved = 0
+		for _, pod := range n.pods.Entries() {
+			r.Reserved += pod.ExtendedResources.Get(name)
+		}
+		n.ExtendedResources[name] = r
 	}
 
 	return
 }
 
+// extendedResourceTotalsEqual returns whether the two sets of extended resources have the same
+// resources, with the same totals and watermarks.
+func extendedResourceTotalsEqual(x, y map[corev1.ResourceName]NodeExtendedResource) bool {
+	return maps.EqualFunc(x, y, func(rx, ry NodeExtendedResource) bool {
+		return rx.Total == ry.Total && rx.Watermark == ry.Watermark
+	})
+}
+
 // GetPod returns a copy of the state for the Pod with the given UID, or false if no such Pod is
 // present in the Node's state.
 func (n *Node) GetPod(uid types.UID) (_ Pod, ok bool) {
@@ -265,6 +435,17 @@ func (n *Node) Pods() iter.Seq2[types.UID, Pod] {
 	return n.pods.Entries()
 }
 
+// VMs returns the number of VM pods on the node.
+func (n *Node) VMs() int {
+	count := 0
+	for _, pod := range n.pods.Entries() {
+		if !lo.IsEmpty(pod.VirtualMachine) {
+			count += 1
+		}
+	}
+	return count
+}
+
 // MigratablePods returns an iterator through the migratable pods on the node.
 //
 // This method is provided as a specialized version of (*Node).Pods() in order to support more
@@ -290,8 +471,10 @@ func (n *Node) AddPod(pod Pod) {
 		panic("cannot add Pod that already exists")
 	}
 
-	n.CPU.add(&pod.CPU, pod.Migrating)
-	n.Mem.add(&pod.Mem, pod.Migrating)
+	n.CPU.add(&pod.CPU, pod.Migrating, pod.WarmPool)
+	n.Mem.add(&pod.Mem, pod.Migrating, pod.WarmPool)
+	n.NetworkBandwidth.Reserved += pod.NetworkBandwidth
+	n.addExtendedResources(pod.ExtendedResources, 1)
 	n.pods.Set(pod.UID, pod)
 	if pod.Migratable {
 		n.migratablePods.Set(pod.UID, struct{}{})
@@ -324,8 +507,8 @@ func (n *Node) UpdatePod(oldPod, newPod Pod) (changed bool) {
 // ReconcilePodReserved will make all possible progress on updating the Pod's reserved resources
 // based on the what's requested for the pod and what's available.
 //
-// Currently, that's just updating the reserved resources to match what's requested (or, as much as
-// is possible).
+// Currently, that's updating the reserved resources to match what's requested (or, as much as is
+// possible), and then doing the same for any preapproval that's been requested.
 //
 // The new values of the Pod's resources will be left in the provided Pod.
 //
@@ -336,8 +519,11 @@ func (n *Node) ReconcilePodReserved(pod *Pod) (done bool) {
 		panic("cannot reconcile reserved resources for Pod that doesn't exist in the node state")
 	}
 
-	cpuDone := n.CPU.reconcilePod(&pod.CPU, pod.Migrating)
-	memDone := n.Mem.reconcilePod(&pod.Mem, pod.Migrating)
+	cpuDone := n.CPU.reconcilePod(&pod.CPU, pod.Migrating, pod.WarmPool)
+	memDone := n.Mem.reconcilePod(&pod.Mem, pod.Migrating, pod.WarmPool)
+	// Preapproval is best-effort, so it doesn't affect whether we're done.
+	n.CPU.reconcilePreapproval(&pod.CPU, pod.Migrating, pod.WarmPool)
+	n.Mem.reconcilePreapproval(&pod.Mem, pod.Migrating, pod.WarmPool)
 	n.pods.Set(pod.UID, *pod)
 
 	return cpuDone && memDone
@@ -355,68 +541,137 @@ func (n *Node) RemovePod(uid types.UID) (exists bool) {
 
 	n.pods.Delete(uid)
 	n.migratablePods.Delete(uid)
-	n.CPU.remove(pod.CPU, pod.Migrating)
-	n.Mem.remove(pod.Mem, pod.Migrating)
+	n.CPU.remove(pod.CPU, pod.Migrating, pod.WarmPool)
+	n.Mem.remove(pod.Mem, pod.Migrating, pod.WarmPool)
+	n.NetworkBandwidth.Reserved -= pod.NetworkBandwidth
+	n.addExtendedResources(pod.ExtendedResources, -1)
 	return true
 }
 
+// addExtendedResources adds sign * the pod's requests for each of the node's tracked extended
+// resources to their reserved amounts.
+func (n *Node) addExtendedResources(resources ExtendedResources, sign int64) {
+	for name, amount := range resources.All() {
+		if r, ok := n.ExtendedResources[name]; ok {
+			r.Reserved += sign * amount
+			n.ExtendedResources[name] = r
+		}
+	}
+}
+
+// ExtendedResourcesOverBudget returns the names of the extended resources requested by the pod
+// that the node has more of reserved than in total, sorted by name.
+func (n *Node) ExtendedResourcesOverBudget(pod Pod) []corev1.ResourceName {
+	var names []corev1.ResourceName
+	for name := range pod.ExtendedResources.All() {
+		if r, ok := n.ExtendedResources[name]; ok && r.OverBudget() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // applyOvercommit turns pod-level resource requests into node-level capacity change
 func applyOvercommit[T constraints.Integer](value T, overcommit *resource.Quantity) T {
 	return T(int64(value) * 1000 / overcommit.MilliValue())
 }
 
-func (r *NodeResources[T]) add(p *PodResources[T], migrating bool) {
-	actualReserved := applyOvercommit(p.Reserved, p.Overcommit)
+func (r *NodeResources[T]) add(p *PodResources[T], migrating bool, warmPool bool) {
+	actualReserved := applyOvercommit(p.reservedOnNode(), p.Overcommit)
 
 	r.Reserved += actualReserved
 	if migrating {
 		r.Migrating += actualReserved
 	}
+	if warmPool {
+		r.WarmPool += actualReserved
+	}
 }
 
-func (r *NodeResources[T]) remove(p PodResources[T], migrating bool) {
-	actualReserved := applyOvercommit(p.Reserved, p.Overcommit)
+func (r *NodeResources[T]) remove(p PodResources[T], migrating bool, warmPool bool) {
+	actualReserved := applyOvercommit(p.reservedOnNode(), p.Overcommit)
 
 	r.Reserved -= actualReserved
 	if migrating {
 		r.Migrating -= actualReserved
 	}
+	if warmPool {
+		r.WarmPool -= actualReserved
+	}
 }
 
-func (r *NodeResources[T]) reconcilePod(p *PodResources[T], migrating bool) (done bool) {
+func (r *NodeResources[T]) reconcilePod(p *PodResources[T], migrating bool, warmPool bool) (done bool) {
 	if p.Requested == p.Reserved {
 		return true // nothing to do!
 	}
 
 	if p.Requested < p.Reserved {
 		// Easy enough - we can just make the reduction.
-		r.remove(*p, migrating)
+		r.remove(*p, migrating, warmPool)
 		p.Reserved = p.Requested
-		r.add(p, migrating)
+		r.add(p, migrating, warmPool)
 		return true // nothing to do!
 	}
 
 	desiredIncrease := p.Requested - p.Reserved
 
 	// Difficult case: Requested is greater than Reserved -- how much can we give?
-	// The answer is relative to the overcommit -- taking Total-Reserved and inverting the
-	// overcommit so that we translate the amount relative to the *pod's* overcommit.
+	//
+	// Anything up to the preapproved amount is already set aside for the pod, so we can give all
+	// of that without needing any more capacity.
+	preapprovedIncrease := util.SaturatingSub(p.Preapproved, p.Reserved)
+
+	// Beyond that, the answer is relative to the overcommit -- taking Total-Reserved and inverting
+	// the overcommit so that we translate the amount relative to the *pod's* overcommit.
 	remaining := T(int64(util.SaturatingSub(r.Total, r.Reserved)) * p.Overcommit.MilliValue() / 1000)
 
 	// (X / M) * M is equivalent to floor(X / M) -- any amount that we give must be a multiple of
 	// the factor (roughly, the compute unit).
-	maxIncrease := (remaining / p.Factor) * p.Factor
+	maxIncrease := preapprovedIncrease + (remaining/p.Factor)*p.Factor
 
 	actualIncrease := min(maxIncrease, desiredIncrease)
 	if actualIncrease != 0 {
-		r.remove(*p, migrating)
+		r.remove(*p, migrating, warmPool)
 		p.Reserved += actualIncrease
-		r.add(p, migrating)
+		r.add(p, migrating, warmPool)
 	}
 	// We're done iff everything that was asked for has been granted
 	return p.Reserved == p.Requested
 }
 
+// reconcilePreapproval updates the Pod's Preapproved amount to match PreapprovalRequested, as much
+// as is possible without going over the node's Total.
+func (r *NodeResources[T]) reconcilePreapproval(p *PodResources[T], migrating bool, warmPool bool) (done bool) {
+	if p.PreapprovalRequested == p.Preapproved {
+		return true
+	}
+
+	// Reducing the preapproval -- or increasing it within what's already reserved -- doesn't need
+	// any extra capacity.
+	if p.PreapprovalRequested <= p.reservedOnNode() {
+		r.remove(*p, migrating, warmPool)
+		p.Preapproved = p.PreapprovalRequested
+		r.add(p, migrating, warmPool)
+		return true
+	}
+
+	desiredIncrease := p.PreapprovalRequested - p.reservedOnNode()
+
+	// Same as in reconcilePod: only give multiples of the factor, relative to the pod's overcommit.
+	remaining := T(int64(util.SaturatingSub(r.Total, r.Reserved)) * p.Overcommit.MilliValue() / 1000)
+	var maxIncrease T
+	if p.Factor != 0 {
+		maxIncrease = (remaining / p.Factor) * p.Factor
+	}
+
+	actualIncrease := min(maxIncrease, desiredIncrease)
+	r.remove(*p, migrating, warmPool)
+	p.Preapproved = p.reservedOnNode() + actualIncrease
+	r.add(p, migrating, warmPool)
+
+	return p.Preapproved == p.PreapprovalRequested
+}
+
 // UnmigratedAboveWatermark returns the amount of T above Watermark that isn't already being
 // migrated.
 //
@@ -10,7 +10,9 @@ import (
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
@@ -34,23 +36,33 @@ func fixedPod(id int, cpu vmv1.MilliCPU, mem api.Bytes) state.Pod {
 			Name:      fmt.Sprintf("pod-name-%d", id),
 			Namespace: "test-namespace",
 		},
-		UID:            podUID(id),
-		CreatedAt:      createdAt,
-		VirtualMachine: lo.Empty[util.NamespacedName](),
-		Migratable:     false,
-		AlwaysMigrate:  false,
-		Migrating:      false,
+		UID:               podUID(id),
+		CreatedAt:         createdAt,
+		VirtualMachine:    lo.Empty[util.NamespacedName](),
+		Migratable:        false,
+		AlwaysMigrate:     false,
+		DisruptionGroup:   "",
+		Migrating:         false,
+		NetworkBandwidth:  0,
+		ExtendedResources: state.ExtendedResources{},
+		WarmPool:          false,
+		Priority:          0,
+		MigrationCost:     0,
 		CPU: state.PodResources[vmv1.MilliCPU]{
-			Reserved:   cpu,
-			Requested:  cpu,
-			Factor:     0,
-			Overcommit: lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			Reserved:             cpu,
+			Requested:            cpu,
+			Preapproved:          0,
+			PreapprovalRequested: 0,
+			Factor:               0,
+			Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
 		},
 		Mem: state.PodResources[api.Bytes]{
-			Reserved:   mem,
-			Requested:  mem,
-			Factor:     0,
-			Overcommit: lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			Reserved:             mem,
+			Requested:            mem,
+			Preapproved:          0,
+			PreapprovalRequested: 0,
+			Factor:               0,
+			Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
 		},
 	}
 }
@@ -70,34 +82,240 @@ func TestBasicNodeOperations(t *testing.T) {
 	node.AddPod(fixedPod(1, 2*cpu, 8*gib))
 	node.AddPod(fixedPod(2, 1*cpu, 4*gib))
 	assert.Equal(t, state.NodeResources[vmv1.MilliCPU]{
-		Total:     10 * cpu,
-		Reserved:  3 * cpu,
-		Watermark: 8 * cpu,
-		Migrating: 0,
+		Capacity:    10 * cpu,
+		Allocatable: 10 * cpu,
+		Total:       10 * cpu,
+		Reserved:    3 * cpu,
+		Watermark:   8 * cpu,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.CPU)
 	assert.Equal(t, state.NodeResources[api.Bytes]{
-		Total:     40 * gib,
-		Reserved:  12 * gib,
-		Watermark: 32 * gib,
-		Migrating: 0,
+		Capacity:    40 * gib,
+		Allocatable: 40 * gib,
+		Total:       40 * gib,
+		Reserved:    12 * gib,
+		Watermark:   32 * gib,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.Mem)
 
 	node.RemovePod(podUID(2))
 
 	assert.Equal(t, state.NodeResources[vmv1.MilliCPU]{
-		Total:     10 * cpu,
-		Reserved:  2 * cpu,
-		Watermark: 8 * cpu,
-		Migrating: 0,
+		Capacity:    10 * cpu,
+		Allocatable: 10 * cpu,
+		Total:       10 * cpu,
+		Reserved:    2 * cpu,
+		Watermark:   8 * cpu,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.CPU)
 	assert.Equal(t, state.NodeResources[api.Bytes]{
-		Total:     40 * gib,
-		Reserved:  8 * gib,
-		Watermark: 32 * gib,
-		Migrating: 0,
+		Capacity:    40 * gib,
+		Allocatable: 40 * gib,
+		Total:       40 * gib,
+		Reserved:    8 * gib,
+		Watermark:   32 * gib,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.Mem)
 }
 
+func TestNodeVMCount(t *testing.T) {
+	cpu := vmv1.MilliCPU(1000)
+	gib := api.Bytes(1024 * 1024 * 1024)
+
+	vmPod := func(id int) state.Pod {
+		pod := fixedPod(id, 1*cpu, 4*gib)
+		pod.VirtualMachine = util.NamespacedName{
+			Name:      fmt.Sprintf("vm-name-%d", id),
+			Namespace: "test-namespace",
+		}
+		return pod
+	}
+
+	node := state.NodeStateFromParams(
+		"node-1",
+		10*cpu,
+		40*gib,
+		defaultWatermarkFraction,
+		map[string]string{},
+	)
+	assert.Equal(t, 0, node.VMs())
+
+	node.AddPod(vmPod(1))
+	node.AddPod(fixedPod(2, 1*cpu, 4*gib))
+	node.AddPod(vmPod(3))
+	assert.Equal(t, 2, node.VMs())
+
+	node.RemovePod(podUID(2))
+	assert.Equal(t, 2, node.VMs())
+
+	node.RemovePod(podUID(1))
+	assert.Equal(t, 1, node.VMs())
+}
+
+func TestNodeNetworkBandwidth(t *testing.T) {
+	cpu := vmv1.MilliCPU(1000)
+	gib := api.Bytes(1024 * 1024 * 1024)
+	gbit := uint64(1000 * 1000 * 1000)
+
+	bandwidthPod := func(id int, bandwidth uint64) state.Pod {
+		pod := fixedPod(id, 1*cpu, 4*gib)
+		pod.NetworkBandwidth = bandwidth
+		return pod
+	}
+
+	node := state.NodeStateFromParams(
+		"node-1",
+		10*cpu,
+		40*gib,
+		defaultWatermarkFraction,
+		map[string]string{},
+	)
+	node.NetworkBandwidth.Total = 10 * gbit
+
+	node.AddPod(bandwidthPod(1, 4*gbit))
+	node.AddPod(fixedPod(2, 1*cpu, 4*gib))
+	assert.Equal(t, 4*gbit, node.NetworkBandwidth.Reserved)
+	assert.False(t, node.NetworkBandwidth.OverBudget())
+
+	// Speculative changes shouldn't leak through if not committed
+	node.Speculatively(func(n *state.Node) (commit bool) {
+		n.AddPod(bandwidthPod(3, 8*gbit))
+		assert.Equal(t, 12*gbit, n.NetworkBandwidth.Reserved)
+		assert.True(t, n.NetworkBandwidth.OverBudget())
+		return false
+	})
+	assert.Equal(t, 4*gbit, node.NetworkBandwidth.Reserved)
+
+	node.AddPod(bandwidthPod(3, 6*gbit))
+	assert.Equal(t, 10*gbit, node.NetworkBandwidth.Reserved)
+	assert.False(t, node.NetworkBandwidth.OverBudget())
+
+	node.RemovePod(podUID(1))
+	assert.Equal(t, 6*gbit, node.NetworkBandwidth.Reserved)
+
+	// Nodes with unknown bandwidth are never over budget
+	node.NetworkBandwidth.Total = 0
+	node.AddPod(bandwidthPod(4, 100*gbit))
+	assert.False(t, node.NetworkBandwidth.OverBudget())
+}
+
+func TestNodeExtendedResources(t *testing.T) {
+	cpu := vmv1.MilliCPU(1000)
+	gib := api.Bytes(1024 * 1024 * 1024)
+	const gpu = corev1.ResourceName("nvidia.com/gpu")
+
+	gpuPod := func(id int, gpus int64) state.Pod {
+		pod := fixedPod(id, 1*cpu, 4*gib)
+		pod.ExtendedResources = state.NewExtendedResources(map[corev1.ResourceName]int64{gpu: gpus})
+		return pod
+	}
+
+	k8sNode := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, //nolint:exhaustruct // only need the name
+		Status: corev1.NodeStatus{ //nolint:exhaustruct // only need allocatable
+			Allocatable: corev1.ResourceList{gpu: resource.MustParse("4")},
+		},
+	}
+
+	node := state.NodeStateFromParams("node-1", 10*cpu, 40*gib, defaultWatermarkFraction, map[string]string{})
+	node.AddPod(gpuPod(1, 2))
+	node.AddPod(fixedPod(2, 1*cpu, 4*gib))
+	// Not tracked yet, so nothing's reserved
+	assert.Empty(t, node.ExtendedResources)
+
+	// Start tracking the resource: existing pods are counted
+	updated := state.NodeStateFromParams("node-1", 10*cpu, 40*gib, defaultWatermarkFraction, map[string]string{})
+	updated.ExtendedResources = state.NodeExtendedResourcesFromK8sObj(k8sNode, map[corev1.ResourceName]float64{gpu: 0.5})
+	assert.True(t, node.Update(updated))
+	assert.Equal(t, state.NodeExtendedResource{Total: 4, Reserved: 2, Watermark: 2}, node.ExtendedResources[gpu])
+
+	// Speculative changes shouldn't leak through if not committed
+	node.Speculatively(func(n *state.Node) (commit bool) {
+		n.AddPod(gpuPod(3, 3))
+		assert.Equal(t, int64(5), n.ExtendedResources[gpu].Reserved)
+		assert.Equal(t, []corev1.ResourceName{gpu}, n.ExtendedResourcesOverBudget(gpuPod(3, 3)))
+		// Pods that don't request the resource aren't blocked by it
+		assert.Empty(t, n.ExtendedResourcesOverBudget(fixedPod(4, 1*cpu, 4*gib)))
+		return false
+	})
+	assert.Equal(t, int64(2), node.ExtendedResources[gpu].Reserved)
+
+	node.AddPod(gpuPod(3, 2))
+	assert.Equal(t, int64(4), node.ExtendedResources[gpu].Reserved)
+	assert.Empty(t, node.ExtendedResourcesOverBudget(gpuPod(3, 2)))
+
+	node.RemovePod(podUID(1))
+	assert.Equal(t, int64(2), node.ExtendedResources[gpu].Reserved)
+}
+
+func TestExtendedResourcesEncoding(t *testing.T) {
+	r := state.NewExtendedResources(map[corev1.ResourceName]int64{
+		"nvidia.com/gpu":  2,
+		"example.com/foo": 1,
+		"example.com/bar": 0,
+	})
+	assert.Equal(t, "example.com/foo=1,nvidia.com/gpu=2", r.String())
+	assert.Equal(t, int64(2), r.Get("nvidia.com/gpu"))
+	assert.Equal(t, int64(0), r.Get("example.com/bar"))
+	// Encoding is canonical, so that pods are comparable
+	assert.Equal(t, r, state.NewExtendedResources(map[corev1.ResourceName]int64{"nvidia.com/gpu": 2, "example.com/foo": 1}))
+	assert.Equal(t, state.ExtendedResources{}, state.NewExtendedResources(nil))
+}
+
+func TestNodeWarmPool(t *testing.T) {
+	cpu := vmv1.MilliCPU(1000)
+	gib := api.Bytes(1024 * 1024 * 1024)
+
+	warmPoolPod := func(id int, cpu vmv1.MilliCPU, mem api.Bytes) state.Pod {
+		pod := fixedPod(id, cpu, mem)
+		pod.WarmPool = true
+		return pod
+	}
+
+	node := state.NodeStateFromParams(
+		"node-1",
+		10*cpu,
+		40*gib,
+		defaultWatermarkFraction,
+		map[string]string{},
+	)
+
+	node.AddPod(fixedPod(1, 4*cpu, 16*gib))
+	node.AddPod(warmPoolPod(2, 2*cpu, 8*gib))
+	assert.Equal(t, 6*cpu, node.CPU.Reserved)
+	assert.Equal(t, 2*cpu, node.CPU.WarmPool)
+	assert.Equal(t, 8*gib, node.Mem.WarmPool)
+	assert.False(t, node.WarmPoolOverWatermark())
+
+	// Another warm pool VM would take the node above the watermark, even though it fits.
+	node.Speculatively(func(n *state.Node) (commit bool) {
+		n.AddPod(warmPoolPod(3, 3*cpu, 12*gib))
+		assert.Equal(t, 5*cpu, n.CPU.WarmPool)
+		assert.False(t, n.OverBudget())
+		assert.True(t, n.WarmPoolOverWatermark())
+		return false
+	})
+	assert.Equal(t, 2*cpu, node.CPU.WarmPool)
+
+	// Once the VM is claimed, its resources are no longer counted as part of the warm pool.
+	oldPod, ok := node.GetPod(podUID(2))
+	assert.True(t, ok)
+	newPod := oldPod
+	newPod.WarmPool = false
+	node.UpdatePod(oldPod, newPod)
+	assert.Equal(t, 6*cpu, node.CPU.Reserved)
+	assert.Equal(t, vmv1.MilliCPU(0), node.CPU.WarmPool)
+	assert.Equal(t, api.Bytes(0), node.Mem.WarmPool)
+
+	// Regular pods above the watermark aren't the warm pool's concern.
+	node.AddPod(fixedPod(3, 3*cpu, 12*gib))
+	assert.False(t, node.WarmPoolOverWatermark())
+}
+
 func TestSpeculativeNodeOperations(t *testing.T) {
 	cpu := vmv1.MilliCPU(1000)
 	gib := api.Bytes(1024 * 1024 * 1024)
@@ -113,16 +331,22 @@ func TestSpeculativeNodeOperations(t *testing.T) {
 	// add a single pod to start with
 	node.AddPod(fixedPod(1, 2*cpu, 8*gib))
 	assert.Equal(t, state.NodeResources[vmv1.MilliCPU]{
-		Total:     10 * cpu,
-		Reserved:  2 * cpu,
-		Watermark: 8 * cpu,
-		Migrating: 0,
+		Capacity:    10 * cpu,
+		Allocatable: 10 * cpu,
+		Total:       10 * cpu,
+		Reserved:    2 * cpu,
+		Watermark:   8 * cpu,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.CPU)
 	assert.Equal(t, state.NodeResources[api.Bytes]{
-		Total:     40 * gib,
-		Reserved:  8 * gib,
-		Watermark: 32 * gib,
-		Migrating: 0,
+		Capacity:    40 * gib,
+		Allocatable: 40 * gib,
+		Total:       40 * gib,
+		Reserved:    8 * gib,
+		Watermark:   32 * gib,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.Mem)
 
 	// try out removing a pod + adding a new one, but don't go through with it
@@ -138,16 +362,22 @@ func TestSpeculativeNodeOperations(t *testing.T) {
 	assert.Equal(t, true, ok(node.GetPod(podUID(1))))
 	assert.Equal(t, false, ok(node.GetPod(podUID(2))))
 	assert.Equal(t, state.NodeResources[vmv1.MilliCPU]{
-		Total:     10 * cpu,
-		Reserved:  2 * cpu,
-		Watermark: 8 * cpu,
-		Migrating: 0,
+		Capacity:    10 * cpu,
+		Allocatable: 10 * cpu,
+		Total:       10 * cpu,
+		Reserved:    2 * cpu,
+		Watermark:   8 * cpu,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.CPU)
 	assert.Equal(t, state.NodeResources[api.Bytes]{
-		Total:     40 * gib,
-		Reserved:  8 * gib,
-		Watermark: 32 * gib,
-		Migrating: 0,
+		Capacity:    40 * gib,
+		Allocatable: 40 * gib,
+		Total:       40 * gib,
+		Reserved:    8 * gib,
+		Watermark:   32 * gib,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.Mem)
 
 	// same as before, but actually do it
@@ -159,16 +389,22 @@ func TestSpeculativeNodeOperations(t *testing.T) {
 	assert.Equal(t, false, ok(node.GetPod(podUID(1))))
 	assert.Equal(t, true, ok(node.GetPod(podUID(2))))
 	assert.Equal(t, state.NodeResources[vmv1.MilliCPU]{
-		Total:     10 * cpu,
-		Reserved:  3 * cpu,
-		Watermark: 8 * cpu,
-		Migrating: 0,
+		Capacity:    10 * cpu,
+		Allocatable: 10 * cpu,
+		Total:       10 * cpu,
+		Reserved:    3 * cpu,
+		Watermark:   8 * cpu,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.CPU)
 	assert.Equal(t, state.NodeResources[api.Bytes]{
-		Total:     40 * gib,
-		Reserved:  12 * gib,
-		Watermark: 32 * gib,
-		Migrating: 0,
+		Capacity:    40 * gib,
+		Allocatable: 40 * gib,
+		Total:       40 * gib,
+		Reserved:    12 * gib,
+		Watermark:   32 * gib,
+		Migrating:   0,
+		WarmPool:    0,
 	}, node.Mem)
 }
 
@@ -219,20 +455,30 @@ func TestPodReconciling(t *testing.T) {
 				Name:      "vm-name",
 				Namespace: "test-namespace",
 			},
-			Migratable:    false,
-			AlwaysMigrate: false,
-			Migrating:     false,
+			Migratable:        false,
+			AlwaysMigrate:     false,
+			DisruptionGroup:   "",
+			Migrating:         false,
+			NetworkBandwidth:  0,
+			ExtendedResources: state.ExtendedResources{},
+			WarmPool:          false,
+			Priority:          0,
+			MigrationCost:     0,
 			CPU: state.PodResources[vmv1.MilliCPU]{
-				Reserved:   p.cpu.reserved,
-				Requested:  p.cpu.requested,
-				Factor:     factorCPU,
-				Overcommit: overcommitFactors.cpu,
+				Reserved:             p.cpu.reserved,
+				Requested:            p.cpu.requested,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               factorCPU,
+				Overcommit:           overcommitFactors.cpu,
 			},
 			Mem: state.PodResources[api.Bytes]{
-				Reserved:   p.mem.reserved,
-				Requested:  p.mem.requested,
-				Factor:     factorMem,
-				Overcommit: overcommitFactors.mem,
+				Reserved:             p.mem.reserved,
+				Requested:            p.mem.requested,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               factorMem,
+				Overcommit:           overcommitFactors.mem,
 			},
 		}
 	}
@@ -15,6 +15,7 @@ import (
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/api/cuarith"
 	"github.com/neondatabase/autoscaling/pkg/util"
 )
 
@@ -37,9 +38,34 @@ type Pod struct {
 	// label to mark that this pod should be continuously migrated.
 	AlwaysMigrate bool
 
+	// DisruptionGroup, if not empty, gives the value of the VM's disruption group label. At most
+	// one VM in each disruption group (per namespace) may be migrating at a time.
+	DisruptionGroup string
+
 	// Migrating is true iff there is a VirtualMachineMigration with this pod as the source.
 	Migrating bool
 
+	// NetworkBandwidth is the network bandwidth, in bits per second, that the pod is expected to
+	// use, from the VM's api.AnnotationNetworkBandwidth. It is zero for non-VM pods.
+	NetworkBandwidth uint64
+
+	// ExtendedResources gives the pod's requests for extended resources (e.g., "nvidia.com/gpu") or
+	// hugepages, summed across its containers -- plus, for VMs, any that the plugin is configured
+	// to require from the VM's annotations.
+	ExtendedResources ExtendedResources
+
+	// WarmPool is true if this Pod is owned by a VirtualMachine in a warm pool that has not yet
+	// been claimed, from the vmv1.WarmPoolNameLabel.
+	WarmPool bool
+
+	// Priority is the pod's priority, from its PriorityClass. It is zero if the pod has none.
+	Priority int32
+
+	// MigrationCost is the relative cost of live migrating the pod's VM, from the VM's
+	// api.AnnotationMigrationCost. VMs with a lower cost are chosen for migration first. It is zero
+	// for non-VM pods, or if the annotation is not set.
+	MigrationCost uint64
+
 	CPU PodResources[vmv1.MilliCPU]
 	Mem PodResources[api.Bytes]
 }
@@ -56,7 +82,25 @@ func (p Pod) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 		}
 		enc.AddBool("Migratable", p.Migratable)
 		enc.AddBool("AlwaysMigrate", p.AlwaysMigrate)
+		if p.DisruptionGroup != "" {
+			enc.AddString("DisruptionGroup", p.DisruptionGroup)
+		}
 		enc.AddBool("Migrating", p.Migrating)
+		if p.NetworkBandwidth != 0 {
+			enc.AddUint64("NetworkBandwidth", p.NetworkBandwidth)
+		}
+		if p.WarmPool {
+			enc.AddBool("WarmPool", p.WarmPool)
+		}
+		if p.MigrationCost != 0 {
+			enc.AddUint64("MigrationCost", p.MigrationCost)
+		}
+	}
+	if p.Priority != 0 {
+		enc.AddInt32("Priority", p.Priority)
+	}
+	if p.ExtendedResources != (ExtendedResources{}) {
+		enc.AddString("ExtendedResources", p.ExtendedResources.String())
 	}
 	if err := enc.AddReflected("CPU", p.CPU); err != nil {
 		return err
@@ -87,6 +131,19 @@ type PodResources[T constraints.Unsigned] struct {
 	// Reserved -- in effect, it's been given back resources that it previously set aside.
 	Requested T
 
+	// Preapproved is the amount of T, above Reserved, that has been "softly" set aside for this
+	// Pod, so that increases in Requested up to this amount can be approved without needing any
+	// additional capacity on the node.
+	//
+	// The node counts max(Reserved, Preapproved) for the Pod. Preapproved is zero for pods that
+	// are not VMs, or VMs whose autoscaler-agent hasn't asked for preapproval.
+	Preapproved T
+
+	// PreapprovalRequested is the amount of T that the autoscaler-agent would like to have
+	// preapproved for the Pod. Unlike Requested, the scheduler makes no guarantees about
+	// eventually granting this.
+	PreapprovalRequested T
+
 	// Factor is the smallest incremental change in T that can be allocated to the pod.
 	//
 	// For pods that aren't VMs, this should be set to zero, as it has no impact.
@@ -104,6 +161,12 @@ type PodResources[T constraints.Unsigned] struct {
 	Overcommit *resource.Quantity
 }
 
+// reservedOnNode returns the amount of T that's set aside on the node for the Pod, including any
+// amount that's preapproved but not yet reserved.
+func (r PodResources[T]) reservedOnNode() T {
+	return max(r.Reserved, r.Preapproved)
+}
+
 func PodStateFromK8sObj(pod *corev1.Pod) (Pod, error) {
 	if vmRef, ok := vmv1.VirtualMachineOwnerForPod(pod); ok {
 		return podStateForVMRunner(pod, vmRef)
@@ -112,6 +175,22 @@ func PodStateFromK8sObj(pod *corev1.Pod) (Pod, error) {
 	}
 }
 
+// IsSystemPod returns whether the pod is owned by a DaemonSet, or is the mirror of a static pod.
+//
+// These pods are typically per-node infrastructure (e.g., logging agents), and so they may be
+// accounted for differently from other pods.
+func IsSystemPod(pod *corev1.Pod) bool {
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return true
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" && ref.Controller != nil && *ref.Controller {
+			return true
+		}
+	}
+	return false
+}
+
 func podStateForNormalPod(pod *corev1.Pod) Pod {
 	// this pod is *not* a VM runner pod -- we should use the standard kubernetes resources.
 
@@ -122,8 +201,28 @@ func podStateForNormalPod(pod *corev1.Pod) Pod {
 		//
 		// NB: .Cpu()/.Memory() return a pointer to a value equal to zero if the resource is not
 		// present. So we can just add it either way.
-		cpu += vmv1.MilliCPUFromResourceQuantity(*container.Resources.Requests.Cpu())
-		mem += api.BytesFromResourceQuantity(*container.Resources.Requests.Memory())
+		containerCPU := vmv1.MilliCPUFromResourceQuantity(*container.Resources.Requests.Cpu())
+		containerMem := api.BytesFromResourceQuantity(*container.Resources.Requests.Memory())
+
+		// If the pod is being resized in-place, the new requests in the spec may not have been
+		// applied by the kubelet yet. This matches how kube-scheduler handles it: while a resize is
+		// pending, use the larger of the requested and allocated resources, or only the allocated
+		// resources if the resize is infeasible (because it'll never be applied).
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != container.Name || status.AllocatedResources == nil {
+				continue
+			}
+			allocatedCPU := vmv1.MilliCPUFromResourceQuantity(*status.AllocatedResources.Cpu())
+			allocatedMem := api.BytesFromResourceQuantity(*status.AllocatedResources.Memory())
+			if pod.Status.Resize == corev1.PodResizeStatusInfeasible {
+				containerCPU, containerMem = allocatedCPU, allocatedMem
+			} else {
+				containerCPU, containerMem = max(containerCPU, allocatedCPU), max(containerMem, allocatedMem)
+			}
+		}
+
+		cpu += containerCPU
+		mem += containerMem
 	}
 
 	return Pod{
@@ -131,22 +230,32 @@ func podStateForNormalPod(pod *corev1.Pod) Pod {
 		UID:            pod.UID,
 		CreatedAt:      pod.CreationTimestamp.Time,
 
-		VirtualMachine: lo.Empty[util.NamespacedName](),
-		Migratable:     false,
-		AlwaysMigrate:  false,
-		Migrating:      false,
+		VirtualMachine:    lo.Empty[util.NamespacedName](),
+		Migratable:        false,
+		AlwaysMigrate:     false,
+		DisruptionGroup:   "",
+		Migrating:         false,
+		NetworkBandwidth:  0,
+		ExtendedResources: extendedResourcesFromContainers(pod.Spec.Containers),
+		WarmPool:          false,
+		Priority:          lo.FromPtr(pod.Spec.Priority),
+		MigrationCost:     0,
 
 		CPU: PodResources[vmv1.MilliCPU]{
-			Reserved:   cpu,
-			Requested:  cpu,
-			Factor:     0,
-			Overcommit: resource.NewMilliQuantity(1000, resource.DecimalSI), // 1000m = 1.0 = "no overcommit"
+			Reserved:             cpu,
+			Requested:            cpu,
+			Preapproved:          0,
+			PreapprovalRequested: 0,
+			Factor:               0,
+			Overcommit:           resource.NewMilliQuantity(1000, resource.DecimalSI), // 1000m = 1.0 = "no overcommit"
 		},
 		Mem: PodResources[api.Bytes]{
-			Reserved:   mem,
-			Requested:  mem,
-			Factor:     0,
-			Overcommit: resource.NewMilliQuantity(1000, resource.DecimalSI), // 1000m = 1.0 = "no overcommit"
+			Reserved:             mem,
+			Requested:            mem,
+			Preapproved:          0,
+			PreapprovalRequested: 0,
+			Factor:               0,
+			Overcommit:           resource.NewMilliQuantity(1000, resource.DecimalSI), // 1000m = 1.0 = "no overcommit"
 		},
 	}
 }
@@ -163,10 +272,13 @@ func podStateForVMRunner(pod *corev1.Pod, vmRef metav1.OwnerReference) (Pod, err
 	migrating := ownedByMigration && migrationRole == vmv1.MigrationRoleSource
 	// allow ongoing migrations to continue. Don't allow migrations of current migration
 	// targets. New migrations can be started when auto migrations are enabled, or if the
-	// testing-only "always migrate" flag is enabled.
-	migratable := migrating || (migrationRole != vmv1.MigrationRoleTarget && (autoMigrate || alwaysMigrate))
+	// testing-only "always migrate" flag is enabled -- unless the VM has opted out entirely.
+	neverMigrate := api.IsMarkedNeverMigrate(pod)
+	migratable := migrating || (migrationRole != vmv1.MigrationRoleTarget && !neverMigrate && (autoMigrate || alwaysMigrate))
 
-	autoscalable := api.HasAutoscalingEnabled(pod)
+	// If autoscaling is frozen, we should account for the VM at its current size, just like if
+	// autoscaling is disabled. That way, manual changes to the VM are reflected here.
+	autoscalable := api.HasAutoscalingEnabled(pod) && !vmv1.ScalingFrozenForPod(pod)
 
 	res, err := vmv1.VirtualMachineResourcesFromPod(pod)
 	if err != nil {
@@ -175,7 +287,7 @@ func podStateForVMRunner(pod *corev1.Pod, vmRef metav1.OwnerReference) (Pod, err
 
 	actualResources := &api.Resources{
 		VCPU: res.CPUs.Use,
-		Mem:  api.BytesFromResourceQuantity(res.MemorySlotSize) * api.Bytes(res.MemorySlots.Use),
+		Mem:  cuarith.BytesFromSlots(uint64(res.MemorySlots.Use), api.BytesFromResourceQuantity(res.MemorySlotSize)),
 	}
 
 	overcommit, err := vmv1.VirtualMachineOvercommitFromPod(pod)
@@ -183,7 +295,20 @@ func podStateForVMRunner(pod *corev1.Pod, vmRef metav1.OwnerReference) (Pod, err
 		return lo.Empty[Pod](), err
 	}
 
+	networkBandwidth, err := api.ExtractNetworkBandwidth(pod)
+	if err != nil {
+		return lo.Empty[Pod](), err
+	}
+
+	migrationCost, err := api.ExtractMigrationCost(pod)
+	if err != nil {
+		return lo.Empty[Pod](), err
+	}
+
 	var scalingUnit, requested, approved *api.Resources
+	// preapprovalRequested and preapproved are zero unless the autoscaler-agent asked for
+	// preapproval
+	var preapprovalRequested, preapproved api.Resources
 
 	if !autoscalable {
 		approved = actualResources
@@ -212,6 +337,17 @@ func podStateForVMRunner(pod *corev1.Pod, vmRef metav1.OwnerReference) (Pod, err
 		} else if approved == nil {
 			approved = actualResources
 		}
+
+		if r, err := api.ExtractPreapprovalRequest(pod); err != nil {
+			return lo.Empty[Pod](), err
+		} else if r != nil {
+			preapprovalRequested = *r
+		}
+		if r, err := api.ExtractPreapprovedScaling(pod); err != nil {
+			return lo.Empty[Pod](), err
+		} else if r != nil {
+			preapproved = *r
+		}
 	}
 
 	if scalingUnit == nil {
@@ -227,22 +363,32 @@ func podStateForVMRunner(pod *corev1.Pod, vmRef metav1.OwnerReference) (Pod, err
 		UID:            pod.UID,
 		CreatedAt:      pod.CreationTimestamp.Time,
 
-		VirtualMachine: vm,
-		Migratable:     migratable,
-		AlwaysMigrate:  alwaysMigrate,
-		Migrating:      migrating,
+		VirtualMachine:    vm,
+		Migratable:        migratable,
+		AlwaysMigrate:     alwaysMigrate,
+		DisruptionGroup:   api.DisruptionGroup(pod),
+		Migrating:         migrating,
+		NetworkBandwidth:  networkBandwidth,
+		ExtendedResources: extendedResourcesFromContainers(pod.Spec.Containers),
+		WarmPool:          vmv1.IsWarmPoolPod(pod),
+		Priority:          lo.FromPtr(pod.Spec.Priority),
+		MigrationCost:     migrationCost,
 
 		CPU: PodResources[vmv1.MilliCPU]{
-			Reserved:   approved.VCPU,
-			Requested:  requested.VCPU,
-			Factor:     scalingUnit.VCPU,
-			Overcommit: overcommitFromOptionalQuantity(lo.FromPtr(overcommit).CPU),
+			Reserved:             approved.VCPU,
+			Requested:            requested.VCPU,
+			Preapproved:          preapproved.VCPU,
+			PreapprovalRequested: preapprovalRequested.VCPU,
+			Factor:               scalingUnit.VCPU,
+			Overcommit:           overcommitFromOptionalQuantity(lo.FromPtr(overcommit).CPU),
 		},
 		Mem: PodResources[api.Bytes]{
-			Reserved:   approved.Mem,
-			Requested:  requested.Mem,
-			Factor:     scalingUnit.Mem,
-			Overcommit: overcommitFromOptionalQuantity(lo.FromPtr(overcommit).Memory),
+			Reserved:             approved.Mem,
+			Requested:            requested.Mem,
+			Preapproved:          preapproved.Mem,
+			PreapprovalRequested: preapprovalRequested.Mem,
+			Factor:               scalingUnit.Mem,
+			Overcommit:           overcommitFromOptionalQuantity(lo.FromPtr(overcommit).Memory),
 		},
 	}, nil
 }
@@ -259,8 +405,10 @@ func overcommitFromOptionalQuantity(q *resource.Quantity) *resource.Quantity {
 
 // BetterMigrationTargetThan returns <0 iff the pod is a better migration target than the 'other'
 // pod.
+//
+// Older pods are preferred, so that we naturally avoid continuously re-migrating the same VMs --
+// live migration creates a new pod, so a pod's creation time is when its VM was last migrated (or
+// started).
 func (p Pod) BetterMigrationTargetThan(other Pod) int {
-	// For now, just prioritize migration for older pods, so that we naturally avoid continuously
-	// re-migrating the same VMs.
 	return p.CreatedAt.Compare(other.CreatedAt)
 }
@@ -651,23 +651,33 @@ func TestPodStateExtraction(t *testing.T) {
 					Name:      "pod-name",
 					Namespace: "test-namespace",
 				},
-				UID:            "pod-uid",
-				CreatedAt:      createdAt,
-				VirtualMachine: lo.FromPtr(c.extracted.vm),
-				Migratable:     lo.FromPtr(c.extracted.flags).migratable,
-				AlwaysMigrate:  lo.FromPtr(c.extracted.flags).alwaysMigrate,
-				Migrating:      lo.FromPtr(c.extracted.flags).migrating,
+				UID:               "pod-uid",
+				CreatedAt:         createdAt,
+				VirtualMachine:    lo.FromPtr(c.extracted.vm),
+				Migratable:        lo.FromPtr(c.extracted.flags).migratable,
+				AlwaysMigrate:     lo.FromPtr(c.extracted.flags).alwaysMigrate,
+				DisruptionGroup:   "",
+				Migrating:         lo.FromPtr(c.extracted.flags).migrating,
+				NetworkBandwidth:  0,
+				ExtendedResources: state.ExtendedResources{},
+				WarmPool:          false,
+				Priority:          0,
+				MigrationCost:     0,
 				CPU: state.PodResources[vmv1.MilliCPU]{
-					Reserved:   c.extracted.reserved.cpu,
-					Requested:  lo.FromPtrOr(c.extracted.requested, c.extracted.reserved).cpu,
-					Factor:     lo.FromPtr(c.extracted.factor).cpu,
-					Overcommit: c.extracted.overcommit.cpu,
+					Reserved:             c.extracted.reserved.cpu,
+					Requested:            lo.FromPtrOr(c.extracted.requested, c.extracted.reserved).cpu,
+					Preapproved:          0,
+					PreapprovalRequested: 0,
+					Factor:               lo.FromPtr(c.extracted.factor).cpu,
+					Overcommit:           c.extracted.overcommit.cpu,
 				},
 				Mem: state.PodResources[api.Bytes]{
-					Reserved:   c.extracted.reserved.mem,
-					Requested:  lo.FromPtrOr(c.extracted.requested, c.extracted.reserved).mem,
-					Factor:     lo.FromPtr(c.extracted.factor).mem,
-					Overcommit: c.extracted.overcommit.mem,
+					Reserved:             c.extracted.reserved.mem,
+					Requested:            lo.FromPtrOr(c.extracted.requested, c.extracted.reserved).mem,
+					Preapproved:          0,
+					PreapprovalRequested: 0,
+					Factor:               lo.FromPtr(c.extracted.factor).mem,
+					Overcommit:           c.extracted.overcommit.mem,
 				},
 			}
 
@@ -688,3 +698,112 @@ func TestPodStateExtraction(t *testing.T) {
 		})
 	}
 }
+
+func TestIsSystemPod(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		ownerRefs   []metav1.OwnerReference
+		expected    bool
+	}{
+		{
+			name:        "normal-pod",
+			annotations: nil,
+			ownerRefs: []metav1.OwnerReference{{
+				APIVersion:         "apps/v1",
+				Kind:               "ReplicaSet",
+				Name:               "some-replicaset",
+				UID:                "replicaset-uid",
+				Controller:         lo.ToPtr(true),
+				BlockOwnerDeletion: nil,
+			}},
+			expected: false,
+		},
+		{
+			name:        "daemonset-pod",
+			annotations: nil,
+			ownerRefs: []metav1.OwnerReference{{
+				APIVersion:         "apps/v1",
+				Kind:               "DaemonSet",
+				Name:               "logging-agent",
+				UID:                "daemonset-uid",
+				Controller:         lo.ToPtr(true),
+				BlockOwnerDeletion: nil,
+			}},
+			expected: true,
+		},
+		{
+			name:        "static-pod",
+			annotations: map[string]string{corev1.MirrorPodAnnotationKey: "abcdef"},
+			ownerRefs:   nil,
+			expected:    true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "pod-name",
+					Namespace:       "test-namespace",
+					Annotations:     c.annotations,
+					OwnerReferences: c.ownerRefs,
+				},
+			}
+			assert.Equal(t, c.expected, state.IsSystemPod(pod))
+		})
+	}
+}
+
+func TestPodStateDuringResize(t *testing.T) {
+	resources := func(cpu, mem string) corev1.ResourceList {
+		return corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(mem),
+		}
+	}
+
+	//nolint:exhaustruct // This is a test
+	obj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-name",
+			Namespace: "test-namespace",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:      "container",
+				Resources: corev1.ResourceRequirements{Requests: resources("2", "1Gi")},
+			}},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:               "container",
+				AllocatedResources: resources("1", "2Gi"),
+			}},
+		},
+	}
+
+	cases := []struct {
+		status      corev1.PodResizeStatus
+		expectedCPU vmv1.MilliCPU
+		expectedMem api.Bytes
+	}{
+		// resize infeasible: use only the allocated resources
+		{status: corev1.PodResizeStatusInfeasible, expectedCPU: 1000, expectedMem: 2 << 30},
+		// otherwise: use the larger of spec & allocated
+		{status: "", expectedCPU: 2000, expectedMem: 2 << 30},
+		{status: corev1.PodResizeStatusProposed, expectedCPU: 2000, expectedMem: 2 << 30},
+		{status: corev1.PodResizeStatusInProgress, expectedCPU: 2000, expectedMem: 2 << 30},
+		{status: corev1.PodResizeStatusDeferred, expectedCPU: 2000, expectedMem: 2 << 30},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("status=%q", c.status), func(t *testing.T) {
+			obj.Status.Resize = c.status
+			pod, err := state.PodStateFromK8sObj(obj)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expectedCPU, pod.CPU.Reserved)
+			assert.Equal(t, c.expectedMem, pod.Mem.Reserved)
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package plugin
+
+// Handing off state between instances of the scheduler, as configured by (Config).StateHandoff.
+//
+// Most of the plugin's state is rebuilt on startup from the Pods and Nodes, but the resources we
+// approve for each VM are only recorded on the VirtualMachine object some time later: VM patches
+// are asynchronous, and may be retried. If the scheduler is restarted in between (e.g. during a
+// rollout), the new instance only sees the older approvals, and may approve the same capacity to
+// another VM.
+//
+// To close that gap, the plugin periodically -- and once more on shutdown -- writes a snapshot of
+// the resources reserved for each VM to a ConfigMap. The next instance reads the snapshot before
+// handling any events, and counts each VM as having at least the resources reserved in the snapshot
+// until the VM has been patched after startup -- which happens for any VM whose reservation was
+// raised, even if nothing else changed.
+//
+// Each node's watermark hysteresis and defragmentation hold are restored as well. Drains and
+// cordons are already recorded on the Node objects, so they aren't included.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// stateHandoffConfigMapKey is the key in the ConfigMap's data that the snapshot is stored under.
+const stateHandoffConfigMapKey = "state.json"
+
+// stateSnapshot is the state handed off from one instance of the scheduler to the next.
+type stateSnapshot struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Pods stores the resources reserved for each VM pod, by the pod's UID.
+	Pods map[types.UID]snapshotPod `json:"pods"`
+	// Nodes stores the state of each node that can't be rebuilt from its Node object, by name. Nodes
+	// with nothing to restore are omitted.
+	Nodes map[string]snapshotNode `json:"nodes"`
+}
+
+type snapshotPod struct {
+	Reserved    api.Resources `json:"reserved"`
+	Preapproved api.Resources `json:"preapproved"`
+}
+
+type snapshotNode struct {
+	OverWatermark      bool      `json:"overWatermark"`
+	DefragmentingUntil time.Time `json:"defragmentingUntil"`
+}
+
+// runStateHandoff periodically writes a snapshot of the state to the configured ConfigMap until the
+// context is canceled, and then writes a final snapshot before returning.
+func (s *PluginState) runStateHandoff(
+	ctx context.Context,
+	logger *zap.Logger,
+	config StateHandoffConfig,
+	client corev1client.ConfigMapsGetter,
+) {
+	ticker := time.NewTicker(time.Second * time.Duration(config.IntervalSeconds))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Use a fresh context for the final snapshot, because ours was just canceled. The write
+			// is still bounded by the config's K8sCRUDTimeoutSeconds.
+			logger.Info("Writing final state snapshot before shutdown")
+			s.writeStateSnapshot(context.Background(), logger, config, client)
+			return
+		case <-ticker.C:
+		}
+
+		s.writeStateSnapshot(ctx, logger, config, client)
+	}
+}
+
+// writeStateSnapshot writes the current state to the ConfigMap, if startup is done.
+func (s *PluginState) writeStateSnapshot(
+	ctx context.Context,
+	logger *zap.Logger,
+	config StateHandoffConfig,
+	client corev1client.ConfigMapsGetter,
+) {
+	if !s.leading.Load() {
+		return // standby replicas' state isn't authoritative
+	}
+
+	snapshot, ok := s.stateSnapshot(time.Now())
+	if !ok {
+		logger.Info("Skipping state snapshot because startup is not done yet")
+		return
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		panic(fmt.Errorf("could not marshal state snapshot JSON: %w", err))
+	}
+
+	err = s.writeConfigMapData(ctx, config.Namespace, config.Name, client, stateHandoffConfigMapKey, data)
+	if err != nil {
+		logger.Error("Failed to write state snapshot", zap.Error(err))
+		s.metrics.StateHandoffs.WithLabelValues("write", fmt.Sprintf("error: %s", util.RootError(err))).Inc()
+	} else {
+		s.metrics.StateHandoffs.WithLabelValues("write", "success").Inc()
+	}
+}
+
+// stateSnapshot returns the state to hand off to the next instance, or false if the plugin hasn't
+// finished startup (and so the state may be incomplete).
+func (s *PluginState) stateSnapshot(now time.Time) (_ stateSnapshot, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.startupDone {
+		return lo.Empty[stateSnapshot](), false
+	}
+
+	snapshot := stateSnapshot{
+		Timestamp: now,
+		Pods:      make(map[types.UID]snapshotPod),
+		Nodes:     make(map[string]snapshotNode),
+	}
+	for name, ns := range s.nodes {
+		if ns.overWatermark || ns.defragmenting(now) {
+			snapshot.Nodes[name] = snapshotNode{
+				OverWatermark:      ns.overWatermark,
+				DefragmentingUntil: ns.defragmentingUntil,
+			}
+		}
+
+		for uid, pod := range ns.node.Pods() {
+			if lo.IsEmpty(pod.VirtualMachine) {
+				continue
+			}
+			snapshot.Pods[uid] = snapshotPod{
+				Reserved:    api.Resources{VCPU: pod.CPU.Reserved, Mem: pod.Mem.Reserved},
+				Preapproved: api.Resources{VCPU: pod.CPU.Preapproved, Mem: pod.Mem.Preapproved},
+			}
+		}
+	}
+	return snapshot, true
+}
+
+// restoreStateSnapshot reads the snapshot left by the previous instance, and stores it to be
+// applied as pods and nodes are added -- unless there isn't one, or it's too old.
+//
+// This must be called before any events are handled, or -- if the config's LeaderElection is
+// enabled -- on becoming the leader. Failing to restore the snapshot isn't fatal: we just continue
+// the same way we would without it.
+func (s *PluginState) restoreStateSnapshot(
+	ctx context.Context,
+	logger *zap.Logger,
+	config StateHandoffConfig,
+	client corev1client.ConfigMapsGetter,
+	now time.Time,
+) {
+	outcome, err := s.tryRestoreStateSnapshot(ctx, logger, config, client, now)
+	if err != nil {
+		logger.Error("Failed to restore state snapshot", zap.Error(err))
+		outcome = fmt.Sprintf("error: %s", util.RootError(err))
+	}
+	s.metrics.StateHandoffs.WithLabelValues("restore", outcome).Inc()
+}
+
+func (s *PluginState) tryRestoreStateSnapshot(
+	ctx context.Context,
+	logger *zap.Logger,
+	config StateHandoffConfig,
+	client corev1client.ConfigMapsGetter,
+	now time.Time,
+) (outcome string, _ error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(s.config().K8sCRUDTimeoutSeconds))
+	defer cancel()
+
+	start := time.Now()
+	cm, err := client.ConfigMaps(config.Namespace).Get(ctx, config.Name, metav1.GetOptions{})
+	s.apiHealth.Observe(time.Since(start), err)
+	s.metrics.RecordK8sOp("Get", "ConfigMap", config.Name, err)
+
+	if err != nil && apierrors.IsNotFound(err) {
+		logger.Info("No state snapshot to restore")
+		return "missing", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	data, ok := cm.Data[stateHandoffConfigMapKey]
+	if !ok {
+		logger.Info("No state snapshot to restore")
+		return "missing", nil
+	}
+
+	var snapshot stateSnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		return "", fmt.Errorf("Error decoding snapshot JSON: %w", err)
+	}
+
+	age := now.Sub(snapshot.Timestamp)
+	if age > time.Second*time.Duration(config.MaxAgeSeconds) {
+		logger.Warn("Ignoring state snapshot because it's too old", zap.Duration("age", age))
+		return "stale", nil
+	}
+
+	logger.Info(
+		"Restoring state snapshot",
+		zap.Duration("age", age),
+		zap.Int("pods", len(snapshot.Pods)),
+		zap.Int("nodes", len(snapshot.Nodes)),
+	)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handoff = &snapshot
+	// If we're restoring after startup (i.e., on becoming the leader), nodes and pods have already
+	// been added.
+	if s.startupDone {
+		for name, ns := range s.nodes {
+			s.applyHandoffToNode(name, ns)
+		}
+		s.finishHandoff()
+	}
+	return "restored", nil
+}
+
+// applyHandoffToPod raises the resources reserved for the pod to at least what was reserved in the
+// snapshot from the previous instance, returning whether there was anything to raise.
+//
+// The snapshot is used for each pod until its VM is patched after startup (see forgetHandoffPod),
+// so that the reservation isn't lost from events that arrive before then.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) applyHandoffToPod(logger *zap.Logger, podObj *corev1.Pod, pod *state.Pod) (raised bool) {
+	if s.handoff == nil {
+		return false
+	}
+	restored, ok := s.handoff.Pods[pod.UID]
+	if !ok {
+		return false
+	}
+
+	// We only set the approved resources for pods that have autoscaling enabled -- for the rest,
+	// their reserved resources are just what they're using.
+	if !api.HasAutoscalingEnabled(podObj) {
+		s.forgetHandoffPod(pod.UID)
+		return false
+	}
+
+	before := *pod
+	pod.CPU.Reserved = max(pod.CPU.Reserved, restored.Reserved.VCPU)
+	pod.Mem.Reserved = max(pod.Mem.Reserved, restored.Reserved.Mem)
+	pod.CPU.Preapproved = max(pod.CPU.Preapproved, restored.Preapproved.VCPU)
+	pod.Mem.Preapproved = max(pod.Mem.Preapproved, restored.Preapproved.Mem)
+
+	if *pod == before {
+		return false
+	}
+	logger.Info(
+		"Using reserved resources for Pod from previous scheduler instance",
+		zap.Object("PodFromObject", before),
+		zap.Object("Pod", *pod),
+	)
+	return true
+}
+
+// forgetHandoffPod stops using the snapshot from the previous instance for the pod, either because
+// its VM has been patched with the current reservation, or because the pod was deleted.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) forgetHandoffPod(uid types.UID) {
+	if s.handoff == nil {
+		return
+	}
+	delete(s.handoff.Pods, uid)
+	if len(s.handoff.Pods) == 0 && s.startupDone {
+		s.handoff = nil
+	}
+}
+
+// applyHandoffToNode restores the parts of the node's state from the snapshot that can't be rebuilt
+// from the Node object, if there's a snapshot from the previous instance.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) applyHandoffToNode(name string, ns *nodeState) {
+	if s.handoff == nil {
+		return
+	}
+	if restored, ok := s.handoff.Nodes[name]; ok {
+		ns.overWatermark = restored.OverWatermark
+		ns.defragmentingUntil = restored.DefragmentingUntil
+	}
+}
+
+// finishHandoff drops the parts of the snapshot from the previous instance that are no longer
+// needed once startup is done: all nodes have been added by then, as have all pods that still
+// exist.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) finishHandoff() {
+	if s.handoff == nil {
+		return
+	}
+
+	s.handoff.Nodes = nil
+	for uid := range s.handoff.Pods {
+		if !s.podExists(uid) {
+			delete(s.handoff.Pods, uid)
+		}
+	}
+	if len(s.handoff.Pods) == 0 {
+		s.handoff = nil
+	}
+}
+
+// podExists returns whether the pod is present on any node in the local state.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) podExists(uid types.UID) bool {
+	for _, ns := range s.nodes {
+		if _, ok := ns.node.GetPod(uid); ok {
+			return true
+		}
+	}
+	return false
+}
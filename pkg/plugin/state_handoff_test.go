@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestStateHandoff(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	newState := func() *PluginState {
+		pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+		return newPluginState(*config, pluginMetrics, nil)
+	}
+	now := time.Now()
+
+	// The previous instance has approved upscaling for vm-a that hasn't been written to its VM yet.
+	old := newState()
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+	node.AddPod(preemptionTestPod("vm-a", 3000, true))
+	node.AddPod(preemptionTestPod("not-a-vm", 1000, false))
+	old.nodes["node-1"] = &nodeState{ //nolint:exhaustruct // only need the node and its hysteresis
+		node:               node,
+		overWatermark:      true,
+		defragmentingUntil: now.Add(time.Minute),
+	}
+
+	_, ok := old.stateSnapshot(now)
+	assert.False(t, ok, "snapshot must not be taken before startup is done")
+	old.startupDone = true
+	snapshot, ok := old.stateSnapshot(now)
+	assert.True(t, ok)
+	assert.Equal(t, map[types.UID]snapshotPod{
+		"vm-a": {Reserved: api.Resources{VCPU: 3000, Mem: 0}, Preapproved: api.Resources{VCPU: 0, Mem: 0}},
+	}, snapshot.Pods)
+	assert.Contains(t, snapshot.Nodes, "node-1")
+
+	// Round-trip through JSON, as it would be through the ConfigMap
+	data, err := json.Marshal(snapshot)
+	assert.NoError(t, err)
+	var restored stateSnapshot
+	assert.NoError(t, json.Unmarshal(data, &restored))
+
+	s := newState()
+	s.handoff = &restored
+
+	ns := &nodeState{} //nolint:exhaustruct // only need the restored fields
+	s.applyHandoffToNode("node-1", ns)
+	assert.True(t, ns.overWatermark)
+	assert.True(t, ns.defragmenting(now))
+
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:    "vm-a",
+			Labels: map[string]string{api.LabelEnableAutoscaling: "true"},
+		},
+	}
+
+	// The VM only records 1 CPU approved, so the pod's reservation is raised to what the previous
+	// instance reserved.
+	pod := preemptionTestPod("vm-a", 1000, true)
+	assert.True(t, s.applyHandoffToPod(zap.NewNop(), podObj, &pod))
+	assert.Equal(t, vmv1.MilliCPU(3000), pod.CPU.Reserved)
+
+	// ... but never lowered.
+	pod = preemptionTestPod("vm-a", 4000, true)
+	assert.False(t, s.applyHandoffToPod(zap.NewNop(), podObj, &pod))
+	assert.Equal(t, vmv1.MilliCPU(4000), pod.CPU.Reserved)
+
+	// Once the VM's been patched, the snapshot isn't used any more.
+	s.startupDone = true
+	s.forgetHandoffPod("vm-a")
+	assert.Nil(t, s.handoff)
+	pod = preemptionTestPod("vm-a", 1000, true)
+	assert.False(t, s.applyHandoffToPod(zap.NewNop(), podObj, &pod))
+	assert.Equal(t, vmv1.MilliCPU(1000), pod.CPU.Reserved)
+}
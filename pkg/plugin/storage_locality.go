@@ -0,0 +1,63 @@
+package plugin
+
+// Accounting for how well VM pods' storage locality preferences are satisfied.
+//
+// neonvm-controller adds a preferred node affinity to runner pods for VMs that ask to be placed
+// close to (or away from) their storage, and records the storage's topology on the pod in the
+// api.InternalAnnotationStorageTopology annotation. Because the preference is only one part of
+// scoring, pods may still end up elsewhere -- so once each pod is bound, we check whether its node
+// satisfies the preference, and count the outcome.
+
+import (
+	"slices"
+
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+const (
+	storageLocalitySatisfied   = "satisfied"
+	storageLocalityUnsatisfied = "unsatisfied"
+	// storageLocalityUnknown is used when the node doesn't have the topology label at all.
+	storageLocalityUnknown = "unknown"
+)
+
+// recordStorageLocalityPlacement updates the StorageLocalityPlacements metric for a VM pod that's
+// just been bound to a node, if it has a storage locality preference.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) recordStorageLocalityPlacement(logger *zap.Logger, pod *corev1.Pod) {
+	topology, err := api.ExtractStorageTopology(pod)
+	if err != nil {
+		logger.Warn("Failed to get storage topology for Pod", zap.Error(err))
+		return
+	} else if topology == nil {
+		return
+	}
+
+	ns, ok := s.nodes[pod.Spec.NodeName]
+	if !ok {
+		return
+	}
+
+	outcome := storageLocalityPlacementOutcome(topology, ns.labels)
+	s.metrics.StorageLocalityPlacements.WithLabelValues(string(topology.Policy), outcome).Inc()
+}
+
+// storageLocalityPlacementOutcome returns whether a node with the labels satisfies the storage
+// topology's policy.
+func storageLocalityPlacementOutcome(topology *api.StorageTopology, nodeLabels map[string]string) string {
+	value, ok := nodeLabels[topology.Key]
+	if !ok {
+		return storageLocalityUnknown
+	}
+
+	colocated := slices.Contains(topology.Values, value)
+	if colocated == (topology.Policy == api.StorageLocalityColocate) {
+		return storageLocalitySatisfied
+	}
+	return storageLocalityUnsatisfied
+}
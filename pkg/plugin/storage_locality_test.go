@@ -0,0 +1,34 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+func TestStorageLocalityPlacementOutcome(t *testing.T) {
+	const key = "topology.kubernetes.io/zone"
+	values := []string{"zone-a", "zone-b"}
+
+	cases := []struct {
+		name     string
+		policy   api.StorageLocalityPolicy
+		labels   map[string]string
+		expected string
+	}{
+		{"colocate-same", api.StorageLocalityColocate, map[string]string{key: "zone-b"}, storageLocalitySatisfied},
+		{"colocate-other", api.StorageLocalityColocate, map[string]string{key: "zone-c"}, storageLocalityUnsatisfied},
+		{"anti-colocate-same", api.StorageLocalityAntiColocate, map[string]string{key: "zone-a"}, storageLocalityUnsatisfied},
+		{"anti-colocate-other", api.StorageLocalityAntiColocate, map[string]string{key: "zone-c"}, storageLocalitySatisfied},
+		{"missing-label", api.StorageLocalityColocate, map[string]string{}, storageLocalityUnknown},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			topology := &api.StorageTopology{Policy: c.policy, Key: key, Values: values}
+			assert.Equal(t, c.expected, storageLocalityPlacementOutcome(topology, c.labels))
+		})
+	}
+}
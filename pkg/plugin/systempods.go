@@ -0,0 +1,153 @@
+package plugin
+
+// Accounting for DaemonSet and static pods, as configured by (Config).SystemPodAccounting.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// applySystemPodAccounting updates the resources of the pod to match the configured accounting
+// policy, if it's a system pod (see state.IsSystemPod).
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) applySystemPodAccounting(config *Config, obj *corev1.Pod, pod *state.Pod) {
+	if !lo.IsEmpty(pod.VirtualMachine) || !state.IsSystemPod(obj) {
+		return
+	}
+
+	switch config.systemPodAccountingMode() {
+	case SystemPodAccountingRequests:
+		// nothing to do; requests are already used by default.
+	case SystemPodAccountingFixedReserve:
+		// the pod is accounted for by the reserve subtracted from the node's resources.
+		setPodResources(pod, 0, 0)
+	case SystemPodAccountingExclude:
+		// the pod is already accounted for by the node's allocatable resources.
+		setPodResources(pod, 0, 0)
+	case SystemPodAccountingCapped:
+		limit := config.SystemPodAccounting.Cap
+		setPodResources(pod, min(pod.CPU.Requested, limit.VCPU), min(pod.Mem.Requested, limit.Mem))
+	case SystemPodAccountingMeasured:
+		name := util.GetNamespacedName(obj)
+		s.systemPods[name] = obj.UID
+		if usage, ok := s.systemPodUsage[name]; ok {
+			setPodResources(pod, usage.VCPU, usage.Mem)
+		}
+	}
+}
+
+func setPodResources(pod *state.Pod, cpu vmv1.MilliCPU, mem api.Bytes) {
+	pod.CPU.Reserved = cpu
+	pod.CPU.Requested = cpu
+	pod.Mem.Reserved = mem
+	pod.Mem.Requested = mem
+}
+
+// runSystemPodUsageWatcher periodically fetches the usage of all pods from metrics-server, and
+// updates any system pods with changed usage, until the context is canceled.
+func (s *PluginState) runSystemPodUsageWatcher(
+	ctx context.Context,
+	logger *zap.Logger,
+	client rest.Interface,
+	interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		usage, err := fetchPodUsage(ctx, client)
+		if err != nil {
+			logger.Error("Failed to fetch pod usage from metrics-server", zap.Error(err))
+			continue
+		}
+
+		s.updateSystemPodUsage(logger, usage)
+	}
+}
+
+// updateSystemPodUsage stores the usage for all known system pods, requeuing any that changed so
+// that the new usage is reflected in the node's state.
+func (s *PluginState) updateSystemPodUsage(logger *zap.Logger, usage map[util.NamespacedName]api.Resources) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	newUsage := make(map[util.NamespacedName]api.Resources)
+	for name, uid := range s.systemPods {
+		current, ok := usage[name]
+		if !ok {
+			continue
+		}
+		newUsage[name] = current
+
+		if previous, ok := s.systemPodUsage[name]; ok && previous == current {
+			continue
+		}
+		if err := s.requeuePod(uid); err != nil {
+			logger.Warn(
+				"Could not requeue system Pod after usage changed",
+				zap.Object("Pod", name),
+				zap.Error(err),
+			)
+		}
+	}
+
+	s.systemPodUsage = newUsage
+}
+
+// podMetricsList is the subset of the metrics.k8s.io PodMetricsList that we use.
+type podMetricsList struct {
+	Items []struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+		} `json:"metadata"`
+		Containers []struct {
+			Usage corev1.ResourceList `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// fetchPodUsage fetches the current usage of all pods from metrics-server.
+func fetchPodUsage(ctx context.Context, client rest.Interface) (map[util.NamespacedName]api.Resources, error) {
+	body, err := client.Get().AbsPath("/apis/metrics.k8s.io/v1beta1/pods").DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error doing request: %w", err)
+	}
+
+	var list podMetricsList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("Bad JSON response: %w", err)
+	}
+
+	usage := make(map[util.NamespacedName]api.Resources)
+	for _, item := range list.Items {
+		var total api.Resources
+		for _, c := range item.Containers {
+			total.VCPU += vmv1.MilliCPUFromResourceQuantity(*c.Usage.Cpu())
+			total.Mem += api.BytesFromResourceQuantity(*c.Usage.Memory())
+		}
+		usage[util.NamespacedName{Namespace: item.Metadata.Namespace, Name: item.Metadata.Name}] = total
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestSystemPodAccounting(t *testing.T) {
+	staticPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "kube-proxy",
+			Namespace:   "kube-system",
+			UID:         "kube-proxy",
+			Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "abcdef"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "kube-proxy",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("500m"),
+						corev1.ResourceMemory: resource.MustParse("256Mi"),
+					},
+				},
+			}},
+		},
+	}
+
+	accountedFor := func(accounting *SystemPodAccountingConfig) state.Pod {
+		config := defaultBenchmarkConfig()
+		config.SystemPodAccounting = accounting
+		_, err := config.validate()
+		assert.NoError(t, err)
+
+		pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+		s := newPluginState(*config, pluginMetrics, nil)
+		pod, err := config.podStateFromK8sObj(staticPod)
+		assert.NoError(t, err)
+		s.applySystemPodAccounting(s.config(), staticPod, &pod)
+		return pod
+	}
+
+	pod := accountedFor(nil)
+	assert.Equal(t, vmv1.MilliCPU(500), pod.CPU.Reserved)
+	assert.Equal(t, api.Bytes(256*1024*1024), pod.Mem.Reserved)
+
+	pod = accountedFor(&SystemPodAccountingConfig{
+		Mode:                   SystemPodAccountingExclude,
+		FixedReserve:           nil,
+		Cap:                    nil,
+		MeasureIntervalSeconds: 0,
+	})
+	assert.Equal(t, vmv1.MilliCPU(0), pod.CPU.Reserved)
+	assert.Equal(t, api.Bytes(0), pod.Mem.Reserved)
+
+	// Only the CPU is over the cap
+	pod = accountedFor(&SystemPodAccountingConfig{
+		Mode:                   SystemPodAccountingCapped,
+		FixedReserve:           nil,
+		Cap:                    &api.Resources{VCPU: 250, Mem: 1024 * 1024 * 1024},
+		MeasureIntervalSeconds: 0,
+	})
+	assert.Equal(t, vmv1.MilliCPU(250), pod.CPU.Reserved)
+	assert.Equal(t, api.Bytes(256*1024*1024), pod.Mem.Reserved)
+}
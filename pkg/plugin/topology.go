@@ -0,0 +1,246 @@
+package plugin
+
+// Evaluation of topology spread constraints and required inter-pod (anti-)affinity against the
+// plugin's local state, as configured by (Config).HonorPodTopology -- plus spreading each tenant's VMs
+// across zones when scoring, as configured by (ScoringConfig).TopologySpread.
+//
+// We use our local state instead of the scheduler's snapshot so that pods we've reserved but that
+// haven't been bound yet are counted, and so that placement remains consistent with the view we
+// use for the watermark and migrations.
+//
+// Compared to the upstream scheduler plugins, the semantics are simplified:
+//
+//   - Only nodes with the topology key are considered as domains (nodeAffinityPolicy and
+//     nodeTaintsPolicy are treated as "Ignore"), and matchLabelKeys is not supported.
+//   - For affinity terms, a non-empty namespaceSelector is ignored; only the explicitly listed
+//     namespaces are used.
+//   - The required anti-affinity of pods already on the node is not checked.
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/samber/lo"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// topologyCheck returns a non-empty reason if placing the pod on the node would violate one of its
+// "DoNotSchedule" topology spread constraints or required pod affinity / anti-affinity terms.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) topologyCheck(pod *corev1.Pod, nodeName string) (reason string, _ error) {
+	for _, c := range pod.Spec.TopologySpreadConstraints {
+		if c.WhenUnsatisfiable != corev1.DoNotSchedule {
+			continue
+		}
+
+		skew, hasDomain, err := s.topologySpreadSkew(pod, nodeName, c)
+		if err != nil {
+			return "", err
+		} else if !hasDomain {
+			return fmt.Sprintf("Node is missing label for topology key %q", c.TopologyKey), nil
+		} else if skew > int(c.MaxSkew) {
+			return fmt.Sprintf("Placement would exceed maxSkew %d for topology key %q", c.MaxSkew, c.TopologyKey), nil
+		}
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil {
+		return "", nil
+	}
+
+	if affinity.PodAffinity != nil {
+		for _, term := range affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			inDomain, total, err := s.podsMatchingTerm(pod, nodeName, term)
+			if err != nil {
+				return "", err
+			}
+
+			// Same as upstream: if there are no matching pods anywhere, allow the pod if it matches
+			// its own term, so that the first of a group of pods with affinity for each other can be
+			// scheduled.
+			if inDomain == 0 && !(total == 0 && podMatchesOwnTerm(pod, term)) {
+				return fmt.Sprintf("No pods matching required affinity for topology key %q", term.TopologyKey), nil
+			}
+		}
+	}
+
+	if affinity.PodAntiAffinity != nil {
+		for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			inDomain, _, err := s.podsMatchingTerm(pod, nodeName, term)
+			if err != nil {
+				return "", err
+			}
+
+			if inDomain != 0 {
+				return fmt.Sprintf("Pods matching required anti-affinity for topology key %q", term.TopologyKey), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// softTopologySpreadExcess returns the total amount by which placing the pod on the node would
+// exceed maxSkew for each of the pod's "ScheduleAnyway" topology spread constraints.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) softTopologySpreadExcess(pod *corev1.Pod, nodeName string) (int, error) {
+	excess := 0
+	for _, c := range pod.Spec.TopologySpreadConstraints {
+		if c.WhenUnsatisfiable != corev1.ScheduleAnyway {
+			continue
+		}
+
+		skew, hasDomain, err := s.topologySpreadSkew(pod, nodeName, c)
+		if err != nil {
+			return 0, err
+		} else if hasDomain {
+			excess += max(0, skew-int(c.MaxSkew))
+		}
+	}
+	return excess, nil
+}
+
+// tenantSpreadPenalty returns how much placing the pod on the node would concentrate its tenant's
+// VMs in the node's topology domains, as configured by the scoring config's TopologySpread.
+//
+// For each label, this is the number of the tenant's VMs in the node's domain beyond the number in
+// the domain with the fewest, multiplied by the label's weight. Zero means no penalty.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) tenantSpreadPenalty(config *Config, pod *corev1.Pod, nodeName string) float64 {
+	cfg := config.Scoring.TopologySpread
+	if cfg == nil {
+		return 0
+	}
+	tenant, ok := pod.Labels[cfg.TenantLabel]
+	if !ok {
+		return 0
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{cfg.TenantLabel: tenant})
+	anyNamespace := func(string) bool { return true }
+
+	penalty := 0.0
+	for key, weight := range cfg.Weights {
+		domain, ok := s.nodes[nodeName].labels[key]
+		if !ok {
+			continue
+		}
+
+		counts := s.domainCounts(key, anyNamespace, selector, pod.UID)
+		penalty += weight * float64(counts[domain]-lo.Min(lo.Values(counts)))
+	}
+	return penalty
+}
+
+// topologySpreadSkew returns the skew of the constraint if the pod were placed on the node, or
+// false if the node doesn't have the constraint's topology key.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) topologySpreadSkew(
+	pod *corev1.Pod,
+	nodeName string,
+	c corev1.TopologySpreadConstraint,
+) (skew int, hasDomain bool, _ error) {
+	selector, err := metav1.LabelSelectorAsSelector(c.LabelSelector)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid labelSelector for topology key %q: %w", c.TopologyKey, err)
+	}
+
+	domain, ok := s.nodes[nodeName].labels[c.TopologyKey]
+	if !ok {
+		return 0, false, nil
+	}
+
+	sameNamespace := func(namespace string) bool { return namespace == pod.Namespace }
+	counts := s.domainCounts(c.TopologyKey, sameNamespace, selector, pod.UID)
+
+	minCount := 0
+	if len(counts) >= int(lo.FromPtr(c.MinDomains)) {
+		minCount = lo.Min(lo.Values(counts))
+	}
+
+	return counts[domain] + 1 - minCount, true, nil
+}
+
+// podsMatchingTerm returns the number of pods matching the affinity term in the same domain as the
+// node, and the total number across all domains.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) podsMatchingTerm(
+	pod *corev1.Pod,
+	nodeName string,
+	term corev1.PodAffinityTerm,
+) (inDomain int, total int, _ error) {
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid labelSelector for topology key %q: %w", term.TopologyKey, err)
+	}
+
+	counts := s.domainCounts(term.TopologyKey, termNamespaceMatcher(pod, term), selector, pod.UID)
+	for _, count := range counts {
+		total += count
+	}
+
+	if domain, ok := s.nodes[nodeName].labels[term.TopologyKey]; ok {
+		inDomain = counts[domain]
+	}
+	return inDomain, total, nil
+}
+
+// domainCounts returns the number of pods matching the namespace and selector in each value of the
+// topology key across all nodes, excluding the pod with UID 'exclude'.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) domainCounts(
+	topologyKey string,
+	namespaceMatches func(string) bool,
+	selector labels.Selector,
+	exclude types.UID,
+) map[string]int {
+	counts := make(map[string]int)
+	for _, ns := range s.nodes {
+		domain, ok := ns.labels[topologyKey]
+		if !ok {
+			continue
+		}
+
+		count := counts[domain] // make sure the domain is present, even if there's no matches
+		for uid, pod := range ns.node.Pods() {
+			if uid != exclude && namespaceMatches(pod.Namespace) && selector.Matches(labels.Set(s.podLabels[uid])) {
+				count += 1
+			}
+		}
+		counts[domain] = count
+	}
+	return counts
+}
+
+// termNamespaceMatcher returns a function reporting whether pods in a namespace are selected by the
+// affinity term.
+func termNamespaceMatcher(pod *corev1.Pod, term corev1.PodAffinityTerm) func(string) bool {
+	sel := term.NamespaceSelector
+	if sel != nil && len(sel.MatchLabels) == 0 && len(sel.MatchExpressions) == 0 {
+		// an empty namespaceSelector selects all namespaces
+		return func(string) bool { return true }
+	} else if sel == nil && len(term.Namespaces) == 0 {
+		return func(namespace string) bool { return namespace == pod.Namespace }
+	}
+
+	return func(namespace string) bool { return slices.Contains(term.Namespaces, namespace) }
+}
+
+// podMatchesOwnTerm returns whether the pod itself would be selected by the affinity term.
+func podMatchesOwnTerm(pod *corev1.Pod, term corev1.PodAffinityTerm) bool {
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false
+	}
+	return termNamespaceMatcher(pod, term)(pod.Namespace) && selector.Matches(labels.Set(pod.Labels))
+}
@@ -0,0 +1,227 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestTopologyCheck(t *testing.T) {
+	const zoneKey = "topology.kubernetes.io/zone"
+
+	config := defaultBenchmarkConfig()
+	s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+	// zone-a has two "db" pods, zone-b has one "web" pod, and node-x isn't in any zone.
+	addNode := func(name string, labels map[string]string, pods map[string]string) {
+		node := state.NodeStateFromParams(name, 10000, 40*1024*1024*1024, config.Watermark, nil)
+		for podName, app := range pods {
+			node.AddPod(preemptionTestPod(podName, 1000, true))
+			s.podLabels[types.UID(podName)] = map[string]string{"app": app}
+		}
+		s.nodes[name] = &nodeState{node: node, labels: labels} //nolint:exhaustruct // only need the node and labels
+	}
+	addNode("node-a1", map[string]string{zoneKey: "zone-a"}, map[string]string{"db-1": "db", "db-2": "db"})
+	addNode("node-a2", map[string]string{zoneKey: "zone-a"}, nil)
+	addNode("node-b1", map[string]string{zoneKey: "zone-b"}, map[string]string{"web-1": "web"})
+	addNode("node-x", map[string]string{}, nil)
+
+	selector := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}} //nolint:exhaustruct // only need labels
+	}
+	spread := func(app string, maxSkew int32, when corev1.UnsatisfiableConstraintAction) *corev1.PodSpec {
+		return &corev1.PodSpec{ //nolint:exhaustruct // only need the constraints
+			TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+				{ //nolint:exhaustruct // optional fields are unset
+					MaxSkew:           maxSkew,
+					TopologyKey:       zoneKey,
+					WhenUnsatisfiable: when,
+					LabelSelector:     selector(app),
+				},
+			},
+		}
+	}
+	term := func(app string) corev1.PodAffinityTerm {
+		return corev1.PodAffinityTerm{ //nolint:exhaustruct // only need the selector and key
+			LabelSelector: selector(app),
+			TopologyKey:   zoneKey,
+		}
+	}
+	affinity := func(app string) *corev1.PodSpec {
+		return &corev1.PodSpec{ //nolint:exhaustruct // only need the affinity
+			Affinity: &corev1.Affinity{ //nolint:exhaustruct // only need pod affinity
+				PodAffinity: &corev1.PodAffinity{ //nolint:exhaustruct // only need required terms
+					RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term(app)},
+				},
+			},
+		}
+	}
+	antiAffinity := func(app string) *corev1.PodSpec {
+		return &corev1.PodSpec{ //nolint:exhaustruct // only need the affinity
+			Affinity: &corev1.Affinity{ //nolint:exhaustruct // only need pod anti-affinity
+				PodAntiAffinity: &corev1.PodAntiAffinity{ //nolint:exhaustruct // only need required terms
+					RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term(app)},
+				},
+			},
+		}
+	}
+
+	cases := []struct {
+		name      string
+		app       string
+		spec      *corev1.PodSpec
+		node      string
+		expectErr bool
+		// expectedReason is the reason topologyCheck should return, or "" if the pod is allowed
+		expectedReason string
+	}{
+		{
+			name:           "no constraints",
+			app:            "db",
+			spec:           &corev1.PodSpec{}, //nolint:exhaustruct // intentionally empty
+			node:           "node-x",
+			expectErr:      false,
+			expectedReason: "",
+		},
+		{
+			name:           "spread within maxSkew",
+			app:            "db",
+			spec:           spread("db", 1, corev1.DoNotSchedule),
+			node:           "node-b1",
+			expectErr:      false,
+			expectedReason: "",
+		},
+		{
+			name:           "spread exceeds maxSkew",
+			app:            "db",
+			spec:           spread("db", 1, corev1.DoNotSchedule),
+			node:           "node-a2",
+			expectErr:      false,
+			expectedReason: `Placement would exceed maxSkew 1 for topology key "topology.kubernetes.io/zone"`,
+		},
+		{
+			name:           "spread with larger maxSkew",
+			app:            "db",
+			spec:           spread("db", 3, corev1.DoNotSchedule),
+			node:           "node-a2",
+			expectErr:      false,
+			expectedReason: "",
+		},
+		{
+			name:           "spread on node without domain",
+			app:            "db",
+			spec:           spread("db", 1, corev1.DoNotSchedule),
+			node:           "node-x",
+			expectErr:      false,
+			expectedReason: `Node is missing label for topology key "topology.kubernetes.io/zone"`,
+		},
+		{
+			name:           "ScheduleAnyway is ignored",
+			app:            "db",
+			spec:           spread("db", 1, corev1.ScheduleAnyway),
+			node:           "node-a2",
+			expectErr:      false,
+			expectedReason: "",
+		},
+		{
+			name:           "affinity satisfied",
+			app:            "web",
+			spec:           affinity("db"),
+			node:           "node-a2",
+			expectErr:      false,
+			expectedReason: "",
+		},
+		{
+			name:           "affinity not satisfied",
+			app:            "web",
+			spec:           affinity("db"),
+			node:           "node-b1",
+			expectErr:      false,
+			expectedReason: `No pods matching required affinity for topology key "topology.kubernetes.io/zone"`,
+		},
+		{
+			name:           "affinity for own group with no existing pods",
+			app:            "cache",
+			spec:           affinity("cache"),
+			node:           "node-b1",
+			expectErr:      false,
+			expectedReason: "",
+		},
+		{
+			name:           "affinity with no existing pods",
+			app:            "web",
+			spec:           affinity("cache"),
+			node:           "node-b1",
+			expectErr:      false,
+			expectedReason: `No pods matching required affinity for topology key "topology.kubernetes.io/zone"`,
+		},
+		{
+			name:           "anti-affinity satisfied",
+			app:            "web",
+			spec:           antiAffinity("db"),
+			node:           "node-b1",
+			expectErr:      false,
+			expectedReason: "",
+		},
+		{
+			name:           "anti-affinity not satisfied",
+			app:            "web",
+			spec:           antiAffinity("db"),
+			node:           "node-a2",
+			expectErr:      false,
+			expectedReason: `Pods matching required anti-affinity for topology key "topology.kubernetes.io/zone"`,
+		},
+		{
+			name: "invalid selector",
+			app:  "db",
+			spec: &corev1.PodSpec{ //nolint:exhaustruct // only need the constraints
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+					{ //nolint:exhaustruct // optional fields are unset
+						MaxSkew:           1,
+						TopologyKey:       zoneKey,
+						WhenUnsatisfiable: corev1.DoNotSchedule,
+						LabelSelector: &metav1.LabelSelector{ //nolint:exhaustruct // only need expressions
+							MatchExpressions: []metav1.LabelSelectorRequirement{
+								{Key: "app", Operator: "Bogus", Values: nil},
+							},
+						},
+					},
+				},
+			},
+			node:           "node-a2",
+			expectErr:      true,
+			expectedReason: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pod := &corev1.Pod{ //nolint:exhaustruct // only need metadata and spec
+				ObjectMeta: metav1.ObjectMeta{ //nolint:exhaustruct // only need name, namespace, UID, and labels
+					Name:      "new-pod",
+					Namespace: "default",
+					UID:       types.UID("new-pod"),
+					Labels:    map[string]string{"app": c.app},
+				},
+				Spec: lo.FromPtr(c.spec),
+			}
+
+			reason, err := s.topologyCheck(pod, c.node)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package plugin
+
+// Expiring resources reserved for pods that are never bound, as configured by
+// (Config).UnboundReservationTTLSeconds.
+//
+// Normally, a pod that's Reserved is either bound (and we see it scheduled on the node), or the
+// scheduler calls Unreserve. But if something goes wrong in between -- e.g., the API server times
+// out during binding -- we may get neither, and the pod's resources would be counted against the
+// node until restart.
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// unboundReservationCheckInterval is how often we check for expired reservations. The TTL itself
+// may be reloaded, so we always check on a fixed interval.
+const unboundReservationCheckInterval = 5 * time.Second
+
+// runUnboundReservationExpiry periodically releases the reservations for pods that haven't been
+// bound within the configured TTL, until the context is canceled.
+func (s *PluginState) runUnboundReservationExpiry(ctx context.Context, logger *zap.Logger) {
+	ticker := time.NewTicker(unboundReservationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.expireUnboundReservations(logger, time.Now())
+	}
+}
+
+// expireUnboundReservations releases the reservations for pods that were Reserved more than the
+// configured TTL before now, without being bound.
+//
+// If the pod *was* bound and we just haven't seen it yet, it'll be added back when its event is
+// handled, same as any other pod.
+func (s *PluginState) expireUnboundReservations(logger *zap.Logger, now time.Time) {
+	ttlSeconds := s.config().UnboundReservationTTLSeconds
+	if ttlSeconds == 0 {
+		return
+	}
+	ttl := time.Second * time.Duration(ttlSeconds)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []types.UID
+	for uid, reservedAt := range s.tentativelyScheduledAt {
+		if now.Sub(reservedAt) > ttl {
+			expired = append(expired, uid)
+		}
+	}
+
+	for _, uid := range expired {
+		nodeName := s.tentativelyScheduled[uid]
+		reservedAt := s.tentativelyScheduledAt[uid]
+
+		delete(s.tentativelyScheduled, uid)
+		delete(s.tentativelyScheduledAt, uid)
+		delete(s.podLabels, uid)
+		delete(s.podMaxResources, uid)
+
+		podLogger := logger.With(
+			zap.String("UID", string(uid)),
+			logFieldForNodeName(nodeName),
+			zap.Time("ReservedAt", reservedAt),
+		)
+
+		ns, ok := s.nodes[nodeName]
+		if !ok {
+			podLogger.Warn("Node for expired reservation not found in local state")
+			continue
+		}
+
+		pod, ok := ns.node.GetPod(uid)
+		if !ok {
+			podLogger.Warn("Pod for expired reservation not found on Node")
+			continue
+		}
+
+		ns.node.RemovePod(uid)
+		s.metrics.UnboundReservationsExpired.Inc()
+		podLogger.Warn(
+			"Released reservation for Pod that wasn't bound in time",
+			zap.Object("Pod", pod),
+			zap.Object("Node", ns.node),
+		)
+
+		s.updateNodeMetricsAndRequeue(podLogger, ns)
+		// In case the pod was bound and we missed it, make sure it's re-added.
+		if err := s.requeuePod(uid); err != nil {
+			podLogger.Info("Could not requeue Pod for expired reservation, maybe it was deleted?", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestExpireUnboundReservations(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.UnboundReservationTTLSeconds = 60
+
+	c, err := newBenchmarkCluster(config, defaultBenchmarkClusterConfig(1, 2))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := c.enforcer.state
+	nodeName := c.nodeInfos[0].Node().Name
+	reservedBefore := s.nodes[nodeName].node.CPU.Reserved
+
+	status := c.enforcer.Reserve(ctx, nil, c.newPod, nodeName)
+	require.True(t, status.IsSuccess())
+	assert.Greater(t, s.nodes[nodeName].node.CPU.Reserved, reservedBefore)
+
+	var requeued []types.UID
+	s.requeuePod = func(uid types.UID) error {
+		requeued = append(requeued, uid)
+		return nil
+	}
+
+	// Before the TTL, nothing changes
+	reservedAt := s.tentativelyScheduledAt[c.newPod.UID]
+	s.expireUnboundReservations(zap.NewNop(), reservedAt.Add(30*time.Second))
+	assert.Contains(t, s.tentativelyScheduled, c.newPod.UID)
+	assert.Empty(t, requeued)
+
+	// After the TTL, the reservation is released
+	s.expireUnboundReservations(zap.NewNop(), reservedAt.Add(90*time.Second))
+	assert.NotContains(t, s.tentativelyScheduled, c.newPod.UID)
+	assert.NotContains(t, s.tentativelyScheduledAt, c.newPod.UID)
+	assert.Equal(t, reservedBefore, s.nodes[nodeName].node.CPU.Reserved)
+	assert.Equal(t, []types.UID{c.newPod.UID}, requeued)
+
+	// A late Unreserve is fine
+	c.enforcer.Unreserve(ctx, nil, c.newPod, nodeName)
+	assert.Equal(t, reservedBefore, s.nodes[nodeName].node.CPU.Reserved)
+}
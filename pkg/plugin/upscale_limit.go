@@ -0,0 +1,146 @@
+package plugin
+
+// Cluster-wide rate limiting of upscaling, as configured by (Config).UpscaleRateLimit.
+
+import (
+	"time"
+
+	"golang.org/x/exp/constraints"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+)
+
+// upscaleLimiter is a token bucket tracking how much new capacity can currently be granted for
+// upscaling.
+//
+// When the budget doesn't cover all of an increase, only part of it is granted -- a multiple of the
+// pod's scaling factor -- and only that part is taken from the budget. The rest is granted once the
+// budget has refilled.
+//
+// Unlike a typical token bucket, the budget is allowed to go negative, but only if a single factor
+// is larger than the burst size. Otherwise, those increases would never be granted.
+//
+// upscaleLimiter is not safe for concurrent use. It is expected to only be used while holding the
+// PluginState's lock.
+type upscaleLimiter struct {
+	config UpscaleRateLimitConfig
+
+	lastUpdate time.Time
+
+	cpuBudget float64
+	memBudget float64
+}
+
+// newUpscaleLimiter returns a new upscaleLimiter for the config, or nil if config is nil.
+//
+// A nil *upscaleLimiter permits all upscaling.
+func newUpscaleLimiter(config *UpscaleRateLimitConfig, now time.Time) *upscaleLimiter {
+	if config == nil {
+		return nil
+	}
+
+	l := &upscaleLimiter{
+		config:     *config,
+		lastUpdate: now,
+		cpuBudget:  0,
+		memBudget:  0,
+	}
+	l.cpuBudget, l.memBudget = l.maxBudget()
+	return l
+}
+
+func (l *upscaleLimiter) maxBudget() (cpu float64, mem float64) {
+	burst := float64(l.config.BurstSeconds)
+	return float64(l.config.CPUPerSecond) * burst, float64(l.config.MemoryPerSecond) * burst
+}
+
+func (l *upscaleLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.lastUpdate).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	l.lastUpdate = now
+
+	maxCPU, maxMem := l.maxBudget()
+	l.cpuBudget = min(maxCPU, l.cpuBudget+elapsed*float64(l.config.CPUPerSecond))
+	l.memBudget = min(maxMem, l.memBudget+elapsed*float64(l.config.MemoryPerSecond))
+}
+
+// take takes as much of the increase in CPU and memory from the budget as it currently allows,
+// returning the amounts granted. Each is either the full increase, or a multiple of its factor.
+//
+// If less than the full increase was granted, take also returns the duration to wait before
+// retrying for the rest.
+func (l *upscaleLimiter) take(
+	now time.Time,
+	cpu, cpuFactor vmv1.MilliCPU,
+	mem, memFactor api.Bytes,
+) (grantedCPU vmv1.MilliCPU, grantedMem api.Bytes, wait time.Duration) {
+	if l == nil || (cpu == 0 && mem == 0) {
+		return cpu, mem, 0
+	}
+
+	l.refill(now)
+
+	maxCPU, maxMem := l.maxBudget()
+	grantedCPU, cpuWait := takeFromBudget(&l.cpuBudget, maxCPU, float64(l.config.CPUPerSecond), cpu, cpuFactor)
+	grantedMem, memWait := takeFromBudget(&l.memBudget, maxMem, float64(l.config.MemoryPerSecond), mem, memFactor)
+	return grantedCPU, grantedMem, max(cpuWait, memWait)
+}
+
+// takeAll takes the full increase in CPU and memory from the budget, if it currently allows it,
+// returning whether it did. Unlike take, nothing is taken if only part of the increase is allowed.
+func (l *upscaleLimiter) takeAll(now time.Time, cpu vmv1.MilliCPU, mem api.Bytes) bool {
+	if l == nil || (cpu == 0 && mem == 0) {
+		return true
+	}
+
+	l.refill(now)
+
+	if l.cpuBudget < float64(cpu) || l.memBudget < float64(mem) {
+		return false
+	}
+	l.cpuBudget -= float64(cpu)
+	l.memBudget -= float64(mem)
+	return true
+}
+
+// takeFromBudget takes as much of the increase from the budget as it allows, returning the amount
+// granted and -- if that's less than the increase -- how long until more can be granted.
+//
+// The amount granted is either the full increase, or a multiple of the factor. If the budget is
+// full but a single factor is larger than the maximum, one factor is granted anyway.
+func takeFromBudget[T constraints.Unsigned](
+	budget *float64,
+	maxBudget float64,
+	perSecond float64,
+	increase T,
+	factor T,
+) (granted T, wait time.Duration) {
+	if increase == 0 {
+		return 0, 0
+	}
+	factor = max(factor, 1)
+	step := min(increase, factor)
+
+	switch {
+	case *budget >= float64(increase):
+		granted = increase
+	case *budget >= maxBudget && maxBudget < float64(step):
+		granted = step
+	case *budget > 0:
+		granted = T(*budget/float64(factor)) * factor
+	}
+	*budget -= float64(granted)
+
+	if granted == increase {
+		return granted, 0
+	}
+
+	// Wait until the budget covers the next step (or is full, if that's not enough)
+	needed := min(float64(min(increase-granted, factor)), maxBudget)
+	seconds := max(0, (needed-*budget)/perSecond)
+	// round up to the nearest millisecond, so we don't retry slightly too early.
+	return granted, time.Duration(seconds*1000+1) * time.Millisecond
+}
@@ -0,0 +1,215 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestUpscaleLimiter(t *testing.T) {
+	config := UpscaleRateLimitConfig{
+		CPUPerSecond:    250,
+		MemoryPerSecond: 1024,
+		BurstSeconds:    4, // budget of 1000 CPU, 4096 memory
+	}
+
+	type take struct {
+		afterSeconds float64
+		cpu          vmv1.MilliCPU
+		mem          api.Bytes
+
+		grantedCPU vmv1.MilliCPU
+		grantedMem api.Bytes
+		wait       time.Duration
+	}
+
+	cases := []struct {
+		name      string
+		cpuFactor vmv1.MilliCPU
+		memFactor api.Bytes
+		takes     []take
+	}{
+		{
+			name:      "within-budget",
+			cpuFactor: 250,
+			memFactor: 1024,
+			takes: []take{
+				{cpu: 500, mem: 2048, grantedCPU: 500, grantedMem: 2048},
+				{cpu: 500, mem: 2048, grantedCPU: 500, grantedMem: 2048},
+			},
+		},
+		{
+			name:      "partial-grant",
+			cpuFactor: 250,
+			memFactor: 1024,
+			takes: []take{
+				// Only 1000 CPU is available, so only that's granted; memory is granted in full.
+				{cpu: 1500, mem: 1024, grantedCPU: 1000, grantedMem: 1024, wait: time.Second + time.Millisecond},
+				// After a second, one more factor is available.
+				{afterSeconds: 1, cpu: 500, grantedCPU: 250, wait: time.Second + time.Millisecond},
+			},
+		},
+		{
+			name:      "nothing-available",
+			cpuFactor: 250,
+			memFactor: 1024,
+			takes: []take{
+				{cpu: 1000, grantedCPU: 1000},
+				// Less than a factor has refilled, so nothing is granted.
+				{afterSeconds: 0.5, cpu: 250, grantedCPU: 0, wait: 500*time.Millisecond + time.Millisecond},
+				{afterSeconds: 0.5, cpu: 250, grantedCPU: 250},
+			},
+		},
+		{
+			name:      "factor-larger-than-burst",
+			cpuFactor: 2000,
+			memFactor: 1024,
+			takes: []take{
+				// One factor is granted anyway once the budget is full, leaving the budget
+				// negative.
+				{cpu: 4000, grantedCPU: 2000, wait: 8*time.Second + time.Millisecond},
+				{afterSeconds: 2, cpu: 2000, grantedCPU: 0, wait: 6*time.Second + time.Millisecond},
+				{afterSeconds: 6, cpu: 2000, grantedCPU: 2000},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now := time.Now()
+			l := newUpscaleLimiter(&config, now)
+
+			for i, tk := range c.takes {
+				now = now.Add(time.Duration(tk.afterSeconds * float64(time.Second)))
+				cpu, mem, wait := l.take(now, tk.cpu, c.cpuFactor, tk.mem, c.memFactor)
+				assert.Equal(t, tk.grantedCPU, cpu, "granted CPU for take #%d", i)
+				assert.Equal(t, tk.grantedMem, mem, "granted memory for take #%d", i)
+				assert.Equal(t, tk.wait, wait, "wait for take #%d", i)
+			}
+		})
+	}
+
+	// A nil limiter grants everything
+	var l *upscaleLimiter
+	cpu, mem, wait := l.take(time.Now(), 1000, 250, 4096, 1024)
+	assert.Equal(t, vmv1.MilliCPU(1000), cpu)
+	assert.Equal(t, api.Bytes(4096), mem)
+	assert.Zero(t, wait)
+}
+
+func TestUpscaleLimiterPartialApproval(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.ReservationTTLSeconds = 0
+	config.UpscaleRateLimit = &UpscaleRateLimitConfig{
+		CPUPerSecond:    250,
+		MemoryPerSecond: 1 << 30,
+		BurstSeconds:    2, // budget of 500 CPU
+	}
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	s.startupDone = true
+
+	pod := preemptionTestPod("vm-a", 1000, true)
+	pod.CPU.Requested = 2000
+	pod.CPU.Factor = 250
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+	node.AddPod(pod)
+	ns := &nodeState{ //nolint:exhaustruct // only need the node and patch times
+		node:            node,
+		podsVMPatchedAt: make(map[types.UID]time.Time),
+	}
+	s.nodes["node-1"] = ns
+
+	obj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vm-a",
+			Namespace: "default",
+			UID:       "vm-a",
+			Labels:    map[string]string{api.LabelEnableAutoscaling: "true"},
+		},
+	}
+
+	result := s.reconcilePodResources(zap.NewNop(), s.config(), ns, obj, pod, false)
+	require.NotNil(t, result)
+	assert.True(t, result.needsMoreResources)
+	require.NotNil(t, result.retryAfter)
+	assert.Greater(t, *result.retryAfter, time.Duration(0))
+
+	// Only the increase that the limit allows is reserved
+	updated, ok := ns.node.GetPod("vm-a")
+	require.True(t, ok)
+	assert.Equal(t, vmv1.MilliCPU(1500), updated.CPU.Reserved)
+	assert.Equal(t, 1.0, testutil.ToFloat64(pluginMetrics.UpscaleRateLimited))
+}
+
+// Requests approved immediately because they're within the pod's preapproval are still subject to
+// the limit, and aren't taken from it again once they're reserved.
+func TestUpscaleLimiterPreapproval(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.ReservationTTLSeconds = 0
+	config.UpscaleRateLimit = &UpscaleRateLimitConfig{
+		CPUPerSecond:    250,
+		MemoryPerSecond: 1 << 30,
+		BurstSeconds:    2, // budget of 500 CPU
+	}
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	s.startupDone = true
+
+	pod := preemptionTestPod("vm-a", 1000, true)
+	pod.CPU.Factor = 250
+	pod.CPU.Preapproved = 2000
+	pod.CPU.PreapprovalRequested = 2000
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+	node.AddPod(pod)
+	ns := &nodeState{ //nolint:exhaustruct // only need the node and patch times
+		node:            node,
+		podsVMPatchedAt: make(map[types.UID]time.Time),
+	}
+	s.nodes["node-1"] = ns
+
+	obj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vm-a",
+			Namespace: "default",
+			UID:       "vm-a",
+			Labels:    map[string]string{api.LabelEnableAutoscaling: "true"},
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1"},
+	}
+
+	// The whole budget is used by the first request, so the next one has to wait.
+	assert.True(t, s.approveWithinPreapproval(zap.NewNop(), obj, api.Resources{VCPU: 1500, Mem: 0}))
+	assert.False(t, s.approveWithinPreapproval(zap.NewNop(), obj, api.Resources{VCPU: 2000, Mem: 0}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(pluginMetrics.UpscaleRateLimited))
+	// ... but repeating the first request doesn't need more.
+	assert.True(t, s.approveWithinPreapproval(zap.NewNop(), obj, api.Resources{VCPU: 1500, Mem: 0}))
+
+	// Once the approved resources are reserved, they aren't taken from the limit again.
+	requestedPod := pod
+	requestedPod.CPU.Requested = 1500
+	ns.node.UpdatePod(pod, requestedPod)
+	result := s.reconcilePodResources(zap.NewNop(), s.config(), ns, obj, requestedPod, false)
+	require.NotNil(t, result)
+	assert.False(t, result.needsMoreResources)
+
+	updated, ok := ns.node.GetPod("vm-a")
+	require.True(t, ok)
+	assert.Equal(t, vmv1.MilliCPU(1500), updated.CPU.Reserved)
+	assert.Equal(t, 1.0, testutil.ToFloat64(pluginMetrics.UpscaleRateLimited))
+	assert.NotContains(t, s.preapprovalCharged, types.UID("vm-a"))
+}
@@ -0,0 +1,127 @@
+package plugin
+
+// Blending of VMs' reported usage into watermark evaluation, as configured by
+// (Config).UsageBlending.
+
+import (
+	"math"
+
+	"go.uber.org/zap"
+	"golang.org/x/exp/constraints"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// recordPodUsage stores the usage reported by the autoscaler-agent for the pod, if usage blending,
+// migration deferral, or node pressure downscaling is enabled.
+//
+// With usage blending, the pod's node is also requeued if the pod's blended usage has changed
+// significantly since the last time that happened, so that the node's watermark status reflects
+// the new usage without waiting for some other change on the node.
+func (s *PluginState) recordPodUsage(logger *zap.Logger, uid types.UID, nodeName string, metrics api.Metrics) {
+	cfg := s.config()
+	if cfg.UsageBlending == nil && cfg.MigrationDeferral == nil && cfg.NodePressureDownscale == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.podUsage[uid] = metrics
+
+	if cfg.UsageBlending == nil {
+		return
+	}
+	ns, ok := s.nodes[nodeName]
+	if !ok {
+		return
+	}
+	pod, ok := ns.node.GetPod(uid)
+	if !ok {
+		return
+	}
+	if last, ok := s.podUsageAtRequeue[uid]; ok && !cfg.UsageBlending.changedSignificantly(pod, last, metrics) {
+		return
+	}
+
+	s.podUsageAtRequeue[uid] = metrics
+	if err := s.requeueNode(nodeName); err != nil {
+		logger.Error("Failed to requeue Node after Pod's usage changed", zap.Error(err))
+	}
+}
+
+// changedSignificantly returns whether the blended amount of CPU or memory for the pod differs
+// between the two usage reports by at least RequeueChangeFraction of what's reserved for it.
+func (c *UsageBlendingConfig) changedSignificantly(pod state.Pod, old, new api.Metrics) bool {
+	fraction := c.RequeueChangeFraction
+	if fraction == 0 {
+		fraction = DefaultUsageBlendingRequeueChangeFraction
+	}
+
+	significant := func(change float64, reserved float64) bool {
+		return change > 0 && change >= fraction*reserved
+	}
+
+	cpuChange := c.CPUUsageWeight * math.Abs(float64(new.LoadAverage1Min)-float64(old.LoadAverage1Min)) * 1000
+	if significant(cpuChange, float64(pod.CPU.Reserved)) {
+		return true
+	}
+
+	// If memory usage was only reported by one of them, the blended amount of memory changed from
+	// (or to) just the reserved amount.
+	oldMem, newMem := float64(pod.Mem.Reserved), float64(pod.Mem.Reserved)
+	if old.MemoryUsageBytes != nil {
+		oldMem = float64(*old.MemoryUsageBytes)
+	}
+	if new.MemoryUsageBytes != nil {
+		newMem = float64(*new.MemoryUsageBytes)
+	}
+	memChange := c.MemoryUsageWeight * math.Abs(newMem-oldMem)
+	return significant(memChange, float64(pod.Mem.Reserved))
+}
+
+// applyUsageBlending replaces the reserved resources of each pod on the node with a blend of its
+// reserved resources and its most recently reported usage, if usage blending is enabled.
+//
+// This MUST only be called on a temporary node (e.g., from (*state.Node).Speculatively()) that
+// will not be committed, because the resulting node no longer reflects what's actually reserved.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) applyUsageBlending(tmpNode *state.Node) {
+	config := s.config().UsageBlending
+	if config == nil {
+		return
+	}
+
+	// Collect the changes first, so that we're not modifying the node while iterating over it.
+	var updates [][2]state.Pod
+	for uid, pod := range tmpNode.Pods() {
+		usage, ok := s.podUsage[uid]
+		if !ok {
+			continue
+		}
+
+		newPod := pod
+		cpuUsage := vmv1.MilliCPU(math.Round(float64(usage.LoadAverage1Min) * 1000))
+		newPod.CPU.Reserved = blendUsage(pod.CPU.Reserved, cpuUsage, config.CPUUsageWeight)
+		if usage.MemoryUsageBytes != nil {
+			memUsage := api.Bytes(math.Round(float64(*usage.MemoryUsageBytes)))
+			newPod.Mem.Reserved = blendUsage(pod.Mem.Reserved, memUsage, config.MemoryUsageWeight)
+		}
+		updates = append(updates, [2]state.Pod{pod, newPod})
+	}
+
+	for _, u := range updates {
+		tmpNode.UpdatePod(u[0], u[1])
+	}
+}
+
+// blendUsage returns the weighted average of the reserved and used amounts, where weight is the
+// fraction from usage.
+func blendUsage[T constraints.Unsigned](reserved T, usage T, weight float64) T {
+	return T(math.Round((1-weight)*float64(reserved) + weight*float64(usage)))
+}
@@ -0,0 +1,156 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestBlendUsage(t *testing.T) {
+	cases := []struct {
+		name     string
+		reserved uint64
+		usage    uint64
+		weight   float64
+		expected uint64
+	}{
+		{name: "no weight", reserved: 4000, usage: 1000, weight: 0, expected: 4000},
+		{name: "full weight", reserved: 4000, usage: 1000, weight: 1, expected: 1000},
+		{name: "half weight", reserved: 4000, usage: 1000, weight: 0.5, expected: 2500},
+		{name: "usage above reserved", reserved: 1000, usage: 3000, weight: 0.25, expected: 1500},
+		{name: "rounded", reserved: 1000, usage: 0, weight: 0.3333, expected: 667},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, blendUsage(c.reserved, c.usage, c.weight))
+		})
+	}
+}
+
+func TestApplyUsageBlending(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	withMem := func(pod state.Pod, mem api.Bytes) state.Pod {
+		pod.Mem.Reserved = mem
+		pod.Mem.Requested = mem
+		return pod
+	}
+
+	cases := []struct {
+		name     string
+		blending *UsageBlendingConfig
+		usage    map[string]api.Metrics
+		// expected reserved resources of each pod after blending
+		expected map[string]api.Resources
+	}{
+		{
+			name:     "disabled",
+			blending: nil,
+			usage: map[string]api.Metrics{
+				"vm-a": {LoadAverage1Min: 0.5, LoadAverage5Min: nil, MemoryUsageBytes: lo.ToPtr[float32](1 * gib)},
+			},
+			expected: map[string]api.Resources{
+				"vm-a": {VCPU: 4000, Mem: 16 * gib},
+				"vm-b": {VCPU: 2000, Mem: 8 * gib},
+			},
+		},
+		{
+			name:     "enabled",
+			blending: &UsageBlendingConfig{CPUUsageWeight: 0.5, MemoryUsageWeight: 0.25, RequeueChangeFraction: 0},
+			usage: map[string]api.Metrics{
+				"vm-a": {LoadAverage1Min: 0.5, LoadAverage5Min: nil, MemoryUsageBytes: lo.ToPtr[float32](4 * gib)},
+				// without memory usage, only CPU is blended
+				"vm-b": {LoadAverage1Min: 1, LoadAverage5Min: nil, MemoryUsageBytes: nil},
+			},
+			expected: map[string]api.Resources{
+				"vm-a": {VCPU: 2250, Mem: 13 * gib},
+				"vm-b": {VCPU: 1500, Mem: 8 * gib},
+			},
+		},
+		{
+			name:     "no usage reported",
+			blending: &UsageBlendingConfig{CPUUsageWeight: 0.5, MemoryUsageWeight: 0.5, RequeueChangeFraction: 0},
+			usage:    map[string]api.Metrics{},
+			expected: map[string]api.Resources{
+				"vm-a": {VCPU: 4000, Mem: 16 * gib},
+				"vm-b": {VCPU: 2000, Mem: 8 * gib},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := defaultBenchmarkConfig()
+			config.UsageBlending = c.blending
+			s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+			node := state.NodeStateFromParams("node-1", 10000, 40*gib, config.Watermark, nil)
+			node.AddPod(withMem(preemptionTestPod("vm-a", 4000, true), 16*gib))
+			node.AddPod(withMem(preemptionTestPod("vm-b", 2000, true), 8*gib))
+
+			for name, usage := range c.usage {
+				s.podUsage[types.UID(name)] = usage
+			}
+
+			node.Speculatively(func(n *state.Node) (commit bool) {
+				s.applyUsageBlending(n)
+
+				for name, expected := range c.expected {
+					pod, ok := n.GetPod(types.UID(name))
+					assert.True(t, ok)
+					assert.Equal(t, expected.VCPU, pod.CPU.Reserved, "CPU of %s", name)
+					assert.Equal(t, expected.Mem, pod.Mem.Reserved, "memory of %s", name)
+				}
+				return false
+			})
+
+			// The original node is unchanged
+			assert.Equal(t, vmv1.MilliCPU(6000), node.CPU.Reserved)
+			assert.Equal(t, api.Bytes(24*gib), node.Mem.Reserved)
+		})
+	}
+}
+
+func TestRecordPodUsageRequeuesNode(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.UsageBlending = &UsageBlendingConfig{CPUUsageWeight: 0.5, MemoryUsageWeight: 0, RequeueChangeFraction: 0.1}
+	s := newPluginState(*config, metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry()), nil)
+
+	var requeued []string
+	s.requeueNode = func(nodeName string) error {
+		requeued = append(requeued, nodeName)
+		return nil
+	}
+
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+	node.AddPod(preemptionTestPod("vm-a", 4000, true))
+	s.nodes["node-1"] = &nodeState{node: node} //nolint:exhaustruct // only need the node
+
+	report := func(load float32) {
+		s.recordPodUsage(zap.NewNop(), "vm-a", "node-1", api.Metrics{LoadAverage1Min: load, LoadAverage5Min: nil, MemoryUsageBytes: nil})
+	}
+
+	// The first report always requeues the node
+	report(1)
+	assert.Equal(t, []string{"node-1"}, requeued)
+
+	// Blended CPU changes by 250m, less than 10% of the 4 CPUs reserved
+	report(1.5)
+	assert.Len(t, requeued, 1)
+
+	// ... but changes add up from the last time the node was requeued
+	report(2)
+	assert.Len(t, requeued, 2)
+	assert.Equal(t, float32(2), s.podUsage["vm-a"].LoadAverage1Min)
+}
@@ -0,0 +1,65 @@
+package plugin
+
+// Requirements for extended resources from VM annotations, as configured by
+// (Config).VMAnnotationResources.
+//
+// Some VMs need resources like hugepages or local NVMe, but only express that by annotation -- the
+// runner pod doesn't request them. To still place those VMs on nodes that have the resources, the
+// annotations are mapped to synthetic requests for the tracked ExtendedResources, which are then
+// handled the same as if the pod requested them directly.
+
+import (
+	"fmt"
+
+	"github.com/samber/lo"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// podStateFromK8sObj returns the state of the pod, including any extended resources required by
+// its VM's annotations.
+func (c Config) podStateFromK8sObj(pod *corev1.Pod) (state.Pod, error) {
+	podState, err := state.PodStateFromK8sObj(pod)
+	if err != nil {
+		return lo.Empty[state.Pod](), err
+	}
+
+	if lo.IsEmpty(podState.VirtualMachine) {
+		return podState, nil
+	}
+
+	required, err := c.vmAnnotationResources(pod.Annotations)
+	if err != nil {
+		return lo.Empty[state.Pod](), err
+	}
+	podState.ExtendedResources = podState.ExtendedResources.Plus(required)
+	return podState, nil
+}
+
+// vmAnnotationResources returns the amounts of extended resources required by a VM with the given
+// annotations.
+func (c Config) vmAnnotationResources(annotations map[string]string) (map[corev1.ResourceName]int64, error) {
+	amounts := make(map[corev1.ResourceName]int64)
+	for _, r := range c.VMAnnotationResources {
+		value, ok := annotations[r.Annotation]
+		if !ok {
+			continue
+		}
+
+		q := r.Amount
+		if q == nil {
+			parsed, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse %s annotation: %w", r.Annotation, err)
+			} else if parsed.Sign() < 0 {
+				return nil, fmt.Errorf("%s annotation must not be negative", r.Annotation)
+			}
+			q = &parsed
+		}
+		amounts[corev1.ResourceName(r.Resource)] += q.Value()
+	}
+	return amounts, nil
+}
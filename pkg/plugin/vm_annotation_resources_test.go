@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestVMAnnotationResources(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.ExtendedResources = []ExtendedResourceConfig{
+		{Name: "hugepages-2Mi", Watermark: 1.0},
+		{Name: "example.com/local-nvme", Watermark: 1.0},
+	}
+	config.VMAnnotationResources = []VMAnnotationResourceConfig{
+		{Annotation: "example.com/hugepages", Resource: "hugepages-2Mi", Amount: nil},
+		{Annotation: "example.com/local-nvme", Resource: "example.com/local-nvme", Amount: lo.ToPtr(resource.MustParse("1"))},
+	}
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	amounts, err := config.vmAnnotationResources(map[string]string{
+		"example.com/hugepages":  "4Mi",
+		"example.com/local-nvme": "true",
+		"unrelated":              "1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[corev1.ResourceName]int64{
+		"hugepages-2Mi":          4 * 1024 * 1024,
+		"example.com/local-nvme": 1,
+	}, amounts)
+
+	// Combined with what the pod requests directly
+	requested := state.NewExtendedResources(map[corev1.ResourceName]int64{"example.com/local-nvme": 1})
+	assert.Equal(t, "example.com/local-nvme=2,hugepages-2Mi=4194304", requested.Plus(amounts).String())
+
+	_, err = config.vmAnnotationResources(map[string]string{"example.com/hugepages": "lots"})
+	assert.ErrorContains(t, err, "could not parse example.com/hugepages annotation")
+
+	// The resource must be tracked, otherwise nothing would be checked
+	config.VMAnnotationResources[0].Resource = "hugepages-1Gi"
+	path, err := config.validate()
+	assert.Error(t, err)
+	assert.Equal(t, "vmAnnotationResources[0].resource", path)
+}
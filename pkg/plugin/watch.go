@@ -5,6 +5,7 @@ package plugin
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"go.uber.org/zap"
@@ -13,6 +14,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	coreclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/events"
 
 	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
 	vmclient "github.com/neondatabase/autoscaling/neonvm/client/clientset/versioned"
@@ -44,28 +46,77 @@ func watchHandlers[P reconcile.Object](
 	}
 }
 
-// helper function
-func onlyErr[T any](_ T, err error) error {
-	return err
+// watchSettings are the settings shared by all of the plugin's watches.
+type watchSettings struct {
+	metrics    watch.Metrics
+	staleAfter *time.Duration
+	// onStale is called with the name of the watch that was detected as stale. It may be nil.
+	onStale func(instance string)
 }
 
-func watchConfig[T any](metrics watch.Metrics) watch.Config {
+func makeWatchSettings(
+	logger *zap.Logger,
+	config *Config,
+	recorder events.EventRecorder,
+	metrics watch.Metrics,
+) watchSettings {
+	if config.WatchStaleTimeoutSeconds == 0 {
+		return watchSettings{metrics: metrics, staleAfter: nil, onStale: nil}
+	}
+
+	staleAfter := time.Second * time.Duration(config.WatchStaleTimeoutSeconds)
+
+	// Our watches aren't about any particular object, so we attach the Events to the scheduler's
+	// own pod -- if we know what it is.
+	podName, podNamespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if podName == "" || podNamespace == "" {
+		logger.Warn("POD_NAME or POD_NAMESPACE not set, will not emit Events for stale watches")
+		return watchSettings{metrics: metrics, staleAfter: &staleAfter, onStale: nil}
+	}
+	podRef := &corev1.ObjectReference{ //nolint:exhaustruct // only the fields needed to refer to a Pod
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       podName,
+		Namespace:  podNamespace,
+	}
+
+	return watchSettings{
+		metrics:    metrics,
+		staleAfter: &staleAfter,
+		onStale: func(instance string) {
+			recorder.Eventf(
+				podRef, nil, corev1.EventTypeWarning, "StaleWatch", "Relist",
+				"Scheduler plugin watch on %s was stale; restarting it with a fresh re-list", instance,
+			)
+		},
+	}
+}
+
+func watchConfig[T any](settings watchSettings) watch.Config {
 	sampleObj := any(new(T)).(runtime.Object)
 	gvk, err := util.LookupGVKForType(sampleObj)
 	if err != nil {
 		panic(err)
 	}
 	kind := gvk.Kind
+	instance := fmt.Sprint(kind, "s")
+
+	var onStale func()
+	if settings.onStale != nil {
+		onStale = func() { settings.onStale(instance) }
+	}
 
 	return watch.Config{
 		ObjectNameLogField: kind,
 		Metrics: watch.MetricsConfig{
-			Metrics:  metrics,
-			Instance: fmt.Sprint(kind, "s"),
+			Metrics:  settings.metrics,
+			Instance: instance,
 		},
 		// FIXME: make these configurable.
 		RetryRelistAfter: util.NewTimeRange(time.Second, 3, 5),
 		RetryWatchAfter:  util.NewTimeRange(time.Second, 3, 5),
+		StaleAfter:       settings.staleAfter,
+		OnStale:          onStale,
 	}
 }
 
@@ -73,14 +124,14 @@ func watchNodeEvents(
 	ctx context.Context,
 	parentLogger *zap.Logger,
 	client coreclient.Interface,
-	metrics watch.Metrics,
+	settings watchSettings,
 	callbacks watch.HandlerFuncs[*corev1.Node],
 ) (*watch.Store[corev1.Node], error) {
 	return watch.Watch(
 		ctx,
 		parentLogger.Named("watch-nodes"),
 		client.CoreV1().Nodes(),
-		watchConfig[corev1.Node](metrics),
+		watchConfig[corev1.Node](settings),
 		watch.Accessors[*corev1.NodeList, corev1.Node]{
 			Items: func(list *corev1.NodeList) []corev1.Node { return list.Items },
 		},
@@ -90,18 +141,39 @@ func watchNodeEvents(
 	)
 }
 
+func watchNamespaceEvents(
+	ctx context.Context,
+	parentLogger *zap.Logger,
+	client coreclient.Interface,
+	settings watchSettings,
+	callbacks watch.HandlerFuncs[*corev1.Namespace],
+) (*watch.Store[corev1.Namespace], error) {
+	return watch.Watch(
+		ctx,
+		parentLogger.Named("watch-namespaces"),
+		client.CoreV1().Namespaces(),
+		watchConfig[corev1.Namespace](settings),
+		watch.Accessors[*corev1.NamespaceList, corev1.Namespace]{
+			Items: func(list *corev1.NamespaceList) []corev1.Namespace { return list.Items },
+		},
+		watch.InitModeSync,
+		metav1.ListOptions{},
+		callbacks,
+	)
+}
+
 func watchPodEvents(
 	ctx context.Context,
 	parentLogger *zap.Logger,
 	client coreclient.Interface,
-	metrics watch.Metrics,
+	settings watchSettings,
 	callbacks watch.HandlerFuncs[*corev1.Pod],
 ) (*watch.Store[corev1.Pod], error) {
 	return watch.Watch(
 		ctx,
 		parentLogger.Named("watch-pods"),
 		client.CoreV1().Pods(corev1.NamespaceAll),
-		watchConfig[corev1.Pod](metrics),
+		watchConfig[corev1.Pod](settings),
 		watch.Accessors[*corev1.PodList, corev1.Pod]{
 			Items: func(list *corev1.PodList) []corev1.Pod { return list.Items },
 		},
@@ -115,14 +187,14 @@ func watchMigrationEvents(
 	ctx context.Context,
 	parentLogger *zap.Logger,
 	client vmclient.Interface,
-	metrics watch.Metrics,
+	settings watchSettings,
 	callbacks watch.HandlerFuncs[*vmv1.VirtualMachineMigration],
-) error {
-	return onlyErr(watch.Watch(
+) (*watch.Store[vmv1.VirtualMachineMigration], error) {
+	return watch.Watch(
 		ctx,
 		parentLogger.Named("watch-migrations"),
 		client.NeonvmV1().VirtualMachineMigrations(corev1.NamespaceAll),
-		watchConfig[vmv1.VirtualMachineMigration](metrics),
+		watchConfig[vmv1.VirtualMachineMigration](settings),
 		watch.Accessors[*vmv1.VirtualMachineMigrationList, vmv1.VirtualMachineMigration]{
 			Items: func(list *vmv1.VirtualMachineMigrationList) []vmv1.VirtualMachineMigration { return list.Items },
 		},
@@ -136,5 +208,5 @@ func watchMigrationEvents(
 			LabelSelector: LabelPluginCreatedMigration,
 		},
 		callbacks,
-	))
+	)
 }
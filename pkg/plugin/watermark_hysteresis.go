@@ -0,0 +1,49 @@
+package plugin
+
+// Hysteresis around the watermark, as configured by (Config).WatermarkHysteresis.
+//
+// Without hysteresis, a node whose usage is oscillating around the watermark will have VMs
+// migrated away every time it goes slightly above, which can cause a lot of migrations for little
+// benefit. Instead, once a node goes above the watermark, we keep migrating VMs away until it's
+// below the watermark minus the hysteresis.
+
+import (
+	"golang.org/x/exp/constraints"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+// applyWatermarkHysteresis updates whether the node is over its watermark, and -- if it is --
+// lowers the watermarks of the temporary node by the configured hysteresis, so that migrations
+// continue until the node is below the lowered watermarks.
+//
+// tmpNode must already have any watermark overrides applied.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) applyWatermarkHysteresis(ns *nodeState, tmpNode *state.Node) {
+	if reservedAboveWatermark(tmpNode) {
+		ns.overWatermark = true
+	}
+
+	hysteresis := s.config().WatermarkHysteresis
+	if hysteresis == 0 || !ns.overWatermark {
+		ns.overWatermark = reservedAboveWatermark(tmpNode)
+		return
+	}
+
+	tmpNode.CPU.Watermark = lowerWatermark(tmpNode.CPU, hysteresis)
+	tmpNode.Mem.Watermark = lowerWatermark(tmpNode.Mem, hysteresis)
+	ns.overWatermark = reservedAboveWatermark(tmpNode)
+}
+
+// reservedAboveWatermark returns whether the node's reserved CPU or memory is above its watermark,
+// including any resources that are being migrated away.
+func reservedAboveWatermark(node *state.Node) bool {
+	return node.CPU.Reserved > node.CPU.Watermark || node.Mem.Reserved > node.Mem.Watermark
+}
+
+// lowerWatermark returns the watermark for the resource, lowered by the fraction of its total.
+func lowerWatermark[T constraints.Unsigned](r state.NodeResources[T], fraction float64) T {
+	return util.SaturatingSub(r.Watermark, watermarkFromFraction(r.Total, fraction))
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/plugin/metrics"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+func TestWatermarkHysteresis(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.Watermark = 0.8
+	config.WatermarkHysteresis = 0.1
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+
+	overWatermark := func(ns *nodeState) bool {
+		ns.node.Speculatively(func(tmp *state.Node) (commit bool) {
+			s.applyWatermarkHysteresis(ns, tmp)
+			return false
+		})
+		return ns.overWatermark
+	}
+
+	// Each step gives the total CPU reserved on the node, and whether it should be considered over
+	// the watermark afterwards.
+	steps := []struct {
+		cpu      vmv1.MilliCPU
+		expected bool
+	}{
+		{7500, false},
+		{8500, true},  // above the watermark
+		{7500, true},  // below the watermark, but not below the hysteresis
+		{6900, false}, // below the hysteresis
+		{7500, false}, // not above the watermark again yet
+	}
+
+	ns := &nodeState{} //nolint:exhaustruct // only need the node and over-watermark state
+	for i, step := range steps {
+		node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+		node.AddPod(preemptionTestPod(fmt.Sprintf("vm-%d", i), step.cpu, true))
+		ns.node = node
+		assert.Equal(t, step.expected, overWatermark(ns), "step %d with %d reserved", i, step.cpu)
+	}
+
+	// Without hysteresis, the node is only over the watermark while it's above it
+	noHysteresis := *config
+	noHysteresis.WatermarkHysteresis = 0
+	s.currentConfig.Store(&noHysteresis)
+	ns.overWatermark = true
+	assert.False(t, overWatermark(ns))
+}
+
+func TestWatermarkHysteresisMigrations(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.Watermark = 0.8
+	config.WatermarkHysteresis = 0.2
+
+	pluginMetrics := metrics.BuildPluginMetrics(config.NodeMetricLabels, prometheus.NewRegistry())
+	s := newPluginState(*config, pluginMetrics, nil)
+	var requeued []types.UID
+	s.requeuePod = func(uid types.UID) error {
+		requeued = append(requeued, uid)
+		return nil
+	}
+
+	node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+	for i := range 9 {
+		node.AddPod(preemptionTestPod(fmt.Sprintf("vm-%d", i), 1000, true))
+	}
+	ns := &nodeState{ //nolint:exhaustruct // only need the node and requested migrations
+		node:                node,
+		requestedMigrations: make(map[types.UID]time.Time),
+	}
+
+	// 9000 reserved, with the watermark lowered to 6000: 3 VMs should be migrated, instead of the
+	// 1 that would bring it to the watermark.
+	assert.NoError(t, s.balanceNode(zap.NewNop(), ns))
+	assert.True(t, ns.overWatermark)
+	assert.Len(t, requeued, 3)
+}
@@ -0,0 +1,75 @@
+package plugin
+
+// Per-namespace watermarks, from the config's WatermarkOverrides.
+//
+// Whether to migrate VMs away is decided for each node as a whole, so a node with VMs from
+// namespaces with different watermarks uses the lowest of them: a latency-sensitive VM shouldn't
+// have to wait for the node to reach a batch workload's watermark.
+//
+// Each override applies to both CPU and memory. VMs in namespaces without an override use the
+// global CPUWatermark and MemoryWatermark, and the lowest is taken for each resource separately.
+
+import (
+	"github.com/samber/lo"
+	"golang.org/x/exp/constraints"
+
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+)
+
+// applyWatermarkOverrides sets the watermarks of the temporary node to the lowest watermarks of the
+// VMs on it, if any of them are in namespaces with a WatermarkOverride.
+//
+// NOTE: this function expects that the caller has acquired s.mu.
+func (s *PluginState) applyWatermarkOverrides(tmpNode *state.Node) {
+	config := s.config()
+	if len(config.WatermarkOverrides) == 0 || s.namespaceLabels == nil {
+		return
+	}
+
+	fractions, overridden := nodeWatermarks(*config, tmpNode, s.namespaceLabels)
+	if !overridden {
+		return
+	}
+
+	tmpNode.CPU.Watermark = watermarkFromFraction(tmpNode.CPU.Total, fractions.CPU)
+	tmpNode.Mem.Watermark = watermarkFromFraction(tmpNode.Mem.Total, fractions.Mem)
+}
+
+// nodeWatermarks returns the lowest watermarks of the VMs on the node, or false if none of them
+// are in namespaces matching a WatermarkOverride.
+//
+// VMs in namespaces without an override -- or that we don't know the labels of -- use the global
+// watermarks.
+func nodeWatermarks(
+	config Config,
+	node *state.Node,
+	namespaceLabels func(namespace string) (map[string]string, bool),
+) (_ state.WatermarkFractions, overridden bool) {
+	fractions := state.WatermarkFractions{CPU: 1.0, Mem: 1.0}
+	checked := make(map[string]struct{})
+
+	for _, pod := range node.Pods() {
+		if lo.IsEmpty(pod.VirtualMachine) {
+			continue
+		} else if _, ok := checked[pod.Namespace]; ok {
+			continue
+		}
+		checked[pod.Namespace] = struct{}{}
+
+		w := state.WatermarkFractions{CPU: config.cpuWatermark(), Mem: config.memWatermark()}
+		if labels, ok := namespaceLabels(pod.Namespace); ok {
+			if override, ok := config.watermarkForNamespace(labels); ok {
+				w = state.WatermarkFractions{CPU: override, Mem: override}
+				overridden = true
+			}
+		}
+		fractions.CPU = min(fractions.CPU, w.CPU)
+		fractions.Mem = min(fractions.Mem, w.Mem)
+	}
+
+	return fractions, overridden
+}
+
+func watermarkFromFraction[T constraints.Unsigned](total T, fraction float64) T {
+	return T(float64(total) * fraction)
+}
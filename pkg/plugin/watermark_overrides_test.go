@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+
+	vmv1 "github.com/neondatabase/autoscaling/neonvm/apis/neonvm/v1"
+	"github.com/neondatabase/autoscaling/pkg/api"
+	"github.com/neondatabase/autoscaling/pkg/plugin/state"
+	"github.com/neondatabase/autoscaling/pkg/util"
+)
+
+func TestNodeWatermarks(t *testing.T) {
+	config := defaultBenchmarkConfig()
+	config.Watermark = 0.9
+	config.MemoryWatermark = 0.8
+	config.WatermarkOverrides = []WatermarkOverride{
+		{NamespaceSelector: map[string]string{"tier": "production"}, Watermark: 0.7},
+		{NamespaceSelector: map[string]string{"tier": "batch"}, Watermark: 0.95},
+	}
+	_, err := config.validate()
+	assert.NoError(t, err)
+
+	namespaces := map[string]map[string]string{
+		"prod":    {"tier": "production"},
+		"batch":   {"tier": "batch"},
+		"default": {},
+	}
+	namespaceLabels := func(namespace string) (map[string]string, bool) {
+		labels, ok := namespaces[namespace]
+		return labels, ok
+	}
+
+	nextID := 0
+	vmPod := func(namespace string) state.Pod {
+		nextID += 1
+		name := util.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("vm-%d", nextID)}
+		return state.Pod{
+			NamespacedName:    name,
+			UID:               types.UID(fmt.Sprintf("pod-uid-%d", nextID)),
+			CreatedAt:         lo.Empty[time.Time](),
+			VirtualMachine:    name,
+			Migratable:        true,
+			AlwaysMigrate:     false,
+			DisruptionGroup:   "",
+			Migrating:         false,
+			NetworkBandwidth:  0,
+			ExtendedResources: state.ExtendedResources{},
+			WarmPool:          false,
+			Priority:          0,
+			MigrationCost:     0,
+			CPU: state.PodResources[vmv1.MilliCPU]{
+				Reserved:             0,
+				Requested:            0,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               0,
+				Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			},
+			Mem: state.PodResources[api.Bytes]{
+				Reserved:             0,
+				Requested:            0,
+				Preapproved:          0,
+				PreapprovalRequested: 0,
+				Factor:               0,
+				Overcommit:           lo.ToPtr(resource.MustParse("1000m")), // 1000m = 1.0 = "no overcommit"
+			},
+		}
+	}
+
+	cases := []struct {
+		name       string
+		namespaces []string
+		expected   state.WatermarkFractions
+		overridden bool
+	}{
+		{"no-vms", nil, state.WatermarkFractions{CPU: 1.0, Mem: 1.0}, false},
+		{"no-overrides", []string{"default", "default"}, state.WatermarkFractions{CPU: 0.9, Mem: 0.8}, false},
+		{"unknown-namespace", []string{"deleted"}, state.WatermarkFractions{CPU: 0.9, Mem: 0.8}, false},
+		{"only-batch", []string{"batch", "batch"}, state.WatermarkFractions{CPU: 0.95, Mem: 0.95}, true},
+		// Each resource takes the lowest separately
+		{"batch-and-default", []string{"batch", "default"}, state.WatermarkFractions{CPU: 0.9, Mem: 0.8}, true},
+		{"prod-and-batch", []string{"batch", "prod"}, state.WatermarkFractions{CPU: 0.7, Mem: 0.7}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node := state.NodeStateFromParams("node-1", 10000, 40*1024*1024*1024, config.Watermark, nil)
+			for _, ns := range c.namespaces {
+				node.AddPod(vmPod(ns))
+			}
+
+			fractions, overridden := nodeWatermarks(*config, node, namespaceLabels)
+			assert.Equal(t, c.overridden, overridden)
+			assert.Equal(t, c.expected, fractions)
+		})
+	}
+}
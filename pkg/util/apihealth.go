@@ -0,0 +1,158 @@
+package util
+
+// Detection of sustained API server degradation, so that callers can shed non-critical writes.
+
+import (
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// APIHealthConfig defines when the API server is considered degraded, and when it's considered
+// to have recovered.
+//
+// The "schema" struct tags are used for the scheduler plugin's config schema.
+type APIHealthConfig struct {
+	// WindowSeconds gives the duration, in seconds, of the sliding window of recent requests that
+	// we look at to determine whether the API server is degraded.
+	WindowSeconds uint `json:"windowSeconds" schema:"minimum=1,required"`
+	// MinRequests is the minimum number of requests in the window before the API server can be
+	// considered degraded, so that a single failure doesn't trigger degraded mode.
+	MinRequests uint `json:"minRequests"`
+	// BadRequestFraction is the fraction of requests in the window that failed or were slow, at
+	// or above which the API server is considered degraded.
+	BadRequestFraction float64 `json:"badRequestFraction" schema:"exclusiveMinimum=0,maximum=1,required"`
+	// SlowRequestMillis gives the duration, in milliseconds, at or above which a successful request
+	// is counted as slow.
+	SlowRequestMillis uint `json:"slowRequestMillis" schema:"minimum=1,required"`
+	// RecoverySeconds gives the duration, in seconds, that the API server must continuously be
+	// healthy before we leave degraded mode.
+	RecoverySeconds uint `json:"recoverySeconds" schema:"minimum=1,required"`
+}
+
+// APIHealth tracks the outcomes of recent requests to the API server, to determine whether it's
+// degraded.
+//
+// Entering degraded mode happens as soon as the recent requests are bad enough, but leaving it
+// requires the API server to be healthy for the configured recovery period, so that we don't flap
+// between modes.
+//
+// A nil *APIHealth is never degraded. APIHealth is safe for concurrent use.
+type APIHealth struct {
+	mu sync.Mutex
+
+	config   APIHealthConfig
+	onChange func(degraded bool)
+
+	requests    *RecentCounter
+	badRequests *RecentCounter
+
+	degraded bool
+	// healthySince is the time that the API server was first seen as healthy while degraded, or
+	// zero if it hasn't been healthy since the last time it was unhealthy.
+	healthySince time.Time
+}
+
+// NewAPIHealth returns a new APIHealth for the config, or nil if config is nil.
+//
+// onChange, if not nil, is called with the new mode every time we enter or leave degraded mode.
+// It is called while holding the APIHealth's lock, so must not call its methods.
+func NewAPIHealth(config *APIHealthConfig, onChange func(degraded bool)) *APIHealth {
+	if config == nil {
+		return nil
+	}
+
+	window := time.Second * time.Duration(config.WindowSeconds)
+	return &APIHealth{
+		mu:           sync.Mutex{},
+		config:       *config,
+		onChange:     onChange,
+		requests:     NewRecentCounter(window),
+		badRequests:  NewRecentCounter(window),
+		degraded:     false,
+		healthySince: time.Time{},
+	}
+}
+
+// Observe records the outcome of a request to the API server, taking latency to complete.
+//
+// Errors that are caused by the request itself (e.g., conflicts or failed preconditions) don't
+// count against the API server's health.
+func (h *APIHealth) Observe(latency time.Duration, err error) {
+	if h == nil {
+		return
+	}
+	h.observe(time.Now(), latency, err)
+}
+
+// Degraded returns whether the API server is currently considered degraded.
+func (h *APIHealth) Degraded() bool {
+	if h == nil {
+		return false
+	}
+	return h.degradedAt(time.Now())
+}
+
+// observe is separated from its exported version to provide more flexibility around testing.
+func (h *APIHealth) observe(now time.Time, latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.requests.inc(now)
+	if isAPIServerError(err) || latency >= time.Millisecond*time.Duration(h.config.SlowRequestMillis) {
+		h.badRequests.inc(now)
+	}
+	h.update(now)
+}
+
+// degradedAt is separated from its exported version to provide more flexibility around testing.
+func (h *APIHealth) degradedAt(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.update(now)
+	return h.degraded
+}
+
+func (h *APIHealth) update(now time.Time) {
+	total := h.requests.get(now)
+	bad := h.badRequests.get(now)
+	unhealthy := total != 0 && total >= h.config.MinRequests &&
+		float64(bad) >= h.config.BadRequestFraction*float64(total)
+
+	switch {
+	case unhealthy:
+		h.healthySince = time.Time{}
+		if !h.degraded {
+			h.setDegraded(true)
+		}
+	case h.degraded && h.healthySince.IsZero():
+		h.healthySince = now
+	case h.degraded && now.Sub(h.healthySince) >= time.Second*time.Duration(h.config.RecoverySeconds):
+		h.healthySince = time.Time{}
+		h.setDegraded(false)
+	}
+}
+
+func (h *APIHealth) setDegraded(degraded bool) {
+	h.degraded = degraded
+	if h.onChange != nil {
+		h.onChange(degraded)
+	}
+}
+
+// isAPIServerError returns whether the error from a request may indicate a problem with the API
+// server, rather than with the request.
+func isAPIServerError(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case apierrors.IsNotFound(err), apierrors.IsAlreadyExists(err), apierrors.IsConflict(err),
+		apierrors.IsInvalid(err), apierrors.IsBadRequest(err), apierrors.IsForbidden(err),
+		apierrors.IsUnauthorized(err):
+		return false
+	default:
+		return true
+	}
+}
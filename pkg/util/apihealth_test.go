@@ -0,0 +1,58 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIHealth(t *testing.T) {
+	ts := time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return ts.Add(time.Duration(seconds) * time.Second)
+	}
+
+	var changes []bool
+	h := NewAPIHealth(&APIHealthConfig{
+		WindowSeconds:      10,
+		MinRequests:        3,
+		BadRequestFraction: 0.5,
+		SlowRequestMillis:  1000,
+		RecoverySeconds:    20,
+	}, func(degraded bool) {
+		changes = append(changes, degraded)
+	})
+
+	fail := errors.New("request failed")
+
+	// Not enough requests to be degraded, even though they all failed
+	h.observe(at(0), time.Millisecond, fail)
+	h.observe(at(1), time.Millisecond, fail)
+	assert.False(t, h.degradedAt(at(1)))
+
+	// Slow requests count as bad too
+	h.observe(at(2), 2*time.Second, nil)
+	assert.True(t, h.degradedAt(at(2)))
+
+	// Once the bad requests are out of the window, we still wait for the recovery period
+	h.observe(at(15), time.Millisecond, nil)
+	assert.True(t, h.degradedAt(at(15)))
+	assert.True(t, h.degradedAt(at(30)))
+
+	// ... and any more unhealthiness resets the recovery period
+	h.observe(at(31), time.Millisecond, fail)
+	h.observe(at(32), time.Millisecond, fail)
+	h.observe(at(33), time.Millisecond, fail)
+	assert.True(t, h.degradedAt(at(33)))
+	assert.True(t, h.degradedAt(at(50)))
+	assert.False(t, h.degradedAt(at(70)))
+
+	assert.Equal(t, []bool{true, false}, changes)
+
+	// A nil APIHealth is never degraded
+	var nilHealth *APIHealth
+	nilHealth.Observe(time.Hour, fail)
+	assert.False(t, nilHealth.Degraded())
+}
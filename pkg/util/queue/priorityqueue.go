@@ -97,6 +97,15 @@ func (it ItemHandle[T]) Update(update func(value *T)) {
 	heap.Fix(it.queue, it.item.index)
 }
 
+// Remove removes the item from the queue
+func (it ItemHandle[T]) Remove() {
+	if it.item.index == -1 {
+		panic("item has since been removed from the queue")
+	}
+
+	heap.Remove(it.queue, it.item.index)
+}
+
 ///////////////////////////////////////////////////////////
 //      INTERNAL METHODS, FOR container/heap TO USE      //
 ///////////////////////////////////////////////////////////
@@ -72,5 +72,13 @@ func TestPriorityQueueOperations(t *testing.T) {
 	// => [ qux=2 ]
 	assert.Equal(t, "bar", getV(q.Pop()).name)
 
+	// => [ qux=2 , quux=5 ]
+	quuxHandle := q.Push(value{name: "quux", priority: 5})
+	assert.Equal(t, "quux", getV(q.Peek()).name)
+
+	// => [ qux=2 ]
+	quuxHandle.Remove()
+	assert.Equal(t, 1, q.Len())
+
 	assert.Equal(t, "qux", getV(q.Pop()).name)
 }
@@ -22,6 +22,7 @@ import (
 // - errors_total (number of errors, either error events or re-List errors, labeled by source: ["List", "Watch", "Watch.Event"])
 // - alive_current (1 iff the watcher is currently running or failing, else 0)
 // - failing_current (1 iff the watcher's last request failed *and* it's waiting to retry, else 0)
+// - stale_total (number of times the watch stream or store was detected as stale, if enabled)
 //
 // Prefixes are typically of the form "COMPONENT_watchers" (e.g. "autoscaling_agent_watchers").
 // Separate reporting per call to Watch is automatically done with the "watcher_instance" label
@@ -36,6 +37,7 @@ type Metrics struct {
 	errorsTotal         *prometheus.CounterVec
 	aliveCurrent        *prometheus.GaugeVec
 	failingCurrent      *prometheus.GaugeVec
+	staleTotal          *prometheus.CounterVec
 
 	// note: all usage of Metrics is by value, so this field gets copied in on each Watch call.
 	// It gives us a bit of state to use for the failing and unfailing functions.
@@ -103,6 +105,13 @@ func NewMetrics(prefix string, reg prometheus.Registerer) Metrics {
 			},
 			[]string{metricInstanceLabel},
 		)),
+		staleTotal: util.RegisterMetric(reg, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: fmt.Sprint(prefix, "_stale_total"),
+				Help: "Number of times the watch stream was detected as stale, either from no events or failing relists",
+			},
+			[]string{metricInstanceLabel},
+		)),
 	}
 }
 
@@ -158,6 +167,10 @@ func (m *MetricsConfig) doneWatch(err error) {
 	}
 }
 
+func (m *MetricsConfig) staleDetected() {
+	m.staleTotal.WithLabelValues(m.Instance).Inc()
+}
+
 func (m *MetricsConfig) recordEvent(ty watch.EventType) {
 	m.eventsTotal.WithLabelValues(m.Instance, string(ty)).Inc()
 
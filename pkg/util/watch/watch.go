@@ -50,6 +50,16 @@ type Config struct {
 	// RetryWatchAfter gives a retry interval when a non-initial watch fails. If left nil, then
 	// Watch will not retry.
 	RetryWatchAfter *util.TimeRange
+
+	// StaleAfter, if not nil, gives the maximum duration without any watch events (including
+	// bookmarks) before we assume that the watch stream has silently stalled and restart it with
+	// a fresh re-list. It's also the maximum duration that re-listing may fail for before the
+	// store is reported as stale.
+	//
+	// If left nil, stale watch streams are not detected.
+	StaleAfter *time.Duration
+	// OnStale, if not nil, is called each time the store is detected to be stale.
+	OnStale func()
 }
 
 // Accessors provides the "glue" functions for Watch to go from a list L (returned by the
@@ -281,12 +291,40 @@ func Watch[C Client[L], L metav1.ListMetaAccessor, T any, P Object[T]](
 
 		defer config.Metrics.unfailing()
 
+		// staleTimer fires when we haven't received any events for config.StaleAfter. If stale
+		// watch detection is disabled, staleTimer is nil and staleC never fires.
+		var staleTimer *time.Timer
+		var staleC <-chan time.Time
+		if config.StaleAfter != nil {
+			staleTimer = time.NewTimer(*config.StaleAfter)
+			defer staleTimer.Stop()
+			staleC = staleTimer.C
+		}
+		resetStaleTimer := func() {
+			if staleTimer != nil {
+				staleTimer.Reset(*config.StaleAfter)
+			}
+		}
+		reportStale := func(msg string) {
+			logger.Warn(msg, zap.Duration("staleAfter", *config.StaleAfter))
+			config.Metrics.staleDetected()
+			if config.OnStale != nil {
+				config.OnStale()
+			}
+		}
+
 		logger.Info("All setup complete, entering event loop")
 
 		for {
-			// this is used exclusively for relisting, but must be defined up here so that our gotos
-			// don't jump over variables.
+			// these are used exclusively for relisting, but must be defined up here so that our
+			// gotos don't jump over variables.
 			var signalRelistComplete []chan struct{}
+			var relistStartedAt time.Time
+			var reportedStaleRelist bool
+
+			// We've just started a new watcher, so reset the timer for detecting when it's stale.
+			resetStaleTimer()
+
 			for {
 				select {
 				case <-stopSignal.Recv():
@@ -298,12 +336,16 @@ func Watch[C Client[L], L metav1.ListMetaAccessor, T any, P Object[T]](
 				case <-store.triggerRelist:
 					config.Metrics.relistRequested()
 					goto relist
+				case <-staleC:
+					reportStale("Watch stream is stale because no events were received recently, relisting")
+					goto relist
 				case event, ok := <-watcher.ResultChan():
 					if !ok {
 						logger.Info("Watcher ended gracefully, restarting")
 						goto newWatcher
 					}
 
+					resetStaleTimer()
 					config.Metrics.recordEvent(event.Type)
 
 					if event.Type == watch.Error {
@@ -365,6 +407,8 @@ func Watch[C Client[L], L metav1.ListMetaAccessor, T any, P Object[T]](
 			// relist requests, having them handled naturally as we get around to watching again.
 			// This can amplify request failures - particularly if the K8s API server is overloaded.
 			signalRelistComplete = make([]chan struct{}, 0, 1)
+			relistStartedAt = time.Now()
+			reportedStaleRelist = false
 
 			// When we get to this point in the control flow, it's not guaranteed that the watcher
 			// has stopped.
@@ -420,6 +464,13 @@ func Watch[C Client[L], L metav1.ListMetaAccessor, T any, P Object[T]](
 					store.failing.Store(true)
 					config.Metrics.failing()
 
+					// If we've been failing to relist for a while, the contents of the store may be
+					// significantly out of date. Report that (once), but keep retrying.
+					if config.StaleAfter != nil && !reportedStaleRelist && time.Since(relistStartedAt) > *config.StaleAfter {
+						reportStale("Store is stale because relisting has been failing")
+						reportedStaleRelist = true
+					}
+
 					select {
 					case <-time.After(retryAfter):
 						logger.Info("Relist delay reached, retrying", zap.Duration("delay", retryAfter))
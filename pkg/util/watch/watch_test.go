@@ -0,0 +1,130 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestStaleWatchDetection(t *testing.T) {
+	cases := []struct {
+		name       string
+		staleAfter *time.Duration
+		// createEvery, if non-zero, creates a Node at this interval, so that the watch stream
+		// receives events.
+		createEvery time.Duration
+		runFor      time.Duration
+
+		expectStale bool
+	}{
+		{
+			name:        "disabled",
+			staleAfter:  nil,
+			createEvery: 0,
+			runFor:      500 * time.Millisecond,
+			expectStale: false,
+		},
+		{
+			name:        "no events",
+			staleAfter:  lo.ToPtr(100 * time.Millisecond),
+			createEvery: 0,
+			runFor:      500 * time.Millisecond,
+			expectStale: true,
+		},
+		{
+			name:        "regular events",
+			staleAfter:  lo.ToPtr(time.Second),
+			createEvery: 50 * time.Millisecond,
+			runFor:      1500 * time.Millisecond,
+			expectStale: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client := fake.NewSimpleClientset()
+			var lists atomic.Int32
+			client.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+				lists.Add(1)
+				return false, nil, nil // fall through to the default handling
+			})
+
+			var staleCalls atomic.Int32
+			metrics := NewMetrics("test_watchers", prometheus.NewRegistry())
+			config := Config{
+				ObjectNameLogField: "node",
+				Metrics:            MetricsConfig{Metrics: metrics, Instance: "Nodes"},
+				RetryRelistAfter:   nil,
+				RetryWatchAfter:    nil,
+				StaleAfter:         c.staleAfter,
+				OnStale:            func() { staleCalls.Add(1) },
+			}
+
+			store, err := Watch(
+				ctx,
+				zap.NewNop(),
+				client.CoreV1().Nodes(),
+				config,
+				Accessors[*corev1.NodeList, corev1.Node]{
+					Items: func(list *corev1.NodeList) []corev1.Node { return list.Items },
+				},
+				InitModeSync,
+				metav1.ListOptions{}, //nolint:exhaustruct // no options needed
+				HandlerFuncs[*corev1.Node]{AddFunc: nil, UpdateFunc: nil, DeleteFunc: nil},
+			)
+			require.NoError(t, err)
+			defer store.Stop()
+
+			deadline := time.After(c.runFor)
+			var ticker <-chan time.Time
+			if c.createEvery != 0 {
+				tick := time.NewTicker(c.createEvery)
+				defer tick.Stop()
+				ticker = tick.C
+			}
+		loop:
+			for count := 0; ; count++ {
+				select {
+				case <-deadline:
+					break loop
+				case <-ticker:
+					name := fmt.Sprintf("node-%d", count)
+					// the fake clientset doesn't set UIDs, which the store needs to tell objects apart.
+					node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(name)}}
+					_, err := client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+					require.NoError(t, err)
+				}
+			}
+
+			stale := testutil.ToFloat64(metrics.staleTotal.WithLabelValues("Nodes"))
+			if c.expectStale {
+				assert.Positive(t, staleCalls.Load())
+				assert.Positive(t, stale)
+				assert.Greater(t, lists.Load(), int32(1), "stale watch should be restarted with a re-list")
+			} else {
+				assert.Zero(t, staleCalls.Load())
+				assert.Zero(t, stale)
+				assert.Equal(t, int32(1), lists.Load())
+			}
+		})
+	}
+}